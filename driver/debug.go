@@ -0,0 +1,7 @@
+//go:build debug
+
+package driver
+
+// debug enables internal consistency checks, e.g. verifying the AST invariants
+// after type checking. Build with `-tags debug` to enable.
+const debug = true
@@ -0,0 +1,114 @@
+package driver
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/ast/encode"
+	"github.com/corani/cubit/internal/ir/passes"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// dumpAST is the value of -dump-ast: the format to dump the AST in, or empty
+// to not dump it. Without a value, the flag dumps it as an s-expression.
+type dumpAST string
+
+func (d *dumpAST) String() string {
+	return string(*d)
+}
+
+func (d *dumpAST) Set(value string) error {
+	switch value {
+	case "true", "sexpr":
+		*d = "sexpr"
+	case "json":
+		*d = "json"
+	case "false":
+		*d = ""
+	default:
+		return fmt.Errorf("unknown format %q, want sexpr or json", value)
+	}
+
+	return nil
+}
+
+// IsBoolFlag lets the flag be given without a value.
+func (d *dumpAST) IsBoolFlag() bool {
+	return true
+}
+
+// write writes unit to w in the format of d.
+func (d dumpAST) write(w io.Writer, unit *ast.CompilationUnit) error {
+	if d == "json" {
+		data, err := encode.MarshalIndent(unit, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "%s\n", data)
+
+		return err
+	}
+
+	return ast.Dump(w, unit)
+}
+
+// The stages of -dump-ir besides the passes: the IR as it's lowered, and as
+// it's handed to the backend.
+const (
+	stageLower = "lower"
+	stageOpt   = "opt"
+)
+
+// parseDumpIR returns the stages in s, a comma-separated list of lower, the
+// names of the passes, opt or all.
+func parseDumpIR(s string, all []passes.Pass) (map[string]bool, error) {
+	stages := make(map[string]bool)
+
+	if s == "" {
+		return stages, nil
+	}
+
+	names := []string{stageLower}
+	for _, pass := range all {
+		names = append(names, pass.Name())
+	}
+
+	names = append(names, stageOpt)
+
+	for _, stage := range strings.Split(s, ",") {
+		switch {
+		case stage == "all":
+			for _, name := range names {
+				stages[name] = true
+			}
+		case slices.Contains(names, stage):
+			stages[stage] = true
+		default:
+			return nil, fmt.Errorf("unknown stage %q, want one of %s or all", stage, strings.Join(names, ", "))
+		}
+	}
+
+	return stages, nil
+}
+
+// writeTokens writes tokens, one per line, with their locations.
+func writeTokens(w io.Writer, tokens []lexer.Token) error {
+	for _, tok := range tokens {
+		if _, err := fmt.Fprintf(w, "%s: %s\n", tok.Location, tok); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeIR writes the SSA code of the IR after stage, followed by a blank line.
+func writeIR(w io.Writer, stage, ssa string) error {
+	_, err := fmt.Fprintf(w, "# IR after %s\n%s\n\n", stage, strings.Trim(ssa, "\n"))
+
+	return err
+}
@@ -0,0 +1,76 @@
+package driver_test
+
+import (
+	"fmt"
+
+	"github.com/corani/cubit/driver"
+)
+
+// noRecursion reports the functions that call themselves.
+func noRecursion(pkg *driver.Package) []error {
+	var errs []error
+
+	for _, fn := range pkg.Funcs {
+		driver.Walk(fn, func(n driver.Node) bool {
+			if call, ok := n.(*driver.CallExpr); ok && call.FuncDef == fn {
+				errs = append(errs, driver.Errorf(call.Location(), "%s calls itself", fn.Ident))
+			}
+
+			return true
+		})
+	}
+
+	return errs
+}
+
+// double replaces multiplications by 2 with additions.
+type double struct{}
+
+func (double) Name() string { return "double" }
+
+func (double) Run(fn *driver.Func) bool {
+	changed := false
+
+	for _, block := range fn.Blocks {
+		for _, instr := range block.Instructions {
+			if b, ok := instr.(*driver.Binop); ok && b.Op == driver.BinOpMul {
+				if n, ok := b.Rhs.IntConst(); ok && n == 2 {
+					b.Op, b.Rhs = driver.BinOpAdd, b.Lhs
+					changed = true
+				}
+			}
+		}
+	}
+
+	return changed
+}
+
+func init() {
+	driver.RegisterCheck("norecursion", noRecursion)
+	driver.RegisterPass(double{})
+}
+
+func Example() {
+	var loc driver.Location
+
+	word := driver.NewAbiTyBase(driver.BaseWord)
+	x, r := driver.NewValIdent(loc, "x", word), driver.NewValIdent(loc, "r", word)
+
+	fn := driver.NewFunc(loc, "twice", driver.NewFuncParam(loc, word, "x")).WithRetTy(word).WithBlocks(
+		driver.NewBlock(loc, "start", []driver.Instruction{
+			driver.NewBinop(loc, driver.BinOpMul, r, x, driver.NewValInteger(loc, 2, word)),
+			driver.NewRet(loc, r),
+		}))
+
+	fmt.Println(double{}.Run(&fn))
+
+	for _, instr := range fn.Blocks[0].Instructions {
+		if b, ok := instr.(*driver.Binop); ok {
+			fmt.Println(b.Ret.Ident, "=", b.Op, b.Lhs.Ident, b.Rhs.Ident)
+		}
+	}
+
+	// Output:
+	// true
+	// r = add x x
+}
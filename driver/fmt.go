@@ -0,0 +1,106 @@
+package driver
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/format"
+	"github.com/corani/cubit/internal/loader"
+)
+
+// runFmt is the fmt command: it rewrites the source files in args, or the
+// ones in the directories in args, in the canonical style. With -l or -d it
+// only lists the files that aren't, or shows how they'd change, and exits
+// with exitErrors if there are any, so it can check a tree in CI.
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+
+	var list, diff, help bool
+
+	flags.BoolVar(&list, "l", false, "list the files that aren't formatted, instead of rewriting them")
+	flags.BoolVar(&diff, "d", false, "show how the files would change, instead of rewriting them")
+	flags.BoolVar(&help, "help", false, "show help message")
+
+	flags.Usage = func() {
+		out := flags.Output()
+
+		fmt.Fprintln(out, "Usage: cubit fmt [options] [source files or directories]")
+		fmt.Fprintln(out, "Options:")
+		flags.PrintDefaults()
+	}
+
+	// The flag set exits with exitUsage on errors.
+	_ = flags.Parse(args)
+
+	if help {
+		flags.SetOutput(os.Stdout)
+		flags.Usage()
+
+		return 0
+	}
+
+	if flags.NArg() == 0 {
+		usagef("Invalid arguments: fmt needs a source file or directory")
+	}
+
+	files, err := loader.SourceFiles(flags.Args()...)
+	if err != nil {
+		usagef("Invalid arguments: %v", err)
+	}
+
+	status := 0
+
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = exitErrors
+
+			continue
+		}
+
+		diags := diag.NewBag(diag.Config{})
+
+		out, err := format.Source(file, src, diags)
+		if err != nil {
+			// Syntax errors and lost comments are diagnostics, a broken
+			// printer isn't.
+			if diags.HasErrors() {
+				_ = diags.WriteText(os.Stderr)
+			} else {
+				fmt.Fprintln(os.Stderr, err)
+			}
+
+			status = exitErrors
+
+			continue
+		}
+
+		if bytes.Equal(src, out) {
+			continue
+		}
+
+		if list {
+			fmt.Println(file)
+		}
+
+		if diff {
+			os.Stdout.Write(format.Diff(file+".orig", file, src, out))
+		}
+
+		if list || diff {
+			status = exitErrors
+			continue
+		}
+
+		if err := os.WriteFile(file, out, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = exitErrors
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,112 @@
+package driver
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/corani/cubit/internal/depgraph"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/loader"
+	"github.com/corani/cubit/internal/typecheck"
+)
+
+// runGraph is the graph command: it writes the dependency graph of the
+// program in args, of the packages and the functions, as DOT or JSON. Like a
+// build, it builds the sources of the manifest without args.
+func runGraph(args []string) int {
+	flags := flag.NewFlagSet("graph", flag.ExitOnError)
+
+	var packagesOnly, help bool
+	var format, output string
+
+	flags.StringVar(&format, "format", "dot", "format of the graph: dot (for Graphviz) or json")
+	flags.BoolVar(&packagesOnly, "packages", false, "only write the packages and their imports, without the functions")
+	flags.StringVar(&output, "o", "", "file to write the graph to (default: stdout)")
+	flags.BoolVar(&help, "help", false, "show help message")
+
+	flags.Usage = func() {
+		out := flags.Output()
+
+		fmt.Fprintln(out, "Usage: cubit graph [options] [source files or directory]")
+		fmt.Fprintln(out, "Options:")
+		flags.PrintDefaults()
+	}
+
+	// The flag set exits with exitUsage on errors.
+	_ = flags.Parse(args)
+
+	if help {
+		flags.SetOutput(os.Stdout)
+		flags.Usage()
+
+		return 0
+	}
+
+	if format != "dot" && format != "json" {
+		usagef("Invalid -format: %s", format)
+	}
+
+	_, sources, err := findManifest("", flags.Args())
+	if err != nil {
+		usagef("Invalid manifest: %v", err)
+	}
+
+	if len(sources) == 0 {
+		usagef("Invalid arguments: graph needs a source file or directory")
+	}
+
+	diags := diag.NewBag(diag.Config{})
+	ld := loader.NewLoader(diags)
+
+	unit, err := ld.LoadPackage(sources...)
+	if err == nil {
+		err = typecheck.NewChecker(typecheck.Options{Diagnostics: diags}).Check(unit)
+	}
+
+	if err != nil {
+		if diags.HasErrors() {
+			if err := diags.WriteText(os.Stderr); err != nil {
+				fatalf("failed to write diagnostics: %v", err)
+			}
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+
+		return exitErrors
+	}
+
+	graph := depgraph.Build(ld.Files())
+	if packagesOnly {
+		graph = graph.PackagesOnly()
+	}
+
+	out := os.Stdout
+
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+
+			return exitErrors
+		}
+
+		defer f.Close()
+
+		out = f
+	}
+
+	if format == "json" {
+		err = graph.WriteJSON(out)
+	} else {
+		err = graph.WriteDOT(out)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+
+		return exitErrors
+	}
+
+	return 0
+}
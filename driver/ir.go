@@ -0,0 +1,105 @@
+package driver
+
+import (
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// The IR a pass sees, for programs outside the module. A function is a list of
+// blocks, each a list of instructions ending with a jump, return or halt.
+type (
+	// Location is a position in the source, of a node or an instruction.
+	Location = lexer.Location
+
+	Block = ir.Block
+	// Instruction is one of *Ret, *Hlt, *Call, *Binop, *Jmp, *Jnz, *Load,
+	// *Store, *Convert, *Alloc or *Phi.
+	Instruction = ir.Instruction
+	// Visitor visits the instructions, see Instruction.Accept.
+	Visitor = ir.Visitor
+
+	Ret     = ir.Ret
+	Hlt     = ir.Hlt
+	Call    = ir.Call
+	Binop   = ir.Binop
+	Jmp     = ir.Jmp
+	Jnz     = ir.Jnz
+	Load    = ir.Load
+	Store   = ir.Store
+	Convert = ir.Convert
+	Alloc   = ir.Alloc
+	Phi     = ir.Phi
+	Arg     = ir.Arg
+	PhiArg  = ir.PhiArg
+
+	// Val is an operand: a constant, a global or a temporary.
+	Val       = ir.Val
+	Ident     = ir.Ident
+	AbiTy     = ir.AbiTy
+	BaseTy    = ir.BaseTy
+	BinOpKind = ir.BinOpKind
+)
+
+// The base types of values.
+const (
+	BaseWord   = ir.BaseWord
+	BaseLong   = ir.BaseLong
+	BaseSingle = ir.BaseSingle
+	BaseDouble = ir.BaseDouble
+)
+
+// The binary operations. Division, remainder and ordered comparisons treat
+// their operands as signed; their U variants treat them as unsigned.
+const (
+	BinOpAdd  = ir.BinOpAdd
+	BinOpSub  = ir.BinOpSub
+	BinOpMul  = ir.BinOpMul
+	BinOpDiv  = ir.BinOpDiv
+	BinOpUDiv = ir.BinOpUDiv
+	BinOpMod  = ir.BinOpMod
+	BinOpUMod = ir.BinOpUMod
+	BinOpEq   = ir.BinOpEq
+	BinOpNe   = ir.BinOpNe
+	BinOpLt   = ir.BinOpLt
+	BinOpULt  = ir.BinOpULt
+	BinOpLe   = ir.BinOpLe
+	BinOpULe  = ir.BinOpULe
+	BinOpGt   = ir.BinOpGt
+	BinOpUGt  = ir.BinOpUGt
+	BinOpGe   = ir.BinOpGe
+	BinOpUGe  = ir.BinOpUGe
+	BinOpShl  = ir.BinOpShl
+	BinOpShr  = ir.BinOpShr
+	BinOpAnd  = ir.BinOpAnd
+	BinOpOr   = ir.BinOpOr
+)
+
+// The constructors of the IR, for passes that add or replace instructions.
+var (
+	NewFunc       = ir.NewFuncDef
+	NewFuncParam  = ir.NewParamRegular
+	NewBlock      = ir.NewBlock
+	NewAbiTyBase  = ir.NewAbiTyBase
+	NewValIdent   = ir.NewValIdent
+	NewValGlobal  = ir.NewValGlobal
+	NewValInteger = ir.NewValInteger
+	NewRet        = ir.NewRet
+	NewHlt        = ir.NewHlt
+	NewCall       = ir.NewCall
+	NewArg        = ir.NewArgRegular
+	NewBinop      = ir.NewBinop
+	NewJmp        = ir.NewJmp
+	NewJnz        = ir.NewJnz
+	NewLoad       = ir.NewLoad
+	NewStore      = ir.NewStore
+	NewConvert    = ir.NewConvert
+	NewAlloc      = ir.NewAlloc
+	NewPhi        = ir.NewPhi
+	NewPhiArg     = ir.NewPhiArg
+
+	// Operands returns pointers to the operands of an instruction, to
+	// inspect or replace them.
+	Operands = ir.Operands
+	// IsTerminator reports whether an instruction ends its block.
+	IsTerminator = ir.IsTerminator
+)
@@ -0,0 +1,49 @@
+package driver
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/corani/cubit/internal/lsp"
+)
+
+// runLSP is the lsp command: it runs a language server on stdin and stdout,
+// for an editor to start, until the editor tells it to exit.
+func runLSP(args []string) int {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+
+	var help bool
+
+	flags.BoolVar(&help, "help", false, "show help message")
+
+	flags.Usage = func() {
+		out := flags.Output()
+
+		fmt.Fprintln(out, "Usage: cubit lsp [options]")
+		fmt.Fprintln(out, "Options:")
+		flags.PrintDefaults()
+	}
+
+	// The flag set exits with exitUsage on errors.
+	_ = flags.Parse(args)
+
+	if help {
+		flags.SetOutput(os.Stdout)
+		flags.Usage()
+
+		return 0
+	}
+
+	if flags.NArg() > 0 {
+		usagef("Invalid arguments: lsp speaks the protocol over stdin and stdout")
+	}
+
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+
+		return exitErrors
+	}
+
+	return 0
+}
@@ -0,0 +1,818 @@
+package driver
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/codegen"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/ir/bytecode"
+	"github.com/corani/cubit/internal/ir/interp"
+	"github.com/corani/cubit/internal/ir/passes"
+	"github.com/corani/cubit/internal/ir/profile"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/corani/cubit/internal/loader"
+	"github.com/corani/cubit/internal/manifest"
+	"github.com/corani/cubit/internal/target"
+	"github.com/corani/cubit/internal/timing"
+	"github.com/corani/cubit/internal/typecheck"
+)
+
+func withExt(filename, ext string) string {
+	// replace the existing extension with the new one
+	current := filepath.Ext(filename)
+
+	if current != "" {
+		return filename[:len(filename)-len(current)] + ext
+	}
+
+	return filename + ext
+}
+
+// exitWith reports the exit status of a program that ran in the compiler, and
+// exits with it.
+func exitWith(status int, err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Program failed: %v\n", err)
+		os.Exit(exitErrors)
+	}
+
+	if status != 0 {
+		fmt.Fprintf(os.Stderr, "Program exited with code %d\n", status)
+		os.Exit(status)
+	}
+}
+
+// runBytecode runs the bytecode in filename in the VM.
+func runBytecode(filename string) (int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	prog, err := bytecode.Decode(f)
+	if err != nil {
+		return 0, err
+	}
+
+	vm, err := bytecode.New(prog)
+	if err != nil {
+		return 0, err
+	}
+
+	return vm.Run()
+}
+
+// The exit codes of the compiler, for build systems. A program that runs
+// with -run or -jit exits with its own code instead.
+const (
+	exitErrors   = 1 // the source has errors, or the program failed to run
+	exitUsage    = 2 // invalid flags or arguments, like the flag package
+	exitInternal = 3 // the compiler failed, e.g. to write a file or to run the assembler
+)
+
+// usagef reports invalid flags or arguments and exits.
+func usagef(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(exitUsage)
+}
+
+// cleanup removes the temporary directory of cubit run, also when the compiler
+// exits, which skips the deferred calls.
+var cleanup = func() {}
+
+// fatalf reports a failure of the compiler itself, rather than of the source,
+// and exits.
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "cubit: "+format+"\n", args...)
+	cleanup()
+	os.Exit(exitInternal)
+}
+
+// command is a subcommand of the compiler.
+type command struct {
+	name, usage string
+}
+
+// commands are the subcommands of the compiler. Without one, it builds.
+var commands = []command{
+	{"build", "build the program"},
+	{"run", "build the program in a temporary directory and run it, with the arguments after --, or with -jit in the interpreter"},
+	{"check", "only check the program for errors, without writing any files (except with -ast)"},
+	{"fmt", "rewrite the source files in the canonical style, see cubit fmt -help"},
+	{"repl", "evaluate declarations, statements and expressions as they're entered"},
+	{"lsp", "run a language server on stdin and stdout, for editors"},
+	{"test", "run the @(test) functions of packages, see cubit test -help"},
+	{"graph", "write the package and call graph as DOT or JSON, see cubit graph -help"},
+}
+
+// Main runs the compiler with the arguments of the process, like the cubit
+// command, with the passes and attributes that are registered, and exits.
+func Main() {
+	var writeAST, writeSSA, sourceNames, debugInfo, stats, run, jit, strict, object, shared, annotate, freestanding, help bool
+	var disable, enable, diagFormat, backend, targetTriple, output, emit, hardening, profileGenerate, profileUse,
+		linkerScript, outDir string
+	var inline, jobs int
+	var link, manifestFile, profileName, color, optLevel, optList string
+	var dumpTokens, timeReport bool
+	var timeTrace string
+	var dumpAst dumpAST
+	var dumpIR string
+
+	flag.BoolVar(&writeAST, "ast", false, "write AST to file")
+	flag.BoolVar(&writeSSA, "ssa", false, "write SSA code to file")
+	flag.BoolVar(&sourceNames, "names", false, "name temporaries after the variables they're assigned to")
+	flag.BoolVar(&debugInfo, "g", false, "emit source line information for debuggers")
+	flag.BoolVar(&stats, "stats", false, "print the size of every function before and after optimization")
+	flag.BoolVar(&timeReport, "time-report", false, "print how long every phase took, and how much memory it allocated, on stderr")
+	flag.StringVar(&timeTrace, "time-trace", "", "write the phases to this file as a trace for chrome://tracing")
+	flag.BoolVar(&run, "run", false, "run the compiled code")
+	flag.BoolVar(&jit, "jit", false, "run the program in the IR interpreter instead of building it")
+	flag.BoolVar(&strict, "strict", false, "report warnings as errors")
+	flag.StringVar(&disable, "disable", "", "comma-separated warnings to disable, by code, name or group")
+	flag.StringVar(&enable, "enable", "", "comma-separated warnings to enable, overriding -disable")
+	flag.StringVar(&diagFormat, "diagnostics", "text", "format of errors and warnings: text, json (one object per line) or sarif")
+	flag.StringVar(&diagFormat, "diagnostics-format", "text", "the same as -diagnostics")
+	flag.StringVar(&diagFormat, "format", "text", "the same as -diagnostics, e.g. for cubit check -format=json")
+	flag.StringVar(&color, "color", "auto", "color the errors and warnings, and show their source: auto (at a terminal, unless $NO_COLOR is set), always or never")
+	flag.StringVar(&backend, "backend", "", "backend that generates the assembly: qbe, native, c or bytecode (default: qbe, or c for wasm32)")
+	flag.BoolVar(&object, "obj", false, "write an ELF object instead of assembly (native backend only)")
+	flag.StringVar(&targetTriple, "target", "", "target to generate code for, a triple like aarch64-linux-gnu, or linux/arm64 or wasm32 (default: the host)")
+	flag.BoolVar(&shared, "shared", false, "build a shared library, lib<name>.so, and a C header for its @(export) functions")
+	flag.Bool("fPIC", false, "generate position-independent code (the code always is)")
+	flag.StringVar(&emit, "emit", "exe", "what to build: exe, asm to stop after the assembly (or C source, bytecode or object), or ir to stop after the SSA code")
+	flag.BoolVar(&annotate, "annotate", false, "interleave the source lines as comments in the assembly or C source, like objdump -S")
+	flag.StringVar(&hardening, "hardening", "", "comma-separated run-time checks to add: canary (stack canaries), zero (zeroed stack slots), overflow (trap on int overflow) or all")
+	flag.StringVar(&profileGenerate, "profile-generate", "", "build a program that counts how often its blocks run, and writes the counts to this file when it exits")
+	flag.StringVar(&profileUse, "profile-use", "", "optimize with the counts in this file, written by a program built with -profile-generate")
+	flag.BoolVar(&freestanding, "freestanding", false, "build a program that runs without an operating system, with a _start entry and without the C library")
+	flag.StringVar(&linkerScript, "linker-script", "", "linker script to link a -freestanding program with")
+	flag.StringVar(&output, "o", "", "file to write the executable to (default: <out-dir>/<source file without extension>)")
+	flag.StringVar(&outDir, "out-dir", "", "directory to write the intermediate files to (default: out, next to the source file)")
+	flag.IntVar(&inline, "inline", passes.DefaultInlineThreshold,
+		"size in instructions up to which functions are inlined; 0 only inlines @(inline), negative disables")
+	flag.StringVar(&optLevel, "O", string(passes.LevelFull),
+		"optimization level: 0 doesn't optimize, 1 optimizes without inlining, 2 runs all passes, s runs all passes but only inlines @(inline) functions; with 2 and s, C source is built without frame pointers")
+
+	for _, level := range passes.Levels {
+		flag.BoolFunc("O"+string(level), "the same as -O "+string(level), func(string) error {
+			return flag.Set("O", string(level))
+		})
+	}
+
+	flag.StringVar(&optList, "opt", "", "comma-separated passes to run, in order, instead of the ones of -O, e.g. constfold,dce")
+	flag.StringVar(&link, "link", "", "comma-separated libraries to link the program with, like @(link) attributes")
+	flag.StringVar(&manifestFile, "manifest", "", "project manifest to build with (default: "+manifest.Filename+" in the directory that's built, or the working directory)")
+	flag.StringVar(&profileName, "profile", manifest.DefaultProfile, "profile of the manifest to build with, like debug or release")
+	flag.IntVar(&jobs, "j", 0, "number of functions to lower, optimize and generate code for at once (default: GOMAXPROCS)")
+	flag.BoolVar(&dumpTokens, "dump-tokens", false, "print the tokens of every file as it's lexed, the source files and their imports")
+	flag.Var(&dumpAst, "dump-ast", "print the AST after type checking, as an s-expression, or as JSON with -dump-ast=json")
+	flag.StringVar(&dumpIR, "dump-ir", "", "comma-separated stages to print the IR after: lower, a pass like constfold, opt for the IR the backend gets, or all")
+	flag.BoolVar(&help, "help", false, "show help message")
+
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+
+		fmt.Fprintln(out, "Usage: cubit [command] [options] [source files or directory]")
+		fmt.Fprintln(out, "Commands:")
+
+		for _, c := range commands {
+			fmt.Fprintf(out, "  %-6s %s\n", c.name, c.usage)
+		}
+
+		fmt.Fprintln(out, "Options:")
+		flag.PrintDefaults()
+	}
+
+	subcmd, args := "", os.Args[1:]
+	if len(args) > 0 && slices.ContainsFunc(commands, func(c command) bool { return c.name == args[0] }) {
+		subcmd, args = args[0], args[1:]
+	}
+
+	// fmt, repl, lsp, test and graph don't build one program, so they have flags of
+	// their own.
+	switch subcmd {
+	case "fmt":
+		os.Exit(runFmt(args))
+	case "repl":
+		os.Exit(runRepl(args))
+	case "lsp":
+		os.Exit(runLSP(args))
+	case "test":
+		os.Exit(runTest(args))
+	case "graph":
+		os.Exit(runGraph(args))
+	}
+
+	// The arguments after -- are the program's, when it runs.
+	var progArgs []string
+	if i := slices.Index(args, "--"); i >= 0 {
+		args, progArgs = args[:i], args[i+1:]
+	}
+
+	// The flag package exits with exitUsage on errors.
+	_ = flag.CommandLine.Parse(args)
+
+	if help {
+		flag.CommandLine.SetOutput(os.Stdout)
+		flag.Usage()
+
+		return
+	}
+
+	// The phases are only timed for a report, a nil recorder skips them.
+	var rec *timing.Recorder
+	if timeReport || timeTrace != "" {
+		rec = timing.New()
+	}
+
+	// report writes the report of the phases, once the compiler is done, and
+	// before the program runs.
+	report := sync.OnceFunc(func() {
+		if err := writeTimeReport(rec, timeReport, timeTrace); err != nil {
+			fatalf("failed to write time report: %v", err)
+		}
+	})
+	defer report()
+
+	// A project with a manifest is built with its settings, except the ones
+	// that are given as flags.
+	project, srcFiles, err := findManifest(manifestFile, flag.Args())
+	if err != nil {
+		usagef("Invalid manifest: %v", err)
+	}
+
+	if project != nil {
+		if err := applyManifest(project, profileName); err != nil {
+			usagef("Invalid manifest: %v", err)
+		}
+	} else if flagSet("profile") {
+		usagef("Invalid -profile: there's no %s", manifest.Filename)
+	}
+
+	if subcmd == "run" && !jit {
+		run = true
+	}
+
+	switch {
+	case len(progArgs) == 0:
+	case jit || codegen.Backend(backend) == codegen.BackendBytecode:
+		usagef("Invalid arguments: the interpreter and the VM don't pass arguments to the program")
+	case !run:
+		usagef("Invalid arguments: the arguments after -- are for a program that runs, with run or -run")
+	}
+
+	disabled, err := diag.ParseList(disable)
+	if err != nil {
+		usagef("Invalid -disable: %v", err)
+	}
+
+	enabled, err := diag.ParseList(enable)
+	if err != nil {
+		usagef("Invalid -enable: %v", err)
+	}
+
+	if diagFormat != "text" && diagFormat != "json" && diagFormat != "sarif" {
+		usagef("Invalid -diagnostics, -diagnostics-format or -format: %s", diagFormat)
+	}
+
+	if color != "auto" && color != "always" && color != "never" {
+		usagef("Invalid -color: %s", color)
+	}
+
+	target, err := target.Parse(targetTriple)
+	if err != nil {
+		usagef("Invalid -target: %v", err)
+	}
+
+	if backend == "" {
+		backend = string(codegen.DefaultBackend(target))
+	}
+
+	if b := codegen.Backend(backend); b != codegen.BackendQBE && b != codegen.BackendNative && b != codegen.BackendC &&
+		b != codegen.BackendBytecode {
+		usagef("Invalid -backend: %s", backend)
+	}
+
+	if err := codegen.Backend(backend).Check(target); err != nil {
+		usagef("Invalid -backend: %v", err)
+	}
+
+	if shared && (run || jit || codegen.Backend(backend) == codegen.BackendBytecode) {
+		usagef("Invalid -shared: a shared library can't be run, and is built from assembly or C")
+	}
+
+	if emit != "exe" && emit != "asm" && emit != "ir" {
+		usagef("Invalid -emit: %s", emit)
+	}
+
+	if emit != "exe" && (run || jit) {
+		usagef("Invalid -emit: with %s, there's no program to run", emit)
+	}
+
+	if annotate && (object || codegen.Backend(backend) == codegen.BackendBytecode) {
+		usagef("Invalid -annotate: only assembly and C source can be annotated")
+	}
+
+	hardened, err := passes.ParseHardening(hardening)
+	if err != nil {
+		usagef("Invalid -hardening: %v", err)
+	}
+
+	if hardened.Canaries && (jit || codegen.Backend(backend) == codegen.BackendBytecode) {
+		usagef("Invalid -hardening: stack canaries need getauxval, which the interpreter and the VM don't have")
+	}
+
+	if hardened.Canaries && target.OS != "linux" {
+		usagef("Invalid -hardening: stack canaries need getauxval, which %s doesn't have", target.OS)
+	}
+
+	if profileGenerate != "" && profileUse != "" {
+		usagef("Invalid -profile-generate: a program can't be instrumented and optimized with a profile at once")
+	}
+
+	if profileGenerate != "" && (shared || jit || codegen.Backend(backend) == codegen.BackendBytecode) {
+		usagef("Invalid -profile-generate: the counts are written with atexit and fopen, by a program with a main function")
+	}
+
+	if freestanding && (run || jit || shared || codegen.Backend(backend) == codegen.BackendBytecode) {
+		usagef("Invalid -freestanding: a freestanding program runs on its own, it can't be run here or loaded as a library")
+	}
+
+	if freestanding && hardened.Canaries {
+		usagef("Invalid -hardening: stack canaries need getauxval, which a freestanding program doesn't have")
+	}
+
+	if freestanding && profileGenerate != "" {
+		usagef("Invalid -profile-generate: the counts are written with atexit and fopen, which a freestanding program doesn't have")
+	}
+
+	if linkerScript != "" && !freestanding {
+		usagef("Invalid -linker-script: only a -freestanding program is linked with a script of its own")
+	}
+
+	var prof *profile.Profile
+
+	if profileUse != "" {
+		f, err := os.Open(profileUse)
+		if err != nil {
+			usagef("Invalid -profile-use: %v", err)
+		}
+
+		prof, err = profile.Read(f)
+		f.Close()
+
+		if err != nil {
+			usagef("Invalid -profile-use: %s: %v", profileUse, err)
+		}
+	}
+
+	level, err := passes.ParseLevel(optLevel)
+	if err != nil {
+		usagef("Invalid -O: %v", err)
+	}
+
+	if jobs < 0 {
+		usagef("Invalid -j: %d, want 1 or more, or 0 for GOMAXPROCS", jobs)
+	}
+
+	// The passes of the level and the registered ones, or the ones -opt
+	// lists, which may be any of them.
+	opts := passes.Options{InlineThreshold: inline, Profile: prof, Jobs: jobs}
+
+	optPasses := append(passes.Pipeline(level, opts), registeredPasses()...)
+
+	if optList != "" {
+		optPasses, err = passes.Select(append(passes.Default(opts), registeredPasses()...), strings.Split(optList, ","))
+		if err != nil {
+			usagef("Invalid -opt: %v", err)
+		}
+	}
+
+	if object && codegen.Backend(backend) != codegen.BackendNative {
+		usagef("Invalid -obj: only the native backend writes objects")
+	}
+
+	dumpStages, err := parseDumpIR(dumpIR, append(passes.Default(passes.Options{}), registeredPasses()...))
+	if err != nil {
+		usagef("Invalid -dump-ir: %v", err)
+	}
+
+	if len(dumpStages) > 0 && subcmd == "check" {
+		usagef("Invalid -dump-ir: check doesn't lower the program")
+	}
+
+	diags := diag.NewBag(diag.Config{
+		Strict:  strict,
+		Disable: disabled,
+		Enable:  enabled,
+	})
+
+	// writeDiagnostics writes the errors and warnings of all passes so far.
+	// Text for a terminal shows the source, and is colored unless that's
+	// turned off; text for a file or a pipe stays one line per diagnostic.
+	writeDiagnostics := func() {
+		write := diags.WriteText
+
+		switch terminal := isTerminal(os.Stdout); {
+		case diagFormat == "json":
+			write = diags.WriteJSON
+		case diagFormat == "sarif":
+			write = diags.WriteSARIF
+		case terminal || color == "always":
+			opts := diag.RenderOptions{
+				Color: color == "always" || color == "auto" && terminal && os.Getenv("NO_COLOR") == "",
+				Width: terminalWidth(os.Stdout),
+			}
+
+			write = func(w io.Writer) error { return diags.Render(w, opts) }
+		}
+
+		if err := write(os.Stdout); err != nil {
+			fatalf("failed to write diagnostics: %v", err)
+		}
+	}
+
+	// The source files of the package, or directories with them, or the ones
+	// of the manifest. Without a command, they default to the example, for
+	// quick edit-run cycles on the compiler.
+	if len(srcFiles) == 0 {
+		if subcmd != "" {
+			usagef("Invalid arguments: %s needs a source file or directory", subcmd)
+		}
+
+		srcFiles = []string{"examples/example.in"}
+	}
+
+	// ensure the source files exist
+	for _, srcFile := range srcFiles {
+		if _, err := os.Stat(srcFile); errors.Is(err, os.ErrNotExist) {
+			usagef("Source file %s does not exist.", srcFile)
+		}
+	}
+
+	// The outputs are named after the first source file, or directory, and
+	// the output directory is next to it, or in it.
+	srcFile := srcFiles[0]
+	name, srcDir := withExt(filepath.Base(srcFile), ""), filepath.Dir(srcFile)
+
+	if info, err := os.Stat(srcFile); err == nil && info.IsDir() {
+		abs, err := filepath.Abs(srcFile)
+		if err != nil {
+			fatalf("failed to resolve source directory: %v", err)
+		}
+
+		name, srcDir = filepath.Base(abs), srcFile
+	}
+
+	// The outputs of a project are named after its package, next to the
+	// manifest.
+	if project != nil {
+		srcDir = project.Dir
+
+		if project.Name != "" {
+			name = project.Name
+		} else if abs, err := filepath.Abs(project.Dir); err == nil {
+			name = filepath.Base(abs)
+		}
+	}
+
+	switch {
+	case outDir != "":
+	case subcmd == "run" && !jit:
+		// Like go run, the program is built in a temporary directory, which
+		// is removed once it ran.
+		dir, err := os.MkdirTemp("", "cubit-run-")
+		if err != nil {
+			fatalf("failed to create temporary directory: %v", err)
+		}
+
+		outDir = dir
+		cleanup = sync.OnceFunc(func() { os.RemoveAll(dir) })
+
+		defer cleanup()
+	default:
+		outDir = filepath.Join(srcDir, "out")
+	}
+
+	if subcmd != "check" || writeAST {
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			fatalf("failed to create output directory: %v", err)
+		}
+	}
+
+	astuFile := filepath.Join(outDir, name+".astu")
+	asttFile := filepath.Join(outDir, name+".astt")
+	ssaFile := filepath.Join(outDir, name+".ssa")
+	asmExt := codegen.Backend(backend).Ext()
+	if object {
+		asmExt = ".o"
+	}
+
+	asmFile := filepath.Join(outDir, name+asmExt)
+	binFile := filepath.Join(outDir, name)
+	headerFile := filepath.Join(outDir, name+".h")
+
+	if shared {
+		binFile = filepath.Join(outDir, "lib"+name+".so")
+	}
+
+	if output != "" {
+		// An absolute path, so -run doesn't look the executable up in $PATH.
+		if binFile, err = filepath.Abs(output); err != nil {
+			fatalf("failed to resolve output file: %v", err)
+		}
+	}
+
+	ldr := loader.NewLoader(diags).WithTiming(rec)
+
+	if dumpTokens {
+		ldr.WithTokens(func(_ string, tokens []lexer.Token) {
+			if err := writeTokens(os.Stdout, tokens); err != nil {
+				fatalf("failed to write tokens: %v", err)
+			}
+		})
+	}
+
+	unit, err := ldr.LoadPackage(srcFiles...)
+	if err != nil {
+		// Syntax errors are diagnostics, a missing import isn't. Tools that
+		// read the JSON or SARIF get it as one, without a location.
+		loadErr := !diags.HasErrors()
+		if loadErr && diagFormat != "text" {
+			diags.Add(fmt.Errorf("failed to load source and imports: %w", err))
+		}
+
+		writeDiagnostics()
+
+		if loadErr && diagFormat == "text" {
+			fmt.Fprintf(os.Stderr, "failed to load source and imports: %v\n", err)
+		}
+
+		cleanup()
+		os.Exit(exitErrors)
+	}
+
+	if writeAST {
+		// Before type checking
+		if err := os.WriteFile(astuFile, []byte(unit.String()), 0644); err != nil {
+			fatalf("failed to write AST file: %v", err)
+		}
+	}
+
+	// Type checking
+	stop := rec.Start("typecheck", "")
+	// A hosted executable is linked with the C library, which starts it in
+	// main.
+	executable := emit == "exe" && !jit && !shared && !freestanding &&
+		codegen.Backend(backend) != codegen.BackendBytecode
+
+	err = typecheck.NewChecker(typecheck.Options{Diagnostics: diags, Freestanding: freestanding,
+		Executable: executable}).Check(unit)
+	stop()
+
+	writeDiagnostics()
+
+	// Also when the type checker failed, to see how far it got.
+	if dumpAst != "" {
+		if err := dumpAst.write(os.Stdout, unit); err != nil {
+			fatalf("failed to write AST: %v", err)
+		}
+	}
+
+	if err != nil {
+		cleanup()
+		os.Exit(exitErrors)
+	}
+
+	if debug {
+		if errs := ast.Check(unit); len(errs) > 0 {
+			fatalf("AST invariants violated: %v", errors.Join(errs...))
+		}
+	}
+
+	if writeAST {
+		// After type checking
+		if err := os.WriteFile(asttFile, []byte(unit.String()), 0644); err != nil {
+			fatalf("failed to write AST file: %v", err)
+		}
+	}
+
+	if subcmd == "check" {
+		return
+	}
+
+	stop = rec.Start("lower", "")
+	lowUnit, err := ir.LowerWithOptions(unit, ir.LowerOptions{SourceNames: sourceNames, Target: target,
+		TrapOverflow: hardened.TrapOverflow, Jobs: jobs})
+	stop()
+
+	if err != nil {
+		fatalf("failed to lower IR: %v", err)
+	}
+
+	if debug {
+		if errs := ir.Verify(lowUnit); len(errs) > 0 {
+			fatalf("IR invariants violated after lowering: %v", errors.Join(errs...))
+		}
+	}
+
+	for _, lib := range strings.Split(link, ",") {
+		if lib != "" && !slices.Contains(lowUnit.Libraries, lib) {
+			lowUnit.Libraries = append(lowUnit.Libraries, lib)
+		}
+	}
+
+	if dumpStages[stageLower] {
+		if err := writeIR(os.Stdout, stageLower, codegen.SprintSSA(lowUnit, codegen.Options{Target: target})); err != nil {
+			fatalf("failed to write IR: %v", err)
+		}
+	}
+
+	if freestanding {
+		if err := lowUnit.WithStart(); err != nil {
+			fatalf("failed to add entry point: %v", err)
+		}
+	}
+
+	if profileGenerate != "" {
+		// The program may run in another directory.
+		path, err := filepath.Abs(profileGenerate)
+		if err != nil {
+			fatalf("failed to resolve profile file: %v", err)
+		}
+
+		if err := profile.Instrument(lowUnit, path, target); err != nil {
+			fatalf("failed to instrument IR: %v", err)
+		}
+	}
+
+	before := lowUnit.Stats()
+
+	for i, pass := range optPasses {
+		if !dumpStages[pass.Name()] {
+			continue
+		}
+
+		// One function at a time, so the dumps are in order.
+		opts.Jobs = 1
+		optPasses[i] = passes.Observe(pass, func(pass passes.Pass, fd *ir.FuncDef) {
+			if err := writeIR(os.Stdout, pass.Name(), codegen.SprintFuncSSA(fd, codegen.Options{Target: target})); err != nil {
+				fatalf("failed to write IR: %v", err)
+			}
+		})
+	}
+
+	if rec != nil {
+		// One function at a time, so the times of the passes add up.
+		opts.Jobs = 1
+
+		for i, pass := range optPasses {
+			optPasses[i] = passes.Measure(pass, func(pass passes.Pass, fd *ir.FuncDef) func() {
+				return rec.StartFunc(pass.Name(), string(fd.Ident))
+			})
+		}
+	}
+
+	if err := passes.RunJobs(lowUnit, opts.Jobs, optPasses...); err != nil {
+		fatalf("failed to optimize IR: %v", err)
+	}
+
+	// Functions whose calls were all inlined, and functions that are never
+	// called, don't need to be emitted.
+	lowUnit.RemoveDeadFuncs()
+
+	if prof != nil {
+		stop = rec.Start("layout", "")
+		if err := passes.Layout(lowUnit, prof); err != nil {
+			fatalf("failed to lay out blocks: %v", err)
+		}
+
+		stop()
+	}
+
+	stop = rec.Start("harden", "")
+	if err := passes.Harden(lowUnit, hardened); err != nil {
+		fatalf("failed to harden IR: %v", err)
+	}
+
+	stop()
+
+	if dumpStages[stageOpt] {
+		if err := writeIR(os.Stdout, stageOpt, codegen.SprintSSA(lowUnit, codegen.Options{Target: target})); err != nil {
+			fatalf("failed to write IR: %v", err)
+		}
+	}
+
+	if stats {
+		if err := ir.WriteStats(os.Stdout, before, lowUnit.Stats()); err != nil {
+			fatalf("failed to write stats: %v", err)
+		}
+	}
+
+	if debug {
+		if errs := ir.Verify(lowUnit); len(errs) > 0 {
+			fatalf("IR invariants violated after optimization: %v", errors.Join(errs...))
+		}
+	}
+
+	genOpts := codegen.Options{DebugInfo: debugInfo, Backend: codegen.Backend(backend), Target: target,
+		Object: object, Shared: shared, Annotate: annotate, StackProtector: hardened.Canaries,
+		Freestanding: freestanding, LinkerScript: linkerScript, Jobs: jobs, OptLevel: string(level),
+		// Debuggers and profilers walk the stack by the frame pointers.
+		OmitFramePointer: (level == passes.LevelFull || level == passes.LevelSize) && !debugInfo}
+
+	if writeSSA || emit == "ir" {
+		if err := codegen.WriteSSA(lowUnit, ssaFile, genOpts); err != nil {
+			fatalf("failed to write SSA file: %v", err)
+		}
+	}
+
+	if emit == "ir" {
+		return
+	}
+
+	if jit {
+		// The interpreter runs the optimized IR, so there's nothing to
+		// assemble or link.
+		in, err := interp.New(lowUnit)
+		if err != nil {
+			fatalf("failed to load program: %v", err)
+		}
+
+		report()
+		exitWith(in.Run())
+
+		return
+	}
+
+	stop = rec.Start("codegen", "")
+	if err := codegen.GenerateAssembly(srcFile, lowUnit, asmFile, genOpts); err != nil {
+		fatalf("failed to generate assembly: %v", err)
+	}
+
+	stop()
+
+	if emit == "asm" {
+		return
+	}
+
+	if codegen.Backend(backend) == codegen.BackendBytecode {
+		// Bytecode isn't linked, it runs in the VM. It's read back from the
+		// file, like an application that embeds the VM would.
+		if run {
+			report()
+
+			status, err := runBytecode(asmFile)
+			cleanup()
+			exitWith(status, err)
+		}
+
+		return
+	}
+
+	stop = rec.Start("link", "")
+	if err := codegen.Compile(asmFile, binFile, lowUnit.Libraries, genOpts); err != nil {
+		fatalf("failed to compile assembly: %v", err)
+	}
+
+	stop()
+
+	if shared {
+		if err := codegen.WriteHeader(unit, headerFile, name); err != nil {
+			fatalf("failed to write header: %v", err)
+		}
+	}
+
+	if run {
+		report()
+
+		// run and check the exit code
+		cmd := exec.Command(binFile, progArgs...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		if err := cmd.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				fmt.Fprintf(os.Stderr, "Program exited with code %d\n", exitErr.ExitCode())
+
+				cleanup()
+				os.Exit(exitErr.ExitCode())
+			} else {
+				fatalf("failed to run compiled binary: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package driver
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/corani/cubit/internal/manifest"
+)
+
+// findManifest returns the manifest of the project that's built, or nil if
+// there's none: the one at path, or else the one in the directory that's the
+// only argument, or in the working directory without arguments. It also
+// returns the sources to build, which are the ones of the manifest if there
+// are no arguments, or the argument is its directory.
+func findManifest(path string, args []string) (*manifest.Manifest, []string, error) {
+	sources := args
+
+	if path == "" {
+		dir := "."
+
+		switch len(args) {
+		case 0:
+		case 1:
+			if info, err := os.Stat(args[0]); err != nil || !info.IsDir() {
+				return nil, args, nil
+			}
+
+			dir, sources = args[0], nil
+		default:
+			return nil, args, nil
+		}
+
+		var ok bool
+		if path, ok = manifest.Find(dir); !ok {
+			return nil, args, nil
+		}
+	}
+
+	m, err := manifest.Load(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(sources) == 0 {
+		sources = m.SourcePaths()
+	}
+
+	return m, sources, nil
+}
+
+// applyManifest sets the flags to the settings of the manifest for the
+// profile, except the ones that are set on the command line.
+func applyManifest(m *manifest.Manifest, profile string) error {
+	settings, err := m.Settings(profile)
+	if err != nil {
+		return err
+	}
+
+	// The flags set so far are the ones on the command line.
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for _, s := range settings {
+		if set[s.Flag] {
+			continue
+		}
+
+		if err := flag.Set(s.Flag, s.Value); err != nil {
+			return fmt.Errorf("%s: invalid %s: %w", s.Loc, s.Flag, err)
+		}
+	}
+
+	return nil
+}
+
+// flagSet reports whether the flag name is set on the command line.
+func flagSet(name string) bool {
+	set := false
+
+	flag.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+
+	return set
+}
@@ -0,0 +1,199 @@
+// Package driver is the cubit command: it parses the flags, and loads,
+// checks, lowers, optimizes and builds the program, or runs one of the other
+// commands.
+//
+// Programs that embed the compiler extend it without a fork: they register
+// checks of the AST, passes over the IR and attributes with checks of their
+// own from init, e.g. for the lint rules of a project, and run the compiler
+// with Main.
+//
+//	// noRecursion reports the functions that call themselves.
+//	func noRecursion(pkg *driver.Package) []error {
+//		var errs []error
+//
+//		for _, fn := range pkg.Funcs {
+//			driver.Walk(fn, func(n driver.Node) bool {
+//				if call, ok := n.(*driver.CallExpr); ok && call.FuncDef == fn {
+//					errs = append(errs, driver.Errorf(call.Location(), "%s calls itself", fn.Ident))
+//				}
+//
+//				return true
+//			})
+//		}
+//
+//		return errs
+//	}
+//
+//	// double replaces multiplications by 2 with additions.
+//	type double struct{}
+//
+//	func (double) Name() string { return "double" }
+//
+//	func (double) Run(fn *driver.Func) bool {
+//		changed := false
+//
+//		for _, block := range fn.Blocks {
+//			for _, instr := range block.Instructions {
+//				if b, ok := instr.(*driver.Binop); ok && b.Op == driver.BinOpMul {
+//					if n, ok := b.Rhs.IntConst(); ok && n == 2 {
+//						b.Op, b.Rhs = driver.BinOpAdd, b.Lhs
+//						changed = true
+//					}
+//				}
+//			}
+//		}
+//
+//		return changed
+//	}
+//
+//	func init() {
+//		driver.RegisterCheck("norecursion", noRecursion)
+//		driver.RegisterPass(double{})
+//	}
+//
+//	func main() {
+//		driver.Main()
+//	}
+package driver
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/ir/passes"
+	"github.com/corani/cubit/internal/typecheck"
+)
+
+// The types of the passes and attributes, for programs outside the module.
+type (
+	// Pass is a transformation of IR functions, see RegisterPass.
+	Pass = passes.Pass
+	// UnitPass is a pass that sees the whole unit before it runs.
+	UnitPass = passes.UnitPass
+	// Func is a function of the IR, in SSA form.
+	Func = ir.FuncDef
+	// Unit is the IR of a package and its imports.
+	Unit = ir.CompilationUnit
+
+	// AttrSpec describes an attribute, see RegisterAttrHandler.
+	AttrSpec = ast.AttrSpec
+	// AttrKey is the name of an attribute.
+	AttrKey = ast.AttrKey
+	// AttrTarget is the declarations an attribute is valid on.
+	AttrTarget = ast.AttrTarget
+	// AttrValueType is the type of the value of an attribute.
+	AttrValueType = ast.AttrValueType
+	// AttrValue is the value of an attribute: AttrString, AttrInt or
+	// AttrBool.
+	AttrValue  = ast.AttrValue
+	AttrString = ast.AttrString
+	AttrInt    = ast.AttrInt
+	AttrBool   = ast.AttrBool
+
+	// Node is a declaration an attribute is on: a *Package, *TypeDecl,
+	// *DataDecl, *FuncDecl or *Param.
+	Node     = ast.Node
+	Package  = ast.CompilationUnit
+	TypeDecl = ast.TypeDef
+	DataDecl = ast.DataDef
+	FuncDecl = ast.FuncDef
+	Param    = ast.FuncParam
+
+	// CallExpr is a call in the AST, with the declaration of the function it
+	// calls once the program is type checked.
+	CallExpr = ast.Call
+	// Check is a check of a type checked package, see RegisterCheck.
+	Check = typecheck.UnitCheck
+)
+
+// The declarations an attribute can be on.
+const (
+	AttrOnPackage = ast.AttrOnPackage
+	AttrOnType    = ast.AttrOnType
+	AttrOnData    = ast.AttrOnData
+	AttrOnFunc    = ast.AttrOnFunc
+	AttrOnParam   = ast.AttrOnParam
+)
+
+// The types of the values of attributes.
+const (
+	AttrStringType = ast.AttrStringType
+	AttrIntType    = ast.AttrIntType
+	AttrBoolType   = ast.AttrBoolType
+)
+
+// AttrHandler checks an attribute on a declaration, and returns the error to
+// report, at the declaration.
+type AttrHandler func(decl Node, value AttrValue) error
+
+var (
+	pluginMu     sync.Mutex
+	pluginPasses []Pass
+)
+
+// RegisterPass adds pass to the passes the compiler runs, after the built-in
+// ones and in the order they're registered. Like those, it runs until none of
+// them changes anything, so a pass that only inspects the functions reports
+// that it didn't change them, and it may run on unrelated functions at once,
+// see passes.RunJobs. Registered passes also run with -O0, unless -opt lists
+// the passes to run. It panics if a pass of the same name is registered.
+func RegisterPass(pass Pass) {
+	pluginMu.Lock()
+	defer pluginMu.Unlock()
+
+	if slices.ContainsFunc(pluginPasses, func(p Pass) bool { return p.Name() == pass.Name() }) {
+		panic(fmt.Sprintf("driver: pass %s is already registered", pass.Name()))
+	}
+
+	pluginPasses = append(pluginPasses, pass)
+}
+
+// registeredPasses returns the passes of RegisterPass.
+func registeredPasses() []Pass {
+	pluginMu.Lock()
+	defer pluginMu.Unlock()
+
+	return slices.Clone(pluginPasses)
+}
+
+// RegisterAttrHandler adds the attribute of spec, which handler checks on
+// every declaration it's on, once it's on the right kind of declaration with
+// the right type of value. The type checker runs the checks, so they apply to
+// every command that checks the program, like check and lsp. It panics if the
+// attribute is already known, or conflicts with an unknown one. It isn't safe
+// to call while the compiler runs, so it's meant to be called from init.
+func RegisterAttrHandler(spec AttrSpec, handler AttrHandler) {
+	spec.Check = handler
+
+	if err := ast.RegisterAttr(spec); err != nil {
+		panic("driver: " + err.Error())
+	}
+}
+
+// RegisterCheck adds check to the checks of the type checker, which run on a
+// package once it type checks, in the order they're registered. Like
+// attribute handlers, they apply to every command that checks the program.
+// The check reports the errors of Errorf at their location, and others at the
+// package. It panics if a check of the same name is registered, and isn't
+// safe to call while the compiler runs, so it's meant to be called from init.
+func RegisterCheck(name string, check Check) {
+	if err := typecheck.RegisterCheck(name, check); err != nil {
+		panic("driver: " + err.Error())
+	}
+}
+
+// Errorf returns an error to report at loc, e.g. from a check or an attribute
+// handler.
+func Errorf(loc Location, format string, args ...any) error {
+	return diag.Errorf(loc, format, args...)
+}
+
+// Walk calls fn for node and the nodes in it, depth first, and doesn't visit
+// the nodes in a node fn returns false for.
+func Walk(node Node, fn func(Node) bool) {
+	ast.Walk(node, fn)
+}
@@ -0,0 +1,5 @@
+//go:build !debug
+
+package driver
+
+const debug = false
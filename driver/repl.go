@@ -0,0 +1,49 @@
+package driver
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/corani/cubit/internal/repl"
+)
+
+// runRepl is the repl command: it evaluates the entries read from stdin, in
+// the IR interpreter, until the input ends.
+func runRepl(args []string) int {
+	flags := flag.NewFlagSet("repl", flag.ExitOnError)
+
+	var help bool
+
+	flags.BoolVar(&help, "help", false, "show help message")
+
+	flags.Usage = func() {
+		out := flags.Output()
+
+		fmt.Fprintln(out, "Usage: cubit repl [options]")
+		fmt.Fprintln(out, "Options:")
+		flags.PrintDefaults()
+	}
+
+	// The flag set exits with exitUsage on errors.
+	_ = flags.Parse(args)
+
+	if help {
+		flags.SetOutput(os.Stdout)
+		flags.Usage()
+
+		return 0
+	}
+
+	if flags.NArg() > 0 {
+		usagef("Invalid arguments: repl reads its input from stdin")
+	}
+
+	if err := repl.New().Run(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+
+		return exitErrors
+	}
+
+	return 0
+}
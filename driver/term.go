@@ -0,0 +1,25 @@
+package driver
+
+import (
+	"os"
+	"strconv"
+)
+
+// isTerminal reports whether f is a terminal, rather than a file or a pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalWidth returns the width of the terminal f is, or $COLUMNS if it
+// can't tell, or 0 if it doesn't know either.
+func terminalWidth(f *os.File) int {
+	if width := windowWidth(f); width > 0 {
+		return width
+	}
+
+	width, _ := strconv.Atoi(os.Getenv("COLUMNS"))
+
+	return max(width, 0)
+}
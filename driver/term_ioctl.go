@@ -0,0 +1,24 @@
+//go:build linux || darwin
+
+package driver
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// windowWidth asks the terminal f is for its width, or returns 0 if it isn't
+// one.
+func windowWidth(f *os.File) int {
+	var ws struct {
+		Row, Col, Xpixel, Ypixel uint16
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return 0
+	}
+
+	return int(ws.Col)
+}
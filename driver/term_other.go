@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package driver
+
+import "os"
+
+// windowWidth can't ask for the width of a terminal here, so it's left to
+// $COLUMNS.
+func windowWidth(*os.File) int {
+	return 0
+}
@@ -0,0 +1,200 @@
+package driver
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/corani/cubit/internal/codegen"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/loader"
+	"github.com/corani/cubit/internal/target"
+	"github.com/corani/cubit/internal/testrun"
+)
+
+// runTest is the test command: it runs the @(test) functions of the packages
+// in args, ./... without any, and reports which pass and fail, like go test.
+// It exits with exitErrors if a test fails or a package doesn't compile.
+func runTest(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+
+	var verbose, help bool
+	var run, exec, backend string
+
+	flags.StringVar(&run, "run", "", "only run the tests whose names match this regular expression")
+	flags.BoolVar(&verbose, "v", false, "report every test, and the output of the ones that pass")
+	flags.StringVar(&exec, "exec", string(testrun.ModeJIT), "where the tests run: jit (in the IR interpreter) or binary (built with a harness)")
+	flags.StringVar(&backend, "backend", "", "backend that builds the binary with -exec=binary: qbe, native or c (default: qbe)")
+	flags.BoolVar(&help, "help", false, "show help message")
+
+	flags.Usage = func() {
+		out := flags.Output()
+
+		fmt.Fprintln(out, "Usage: cubit test [options] [packages]")
+		fmt.Fprintln(out, "A package is a source file or a directory; dir/... stands for the ones under dir with tests.")
+		fmt.Fprintln(out, "Options:")
+		flags.PrintDefaults()
+	}
+
+	// The flag set exits with exitUsage on errors.
+	_ = flags.Parse(args)
+
+	if help {
+		flags.SetOutput(os.Stdout)
+		flags.Usage()
+
+		return 0
+	}
+
+	opts := testrun.Options{Mode: testrun.Mode(exec)}
+
+	if run != "" {
+		match, err := regexp.Compile(run)
+		if err != nil {
+			usagef("Invalid -run: %v", err)
+		}
+
+		opts.Match = match
+	}
+
+	switch opts.Mode {
+	case testrun.ModeJIT:
+		if backend != "" {
+			usagef("Invalid arguments: -backend is only valid with -exec=binary")
+		}
+	case testrun.ModeBinary:
+		opts.Codegen.Backend = codegen.Backend(backend)
+		if backend == "" {
+			opts.Codegen.Backend = codegen.DefaultBackend(target.Host())
+		}
+
+		if b := opts.Codegen.Backend; b != codegen.BackendQBE && b != codegen.BackendNative && b != codegen.BackendC {
+			usagef("Invalid -backend: %s", backend)
+		}
+	default:
+		usagef("Invalid -exec: %s", exec)
+	}
+
+	patterns := flags.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+
+	pkgs, err := testrun.Packages(patterns...)
+	if err != nil {
+		usagef("Invalid arguments: %v", err)
+	}
+
+	if len(pkgs) == 0 {
+		fmt.Fprintf(os.Stderr, "no packages with tests in %s\n", strings.Join(patterns, " "))
+
+		return 0
+	}
+
+	status := 0
+
+	for _, pkg := range pkgs {
+		if !testPackage(pkg, opts, verbose) {
+			status = exitErrors
+		}
+	}
+
+	return status
+}
+
+// testPackage runs the tests of pkg and reports them, and reports whether
+// they all pass.
+func testPackage(pkg string, opts testrun.Options, verbose bool) bool {
+	start := time.Now()
+
+	diags := diag.NewBag(diag.Config{})
+	opts.Diagnostics = diags
+
+	results, err := runPackage(pkg, opts)
+	if err != nil {
+		// The errors of the package are diagnostics, the others aren't.
+		if diags.HasErrors() {
+			if err := diags.WriteText(os.Stderr); err != nil {
+				fatalf("failed to write diagnostics: %v", err)
+			}
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+
+		fmt.Printf("FAIL\t%s [build failed]\n", pkg)
+
+		return false
+	}
+
+	passed := true
+
+	for _, r := range results {
+		if verbose {
+			fmt.Printf("=== RUN   %s\n", r.Name)
+		}
+
+		switch {
+		case !r.Passed():
+			passed = false
+
+			fmt.Printf("--- FAIL: %s (%.2fs)\n", r.Name, r.Elapsed.Seconds())
+			writeIndented(os.Stdout, r.Failure)
+			writeIndented(os.Stdout, r.Output)
+		case verbose:
+			fmt.Printf("--- PASS: %s (%.2fs)\n", r.Name, r.Elapsed.Seconds())
+			writeIndented(os.Stdout, r.Output)
+		}
+	}
+
+	elapsed := time.Since(start).Seconds()
+
+	switch {
+	case !passed:
+		fmt.Println("FAIL")
+		fmt.Printf("FAIL\t%s\t%.2fs\n", pkg, elapsed)
+	case len(results) == 0:
+		fmt.Printf("ok  \t%s\t%.2fs [no tests to run]\n", pkg, elapsed)
+	default:
+		fmt.Printf("ok  \t%s\t%.2fs\n", pkg, elapsed)
+	}
+
+	return passed
+}
+
+// runPackage loads pkg and runs its tests, in a binary that's built in a
+// temporary directory with -exec=binary.
+func runPackage(pkg string, opts testrun.Options) ([]testrun.Result, error) {
+	unit, err := loader.NewLoader(opts.Diagnostics).LoadPackage(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Mode == testrun.ModeBinary {
+		dir, err := os.MkdirTemp("", "cubit-test-")
+		if err != nil {
+			return nil, err
+		}
+
+		defer os.RemoveAll(dir)
+
+		opts.Dir = dir
+	}
+
+	return testrun.Run(unit, opts)
+}
+
+// writeIndented writes the lines of s to w, indented like the output of a
+// test in go test.
+func writeIndented(w io.Writer, s string) {
+	for line := range strings.Lines(s) {
+		fmt.Fprintf(w, "    %s", line)
+
+		if !strings.HasSuffix(line, "\n") {
+			fmt.Fprintln(w)
+		}
+	}
+}
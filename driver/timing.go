@@ -0,0 +1,35 @@
+package driver
+
+import (
+	"os"
+
+	"github.com/corani/cubit/internal/timing"
+)
+
+// writeTimeReport writes the table of the phases in rec to stderr, so it
+// isn't mixed with the output of the compiler, with table, and the trace to
+// the file trace, if it's not empty.
+func writeTimeReport(rec *timing.Recorder, table bool, trace string) error {
+	if table {
+		if err := rec.WriteTable(os.Stderr); err != nil {
+			return err
+		}
+	}
+
+	if trace == "" {
+		return nil
+	}
+
+	f, err := os.Create(trace)
+	if err != nil {
+		return err
+	}
+
+	if err := rec.WriteTrace(f); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
@@ -1,3 +1,6 @@
+// Package ast defines the syntax tree produced by the parser. It is the only
+// AST representation in the compiler: types are described by the recursive
+// Type, and IR-level structures live in package ir.
 package ast
 
 import (
@@ -27,6 +30,33 @@ type Visitor interface {
 	VisitArrayIndex(*ArrayIndex)
 	VisitIf(*If)
 	VisitFor(*For)
+	VisitAssert(*Assert)
+}
+
+// VisitorE is like Visitor, but each method returns an error. Nodes dispatch to
+// it through AcceptE; a visitor that drives its own traversal stops at the first
+// error by returning it.
+type VisitorE interface {
+	VisitCompilationUnit(*CompilationUnit) error
+	VisitTypeDef(*TypeDef) error
+	VisitDataDef(*DataDef) error
+	VisitFuncDef(*FuncDef) error
+	VisitGenericParam(*GenericParam) error
+	VisitFuncParam(*FuncParam) error
+	VisitBody(*Body) error
+	VisitCall(*Call) error
+	VisitDeclare(*Declare) error
+	VisitAssign(*Assign) error
+	VisitReturn(*Return) error
+	VisitLiteral(*Literal) error
+	VisitBinop(*Binop) error
+	VisitUnaryOp(*UnaryOp) error
+	VisitVariableRef(*VariableRef) error
+	VisitDeref(*Deref) error
+	VisitArrayIndex(*ArrayIndex) error
+	VisitIf(*If) error
+	VisitFor(*For) error
+	VisitAssert(*Assert) error
 }
 
 type CompilationUnit struct {
@@ -36,6 +66,7 @@ type CompilationUnit struct {
 	Data       []*DataDef
 	Funcs      []*FuncDef
 	Attributes Attributes
+	Doc        *CommentGroup // package documentation, if any
 	Loc        lexer.Location
 }
 
@@ -61,11 +92,17 @@ func (cu *CompilationUnit) Accept(v Visitor) {
 	v.VisitCompilationUnit(cu)
 }
 
+// AcceptE implements the VisitorE pattern for CompilationUnit.
+func (cu *CompilationUnit) AcceptE(v VisitorE) error {
+	return v.VisitCompilationUnit(cu)
+}
+
 type TypeDef struct {
 	Ident      string // type name
 	Type       *Type
 	Value      Expression // optional initial value
 	Attributes Attributes
+	Doc        *CommentGroup // documentation, if any
 	Loc        lexer.Location
 }
 
@@ -87,11 +124,16 @@ func (td *TypeDef) Accept(v Visitor) {
 	v.VisitTypeDef(td)
 }
 
+func (td *TypeDef) AcceptE(v VisitorE) error {
+	return v.VisitTypeDef(td)
+}
+
 type DataDef struct {
 	Ident      string // data name
 	Type       *Type
 	Value      Expression // optional initial value
 	Attributes Attributes
+	Doc        *CommentGroup // documentation, if any
 	Loc        lexer.Location
 }
 
@@ -113,6 +155,10 @@ func (dd *DataDef) Accept(v Visitor) {
 	v.VisitDataDef(dd)
 }
 
+func (dd *DataDef) AcceptE(v VisitorE) error {
+	return v.VisitDataDef(dd)
+}
+
 type FuncDef struct {
 	Ident         string          // function name
 	GenericParams []*GenericParam // generic parameters, if any
@@ -120,6 +166,7 @@ type FuncDef struct {
 	ReturnType    *Type           // return type
 	Body          *Body           // function body
 	Attributes    Attributes      // function attributes
+	Doc           *CommentGroup   // documentation, if any
 	Loc           lexer.Location  // location information
 }
 
@@ -142,6 +189,10 @@ func (fd *FuncDef) Accept(v Visitor) {
 	v.VisitFuncDef(fd)
 }
 
+func (fd *FuncDef) AcceptE(v VisitorE) error {
+	return v.VisitFuncDef(fd)
+}
+
 type FuncParam struct {
 	Ident      string // parameter name
 	Type       *Type
@@ -168,9 +219,14 @@ func (fp *FuncParam) Accept(v Visitor) {
 	v.VisitFuncParam(fp)
 }
 
+func (fp *FuncParam) AcceptE(v VisitorE) error {
+	return v.VisitFuncParam(fp)
+}
+
 type Body struct {
 	Instructions []Instruction
 	Loc          lexer.Location
+	End          lexer.Location // of the closing brace, if parsed
 }
 
 func NewBody(instructions []Instruction, location lexer.Location) *Body {
@@ -180,6 +236,13 @@ func NewBody(instructions []Instruction, location lexer.Location) *Body {
 	}
 }
 
+// WithEnd sets the location of the closing brace of the body.
+func (b *Body) WithEnd(end lexer.Location) *Body {
+	b.End = end
+
+	return b
+}
+
 func (b *Body) Location() lexer.Location {
 	return b.Loc
 }
@@ -188,12 +251,17 @@ func (b *Body) Accept(v Visitor) {
 	v.VisitBody(b)
 }
 
+func (b *Body) AcceptE(v VisitorE) error {
+	return v.VisitBody(b)
+}
+
 func (*Body) isInstruction() {}
 
 type Instruction interface {
 	isInstruction()
 	Location() lexer.Location
 	Accept(v Visitor)
+	AcceptE(v VisitorE) error
 }
 
 var _ []Instruction = []Instruction{
@@ -203,6 +271,7 @@ var _ []Instruction = []Instruction{
 	(*Return)(nil),
 	(*If)(nil),
 	(*For)(nil),
+	(*Assert)(nil),
 	(*Body)(nil),
 }
 
@@ -235,6 +304,10 @@ func (d *Declare) Accept(v Visitor) {
 	v.VisitDeclare(d)
 }
 
+func (d *Declare) AcceptE(v VisitorE) error {
+	return v.VisitDeclare(d)
+}
+
 func (*Declare) isInstruction() {}
 
 // Assign represents assignment to an lvalue (e.g., x = 1, p^ = 2, a[0] = 3)
@@ -262,6 +335,10 @@ func (a *Assign) Accept(v Visitor) {
 	v.VisitAssign(a)
 }
 
+func (a *Assign) AcceptE(v VisitorE) error {
+	return v.VisitAssign(a)
+}
+
 func (*Assign) isInstruction() {}
 
 // If represents an if/else if/else statement.
@@ -291,6 +368,10 @@ func (i *If) Accept(v Visitor) {
 	v.VisitIf(i)
 }
 
+func (i *If) AcceptE(v VisitorE) error {
+	return v.VisitIf(i)
+}
+
 func (*If) isInstruction() {}
 
 type For struct {
@@ -319,11 +400,15 @@ func (f *For) Accept(v Visitor) {
 	v.VisitFor(f)
 }
 
+func (f *For) AcceptE(v VisitorE) error {
+	return v.VisitFor(f)
+}
+
 func (*For) isInstruction() {}
 
 type Call struct {
 	Ident   string   // function name
-	Type    *Type    // return type, if any
+	typ     *Type    // return type, if any
 	FuncDef *FuncDef // set during type checking
 	Args    []Arg
 	Loc     lexer.Location
@@ -341,10 +426,24 @@ func (c *Call) Location() lexer.Location {
 	return c.Loc
 }
 
+// Type returns the type of the expression, as resolved by the type checker.
+func (c *Call) Type() *Type {
+	return c.typ
+}
+
+// SetType sets the type of the expression.
+func (c *Call) SetType(ty *Type) {
+	c.typ = ty
+}
+
 func (c *Call) Accept(v Visitor) {
 	v.VisitCall(c)
 }
 
+func (c *Call) AcceptE(v VisitorE) error {
+	return v.VisitCall(c)
+}
+
 func (*Call) isInstruction() {}
 func (*Call) isExpression()  {}
 
@@ -393,12 +492,51 @@ func (r *Return) Accept(v Visitor) {
 	v.VisitReturn(r)
 }
 
+func (r *Return) AcceptE(v VisitorE) error {
+	return v.VisitReturn(r)
+}
+
 func (*Return) isInstruction() {}
 
+// Assert is a compile-time assertion, `#assert(cond, "message")`. The type
+// checker evaluates the condition as a constant and fails compilation with the
+// message if it's false. It generates no code.
+type Assert struct {
+	Cond    Expression
+	Message string // optional
+	Loc     lexer.Location
+}
+
+func NewAssert(cond Expression, message string, location lexer.Location) *Assert {
+	return &Assert{
+		Cond:    cond,
+		Message: message,
+		Loc:     location,
+	}
+}
+
+func (a *Assert) Location() lexer.Location {
+	return a.Loc
+}
+
+func (a *Assert) Accept(v Visitor) {
+	v.VisitAssert(a)
+}
+
+func (a *Assert) AcceptE(v VisitorE) error {
+	return v.VisitAssert(a)
+}
+
+func (*Assert) isInstruction() {}
+
+// Expression is implemented by all expression nodes. Type returns the type of
+// the expression, which is nil or TypeUnknown until the type checker has run.
 type Expression interface {
 	isExpression()
 	Location() lexer.Location
+	Type() *Type
 	Accept(v Visitor)
+	AcceptE(v VisitorE) error
 }
 
 var _ []Expression = []Expression{
@@ -414,14 +552,14 @@ var _ []Expression = []Expression{
 // Deref represents a pointer dereference expression (e.g., a^)
 type Deref struct {
 	Expr Expression // the pointer expression to dereference
-	Type *Type      // the type after dereferencing
+	typ  *Type      // the type after dereferencing
 	Loc  lexer.Location
 }
 
 func NewDeref(expr Expression, location lexer.Location) *Deref {
 	return &Deref{
 		Expr: expr,
-		Type: &Type{Kind: TypeUnknown},
+		typ:  &Type{Kind: TypeUnknown},
 		Loc:  location,
 	}
 }
@@ -430,23 +568,37 @@ func (d *Deref) Location() lexer.Location {
 	return d.Loc
 }
 
+// Type returns the type of the expression, as resolved by the type checker.
+func (d *Deref) Type() *Type {
+	return d.typ
+}
+
+// SetType sets the type of the expression.
+func (d *Deref) SetType(ty *Type) {
+	d.typ = ty
+}
+
 func (d *Deref) Accept(v Visitor) {
 	v.VisitDeref(d)
 }
 
+func (d *Deref) AcceptE(v VisitorE) error {
+	return v.VisitDeref(d)
+}
+
 func (*Deref) isExpression() {}
 func (*Deref) isLValue()     {}
 
 type VariableRef struct {
 	Ident string
-	Type  *Type
+	typ   *Type
 	Loc   lexer.Location
 }
 
 func NewVariableRef(ident string, ty TypeKind, location lexer.Location) *VariableRef {
 	return &VariableRef{
 		Ident: ident,
-		Type:  &Type{Kind: ty},
+		typ:   &Type{Kind: ty},
 		Loc:   location,
 	}
 }
@@ -455,15 +607,29 @@ func (vref *VariableRef) Location() lexer.Location {
 	return vref.Loc
 }
 
+// Type returns the type of the expression, as resolved by the type checker.
+func (vref *VariableRef) Type() *Type {
+	return vref.typ
+}
+
+// SetType sets the type of the expression.
+func (vref *VariableRef) SetType(ty *Type) {
+	vref.typ = ty
+}
+
 func (vref *VariableRef) Accept(v Visitor) {
 	v.VisitVariableRef(vref)
 }
 
+func (vref *VariableRef) AcceptE(v VisitorE) error {
+	return v.VisitVariableRef(vref)
+}
+
 func (*VariableRef) isExpression() {}
 func (*VariableRef) isLValue()     {}
 
 type Literal struct {
-	Type        *Type
+	typ         *Type
 	IntValue    int
 	StringValue string
 	BoolValue   bool
@@ -473,14 +639,14 @@ type Literal struct {
 
 func NewArrayLiteral(ty *Type, elements []Literal, location lexer.Location) *Literal {
 	return &Literal{
-		Type:       ty,
+		typ:        ty,
 		ArrayValue: elements,
 		Loc:        location,
 	}
 }
 func NewIntLiteral(val int, location lexer.Location) *Literal {
 	return &Literal{
-		Type:     &Type{Kind: TypeInt},
+		typ:      &Type{Kind: TypeInt},
 		IntValue: val,
 		Loc:      location,
 	}
@@ -488,7 +654,7 @@ func NewIntLiteral(val int, location lexer.Location) *Literal {
 
 func NewBoolLiteral(val bool, location lexer.Location) *Literal {
 	return &Literal{
-		Type:      &Type{Kind: TypeBool},
+		typ:       &Type{Kind: TypeBool},
 		BoolValue: val,
 		Loc:       location,
 	}
@@ -496,7 +662,7 @@ func NewBoolLiteral(val bool, location lexer.Location) *Literal {
 
 func NewStringLiteral(val string, location lexer.Location) *Literal {
 	return &Literal{
-		Type:        &Type{Kind: TypeString},
+		typ:         &Type{Kind: TypeString},
 		StringValue: val,
 		Loc:         location,
 	}
@@ -506,10 +672,24 @@ func (l *Literal) Location() lexer.Location {
 	return l.Loc
 }
 
+// Type returns the type of the expression, as resolved by the type checker.
+func (l *Literal) Type() *Type {
+	return l.typ
+}
+
+// SetType sets the type of the expression.
+func (l *Literal) SetType(ty *Type) {
+	l.typ = ty
+}
+
 func (l *Literal) Accept(v Visitor) {
 	v.VisitLiteral(l)
 }
 
+func (l *Literal) AcceptE(v VisitorE) error {
+	return v.VisitLiteral(l)
+}
+
 func (*Literal) isExpression() {}
 
 // BinOpKind represents the kind of binary operation.
@@ -538,7 +718,8 @@ const (
 type Binop struct {
 	Operation BinOpKind
 	Lhs, Rhs  Expression
-	Type      *Type
+	typ       *Type
+	constVal  *Literal
 	Loc       lexer.Location
 }
 
@@ -548,7 +729,7 @@ func NewBinop(op BinOpKind, lhs, rhs Expression, location lexer.Location) *Binop
 		Operation: op,
 		Lhs:       lhs,
 		Rhs:       rhs,
-		Type:      &Type{Kind: TypeUnknown},
+		typ:       &Type{Kind: TypeUnknown},
 		Loc:       location,
 	}
 }
@@ -557,17 +738,42 @@ func (b *Binop) Location() lexer.Location {
 	return b.Loc
 }
 
+// Type returns the type of the expression, as resolved by the type checker.
+func (b *Binop) Type() *Type {
+	return b.typ
+}
+
+// SetType sets the type of the expression.
+func (b *Binop) SetType(ty *Type) {
+	b.typ = ty
+}
+
+// Const returns the constant value of the expression, as folded by the type
+// checker, or nil if the expression isn't constant.
+func (b *Binop) Const() *Literal {
+	return b.constVal
+}
+
+// SetConst records the constant value of the expression.
+func (b *Binop) SetConst(lit *Literal) {
+	b.constVal = lit
+}
+
 func (b *Binop) Accept(v Visitor) {
 	v.VisitBinop(b)
 }
 
+func (b *Binop) AcceptE(v VisitorE) error {
+	return v.VisitBinop(b)
+}
+
 func (*Binop) isExpression() {}
 
 // ArrayIndex represents an array access (e.g., data[1])
 type ArrayIndex struct {
 	Array Expression // the array variable/expression
 	Index Expression // the index expression
-	Type  *Type      // the type of the array element
+	typ   *Type      // the type of the array element
 	Loc   lexer.Location
 }
 
@@ -575,7 +781,7 @@ func NewArrayIndex(array, index Expression, location lexer.Location) *ArrayIndex
 	return &ArrayIndex{
 		Array: array,
 		Index: index,
-		Type:  &Type{Kind: TypeUnknown},
+		typ:   &Type{Kind: TypeUnknown},
 		Loc:   location,
 	}
 }
@@ -584,10 +790,24 @@ func (a *ArrayIndex) Location() lexer.Location {
 	return a.Loc
 }
 
+// Type returns the type of the expression, as resolved by the type checker.
+func (a *ArrayIndex) Type() *Type {
+	return a.typ
+}
+
+// SetType sets the type of the expression.
+func (a *ArrayIndex) SetType(ty *Type) {
+	a.typ = ty
+}
+
 func (a *ArrayIndex) Accept(v Visitor) {
 	v.VisitArrayIndex(a)
 }
 
+func (a *ArrayIndex) AcceptE(v VisitorE) error {
+	return v.VisitArrayIndex(a)
+}
+
 func (*ArrayIndex) isExpression() {}
 func (*ArrayIndex) isLValue()     {}
 
@@ -601,7 +821,8 @@ const (
 type UnaryOp struct {
 	Operation UnaryOpKind
 	Expr      Expression
-	Type      *Type
+	typ       *Type
+	constVal  *Literal
 	Loc       lexer.Location
 }
 
@@ -609,7 +830,7 @@ func NewUnaryOp(op UnaryOpKind, expr Expression, location lexer.Location) *Unary
 	return &UnaryOp{
 		Operation: op,
 		Expr:      expr,
-		Type:      &Type{Kind: TypeUnknown},
+		typ:       &Type{Kind: TypeUnknown},
 		Loc:       location,
 	}
 }
@@ -618,8 +839,33 @@ func (u *UnaryOp) Location() lexer.Location {
 	return u.Loc
 }
 
+// Type returns the type of the expression, as resolved by the type checker.
+func (u *UnaryOp) Type() *Type {
+	return u.typ
+}
+
+// SetType sets the type of the expression.
+func (u *UnaryOp) SetType(ty *Type) {
+	u.typ = ty
+}
+
+// Const returns the constant value of the expression, as folded by the type
+// checker, or nil if the expression isn't constant.
+func (u *UnaryOp) Const() *Literal {
+	return u.constVal
+}
+
+// SetConst records the constant value of the expression.
+func (u *UnaryOp) SetConst(lit *Literal) {
+	u.constVal = lit
+}
+
 func (u *UnaryOp) Accept(v Visitor) {
 	v.VisitUnaryOp(u)
 }
 
+func (u *UnaryOp) AcceptE(v VisitorE) error {
+	return v.VisitUnaryOp(u)
+}
+
 func (*UnaryOp) isExpression() {}
@@ -0,0 +1,310 @@
+// Package astbuild constructs AST nodes programmatically, for code generators
+// and tests. The trees it builds have the same shape as the ones produced by the
+// parser:
+//
+//	unit, err := astbuild.Unit("main").
+//		Func(astbuild.Func("main").Attr(ast.AttrKeyExport).Returns(astbuild.Int()).Body(
+//			astbuild.Define("x", astbuild.Lit(41)),
+//			astbuild.Return(astbuild.Bin(ast.BinOpAdd, astbuild.Ref("x"), astbuild.Lit(1))),
+//		)).
+//		Build()
+package astbuild
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// UnitBuilder builds a compilation unit.
+type UnitBuilder struct {
+	unit  *ast.CompilationUnit
+	funcs []*FuncBuilder
+}
+
+// Unit starts a compilation unit for the given package.
+func Unit(pkg string) *UnitBuilder {
+	unit := ast.NewCompilationUnit(lexer.Location{})
+	unit.Ident = pkg
+
+	return &UnitBuilder{unit: unit}
+}
+
+// At sets the location of the package declaration.
+func (b *UnitBuilder) At(loc lexer.Location) *UnitBuilder {
+	b.unit.Loc = loc
+
+	return b
+}
+
+// Import adds an import of pkg under its own name.
+func (b *UnitBuilder) Import(pkg string) *UnitBuilder {
+	return b.ImportAs(pkg, pkg)
+}
+
+// ImportAs adds an import of pkg under alias.
+func (b *UnitBuilder) ImportAs(pkg, alias string) *UnitBuilder {
+	b.unit.Imports[alias] = pkg
+
+	return b
+}
+
+// Func adds function definitions to the unit.
+func (b *UnitBuilder) Func(funcs ...*FuncBuilder) *UnitBuilder {
+	b.funcs = append(b.funcs, funcs...)
+
+	return b
+}
+
+// Build returns the compilation unit, or an error describing every way in which
+// the unit is malformed.
+func (b *UnitBuilder) Build() (*ast.CompilationUnit, error) {
+	var errs []error
+
+	if b.unit.Ident == "" {
+		errs = append(errs, errors.New("unit: missing package name"))
+	}
+
+	unit := b.unit.Clone()
+	seen := make(map[string]bool)
+
+	for _, fb := range b.funcs {
+		fd, err := fb.Build()
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if seen[fd.Ident] {
+			errs = append(errs, fmt.Errorf("func %s: redeclared in unit", fd.Ident))
+		}
+
+		seen[fd.Ident] = true
+		unit.Funcs = append(unit.Funcs, fd)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return unit, nil
+}
+
+// FuncBuilder builds a function definition.
+type FuncBuilder struct {
+	fd     *ast.FuncDef
+	body   []Stmt
+	noBody bool
+}
+
+// Func starts a function definition. Without a call to Returns, the function
+// returns void.
+func Func(name string) *FuncBuilder {
+	fd := ast.NewFuncDef(name, ast.Attributes{}, lexer.Location{})
+	fd.ReturnType = Void()
+
+	return &FuncBuilder{fd: fd, noBody: true}
+}
+
+// At sets the location of the function.
+func (b *FuncBuilder) At(loc lexer.Location) *FuncBuilder {
+	b.fd.Loc = loc
+
+	return b
+}
+
+// Attr sets an attribute on the function. Without a value the attribute is a
+// flag; otherwise the value must be a string, int or bool.
+func (b *FuncBuilder) Attr(key ast.AttrKey, value ...any) *FuncBuilder {
+	b.fd.Attributes[key] = attrValue(value)
+
+	return b
+}
+
+// Param appends a parameter.
+func (b *FuncBuilder) Param(name string, ty *ast.Type) *FuncBuilder {
+	b.fd.Params = append(b.fd.Params, ast.NewFuncParam(name, ty, nil, nil, b.fd.Loc))
+
+	return b
+}
+
+// Returns sets the return type.
+func (b *FuncBuilder) Returns(ty *ast.Type) *FuncBuilder {
+	b.fd.ReturnType = ty
+
+	return b
+}
+
+// Body appends statements to the function body. Functions without a call to
+// Body are declarations, which is only valid for extern and builtin functions.
+func (b *FuncBuilder) Body(stmts ...Stmt) *FuncBuilder {
+	b.body = append(b.body, stmts...)
+	b.noBody = false
+
+	return b
+}
+
+// Build returns the function definition. Like the parser, it sets the type of
+// return statements to the return type and adds an implicit return to void
+// functions.
+func (b *FuncBuilder) Build() (*ast.FuncDef, error) {
+	fd := b.fd.Clone()
+
+	if !b.noBody {
+		instructions := flatten(b.body)
+
+		if n := len(instructions); fd.ReturnType.Kind == ast.TypeVoid {
+			if n == 0 {
+				instructions = append(instructions, ast.NewReturn(fd.Loc, fd.ReturnType))
+			} else if _, ok := instructions[n-1].(*ast.Return); !ok {
+				instructions = append(instructions, ast.NewReturn(fd.Loc, fd.ReturnType))
+			}
+		}
+
+		fd.Body = ast.NewBody(instructions, fd.Loc)
+
+		ast.Walk(fd.Body, func(n ast.Node) bool {
+			if ret, ok := n.(*ast.Return); ok && ret.Type == nil {
+				ret.Type = fd.ReturnType
+			}
+
+			return true
+		})
+	}
+
+	if err := validate(fd); err != nil {
+		return nil, fmt.Errorf("func %s: %w", fd.Ident, err)
+	}
+
+	return fd, nil
+}
+
+func attrValue(value []any) ast.AttrValue {
+	if len(value) == 0 {
+		return ast.AttrBool(true)
+	}
+
+	switch v := value[0].(type) {
+	case string:
+		return ast.AttrString(v)
+	case int:
+		return ast.AttrInt(v)
+	case bool:
+		return ast.AttrBool(v)
+	default:
+		panic(fmt.Sprintf("astbuild: unsupported attribute value %T", v))
+	}
+}
+
+// validate checks that fd is well-formed: every required child is present and
+// the declaration matches its attributes.
+func validate(fd *ast.FuncDef) error {
+	var errs []error
+
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if fd.Ident == "" {
+		fail("missing name")
+	}
+
+	if fd.ReturnType == nil {
+		fail("missing return type")
+	}
+
+	external := fd.Attributes.Has(ast.AttrKeyExtern) || fd.Attributes.Has(ast.AttrKeyBuiltin)
+
+	switch {
+	case external && fd.Body != nil:
+		fail("extern and builtin functions can't have a body")
+	case !external && fd.Body == nil:
+		fail("missing body")
+	}
+
+	var names []string
+
+	for _, param := range fd.Params {
+		if slices.Contains(names, param.Ident) {
+			fail("duplicate parameter %s", param.Ident)
+		}
+
+		if param.Type == nil {
+			fail("parameter %s: missing type", param.Ident)
+		}
+
+		names = append(names, param.Ident)
+	}
+
+	if fd.Body != nil && fd.ReturnType != nil && fd.ReturnType.Kind != ast.TypeVoid {
+		list := fd.Body.Instructions
+		if len(list) == 0 {
+			fail("missing return statement")
+		} else if _, ok := list[len(list)-1].(*ast.Return); !ok {
+			fail("missing return statement")
+		}
+	}
+
+	ast.Walk(fd, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Assign:
+			if isNil(n.LHS) || isNil(n.Value) {
+				fail("assignment requires a target and a value")
+			}
+		case *ast.Binop:
+			if isNil(n.Lhs) || isNil(n.Rhs) {
+				fail("binary %s requires two operands", n.Operation)
+			}
+		case *ast.UnaryOp:
+			if isNil(n.Expr) {
+				fail("unary %s requires an operand", n.Operation)
+			}
+		case *ast.Deref:
+			if isNil(n.Expr) {
+				fail("dereference requires an operand")
+			}
+		case *ast.ArrayIndex:
+			if isNil(n.Array) || isNil(n.Index) {
+				fail("index expression requires an array and an index")
+			}
+		case *ast.If:
+			if isNil(n.Cond) || n.Then == nil {
+				fail("if requires a condition and a body")
+			}
+		case *ast.For:
+			if n.Body == nil {
+				fail("for requires a body")
+			}
+		case *ast.Call:
+			if n.Ident == "" {
+				fail("call requires a function name")
+			}
+
+			for _, arg := range n.Args {
+				if isNil(arg.Value) {
+					fail("call %s: missing argument value", n.Ident)
+				}
+			}
+		}
+
+		return true
+	})
+
+	return errors.Join(errs...)
+}
+
+// isNil reports whether n is nil or a nil pointer wrapped in an interface.
+func isNil(n ast.Node) bool {
+	if n == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(n)
+
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
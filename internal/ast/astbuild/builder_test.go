@@ -0,0 +1,128 @@
+package astbuild
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild_MatchesParser(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+@(extern)
+puts :: func(s: string) -> int
+
+fib :: func(n: int) -> int {
+    if n < 2 {
+        return n
+    } else {
+        puts("recurse")
+    }
+    return fib(n - 1) + fib(n - 2)
+}
+
+@(export)
+main :: func() {
+    x := 0
+    for i := 0; i < 10; i = i + 1 {
+        x = fib(i)
+    }
+}
+`
+
+	parsed := testutil.Parse(t, "test.in", src)
+
+	built, err := Unit("main").Func(
+		Func("puts").Attr(ast.AttrKeyExtern).Param("s", String()).Returns(Int()),
+		Func("fib").Param("n", Int()).Returns(Int()).Body(
+			If(Bin(ast.BinOpLt, Ref("n"), Lit(2)),
+				Return(Ref("n")),
+			).Else(
+				Do(Call("puts", Lit("recurse"))),
+			),
+			Return(Bin(ast.BinOpAdd,
+				Call("fib", Bin(ast.BinOpSub, Ref("n"), Lit(1))),
+				Call("fib", Bin(ast.BinOpSub, Ref("n"), Lit(2))))),
+		),
+		Func("main").Attr(ast.AttrKeyExport).Body(
+			Define("x", Lit(0)),
+			For(Define("i", Lit(0)), Bin(ast.BinOpLt, Ref("i"), Lit(10)),
+				Assign(Ref("i"), Bin(ast.BinOpAdd, Ref("i"), Lit(1))),
+				Assign(Ref("x"), Call("fib", Ref("i"))),
+			),
+		),
+	).Build()
+	require.NoError(t, err)
+
+	stripLocations := regexp.MustCompile(` @[^ )\n]+`)
+	dump := func(unit *ast.CompilationUnit) string {
+		return stripLocations.ReplaceAllString(ast.SDump(unit), "")
+	}
+
+	require.Equal(t, dump(parsed), dump(built))
+}
+
+func TestBuild_Errors(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		unit     *UnitBuilder
+		expected string
+	}{
+		{
+			name:     "missing package",
+			unit:     Unit("").Func(Func("main").Body()),
+			expected: "unit: missing package name",
+		},
+		{
+			name:     "redeclared function",
+			unit:     Unit("main").Func(Func("f").Body(), Func("f").Body()),
+			expected: "func f: redeclared in unit",
+		},
+		{
+			name:     "missing body",
+			unit:     Unit("main").Func(Func("f")),
+			expected: "func f: missing body",
+		},
+		{
+			name:     "extern with body",
+			unit:     Unit("main").Func(Func("f").Attr(ast.AttrKeyExtern).Body()),
+			expected: "func f: extern and builtin functions can't have a body",
+		},
+		{
+			name:     "duplicate parameter",
+			unit:     Unit("main").Func(Func("f").Param("a", Int()).Param("a", Int()).Body()),
+			expected: "func f: duplicate parameter a",
+		},
+		{
+			name:     "missing return",
+			unit:     Unit("main").Func(Func("f").Returns(Int()).Body(Define("x", Lit(1)))),
+			expected: "func f: missing return statement",
+		},
+		{
+			name:     "missing operand",
+			unit:     Unit("main").Func(Func("f").Body(Define("x", Bin(ast.BinOpAdd, Lit(1), nil)))),
+			expected: "func f: binary + requires two operands",
+		},
+		{
+			name:     "nil pointer operand",
+			unit:     Unit("main").Func(Func("f").Body(Do(Call("g", (*ast.VariableRef)(nil))))),
+			expected: "func f: call g: missing argument value",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := tc.unit.Build()
+			require.EqualError(t, err, tc.expected)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package astbuild
+
+import (
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Types.
+
+func Int() *ast.Type    { return ast.NewType(ast.TypeInt, lexer.Location{}) }
+func Bool() *ast.Type   { return ast.NewType(ast.TypeBool, lexer.Location{}) }
+func String() *ast.Type { return ast.NewType(ast.TypeString, lexer.Location{}) }
+func Void() *ast.Type   { return ast.NewType(ast.TypeVoid, lexer.Location{}) }
+func Any() *ast.Type    { return ast.NewType(ast.TypeAny, lexer.Location{}) }
+
+// Ptr returns a pointer to elem.
+func Ptr(elem *ast.Type) *ast.Type {
+	return ast.NewPointerType(elem, 1, lexer.Location{})
+}
+
+// Array returns an array of size elements of type elem.
+func Array(size int, elem *ast.Type) *ast.Type {
+	return ast.NewArrayType(elem, ast.NewSizeLiteral(size), lexer.Location{})
+}
+
+// Vararg returns a variadic parameter type of elem.
+func Vararg(elem *ast.Type) *ast.Type {
+	return ast.NewVarargType(elem, lexer.Location{})
+}
+
+// Expressions.
+
+// Lit returns an int, bool or string literal.
+func Lit[T int | bool | string](v T) *ast.Literal {
+	switch v := any(v).(type) {
+	case int:
+		return ast.NewIntLiteral(v, lexer.Location{})
+	case bool:
+		return ast.NewBoolLiteral(v, lexer.Location{})
+	default:
+		return ast.NewStringLiteral(v.(string), lexer.Location{})
+	}
+}
+
+// ArrayLit returns a zero-initialized array literal of the given type.
+func ArrayLit(ty *ast.Type) *ast.Literal {
+	return ast.NewArrayLiteral(ty, nil, lexer.Location{})
+}
+
+// Ref returns a reference to a variable or parameter.
+func Ref(name string) *ast.VariableRef {
+	return ast.NewVariableRef(name, ast.TypeUnknown, lexer.Location{})
+}
+
+// Bin returns the binary expression `lhs op rhs`.
+func Bin(op ast.BinOpKind, lhs, rhs ast.Expression) *ast.Binop {
+	return ast.NewBinop(op, lhs, rhs, lexer.Location{})
+}
+
+// Neg returns the negation of expr.
+func Neg(expr ast.Expression) *ast.UnaryOp {
+	return ast.NewUnaryOp(ast.UnaryOpMinus, expr, lexer.Location{})
+}
+
+// Deref returns the dereference `expr^`.
+func Deref(expr ast.Expression) *ast.Deref {
+	return ast.NewDeref(expr, lexer.Location{})
+}
+
+// Index returns the index expression `array[index]`.
+func Index(array, index ast.Expression) *ast.ArrayIndex {
+	return ast.NewArrayIndex(array, index, lexer.Location{})
+}
+
+// Call returns a call of the named function with positional arguments.
+func Call(name string, args ...ast.Expression) *ast.Call {
+	list := make([]ast.Arg, len(args))
+
+	for i, arg := range args {
+		list[i] = ast.NewArg("", arg, nil, lexer.Location{})
+	}
+
+	return ast.NewCall(lexer.Location{}, name, list...)
+}
+
+// Statements.
+
+// Stmt is a statement in a body. A statement may expand to more than one
+// instruction, e.g. a declaration with a value is a Declare followed by an
+// Assign.
+type Stmt interface {
+	instructions() []ast.Instruction
+}
+
+type stmt []ast.Instruction
+
+func (s stmt) instructions() []ast.Instruction {
+	return s
+}
+
+func flatten(stmts []Stmt) []ast.Instruction {
+	var list []ast.Instruction
+
+	for _, s := range stmts {
+		list = append(list, s.instructions()...)
+	}
+
+	return list
+}
+
+// Declare declares a variable without a value.
+func Declare(name string, ty *ast.Type) Stmt {
+	return stmt{ast.NewDeclare(name, ty, lexer.Location{})}
+}
+
+// Define declares a variable with an inferred type and assigns value to it, as
+// in `name := value`.
+func Define(name string, value ast.Expression) Stmt {
+	return DefineTyped(name, ast.NewType(ast.TypeUnknown, lexer.Location{}), value)
+}
+
+// DefineTyped declares a variable of the given type and assigns value to it, as
+// in `name: ty = value`.
+func DefineTyped(name string, ty *ast.Type, value ast.Expression) Stmt {
+	return stmt{
+		ast.NewDeclare(name, ty, lexer.Location{}),
+		ast.NewAssign(ast.NewVariableRef(name, ty.Kind, lexer.Location{}), value, nil, lexer.Location{}),
+	}
+}
+
+// Assign assigns value to lhs.
+func Assign(lhs ast.LValue, value ast.Expression) Stmt {
+	return stmt{ast.NewAssign(lhs, value, nil, lexer.Location{})}
+}
+
+// Do uses a call as a statement.
+func Do(call *ast.Call) Stmt {
+	return stmt{call}
+}
+
+// Return returns from the function, optionally with a value. The type of the
+// return is set when the function is built.
+func Return(value ...ast.Expression) Stmt {
+	return stmt{ast.NewReturn(lexer.Location{}, nil, value...)}
+}
+
+// Block nests statements in a block.
+func Block(stmts ...Stmt) Stmt {
+	return stmt{ast.NewBody(flatten(stmts), lexer.Location{})}
+}
+
+// IfStmt is an if statement, with an optional else branch.
+type IfStmt struct {
+	iff *ast.If
+}
+
+// If returns `if cond { then... }`.
+func If(cond ast.Expression, then ...Stmt) *IfStmt {
+	return &IfStmt{
+		iff: ast.NewIf(lexer.Location{}, nil, cond, ast.NewBody(flatten(then), lexer.Location{}), nil),
+	}
+}
+
+// Init sets the initializer of the if statement.
+func (s *IfStmt) Init(init Stmt) *IfStmt {
+	s.iff.Init = init.instructions()
+
+	return s
+}
+
+// Else sets the else branch of the if statement.
+func (s *IfStmt) Else(stmts ...Stmt) *IfStmt {
+	s.iff.Else = ast.NewBody(flatten(stmts), lexer.Location{})
+
+	return s
+}
+
+// ElseIf chains another if statement as the else branch.
+func (s *IfStmt) ElseIf(next *IfStmt) *IfStmt {
+	return s.Else(next)
+}
+
+func (s *IfStmt) instructions() []ast.Instruction {
+	return []ast.Instruction{s.iff}
+}
+
+// For returns `for init; cond; post { body... }`. Init and post may be nil.
+func For(init Stmt, cond ast.Expression, post Stmt, body ...Stmt) Stmt {
+	var initList, postList []ast.Instruction
+
+	if init != nil {
+		initList = init.instructions()
+	}
+
+	if post != nil {
+		postList = post.instructions()
+	}
+
+	return stmt{ast.NewFor(lexer.Location{}, initList, cond, postList,
+		ast.NewBody(flatten(body), lexer.Location{}))}
+}
@@ -0,0 +1,156 @@
+package ast
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// ValidateAttributes checks the attributes of the unit and its declarations
+// against the attribute registry. It reports unknown attributes, attributes used
+// on the wrong kind of declaration, values of the wrong type and conflicting
+// combinations, each at the location of the declaration they're attached to,
+// and runs the checks of registered attributes.
+func ValidateAttributes(unit *CompilationUnit) []error {
+	var errs []error
+
+	check := func(decl Node, attrs Attributes, target AttrTarget, loc lexer.Location) {
+		for _, key := range attrs.Keys() {
+			spec, ok := LookupAttr(key)
+			if !ok {
+				errs = append(errs, diag.Errorf(loc, "unknown attribute %s", key))
+
+				continue
+			}
+
+			valid := true
+
+			if spec.Targets&target == 0 {
+				valid = false
+				errs = append(errs, diag.Errorf(loc, "attribute %s is not valid on a %s declaration (valid on: %s)",
+					key, target, spec.Targets))
+			}
+
+			switch actual := attrs[key].Type(); {
+			case actual == spec.Value:
+			case spec.Value == AttrBoolType:
+				valid = false
+				errs = append(errs, diag.Errorf(loc, "attribute %s doesn't take a value", key))
+			default:
+				valid = false
+				errs = append(errs, diag.Errorf(loc, "attribute %s requires a %s value, got %s",
+					key, spec.Value, actual))
+			}
+
+			if valid && spec.Check != nil {
+				if err := spec.Check(decl, attrs[key]); err != nil {
+					var d diag.Diagnostic
+					if !errors.As(err, &d) {
+						err = diag.Errorf(loc, "attribute %s: %v", key, err)
+					}
+
+					errs = append(errs, err)
+				}
+			}
+
+			// Report each conflicting pair once, at the first of the two keys.
+			for _, other := range spec.Conflicts {
+				if attrs.Has(other) && slices.Index(attrKeys, key) < slices.Index(attrKeys, other) {
+					errs = append(errs, diag.Errorf(loc, "attributes %s and %s can't be combined", key, other))
+				}
+			}
+		}
+	}
+
+	// The linker gets the libraries as -l options.
+	checkLink := func(attrs Attributes, loc lexer.Location) {
+		for _, lib := range attrs.Libraries() {
+			if lib == "" || strings.HasPrefix(lib, "-") || strings.ContainsAny(lib, " \t/") {
+				errs = append(errs, diag.Errorf(loc, "attribute %s: invalid library name %q", AttrKeyLink, lib))
+			}
+		}
+	}
+
+	check(unit, unit.Attributes, AttrOnPackage, unit.Loc)
+	checkLink(unit.Attributes, unit.Loc)
+
+	for _, td := range unit.Types {
+		check(td, td.Attributes, AttrOnType, td.Loc)
+	}
+
+	for _, dd := range unit.Data {
+		check(dd, dd.Attributes, AttrOnData, dd.Loc)
+	}
+
+	for _, fd := range unit.Funcs {
+		check(fd, fd.Attributes, AttrOnFunc, fd.Loc)
+
+		// Extern and builtin functions are defined elsewhere, all others need
+		// a body.
+		switch provided := providedBy(fd); {
+		case provided != "" && fd.Body != nil:
+			errs = append(errs, diag.Errorf(fd.Loc, "%s function '%s' can't have a body", provided, fd.Ident))
+		case provided == "" && fd.Body == nil:
+			errs = append(errs, diag.Errorf(fd.Loc, "function '%s' has no body (only %s and %s functions can omit it)",
+				fd.Ident, AttrKeyExtern, AttrKeyBuiltin))
+		}
+
+		if fd.Attributes.Has(AttrKeyNoReturn) && fd.ReturnType != nil && fd.ReturnType.Kind != TypeVoid {
+			errs = append(errs, diag.Errorf(fd.Loc, "%s function '%s' can't have a return type",
+				AttrKeyNoReturn, fd.Ident))
+		}
+
+		if fd.Attributes.Has(AttrKeyLink) && !fd.Attributes.Has(AttrKeyExtern) {
+			errs = append(errs, diag.Errorf(fd.Loc, "attribute %s is only valid on %s functions", AttrKeyLink, AttrKeyExtern))
+		}
+
+		checkLink(fd.Attributes, fd.Loc)
+
+		// The test runner calls a test without arguments, and fails it if it
+		// returns false or a non-zero int.
+		if fd.Attributes.Has(AttrKeyTest) && (len(fd.Params) > 0 || !isTestResult(fd.ReturnType)) {
+			errs = append(errs, diag.Errorf(fd.Loc, "test function '%s' can't have parameters and must return nothing, bool or int", fd.Ident))
+		}
+
+		switch callconv := fd.Attributes.CallConv(); callconv {
+		case CallConvC:
+		case CallConvNaked, CallConvInterrupt:
+			if providedBy(fd) != "" {
+				errs = append(errs, diag.Errorf(fd.Loc, "calling convention %s is only valid on functions with a body", callconv))
+			}
+
+			// The hardware calls an interrupt handler, with nothing to pass.
+			if callconv == CallConvInterrupt && (len(fd.Params) > 0 || fd.ReturnType != nil && fd.ReturnType.Kind != TypeVoid) {
+				errs = append(errs, diag.Errorf(fd.Loc, "interrupt function '%s' can't have parameters or a return type", fd.Ident))
+			}
+		default:
+			errs = append(errs, diag.Errorf(fd.Loc, "attribute %s: unknown calling convention %q (expected %s, %s or %s)",
+				AttrKeyCallConv, callconv, CallConvC, CallConvNaked, CallConvInterrupt))
+		}
+
+		for _, param := range fd.Params {
+			check(param, param.Attributes, AttrOnParam, param.Loc)
+		}
+	}
+
+	return errs
+}
+
+func isTestResult(typ *Type) bool {
+	return typ == nil || typ.Kind == TypeVoid || typ.Kind == TypeBool || typ.Kind == TypeInt
+}
+
+// providedBy returns the attribute that marks fd as defined outside the unit,
+// extern or builtin, or "" if the unit must define it.
+func providedBy(fd *FuncDef) AttrKey {
+	for _, key := range []AttrKey{AttrKeyExtern, AttrKeyBuiltin} {
+		if fd.Attributes.Has(key) {
+			return key
+		}
+	}
+
+	return ""
+}
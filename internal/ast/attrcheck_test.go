@@ -0,0 +1,263 @@
+package ast_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAttributes(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		src      string
+		expected []string
+	}{
+		{
+			name: "valid",
+			src: `package main
+
+@(extern, link_name="printf")
+printf :: func(format: string, args: ..any) -> int
+
+@(export, pure)
+main :: func() -> int {
+    return 0
+}
+`,
+		},
+		{
+			name: "wrong target",
+			src: `package main
+
+f :: func(@(export) n: int) {
+}
+`,
+			expected: []string{
+				"test.in:3:21: attribute export is not valid on a parameter declaration (valid on: function)",
+			},
+		},
+		{
+			name: "wrong value",
+			src: `package main
+
+@(link_name=1, pure="yes")
+f :: func() {
+}
+`,
+			expected: []string{
+				"test.in:4:1: attribute pure doesn't take a value",
+				"test.in:4:1: attribute link_name requires a string value, got int",
+			},
+		},
+		{
+			name: "bodies",
+			src: `package main
+
+@(extern)
+puts :: func(s: string) {
+}
+
+@(builtin)
+len :: func(s: string) -> int {
+    return 0
+}
+
+f :: func()
+
+@(export)
+g :: func()
+`,
+			expected: []string{
+				"test.in:4:1: extern function 'puts' can't have a body",
+				"test.in:8:1: builtin function 'len' can't have a body",
+				"test.in:12:1: function 'f' has no body (only extern and builtin functions can omit it)",
+				"test.in:15:1: function 'g' has no body (only extern and builtin functions can omit it)",
+			},
+		},
+		{
+			name: "conflict",
+			src: `package main
+
+@(extern, builtin)
+f :: func()
+
+@(extern, noreturn, pure)
+g :: func()
+`,
+			expected: []string{
+				"test.in:4:1: attributes extern and builtin can't be combined",
+				"test.in:7:1: attributes pure and noreturn can't be combined",
+			},
+		},
+		{
+			name: "noreturn",
+			src: `package main
+
+@(extern, noreturn)
+exit :: func(code: int)
+
+@(extern, noreturn)
+abort :: func() -> int
+`,
+			expected: []string{
+				"test.in:7:1: noreturn function 'abort' can't have a return type",
+			},
+		},
+		{
+			name: "link",
+			src: `package main
+
+@(extern, link="m")
+sqrt :: func(x: int) -> int
+
+@(link="m")
+f :: func() {
+}
+
+@(extern, link="m,-lc, ")
+g :: func()
+`,
+			expected: []string{
+				"test.in:7:1: attribute link is only valid on extern functions",
+				"test.in:11:1: attribute link: invalid library name \"-lc\"",
+				"test.in:11:1: attribute link: invalid library name \"\"",
+			},
+		},
+		{
+			name: "callconv",
+			src: `package main
+
+@(callconv="interrupt")
+timer :: func() {
+}
+
+@(callconv="naked", export)
+_start :: func() {
+}
+
+@(callconv="stdcall")
+f :: func() {
+}
+
+@(callconv="interrupt")
+g :: func(x: int) -> int {
+    return x
+}
+
+@(extern, callconv="naked")
+h :: func()
+`,
+			expected: []string{
+				"test.in:12:1: attribute callconv: unknown calling convention \"stdcall\" (expected c, naked or interrupt)",
+				"test.in:16:1: interrupt function 'g' can't have parameters or a return type",
+				"test.in:21:1: calling convention naked is only valid on functions with a body",
+			},
+		},
+		{
+			name: "test",
+			src: `package main
+
+@(test)
+test_ok :: func() -> bool {
+    return true
+}
+
+@(test)
+test_args :: func(x: int) {
+}
+
+@(test)
+test_string :: func() -> string {
+    return "fail"
+}
+
+@(test, export)
+test_export :: func() {
+}
+`,
+			expected: []string{
+				"test.in:9:1: test function 'test_args' can't have parameters and must return nothing, bool or int",
+				"test.in:13:1: test function 'test_string' can't have parameters and must return nothing, bool or int",
+				"test.in:18:1: attributes export and test can't be combined",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			unit := testutil.Parse(t, "test.in", tc.src)
+
+			var actual []string
+
+			for _, err := range ast.ValidateAttributes(unit) {
+				actual = append(actual, err.Error())
+			}
+
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+// TestRegisterAttr isn't parallel, as it changes the known attributes, which
+// the parallel tests read.
+func TestRegisterAttr(t *testing.T) {
+	maxParams := ast.AttrKey("max_params")
+
+	require.NoError(t, ast.RegisterAttr(ast.AttrSpec{
+		Key:       maxParams,
+		Targets:   ast.AttrOnFunc,
+		Value:     ast.AttrIntType,
+		Conflicts: []ast.AttrKey{ast.AttrKeyExtern},
+		Check: func(decl ast.Node, value ast.AttrValue) error {
+			if fd := decl.(*ast.FuncDef); len(fd.Params) > int(value.(ast.AttrInt)) {
+				return fmt.Errorf("'%s' has more than %d parameters", fd.Ident, value)
+			}
+
+			return nil
+		},
+	}))
+
+	require.EqualError(t, ast.RegisterAttr(ast.AttrSpec{Key: maxParams, Targets: ast.AttrOnFunc}),
+		"attribute max_params is already registered")
+	require.EqualError(t, ast.RegisterAttr(ast.AttrSpec{Key: "other", Targets: ast.AttrOnFunc, Conflicts: []ast.AttrKey{"unknown"}}),
+		"attribute other conflicts with unknown attribute unknown")
+
+	src := `package main
+
+@(max_params=1)
+f :: func(a: int) {
+}
+
+@(max_params=1)
+g :: func(a: int, b: int) {
+}
+
+@(extern, max_params=2)
+h :: func(a: int)
+
+@(max_params)
+i :: func() {
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+
+	var actual []string
+
+	for _, err := range ast.ValidateAttributes(unit) {
+		actual = append(actual, err.Error())
+	}
+
+	require.Equal(t, []string{
+		"test.in:8:1: attribute max_params: 'g' has more than 1 parameters",
+		"test.in:12:1: attributes extern and max_params can't be combined",
+		"test.in:15:1: attribute max_params requires a int value, got bool",
+	}, actual)
+}
@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"errors"
 	"fmt"
 	"slices"
 	"strings"
@@ -10,32 +11,146 @@ import (
 type AttrKey string
 
 const (
-	AttrKeyExport   AttrKey = "export"
-	AttrKeyExtern   AttrKey = "extern"
-	AttrKeyBuiltin  AttrKey = "builtin"
-	AttrKeyPrivate  AttrKey = "private"
-	AttrKeyPure     AttrKey = "pure"
-	AttrKeyLinkname AttrKey = "link_name"
-	AttrKeyNoMangle AttrKey = "no_mangle"
+	AttrKeyExport     AttrKey = "export"
+	AttrKeyExtern     AttrKey = "extern"
+	AttrKeyBuiltin    AttrKey = "builtin"
+	AttrKeyPrivate    AttrKey = "private"
+	AttrKeyPure       AttrKey = "pure"
+	AttrKeyNoReturn   AttrKey = "noreturn"
+	AttrKeyLinkname   AttrKey = "link_name"
+	AttrKeyNoMangle   AttrKey = "no_mangle"
+	AttrKeyAllow      AttrKey = "allow"
+	AttrKeyDeprecated AttrKey = "deprecated"
+	AttrKeyInline     AttrKey = "inline"
+	AttrKeyLink       AttrKey = "link"
+	AttrKeyCallConv   AttrKey = "callconv"
+	AttrKeyHosted     AttrKey = "hosted"
+	AttrKeyTest       AttrKey = "test"
 )
 
-var attrKeys = []AttrKey{
-	AttrKeyExport,
-	AttrKeyExtern,
-	AttrKeyBuiltin,
-	AttrKeyPrivate,
-	AttrKeyPure,
-	AttrKeyLinkname,
-	AttrKeyNoMangle,
+// The calling conventions of the callconv attribute.
+const (
+	CallConvC         = "c"         // the C ABI of the target, the default
+	CallConvNaked     = "naked"     // no prologue and epilogue, for entry points
+	CallConvInterrupt = "interrupt" // saves every register, returns from an interrupt
+)
+
+// AttrTarget is a set of declarations that an attribute can be attached to.
+type AttrTarget uint8
+
+const (
+	AttrOnPackage AttrTarget = 1 << iota
+	AttrOnType
+	AttrOnData
+	AttrOnFunc
+	AttrOnParam
+)
+
+func (t AttrTarget) String() string {
+	names := []string{"package", "type", "data", "function", "parameter"}
+
+	var parts []string
+
+	for i, name := range names {
+		if t&(1<<i) != 0 {
+			parts = append(parts, name)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// AttrSpec describes where an attribute may be used and what value it takes.
+type AttrSpec struct {
+	Key       AttrKey
+	Targets   AttrTarget    // declarations the attribute is valid on
+	Value     AttrValueType // AttrBoolType for flags like `@(export)`
+	Conflicts []AttrKey     // attributes that can't be combined with this one
+	// Check validates the attribute on a declaration, once its target and
+	// value are valid. It's for the attributes of RegisterAttr; an error that
+	// isn't a diagnostic is reported at the declaration.
+	Check func(decl Node, value AttrValue) error
+}
+
+// attrRegistry lists the known attributes, in declaration order.
+var attrRegistry = []AttrSpec{
+	{Key: AttrKeyExport, Targets: AttrOnFunc, Value: AttrBoolType, Conflicts: []AttrKey{AttrKeyPrivate, AttrKeyExtern, AttrKeyTest}},
+	{Key: AttrKeyExtern, Targets: AttrOnFunc, Value: AttrBoolType, Conflicts: []AttrKey{AttrKeyBuiltin, AttrKeyExport, AttrKeyInline, AttrKeyTest}},
+	{Key: AttrKeyBuiltin, Targets: AttrOnFunc, Value: AttrBoolType, Conflicts: []AttrKey{AttrKeyExtern, AttrKeyInline, AttrKeyTest}},
+	{Key: AttrKeyPrivate, Targets: AttrOnPackage | AttrOnType | AttrOnData | AttrOnFunc, Value: AttrBoolType, Conflicts: []AttrKey{AttrKeyExport}},
+	{Key: AttrKeyPure, Targets: AttrOnFunc, Value: AttrBoolType, Conflicts: []AttrKey{AttrKeyNoReturn}},
+	{Key: AttrKeyNoReturn, Targets: AttrOnFunc, Value: AttrBoolType, Conflicts: []AttrKey{AttrKeyPure}},
+	{Key: AttrKeyLinkname, Targets: AttrOnFunc, Value: AttrStringType},
+	{Key: AttrKeyNoMangle, Targets: AttrOnFunc, Value: AttrBoolType},
+	{Key: AttrKeyAllow, Targets: AttrOnPackage | AttrOnFunc | AttrOnParam, Value: AttrStringType},
+	{Key: AttrKeyDeprecated, Targets: AttrOnFunc, Value: AttrStringType},
+	{Key: AttrKeyInline, Targets: AttrOnFunc, Value: AttrBoolType, Conflicts: []AttrKey{AttrKeyExtern, AttrKeyBuiltin}},
+	{Key: AttrKeyLink, Targets: AttrOnPackage | AttrOnFunc, Value: AttrStringType},
+	{Key: AttrKeyCallConv, Targets: AttrOnFunc, Value: AttrStringType, Conflicts: []AttrKey{AttrKeyInline}},
+	{Key: AttrKeyHosted, Targets: AttrOnFunc, Value: AttrBoolType},
+	{Key: AttrKeyTest, Targets: AttrOnFunc, Value: AttrBoolType, Conflicts: []AttrKey{AttrKeyExtern, AttrKeyBuiltin, AttrKeyExport}},
+}
+
+var attrKeys = func() []AttrKey {
+	keys := make([]AttrKey, len(attrRegistry))
+
+	for i, spec := range attrRegistry {
+		keys[i] = spec.Key
+	}
+
+	return keys
+}()
+
+// LookupAttr returns the spec of a known attribute.
+func LookupAttr(key AttrKey) (AttrSpec, bool) {
+	i := slices.Index(attrKeys, key)
+	if i < 0 {
+		return AttrSpec{}, false
+	}
+
+	return attrRegistry[i], true
+}
+
+// RegisterAttr adds spec to the known attributes, for programs that embed the
+// compiler, e.g. for the attributes of their lint rules. The attributes it
+// conflicts with get it as a conflict too. It isn't safe to call while units
+// are parsed or checked, so it's meant to be called from init.
+func RegisterAttr(spec AttrSpec) error {
+	if spec.Key == "" {
+		return errors.New("attribute without a key")
+	}
+
+	if _, ok := LookupAttr(spec.Key); ok {
+		return fmt.Errorf("attribute %s is already registered", spec.Key)
+	}
+
+	if spec.Targets == 0 {
+		return fmt.Errorf("attribute %s isn't valid on any declaration", spec.Key)
+	}
+
+	for _, other := range spec.Conflicts {
+		if _, ok := LookupAttr(other); !ok {
+			return fmt.Errorf("attribute %s conflicts with unknown attribute %s", spec.Key, other)
+		}
+	}
+
+	// Conflicts are reported from the attribute that's registered first.
+	for _, other := range spec.Conflicts {
+		i := slices.Index(attrKeys, other)
+		attrRegistry[i].Conflicts = append(slices.Clip(attrRegistry[i].Conflicts), spec.Key)
+	}
+
+	attrRegistry = append(attrRegistry, spec)
+	attrKeys = append(attrKeys, spec.Key)
+
+	return nil
 }
 
 // ParseAttrKey validates and returns an AttrKey or an error if invalid.
 func ParseAttrKey(s string) (AttrKey, bool) {
-	if slices.Contains(attrKeys, AttrKey(s)) {
-		return AttrKey(s), true
-	}
+	_, ok := LookupAttr(AttrKey(s))
 
-	return AttrKey(s), false
+	return AttrKey(s), ok
 }
 
 // AttrValue is a union type for attribute values (string or int).
@@ -45,6 +160,19 @@ type AttrValue interface {
 
 type AttrValueType int
 
+func (t AttrValueType) String() string {
+	switch t {
+	case AttrStringType:
+		return "string"
+	case AttrIntType:
+		return "int"
+	case AttrBoolType:
+		return "bool"
+	default:
+		return fmt.Sprintf("AttrValueType(%d)", int(t))
+	}
+}
+
 const (
 	AttrStringType AttrValueType = iota
 	AttrIntType
@@ -76,6 +204,56 @@ func (a Attributes) Has(key AttrKey) bool {
 	return exists
 }
 
+// Libraries returns the libraries of a link attribute, which are separated
+// by commas, e.g. `@(link="m,pthread")`.
+func (a Attributes) Libraries() []string {
+	value, ok := a[AttrKeyLink].(AttrString)
+	if !ok {
+		return nil
+	}
+
+	libs := strings.Split(string(value), ",")
+	for i, lib := range libs {
+		libs[i] = strings.TrimSpace(lib)
+	}
+
+	return libs
+}
+
+// CallConv returns the calling convention of a callconv attribute, or
+// CallConvC without one.
+func (a Attributes) CallConv() string {
+	if value, ok := a[AttrKeyCallConv].(AttrString); ok {
+		return string(value)
+	}
+
+	return CallConvC
+}
+
+// Keys returns the attribute keys in declaration order, followed by any
+// unknown keys in lexical order.
+func (a Attributes) Keys() []AttrKey {
+	keys := make([]AttrKey, 0, len(a))
+
+	for _, k := range attrKeys {
+		if a.Has(k) {
+			keys = append(keys, k)
+		}
+	}
+
+	var unknown []AttrKey
+
+	for k := range a {
+		if !slices.Contains(attrKeys, k) {
+			unknown = append(unknown, k)
+		}
+	}
+
+	slices.Sort(unknown)
+
+	return append(keys, unknown...)
+}
+
 func (a Attributes) String() string {
 	if len(a) == 0 {
 		return "(attr)"
@@ -83,8 +261,8 @@ func (a Attributes) String() string {
 
 	var attrs []string
 
-	for k, v := range a {
-		switch v := v.(type) {
+	for _, k := range a.Keys() {
+		switch v := a[k].(type) {
 		case AttrString:
 			attrs = append(attrs, fmt.Sprintf("%s=%q", k, v))
 		case AttrInt:
@@ -0,0 +1,155 @@
+package ast
+
+import (
+	"fmt"
+
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Check verifies the structural invariants of a type-checked unit and returns
+// every violation it finds. It reports bugs in the compiler rather than in the
+// program being compiled, so it is meant to run after the type checker has accepted
+// the unit:
+//
+//   - required children (operands, conditions, bodies) are non-nil;
+//   - every expression, declaration and parameter has a known type;
+//   - the left-hand side of an assignment is a variable, dereference or index;
+//   - a return has a value if and only if its function returns a value;
+//   - functions have a body, unless they are extern or builtin.
+func Check(unit *CompilationUnit) []error {
+	c := &checker{}
+
+	if unit == nil {
+		c.fail(lexer.Location{}, "nil compilation unit")
+
+		return c.errs
+	}
+
+	Walk(unit, func(n Node) bool {
+		c.check(n)
+
+		return true
+	})
+
+	return c.errs
+}
+
+type checker struct {
+	errs []error
+	fn   *FuncDef // function being checked; functions don't nest
+}
+
+func (c *checker) fail(loc lexer.Location, format string, args ...any) {
+	c.errs = append(c.errs, fmt.Errorf("%s: %s", loc, fmt.Sprintf(format, args...)))
+}
+
+func (c *checker) typed(loc lexer.Location, what string, t *Type) {
+	if t == nil || t.Kind == TypeUnknown {
+		c.fail(loc, "%s has no type", what)
+	}
+}
+
+func (c *checker) required(loc lexer.Location, what string, n Node) {
+	if isNilNode(n) {
+		c.fail(loc, "%s is nil", what)
+	}
+}
+
+func (c *checker) check(n Node) {
+	loc := n.Location()
+
+	switch n := n.(type) {
+	case *TypeDef:
+		c.typed(loc, "type "+n.Ident, n.Type)
+	case *DataDef:
+		c.typed(loc, "data "+n.Ident, n.Type)
+	case *FuncDef:
+		c.fn = n
+
+		c.typed(loc, "return type of "+n.Ident, n.ReturnType)
+
+		external := n.Attributes.Has(AttrKeyExtern) || n.Attributes.Has(AttrKeyBuiltin)
+
+		switch {
+		case n.Body == nil && !external:
+			c.fail(loc, "function %s has no body", n.Ident)
+		case n.Body != nil && external:
+			c.fail(loc, "extern function %s has a body", n.Ident)
+		}
+	case *FuncParam:
+		c.typed(loc, "parameter "+n.Ident, n.Type)
+	case *Declare:
+		c.typed(loc, "declaration of "+n.Ident, n.Type)
+	case *Assign:
+		c.required(loc, "assignment target", n.LHS)
+		c.required(loc, "assigned value", n.Value)
+
+		switch n.LHS.(type) {
+		case nil, *VariableRef, *Deref, *ArrayIndex:
+		default:
+			c.fail(loc, "cannot assign to %T", n.LHS)
+		}
+	case *Assert:
+		c.required(loc, "assertion condition", n.Cond)
+	case *Return:
+		c.typed(loc, "return", n.Type)
+
+		fd := c.fn
+		if fd == nil {
+			c.fail(loc, "return outside of a function")
+
+			break
+		}
+
+		if fd.ReturnType == nil {
+			break
+		}
+
+		void := fd.ReturnType.Kind == TypeVoid
+
+		switch {
+		case void && n.Value != nil:
+			c.fail(loc, "return with a value in function %s returning void", fd.Ident)
+		case !void && n.Value == nil:
+			c.fail(loc, "return without a value in function %s returning %s", fd.Ident, fd.ReturnType)
+		}
+	case *If:
+		c.required(loc, "if condition", n.Cond)
+
+		if n.Then == nil {
+			c.fail(loc, "if body is nil")
+		}
+	case *For:
+		if n.Body == nil {
+			c.fail(loc, "for body is nil")
+		}
+	case *Call:
+		c.typed(loc, "call of "+n.Ident, n.Type())
+
+		if n.FuncDef == nil {
+			c.fail(loc, "call of %s is unresolved", n.Ident)
+		}
+
+		for i, arg := range n.Args {
+			c.required(arg.Loc, fmt.Sprintf("argument %d of %s", i+1, n.Ident), arg.Value)
+		}
+	case *Literal:
+		c.typed(loc, "literal", n.Type())
+	case *Binop:
+		c.typed(loc, "binary "+string(n.Operation), n.Type())
+		c.required(loc, "left operand of "+string(n.Operation), n.Lhs)
+		c.required(loc, "right operand of "+string(n.Operation), n.Rhs)
+	case *UnaryOp:
+		c.typed(loc, "unary "+string(n.Operation), n.Type())
+		c.required(loc, "operand of "+string(n.Operation), n.Expr)
+	case *VariableRef:
+		c.typed(loc, "reference to "+n.Ident, n.Type())
+	case *Deref:
+		c.typed(loc, "dereference", n.Type())
+		c.required(loc, "dereferenced expression", n.Expr)
+	case *ArrayIndex:
+		c.typed(loc, "index expression", n.Type())
+		c.required(loc, "indexed array", n.Array)
+		c.required(loc, "index", n.Index)
+	}
+}
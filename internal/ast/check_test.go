@@ -0,0 +1,106 @@
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/corani/cubit/internal/typecheck"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+f :: func(n: int) -> int {
+    x := n + 1
+    return x
+}
+
+main :: func() {
+    f(1)
+}
+`
+
+	parse := func(t *testing.T, checked bool) *ast.CompilationUnit {
+		t.Helper()
+
+		unit := testutil.Parse(t, "test.in", src)
+
+		if checked {
+			require.NoError(t, typecheck.Check(unit))
+		}
+
+		return unit
+	}
+
+	tt := []struct {
+		name     string
+		checked  bool
+		mutate   func(unit *ast.CompilationUnit)
+		expected []string
+	}{
+		{
+			name:    "valid",
+			checked: true,
+		},
+		{
+			name: "not type checked",
+			expected: []string{
+				"test.in:4:5: declaration of x has no type",
+				"test.in:4:5: reference to x has no type",
+				"test.in:4:10: binary + has no type",
+				"test.in:4:10: reference to n has no type",
+				"test.in:5:12: reference to x has no type",
+				"test.in:9:5: call of f has no type",
+				"test.in:9:5: call of f is unresolved",
+			},
+		},
+		{
+			name:    "missing return value",
+			checked: true,
+			mutate: func(unit *ast.CompilationUnit) {
+				unit.Funcs[0].Body.Instructions[2].(*ast.Return).Value = nil
+			},
+			expected: []string{"test.in:5:5: return without a value in function f returning int"},
+		},
+		{
+			name:    "missing operand",
+			checked: true,
+			mutate: func(unit *ast.CompilationUnit) {
+				unit.Funcs[0].Body.Instructions[1].(*ast.Assign).Value.(*ast.Binop).Rhs = nil
+			},
+			expected: []string{"test.in:4:10: right operand of + is nil"},
+		},
+		{
+			name:    "missing body",
+			checked: true,
+			mutate: func(unit *ast.CompilationUnit) {
+				unit.Funcs[1].Body = nil
+			},
+			expected: []string{"test.in:8:1: function main has no body"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			unit := parse(t, tc.checked)
+
+			if tc.mutate != nil {
+				tc.mutate(unit)
+			}
+
+			var actual []string
+
+			for _, err := range ast.Check(unit) {
+				actual = append(actual, err.Error())
+			}
+
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package ast
+
+import "maps"
+
+// Clone returns a deep copy of the compilation unit. Calls in the copy are
+// linked to the cloned function definitions.
+func (cu *CompilationUnit) Clone() *CompilationUnit {
+	if cu == nil {
+		return nil
+	}
+
+	clone := &CompilationUnit{
+		Ident:      cu.Ident,
+		Imports:    maps.Clone(cu.Imports),
+		Attributes: maps.Clone(cu.Attributes),
+		Doc:        cu.Doc.Clone(),
+		Loc:        cu.Loc,
+	}
+
+	for _, td := range cu.Types {
+		clone.Types = append(clone.Types, td.Clone())
+	}
+
+	for _, dd := range cu.Data {
+		clone.Data = append(clone.Data, dd.Clone())
+	}
+
+	funcs := make(map[*FuncDef]*FuncDef, len(cu.Funcs))
+
+	for _, fd := range cu.Funcs {
+		cloned := fd.Clone()
+		funcs[fd] = cloned
+		clone.Funcs = append(clone.Funcs, cloned)
+	}
+
+	// Relink resolved calls to the cloned definitions.
+	Walk(clone, func(n Node) bool {
+		if call, ok := n.(*Call); ok {
+			if fd, ok := funcs[call.FuncDef]; ok {
+				call.FuncDef = fd
+			}
+		}
+
+		return true
+	})
+
+	return clone
+}
+
+func (td *TypeDef) Clone() *TypeDef {
+	if td == nil {
+		return nil
+	}
+
+	clone := NewTypeDef(td.Ident, td.Type.Clone(), CloneExpression(td.Value), td.Attributes, td.Loc)
+	clone.Doc = td.Doc.Clone()
+
+	return clone
+}
+
+func (dd *DataDef) Clone() *DataDef {
+	if dd == nil {
+		return nil
+	}
+
+	clone := NewDataDef(dd.Ident, dd.Type.Clone(), CloneExpression(dd.Value), dd.Attributes, dd.Loc)
+	clone.Doc = dd.Doc.Clone()
+
+	return clone
+}
+
+// Clone returns a deep copy of the function definition. Calls inside the body
+// keep referring to the original (shared) callee definitions.
+func (fd *FuncDef) Clone() *FuncDef {
+	if fd == nil {
+		return nil
+	}
+
+	clone := NewFuncDef(fd.Ident, fd.Attributes, fd.Loc)
+	clone.ReturnType = fd.ReturnType.Clone()
+	clone.Body = fd.Body.Clone()
+	clone.Doc = fd.Doc.Clone()
+
+	for _, gp := range fd.GenericParams {
+		clone.GenericParams = append(clone.GenericParams, gp.Clone())
+	}
+
+	for _, param := range fd.Params {
+		clone.Params = append(clone.Params, param.Clone())
+	}
+
+	return clone
+}
+
+func (gp *GenericParam) Clone() *GenericParam {
+	if gp == nil {
+		return nil
+	}
+
+	return &GenericParam{
+		Kind:       gp.Kind,
+		Symbol:     gp.Symbol,
+		Type:       gp.Type.Clone(),
+		Constraint: gp.Constraint,
+		Loc:        gp.Loc,
+	}
+}
+
+func (fp *FuncParam) Clone() *FuncParam {
+	if fp == nil {
+		return nil
+	}
+
+	return NewFuncParam(fp.Ident, fp.Type.Clone(), CloneExpression(fp.Value), fp.Attributes, fp.Loc)
+}
+
+func (b *Body) Clone() *Body {
+	if b == nil {
+		return nil
+	}
+
+	return NewBody(cloneInstructions(b.Instructions), b.Loc).WithEnd(b.End)
+}
+
+func (c *Call) Clone() *Call {
+	if c == nil {
+		return nil
+	}
+
+	args := make([]Arg, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = arg.Clone()
+	}
+
+	clone := NewCall(c.Loc, c.Ident, args...)
+	clone.SetType(c.Type().Clone())
+	clone.FuncDef = c.FuncDef
+
+	return clone
+}
+
+func (a Arg) Clone() Arg {
+	return NewArg(a.Ident, CloneExpression(a.Value), a.Type.Clone(), a.Loc)
+}
+
+func (d *Declare) Clone() *Declare {
+	if d == nil {
+		return nil
+	}
+
+	return NewDeclare(d.Ident, d.Type.Clone(), d.Loc)
+}
+
+func (a *Assign) Clone() *Assign {
+	if a == nil {
+		return nil
+	}
+
+	lhs, _ := CloneExpression(a.LHS).(LValue)
+
+	return NewAssign(lhs, CloneExpression(a.Value), a.Type.Clone(), a.Loc)
+}
+
+func (r *Return) Clone() *Return {
+	if r == nil {
+		return nil
+	}
+
+	return &Return{
+		Value: CloneExpression(r.Value),
+		Type:  r.Type.Clone(),
+		Loc:   r.Loc,
+	}
+}
+
+func (i *If) Clone() *If {
+	if i == nil {
+		return nil
+	}
+
+	return NewIf(i.Loc, cloneInstructions(i.Init), CloneExpression(i.Cond),
+		i.Then.Clone(), i.Else.Clone())
+}
+
+func (f *For) Clone() *For {
+	if f == nil {
+		return nil
+	}
+
+	return NewFor(f.Loc, cloneInstructions(f.Init), CloneExpression(f.Cond),
+		cloneInstructions(f.Post), f.Body.Clone())
+}
+
+func (a *Assert) Clone() *Assert {
+	if a == nil {
+		return nil
+	}
+
+	return NewAssert(CloneExpression(a.Cond), a.Message, a.Loc)
+}
+
+func (l *Literal) Clone() *Literal {
+	if l == nil {
+		return nil
+	}
+
+	clone := &Literal{
+		typ:         l.typ.Clone(),
+		IntValue:    l.IntValue,
+		StringValue: l.StringValue,
+		BoolValue:   l.BoolValue,
+		Loc:         l.Loc,
+	}
+
+	if l.ArrayValue != nil {
+		clone.ArrayValue = make([]Literal, len(l.ArrayValue))
+
+		for i := range l.ArrayValue {
+			clone.ArrayValue[i] = *l.ArrayValue[i].Clone()
+		}
+	}
+
+	return clone
+}
+
+func (b *Binop) Clone() *Binop {
+	if b == nil {
+		return nil
+	}
+
+	clone := NewBinop(b.Operation, CloneExpression(b.Lhs), CloneExpression(b.Rhs), b.Loc)
+	clone.SetType(b.Type().Clone())
+	clone.SetConst(b.Const().Clone())
+
+	return clone
+}
+
+func (u *UnaryOp) Clone() *UnaryOp {
+	if u == nil {
+		return nil
+	}
+
+	clone := NewUnaryOp(u.Operation, CloneExpression(u.Expr), u.Loc)
+	clone.SetType(u.Type().Clone())
+	clone.SetConst(u.Const().Clone())
+
+	return clone
+}
+
+func (vref *VariableRef) Clone() *VariableRef {
+	if vref == nil {
+		return nil
+	}
+
+	return &VariableRef{
+		Ident: vref.Ident,
+		typ:   vref.typ.Clone(),
+		Loc:   vref.Loc,
+	}
+}
+
+func (d *Deref) Clone() *Deref {
+	if d == nil {
+		return nil
+	}
+
+	clone := NewDeref(CloneExpression(d.Expr), d.Loc)
+	clone.SetType(d.Type().Clone())
+
+	return clone
+}
+
+func (a *ArrayIndex) Clone() *ArrayIndex {
+	if a == nil {
+		return nil
+	}
+
+	clone := NewArrayIndex(CloneExpression(a.Array), CloneExpression(a.Index), a.Loc)
+	clone.SetType(a.Type().Clone())
+
+	return clone
+}
+
+func (t *Type) Clone() *Type {
+	if t == nil {
+		return nil
+	}
+
+	return &Type{
+		Kind: t.Kind,
+		Elem: t.Elem.Clone(),
+		Size: t.Size.Clone(),
+		Loc:  t.Loc,
+	}
+}
+
+func (s *Size) Clone() *Size {
+	if s == nil {
+		return nil
+	}
+
+	clone := *s
+
+	return &clone
+}
+
+// CloneExpression returns a deep copy of an expression, or nil if the expression
+// is nil.
+func CloneExpression(e Expression) Expression {
+	switch e := e.(type) {
+	case *Literal:
+		return e.Clone()
+	case *Binop:
+		return e.Clone()
+	case *UnaryOp:
+		return e.Clone()
+	case *VariableRef:
+		return e.Clone()
+	case *Deref:
+		return e.Clone()
+	case *Call:
+		return e.Clone()
+	case *ArrayIndex:
+		return e.Clone()
+	case nil:
+		return nil
+	default:
+		panic("unsupported expression type in CloneExpression")
+	}
+}
+
+// CloneInstruction returns a deep copy of an instruction, or nil if the
+// instruction is nil.
+func CloneInstruction(i Instruction) Instruction {
+	switch i := i.(type) {
+	case *Call:
+		return i.Clone()
+	case *Declare:
+		return i.Clone()
+	case *Assign:
+		return i.Clone()
+	case *Return:
+		return i.Clone()
+	case *If:
+		return i.Clone()
+	case *For:
+		return i.Clone()
+	case *Assert:
+		return i.Clone()
+	case *Body:
+		return i.Clone()
+	case nil:
+		return nil
+	default:
+		panic("unsupported instruction type in CloneInstruction")
+	}
+}
+
+func cloneInstructions(list []Instruction) []Instruction {
+	if list == nil {
+		return nil
+	}
+
+	clone := make([]Instruction, len(list))
+
+	for i, instr := range list {
+		clone[i] = CloneInstruction(instr)
+	}
+
+	return clone
+}
@@ -0,0 +1,84 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/stretchr/testify/require"
+)
+
+// collectPointers returns all nodes and types reachable from node.
+func collectPointers(node Node) map[any]bool {
+	seen := make(map[any]bool)
+
+	var addType func(*Type)
+	addType = func(t *Type) {
+		for ; t != nil; t = t.Elem {
+			seen[t] = true
+		}
+	}
+
+	Walk(node, func(n Node) bool {
+		seen[n] = true
+
+		switch n := n.(type) {
+		case *FuncDef:
+			addType(n.ReturnType)
+		case *FuncParam:
+			addType(n.Type)
+		case *Return:
+			addType(n.Type)
+		case *Literal:
+			addType(n.Type())
+		case *Binop:
+			addType(n.Type())
+		case *VariableRef:
+			addType(n.Type())
+		}
+
+		return true
+	})
+
+	return seen
+}
+
+func TestClone_FuncDef(t *testing.T) {
+	t.Parallel()
+
+	original := newTestFunc()
+	clone := original.Clone()
+
+	require.Equal(t, original, clone)
+
+	for ptr := range collectPointers(clone) {
+		require.False(t, collectPointers(original)[ptr], "clone shares %T with original", ptr)
+	}
+
+	// Mutating the clone must not affect the original.
+	clone.Params[0].Type.Kind = TypeBool
+	clone.Body.Instructions[0].(*If).Cond.(*Binop).Lhs.(*VariableRef).Ident = "m"
+
+	require.Equal(t, TypeInt, original.Params[0].Type.Kind)
+	require.Equal(t, "n", original.Body.Instructions[0].(*If).Cond.(*Binop).Lhs.(*VariableRef).Ident)
+}
+
+func TestClone_CompilationUnit(t *testing.T) {
+	t.Parallel()
+
+	fd := newTestFunc()
+
+	// Resolve the recursive call, like the type checker would.
+	ret := fd.Body.Instructions[1].(*Return)
+	ret.Value.(*Call).FuncDef = fd
+
+	unit := NewCompilationUnit(lexer.Location{})
+	unit.Ident = "main"
+	unit.Funcs = []*FuncDef{fd}
+
+	clone := unit.Clone()
+	cloned := clone.Funcs[0]
+
+	require.NotSame(t, fd, cloned)
+	require.Same(t, cloned, cloned.Body.Instructions[1].(*Return).Value.(*Call).FuncDef)
+	require.Same(t, fd, ret.Value.(*Call).FuncDef)
+}
@@ -0,0 +1,54 @@
+package ast
+
+import (
+	"strings"
+
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Comment is a single `//` line comment. Text excludes the leading slashes.
+type Comment struct {
+	Text string
+	Loc  lexer.Location
+}
+
+// CommentGroup is a sequence of comments on consecutive lines, such as the
+// documentation of a declaration.
+type CommentGroup struct {
+	List []*Comment
+}
+
+func NewCommentGroup(comments ...*Comment) *CommentGroup {
+	return &CommentGroup{List: comments}
+}
+
+// Text returns the text of the comment group, one line per comment. A single
+// space following the slashes is removed.
+func (g *CommentGroup) Text() string {
+	if g == nil {
+		return ""
+	}
+
+	lines := make([]string, len(g.List))
+
+	for i, c := range g.List {
+		lines[i] = strings.TrimPrefix(c.Text, " ")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (g *CommentGroup) Clone() *CommentGroup {
+	if g == nil {
+		return nil
+	}
+
+	clone := &CommentGroup{List: make([]*Comment, len(g.List))}
+
+	for i, c := range g.List {
+		copied := *c
+		clone.List[i] = &copied
+	}
+
+	return clone
+}
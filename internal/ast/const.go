@@ -0,0 +1,230 @@
+package ast
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/corani/cubit/internal/lexer"
+)
+
+var (
+	ErrNotConstant    = errors.New("not a constant expression")
+	ErrDivisionByZero = errors.New("division by zero")
+	ErrOverflow       = errors.New("constant overflow")
+	ErrConstType      = errors.New("invalid operand types")
+)
+
+// ConstError reports why an expression couldn't be evaluated. Kind is one of the
+// Err* sentinels, so callers can match it with errors.Is.
+type ConstError struct {
+	Kind error
+	Msg  string
+	Loc  lexer.Location
+}
+
+func (e *ConstError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Loc, e.Msg)
+}
+
+func (e *ConstError) Unwrap() error {
+	return e.Kind
+}
+
+func constErrorf(kind error, loc lexer.Location, format string, args ...any) error {
+	return &ConstError{Kind: kind, Msg: fmt.Sprintf(format, args...), Loc: loc}
+}
+
+// EvalConst folds a constant expression built from int, bool and string
+// literals, unary minus and binary operators into a single literal. Integers
+// have the width of `int` in the generated code (32 bits); results outside that
+// range are reported as ErrOverflow.
+func EvalConst(expr Expression) (*Literal, error) {
+	switch e := expr.(type) {
+	case *Literal:
+		if e.Type() == nil {
+			return nil, constErrorf(ErrNotConstant, e.Loc, "literal without type")
+		}
+
+		switch e.Type().Kind {
+		case TypeInt, TypeBool, TypeString:
+			return e.Clone(), nil
+		default:
+			return nil, constErrorf(ErrNotConstant, e.Loc, "%s literal is not a constant", e.Type())
+		}
+	case *UnaryOp:
+		return evalUnaryOp(e)
+	case *Binop:
+		return evalBinop(e)
+	case nil:
+		return nil, constErrorf(ErrNotConstant, lexer.Location{}, "missing expression")
+	default:
+		return nil, constErrorf(ErrNotConstant, expr.Location(), "%T is not a constant", expr)
+	}
+}
+
+// ConstValue returns the constant value of expr as recorded by the type checker:
+// the literal itself for int, bool and string literals, or the folded value of
+// an operation. It returns nil if expr isn't constant or hasn't been checked.
+func ConstValue(expr Expression) *Literal {
+	switch e := expr.(type) {
+	case *Literal:
+		if t := e.Type(); t != nil && (t.Kind == TypeInt || t.Kind == TypeBool || t.Kind == TypeString) {
+			return e
+		}
+
+		return nil
+	case *Binop:
+		return e.Const()
+	case *UnaryOp:
+		return e.Const()
+	default:
+		return nil
+	}
+}
+
+// EvalConstInt is like EvalConst, but requires the result to be an integer, as
+// for array sizes.
+func EvalConstInt(expr Expression) (int, error) {
+	lit, err := EvalConst(expr)
+	if err != nil {
+		return 0, err
+	}
+
+	if lit.Type().Kind != TypeInt {
+		return 0, constErrorf(ErrConstType, lit.Loc, "expected int constant, got %s", lit.Type())
+	}
+
+	return lit.IntValue, nil
+}
+
+func evalUnaryOp(u *UnaryOp) (*Literal, error) {
+	x, err := EvalConst(u.Expr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case u.Operation == UnaryOpMinus && x.Type().Kind == TypeInt:
+		return intConst(-int64(x.IntValue), u.Loc)
+	default:
+		return nil, constErrorf(ErrConstType, u.Loc, "invalid operation: %s%s", u.Operation, x.Type())
+	}
+}
+
+func evalBinop(b *Binop) (*Literal, error) {
+	lhs, err := EvalConst(b.Lhs)
+	if err != nil {
+		return nil, err
+	}
+
+	rhs, err := EvalConst(b.Rhs)
+	if err != nil {
+		return nil, err
+	}
+
+	if lhs.Type().Kind != rhs.Type().Kind {
+		return nil, constErrorf(ErrConstType, b.Loc, "mismatched types %s %s %s",
+			lhs.Type(), b.Operation, rhs.Type())
+	}
+
+	switch lhs.Type().Kind {
+	case TypeInt:
+		return evalIntBinop(b, int64(lhs.IntValue), int64(rhs.IntValue))
+	case TypeBool:
+		return evalBoolBinop(b, lhs.BoolValue, rhs.BoolValue)
+	case TypeString:
+		return evalStringBinop(b, lhs.StringValue, rhs.StringValue)
+	default:
+		return nil, constErrorf(ErrConstType, b.Loc, "invalid operand type %s", lhs.Type())
+	}
+}
+
+func evalIntBinop(b *Binop, x, y int64) (*Literal, error) {
+	switch b.Operation {
+	case BinOpAdd:
+		return intConst(x+y, b.Loc)
+	case BinOpSub:
+		return intConst(x-y, b.Loc)
+	case BinOpMul:
+		return intConst(x*y, b.Loc)
+	case BinOpDiv, BinOpMod:
+		if y == 0 {
+			return nil, constErrorf(ErrDivisionByZero, b.Loc, "division by zero")
+		}
+
+		if b.Operation == BinOpDiv {
+			return intConst(x/y, b.Loc)
+		}
+
+		return intConst(x%y, b.Loc)
+	case BinOpShl, BinOpShr:
+		if y < 0 || y >= 32 {
+			return nil, constErrorf(ErrOverflow, b.Loc, "invalid shift count %d", y)
+		}
+
+		// Shifts work on the bits of the word, the way they do at runtime:
+		// bits shifted out of the word are dropped, and shifting right is
+		// logical.
+		if b.Operation == BinOpShl {
+			return intConst(int64(int32(uint32(x)<<y)), b.Loc)
+		}
+
+		return intConst(int64(int32(uint32(x)>>y)), b.Loc)
+	case BinOpAnd:
+		return intConst(x&y, b.Loc)
+	case BinOpOr:
+		return intConst(x|y, b.Loc)
+	case BinOpEq:
+		return NewBoolLiteral(x == y, b.Loc), nil
+	case BinOpNe:
+		return NewBoolLiteral(x != y, b.Loc), nil
+	case BinOpLt:
+		return NewBoolLiteral(x < y, b.Loc), nil
+	case BinOpLe:
+		return NewBoolLiteral(x <= y, b.Loc), nil
+	case BinOpGt:
+		return NewBoolLiteral(x > y, b.Loc), nil
+	case BinOpGe:
+		return NewBoolLiteral(x >= y, b.Loc), nil
+	default:
+		return nil, constErrorf(ErrConstType, b.Loc, "operator %s not defined on int", b.Operation)
+	}
+}
+
+func evalBoolBinop(b *Binop, x, y bool) (*Literal, error) {
+	switch b.Operation {
+	case BinOpLogAnd:
+		return NewBoolLiteral(x && y, b.Loc), nil
+	case BinOpLogOr:
+		return NewBoolLiteral(x || y, b.Loc), nil
+	case BinOpEq:
+		return NewBoolLiteral(x == y, b.Loc), nil
+	case BinOpNe:
+		return NewBoolLiteral(x != y, b.Loc), nil
+	default:
+		return nil, constErrorf(ErrConstType, b.Loc, "operator %s not defined on bool", b.Operation)
+	}
+}
+
+func evalStringBinop(b *Binop, x, y string) (*Literal, error) {
+	switch b.Operation {
+	case BinOpAdd:
+		return NewStringLiteral(x+y, b.Loc), nil
+	case BinOpEq:
+		return NewBoolLiteral(x == y, b.Loc), nil
+	case BinOpNe:
+		return NewBoolLiteral(x != y, b.Loc), nil
+	default:
+		return nil, constErrorf(ErrConstType, b.Loc, "operator %s not defined on string", b.Operation)
+	}
+}
+
+// intConst returns an int literal, or ErrOverflow if v doesn't fit in 32 bits.
+func intConst(v int64, loc lexer.Location) (*Literal, error) {
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return nil, constErrorf(ErrOverflow, loc, "constant %d overflows int", v)
+	}
+
+	return NewIntLiteral(int(v), loc), nil
+}
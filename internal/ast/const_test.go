@@ -0,0 +1,102 @@
+package ast
+
+import (
+	"math"
+	"testing"
+
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvalConst(t *testing.T) {
+	t.Parallel()
+
+	var loc lexer.Location
+
+	num := func(v int) Expression { return NewIntLiteral(v, loc) }
+	bin := func(op BinOpKind, l, r Expression) Expression { return NewBinop(op, l, r, loc) }
+
+	tt := []struct {
+		name     string
+		expr     Expression
+		expected *Literal
+		err      error
+	}{
+		{
+			name:     "arithmetic",
+			expr:     bin(BinOpMul, bin(BinOpAdd, num(1), num(2)), NewUnaryOp(UnaryOpMinus, num(3), loc)),
+			expected: NewIntLiteral(-9, loc),
+		},
+		{
+			name:     "bitwise",
+			expr:     bin(BinOpOr, bin(BinOpShl, num(1), num(4)), bin(BinOpAnd, num(7), num(2))),
+			expected: NewIntLiteral(18, loc),
+		},
+		{
+			name:     "shift into the sign bit",
+			expr:     bin(BinOpShl, num(1), num(31)),
+			expected: NewIntLiteral(math.MinInt32, loc),
+		},
+		{
+			name:     "shift left negative",
+			expr:     bin(BinOpShl, num(-17), num(2)),
+			expected: NewIntLiteral(-68, loc),
+		},
+		{
+			name:     "shift right negative is logical",
+			expr:     bin(BinOpShr, num(-17), num(2)),
+			expected: NewIntLiteral(1073741819, loc),
+		},
+		{
+			name:     "comparison",
+			expr:     bin(BinOpLogAnd, bin(BinOpLt, num(1), num(2)), NewBoolLiteral(true, loc)),
+			expected: NewBoolLiteral(true, loc),
+		},
+		{
+			name:     "string concatenation",
+			expr:     bin(BinOpAdd, NewStringLiteral("foo", loc), NewStringLiteral("bar", loc)),
+			expected: NewStringLiteral("foobar", loc),
+		},
+		{
+			name: "division by zero",
+			expr: bin(BinOpMod, num(1), bin(BinOpSub, num(2), num(2))),
+			err:  ErrDivisionByZero,
+		},
+		{
+			name: "overflow",
+			expr: bin(BinOpAdd, num(math.MaxInt32), num(1)),
+			err:  ErrOverflow,
+		},
+		{
+			name: "shift overflow",
+			expr: bin(BinOpShl, num(1), num(32)),
+			err:  ErrOverflow,
+		},
+		{
+			name: "mismatched types",
+			expr: bin(BinOpAdd, num(1), NewBoolLiteral(true, loc)),
+			err:  ErrConstType,
+		},
+		{
+			name: "variable",
+			expr: bin(BinOpAdd, num(1), NewVariableRef("x", TypeInt, loc)),
+			err:  ErrNotConstant,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := EvalConst(tc.expr)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package ast
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ChangeKind describes how a declaration or statement differs between two
+// versions of a unit.
+type ChangeKind int
+
+const (
+	ChangeAdded ChangeKind = iota
+	ChangeRemoved
+	ChangeModified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "changed"
+	default:
+		return fmt.Sprintf("ChangeKind(%d)", int(k))
+	}
+}
+
+// Change is a single difference reported by Diff. Path names the declaration,
+// e.g. `func main`, optionally followed by the part that changed, e.g.
+// `func main: signature` or `func main: statement 2`. Old is nil for additions
+// and New is nil for removals.
+type Change struct {
+	Kind ChangeKind
+	Path string
+	Old  Node
+	New  Node
+}
+
+func (c Change) String() string {
+	switch {
+	case c.New != nil:
+		return fmt.Sprintf("%s %s (%s)", c.Kind, c.Path, c.New.Location())
+	case c.Old != nil:
+		return fmt.Sprintf("%s %s (%s)", c.Kind, c.Path, c.Old.Location())
+	default:
+		return fmt.Sprintf("%s %s", c.Kind, c.Path)
+	}
+}
+
+// Diff compares two versions of a unit and reports the declarations that were
+// added, removed or changed. For functions that exist in both, it reports a
+// change to the signature and the statements of the body that were added,
+// removed or changed. Nodes are compared by structure; locations are ignored, so
+// moving a declaration is not a change.
+func Diff(old, new *CompilationUnit) []Change {
+	var changes []Change
+
+	if old.Ident != new.Ident {
+		changes = append(changes, Change{Kind: ChangeModified, Path: "package", Old: old, New: new})
+	}
+
+	changes = append(changes, diffImports(old.Imports, new.Imports)...)
+	changes = append(changes, diffDecls("type", old.Types, new.Types, typeName, diffLeaf)...)
+	changes = append(changes, diffDecls("data", old.Data, new.Data, dataName, diffLeaf)...)
+	changes = append(changes, diffDecls("func", old.Funcs, new.Funcs, funcNames(old.Funcs, new.Funcs), diffFunc)...)
+
+	return changes
+}
+
+func typeName(td *TypeDef) string { return td.Ident }
+func dataName(dd *DataDef) string { return dd.Ident }
+
+// funcNames returns the name functions are matched by: their name, followed by
+// their parameter types if the name is overloaded in either version, e.g.
+// `add(int, int)`. A function that isn't overloaded is matched by its name
+// alone, so a change to its parameters is a change to its signature.
+func funcNames(old, new []*FuncDef) func(*FuncDef) string {
+	overloaded := make(map[string]bool)
+
+	for _, funcs := range [][]*FuncDef{old, new} {
+		seen := make(map[string]bool, len(funcs))
+
+		for _, fd := range funcs {
+			if seen[fd.Ident] {
+				overloaded[fd.Ident] = true
+			}
+
+			seen[fd.Ident] = true
+		}
+	}
+
+	return func(fd *FuncDef) string {
+		if !overloaded[fd.Ident] {
+			return fd.Ident
+		}
+
+		params := make([]string, len(fd.Params))
+		for i, param := range fd.Params {
+			params[i] = param.Type.String()
+		}
+
+		return fd.Ident + "(" + strings.Join(params, ", ") + ")"
+	}
+}
+
+func diffImports(old, new map[string]string) []Change {
+	var changes []Change
+
+	aliases := make([]string, 0, len(old)+len(new))
+	for alias := range old {
+		aliases = append(aliases, alias)
+	}
+
+	for alias := range new {
+		if _, ok := old[alias]; !ok {
+			aliases = append(aliases, alias)
+		}
+	}
+
+	slices.Sort(aliases)
+
+	for _, alias := range aliases {
+		o, inOld := old[alias]
+		n, inNew := new[alias]
+		path := fmt.Sprintf("import %s %q", alias, n)
+
+		switch {
+		case !inNew:
+			changes = append(changes, Change{Kind: ChangeRemoved, Path: fmt.Sprintf("import %s %q", alias, o)})
+		case !inOld:
+			changes = append(changes, Change{Kind: ChangeAdded, Path: path})
+		case o != n:
+			changes = append(changes, Change{Kind: ChangeModified, Path: path})
+		}
+	}
+
+	return changes
+}
+
+// diffDecls matches declarations by name. Removed and changed declarations are
+// reported in the order of old, added declarations in the order of new.
+func diffDecls[T Node](kind string, old, new []T, name func(T) string, diff func(path string, o, n T) []Change) []Change {
+	var changes []Change
+
+	index := make(map[string]T, len(new))
+	for _, n := range new {
+		index[name(n)] = n
+	}
+
+	seen := make(map[string]bool, len(old))
+
+	for _, o := range old {
+		path := kind + " " + name(o)
+		seen[name(o)] = true
+
+		n, ok := index[name(o)]
+		if !ok {
+			changes = append(changes, Change{Kind: ChangeRemoved, Path: path, Old: o})
+
+			continue
+		}
+
+		changes = append(changes, diff(path, o, n)...)
+	}
+
+	for _, n := range new {
+		if !seen[name(n)] {
+			changes = append(changes, Change{Kind: ChangeAdded, Path: kind + " " + name(n), New: n})
+		}
+	}
+
+	return changes
+}
+
+func diffLeaf[T Node](path string, o, n T) []Change {
+	if shape(o) == shape(n) {
+		return nil
+	}
+
+	return []Change{{Kind: ChangeModified, Path: path, Old: o, New: n}}
+}
+
+func diffFunc(path string, o, n *FuncDef) []Change {
+	var changes []Change
+
+	// Compare everything but the body.
+	oSig, nSig := *o, *n
+	oSig.Body, nSig.Body = nil, nil
+
+	if shape(&oSig) != shape(&nSig) {
+		changes = append(changes, Change{Kind: ChangeModified, Path: path + ": signature", Old: o, New: n})
+	}
+
+	var oList, nList []Instruction
+
+	if o.Body != nil {
+		oList = o.Body.Instructions
+	}
+
+	if n.Body != nil {
+		nList = n.Body.Instructions
+	}
+
+	return append(changes, diffStatements(path, oList, nList)...)
+}
+
+// diffStatements aligns two statement lists on their longest common subsequence.
+// A statement that is removed and replaced by another at the same position is
+// reported as changed. Statements are numbered from 1, in the new list for
+// additions and changes and in the old list for removals.
+func diffStatements(path string, old, new []Instruction) []Change {
+	oShapes := make([]string, len(old))
+	for i, instr := range old {
+		oShapes[i] = shape(instr)
+	}
+
+	nShapes := make([]string, len(new))
+	for i, instr := range new {
+		nShapes[i] = shape(instr)
+	}
+
+	// lcs[i][j] is the length of the LCS of old[i:] and new[j:].
+	lcs := make([][]int, len(old)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(new)+1)
+	}
+
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			if oShapes[i] == nShapes[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var changes []Change
+
+	stmt := func(i int) string {
+		return fmt.Sprintf("%s: statement %d", path, i+1)
+	}
+
+	i, j := 0, 0
+
+	for i < len(old) || j < len(new) {
+		switch {
+		case i < len(old) && j < len(new) && oShapes[i] == nShapes[j]:
+			i, j = i+1, j+1
+		case i < len(old) && j < len(new) && lcs[i+1][j+1] == lcs[i][j]:
+			// Neither statement is part of the LCS: one replaced the other.
+			changes = append(changes, Change{Kind: ChangeModified, Path: stmt(j), Old: old[i], New: new[j]})
+			i, j = i+1, j+1
+		case j == len(new) || (i < len(old) && lcs[i+1][j] >= lcs[i][j+1]):
+			changes = append(changes, Change{Kind: ChangeRemoved, Path: stmt(i), Old: old[i]})
+			i++
+		default:
+			changes = append(changes, Change{Kind: ChangeAdded, Path: stmt(j), New: new[j]})
+			j++
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,114 @@
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	parse := func(src string) *ast.CompilationUnit {
+		return testutil.Parse(t, "test.in", src)
+	}
+
+	old := parse(`package main
+
+add :: func(a: int, b: int) -> int {
+    return a + b
+}
+
+unused :: func() {
+}
+
+main :: func() -> int {
+    x := add(1, 2)
+    y := 3
+    return x
+}
+`)
+
+	new := parse(`package main
+
+// Moving a declaration isn't a change.
+
+add :: func(a: int, b: int) -> int {
+    return a + b
+}
+
+main :: func(n: int) -> int {
+    x := add(1, 2)
+    z := 4
+    x = x + n
+    return x
+}
+
+helper :: func() {
+}
+`)
+
+	var actual []string
+
+	for _, change := range ast.Diff(old, new) {
+		actual = append(actual, change.String())
+	}
+
+	require.Equal(t, []string{
+		"removed func unused (test.in:7:1)",
+		"changed func main: signature (test.in:9:1)",
+		"changed func main: statement 3 (test.in:11:5)",
+		"changed func main: statement 4 (test.in:11:5)",
+		"added func main: statement 5 (test.in:12:5)",
+		"added func helper (test.in:16:1)",
+	}, actual)
+
+	require.Empty(t, ast.Diff(old, old.Clone()))
+}
+
+func TestDiff_Overload(t *testing.T) {
+	t.Parallel()
+
+	parse := func(src string) *ast.CompilationUnit {
+		return testutil.Parse(t, "test.in", src)
+	}
+
+	old := parse(`package main
+
+add :: func(a: int, b: int) -> int {
+    return a + b
+}
+
+add :: func(a: string, b: string) -> string {
+    return a + b
+}
+`)
+
+	new := parse(`package main
+
+add :: func(a: string, b: string) -> string {
+    return b + a
+}
+
+add :: func(a: int, b: int) -> int {
+    return a + b
+}
+
+add :: func(a: bool) -> bool {
+    return a
+}
+`)
+
+	var actual []string
+
+	for _, change := range ast.Diff(old, new) {
+		actual = append(actual, change.String())
+	}
+
+	require.Equal(t, []string{
+		"changed func add(string, string): statement 1 (test.in:4:5)",
+		"added func add(bool) (test.in:11:1)",
+	}, actual)
+}
@@ -0,0 +1,248 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Dump writes a stable s-expression representation of node and its children to
+// w, one node per line. Types are written as `:type` and locations as `@line:col`;
+// the file name is only written where it differs from the enclosing unit.
+func Dump(w io.Writer, node Node) error {
+	d := &dumper{}
+
+	if cu, ok := node.(*CompilationUnit); ok {
+		d.filename = cu.Loc.Filename
+	}
+
+	d.node(node, 0)
+	d.sb.WriteString("\n")
+
+	_, err := io.WriteString(w, d.sb.String())
+
+	return err
+}
+
+// SDump returns the Dump output for node as a string.
+func SDump(node Node) string {
+	var sb strings.Builder
+
+	_ = Dump(&sb, node)
+
+	return sb.String()
+}
+
+// shape returns the dump of node without locations, to compare trees by
+// structure.
+func shape(node Node) string {
+	d := &dumper{noLoc: true}
+	d.node(node, 0)
+
+	return d.sb.String()
+}
+
+type dumper struct {
+	sb       strings.Builder
+	filename string
+	noLoc    bool
+	started  bool
+}
+
+func (d *dumper) open(depth int, parts ...string) {
+	if d.started {
+		d.sb.WriteString("\n")
+	}
+
+	d.started = true
+
+	d.sb.WriteString(strings.Repeat("  ", depth))
+	d.sb.WriteString("(")
+	d.sb.WriteString(strings.Join(slices.DeleteFunc(parts, func(s string) bool { return s == "" }), " "))
+}
+
+func (d *dumper) close() {
+	d.sb.WriteString(")")
+}
+
+func (d *dumper) node(n Node, depth int) {
+	if isNilNode(n) {
+		d.open(depth, "nil")
+		d.close()
+
+		return
+	}
+
+	loc := d.loc(n.Location())
+
+	switch n := n.(type) {
+	case *CompilationUnit:
+		d.open(depth, "unit", n.Ident, loc, dumpAttrs(n.Attributes))
+
+		aliases := make([]string, 0, len(n.Imports))
+		for alias := range n.Imports {
+			aliases = append(aliases, alias)
+		}
+
+		slices.Sort(aliases)
+
+		for _, alias := range aliases {
+			d.open(depth+1, "import", alias, strconv.Quote(n.Imports[alias]))
+			d.close()
+		}
+	case *TypeDef:
+		d.open(depth, "typedef", n.Ident, dumpType(n.Type), loc, dumpAttrs(n.Attributes))
+	case *DataDef:
+		d.open(depth, "datadef", n.Ident, dumpType(n.Type), loc, dumpAttrs(n.Attributes))
+	case *FuncDef:
+		d.open(depth, "func", n.Ident, dumpType(n.ReturnType), loc, dumpAttrs(n.Attributes))
+	case *GenericParam:
+		if n.Kind == GenericValue {
+			d.open(depth, "generic", "$"+n.Symbol, dumpType(n.Type), loc)
+		} else if n.Constraint != "" {
+			d.open(depth, "generic", "$"+n.Symbol, n.Constraint, loc)
+		} else {
+			d.open(depth, "generic", "$"+n.Symbol, loc)
+		}
+	case *FuncParam:
+		d.open(depth, "param", n.Ident, dumpType(n.Type), loc, dumpAttrs(n.Attributes))
+	case *Body:
+		d.open(depth, "body", loc)
+	case *Call:
+		d.open(depth, "call", n.Ident, dumpType(n.Type()), loc)
+	case *Declare:
+		d.open(depth, "declare", n.Ident, dumpType(n.Type), loc)
+	case *Assign:
+		d.open(depth, "assign", dumpType(n.Type), loc)
+	case *Return:
+		d.open(depth, "return", dumpType(n.Type), loc)
+	case *If:
+		d.open(depth, "if", loc)
+		d.list("init", n.Init, depth+1)
+		d.node(n.Cond, depth+1)
+		d.node(n.Then, depth+1)
+
+		if n.Else != nil {
+			d.node(n.Else, depth+1)
+		}
+
+		d.close()
+
+		return
+	case *For:
+		d.open(depth, "for", loc)
+		d.list("init", n.Init, depth+1)
+
+		if n.Cond != nil {
+			d.node(n.Cond, depth+1)
+		}
+
+		d.list("post", n.Post, depth+1)
+		d.node(n.Body, depth+1)
+		d.close()
+
+		return
+	case *Literal:
+		d.open(depth, "lit", dumpLiteral(n), dumpType(n.Type()), loc)
+	case *Binop:
+		d.open(depth, "binop", string(n.Operation), dumpType(n.Type()), loc)
+	case *UnaryOp:
+		d.open(depth, "unop", string(n.Operation), dumpType(n.Type()), loc)
+	case *VariableRef:
+		d.open(depth, "ref", n.Ident, dumpType(n.Type()), loc)
+	case *Deref:
+		d.open(depth, "deref", dumpType(n.Type()), loc)
+	case *ArrayIndex:
+		d.open(depth, "index", dumpType(n.Type()), loc)
+	case *Assert:
+		d.open(depth, "assert", strconv.Quote(n.Message), loc)
+	default:
+		d.open(depth, fmt.Sprintf("%T", n), loc)
+	}
+
+	for _, child := range Children(n) {
+		d.node(child, depth+1)
+	}
+
+	d.close()
+}
+
+// list writes a labeled group of instructions, or nothing if the list is empty.
+func (d *dumper) list(label string, list []Instruction, depth int) {
+	if len(list) == 0 {
+		return
+	}
+
+	d.open(depth, label)
+
+	for _, instr := range list {
+		d.node(instr, depth+1)
+	}
+
+	d.close()
+}
+
+func (d *dumper) loc(loc lexer.Location) string {
+	switch {
+	case d.noLoc, loc == lexer.Location{}:
+		return ""
+	case loc.Filename != d.filename:
+		return "@" + loc.String()
+	default:
+		return fmt.Sprintf("@%d:%d", loc.Line, loc.Column)
+	}
+}
+
+func dumpType(t *Type) string {
+	if t == nil {
+		return ""
+	}
+
+	return ":" + t.String()
+}
+
+func dumpLiteral(l *Literal) string {
+	if l.Type() == nil {
+		return ""
+	}
+
+	switch l.Type().Kind {
+	case TypeInt:
+		return strconv.Itoa(l.IntValue)
+	case TypeBool:
+		return strconv.FormatBool(l.BoolValue)
+	case TypeString:
+		return strconv.Quote(l.StringValue)
+	default:
+		return ""
+	}
+}
+
+func dumpAttrs(a Attributes) string {
+	if len(a) == 0 {
+		return ""
+	}
+
+	parts := []string{"attrs"}
+
+	for _, key := range a.Keys() {
+		switch v := a[key].(type) {
+		case AttrBool:
+			if v {
+				parts = append(parts, string(key))
+			} else {
+				parts = append(parts, string(key)+"=false")
+			}
+		case AttrString:
+			parts = append(parts, string(key)+"="+strconv.Quote(string(v)))
+		case AttrInt:
+			parts = append(parts, fmt.Sprintf("%s=%d", key, v))
+		}
+	}
+
+	return "(" + strings.Join(parts, " ") + ")"
+}
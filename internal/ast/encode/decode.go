@@ -0,0 +1,499 @@
+package encode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Unmarshal parses the JSON encoding of a compilation unit, as produced by
+// Marshal. Calls that were resolved during type checking are linked back to the
+// function definitions of the unit.
+func Unmarshal(data []byte) (*ast.CompilationUnit, error) {
+	var n node
+
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+
+	d := &decoder{}
+
+	unit, err := d.decodeUnit(&n)
+	if err != nil {
+		return nil, err
+	}
+
+	funcs := make(map[string]*ast.FuncDef, len(unit.Funcs))
+	for _, fd := range unit.Funcs {
+		funcs[fd.Ident] = fd
+	}
+
+	for _, pending := range d.calls {
+		fd, ok := funcs[pending.ident]
+		if !ok {
+			return nil, fmt.Errorf("%s: call resolved to unknown function %q",
+				pending.call.Loc, pending.ident)
+		}
+
+		pending.call.FuncDef = fd
+	}
+
+	return unit, nil
+}
+
+type decoder struct {
+	calls []pendingCall
+}
+
+// pendingCall is a call whose FuncDef is linked once all functions are decoded.
+type pendingCall struct {
+	call  *ast.Call
+	ident string
+}
+
+func (d *decoder) decodeUnit(n *node) (*ast.CompilationUnit, error) {
+	if err := expectKind(n, kindUnit); err != nil {
+		return nil, err
+	}
+
+	unit := ast.NewCompilationUnit(decodeLocation(n.Loc))
+	unit.Ident = n.Ident
+	unit.Attributes = decodeAttributes(n.Attributes)
+	unit.Doc = decodeDoc(n.Doc)
+
+	for alias, pkg := range n.Imports {
+		unit.Imports[alias] = pkg
+	}
+
+	for _, tn := range n.Types {
+		if err := expectKind(tn, kindTypeDef); err != nil {
+			return nil, err
+		}
+
+		value, err := d.decodeExpr(tn.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		td := ast.NewTypeDef(tn.Ident, decodeType(tn.Type), value,
+			decodeAttributes(tn.Attributes), decodeLocation(tn.Loc))
+		td.Doc = decodeDoc(tn.Doc)
+
+		unit.Types = append(unit.Types, td)
+	}
+
+	for _, dn := range n.Data {
+		if err := expectKind(dn, kindDataDef); err != nil {
+			return nil, err
+		}
+
+		value, err := d.decodeExpr(dn.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		dd := ast.NewDataDef(dn.Ident, decodeType(dn.Type), value,
+			decodeAttributes(dn.Attributes), decodeLocation(dn.Loc))
+		dd.Doc = decodeDoc(dn.Doc)
+
+		unit.Data = append(unit.Data, dd)
+	}
+
+	for _, fn := range n.Funcs {
+		fd, err := d.decodeFuncDef(fn)
+		if err != nil {
+			return nil, err
+		}
+
+		unit.Funcs = append(unit.Funcs, fd)
+	}
+
+	return unit, nil
+}
+
+func (d *decoder) decodeFuncDef(n *node) (*ast.FuncDef, error) {
+	if err := expectKind(n, kindFuncDef); err != nil {
+		return nil, err
+	}
+
+	fd := ast.NewFuncDef(n.Ident, decodeAttributes(n.Attributes), decodeLocation(n.Loc))
+	fd.ReturnType = decodeType(n.ReturnType)
+	fd.Doc = decodeDoc(n.Doc)
+
+	for _, gn := range n.Generics {
+		if err := expectKind(gn, kindGeneric); err != nil {
+			return nil, err
+		}
+
+		var gp *ast.GenericParam
+
+		switch gn.Variant {
+		case "type":
+			gp = ast.NewGenericParamType(gn.Symbol)
+		case "value":
+			gp = ast.NewGenericParamValue(gn.Symbol, decodeType(gn.Type))
+		default:
+			return nil, fmt.Errorf("unknown generic parameter variant %q", gn.Variant)
+		}
+
+		gp.Constraint = gn.Constraint
+		gp.Loc = decodeLocation(gn.Loc)
+		fd.GenericParams = append(fd.GenericParams, gp)
+	}
+
+	for _, pn := range n.Params {
+		if err := expectKind(pn, kindParam); err != nil {
+			return nil, err
+		}
+
+		value, err := d.decodeExpr(pn.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		fd.Params = append(fd.Params, ast.NewFuncParam(pn.Ident, decodeType(pn.Type), value,
+			decodeAttributes(pn.Attributes), decodeLocation(pn.Loc)))
+	}
+
+	body, err := d.decodeBody(n.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	fd.Body = body
+
+	return fd, nil
+}
+
+func (d *decoder) decodeBody(n *node) (*ast.Body, error) {
+	if n == nil {
+		return nil, nil
+	}
+
+	if err := expectKind(n, kindBody); err != nil {
+		return nil, err
+	}
+
+	instructions, err := d.decodeList(n.Statements)
+	if err != nil {
+		return nil, err
+	}
+
+	return ast.NewBody(instructions, decodeLocation(n.Loc)), nil
+}
+
+func (d *decoder) decodeList(nodes []*node) ([]ast.Instruction, error) {
+	var list []ast.Instruction
+
+	for _, n := range nodes {
+		instr, err := d.decodeInstr(n)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, instr)
+	}
+
+	return list, nil
+}
+
+func (d *decoder) decodeInstr(n *node) (ast.Instruction, error) {
+	if n == nil {
+		return nil, fmt.Errorf("missing instruction")
+	}
+
+	loc := decodeLocation(n.Loc)
+
+	switch n.Kind {
+	case kindBody:
+		return d.decodeBody(n)
+	case kindCall:
+		return d.decodeCall(n)
+	case kindDeclare:
+		return ast.NewDeclare(n.Ident, decodeType(n.Type), loc), nil
+	case kindAssign:
+		lhs, err := d.decodeExpr(n.LHS)
+		if err != nil {
+			return nil, err
+		}
+
+		lvalue, ok := lhs.(ast.LValue)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s is not an lvalue", loc, n.LHS.Kind)
+		}
+
+		value, err := d.decodeExpr(n.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.NewAssign(lvalue, value, decodeType(n.Type), loc), nil
+	case kindReturn:
+		value, err := d.decodeExpr(n.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if value == nil {
+			return ast.NewReturn(loc, decodeType(n.Type)), nil
+		}
+
+		return ast.NewReturn(loc, decodeType(n.Type), value), nil
+	case kindIf:
+		init, err := d.decodeList(n.Init)
+		if err != nil {
+			return nil, err
+		}
+
+		cond, err := d.decodeExpr(n.Cond)
+		if err != nil {
+			return nil, err
+		}
+
+		then, err := d.decodeBody(n.Then)
+		if err != nil {
+			return nil, err
+		}
+
+		elseBody, err := d.decodeBody(n.Else)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.NewIf(loc, init, cond, then, elseBody), nil
+	case kindFor:
+		init, err := d.decodeList(n.Init)
+		if err != nil {
+			return nil, err
+		}
+
+		cond, err := d.decodeExpr(n.Cond)
+		if err != nil {
+			return nil, err
+		}
+
+		post, err := d.decodeList(n.Post)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := d.decodeBody(n.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.NewFor(loc, init, cond, post, body), nil
+	case kindAssert:
+		cond, err := d.decodeExpr(n.Cond)
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.NewAssert(cond, n.String, loc), nil
+	default:
+		return nil, fmt.Errorf("%s: unexpected instruction kind %q", loc, n.Kind)
+	}
+}
+
+// decodeExpr decodes an optional expression; a nil node decodes to nil.
+func (d *decoder) decodeExpr(n *node) (ast.Expression, error) {
+	if n == nil {
+		return nil, nil
+	}
+
+	loc := decodeLocation(n.Loc)
+
+	switch n.Kind {
+	case kindCall:
+		return d.decodeCall(n)
+	case kindLiteral:
+		return d.decodeLiteral(n)
+	case kindBinop:
+		lhs, err := d.decodeExpr(n.LHS)
+		if err != nil {
+			return nil, err
+		}
+
+		rhs, err := d.decodeExpr(n.RHS)
+		if err != nil {
+			return nil, err
+		}
+
+		binop := ast.NewBinop(ast.BinOpKind(n.Op), lhs, rhs, loc)
+		binop.SetType(decodeType(n.Type))
+
+		return binop, nil
+	case kindUnaryOp:
+		expr, err := d.decodeExpr(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+
+		unop := ast.NewUnaryOp(ast.UnaryOpKind(n.Op), expr, loc)
+		unop.SetType(decodeType(n.Type))
+
+		return unop, nil
+	case kindRef:
+		ref := ast.NewVariableRef(n.Ident, ast.TypeUnknown, loc)
+		ref.SetType(decodeType(n.Type))
+
+		return ref, nil
+	case kindDeref:
+		expr, err := d.decodeExpr(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+
+		deref := ast.NewDeref(expr, loc)
+		deref.SetType(decodeType(n.Type))
+
+		return deref, nil
+	case kindIndex:
+		array, err := d.decodeExpr(n.Array)
+		if err != nil {
+			return nil, err
+		}
+
+		index, err := d.decodeExpr(n.Index)
+		if err != nil {
+			return nil, err
+		}
+
+		idx := ast.NewArrayIndex(array, index, loc)
+		idx.SetType(decodeType(n.Type))
+
+		return idx, nil
+	default:
+		return nil, fmt.Errorf("%s: unexpected expression kind %q", loc, n.Kind)
+	}
+}
+
+func (d *decoder) decodeCall(n *node) (*ast.Call, error) {
+	var args []ast.Arg
+
+	for _, an := range n.Args {
+		if err := expectKind(an, kindArg); err != nil {
+			return nil, err
+		}
+
+		value, err := d.decodeExpr(an.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, ast.NewArg(an.Ident, value, decodeType(an.Type), decodeLocation(an.Loc)))
+	}
+
+	call := ast.NewCall(decodeLocation(n.Loc), n.Ident, args...)
+	call.SetType(decodeType(n.Type))
+
+	if n.Func != "" {
+		d.calls = append(d.calls, pendingCall{call: call, ident: n.Func})
+	}
+
+	return call, nil
+}
+
+func (d *decoder) decodeLiteral(n *node) (*ast.Literal, error) {
+	if err := expectKind(n, kindLiteral); err != nil {
+		return nil, err
+	}
+
+	lit := &ast.Literal{
+		IntValue:    n.Int,
+		StringValue: n.String,
+		BoolValue:   n.Bool,
+		Loc:         decodeLocation(n.Loc),
+	}
+	lit.SetType(decodeType(n.Type))
+
+	for _, en := range n.Elements {
+		elem, err := d.decodeLiteral(en)
+		if err != nil {
+			return nil, err
+		}
+
+		lit.ArrayValue = append(lit.ArrayValue, *elem)
+	}
+
+	return lit, nil
+}
+
+func decodeType(n *typeNode) *ast.Type {
+	if n == nil {
+		return nil
+	}
+
+	ty := ast.NewType(ast.TypeUnknown, decodeLocation(n.Loc))
+
+	for kind, name := range typeKindNames {
+		if name == n.Kind {
+			ty.Kind = kind
+		}
+	}
+
+	ty.Elem = decodeType(n.Elem)
+
+	if n.Size != nil {
+		if n.Size.Symbol != "" {
+			ty.Size = ast.NewSizeSymbol(n.Size.Symbol)
+		} else {
+			ty.Size = ast.NewSizeLiteral(n.Size.Value)
+		}
+	}
+
+	return ty
+}
+
+func decodeAttributes(m map[string]any) ast.Attributes {
+	attrs := ast.Attributes{}
+
+	for k, v := range m {
+		switch v := v.(type) {
+		case string:
+			attrs[ast.AttrKey(k)] = ast.AttrString(v)
+		case float64:
+			attrs[ast.AttrKey(k)] = ast.AttrInt(int(v))
+		case bool:
+			attrs[ast.AttrKey(k)] = ast.AttrBool(v)
+		}
+	}
+
+	return attrs
+}
+
+func decodeDoc(list []*comment) *ast.CommentGroup {
+	if len(list) == 0 {
+		return nil
+	}
+
+	doc := ast.NewCommentGroup()
+
+	for _, c := range list {
+		doc.List = append(doc.List, &ast.Comment{Text: c.Text, Loc: decodeLocation(c.Loc)})
+	}
+
+	return doc
+}
+
+func decodeLocation(loc *location) lexer.Location {
+	if loc == nil {
+		return lexer.Location{}
+	}
+
+	return lexer.Location{Filename: loc.File, Line: loc.Line, Column: loc.Column}
+}
+
+func expectKind(n *node, kind string) error {
+	if n == nil {
+		return fmt.Errorf("missing %s", kind)
+	}
+
+	if n.Kind != kind {
+		return fmt.Errorf("%s: expected %s, got %q", decodeLocation(n.Loc), kind, n.Kind)
+	}
+
+	return nil
+}
@@ -0,0 +1,478 @@
+// Package encode serializes the AST to and from JSON, so tools outside of the
+// compiler can consume the parser output.
+//
+// Every node is encoded as an object with a "kind" discriminator and a "loc".
+// Types are encoded as nested objects, attributes as plain JSON values.
+package encode
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Node kinds used as the "kind" discriminator.
+const (
+	kindUnit    = "unit"
+	kindTypeDef = "typedef"
+	kindDataDef = "datadef"
+	kindFuncDef = "funcdef"
+	kindGeneric = "generic"
+	kindParam   = "param"
+	kindBody    = "body"
+	kindCall    = "call"
+	kindArg     = "arg"
+	kindDeclare = "declare"
+	kindAssign  = "assign"
+	kindReturn  = "return"
+	kindIf      = "if"
+	kindFor     = "for"
+	kindAssert  = "assert"
+	kindLiteral = "literal"
+	kindBinop   = "binop"
+	kindUnaryOp = "unop"
+	kindRef     = "ref"
+	kindDeref   = "deref"
+	kindIndex   = "index"
+)
+
+// node is the JSON representation of every AST node. Only the fields relevant
+// to a given kind are populated.
+type node struct {
+	Kind       string            `json:"kind"`
+	Ident      string            `json:"ident,omitempty"`
+	Op         string            `json:"op,omitempty"`
+	Variant    string            `json:"variant,omitempty"` // generic parameter kind
+	Type       *typeNode         `json:"type,omitempty"`
+	ReturnType *typeNode         `json:"returnType,omitempty"`
+	Imports    map[string]string `json:"imports,omitempty"`
+	Types      []*node           `json:"types,omitempty"`
+	Data       []*node           `json:"data,omitempty"`
+	Funcs      []*node           `json:"funcs,omitempty"`
+	Generics   []*node           `json:"generics,omitempty"`
+	Params     []*node           `json:"params,omitempty"`
+	Func       string            `json:"func,omitempty"` // resolved callee of a call
+	Args       []*node           `json:"args,omitempty"`
+	Init       []*node           `json:"init,omitempty"`
+	Post       []*node           `json:"post,omitempty"`
+	Body       *node             `json:"body,omitempty"`
+	Then       *node             `json:"then,omitempty"`
+	Else       *node             `json:"else,omitempty"`
+	Cond       *node             `json:"cond,omitempty"`
+	LHS        *node             `json:"lhs,omitempty"`
+	RHS        *node             `json:"rhs,omitempty"`
+	Expr       *node             `json:"expr,omitempty"`
+	Array      *node             `json:"array,omitempty"`
+	Index      *node             `json:"index,omitempty"`
+	Value      *node             `json:"value,omitempty"`
+	Elements   []*node           `json:"elements,omitempty"`
+	Statements []*node           `json:"statements,omitempty"`
+	Int        int               `json:"int,omitempty"`
+	String     string            `json:"string,omitempty"`
+	Bool       bool              `json:"bool,omitempty"`
+	Symbol     string            `json:"symbol,omitempty"`
+	Constraint string            `json:"constraint,omitempty"` // of a generic type parameter
+	Attributes map[string]any    `json:"attributes,omitempty"`
+	Doc        []*comment        `json:"doc,omitempty"`
+	Loc        *location         `json:"loc,omitempty"`
+}
+
+type comment struct {
+	Text string    `json:"text"`
+	Loc  *location `json:"loc,omitempty"`
+}
+
+type typeNode struct {
+	Kind string    `json:"kind"`
+	Elem *typeNode `json:"elem,omitempty"`
+	Size *sizeNode `json:"size,omitempty"`
+	Loc  *location `json:"loc,omitempty"`
+}
+
+type sizeNode struct {
+	Value  int    `json:"value,omitempty"`
+	Symbol string `json:"symbol,omitempty"`
+}
+
+type location struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+var typeKindNames = map[ast.TypeKind]string{
+	ast.TypeUnknown: "unknown",
+	ast.TypeInt:     "int",
+	ast.TypeBool:    "bool",
+	ast.TypeString:  "string",
+	ast.TypeVoid:    "void",
+	ast.TypePointer: "pointer",
+	ast.TypeArray:   "array",
+	ast.TypeAny:     "any",
+	ast.TypeVararg:  "vararg",
+}
+
+// Marshal returns the JSON encoding of the compilation unit.
+func Marshal(unit *ast.CompilationUnit) ([]byte, error) {
+	return json.Marshal(encodeUnit(unit))
+}
+
+// MarshalIndent is like Marshal but indents the output.
+func MarshalIndent(unit *ast.CompilationUnit, prefix, indent string) ([]byte, error) {
+	return json.MarshalIndent(encodeUnit(unit), prefix, indent)
+}
+
+func encodeUnit(unit *ast.CompilationUnit) *node {
+	e := &encoder{}
+	unit.Accept(e)
+
+	return e.last
+}
+
+// encoder implements ast.Visitor and builds the JSON representation.
+type encoder struct {
+	last *node
+}
+
+func (e *encoder) VisitCompilationUnit(cu *ast.CompilationUnit) {
+	n := &node{
+		Kind:       kindUnit,
+		Ident:      cu.Ident,
+		Imports:    cu.Imports,
+		Attributes: encodeAttributes(cu.Attributes),
+		Doc:        encodeDoc(cu.Doc),
+		Loc:        encodeLocation(cu.Loc),
+	}
+
+	for _, td := range cu.Types {
+		n.Types = append(n.Types, e.encode(td))
+	}
+
+	for _, dd := range cu.Data {
+		n.Data = append(n.Data, e.encode(dd))
+	}
+
+	for _, fd := range cu.Funcs {
+		n.Funcs = append(n.Funcs, e.encode(fd))
+	}
+
+	e.last = n
+}
+
+func (e *encoder) VisitTypeDef(td *ast.TypeDef) {
+	e.last = &node{
+		Kind:       kindTypeDef,
+		Ident:      td.Ident,
+		Type:       encodeType(td.Type),
+		Value:      e.encode(td.Value),
+		Attributes: encodeAttributes(td.Attributes),
+		Doc:        encodeDoc(td.Doc),
+		Loc:        encodeLocation(td.Loc),
+	}
+}
+
+func (e *encoder) VisitDataDef(dd *ast.DataDef) {
+	e.last = &node{
+		Kind:       kindDataDef,
+		Ident:      dd.Ident,
+		Type:       encodeType(dd.Type),
+		Value:      e.encode(dd.Value),
+		Attributes: encodeAttributes(dd.Attributes),
+		Doc:        encodeDoc(dd.Doc),
+		Loc:        encodeLocation(dd.Loc),
+	}
+}
+
+func (e *encoder) VisitFuncDef(fd *ast.FuncDef) {
+	n := &node{
+		Kind:       kindFuncDef,
+		Ident:      fd.Ident,
+		ReturnType: encodeType(fd.ReturnType),
+		Body:       e.encode(fd.Body),
+		Attributes: encodeAttributes(fd.Attributes),
+		Doc:        encodeDoc(fd.Doc),
+		Loc:        encodeLocation(fd.Loc),
+	}
+
+	for _, gp := range fd.GenericParams {
+		n.Generics = append(n.Generics, e.encode(gp))
+	}
+
+	for _, param := range fd.Params {
+		n.Params = append(n.Params, e.encode(param))
+	}
+
+	e.last = n
+}
+
+func (e *encoder) VisitGenericParam(gp *ast.GenericParam) {
+	n := &node{
+		Kind:       kindGeneric,
+		Symbol:     gp.Symbol,
+		Type:       encodeType(gp.Type),
+		Constraint: gp.Constraint,
+		Loc:        encodeLocation(gp.Loc),
+	}
+
+	if gp.Kind == ast.GenericValue {
+		n.Variant = "value"
+	} else {
+		n.Variant = "type"
+	}
+
+	e.last = n
+}
+
+func (e *encoder) VisitFuncParam(fp *ast.FuncParam) {
+	e.last = &node{
+		Kind:       kindParam,
+		Ident:      fp.Ident,
+		Type:       encodeType(fp.Type),
+		Value:      e.encode(fp.Value),
+		Attributes: encodeAttributes(fp.Attributes),
+		Loc:        encodeLocation(fp.Loc),
+	}
+}
+
+func (e *encoder) VisitBody(b *ast.Body) {
+	e.last = &node{
+		Kind:       kindBody,
+		Statements: e.encodeList(b.Instructions),
+		Loc:        encodeLocation(b.Loc),
+	}
+}
+
+func (e *encoder) VisitCall(c *ast.Call) {
+	n := &node{
+		Kind:  kindCall,
+		Ident: c.Ident,
+		Type:  encodeType(c.Type()),
+		Loc:   encodeLocation(c.Loc),
+	}
+
+	if c.FuncDef != nil {
+		n.Func = c.FuncDef.Ident
+	}
+
+	for _, arg := range c.Args {
+		n.Args = append(n.Args, &node{
+			Kind:  kindArg,
+			Ident: arg.Ident,
+			Value: e.encode(arg.Value),
+			Type:  encodeType(arg.Type),
+			Loc:   encodeLocation(arg.Loc),
+		})
+	}
+
+	e.last = n
+}
+
+func (e *encoder) VisitDeclare(d *ast.Declare) {
+	e.last = &node{
+		Kind:  kindDeclare,
+		Ident: d.Ident,
+		Type:  encodeType(d.Type),
+		Loc:   encodeLocation(d.Loc),
+	}
+}
+
+func (e *encoder) VisitAssign(a *ast.Assign) {
+	e.last = &node{
+		Kind:  kindAssign,
+		LHS:   e.encode(a.LHS),
+		Value: e.encode(a.Value),
+		Type:  encodeType(a.Type),
+		Loc:   encodeLocation(a.Loc),
+	}
+}
+
+func (e *encoder) VisitReturn(r *ast.Return) {
+	e.last = &node{
+		Kind:  kindReturn,
+		Value: e.encode(r.Value),
+		Type:  encodeType(r.Type),
+		Loc:   encodeLocation(r.Loc),
+	}
+}
+
+func (e *encoder) VisitLiteral(l *ast.Literal) {
+	n := &node{
+		Kind:   kindLiteral,
+		Type:   encodeType(l.Type()),
+		Int:    l.IntValue,
+		String: l.StringValue,
+		Bool:   l.BoolValue,
+		Loc:    encodeLocation(l.Loc),
+	}
+
+	for i := range l.ArrayValue {
+		n.Elements = append(n.Elements, e.encode(&l.ArrayValue[i]))
+	}
+
+	e.last = n
+}
+
+func (e *encoder) VisitBinop(b *ast.Binop) {
+	e.last = &node{
+		Kind: kindBinop,
+		Op:   string(b.Operation),
+		LHS:  e.encode(b.Lhs),
+		RHS:  e.encode(b.Rhs),
+		Type: encodeType(b.Type()),
+		Loc:  encodeLocation(b.Loc),
+	}
+}
+
+func (e *encoder) VisitUnaryOp(u *ast.UnaryOp) {
+	e.last = &node{
+		Kind: kindUnaryOp,
+		Op:   string(u.Operation),
+		Expr: e.encode(u.Expr),
+		Type: encodeType(u.Type()),
+		Loc:  encodeLocation(u.Loc),
+	}
+}
+
+func (e *encoder) VisitVariableRef(v *ast.VariableRef) {
+	e.last = &node{
+		Kind:  kindRef,
+		Ident: v.Ident,
+		Type:  encodeType(v.Type()),
+		Loc:   encodeLocation(v.Loc),
+	}
+}
+
+func (e *encoder) VisitDeref(d *ast.Deref) {
+	e.last = &node{
+		Kind: kindDeref,
+		Expr: e.encode(d.Expr),
+		Type: encodeType(d.Type()),
+		Loc:  encodeLocation(d.Loc),
+	}
+}
+
+func (e *encoder) VisitArrayIndex(a *ast.ArrayIndex) {
+	e.last = &node{
+		Kind:  kindIndex,
+		Array: e.encode(a.Array),
+		Index: e.encode(a.Index),
+		Type:  encodeType(a.Type()),
+		Loc:   encodeLocation(a.Loc),
+	}
+}
+
+func (e *encoder) VisitIf(i *ast.If) {
+	e.last = &node{
+		Kind: kindIf,
+		Init: e.encodeList(i.Init),
+		Cond: e.encode(i.Cond),
+		Then: e.encode(i.Then),
+		Else: e.encode(i.Else),
+		Loc:  encodeLocation(i.Loc),
+	}
+}
+
+func (e *encoder) VisitFor(f *ast.For) {
+	e.last = &node{
+		Kind: kindFor,
+		Init: e.encodeList(f.Init),
+		Cond: e.encode(f.Cond),
+		Post: e.encodeList(f.Post),
+		Body: e.encode(f.Body),
+		Loc:  encodeLocation(f.Loc),
+	}
+}
+
+func (e *encoder) VisitAssert(a *ast.Assert) {
+	e.last = &node{
+		Kind:   kindAssert,
+		Cond:   e.encode(a.Cond),
+		String: a.Message,
+		Loc:    encodeLocation(a.Loc),
+	}
+}
+
+// encode encodes an optional node, returning nil for nil (or typed nil) nodes.
+func (e *encoder) encode(n interface{ Accept(ast.Visitor) }) *node {
+	if n == nil || reflect.ValueOf(n).IsNil() {
+		return nil
+	}
+
+	e.last = nil
+	n.Accept(e)
+
+	return e.last
+}
+
+func (e *encoder) encodeList(list []ast.Instruction) []*node {
+	var nodes []*node
+
+	for _, instr := range list {
+		nodes = append(nodes, e.encode(instr))
+	}
+
+	return nodes
+}
+
+func encodeType(ty *ast.Type) *typeNode {
+	if ty == nil {
+		return nil
+	}
+
+	n := &typeNode{
+		Kind: typeKindNames[ty.Kind],
+		Elem: encodeType(ty.Elem),
+		Loc:  encodeLocation(ty.Loc),
+	}
+
+	if ty.Size != nil {
+		n.Size = &sizeNode{Value: ty.Size.Value, Symbol: ty.Size.Symbol}
+	}
+
+	return n
+}
+
+func encodeAttributes(attrs ast.Attributes) map[string]any {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	m := make(map[string]any, len(attrs))
+
+	for k, v := range attrs {
+		switch v := v.(type) {
+		case ast.AttrString:
+			m[string(k)] = string(v)
+		case ast.AttrInt:
+			m[string(k)] = int(v)
+		case ast.AttrBool:
+			m[string(k)] = bool(v)
+		}
+	}
+
+	return m
+}
+
+func encodeDoc(doc *ast.CommentGroup) []*comment {
+	if doc == nil {
+		return nil
+	}
+
+	list := make([]*comment, len(doc.List))
+
+	for i, c := range doc.List {
+		list[i] = &comment{Text: c.Text, Loc: encodeLocation(c.Loc)}
+	}
+
+	return list
+}
+
+func encodeLocation(loc lexer.Location) *location {
+	if loc == (lexer.Location{}) {
+		return nil
+	}
+
+	return &location{File: loc.Filename, Line: loc.Line, Column: loc.Column}
+}
@@ -0,0 +1,87 @@
+package encode
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/corani/cubit/internal/typecheck"
+	"github.com/stretchr/testify/require"
+)
+
+const source = `package main
+
+// print wraps printf.
+@(extern, link_name="printf")
+print :: func(msg: string, args: ..any)
+
+@(export)
+main :: func() -> int {
+    row := [4]int{}
+    p: ^int
+    for i := 0; i < 4; i = i + 1 {
+        if i == 2 || i == -1 {
+            row[i] = (i << 1) & 3
+        } else {
+            p^ = 1
+        }
+    }
+    print("%d\n", row[2])
+    return 0
+}
+`
+
+func parse(t *testing.T, src string) *ast.CompilationUnit {
+	t.Helper()
+
+	return testutil.Parse(t, "test.in", src)
+}
+
+func TestEncode_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	unit := parse(t, source)
+	require.NoError(t, typecheck.Check(unit))
+
+	data, err := Marshal(unit)
+	require.NoError(t, err)
+
+	decoded, err := Unmarshal(data)
+	require.NoError(t, err)
+
+	again, err := Marshal(decoded)
+	require.NoError(t, err)
+
+	require.Equal(t, string(data), string(again))
+
+	// The resolved callee is linked back to the decoded function definition.
+	main := decoded.Funcs[1]
+	call, ok := main.Body.Instructions[len(main.Body.Instructions)-2].(*ast.Call)
+	require.True(t, ok)
+	require.Same(t, decoded.Funcs[0], call.FuncDef)
+}
+
+func TestEncode_Errors(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name  string
+		input string
+	}{
+		{name: "invalid json", input: `{`},
+		{name: "wrong root", input: `{"kind": "body"}`},
+		{name: "unknown instruction", input: `{"kind": "unit", "funcs": [
+			{"kind": "funcdef", "ident": "f", "body": {"kind": "body", "statements": [{"kind": "literal"}]}}]}`},
+		{name: "unknown callee", input: `{"kind": "unit", "funcs": [
+			{"kind": "funcdef", "ident": "f", "body": {"kind": "body", "statements": [{"kind": "call", "func": "g"}]}}]}`},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Unmarshal([]byte(tc.input))
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package ast
+
+// NodeID identifies a node within an Index. IDs are assigned in depth-first
+// order starting at 1, so numbering the same tree twice yields the same IDs.
+// The zero NodeID identifies no node.
+type NodeID int
+
+// Index numbers the nodes of a tree, so passes can attach information to nodes
+// in side tables instead of adding fields to the AST structs, which are shared
+// between passes.
+type Index struct {
+	ids   map[Node]NodeID
+	nodes []Node
+}
+
+// NewIndex assigns an ID to every node of the tree rooted at root.
+func NewIndex(root Node) *Index {
+	ix := &Index{
+		ids:   make(map[Node]NodeID),
+		nodes: []Node{nil}, // NodeID 0 is reserved
+	}
+
+	Walk(root, func(n Node) bool {
+		ix.ids[n] = NodeID(len(ix.nodes))
+		ix.nodes = append(ix.nodes, n)
+
+		return true
+	})
+
+	return ix
+}
+
+// ID returns the ID of n, or 0 if n is not part of the indexed tree.
+func (ix *Index) ID(n Node) NodeID {
+	return ix.ids[n]
+}
+
+// Node returns the node with the given ID, or nil if there is none.
+func (ix *Index) Node(id NodeID) Node {
+	if id <= 0 || int(id) >= len(ix.nodes) {
+		return nil
+	}
+
+	return ix.nodes[id]
+}
+
+// Len returns the number of indexed nodes.
+func (ix *Index) Len() int {
+	return len(ix.nodes) - 1
+}
+
+// Table is a side table that annotates the nodes of an Index with values of
+// type T, e.g. the reachability or constant value of a node:
+//
+//	reachable := make(ast.Table[bool])
+//	reachable[ix.ID(instr)] = true
+type Table[T any] map[NodeID]T
@@ -0,0 +1,44 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	t.Parallel()
+
+	fd := newTestFunc()
+	ix := NewIndex(fd)
+
+	require.Equal(t, 15, ix.Len())
+	require.Equal(t, NodeID(1), ix.ID(fd))
+	require.Equal(t, NodeID(2), ix.ID(fd.Params[0]))
+
+	for id := NodeID(1); int(id) <= ix.Len(); id++ {
+		require.Equal(t, id, ix.ID(ix.Node(id)))
+	}
+
+	require.Nil(t, ix.Node(0))
+	require.Nil(t, ix.Node(NodeID(ix.Len()+1)))
+	require.Equal(t, NodeID(0), ix.ID(NewIntLiteral(1, fd.Loc)))
+
+	// Numbering is stable for the same tree, and for a copy of it.
+	again := NewIndex(fd.Clone())
+	for id := NodeID(1); int(id) <= ix.Len(); id++ {
+		require.Equal(t, nodeName(ix.Node(id)), nodeName(again.Node(id)))
+	}
+
+	consts := make(Table[int])
+
+	Walk(fd, func(n Node) bool {
+		if lit, ok := n.(*Literal); ok {
+			consts[ix.ID(lit)] = lit.IntValue
+		}
+
+		return true
+	})
+
+	require.Equal(t, Table[int]{7: 2, 15: 1}, consts)
+}
@@ -0,0 +1,54 @@
+package ast
+
+// Parents maps every node in a tree to its parent. Nodes don't keep a pointer to
+// their parent, because passes that rewrite the tree would have to keep those
+// pointers up to date; instead, analyses that need the enclosing function or
+// loop of a node build a Parents for the tree they're working on.
+type Parents map[Node]Node
+
+// NewParents records the parent of every node below root. The root itself has
+// no parent.
+func NewParents(root Node) Parents {
+	parents := make(Parents)
+
+	WalkPath(root, func(n Node, ancestors []Node) bool {
+		if len(ancestors) > 0 {
+			parents[n] = ancestors[len(ancestors)-1]
+		}
+
+		return true
+	})
+
+	return parents
+}
+
+// Parent returns the parent of n, or nil if n is the root or not in the tree.
+func (p Parents) Parent(n Node) Node {
+	return p[n]
+}
+
+// Path returns n followed by its ancestors, ending with the root. The order
+// matches PathEnclosing.
+func (p Parents) Path(n Node) []Node {
+	var path []Node
+
+	for ; n != nil; n = p[n] {
+		path = append(path, n)
+	}
+
+	return path
+}
+
+// Enclosing returns the innermost ancestor of n of type T, e.g. the function or
+// loop that contains a statement.
+func Enclosing[T Node](p Parents, n Node) (T, bool) {
+	for n = p[n]; n != nil; n = p[n] {
+		if t, ok := n.(T); ok {
+			return t, true
+		}
+	}
+
+	var zero T
+
+	return zero, false
+}
@@ -0,0 +1,144 @@
+package ast
+
+import (
+	"slices"
+	"strconv"
+
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// PathEnclosing returns the innermost node of root that contains loc, followed
+// by its ancestors up to root. It returns nil if loc is outside of root, or in a
+// different file.
+//
+// Nodes only record where they start, so the extent of a node is derived from
+// its children: it ends after the last token of its last descendant. A position
+// on a closing brace therefore resolves to the enclosing declaration or
+// statement rather than the block.
+func PathEnclosing(root Node, loc lexer.Location) []Node {
+	if isNilNode(root) {
+		return nil
+	}
+
+	spans := make(map[Node]span)
+	computeSpan(root, spans)
+
+	pos := position{loc.Line, loc.Column}
+
+	if !sameFile(root.Location(), loc) || !spans[root].contains(pos) {
+		return nil
+	}
+
+	var path []Node
+
+	for n := root; n != nil; {
+		path = append(path, n)
+
+		var next Node
+
+		for _, child := range Children(n) {
+			if !sameFile(child.Location(), loc) {
+				continue
+			}
+
+			if spans[child].contains(pos) {
+				next = child
+			}
+		}
+
+		n = next
+	}
+
+	slices.Reverse(path)
+
+	return path
+}
+
+type position struct {
+	line, column int
+}
+
+func (p position) before(other position) bool {
+	return p.line < other.line || (p.line == other.line && p.column < other.column)
+}
+
+// span is the half-open range of positions [start, end) covered by a node.
+type span struct {
+	start, end position
+}
+
+func (s span) contains(p position) bool {
+	return !p.before(s.start) && p.before(s.end)
+}
+
+func sameFile(a, b lexer.Location) bool {
+	return b.Filename == "" || a.Filename == b.Filename
+}
+
+// computeSpan records the span of n and all of its descendants in spans.
+func computeSpan(n Node, spans map[Node]span) span {
+	loc := n.Location()
+	start := position{loc.Line, loc.Column}
+	s := span{start: start, end: position{loc.Line, loc.Column + tokenWidth(n)}}
+
+	for _, child := range Children(n) {
+		cs := computeSpan(child, spans)
+
+		if child.Location().Filename != loc.Filename || cs.start.line == 0 {
+			continue
+		}
+
+		if s.start.line == 0 || cs.start.before(s.start) {
+			s.start = cs.start
+		}
+
+		if s.end.before(cs.end) {
+			s.end = cs.end
+		}
+	}
+
+	spans[n] = s
+
+	return s
+}
+
+// tokenWidth estimates the width of the token a node starts with.
+func tokenWidth(n Node) int {
+	switch n := n.(type) {
+	case *VariableRef:
+		return len(n.Ident)
+	case *Call:
+		return len(n.Ident)
+	case *Declare:
+		return len(n.Ident)
+	case *FuncParam:
+		return len(n.Ident)
+	case *FuncDef:
+		return len(n.Ident)
+	case *Return:
+		return len("return")
+	case *If:
+		return len("if")
+	case *For:
+		return len("for")
+	case *Assert:
+		return len("#assert")
+	case *Literal:
+		if n.Type() == nil {
+			return 1
+		}
+
+		switch n.Type().Kind {
+		case TypeInt:
+			return len(strconv.Itoa(n.IntValue))
+		case TypeBool:
+			return len(strconv.FormatBool(n.BoolValue))
+		case TypeString:
+			return len(n.StringValue) + 2
+		default:
+			return 1
+		}
+	default:
+		return 1
+	}
+}
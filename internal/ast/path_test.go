@@ -0,0 +1,109 @@
+package ast_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathEnclosing(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+f :: func(n: int) -> int {
+    if n < 2 {
+        return n
+    }
+    return f(n - 1)
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+
+	// Describe each node by the first line of its dump.
+	describe := func(path []ast.Node) []string {
+		var list []string
+
+		for _, n := range path {
+			line, _, _ := strings.Cut(ast.SDump(n), "\n")
+			list = append(list, strings.TrimRight(strings.TrimPrefix(line, "("), ")"))
+		}
+
+		return list
+	}
+
+	tt := []struct {
+		name     string
+		line     int
+		column   int
+		expected []string
+	}{
+		{
+			name: "param", line: 3, column: 11,
+			expected: []string{
+				"param n :int @test.in:3:11",
+				"func f :int @test.in:3:1",
+			},
+		},
+		{
+			name: "inner ref", line: 5, column: 16,
+			expected: []string{
+				"ref n :unknown @test.in:5:16",
+				"return :int @test.in:5:9",
+				"body @test.in:4:14",
+				"if @test.in:4:5",
+				"body @test.in:3:26",
+				"func f :int @test.in:3:1",
+			},
+		},
+		{
+			name: "call argument", line: 7, column: 18,
+			expected: []string{
+				"lit 1 :int @test.in:7:18",
+				"binop - :unknown @test.in:7:14",
+				"call f @test.in:7:12",
+				"return :int @test.in:7:5",
+				"body @test.in:3:26",
+				"func f :int @test.in:3:1",
+			},
+		},
+		{
+			name: "operator", line: 7, column: 16,
+			expected: []string{
+				"binop - :unknown @test.in:7:14",
+				"call f @test.in:7:12",
+				"return :int @test.in:7:5",
+				"body @test.in:3:26",
+				"func f :int @test.in:3:1",
+			},
+		},
+		{
+			name: "call ident", line: 7, column: 12,
+			expected: []string{
+				"call f @test.in:7:12",
+				"return :int @test.in:7:5",
+				"body @test.in:3:26",
+				"func f :int @test.in:3:1",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := ast.PathEnclosing(unit, lexer.Location{Filename: "test.in", Line: tc.line, Column: tc.column})
+			require.NotEmpty(t, path)
+			require.Same(t, unit, path[len(path)-1])
+			require.Equal(t, tc.expected, describe(path[:len(path)-1]))
+		})
+	}
+
+	require.Nil(t, ast.PathEnclosing(unit, lexer.Location{Filename: "other.in", Line: 5, Column: 16}))
+	require.Nil(t, ast.PathEnclosing(unit, lexer.Location{Filename: "test.in", Line: 20, Column: 1}))
+}
@@ -0,0 +1,667 @@
+// Package printer renders an AST back to canonical cubit source.
+package printer
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// indentation used for nested blocks.
+const indentation = "    "
+
+// Fprint writes the canonical source representation of the compilation unit to w.
+func Fprint(w io.Writer, unit *ast.CompilationUnit) error {
+	_, err := io.WriteString(w, Sprint(unit))
+
+	return err
+}
+
+// Sprint returns the canonical source representation of the compilation unit.
+func Sprint(unit *ast.CompilationUnit) string {
+	p := newPrinter()
+	unit.Accept(p)
+
+	return p.sb.String()
+}
+
+// SprintComments returns the canonical source representation of the
+// compilation unit, like Sprint, but keeps the comments of its source that
+// aren't documentation, and single blank lines between statements. A comment
+// is written above the statement or declaration it preceded, or at the end of
+// the line of the statement it followed. The comments are those of the lexer,
+// in source order.
+func SprintComments(unit *ast.CompilationUnit, comments []lexer.Comment) string {
+	p := newPrinter()
+	p.source, p.comments = true, comments
+	unit.Accept(p)
+
+	return p.sb.String()
+}
+
+// SprintSignature returns the declaration of a function, with its attributes
+// but without its documentation and body, e.g. to show it in an editor.
+func SprintSignature(fd *ast.FuncDef) string {
+	sig := *fd
+	sig.Doc, sig.Body = nil, nil
+
+	p := newPrinter()
+	sig.Accept(p)
+
+	return strings.TrimSuffix(p.sb.String(), "\n")
+}
+
+// SprintExpr returns the canonical source representation of an expression, e.g.
+// to suggest a fix in a diagnostic.
+func SprintExpr(expr ast.Expression) string {
+	p := newPrinter()
+	expr.Accept(p)
+
+	return p.sb.String()
+}
+
+// printer implements ast.Visitor and writes formatted source.
+type printer struct {
+	sb     strings.Builder
+	indent int
+
+	// With source, comments are the comments that are still to be written,
+	// and line is the last line of the source that was, or 0 at the start
+	// of a block.
+	source   bool
+	comments []lexer.Comment
+	line     int
+}
+
+func newPrinter() *printer {
+	return &printer{}
+}
+
+func (p *printer) VisitCompilationUnit(cu *ast.CompilationUnit) {
+	p.writeFloating(declStart(cu.Doc, cu.Loc))
+	p.writeDoc(cu.Doc)
+	p.writeAttributes(cu.Attributes, "\n")
+	p.writef("package %s\n", cu.Ident)
+
+	if len(cu.Imports) > 0 {
+		p.write("\n")
+
+		aliases := make([]string, 0, len(cu.Imports))
+		for alias := range cu.Imports {
+			aliases = append(aliases, alias)
+		}
+
+		slices.Sort(aliases)
+
+		for _, alias := range aliases {
+			pkg := cu.Imports[alias]
+
+			if alias == pkg {
+				p.writef("import %q\n", pkg)
+			} else {
+				p.writef("import %q as %s\n", pkg, alias)
+			}
+		}
+	}
+
+	for _, td := range cu.Types {
+		p.write("\n")
+		p.writeFloating(declStart(td.Doc, td.Loc))
+		td.Accept(p)
+	}
+
+	for _, dd := range cu.Data {
+		p.write("\n")
+		p.writeFloating(declStart(dd.Doc, dd.Loc))
+		dd.Accept(p)
+	}
+
+	for _, fd := range cu.Funcs {
+		p.write("\n")
+		p.writeFloating(declStart(fd.Doc, fd.Loc))
+		fd.Accept(p)
+	}
+
+	// The comments at the end of the file.
+	if len(p.comments) > 0 {
+		p.write("\n")
+
+		p.line = 0
+		p.writeComments(lexer.Location{Line: math.MaxInt})
+	}
+}
+
+func (p *printer) VisitTypeDef(td *ast.TypeDef) {
+	p.writeDoc(td.Doc)
+	p.writeAttributes(td.Attributes, "\n")
+	p.writef("%s :: %s", td.Ident, td.Type)
+
+	if td.Value != nil {
+		p.write(" = ")
+		td.Value.Accept(p)
+	}
+
+	p.write("\n")
+}
+
+func (p *printer) VisitDataDef(dd *ast.DataDef) {
+	p.writeDoc(dd.Doc)
+	p.writeAttributes(dd.Attributes, "\n")
+	p.writeBinding(dd.Ident, dd.Type, dd.Value)
+	p.write("\n")
+}
+
+func (p *printer) VisitFuncDef(fd *ast.FuncDef) {
+	p.writeDoc(fd.Doc)
+	p.writeAttributes(fd.Attributes, "\n")
+	p.writef("%s :: func(", fd.Ident)
+
+	for i, param := range fd.Params {
+		if i > 0 {
+			p.write(", ")
+		}
+
+		param.Accept(p)
+	}
+
+	p.write(")")
+
+	if fd.ReturnType != nil && fd.ReturnType.Kind != ast.TypeVoid {
+		p.writef(" -> %s", fd.ReturnType)
+	}
+
+	if fd.Body != nil {
+		instructions := fd.Body.Instructions
+
+		// Drop the implicit return the parser adds to void functions.
+		if n := len(instructions); n > 0 {
+			if ret, ok := instructions[n-1].(*ast.Return); ok && ret.Value == nil {
+				instructions = instructions[:n-1]
+			}
+		}
+
+		p.write(" {\n")
+		p.writeBlock(instructions, fd.Body.End)
+		p.write("}")
+	}
+
+	p.write("\n")
+}
+
+func (p *printer) VisitGenericParam(gp *ast.GenericParam) {
+	switch gp.Kind {
+	case ast.GenericType:
+		p.writef("$%s", gp.Symbol)
+
+		if gp.Constraint != "" {
+			p.writef(": %s", gp.Constraint)
+		}
+	case ast.GenericValue:
+		p.writef("$%s/%s", gp.Symbol, gp.Type)
+	}
+}
+
+func (p *printer) VisitFuncParam(fp *ast.FuncParam) {
+	p.writeAttributes(fp.Attributes, " ")
+	p.writeBinding(fp.Ident, fp.Type, fp.Value)
+}
+
+func (p *printer) VisitBody(b *ast.Body) {
+	p.write("{\n")
+	p.writeBlock(b.Instructions, b.End)
+	p.writeIndent()
+	p.write("}")
+}
+
+func (p *printer) VisitCall(c *ast.Call) {
+	p.writef("%s(", c.Ident)
+
+	for i, arg := range c.Args {
+		if i > 0 {
+			p.write(", ")
+		}
+
+		if arg.Ident != "" {
+			p.writef("%s=", arg.Ident)
+		}
+
+		arg.Value.Accept(p)
+	}
+
+	p.write(")")
+}
+
+func (p *printer) VisitDeclare(d *ast.Declare) {
+	p.writeBinding(d.Ident, d.Type, nil)
+}
+
+func (p *printer) VisitAssign(a *ast.Assign) {
+	a.LHS.Accept(p)
+	p.write(" = ")
+	a.Value.Accept(p)
+}
+
+func (p *printer) VisitReturn(r *ast.Return) {
+	p.write("return")
+
+	if r.Value != nil {
+		p.write(" ")
+		r.Value.Accept(p)
+	}
+}
+
+func (p *printer) VisitLiteral(l *ast.Literal) {
+	switch l.Type().Kind {
+	case ast.TypeInt:
+		p.writef("%d", l.IntValue)
+	case ast.TypeBool:
+		p.writef("%t", l.BoolValue)
+	case ast.TypeString:
+		// String values keep their escape sequences from the source.
+		p.writef("\"%s\"", l.StringValue)
+	case ast.TypeArray:
+		p.writef("%s{", l.Type())
+
+		for i := range l.ArrayValue {
+			if i > 0 {
+				p.write(", ")
+			}
+
+			l.ArrayValue[i].Accept(p)
+		}
+
+		p.write("}")
+	default:
+		p.write("<unknown>")
+	}
+}
+
+func (p *printer) VisitBinop(b *ast.Binop) {
+	prec := precedence[b.Operation]
+
+	p.writeOperand(b.Lhs, prec, false)
+	p.writef(" %s ", b.Operation)
+	p.writeOperand(b.Rhs, prec, true)
+}
+
+func (p *printer) VisitUnaryOp(u *ast.UnaryOp) {
+	p.write(string(u.Operation))
+
+	if isSimple(u.Expr) {
+		u.Expr.Accept(p)
+	} else {
+		p.writeParens(u.Expr)
+	}
+}
+
+func (p *printer) VisitVariableRef(v *ast.VariableRef) {
+	p.write(v.Ident)
+}
+
+func (p *printer) VisitDeref(d *ast.Deref) {
+	if _, ok := d.Expr.(*ast.VariableRef); ok {
+		d.Expr.Accept(p)
+	} else {
+		p.writeParens(d.Expr)
+	}
+
+	p.write("^")
+}
+
+func (p *printer) VisitArrayIndex(a *ast.ArrayIndex) {
+	a.Array.Accept(p)
+	p.write("[")
+	a.Index.Accept(p)
+	p.write("]")
+}
+
+func (p *printer) VisitIf(i *ast.If) {
+	p.write("if ")
+	p.writeHeader(i.Init)
+	i.Cond.Accept(p)
+	p.write(" ")
+	i.Then.Accept(p)
+
+	if i.Else == nil {
+		return
+	}
+
+	p.write(" else ")
+
+	// An `else if` is represented as an else body holding a single If.
+	if len(i.Else.Instructions) == 1 {
+		if elseIf, ok := i.Else.Instructions[0].(*ast.If); ok {
+			elseIf.Accept(p)
+
+			return
+		}
+	}
+
+	i.Else.Accept(p)
+}
+
+func (p *printer) VisitFor(f *ast.For) {
+	p.write("for ")
+	p.writeHeader(f.Init)
+	f.Cond.Accept(p)
+
+	if len(f.Post) > 0 {
+		p.write("; ")
+		p.writeStatements(f.Post, "; ")
+	}
+
+	p.write(" ")
+	f.Body.Accept(p)
+}
+
+func (p *printer) VisitAssert(a *ast.Assert) {
+	p.write("#assert(")
+	a.Cond.Accept(p)
+
+	if a.Message != "" {
+		p.writef(", \"%s\"", a.Message)
+	}
+
+	p.write(")")
+}
+
+// precedence mirrors the operator precedence used by the parser.
+var precedence = map[ast.BinOpKind]int{
+	ast.BinOpLogOr:  3,
+	ast.BinOpLogAnd: 4,
+	ast.BinOpEq:     5,
+	ast.BinOpNe:     5,
+	ast.BinOpOr:     6,
+	ast.BinOpLt:     7,
+	ast.BinOpLe:     7,
+	ast.BinOpGt:     7,
+	ast.BinOpGe:     7,
+	ast.BinOpAnd:    8,
+	ast.BinOpAdd:    10,
+	ast.BinOpSub:    10,
+	ast.BinOpShl:    15,
+	ast.BinOpShr:    15,
+	ast.BinOpMul:    20,
+	ast.BinOpDiv:    20,
+	ast.BinOpMod:    20,
+}
+
+// writeOperand writes an operand of a binary operation, adding parentheses when
+// the operand binds less tightly than its parent. All operators are left
+// associative, so a right operand of equal precedence needs parentheses too.
+func (p *printer) writeOperand(expr ast.Expression, parent int, right bool) {
+	if b, ok := expr.(*ast.Binop); ok {
+		prec := precedence[b.Operation]
+
+		if prec < parent || (right && prec == parent) {
+			p.writeParens(expr)
+
+			return
+		}
+	}
+
+	expr.Accept(p)
+}
+
+func (p *printer) writeParens(expr ast.Expression) {
+	p.write("(")
+	expr.Accept(p)
+	p.write(")")
+}
+
+// writeBlock writes a list of statements, one per line, at one deeper level of
+// indentation. With source, the comments are written up to end, the closing
+// brace.
+func (p *printer) writeBlock(instructions []ast.Instruction, end lexer.Location) {
+	p.indent++
+	defer func() { p.indent-- }()
+
+	p.line = 0
+
+	for i := 0; i < len(instructions); i++ {
+		loc := instructions[i].Location()
+
+		p.writeComments(loc)
+		p.writeGap(loc.Line)
+		p.writeIndent()
+
+		if p.writeFolded(instructions, i) {
+			i++
+		} else {
+			instructions[i].Accept(p)
+		}
+
+		last := statementEnd(instructions[i])
+		p.writeLineComment(last)
+		p.line = max(p.line, last.Line)
+
+		p.write("\n")
+	}
+
+	p.writeComments(end)
+}
+
+// writeHeader writes the initializer of an if or for statement, followed by a
+// separator.
+func (p *printer) writeHeader(init []ast.Instruction) {
+	if len(init) == 0 {
+		return
+	}
+
+	p.writeStatements(init, "; ")
+	p.write("; ")
+}
+
+func (p *printer) writeStatements(instructions []ast.Instruction, sep string) {
+	for i := 0; i < len(instructions); i++ {
+		if i > 0 {
+			p.write(sep)
+		}
+
+		if p.writeFolded(instructions, i) {
+			i++
+		} else {
+			instructions[i].Accept(p)
+		}
+	}
+}
+
+// writeFolded writes `x := value` for a declaration that is immediately followed
+// by its initializing assignment, as produced by the parser. It returns false if
+// the instruction at index i is not such a pair.
+func (p *printer) writeFolded(instructions []ast.Instruction, i int) bool {
+	decl, ok := instructions[i].(*ast.Declare)
+	if !ok || i+1 >= len(instructions) {
+		return false
+	}
+
+	assign, ok := instructions[i+1].(*ast.Assign)
+	if !ok {
+		return false
+	}
+
+	ref, ok := assign.LHS.(*ast.VariableRef)
+	if !ok || ref.Ident != decl.Ident || ref.Loc != decl.Loc {
+		return false
+	}
+
+	p.writeBinding(decl.Ident, decl.Type, assign.Value)
+
+	return true
+}
+
+// writeBinding writes `name: type`, `name := value` or `name: type = value`.
+func (p *printer) writeBinding(ident string, ty *ast.Type, value ast.Expression) {
+	known := ty != nil && ty.Kind != ast.TypeUnknown
+
+	switch {
+	case value == nil:
+		p.writef("%s: %s", ident, ty)
+	case known:
+		p.writef("%s: %s = ", ident, ty)
+		value.Accept(p)
+	default:
+		p.writef("%s := ", ident)
+		value.Accept(p)
+	}
+}
+
+// writeDoc writes the comments of a documentation group, one per line.
+func (p *printer) writeDoc(doc *ast.CommentGroup) {
+	if doc == nil {
+		return
+	}
+
+	for _, c := range doc.List {
+		p.writef("//%s\n", c.Text)
+
+		// With source, they're comments of the lexer too.
+		if len(p.comments) > 0 && p.comments[0].Location == c.Loc {
+			p.comments = p.comments[1:]
+		}
+	}
+}
+
+// writeFloating writes the comments before the declaration at start that
+// aren't documentation, followed by a blank line.
+func (p *printer) writeFloating(start lexer.Location) {
+	if len(p.comments) == 0 || !before(p.comments[0].Location, start) {
+		return
+	}
+
+	p.line = 0
+	p.writeComments(start)
+	p.write("\n")
+}
+
+// writeComments writes the comments before loc, one per line.
+func (p *printer) writeComments(loc lexer.Location) {
+	for len(p.comments) > 0 && before(p.comments[0].Location, loc) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+
+		p.writeGap(c.Location.Line)
+		p.writeIndent()
+		p.writef("//%s\n", c.Text)
+		p.line = c.Location.Line
+	}
+}
+
+// writeLineComment writes the comment after loc on the same line, at the end
+// of the line.
+func (p *printer) writeLineComment(loc lexer.Location) {
+	if len(p.comments) == 0 {
+		return
+	}
+
+	if c := p.comments[0]; c.Location.Line == loc.Line && c.Location.Column > loc.Column {
+		p.comments = p.comments[1:]
+		p.writef(" //%s", c.Text)
+	}
+}
+
+// writeGap writes a blank line if the source had one before line, though not
+// at the start of a block.
+func (p *printer) writeGap(line int) {
+	if p.source && p.line > 0 && line > p.line+1 {
+		p.write("\n")
+	}
+}
+
+// before reports whether a comes before b in the source.
+func before(a, b lexer.Location) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+// declStart returns where a declaration starts in the source: at its
+// documentation, if it has any.
+func declStart(doc *ast.CommentGroup, loc lexer.Location) lexer.Location {
+	if doc != nil && len(doc.List) > 0 {
+		return doc.List[0].Loc
+	}
+
+	return loc
+}
+
+// statementEnd returns where a statement ends in the source, as far as it's
+// known: at the closing brace of its last body, or else where it starts.
+func statementEnd(instr ast.Instruction) lexer.Location {
+	var body *ast.Body
+
+	switch instr := instr.(type) {
+	case *ast.If:
+		body = instr.Then
+
+		if instr.Else != nil {
+			body = instr.Else
+
+			// An `else if` has no braces of its own.
+			if body.End == (lexer.Location{}) && len(body.Instructions) == 1 {
+				return statementEnd(body.Instructions[0])
+			}
+		}
+	case *ast.For:
+		body = instr.Body
+	}
+
+	if body == nil || body.End == (lexer.Location{}) {
+		return instr.Location()
+	}
+
+	return body.End
+}
+
+// writeAttributes writes `@(key, key=value)` followed by sep, or nothing if there
+// are no attributes.
+func (p *printer) writeAttributes(attrs ast.Attributes, sep string) {
+	if len(attrs) == 0 {
+		return
+	}
+
+	var parts []string
+
+	for _, key := range attrs.Keys() {
+		switch v := attrs[key].(type) {
+		case ast.AttrBool:
+			if v {
+				parts = append(parts, string(key))
+			} else {
+				parts = append(parts, fmt.Sprintf("%s=false", key))
+			}
+		case ast.AttrString:
+			parts = append(parts, fmt.Sprintf("%s=%q", key, string(v)))
+		case ast.AttrInt:
+			parts = append(parts, fmt.Sprintf("%s=%d", key, v))
+		}
+	}
+
+	p.writef("@(%s)%s", strings.Join(parts, ", "), sep)
+}
+
+func (p *printer) writeIndent() {
+	p.write(strings.Repeat(indentation, p.indent))
+}
+
+func (p *printer) write(text string) {
+	p.sb.WriteString(text)
+}
+
+func (p *printer) writef(format string, args ...any) {
+	p.write(fmt.Sprintf(format, args...))
+}
+
+// isSimple reports whether an expression can be printed without parentheses
+// when used as the operand of a unary operator.
+func isSimple(expr ast.Expression) bool {
+	switch expr.(type) {
+	case *ast.Literal, *ast.VariableRef, *ast.Call, *ast.Deref, *ast.ArrayIndex:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,259 @@
+package printer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func parse(t *testing.T, src string) *ast.CompilationUnit {
+	t.Helper()
+
+	return testutil.Parse(t, "test.in", src)
+}
+
+// parseComments parses src, and returns its unit and all its comments.
+func parseComments(t *testing.T, src string) (*ast.CompilationUnit, []lexer.Comment) {
+	t.Helper()
+
+	scanner, err := lexer.NewScanner("test.in", strings.NewReader(src))
+	require.NoError(t, err)
+
+	lex := lexer.NewLexer(scanner)
+
+	tokens, err := lex.Tokens()
+	require.NoError(t, err)
+
+	var comments []lexer.Comment
+	for _, tok := range tokens {
+		comments = append(comments, tok.Comments...)
+	}
+
+	return testutil.Parse(t, "test.in", src), append(comments, lex.TrailingComments()...)
+}
+
+func TestPrinter_Canonical(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "functions and attributes",
+			input: `package main
+import "core"
+@(link_name="printf",extern)
+print :: func(msg: string, args: ..any)
+@(export)
+main :: func() -> int {
+  count := 11 + 22
+  x: int = 1
+  y: any
+  print("%d\n", count)
+  return 0
+}`,
+			expected: `package main
+
+import "core"
+
+@(extern, link_name="printf")
+print :: func(msg: string, args: ..any)
+
+@(export)
+main :: func() -> int {
+    count := 11 + 22
+    x: int = 1
+    y: any
+    print("%d\n", count)
+    return 0
+}
+`,
+		},
+		{
+			name: "control flow",
+			input: `package main
+loop :: func(row: ^int, n: int) {
+  for i := 0; i < n; i = i + 1 {
+    if x := 1; (row + i)^ == x {
+      row^ = 2
+    } else if i == 1 || i == 2 {
+      (row + i - 1)^ = -(i * 2)
+    } else {
+      return
+    }
+  }
+}`,
+			expected: `package main
+
+loop :: func(row: ^int, n: int) {
+    for i := 0; i < n; i = i + 1 {
+        if x := 1; (row + i)^ == x {
+            row^ = 2
+        } else if i == 1 || i == 2 {
+            (row + i - 1)^ = -(i * 2)
+        } else {
+            return
+        }
+    }
+}
+`,
+		},
+		{
+			name: "doc comments",
+			input: `// Package main says hello.
+package main
+
+// Not documentation.
+
+// print writes a message.
+//   It wraps printf.
+@(extern)
+print :: func(msg: string)
+
+@(export)
+// main is the entry point.
+main :: func() {
+  // Not documentation either.
+  print("hello") // trailing
+}`,
+			expected: `// Package main says hello.
+package main
+
+// print writes a message.
+//   It wraps printf.
+@(extern)
+print :: func(msg: string)
+
+// main is the entry point.
+@(export)
+main :: func() {
+    print("hello")
+}
+`,
+		},
+		{
+			name: "precedence",
+			input: `package main
+f :: func() -> int {
+  a := (1 + 2) * 3
+  b := 1 - (2 - 3)
+  c := (1 << 2) | 4 & 8
+  d := [3]int{1, 2, 3}
+  return a[0]
+}`,
+			expected: `package main
+
+f :: func() -> int {
+    a := (1 + 2) * 3
+    b := 1 - (2 - 3)
+    c := 1 << 2 | 4 & 8
+    d := [3]int{1, 2, 3}
+    return a[0]
+}
+`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual := Sprint(parse(t, tc.input))
+			require.Equal(t, tc.expected, actual)
+
+			// Printing must be idempotent.
+			require.Equal(t, actual, Sprint(parse(t, actual)))
+		})
+	}
+}
+
+func TestPrinter_Comments(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "statements",
+			input: `package main
+f :: func(x: int) -> int {
+  // leading
+  y := x // trailing
+
+
+  if y > 1 {
+    y = 2
+    // before the brace
+  } else if y < 0 {
+    y = 3
+  } // after the if
+  return y
+}`,
+			expected: `package main
+
+f :: func(x: int) -> int {
+    // leading
+    y := x // trailing
+
+    if y > 1 {
+        y = 2
+        // before the brace
+    } else if y < 0 {
+        y = 3
+    } // after the if
+    return y
+}
+`,
+		},
+		{
+			name: "declarations",
+			input: `// floating
+
+// package doc
+package main
+// stray
+
+// doc of f
+f :: func() {
+}
+// end
+
+// of file`,
+			expected: `// floating
+
+// package doc
+package main
+
+// stray
+
+// doc of f
+f :: func() {
+}
+
+// end
+
+// of file
+`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual := SprintComments(parseComments(t, tc.input))
+			require.Equal(t, tc.expected, actual)
+
+			// Printing must be idempotent.
+			require.Equal(t, actual, SprintComments(parseComments(t, actual)))
+		})
+	}
+}
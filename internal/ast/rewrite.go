@@ -0,0 +1,230 @@
+package ast
+
+import "fmt"
+
+// Rewrite returns a copy of the tree rooted at node in which every node has been
+// replaced by the result of fn. It works bottom-up: the children of a node are
+// rewritten first, so fn sees a node with its rewritten children, and the
+// replacement is not rewritten again. To keep a node, fn returns it unchanged.
+//
+// Nodes whose subtree is unchanged are shared with the original tree, and nodes
+// with a changed child are shallow copies, so the original tree is never
+// modified. Returning nil for an element of a list (an instruction, parameter or
+// declaration) removes it from the list. The replacement must fit in the place
+// of the node it replaces, e.g. an expression must be replaced by an expression;
+// Rewrite panics otherwise.
+func Rewrite(node Node, fn func(Node) Node) Node {
+	if isNilNode(node) {
+		return node
+	}
+
+	switch n := node.(type) {
+	case *CompilationUnit:
+		types, c1 := rewriteList(n.Types, fn)
+		data, c2 := rewriteList(n.Data, fn)
+		funcs, c3 := rewriteList(n.Funcs, fn)
+
+		if c1 || c2 || c3 {
+			cp := *n
+			cp.Types, cp.Data, cp.Funcs = types, data, funcs
+			node = &cp
+		}
+	case *TypeDef:
+		if value, ok := rewriteAs(n.Value, fn); ok {
+			cp := *n
+			cp.Value = value
+			node = &cp
+		}
+	case *DataDef:
+		if value, ok := rewriteAs(n.Value, fn); ok {
+			cp := *n
+			cp.Value = value
+			node = &cp
+		}
+	case *FuncDef:
+		generics, c1 := rewriteList(n.GenericParams, fn)
+		params, c2 := rewriteList(n.Params, fn)
+		body, c3 := rewriteAs(n.Body, fn)
+
+		if c1 || c2 || c3 {
+			cp := *n
+			cp.GenericParams, cp.Params, cp.Body = generics, params, body
+			node = &cp
+		}
+	case *FuncParam:
+		if value, ok := rewriteAs(n.Value, fn); ok {
+			cp := *n
+			cp.Value = value
+			node = &cp
+		}
+	case *Body:
+		if list, ok := rewriteList(n.Instructions, fn); ok {
+			cp := *n
+			cp.Instructions = list
+			node = &cp
+		}
+	case *Call:
+		var args []Arg
+
+		for i, arg := range n.Args {
+			value, ok := rewriteAs(arg.Value, fn)
+			if !ok {
+				continue
+			}
+
+			if args == nil {
+				args = append([]Arg(nil), n.Args...)
+			}
+
+			args[i].Value = value
+		}
+
+		if args != nil {
+			cp := *n
+			cp.Args = args
+			node = &cp
+		}
+	case *Assign:
+		lhs, c1 := rewriteAs(n.LHS, fn)
+		value, c2 := rewriteAs(n.Value, fn)
+
+		if c1 || c2 {
+			cp := *n
+			cp.LHS, cp.Value = lhs, value
+			node = &cp
+		}
+	case *Return:
+		if value, ok := rewriteAs(n.Value, fn); ok {
+			cp := *n
+			cp.Value = value
+			node = &cp
+		}
+	case *Literal:
+		var elems []Literal
+
+		changed := false
+
+		for i := range n.ArrayValue {
+			elem, ok := rewriteAs(&n.ArrayValue[i], fn)
+			changed = changed || ok
+
+			if elem != nil {
+				elems = append(elems, *elem)
+			}
+		}
+
+		if changed {
+			cp := *n
+			cp.ArrayValue = elems
+			node = &cp
+		}
+	case *Binop:
+		lhs, c1 := rewriteAs(n.Lhs, fn)
+		rhs, c2 := rewriteAs(n.Rhs, fn)
+
+		if c1 || c2 {
+			cp := *n
+			cp.Lhs, cp.Rhs = lhs, rhs
+			cp.constVal = nil // the folded value no longer applies
+			node = &cp
+		}
+	case *UnaryOp:
+		if expr, ok := rewriteAs(n.Expr, fn); ok {
+			cp := *n
+			cp.Expr = expr
+			cp.constVal = nil
+			node = &cp
+		}
+	case *Deref:
+		if expr, ok := rewriteAs(n.Expr, fn); ok {
+			cp := *n
+			cp.Expr = expr
+			node = &cp
+		}
+	case *ArrayIndex:
+		array, c1 := rewriteAs(n.Array, fn)
+		index, c2 := rewriteAs(n.Index, fn)
+
+		if c1 || c2 {
+			cp := *n
+			cp.Array, cp.Index = array, index
+			node = &cp
+		}
+	case *If:
+		init, c1 := rewriteList(n.Init, fn)
+		cond, c2 := rewriteAs(n.Cond, fn)
+		then, c3 := rewriteAs(n.Then, fn)
+		els, c4 := rewriteAs(n.Else, fn)
+
+		if c1 || c2 || c3 || c4 {
+			cp := *n
+			cp.Init, cp.Cond, cp.Then, cp.Else = init, cond, then, els
+			node = &cp
+		}
+	case *For:
+		init, c1 := rewriteList(n.Init, fn)
+		cond, c2 := rewriteAs(n.Cond, fn)
+		post, c3 := rewriteList(n.Post, fn)
+		body, c4 := rewriteAs(n.Body, fn)
+
+		if c1 || c2 || c3 || c4 {
+			cp := *n
+			cp.Init, cp.Cond, cp.Post, cp.Body = init, cond, post, body
+			node = &cp
+		}
+	case *Assert:
+		if cond, ok := rewriteAs(n.Cond, fn); ok {
+			cp := *n
+			cp.Cond = cond
+			node = &cp
+		}
+	}
+
+	return fn(node)
+}
+
+// rewriteAs rewrites n and converts the result back to the type of the field it
+// is stored in. It reports whether the result differs from n.
+func rewriteAs[T Node](n T, fn func(Node) Node) (T, bool) {
+	var zero T
+
+	if isNilNode(n) {
+		return n, false
+	}
+
+	r := Rewrite(n, fn)
+	if isNilNode(r) {
+		return zero, true
+	}
+
+	t, ok := r.(T)
+	if !ok {
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace %T with %T", n, r))
+	}
+
+	return t, r != Node(n)
+}
+
+// rewriteList rewrites every element of list, dropping elements that are
+// replaced by nil. It returns the original list if nothing changed.
+func rewriteList[T Node](list []T, fn func(Node) Node) ([]T, bool) {
+	var (
+		result  []T
+		changed bool
+	)
+
+	for _, n := range list {
+		r, ok := rewriteAs(n, fn)
+		changed = changed || ok
+
+		if !isNilNode(r) {
+			result = append(result, r)
+		}
+	}
+
+	if !changed {
+		return list, false
+	}
+
+	return result, true
+}
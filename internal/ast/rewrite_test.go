@@ -0,0 +1,78 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewrite(t *testing.T) {
+	t.Parallel()
+
+	fd := newTestFunc()
+	before := SDump(fd)
+
+	// Fold `n - 1` to `n`, and swap `n < 2` to `2 > n`.
+	actual := Rewrite(fd, func(n Node) Node {
+		binop, ok := n.(*Binop)
+		if !ok {
+			return n
+		}
+
+		switch binop.Operation {
+		case BinOpSub:
+			return binop.Lhs
+		case BinOpLt:
+			return NewBinop(BinOpGt, binop.Rhs, binop.Lhs, binop.Loc)
+		default:
+			return n
+		}
+	}).(*FuncDef)
+
+	require.Equal(t, before, SDump(fd), "original tree must not be modified")
+	require.NotSame(t, fd, actual)
+	require.Same(t, fd.Params[0], actual.Params[0], "unchanged subtrees must be shared")
+
+	var visited []string
+
+	Walk(actual, func(n Node) bool {
+		visited = append(visited, nodeName(n))
+
+		return true
+	})
+
+	require.Equal(t, []string{
+		"func f", "param n", "body",
+		"if", "binop >", "lit 2", "ref n", "body", "return", "ref n",
+		"return", "call f", "ref n",
+	}, visited)
+}
+
+func TestRewrite_RemoveFromList(t *testing.T) {
+	t.Parallel()
+
+	actual := Rewrite(newTestFunc(), func(n Node) Node {
+		if _, ok := n.(*If); ok {
+			return nil
+		}
+
+		return n
+	}).(*FuncDef)
+
+	require.Len(t, actual.Body.Instructions, 1)
+	require.IsType(t, &Return{}, actual.Body.Instructions[0])
+}
+
+func TestRewrite_InvalidReplacement(t *testing.T) {
+	t.Parallel()
+
+	require.PanicsWithValue(t, "ast.Rewrite: cannot replace *ast.Literal with *ast.Return", func() {
+		Rewrite(newTestFunc(), func(n Node) Node {
+			if lit, ok := n.(*Literal); ok {
+				return NewReturn(lit.Loc, nil)
+			}
+
+			return n
+		})
+	})
+}
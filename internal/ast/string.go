@@ -113,7 +113,7 @@ func (s *stringer) VisitBody(b *Body) {
 }
 
 func (s *stringer) VisitCall(c *Call) {
-	s.writef("(call %s %q\n", c.Type, c.Ident)
+	s.writef("(call %s %q\n", c.Type(), c.Ident)
 	s.writeIndented(func() {
 		s.write("\t(args\n")
 		s.writeIndented(func() {
@@ -149,9 +149,15 @@ func (s *stringer) VisitReturn(r *Return) {
 	s.write(")")
 }
 
+func (s *stringer) VisitAssert(a *Assert) {
+	s.write("(assert ")
+	a.Cond.Accept(s)
+	s.writef(" %q)", a.Message)
+}
+
 func (s *stringer) VisitLiteral(l *Literal) {
-	s.writef("(lit %s ", l.Type)
-	switch l.Type.Kind {
+	s.writef("(lit %s ", l.Type())
+	switch l.Type().Kind {
 	case TypeInt:
 		s.writef("%d)", l.IntValue)
 	case TypeString:
@@ -174,7 +180,7 @@ func (s *stringer) VisitLiteral(l *Literal) {
 }
 
 func (s *stringer) VisitBinop(b *Binop) {
-	s.writef("(binop %s %q\n", b.Type, b.Operation)
+	s.writef("(binop %s %q\n", b.Type(), b.Operation)
 	s.writeIndented(func() {
 		s.write("\t")
 		b.Lhs.Accept(s)
@@ -188,17 +194,17 @@ func (s *stringer) VisitBinop(b *Binop) {
 }
 
 func (s *stringer) VisitUnaryOp(u *UnaryOp) {
-	s.writef("(unop %s %q ", u.Type, u.Operation)
+	s.writef("(unop %s %q ", u.Type(), u.Operation)
 	u.Expr.Accept(s)
 	s.write(")")
 }
 
 func (s *stringer) VisitVariableRef(v *VariableRef) {
-	s.writef("(ref %s %q)", v.Type, v.Ident)
+	s.writef("(ref %s %q)", v.Type(), v.Ident)
 }
 
 func (s *stringer) VisitDeref(d *Deref) {
-	s.writef("(deref %s ", d.Type)
+	s.writef("(deref %s ", d.Type())
 	d.Expr.Accept(s)
 	s.write(")")
 }
@@ -236,7 +242,7 @@ func (s *stringer) VisitFor(f *For) {
 }
 
 func (s *stringer) VisitArrayIndex(a *ArrayIndex) {
-	s.writef("(index %s ", a.Type)
+	s.writef("(index %s ", a.Type())
 	a.Array.Accept(s)
 	s.write(" ")
 	a.Index.Accept(s)
@@ -0,0 +1,179 @@
+package symbols
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/corani/cubit/internal/ast"
+)
+
+// Table is the result of resolving a compilation unit.
+type Table struct {
+	// Package is the outermost scope, holding the top-level declarations.
+	Package *Scope
+	// Scopes maps each scope-opening node (function, block, if, for) to its scope.
+	Scopes map[ast.Node]*Scope
+	// Refs maps each variable reference to the symbol it resolves to.
+	Refs map[*ast.VariableRef]*Symbol
+	// Calls maps each call to the function symbol it resolves to.
+	Calls map[*ast.Call]*Symbol
+}
+
+// Lookup returns the symbol a variable reference resolves to.
+func (t *Table) Lookup(ref *ast.VariableRef) (*Symbol, bool) {
+	sym, ok := t.Refs[ref]
+
+	return sym, ok
+}
+
+// Declaration returns the declaring node of a variable reference.
+func (t *Table) Declaration(ref *ast.VariableRef) ast.Node {
+	if sym, ok := t.Refs[ref]; ok {
+		return sym.Node
+	}
+
+	return nil
+}
+
+// Resolve builds the symbol table for unit. Top-level declarations are visible
+// throughout the unit; local variables are visible from their declaration to the
+// end of the enclosing block. Each function, if, for and nested block opens a
+// new scope. A function body shares the scope of its parameters.
+//
+// All references that can't be resolved and all names declared twice in the same
+// scope are reported in the returned error; the table is still populated with
+// everything that did resolve.
+func Resolve(unit *ast.CompilationUnit) (*Table, error) {
+	r := &resolver{
+		table: &Table{
+			Package: NewScope(nil, unit),
+			Scopes:  make(map[ast.Node]*Scope),
+			Refs:    make(map[*ast.VariableRef]*Symbol),
+			Calls:   make(map[*ast.Call]*Symbol),
+		},
+	}
+
+	r.table.Scopes[unit] = r.table.Package
+	r.scope = r.table.Package
+
+	for _, td := range unit.Types {
+		r.declare(NewSymbol(td.Ident, KindType, td))
+	}
+
+	for _, dd := range unit.Data {
+		r.declare(NewSymbol(dd.Ident, KindData, dd))
+	}
+
+	for _, fd := range unit.Funcs {
+		r.declare(NewSymbol(fd.Ident, KindFunc, fd))
+	}
+
+	var (
+		stack  []ast.Node
+		opened []bool
+	)
+
+	ast.Inspect(unit, func(n ast.Node) bool {
+		if n == nil {
+			if opened[len(opened)-1] {
+				r.scope = r.scope.Parent()
+			}
+
+			stack = stack[:len(stack)-1]
+			opened = opened[:len(opened)-1]
+
+			return true
+		}
+
+		var parent ast.Node
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+
+		stack = append(stack, n)
+		opened = append(opened, r.visit(n, parent))
+
+		return true
+	})
+
+	return r.table, errors.Join(r.errors...)
+}
+
+type resolver struct {
+	table  *Table
+	scope  *Scope
+	errors []error
+}
+
+// visit resolves or declares n and reports whether it opened a new scope.
+func (r *resolver) visit(n, parent ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.FuncDef, *ast.If, *ast.For:
+		r.open(n)
+
+		return true
+	case *ast.Body:
+		// The function body shares the scope of the parameters.
+		if _, ok := parent.(*ast.FuncDef); ok {
+			r.table.Scopes[n] = r.scope
+
+			return false
+		}
+
+		r.open(n)
+
+		return true
+	case *ast.FuncParam:
+		r.declare(NewSymbol(n.Ident, KindParam, n))
+	case *ast.Declare:
+		r.declare(NewSymbol(n.Ident, KindVariable, n))
+	case *ast.VariableRef:
+		sym, ok := r.scope.Lookup(n.Ident)
+		if !ok {
+			r.errorf(n, "undefined: %s", n.Ident)
+
+			break
+		}
+
+		r.table.Refs[n] = sym
+	case *ast.Call:
+		sym, ok := r.scope.Lookup(n.Ident)
+		if !ok {
+			r.errorf(n, "call to undefined function: %s", n.Ident)
+
+			break
+		}
+
+		if sym.Kind != KindFunc {
+			r.errorf(n, "cannot call non-function %s (%s)", n.Ident, sym.Kind)
+
+			break
+		}
+
+		r.table.Calls[n] = sym
+	}
+
+	return false
+}
+
+// declare adds sym to the current scope and reports a redeclaration if the
+// name was already declared in the same scope. Functions may share a name, as
+// they can be overloaded; the type checker tells the overloads apart.
+func (r *resolver) declare(sym *Symbol) {
+	if prev, ok := r.scope.LookupLocal(sym.Name); ok && prev.Kind == KindFunc && sym.Kind == KindFunc {
+		return
+	}
+
+	if prev, ok := r.scope.Declare(sym); ok {
+		r.errorf(sym.Node, "%s %s redeclared\n\t%s: previously declared here", sym.Kind, sym.Name, prev.Node.Location())
+	}
+}
+
+func (r *resolver) open(n ast.Node) {
+	r.scope = NewScope(r.scope, n)
+	r.table.Scopes[n] = r.scope
+}
+
+func (r *resolver) errorf(n ast.Node, format string, args ...any) {
+	r.errors = append(r.errors, fmt.Errorf("%s: "+format, append([]any{n.Location()}, args...)...))
+}
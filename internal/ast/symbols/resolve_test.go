@@ -0,0 +1,127 @@
+package symbols
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func parse(t *testing.T, src string) *ast.CompilationUnit {
+	t.Helper()
+
+	return testutil.Parse(t, "test.in", src)
+}
+
+// refs returns the variable references in node, in source order.
+func refs(node ast.Node) []*ast.VariableRef {
+	var list []*ast.VariableRef
+
+	ast.Walk(node, func(n ast.Node) bool {
+		if ref, ok := n.(*ast.VariableRef); ok {
+			list = append(list, ref)
+		}
+
+		return true
+	})
+
+	return list
+}
+
+func TestResolve_Shadowing(t *testing.T) {
+	t.Parallel()
+
+	unit := parse(t, `package main
+
+f :: func(x: int) -> int {
+    y := x
+    if x > 0 {
+        x := 2
+        y = x
+    }
+    return g(y)
+}
+
+g :: func(v: int) -> int {
+    return v
+}
+`)
+
+	table, err := Resolve(unit)
+	require.NoError(t, err)
+
+	f := unit.Funcs[0]
+	param := f.Params[0]
+	outerY := f.Body.Instructions[0].(*ast.Declare)
+	innerX := f.Body.Instructions[2].(*ast.If).Then.Instructions[0].(*ast.Declare)
+
+	// y := x; x > 0; x := 2; y = x; y = x; g(y)
+	var decls []ast.Node
+	for _, ref := range refs(f) {
+		decls = append(decls, table.Declaration(ref))
+	}
+
+	require.Equal(t, []ast.Node{
+		outerY, param, param, innerX, outerY, innerX, outerY,
+	}, decls)
+
+	call := f.Body.Instructions[3].(*ast.Return).Value.(*ast.Call)
+	require.Same(t, unit.Funcs[1], table.Calls[call].Node)
+
+	// The inner x lives in the scope of the then-block, nested in the if.
+	sym, ok := table.Scopes[f.Body].LookupLocal("x")
+	require.True(t, ok)
+	require.Equal(t, KindParam, sym.Kind)
+
+	then := table.Scopes[f.Body.Instructions[2].(*ast.If).Then]
+	require.Equal(t, []string{"x"}, then.Names())
+	require.Same(t, table.Scopes[f.Body.Instructions[2]], then.Parent())
+	require.Same(t, table.Package, table.Scopes[f].Parent())
+}
+
+func TestResolve_Errors(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "undefined variable",
+			src:  "package main\nf :: func() -> int {\n    return x\n}\n",
+			want: "test.in:3:12: undefined: x",
+		},
+		{
+			name: "out of scope",
+			src:  "package main\nf :: func(n: int) -> int {\n    if n == 1 {\n        x := 1\n    }\n    return x\n}\n",
+			want: "undefined: x",
+		},
+		{
+			name: "undefined function",
+			src:  "package main\nf :: func() {\n    g()\n}\n",
+			want: "call to undefined function: g",
+		},
+		{
+			name: "call to variable",
+			src:  "package main\nf :: func(g: int) {\n    g()\n}\n",
+			want: "cannot call non-function g (parameter)",
+		},
+		{
+			name: "redeclared",
+			src:  "package main\nf :: func(n: int) {\n    n := 1\n}\n",
+			want: "test.in:3:5: variable n redeclared\n\ttest.in:2:11: previously declared here",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Resolve(parse(t, tc.src))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.want)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Package symbols resolves identifiers in the AST to their declarations.
+package symbols
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/corani/cubit/internal/ast"
+)
+
+type Kind int
+
+const (
+	KindVariable Kind = iota
+	KindParam
+	KindFunc
+	KindType
+	KindData
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindVariable:
+		return "variable"
+	case KindParam:
+		return "parameter"
+	case KindFunc:
+		return "function"
+	case KindType:
+		return "type"
+	case KindData:
+		return "data"
+	default:
+		return "unknown"
+	}
+}
+
+// Symbol is a named declaration. Node is the declaring node: a *ast.Declare,
+// *ast.FuncParam, *ast.FuncDef, *ast.TypeDef or *ast.DataDef.
+type Symbol struct {
+	Name  string
+	Kind  Kind
+	Node  ast.Node
+	Scope *Scope
+}
+
+func NewSymbol(name string, kind Kind, node ast.Node) *Symbol {
+	return &Symbol{
+		Name: name,
+		Kind: kind,
+		Node: node,
+	}
+}
+
+// Scope is a lexical block of declarations. Lookups that miss in a scope
+// continue in its parent, so inner declarations shadow outer ones.
+type Scope struct {
+	parent  *Scope
+	node    ast.Node
+	symbols map[string]*Symbol
+}
+
+// NewScope creates a scope for node, nested inside parent. The parent is nil
+// for the package scope.
+func NewScope(parent *Scope, node ast.Node) *Scope {
+	return &Scope{
+		parent:  parent,
+		node:    node,
+		symbols: make(map[string]*Symbol),
+	}
+}
+
+func (s *Scope) Parent() *Scope {
+	return s.parent
+}
+
+// Node returns the node that opened the scope.
+func (s *Scope) Node() ast.Node {
+	return s.node
+}
+
+// Declare adds sym to the scope. If the name was already declared in this
+// scope, the new symbol replaces it and the previous one is returned.
+func (s *Scope) Declare(sym *Symbol) (*Symbol, bool) {
+	prev, ok := s.symbols[sym.Name]
+
+	sym.Scope = s
+	s.symbols[sym.Name] = sym
+
+	return prev, ok
+}
+
+// LookupLocal finds name in this scope only.
+func (s *Scope) LookupLocal(name string) (*Symbol, bool) {
+	sym, ok := s.symbols[name]
+
+	return sym, ok
+}
+
+// Lookup finds name in this scope or the closest enclosing scope declaring it.
+func (s *Scope) Lookup(name string) (*Symbol, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if sym, ok := scope.symbols[name]; ok {
+			return sym, true
+		}
+	}
+
+	return nil, false
+}
+
+// Names returns the names declared in this scope, sorted.
+func (s *Scope) Names() []string {
+	return slices.Sorted(maps.Keys(s.symbols))
+}
@@ -152,18 +152,32 @@ const (
 
 // Generic parameter struct
 type GenericParam struct {
-	Kind   GenericParamKind // GenericType or GenericValue
-	Symbol string           // without '$' prefix
-	Type   *Type            // for Kind == GenericValue
+	Kind       GenericParamKind // GenericType or GenericValue
+	Symbol     string           // without '$' prefix
+	Type       *Type            // for Kind == GenericValue
+	Constraint string           // for Kind == GenericType, e.g. Comparable; empty if unconstrained
+	Loc        lexer.Location
+}
+
+func (gp *GenericParam) Location() lexer.Location {
+	return gp.Loc
 }
 
 func (gp *GenericParam) Accept(v Visitor) {
 	v.VisitGenericParam(gp)
 }
 
+func (gp *GenericParam) AcceptE(v VisitorE) error {
+	return v.VisitGenericParam(gp)
+}
+
 func (gp *GenericParam) String() string {
 	switch gp.Kind {
 	case GenericType:
+		if gp.Constraint != "" {
+			return fmt.Sprintf("type $%s: %s", gp.Symbol, gp.Constraint)
+		}
+
 		return fmt.Sprintf("type $%s", gp.Symbol)
 	case GenericValue:
 		return fmt.Sprintf("value %s $%s", gp.Type, gp.Symbol)
@@ -0,0 +1,173 @@
+package ast
+
+import (
+	"reflect"
+
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Node is implemented by all AST nodes.
+type Node interface {
+	Location() lexer.Location
+	Accept(v Visitor)
+	AcceptE(v VisitorE) error
+}
+
+var _ []Node = []Node{
+	(*CompilationUnit)(nil),
+	(*TypeDef)(nil),
+	(*DataDef)(nil),
+	(*FuncDef)(nil),
+	(*GenericParam)(nil),
+	(*FuncParam)(nil),
+	(*Body)(nil),
+	(*Call)(nil),
+	(*Declare)(nil),
+	(*Assign)(nil),
+	(*Return)(nil),
+	(*Literal)(nil),
+	(*Binop)(nil),
+	(*UnaryOp)(nil),
+	(*VariableRef)(nil),
+	(*Deref)(nil),
+	(*ArrayIndex)(nil),
+	(*If)(nil),
+	(*For)(nil),
+	(*Assert)(nil),
+}
+
+// Walk traverses the AST in depth-first order, starting at node. It calls fn for
+// each node; if fn returns false, the children of that node are skipped.
+func Walk(node Node, fn func(Node) bool) {
+	if isNilNode(node) || !fn(node) {
+		return
+	}
+
+	for _, child := range Children(node) {
+		Walk(child, fn)
+	}
+}
+
+// Inspect traverses the AST in depth-first order like Walk, but additionally
+// calls fn(nil) after all children of a node have been visited. This allows
+// callers to maintain a stack of the nodes currently being visited.
+func Inspect(node Node, fn func(Node) bool) {
+	if isNilNode(node) || !fn(node) {
+		return
+	}
+
+	for _, child := range Children(node) {
+		Inspect(child, fn)
+	}
+
+	fn(nil)
+}
+
+// WalkPath traverses the AST like Walk, but also passes the ancestors of each
+// node to fn, from node (the root of the walk) down to the direct parent. The
+// slice is reused between calls; callers that keep it must copy it.
+func WalkPath(node Node, fn func(n Node, ancestors []Node) bool) {
+	var path []Node
+
+	Inspect(node, func(n Node) bool {
+		if n == nil {
+			path = path[:len(path)-1]
+
+			return true
+		}
+
+		if !fn(n, path) {
+			return false
+		}
+
+		path = append(path, n)
+
+		return true
+	})
+}
+
+// Children returns the direct children of node in source order. Optional
+// children that are not present are omitted.
+func Children(node Node) []Node {
+	var children []Node
+
+	add := func(nodes ...Node) {
+		for _, n := range nodes {
+			if !isNilNode(n) {
+				children = append(children, n)
+			}
+		}
+	}
+
+	addInstructions := func(list []Instruction) {
+		for _, instr := range list {
+			add(instr)
+		}
+	}
+
+	switch n := node.(type) {
+	case *CompilationUnit:
+		for _, td := range n.Types {
+			add(td)
+		}
+		for _, dd := range n.Data {
+			add(dd)
+		}
+		for _, fd := range n.Funcs {
+			add(fd)
+		}
+	case *TypeDef:
+		add(n.Value)
+	case *DataDef:
+		add(n.Value)
+	case *FuncDef:
+		for _, gp := range n.GenericParams {
+			add(gp)
+		}
+		for _, param := range n.Params {
+			add(param)
+		}
+		add(n.Body)
+	case *FuncParam:
+		add(n.Value)
+	case *Body:
+		addInstructions(n.Instructions)
+	case *Call:
+		for _, arg := range n.Args {
+			add(arg.Value)
+		}
+	case *Assign:
+		add(n.LHS, n.Value)
+	case *Return:
+		add(n.Value)
+	case *Literal:
+		for i := range n.ArrayValue {
+			add(&n.ArrayValue[i])
+		}
+	case *Binop:
+		add(n.Lhs, n.Rhs)
+	case *UnaryOp:
+		add(n.Expr)
+	case *Deref:
+		add(n.Expr)
+	case *ArrayIndex:
+		add(n.Array, n.Index)
+	case *If:
+		addInstructions(n.Init)
+		add(n.Cond, n.Then, n.Else)
+	case *For:
+		addInstructions(n.Init)
+		add(n.Cond)
+		addInstructions(n.Post)
+		add(n.Body)
+	case *Assert:
+		add(n.Cond)
+	}
+
+	return children
+}
+
+// isNilNode returns true for nil interfaces as well as typed nil pointers.
+func isNilNode(node Node) bool {
+	return node == nil || reflect.ValueOf(node).IsNil()
+}
@@ -0,0 +1,151 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFunc() *FuncDef {
+	var loc lexer.Location
+
+	// f :: func(n: int) -> int { if n < 2 { return n }; return f(n - 1) }
+	fd := NewFuncDef("f", nil, loc)
+	fd.Params = []*FuncParam{NewFuncParam("n", NewType(TypeInt, loc), nil, nil, loc)}
+	fd.ReturnType = NewType(TypeInt, loc)
+	fd.Body = NewBody([]Instruction{
+		NewIf(loc, nil,
+			NewBinop(BinOpLt, NewVariableRef("n", TypeUnknown, loc), NewIntLiteral(2, loc), loc),
+			NewBody([]Instruction{NewReturn(loc, fd.ReturnType, NewVariableRef("n", TypeUnknown, loc))}, loc),
+			nil),
+		NewReturn(loc, fd.ReturnType,
+			NewCall(loc, "f", NewArg("", NewBinop(BinOpSub,
+				NewVariableRef("n", TypeUnknown, loc), NewIntLiteral(1, loc), loc), nil, loc))),
+	}, loc)
+
+	return fd
+}
+
+func nodeName(n Node) string {
+	switch n := n.(type) {
+	case *FuncDef:
+		return "func " + n.Ident
+	case *FuncParam:
+		return "param " + n.Ident
+	case *Call:
+		return "call " + n.Ident
+	case *VariableRef:
+		return "ref " + n.Ident
+	case *Literal:
+		return fmt.Sprintf("lit %d", n.IntValue)
+	case *Binop:
+		return "binop " + string(n.Operation)
+	default:
+		return strings.ToLower(strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast."))
+	}
+}
+
+func TestWalk(t *testing.T) {
+	t.Parallel()
+
+	var visited []string
+
+	Walk(newTestFunc(), func(n Node) bool {
+		visited = append(visited, nodeName(n))
+
+		// Don't descend into the if statement.
+		_, isIf := n.(*If)
+
+		return !isIf
+	})
+
+	require.Equal(t, []string{
+		"func f", "param n", "body", "if",
+		"return", "call f", "binop -", "ref n", "lit 1",
+	}, visited)
+}
+
+func TestInspect(t *testing.T) {
+	t.Parallel()
+
+	var (
+		stack    []Node
+		maxDepth int
+		refs     []int
+	)
+
+	Inspect(newTestFunc(), func(n Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+
+			return true
+		}
+
+		stack = append(stack, n)
+		maxDepth = max(maxDepth, len(stack))
+
+		if _, ok := n.(*VariableRef); ok {
+			refs = append(refs, len(stack))
+		}
+
+		return true
+	})
+
+	require.Empty(t, stack)
+	require.Equal(t, 6, maxDepth)
+	require.Equal(t, []int{5, 6, 6}, refs)
+}
+
+func TestWalkPath(t *testing.T) {
+	t.Parallel()
+
+	var paths []string
+
+	WalkPath(newTestFunc(), func(n Node, ancestors []Node) bool {
+		if _, ok := n.(*VariableRef); ok {
+			var names []string
+
+			for _, a := range ancestors {
+				names = append(names, nodeName(a))
+			}
+
+			paths = append(paths, strings.Join(names, " > "))
+		}
+
+		return true
+	})
+
+	require.Equal(t, []string{
+		"func f > body > if > binop <",
+		"func f > body > if > body > return",
+		"func f > body > return > call f > binop -",
+	}, paths)
+}
+
+func TestParents(t *testing.T) {
+	t.Parallel()
+
+	fd := newTestFunc()
+	parents := NewParents(fd)
+
+	iff := fd.Body.Instructions[0].(*If)
+	ret := iff.Then.Instructions[0].(*Return)
+
+	require.Nil(t, parents.Parent(fd))
+	require.Equal(t, Node(iff.Then), parents.Parent(ret))
+	require.Equal(t, []Node{ret, iff.Then, iff, fd.Body, fd}, parents.Path(ret))
+
+	enclosingIf, ok := Enclosing[*If](parents, ret.Value)
+	require.True(t, ok)
+	require.Same(t, iff, enclosingIf)
+
+	enclosingFunc, ok := Enclosing[*FuncDef](parents, ret)
+	require.True(t, ok)
+	require.Same(t, fd, enclosingFunc)
+
+	_, ok = Enclosing[*For](parents, ret)
+	require.False(t, ok)
+}
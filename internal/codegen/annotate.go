@@ -0,0 +1,85 @@
+package codegen
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sources are the lines of source files, by file name. Files are read the
+// first time a line of them is needed.
+type sources map[string][]string
+
+// line returns the text of a line of a source file, without surrounding
+// whitespace, or "" if the file can't be read.
+func (s sources) line(filename string, line int) string {
+	lines, ok := s[filename]
+	if !ok {
+		if data, err := os.ReadFile(filename); err == nil {
+			lines = strings.Split(string(data), "\n")
+		}
+
+		s[filename] = lines
+	}
+
+	if line <= 0 || line > len(lines) {
+		return ""
+	}
+
+	return strings.TrimSpace(lines[line-1])
+}
+
+// Annotate interleaves the source lines of the code in asm as comments that
+// start with comment, like objdump -S. The lines come from the .file and .loc
+// directives of assembly, or the #line directives of C, so the code must be
+// generated with Options.DebugInfo. A line is only written when the code moves
+// to another one.
+func Annotate(asm []byte, comment string) []byte {
+	var out bytes.Buffer
+
+	src := make(sources)
+	files := make(map[string]string) // file number -> name
+	file, line := "", 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(asm))
+	scanner.Buffer(nil, 1<<20)
+
+	for scanner.Scan() {
+		text := scanner.Text()
+		fields := strings.Fields(text)
+
+		var (
+			name string
+			n    int
+		)
+
+		switch {
+		case len(fields) >= 3 && fields[0] == ".file":
+			if name, err := strconv.Unquote(strings.Join(fields[2:], " ")); err == nil {
+				files[fields[1]] = name
+			}
+		case len(fields) >= 3 && fields[0] == ".loc":
+			name = files[fields[1]]
+			n, _ = strconv.Atoi(fields[2])
+		case len(fields) >= 3 && fields[0] == "#line":
+			name, _ = strconv.Unquote(strings.Join(fields[2:], " "))
+			n, _ = strconv.Atoi(fields[1])
+		}
+
+		if name != "" && n > 0 && (name != file || n != line) {
+			file, line = name, n
+
+			if source := src.line(name, n); source != "" {
+				fmt.Fprintf(&out, "\t%s %d: %s\n", comment, n, source)
+			}
+		}
+
+		out.WriteString(text)
+		out.WriteByte('\n')
+	}
+
+	return out.Bytes()
+}
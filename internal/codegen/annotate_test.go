@@ -0,0 +1,55 @@
+package codegen
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotate(t *testing.T) {
+	t.Parallel()
+
+	src := filepath.Join(t.TempDir(), "test.in")
+	require.NoError(t, os.WriteFile(src, []byte("main :: func() -> int {\n    x := 1\n    return x\n}\n"), 0o644))
+
+	file := strconv.Quote(src)
+
+	tt := []struct {
+		name     string
+		comment  string
+		code     string
+		expected string
+	}{
+		{
+			name:    "assembly",
+			comment: "#",
+			code: "main:\n\t.file 1 " + file + "\n\t.loc 1 2\n\tmovl $1, %eax\n\t.loc 1 2\n" +
+				"\tmovl %eax, -8(%rbp)\n\t.loc 1 3\n\tret\n",
+			expected: "main:\n\t.file 1 " + file + "\n\t# 2: x := 1\n\t.loc 1 2\n\tmovl $1, %eax\n\t.loc 1 2\n" +
+				"\tmovl %eax, -8(%rbp)\n\t# 3: return x\n\t.loc 1 3\n\tret\n",
+		},
+		{
+			name:     "C",
+			comment:  "//",
+			code:     "#line 3 " + file + "\n\treturn x_1;\n",
+			expected: "\t// 3: return x\n#line 3 " + file + "\n\treturn x_1;\n",
+		},
+		{
+			name:     "unknown lines",
+			comment:  "#",
+			code:     "\t.file 1 \"missing.in\"\n\t.loc 1 2\n\t.loc 2 1\n\tret\n",
+			expected: "\t.file 1 \"missing.in\"\n\t.loc 1 2\n\t.loc 2 1\n\tret\n",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.expected, string(Annotate([]byte(tc.code), tc.comment)))
+		})
+	}
+}
@@ -0,0 +1,471 @@
+// Package csrc generates C99 from the IR, so cubit code can be built where
+// there's only a C compiler, and linked by existing C build systems.
+//
+// Every temporary is a local variable, of type int32_t for words and int64_t
+// for longs. Arithmetic that can overflow is done on the unsigned types, so it
+// wraps like in the IR. Memory is accessed through helpers that copy bytes,
+// which C allows for any type. Addresses have the type of pointers in the IR,
+// so the code is only portable to machines with pointers of the size the IR was
+// lowered for, like 32-bit WebAssembly.
+package csrc
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/corani/cubit/internal/parallel"
+)
+
+// Options control the generated code.
+type Options struct {
+	// DebugInfo emits #line directives, so the compiler attributes the code
+	// to the cubit source.
+	DebugInfo bool
+	// Jobs is the number of functions generated at once, GOMAXPROCS if it's
+	// 0. The source is the same for any number.
+	Jobs int
+}
+
+// Generate writes the C source of unit to w. Functions and data that aren't
+// defined in unit are declared extern.
+func Generate(w io.Writer, unit *ir.CompilationUnit, opts Options) error {
+	g := &generator{
+		opts:    opts,
+		names:   make(map[ir.Ident]string),
+		used:    make(map[string]bool),
+		externs: make(map[ir.Ident]*extern),
+	}
+
+	if err := g.declare(unit); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(&g.out, "/* package %s (%s) */\n\n#include <stdint.h>\n", unit.Package, unit.Loc)
+	g.out.WriteString(helpers)
+
+	for _, dd := range unit.DataDefs {
+		if err := g.dataType(dd); err != nil {
+			return err
+		}
+	}
+
+	g.out.WriteString("\n")
+
+	for _, ident := range slices.Sorted(maps.Keys(g.externs)) {
+		if e := g.externs[ident]; e.call {
+			fmt.Fprintf(&g.out, "extern %s %s();\n", cType(e.retTy), ident)
+		} else {
+			fmt.Fprintf(&g.out, "extern char %s[];\n", ident)
+		}
+	}
+
+	for i := range unit.FuncDefs {
+		if unit.FuncDefs[i].Blocks != nil {
+			fmt.Fprintf(&g.out, "%s;\n", g.signature(&unit.FuncDefs[i]))
+		}
+	}
+
+	for _, dd := range unit.DataDefs {
+		if err := g.data(dd); err != nil {
+			return err
+		}
+	}
+
+	// The functions are generated in parallel, each by a generator of its
+	// own that shares the names, and written in order.
+	code := make([]string, len(unit.FuncDefs))
+
+	if err := parallel.Do(len(unit.FuncDefs), opts.Jobs, nil, func(i int) error {
+		if unit.FuncDefs[i].Blocks == nil {
+			return nil
+		}
+
+		fg := &generator{opts: g.opts, names: g.names, used: g.used, externs: g.externs}
+		err := fg.function(&unit.FuncDefs[i])
+		code[i] = fg.out.String()
+
+		return err
+	}); err != nil {
+		return err
+	}
+
+	for _, c := range code {
+		g.out.WriteString(c)
+	}
+
+	_, err := io.WriteString(w, g.out.String())
+
+	return err
+}
+
+// helpers are the functions the generated code uses to access memory and to
+// stop the program.
+var helpers = func() string {
+	var sb strings.Builder
+
+	sb.WriteString(`
+static inline void cubit_copy(void *dst, const void *src, int n) {
+	unsigned char *d = dst;
+	const unsigned char *s = src;
+
+	while (n-- > 0) {
+		*d++ = *s++;
+	}
+}
+`)
+
+	for _, load := range []struct{ name, ty string }{
+		{"ub", "uint8_t"}, {"sb", "int8_t"}, {"uh", "uint16_t"}, {"sh", "int16_t"},
+		{"w", "int32_t"}, {"l", "int64_t"},
+	} {
+		fmt.Fprintf(&sb, `
+static inline int64_t cubit_load_%s(int64_t p) {
+	%s v;
+	cubit_copy(&v, (void *)(intptr_t)p, sizeof v);
+	return v;
+}
+`, load.name, load.ty)
+	}
+
+	for _, store := range []struct{ name, ty string }{
+		{"b", "uint8_t"}, {"h", "uint16_t"}, {"w", "uint32_t"}, {"l", "uint64_t"},
+	} {
+		fmt.Fprintf(&sb, `
+static inline void cubit_store_%s(int64_t p, int64_t v) {
+	%s x = (%s)v;
+	cubit_copy((void *)(intptr_t)p, &x, sizeof x);
+}
+`, store.name, store.ty, store.ty)
+	}
+
+	sb.WriteString(`
+static inline void cubit_trap(void) {
+	for (;;) {
+		*(volatile char *)0 = 0;
+	}
+}
+`)
+
+	return sb.String()
+}()
+
+type generator struct {
+	opts    Options
+	out     strings.Builder
+	names   map[ir.Ident]string // symbols defined in the unit -> their C names
+	used    map[string]bool     // C names of the symbols
+	externs map[ir.Ident]*extern
+}
+
+// extern is a symbol that the unit uses but doesn't define.
+type extern struct {
+	call  bool      // called, so declared as a function
+	retTy *ir.AbiTy // the return type of the first call
+}
+
+// declare names the symbols defined in unit and collects the ones it uses
+// but doesn't define. Exported symbols keep their name; the others get a
+// valid C identifier that doesn't clash with the exported ones.
+func (g *generator) declare(unit *ir.CompilationUnit) error {
+	type symbol struct {
+		loc     lexer.Location
+		ident   ir.Ident
+		linkage *ir.Linkage
+	}
+
+	var symbols []symbol
+
+	for _, fd := range unit.FuncDefs {
+		if fd.Blocks == nil {
+			continue
+		}
+
+		if fd.CallConv != ir.CallConvC {
+			return fd.Loc.Errorf("%s calling convention of %s isn't supported by the C backend", fd.CallConv, fd.Ident)
+		}
+
+		symbols = append(symbols, symbol{fd.Loc, fd.Ident, fd.Linkage})
+	}
+
+	for _, dd := range unit.DataDefs {
+		symbols = append(symbols, symbol{dd.Loc, dd.Ident, dd.Linkage})
+	}
+
+	// Exported symbols first, so the others are renamed if they clash.
+	for _, s := range symbols {
+		if s.linkage == nil || s.linkage.Type != ir.LinkageExport {
+			continue
+		}
+
+		if !isIdent(string(s.ident)) {
+			return s.loc.Errorf("exported symbol %s isn't a valid C identifier", s.ident)
+		}
+
+		g.names[s.ident] = string(s.ident)
+		g.used[string(s.ident)] = true
+	}
+
+	for _, s := range symbols {
+		if s.linkage != nil && s.linkage.Type != ir.LinkageExport {
+			return s.loc.Errorf("%s linkage of %s isn't supported by the C backend", s.linkage.Type, s.ident)
+		}
+
+		if _, ok := g.names[s.ident]; !ok {
+			g.names[s.ident] = unique(g.used, sanitize(string(s.ident)))
+		}
+	}
+
+	use := func(ident ir.Ident, call bool, retTy *ir.AbiTy) {
+		if _, ok := g.names[ident]; ok {
+			return
+		}
+
+		e, ok := g.externs[ident]
+		if !ok {
+			e = &extern{retTy: retTy}
+			g.externs[ident] = e
+		}
+
+		if call && !e.call {
+			e.call, e.retTy = true, retTy
+		}
+	}
+
+	for _, fd := range unit.FuncDefs {
+		for _, block := range fd.Blocks {
+			for _, instr := range block.Instructions {
+				var vals []*ir.Val
+
+				switch instr := instr.(type) {
+				case *ir.Call:
+					if ident, ok := global(instr.Val); ok {
+						use(ident, true, instr.RetTy)
+					} else {
+						vals = append(vals, instr.Val)
+					}
+
+					for _, arg := range instr.Args {
+						vals = append(vals, arg.Val)
+					}
+				case *ir.Binop:
+					vals = append(vals, instr.Lhs, instr.Rhs)
+				case *ir.Load:
+					vals = append(vals, instr.Addr)
+				case *ir.Store:
+					vals = append(vals, instr.Addr, instr.Val)
+				case *ir.Convert:
+					vals = append(vals, instr.Val)
+				case *ir.Jnz:
+					vals = append(vals, instr.Cond)
+				case *ir.Ret:
+					vals = append(vals, instr.Val)
+				case *ir.Phi:
+					for _, arg := range instr.Args {
+						vals = append(vals, arg.Val)
+					}
+				}
+
+				for _, val := range vals {
+					if ident, ok := global(val); ok {
+						use(ident, false, nil)
+					}
+				}
+			}
+		}
+	}
+
+	for _, dd := range unit.DataDefs {
+		for _, init := range dd.Initializer {
+			for _, item := range init.Items {
+				switch {
+				case item.Type == ir.DataItemSymbol:
+					use(item.Ident, false, nil)
+				case item.Type == ir.DataItemConst && item.Const.Type == ir.ConstIdent:
+					use(item.Const.Ident, false, nil)
+				}
+			}
+		}
+	}
+
+	for ident := range g.externs {
+		if !isIdent(string(ident)) {
+			return fmt.Errorf("extern symbol %s isn't a valid C identifier", ident)
+		}
+	}
+
+	return nil
+}
+
+// global returns the symbol val is the address of, if it is one.
+func global(val *ir.Val) (ir.Ident, bool) {
+	if val == nil || val.Type != ir.ValDynConst || val.DynConst.Type != ir.DynConstConst ||
+		val.DynConst.Const.Type != ir.ConstIdent {
+		return "", false
+	}
+
+	return val.DynConst.Const.Ident, true
+}
+
+// symbol returns the C name of a symbol.
+func (g *generator) symbol(ident ir.Ident) string {
+	if name, ok := g.names[ident]; ok {
+		return name
+	}
+
+	return string(ident)
+}
+
+// signature returns the declaration of a function without the body.
+func (g *generator) signature(fd *ir.FuncDef) string {
+	var sb strings.Builder
+
+	if fd.Linkage == nil {
+		sb.WriteString("static ")
+	}
+
+	fmt.Fprintf(&sb, "%s %s(", cType(fd.RetTy), g.symbol(fd.Ident))
+
+	if len(fd.Params) == 0 {
+		sb.WriteString("void")
+	}
+
+	for i, param := range fd.Params {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+
+		fmt.Fprintf(&sb, "%s %s", cType(&param.AbiTy), temp(param.Ident))
+	}
+
+	sb.WriteString(")")
+
+	return sb.String()
+}
+
+// cType returns the C type of a value of type ty, or void for no value.
+func cType(ty *ir.AbiTy) string {
+	switch {
+	case ty == nil:
+		return "void"
+	case long(*ty):
+		return "int64_t"
+	default:
+		return "int32_t"
+	}
+}
+
+// long reports whether a value of type ty takes 64 bits.
+func long(ty ir.AbiTy) bool {
+	return ty.Type == ir.AbiTyBase && ty.BaseTy == ir.BaseLong
+}
+
+// temp returns the C name of a temporary. Temporaries are prefixed, so they
+// don't clash with symbols or keywords.
+func temp(ident ir.Ident) string {
+	return "t_" + sanitize(string(ident))
+}
+
+// sanitize replaces the characters of s that can't be in a C identifier. A
+// trailing underscore is added to names that are changed, so they don't clash
+// with names that weren't.
+func sanitize(s string) string {
+	if isIdent(s) {
+		return s
+	}
+
+	var sb strings.Builder
+
+	for i, r := range s {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', i > 0 && r >= '0' && r <= '9':
+			sb.WriteRune(r)
+		default:
+			sb.WriteString("_")
+		}
+	}
+
+	sb.WriteString("_")
+
+	return sb.String()
+}
+
+// unique returns name, or name with a number if it's already used, and marks
+// it as used.
+func unique(used map[string]bool, name string) string {
+	candidate := name
+
+	for i := 1; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s_%d", name, i)
+	}
+
+	used[candidate] = true
+
+	return candidate
+}
+
+// keywords are the reserved words of C99, which can't be identifiers.
+var keywords = map[string]bool{
+	"auto": true, "break": true, "case": true, "char": true, "const": true, "continue": true,
+	"default": true, "do": true, "double": true, "else": true, "enum": true, "extern": true,
+	"float": true, "for": true, "goto": true, "if": true, "inline": true, "int": true,
+	"long": true, "register": true, "restrict": true, "return": true, "short": true,
+	"signed": true, "sizeof": true, "static": true, "struct": true, "switch": true,
+	"typedef": true, "union": true, "unsigned": true, "void": true, "volatile": true,
+	"while": true, "_Bool": true, "_Complex": true, "_Imaginary": true,
+}
+
+// isIdent reports whether s is a valid C identifier that doesn't clash with
+// the keywords or the helpers of the generated code.
+func isIdent(s string) bool {
+	if s == "" || keywords[s] || strings.HasPrefix(s, "cubit_") {
+		return false
+	}
+
+	for i, r := range s {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// value returns the C expression of a value.
+func (g *generator) value(val *ir.Val) (string, error) {
+	if val.AbiTy.IsFloat() {
+		return "", val.Loc.Errorf("floating point values aren't supported by the C backend")
+	}
+
+	switch {
+	case val.Type == ir.ValIdent:
+		return temp(val.Ident), nil
+	case val.DynConst.Type == ir.DynConstThread:
+		return "", val.Loc.Errorf("thread-local data isn't supported by the C backend")
+	case val.DynConst.Const.Type == ir.ConstIdent:
+		return fmt.Sprintf("(int64_t)(intptr_t)&%s", g.symbol(val.DynConst.Const.Ident)), nil
+	case val.DynConst.Const.Type == ir.ConstInteger:
+		return integer(val.DynConst.Const.I64, long(val.AbiTy)), nil
+	default:
+		return "", val.Loc.Errorf("constant %s isn't supported by the C backend", val.DynConst.Const.Type)
+	}
+}
+
+// integer returns the C expression of an integer constant.
+func integer(i int64, long bool) string {
+	switch {
+	case long && i == math.MinInt64:
+		return "INT64_MIN"
+	case long:
+		return fmt.Sprintf("INT64_C(%d)", i)
+	case int32(i) == math.MinInt32:
+		return "INT32_MIN"
+	default:
+		return fmt.Sprint(int32(i))
+	}
+}
@@ -0,0 +1,171 @@
+package csrc
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/corani/cubit/internal/ir/passes"
+	"github.com/corani/cubit/internal/testutil/irtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	unit := irtest.LowerWithPrintf(t, `
+@(export)
+main :: func() -> int {
+    printf("hello?\n")
+
+    return 0
+}`)
+
+	var out bytes.Buffer
+	require.NoError(t, Generate(&out, unit, Options{}))
+
+	src := out.String()
+	require.Contains(t, src, "#include <stdint.h>\n")
+	require.Contains(t, src, "extern void printf();\n")
+	require.Contains(t, src, "\nint32_t main(void) {\n")
+	require.Contains(t, src, "\t\"hello\\?\\012\",\n")
+	require.Contains(t, src, "\tprintf((int64_t)(intptr_t)&_str_0001);\n")
+}
+
+func TestGenerate_Run(t *testing.T) {
+	t.Parallel()
+
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc not found")
+	}
+
+	tt := []struct {
+		name     string
+		src      string
+		expected string
+		exit     int
+	}{
+		{
+			name: "recursion",
+			src: `
+fib :: func(n: int) -> int {
+    if n <= 1 {
+        return n
+    }
+
+    return fib(n - 1) + fib(n - 2)
+}
+
+@(export)
+main :: func() -> int {
+    for n := 1; n <= 5; n = n + 1 {
+        printf("fib(%d) = %d\n", n, fib(n))
+    }
+
+    return fib(10)
+}`,
+			expected: "fib(1) = 1\nfib(2) = 1\nfib(3) = 2\nfib(4) = 3\nfib(5) = 5\n",
+			exit:     55,
+		},
+		{
+			name: "phis on both edges",
+			src: `
+@(export)
+main :: func() -> int {
+    a := 0
+    b := 0
+
+    for i := 0; i < 10; i = i + 1 {
+        if i % 3 == 0 || i % 5 == 0 {
+            a = a + i
+        } else {
+            b = b + i
+        }
+    }
+
+    printf("%d %d\n", a, b)
+
+    return 0
+}`,
+			expected: "23 22\n",
+		},
+		{
+			name: "arrays and stack arguments",
+			src: `
+sum :: func(a: int, b: int, c: int, d: int, e: int, f: int, g: int, h: int) -> int {
+    return a + b + c + d + e + f + g * 10 + h * 100
+}
+
+@(export)
+main :: func() -> int {
+    xs := [4]int{}
+    for i := 0; i < 4; i = i + 1 {
+        xs[i] = i * i
+    }
+
+    printf("%d %d\n", xs[3], sum(1, 2, 3, 4, 5, 6, 7, 8))
+
+    return 0
+}`,
+			expected: "9 891\n",
+		},
+		{
+			name: "overloads",
+			src: `
+twice :: func(x: int) -> int {
+    return x * 2
+}
+
+twice :: func(x: int, y: int) -> int {
+    return (x + y) * 2
+}
+
+@(export)
+main :: func() -> int {
+    x := 21
+    printf("%d %d\n", twice(x), twice(x, 0))
+
+    return 0
+}`,
+			expected: "42 42\n",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			unit := irtest.LowerWithPrintf(t, tc.src)
+			require.NoError(t, passes.Run(unit, passes.Default(passes.Options{})...))
+			unit.RemoveDeadFuncs()
+
+			var out bytes.Buffer
+			require.NoError(t, Generate(&out, unit, Options{DebugInfo: true}))
+
+			dir := t.TempDir()
+			src := filepath.Join(dir, "test.c")
+			bin := filepath.Join(dir, "test")
+
+			require.NoError(t, os.WriteFile(src, out.Bytes(), 0o644))
+
+			build, err := exec.Command(cc, "-std=c99", "-fno-builtin", "-Wall", "-Werror", "-o", bin, src).CombinedOutput()
+			require.NoError(t, err, string(build))
+
+			stdout, err := exec.Command(bin).Output()
+
+			var exit *exec.ExitError
+			if errors.As(err, &exit) {
+				require.Equal(t, tc.exit, exit.ExitCode())
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, 0, tc.exit)
+			}
+
+			require.Equal(t, tc.expected, string(stdout))
+		})
+	}
+}
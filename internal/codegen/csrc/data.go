@@ -0,0 +1,174 @@
+package csrc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// field is a member of the struct that holds the data of a data definition.
+type field struct {
+	decl string // the declaration of the field, without the semicolon
+	init string // the initializer of the field
+	size int
+}
+
+// fields returns the members of the struct of dd, one for every item. C pads
+// members to their alignment and the IR doesn't, so data with an item that
+// isn't aligned can't be expressed.
+func (g *generator) fields(dd ir.DataDef) ([]field, error) {
+	var fields []field
+
+	add := func(loc lexer.Location, ty string, n int, init string, size int) error {
+		offset := 0
+		for _, f := range fields {
+			offset += f.size
+		}
+
+		if offset%size != 0 {
+			return loc.Errorf("data $%s has an item at offset %d that isn't aligned, which the C backend doesn't support",
+				dd.Ident, offset)
+		}
+
+		decl := fmt.Sprintf("%s f%d", ty, len(fields))
+		if n > 0 {
+			decl = fmt.Sprintf("%s f%d[%d]", ty, len(fields), n)
+		}
+
+		fields = append(fields, field{decl: decl, init: init, size: size * max(n, 1)})
+
+		return nil
+	}
+
+	types := map[int]string{1: "uint8_t", 2: "uint16_t", 4: "uint32_t", 8: "uint64_t"}
+
+	for _, init := range dd.Initializer {
+		if init.Type == ir.DataInitZero {
+			if err := add(init.Loc, "unsigned char", max(init.Size, 1), "{0}", 1); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		size := init.ExtTy.Size()
+		if init.ExtTy == ir.ExtSingle || init.ExtTy == ir.ExtDouble {
+			return nil, init.Loc.Errorf("floating point data isn't supported by the C backend")
+		}
+
+		for _, item := range init.Items {
+			var err error
+
+			switch {
+			case item.Type == ir.DataItemString:
+				s := ir.Unescape(item.StringVal)
+				err = add(item.Loc, "unsigned char", max(len(s), 1), fmt.Sprintf("\"%s\"", escape(s)), 1)
+			case item.Type == ir.DataItemSymbol && item.Offset != 0:
+				err = add(item.Loc, "void *", 0, fmt.Sprintf("(char *)&%s + %d", g.symbol(item.Ident), item.Offset), size)
+			case item.Type == ir.DataItemSymbol:
+				err = add(item.Loc, "void *", 0, fmt.Sprintf("(void *)&%s", g.symbol(item.Ident)), size)
+			case item.Const.Type == ir.ConstIdent:
+				err = add(item.Loc, "void *", 0, fmt.Sprintf("(void *)&%s", g.symbol(item.Const.Ident)), size)
+			case item.Const.Type == ir.ConstInteger:
+				value := uint64(item.Const.I64)
+				if size < 8 {
+					value &= 1<<(8*size) - 1
+				}
+
+				err = add(item.Loc, types[size], 0, fmt.Sprintf("%dU", value), size)
+			default:
+				err = item.Loc.Errorf("constant %s isn't supported by the C backend", item.Const.Type)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return fields, nil
+}
+
+// dataType declares the struct of a data definition and the data itself, so
+// functions and other data can refer to it before it's defined.
+func (g *generator) dataType(dd ir.DataDef) error {
+	if dd.Align > 8 {
+		return dd.Loc.Errorf("data $%s is aligned to %d bytes, the C backend aligns to 8", dd.Ident, dd.Align)
+	}
+
+	fields, err := g.fields(dd)
+	if err != nil {
+		return err
+	}
+
+	name := g.symbol(dd.Ident)
+
+	fmt.Fprintf(&g.out, "\nstruct cubit_%s {\n", name)
+
+	for _, f := range fields {
+		fmt.Fprintf(&g.out, "\t%s;\n", f.decl)
+	}
+
+	if len(fields) == 0 {
+		g.out.WriteString("\tuint8_t f0;\n")
+	}
+
+	fmt.Fprintf(&g.out, "};\n%sstruct cubit_%s %s;\n", storage(dd.Linkage), name, name)
+
+	return nil
+}
+
+func (g *generator) data(dd ir.DataDef) error {
+	fields, err := g.fields(dd)
+	if err != nil {
+		return err
+	}
+
+	inits := make([]string, len(fields))
+	for i, f := range fields {
+		inits[i] = f.init
+	}
+
+	if len(inits) == 0 {
+		inits = append(inits, "0")
+	}
+
+	name := g.symbol(dd.Ident)
+
+	fmt.Fprintf(&g.out, "\n/* %s */\n%sstruct cubit_%s %s = {\n\t%s,\n};\n",
+		dd.Loc, storage(dd.Linkage), name, name, strings.Join(inits, ",\n\t"))
+
+	return nil
+}
+
+// storage returns the storage class of a symbol with the given linkage.
+func storage(linkage *ir.Linkage) string {
+	if linkage == nil {
+		return "static "
+	}
+
+	return ""
+}
+
+// escape escapes a string for a C string literal: printable characters are
+// kept, all others are written in octal. Question marks are escaped, so they
+// can't start a trigraph.
+func escape(s string) string {
+	var sb strings.Builder
+
+	for _, b := range []byte(s) {
+		switch {
+		case b == '"' || b == '\\' || b == '?':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case b >= ' ' && b <= '~':
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "\\%03o", b)
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,382 @@
+package csrc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/corani/cubit/internal/ir"
+)
+
+// function is the function being generated.
+type function struct {
+	*generator
+
+	fd      *ir.FuncDef
+	labels  map[string]string // block label -> C label
+	targets map[string]bool   // labels of the blocks that are jumped to
+}
+
+// emit writes a statement.
+func (fn *function) emit(format string, args ...any) {
+	fmt.Fprintf(&fn.out, "\t"+format+"\n", args...)
+}
+
+// incoming returns the C name of the variable the predecessors of a phi
+// assign its argument to.
+func incoming(phi ir.Ident) string {
+	return "in_" + sanitize(string(phi))
+}
+
+// slot returns the C name of the memory of a stack slot.
+func slot(ident ir.Ident) string {
+	return "s_" + sanitize(string(ident))
+}
+
+func (g *generator) function(fd *ir.FuncDef) error {
+	fn := &function{generator: g, fd: fd, labels: make(map[string]string), targets: make(map[string]bool)}
+	used := make(map[string]bool)
+
+	for _, block := range fd.Blocks {
+		fn.labels[block.Label] = unique(used, "L_"+sanitize(block.Label))
+
+		switch term := block.Terminator().(type) {
+		case *ir.Jmp:
+			fn.targets[term.Label] = true
+		case *ir.Jnz:
+			fn.targets[term.True] = true
+			fn.targets[term.False] = true
+		}
+	}
+
+	for _, param := range fd.Params {
+		if param.Type != ir.ParamRegular {
+			return param.Loc.Errorf("%s parameters aren't supported by the C backend", param.Type)
+		}
+	}
+
+	fmt.Fprintf(&g.out, "\n/* %s */\n%s {\n", fd.Loc, g.signature(fd))
+
+	if err := fn.locals(); err != nil {
+		return err
+	}
+
+	line := 0
+
+	for _, block := range fd.Blocks {
+		if fn.targets[block.Label] {
+			fmt.Fprintf(&g.out, "%s:;\n", fn.labels[block.Label])
+		}
+
+		for _, instr := range block.Instructions {
+			if loc := instr.Location(); g.opts.DebugInfo && loc.Line > 0 && loc.Line != line {
+				line = loc.Line
+				fmt.Fprintf(&g.out, "#line %d %q\n", line, loc.Filename)
+			}
+
+			if err := fn.instruction(&block, instr); err != nil {
+				return err
+			}
+		}
+	}
+
+	g.out.WriteString("}\n")
+
+	return nil
+}
+
+// locals declares the temporaries and the stack slots of the function. They
+// are all declared at the start, so a goto never jumps into their scope.
+func (fn *function) locals() error {
+	declared := make(map[string]bool)
+
+	declare := func(ty *ir.AbiTy, name string) {
+		if !declared[name] {
+			declared[name] = true
+			fn.emit("%s %s;", cType(ty), name)
+		}
+	}
+
+	for _, param := range fn.fd.Params {
+		declared[temp(param.Ident)] = true
+	}
+
+	for _, block := range fn.fd.Blocks {
+		for _, instr := range block.Instructions {
+			switch instr := instr.(type) {
+			case *ir.Alloc:
+				size, ok := instr.Size.IntConst()
+				if !ok {
+					return instr.Loc.Errorf("stack slot %%%s has a size that isn't constant", instr.Ret.Ident)
+				}
+
+				if instr.Align > 8 {
+					return instr.Loc.Errorf("stack slot %%%s is aligned to %d bytes, the C backend aligns to 8",
+						instr.Ret.Ident, instr.Align)
+				}
+
+				fn.emit("uint64_t %s[%d];", slot(instr.Ret.Ident), max((size+7)/8, 1))
+				declare(&instr.Ret.AbiTy, temp(instr.Ret.Ident))
+			case *ir.Phi:
+				declare(&instr.Ret.AbiTy, temp(instr.Ret.Ident))
+				declare(&instr.Ret.AbiTy, incoming(instr.Ret.Ident))
+			case *ir.Binop:
+				declare(&instr.Ret.AbiTy, temp(instr.Ret.Ident))
+			case *ir.Load:
+				declare(&instr.Ret.AbiTy, temp(instr.Ret.Ident))
+			case *ir.Convert:
+				declare(&instr.Ret.AbiTy, temp(instr.Ret.Ident))
+			case *ir.Call:
+				if instr.LHS != nil && instr.RetTy != nil {
+					declare(instr.RetTy, temp(*instr.LHS))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// values returns the C expressions of vals.
+func (fn *function) values(vals ...*ir.Val) ([]string, error) {
+	exprs := make([]string, len(vals))
+
+	for i, val := range vals {
+		expr, err := fn.value(val)
+		if err != nil {
+			return nil, err
+		}
+
+		exprs[i] = expr
+	}
+
+	return exprs, nil
+}
+
+func (fn *function) instruction(block *ir.Block, instr ir.Instruction) error {
+	switch instr := instr.(type) {
+	case *ir.Phi:
+		// The predecessors assigned the argument to the incoming variable.
+		fn.emit("%s = %s;", temp(instr.Ret.Ident), incoming(instr.Ret.Ident))
+	case *ir.Jmp:
+		if err := fn.moves(block, instr.Label, "\t"); err != nil {
+			return err
+		}
+
+		fn.emit("goto %s;", fn.labels[instr.Label])
+	case *ir.Jnz:
+		cond, err := fn.value(instr.Cond)
+		if err != nil {
+			return err
+		}
+
+		fn.emit("if (%s) {", cond)
+
+		if err := fn.moves(block, instr.True, "\t\t"); err != nil {
+			return err
+		}
+
+		fn.emit("\tgoto %s;", fn.labels[instr.True])
+		fn.emit("}")
+
+		if err := fn.moves(block, instr.False, "\t"); err != nil {
+			return err
+		}
+
+		fn.emit("goto %s;", fn.labels[instr.False])
+	case *ir.Ret:
+		if instr.Val == nil {
+			fn.emit("return;")
+
+			return nil
+		}
+
+		val, err := fn.value(instr.Val)
+		if err != nil {
+			return err
+		}
+
+		fn.emit("return %s;", val)
+	case *ir.Hlt:
+		fn.emit("cubit_trap();")
+	case *ir.Alloc:
+		fn.emit("%s = (int64_t)(intptr_t)%s;", temp(instr.Ret.Ident), slot(instr.Ret.Ident))
+	case *ir.Binop:
+		return fn.binop(instr)
+	case *ir.Load:
+		addr, err := fn.value(instr.Addr)
+		if err != nil {
+			return err
+		}
+
+		fn.emit("%s = (%s)cubit_load_%s(%s);", temp(instr.Ret.Ident), cType(&instr.Ret.AbiTy),
+			memTy(instr.Ty), addr)
+	case *ir.Store:
+		vals, err := fn.values(instr.Addr, instr.Val)
+		if err != nil {
+			return err
+		}
+
+		size := map[string]string{"ub": "b", "sb": "b", "uh": "h", "sh": "h", "w": "w", "l": "l"}[memTy(instr.Ty)]
+		fn.emit("cubit_store_%s(%s, %s);", size, vals[0], vals[1])
+	case *ir.Convert:
+		val, err := fn.value(instr.Val)
+		if err != nil {
+			return err
+		}
+
+		from := map[string]string{
+			"ub": "uint8_t", "sb": "int8_t", "uh": "uint16_t", "sh": "int16_t", "w": "int32_t", "l": "int64_t",
+		}[memTy(instr.Val.AbiTy)]
+
+		fn.emit("%s = (%s)(%s)%s;", temp(instr.Ret.Ident), cType(&instr.Ret.AbiTy), from, val)
+	case *ir.Call:
+		return fn.call(instr)
+	default:
+		return fmt.Errorf("%s: instruction %T isn't supported by the C backend", instr.Location(), instr)
+	}
+
+	return nil
+}
+
+// moves assigns the arguments the phis of the block labeled to receive from
+// block to their incoming variables, with the given indentation.
+func (fn *function) moves(block *ir.Block, to, indent string) error {
+	for _, b := range fn.fd.Blocks {
+		if b.Label != to {
+			continue
+		}
+
+		for _, instr := range b.Instructions {
+			phi, ok := instr.(*ir.Phi)
+			if !ok {
+				break
+			}
+
+			for _, arg := range phi.Args {
+				if arg.Label != block.Label {
+					continue
+				}
+
+				val, err := fn.value(arg.Val)
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(&fn.out, "%s%s = %s;\n", indent, incoming(phi.Ret.Ident), val)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (fn *function) binop(b *ir.Binop) error {
+	vals, err := fn.values(b.Lhs, b.Rhs)
+	if err != nil {
+		return err
+	}
+
+	lhs, rhs := vals[0], vals[1]
+	ret := temp(b.Ret.Ident)
+
+	// Comparisons operate on the type of their operands, all other operations
+	// on the type of their result.
+	ty := b.Ret.AbiTy
+	if b.Op.IsComparison() {
+		ty = b.Lhs.AbiTy
+	}
+
+	signed, unsigned, bits := "int32_t", "uint32_t", 32
+	if long(ty) {
+		signed, unsigned, bits = "int64_t", "uint64_t", 64
+	}
+
+	ops := map[ir.BinOpKind]string{
+		ir.BinOpAdd: "+", ir.BinOpSub: "-", ir.BinOpMul: "*", ir.BinOpAnd: "&", ir.BinOpOr: "|",
+		ir.BinOpDiv: "/", ir.BinOpUDiv: "/", ir.BinOpMod: "%", ir.BinOpUMod: "%",
+		ir.BinOpShl: "<<", ir.BinOpShr: ">>",
+		ir.BinOpEq: "==", ir.BinOpNe: "!=",
+		ir.BinOpLt: "<", ir.BinOpULt: "<", ir.BinOpLe: "<=", ir.BinOpULe: "<=",
+		ir.BinOpGt: ">", ir.BinOpUGt: ">", ir.BinOpGe: ">=", ir.BinOpUGe: ">=",
+	}
+
+	op, ok := ops[b.Op]
+	if !ok {
+		return b.Loc.Errorf("unknown binop: %s", b.Op)
+	}
+
+	switch b.Op {
+	case ir.BinOpDiv, ir.BinOpMod, ir.BinOpEq, ir.BinOpNe, ir.BinOpLt, ir.BinOpLe, ir.BinOpGt, ir.BinOpGe:
+		// Signed operations that can't overflow, except for dividing the
+		// smallest value by -1, which traps like on most machines.
+		fn.emit("%s = (%s)%s %s (%s)%s;", ret, signed, lhs, op, signed, rhs)
+	case ir.BinOpULt, ir.BinOpULe, ir.BinOpUGt, ir.BinOpUGe:
+		fn.emit("%s = (%s)%s %s (%s)%s;", ret, unsigned, lhs, op, unsigned, rhs)
+	case ir.BinOpShl, ir.BinOpShr:
+		// Shifting by the width or more is undefined in C, the IR uses the
+		// low bits of the amount.
+		fn.emit("%s = (%s)((%s)%s %s (%s & %d));", ret, signed, unsigned, lhs, op, rhs, bits-1)
+	default:
+		fn.emit("%s = (%s)((%s)%s %s (%s)%s);", ret, signed, unsigned, lhs, op, unsigned, rhs)
+	}
+
+	return nil
+}
+
+func (fn *function) call(c *ir.Call) error {
+	var args []string
+
+	for _, arg := range c.Args {
+		switch arg.Type {
+		case ir.ArgRegular:
+			val, err := fn.value(arg.Val)
+			if err != nil {
+				return err
+			}
+
+			args = append(args, val)
+		case ir.ArgVariadic:
+			// Extern functions are declared without a prototype, so variadic
+			// arguments are passed like the others.
+		default:
+			return arg.Loc.Errorf("%s arguments aren't supported by the C backend", arg.Type)
+		}
+	}
+
+	callee := ""
+
+	if ident, ok := global(c.Val); ok {
+		callee = fn.symbol(ident)
+	} else {
+		val, err := fn.value(c.Val)
+		if err != nil {
+			return err
+		}
+
+		callee = fmt.Sprintf("((%s (*)())(intptr_t)%s)", cType(c.RetTy), val)
+	}
+
+	call := fmt.Sprintf("%s(%s)", callee, strings.Join(args, ", "))
+
+	if c.LHS != nil && c.RetTy != nil {
+		fn.emit("%s = %s;", temp(*c.LHS), call)
+	} else {
+		fn.emit("%s;", call)
+	}
+
+	return nil
+}
+
+// memTy returns the name of an integer type: "ub", "sb", "uh", "sh", "w" or
+// "l".
+func memTy(ty ir.AbiTy) string {
+	if ty.Type == ir.AbiTySubW {
+		return string(ty.SubWTy)
+	}
+
+	if long(ty) {
+		return "l"
+	}
+
+	return "w"
+}
@@ -0,0 +1,147 @@
+package csrc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/corani/cubit/internal/ast"
+)
+
+// Header writes a C header that declares the exported functions of unit, so C
+// code can call them in a shared library. guard names the include guard, and
+// is upper-cased and sanitized.
+//
+// By value, bools are passed as words like in the IR, so they're declared as
+// int32_t. In memory they take a byte, so a pointer to a bool is a bool *.
+func Header(w io.Writer, unit *ast.CompilationUnit, guard string) error {
+	count := make(map[string]int)
+	for _, fd := range unit.Funcs {
+		count[fd.Ident]++
+	}
+
+	var decls []string
+
+	for _, fd := range unit.Funcs {
+		if !fd.Attributes.Has(ast.AttrKeyExport) {
+			continue
+		}
+
+		if count[fd.Ident] > 1 {
+			return fd.Location().Errorf("exported function %s is overloaded, so it has no C name", fd.Ident)
+		}
+
+		if !isIdent(fd.Ident) {
+			return fd.Location().Errorf("exported symbol %s isn't a valid C identifier", fd.Ident)
+		}
+
+		decl, err := prototype(fd)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range strings.Split(fd.Doc.Text(), "\n") {
+			if line != "" {
+				decls = append(decls, "// "+line)
+			}
+		}
+
+		decls = append(decls, decl+";")
+	}
+
+	guard = "CUBIT_" + strings.ToUpper(sanitize(guard)) + "_H"
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "/* Code generated by cubit from package %s. DO NOT EDIT. */\n\n", unit.Ident)
+	fmt.Fprintf(bw, "#ifndef %s\n#define %s\n\n", guard, guard)
+	fmt.Fprintf(bw, "#include <stdbool.h>\n#include <stdint.h>\n\n")
+	fmt.Fprintf(bw, "#ifdef __cplusplus\nextern \"C\" {\n#endif\n\n")
+
+	for _, decl := range decls {
+		fmt.Fprintln(bw, decl)
+	}
+
+	if len(decls) > 0 {
+		fmt.Fprintln(bw)
+	}
+
+	fmt.Fprintf(bw, "#ifdef __cplusplus\n}\n#endif\n\n#endif /* %s */\n", guard)
+
+	return bw.Flush()
+}
+
+// prototype returns the C declaration of fd.
+func prototype(fd *ast.FuncDef) (string, error) {
+	ret := "void"
+
+	if fd.ReturnType != nil && fd.ReturnType.Kind != ast.TypeVoid {
+		var err error
+		if ret, err = headerType(fd.ReturnType, false); err != nil {
+			return "", fd.Location().Errorf("return type of %s: %v", fd.Ident, err)
+		}
+	}
+
+	var params []string
+
+	for _, param := range fd.Params {
+		if param.Type != nil && param.Type.Kind == ast.TypeVararg {
+			params = append(params, "...")
+
+			continue
+		}
+
+		ty, err := headerType(param.Type, false)
+		if err != nil {
+			return "", param.Loc.Errorf("parameter %s of %s: %v", param.Ident, fd.Ident, err)
+		}
+
+		if isIdent(param.Ident) {
+			ty = strings.ReplaceAll(ty+" "+param.Ident, "* ", "*")
+		}
+
+		params = append(params, ty)
+	}
+
+	if len(params) == 0 {
+		params = append(params, "void")
+	}
+
+	return fmt.Sprintf("%s %s(%s)", ret, fd.Ident, strings.Join(params, ", ")), nil
+}
+
+// headerType returns the C type of a value of type ty, which is in memory if
+// mem is set.
+func headerType(ty *ast.Type, mem bool) (string, error) {
+	if ty == nil {
+		return "", fmt.Errorf("missing type")
+	}
+
+	switch ty.Kind {
+	case ast.TypeInt:
+		return "int32_t", nil
+	case ast.TypeBool:
+		if mem {
+			return "bool", nil
+		}
+
+		return "int32_t", nil
+	case ast.TypeString:
+		return "const char *", nil
+	case ast.TypePointer, ast.TypeArray:
+		// Arrays are passed by their address.
+		elem, err := headerType(ty.Elem, true)
+		if err != nil {
+			return "", err
+		}
+
+		if strings.HasSuffix(elem, "*") {
+			return elem + "*", nil
+		}
+
+		return elem + " *", nil
+	default:
+		return "", fmt.Errorf("type %s can't be used from C", ty)
+	}
+}
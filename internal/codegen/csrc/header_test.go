@@ -0,0 +1,83 @@
+package csrc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/corani/cubit/internal/typecheck"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeader(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		src      string
+		expected []string
+		err      string
+	}{
+		{
+			name: "exported functions",
+			src: `
+// square returns x squared.
+@(export)
+square :: func(x: int) -> int {
+    return x * x
+}
+
+@(export)
+greet :: func(name: string, flags: ^bool, names: ^string, done: bool) {
+}
+
+helper :: func() -> int {
+    return 1
+}`,
+			expected: []string{
+				"#ifndef CUBIT_LIB_H\n#define CUBIT_LIB_H\n",
+				"// square returns x squared.\nint32_t square(int32_t x);\n",
+				"void greet(const char *name, bool *flags, const char **names, int32_t done);\n",
+			},
+		},
+		{
+			name: "overloaded",
+			src: `
+@(export, link_name="f_int")
+f :: func(x: int) -> int {
+    return x
+}
+
+f :: func(s: string) -> int {
+    return 0
+}`,
+			err: "test.in:4:1: exported function f is overloaded, so it has no C name",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			unit := testutil.Parse(t, "test.in", "package main\n"+tc.src)
+			require.NoError(t, typecheck.Check(unit))
+
+			var out bytes.Buffer
+
+			err := Header(&out, unit, "lib")
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			for _, s := range tc.expected {
+				require.Contains(t, out.String(), s)
+			}
+
+			require.NotContains(t, out.String(), "helper")
+		})
+	}
+}
@@ -5,49 +5,363 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"runtime"
+	"path/filepath"
 	"strings"
 
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/codegen/csrc"
+	"github.com/corani/cubit/internal/codegen/native"
 	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/ir/bytecode"
+	"github.com/corani/cubit/internal/target"
 	"modernc.org/libqbe"
 )
 
-// WriteSSA writes the SSA code for the given CompilationUnit to the specified filename.
-func WriteSSA(unit *ir.CompilationUnit, filename string) error {
+// Backend generates assembly from the SSA code.
+type Backend string
+
+const (
+	// BackendQBE generates assembly with QBE, which is built in.
+	BackendQBE Backend = "qbe"
+	// BackendNative generates assembly directly from the IR. The code is
+	// slower, but doesn't depend on QBE.
+	BackendNative Backend = "native"
+	// BackendC generates C99 instead of assembly, which is built with the C
+	// compiler.
+	BackendC Backend = "c"
+	// BackendBytecode generates bytecode instead of assembly, which runs in
+	// the bytecode VM without a native toolchain.
+	BackendBytecode Backend = "bytecode"
+)
+
+// DefaultBackend returns the backend for code for t: BackendQBE, or BackendC
+// for targets QBE doesn't support.
+func DefaultBackend(t target.Target) Backend {
+	if BackendQBE.Check(t) != nil {
+		return BackendC
+	}
+
+	return BackendQBE
+}
+
+// Check returns an error if the backend can't generate code for t. QBE and
+// the native backend generate code for 64-bit machines, the bytecode needs
+// 64-bit pointers, and the C backend supports every target.
+func (b Backend) Check(t target.Target) error {
+	if t.Arch == "" {
+		t = target.Host()
+	}
+
+	var ok bool
+
+	switch b {
+	case BackendQBE:
+		ok = t.Arch == "amd64" || t.Arch == "arm64" || t.Arch == "riscv64"
+	case BackendNative:
+		// The native backend writes ELF directives.
+		ok = (t.Arch == "amd64" || t.Arch == "arm64" || t.Arch == "riscv64") && (t.OS == "linux" || t.OS == "freebsd")
+	case BackendBytecode:
+		ok = t.PtrSize() == 8
+	case BackendC:
+		ok = true
+	default:
+		return fmt.Errorf("unknown backend %s", b)
+	}
+
+	if !ok {
+		return fmt.Errorf("no %s backend for %s", b, t)
+	}
+
+	return nil
+}
+
+// Ext returns the extension of the files the backend generates.
+func (b Backend) Ext() string {
+	switch b {
+	case BackendC:
+		return ".c"
+	case BackendBytecode:
+		return ".cbc"
+	default:
+		return ".s"
+	}
+}
+
+// Options control the generated code.
+type Options struct {
+	// DebugInfo emits the source file and lines of the code, so the assembly
+	// has line tables for debuggers.
+	DebugInfo bool
+	// Backend generates the assembly. The default is the DefaultBackend of
+	// the target.
+	Backend Backend
+	// Target is the machine to generate code for. The default is the host.
+	// The IR must be lowered for the same target.
+	Target target.Target
+	// Object writes a relocatable ELF object instead of assembly, so no
+	// assembler is needed. Only BackendNative supports it.
+	Object bool
+	// Shared links a shared library instead of an executable. The backends
+	// address data relative to the program counter and call functions
+	// through the PLT, so their code is position-independent already.
+	Shared bool
+	// Annotate interleaves the source lines of the code as comments in the
+	// assembly or C source, which implies DebugInfo. Bytecode and objects
+	// can't be annotated.
+	Annotate bool
+	// StackProtector compiles C source with the stack protector of the C
+	// compiler. The C compiler lays out the stack slots, so the canary the
+	// IR puts in the first slot may not end up next to the return address.
+	StackProtector bool
+	// Freestanding links the program without the C library and its start
+	// code, for kernels and bare-metal boards. The IR must have an entry
+	// point, see ir.CompilationUnit.WithStart.
+	Freestanding bool
+	// LinkerScript is the linker script a freestanding program is linked
+	// with, which places its sections in the memory of the board. The default
+	// is the one of the linker.
+	LinkerScript string
+	// OptLevel is the optimization level the C compiler compiles C source
+	// with, like 2 for -O2 or s for -Os; empty for its default. Assembly is
+	// only assembled, so it's the same at every level.
+	OptLevel string
+	// OmitFramePointer lets the C compiler use the frame pointer as a
+	// general register in C source with an OptLevel. The native and QBE
+	// backends always keep it, as they address the stack relative to it.
+	OmitFramePointer bool
+	// Jobs is the number of functions the native and the C backend generate
+	// at once, GOMAXPROCS if it's 0. QBE and the bytecode backend generate
+	// one at a time.
+	Jobs int
+}
+
+// target returns the target of opts, with the host for the default.
+func (opts Options) target() target.Target {
+	if opts.Target.Arch == "" {
+		return target.Host()
+	}
+
+	return opts.Target
+}
+
+// backend returns the backend of opts, with the default for its target.
+func (opts Options) backend() Backend {
+	if opts.Backend == "" {
+		return DefaultBackend(opts.target())
+	}
+
+	return opts.Backend
+}
+
+func newVisitor(opts Options) *SsaGen {
 	visitor := NewSSAVisitor()
+	if opts.DebugInfo {
+		visitor = visitor.WithDebugInfo()
+	}
+
+	return visitor
+}
+
+// WriteSSA writes the SSA code for the given CompilationUnit to the specified filename,
+// with the source lines the code comes from as comments.
+func WriteSSA(unit *ir.CompilationUnit, filename string, opts Options) error {
+	visitor := newVisitor(opts).WithSource()
 	ssa := unit.Accept(visitor)
 
 	return os.WriteFile(filename, []byte(ssa), 0644)
 }
 
-// GenerateAssembly generates assembly from the given CompilationUnit.
-func GenerateAssembly(srcfile string, unit *ir.CompilationUnit, asmfile string) error {
-	visitor := NewSSAVisitor()
-	ssa := visitor.VisitCompilationUnit(unit)
+// SprintSSA returns the SSA code for unit, without the source lines, e.g. to
+// dump the IR.
+func SprintSSA(unit *ir.CompilationUnit, opts Options) string {
+	return unit.Accept(newVisitor(opts))
+}
+
+// SprintFuncSSA returns the SSA code for fd, e.g. to dump the IR of a function
+// between passes.
+func SprintFuncSSA(fd *ir.FuncDef, opts Options) string {
+	return fd.Accept(newVisitor(opts))
+}
 
+// WriteHeader writes a C header that declares the exported functions of unit
+// to the specified filename, for C code that links the shared library. guard
+// names its include guard.
+func WriteHeader(unit *ast.CompilationUnit, filename, guard string) error {
 	var w bytes.Buffer
 
-	// TODO(daniel): make these flags, so you can generate assembly for different targets?
-	// That may not work with `Compile` below though.
-	goos := runtime.GOOS
-	if goos == "android" {
-		goos = "linux" // For Termux support on Android
+	if err := csrc.Header(&w, unit, guard); err != nil {
+		return err
 	}
 
+	return os.WriteFile(filename, w.Bytes(), 0644)
+}
+
+// GenerateAssembly generates assembly from the given CompilationUnit, C
+// source with BackendC, bytecode with BackendBytecode, or an object with
+// Options.Object.
+func GenerateAssembly(srcfile string, unit *ir.CompilationUnit, asmfile string, opts Options) error {
+	var w bytes.Buffer
+
+	target := opts.target()
+	backend := opts.backend()
+
+	if err := backend.Check(target); err != nil {
+		return err
+	}
+
+	if opts.Object && backend != BackendNative {
+		return fmt.Errorf("the %s backend doesn't write objects", backend)
+	}
+
+	if opts.Annotate && (opts.Object || backend == BackendBytecode) {
+		return fmt.Errorf("only assembly and C source can be annotated")
+	}
+
+	// The source lines are found through the line information.
+	opts.DebugInfo = opts.DebugInfo || opts.Annotate
+
+	// write writes the code, annotated with comments that start with
+	// comment if requested.
+	write := func(comment string) error {
+		code := w.Bytes()
+		if opts.Annotate {
+			code = Annotate(code, comment)
+		}
+
+		return os.WriteFile(asmfile, code, 0644)
+	}
+
+	// Assemblers for arm64 only take # at the start of a line.
+	comment := "#"
+	if target.Arch == "arm64" {
+		comment = "//"
+	}
+
+	if backend == BackendBytecode {
+		prog, err := bytecode.Compile(unit)
+		if err != nil {
+			return err
+		}
+
+		if err := prog.Encode(&w); err != nil {
+			return err
+		}
+
+		return os.WriteFile(asmfile, w.Bytes(), 0644)
+	}
+
+	if backend == BackendC {
+		if err := csrc.Generate(&w, unit, csrc.Options{DebugInfo: opts.DebugInfo, Jobs: opts.Jobs}); err != nil {
+			return err
+		}
+
+		return write("//")
+	}
+
+	if backend == BackendNative {
+		generate := native.Generate
+		if opts.Object {
+			generate = native.Object
+		}
+
+		if err := generate(&w, unit, target.Arch, native.Options{DebugInfo: opts.DebugInfo, Jobs: opts.Jobs}); err != nil {
+			return err
+		}
+
+		return write(comment)
+	}
+
+	for _, fd := range unit.FuncDefs {
+		if fd.CallConv != ir.CallConvC {
+			return fd.Loc.Errorf("%s calling convention of %s isn't supported by the qbe backend", fd.CallConv, fd.Ident)
+		}
+	}
+
+	visitor := newVisitor(opts)
+	ssa := visitor.VisitCompilationUnit(unit)
+
 	if err := libqbe.Main(
-		libqbe.DefaultTarget(goos, runtime.GOARCH),
+		libqbe.DefaultTarget(target.OS, target.Arch),
 		srcfile, strings.NewReader(ssa), &w, nil,
 	); err != nil {
 		return err
 	}
 
-	return os.WriteFile(asmfile, w.Bytes(), 0644)
+	return write(comment)
 }
 
-func Compile(asm, bin string) error {
-	if out, err := exec.Command("cc", "-o", bin, asm).CombinedOutput(); err != nil {
-		return fmt.Errorf("cc failed: %s: %w", string(out), err)
+// Compile assembles and links asm into bin, compiles it if it's C source, or
+// only links it if it's an object. The C compiler drives the linker, so the
+// program gets the startup objects and the C library, and the libraries are
+// linked after asm. With Options.Shared, bin is a shared library, and with
+// Options.Freestanding, a program without either. Code for
+// another machine than the host is built with the
+// cross compiler of the target, <triple>-gcc, and WebAssembly with clang.
+func Compile(asm, bin string, libraries []string, opts Options) error {
+	cc, args := compileCommand(asm, bin, libraries, opts)
+
+	if out, err := exec.Command(cc, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %s: %w", cc, string(out), err)
 	}
 
 	return nil
 }
+
+// compileCommand returns the C compiler that Compile runs, and its arguments.
+func compileCommand(asm, bin string, libraries []string, opts Options) (string, []string) {
+	target := opts.target()
+
+	cc := "cc"
+	args := []string{"-o", bin, asm}
+
+	if opts.Shared {
+		args = append([]string{"-shared", "-fPIC"}, args...)
+	}
+
+	if opts.Freestanding {
+		// The program is linked at a fixed address, without the start code
+		// and the libraries of the C compiler.
+		args = append([]string{"-nostdlib", "-static"}, args...)
+
+		if opts.LinkerScript != "" {
+			args = append(args, "-T", opts.LinkerScript)
+		}
+	}
+
+	switch {
+	case target.Arch == "wasm32":
+		cc = "clang"
+		args = append([]string{"--target=" + target.Triple}, args...)
+	case !target.IsHost():
+		cc = target.Triple + "-gcc"
+	}
+
+	if filepath.Ext(asm) == BackendC.Ext() {
+		// Extern functions are declared without prototypes, which C23 dropped,
+		// and with the types they're called with, which may not be the types
+		// the compiler knows for the functions of the C library.
+		args = append([]string{"-std=c99", "-fno-builtin"}, args...)
+
+		switch {
+		case opts.Freestanding:
+			// The stack protector of the compiler calls into the C library.
+			args = append([]string{"-ffreestanding", "-fno-stack-protector"}, args...)
+		case opts.StackProtector:
+			args = append([]string{"-fstack-protector-strong"}, args...)
+		}
+
+		switch {
+		case opts.OptLevel == "":
+		case opts.OmitFramePointer:
+			args = append([]string{"-O" + opts.OptLevel, "-fomit-frame-pointer"}, args...)
+		default:
+			args = append([]string{"-O" + opts.OptLevel, "-fno-omit-frame-pointer"}, args...)
+		}
+	}
+
+	for _, lib := range libraries {
+		args = append(args, "-l"+lib)
+	}
+
+	return cc, args
+}
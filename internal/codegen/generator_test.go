@@ -0,0 +1,128 @@
+package codegen
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/target"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackend_Check(t *testing.T) {
+	t.Parallel()
+
+	linux := target.Target{Triple: "aarch64-linux-gnu", Arch: "arm64", OS: "linux"}
+	darwin := target.Target{Triple: "arm64-apple-darwin", Arch: "arm64", OS: "darwin"}
+	wasm := target.Target{Triple: "wasm32-wasi", Arch: "wasm32", OS: "wasi"}
+
+	tt := []struct {
+		backend Backend
+		target  target.Target
+		err     string
+	}{
+		{backend: BackendQBE, target: linux},
+		{backend: BackendQBE, target: darwin},
+		{backend: BackendQBE, target: wasm, err: "no qbe backend for wasm32-wasi"},
+		{backend: BackendNative, target: linux},
+		{backend: BackendNative, target: darwin, err: "no native backend for arm64-apple-darwin"},
+		{backend: BackendC, target: wasm},
+		{backend: BackendBytecode, target: wasm, err: "no bytecode backend for wasm32-wasi"},
+		{backend: "llvm", target: linux, err: "unknown backend llvm"},
+	}
+
+	for _, tc := range tt {
+		t.Run(string(tc.backend)+"/"+tc.target.String(), func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.backend.Check(tc.target)
+			if tc.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.err)
+			}
+		})
+	}
+
+	require.Equal(t, BackendQBE, DefaultBackend(linux))
+	require.Equal(t, BackendC, DefaultBackend(wasm))
+}
+
+func TestCompileCommand(t *testing.T) {
+	t.Parallel()
+
+	linux := target.Target{Triple: "aarch64-linux-gnu", Arch: "arm64", OS: "linux"}
+
+	tt := []struct {
+		name     string
+		asm      string
+		opts     Options
+		cc       string
+		expected []string
+	}{
+		{
+			name:     "assembly",
+			asm:      "out/main.s",
+			cc:       "cc",
+			expected: []string{"-o", "out/main", "out/main.s", "-lm"},
+		},
+		{
+			name:     "shared",
+			asm:      "out/main.s",
+			opts:     Options{Shared: true},
+			cc:       "cc",
+			expected: []string{"-shared", "-fPIC", "-o", "out/main", "out/main.s", "-lm"},
+		},
+		{
+			name:     "C source with stack protector",
+			asm:      "out/main.c",
+			opts:     Options{StackProtector: true},
+			cc:       "cc",
+			expected: []string{"-fstack-protector-strong", "-std=c99", "-fno-builtin", "-o", "out/main", "out/main.c", "-lm"},
+		},
+		{
+			name:     "optimized C source",
+			asm:      "out/main.c",
+			opts:     Options{OptLevel: "s", OmitFramePointer: true},
+			cc:       "cc",
+			expected: []string{"-Os", "-fomit-frame-pointer", "-std=c99", "-fno-builtin", "-o", "out/main", "out/main.c", "-lm"},
+		},
+		{
+			name:     "unoptimized C source",
+			asm:      "out/main.c",
+			opts:     Options{OptLevel: "0"},
+			cc:       "cc",
+			expected: []string{"-O0", "-fno-omit-frame-pointer", "-std=c99", "-fno-builtin", "-o", "out/main", "out/main.c", "-lm"},
+		},
+		{
+			name:     "optimized assembly",
+			asm:      "out/main.s",
+			opts:     Options{OptLevel: "2", OmitFramePointer: true},
+			cc:       "cc",
+			expected: []string{"-o", "out/main", "out/main.s", "-lm"},
+		},
+		{
+			name:     "freestanding",
+			asm:      "out/main.s",
+			opts:     Options{Freestanding: true, LinkerScript: "board.ld"},
+			cc:       "cc",
+			expected: []string{"-nostdlib", "-static", "-o", "out/main", "out/main.s", "-T", "board.ld", "-lm"},
+		},
+		{
+			name: "freestanding C source",
+			asm:  "out/main.c",
+			opts: Options{Freestanding: true, Target: linux},
+			cc:   "aarch64-linux-gnu-gcc",
+			expected: []string{"-ffreestanding", "-fno-stack-protector", "-std=c99", "-fno-builtin",
+				"-nostdlib", "-static", "-o", "out/main", "out/main.c", "-lm"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cc, args := compileCommand(tc.asm, "out/main", []string{"m"}, tc.opts)
+			require.Equal(t, tc.cc, cc)
+			require.Equal(t, tc.expected, args)
+		})
+	}
+}
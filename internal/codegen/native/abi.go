@@ -0,0 +1,236 @@
+package native
+
+import (
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// abi is the part of the calling convention of an architecture that assigns
+// the arguments and the result of a call to registers and the stack. Only
+// integers and aggregates of integers are passed.
+//
+// Like in QBE, the value of an aggregate is its address. Aggregates up to 16
+// bytes are passed in one or two registers, a word of the aggregate in each.
+// Larger and opaque aggregates are passed in memory: on the stack, or as the
+// address of a copy if byRef is set. A result that's passed in memory is
+// written where the caller passes the address of.
+type abi struct {
+	args  []string  // the registers of the first arguments
+	ret   [2]string // the registers of the result
+	sret  string    // the register of the address of a result in memory, or "" if it's the first argument
+	byRef bool      // aggregates in memory are passed as the address of a copy
+	split bool      // an aggregate that doesn't fit in the registers left takes the last ones and the stack
+	fill  bool      // arguments after an aggregate that went on the stack still take the registers left
+}
+
+// word is where a word of an argument is passed: in a register, or on the
+// stack at an offset from the stack pointer at the call.
+type word struct {
+	reg   string
+	stack int
+}
+
+// param is how an argument is passed.
+type param struct {
+	words []word // the words of the value, or the address of its copy if ref
+	agg   bool   // the value is an aggregate
+	ref   bool   // the aggregate is passed as the address of a copy
+	size  int    // the size of an aggregate
+	copy  int    // the offset of the copy in the copies of the caller
+}
+
+// convention is how a call passes its arguments and its result.
+type convention struct {
+	abi    abi
+	params []param
+	stack  int  // the size of the arguments on the stack, a multiple of 16
+	copies int  // the size of the copies of the arguments passed by reference, a multiple of 16
+	sret   bool // the result is written where the caller passes the address of
+	ret    int  // the size of an aggregate result, or 0
+}
+
+// sretReg returns the register of the address of a result in memory.
+func (c *convention) sretReg() string {
+	if c.abi.sret != "" {
+		return c.abi.sret
+	}
+
+	return c.abi.args[0]
+}
+
+// retWords returns the number of registers an aggregate result is returned
+// in.
+func (c *convention) retWords() int {
+	return words(c.ret)
+}
+
+// words returns the number of words of 8 bytes an aggregate of size bytes
+// takes.
+func words(size int) int {
+	return (size + 7) / 8
+}
+
+// aggregate returns the size of the aggregate type ty, and whether it's passed
+// in memory.
+func (g *generator) aggregate(loc lexer.Location, ty ir.AbiTy) (int, bool, error) {
+	l, err := g.unit.Layout(ty.Ident)
+	if err != nil {
+		return 0, false, loc.Errorf("%v", err)
+	}
+
+	if l.Align > 8 {
+		return 0, false, loc.Errorf("aggregate :%s aligned to %d bytes isn't supported by the native backend", ty.Ident, l.Align)
+	}
+
+	opaque, err := g.classify(loc, ty.Ident)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return l.Size, opaque || l.Size > 16, nil
+}
+
+// classify reports whether the type named ident is opaque, and fails if it
+// has floating point fields, which are passed in other registers.
+func (g *generator) classify(loc lexer.Location, ident ir.Ident) (bool, error) {
+	for _, td := range g.unit.Types {
+		if td.Ident != ident {
+			continue
+		}
+
+		fields := td.Fields
+		for _, variant := range td.UnionFields {
+			fields = append(fields, variant...)
+		}
+
+		for _, field := range fields {
+			switch {
+			case field.SubTy.Type == ir.SubTyIdent:
+				if _, err := g.classify(loc, field.SubTy.Ident); err != nil {
+					return false, err
+				}
+			case field.SubTy.ExtTy == ir.ExtSingle || field.SubTy.ExtTy == ir.ExtDouble:
+				return false, loc.Errorf("aggregate :%s with floating point fields isn't supported by the native backend", ident)
+			}
+		}
+
+		return td.Type == ir.TypeDefOpaque, nil
+	}
+
+	return false, nil
+}
+
+// convention assigns the arguments of the given types and the result of type
+// ret, if any, to registers and the stack.
+func (g *generator) convention(loc lexer.Location, args []ir.AbiTy, ret *ir.AbiTy) (*convention, error) {
+	a := g.arch.abi()
+	c := &convention{abi: a}
+	next := 0
+
+	if ret != nil && ret.Type == ir.AbiTyIdent {
+		size, mem, err := g.aggregate(loc, *ret)
+		if err != nil {
+			return nil, err
+		}
+
+		c.ret = size
+
+		if mem {
+			c.sret = true
+
+			if a.sret == "" {
+				next++
+			}
+		}
+	}
+
+	// assign passes n words in registers while there are, and the rest on
+	// the stack.
+	assign := func(p *param, n int) {
+		left := len(a.args) - next
+
+		if n > left && !a.split {
+			if !a.fill {
+				next = len(a.args)
+			}
+
+			left = 0
+		}
+
+		for i := range n {
+			if i < left {
+				p.words = append(p.words, word{reg: a.args[next]})
+				next++
+
+				continue
+			}
+
+			p.words = append(p.words, word{stack: c.stack})
+			c.stack += 8
+		}
+	}
+
+	for _, ty := range args {
+		if ty.IsFloat() {
+			return nil, loc.Errorf("floating point values aren't supported by the native backend")
+		}
+
+		if ty.Type != ir.AbiTyIdent {
+			p := param{}
+			assign(&p, 1)
+			c.params = append(c.params, p)
+
+			continue
+		}
+
+		size, mem, err := g.aggregate(loc, ty)
+		if err != nil {
+			return nil, err
+		}
+
+		p := param{agg: true, size: size}
+
+		switch {
+		case mem && a.byRef:
+			p.ref = true
+			p.copy = c.copies
+			c.copies += align(size, 16)
+			assign(&p, 1)
+		case mem:
+			for i := range words(size) {
+				p.words = append(p.words, word{stack: c.stack + 8*i})
+			}
+
+			c.stack += 8 * words(size)
+		default:
+			assign(&p, words(size))
+		}
+
+		c.params = append(c.params, p)
+	}
+
+	c.stack = align(c.stack, 16)
+
+	return c, nil
+}
+
+// chunk is a part of a copy of memory: size bytes at an offset.
+type chunk struct {
+	off, size int
+}
+
+// chunks splits a copy of size bytes into words, and a half word, a quarter
+// and a byte for the rest, so the copy doesn't write past the end.
+func chunks(size int) []chunk {
+	var cs []chunk
+
+	off := 0
+
+	for _, n := range []int{8, 4, 2, 1} {
+		for ; size-off >= n; off += n {
+			cs = append(cs, chunk{off: off, size: n})
+		}
+	}
+
+	return cs
+}
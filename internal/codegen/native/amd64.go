@@ -0,0 +1,584 @@
+package native
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/corani/cubit/internal/ir"
+)
+
+// amd64 generates code for x86-64 with the System V calling convention, in
+// AT&T syntax. Operands are loaded into %rax and %rcx, and the result is
+// stored from %rax. %r10 and %r11 move arguments and aggregates, and %rbx and
+// %r12 to %r15 hold temporaries.
+type amd64 struct{}
+
+// amd64Args are the registers the first integer arguments are passed in.
+var amd64Args = []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
+
+// amd64Preserved are the registers calls preserve.
+var amd64Preserved = []string{"rbx", "r12", "r13", "r14", "r15"}
+
+// amd64Volatile are the registers a function may change without saving them,
+// which an interrupt handler saves for the code it interrupts.
+var amd64Volatile = []string{"rax", "rcx", "rdx", "rsi", "rdi", "r8", "r9", "r10", "r11"}
+
+// amd64Words are the 32-bit halves of the registers.
+var amd64Words = map[string]string{
+	"rax": "eax", "rcx": "ecx", "rdx": "edx", "rdi": "edi", "rsi": "esi",
+	"r8": "r8d", "r9": "r9d", "r11": "r11d", "rbx": "ebx",
+	"r12": "r12d", "r13": "r13d", "r14": "r14d", "r15": "r15d",
+}
+
+// amd64Conds are the condition codes of the comparisons.
+var amd64Conds = map[ir.BinOpKind]string{
+	ir.BinOpEq: "e", ir.BinOpNe: "ne",
+	ir.BinOpLt: "l", ir.BinOpULt: "b",
+	ir.BinOpLe: "le", ir.BinOpULe: "be",
+	ir.BinOpGt: "g", ir.BinOpUGt: "a",
+	ir.BinOpGe: "ge", ir.BinOpUGe: "ae",
+}
+
+// long reports whether a value of type ty takes 64 bits. The value of an
+// aggregate is its address.
+func long(ty ir.AbiTy) bool {
+	return ty.Type == ir.AbiTyBase && ty.BaseTy == ir.BaseLong || ty.Type == ir.AbiTyIdent
+}
+
+// reg returns the name of a register for values of type ty, and the suffix of
+// the instructions on them.
+func (amd64) reg(reg string, ty ir.AbiTy) (string, string) {
+	if long(ty) {
+		return "%" + reg, "q"
+	}
+
+	return "%" + amd64Words[reg], "l"
+}
+
+// load loads val into a register.
+func (a amd64) load(fn *function, reg string, val *ir.Val) error {
+	if val.AbiTy.IsFloat() {
+		return val.Loc.Errorf("floating point values aren't supported by the native backend")
+	}
+
+	switch {
+	case val.Type == ir.ValIdent:
+		if off, ok := fn.area(val.Ident); ok {
+			fn.emit("leaq -%d(%%rbp), %%%s", off, reg)
+
+			return nil
+		}
+
+		if src, ok := fn.register(val.Ident); ok {
+			r, suffix := a.reg(reg, val.AbiTy)
+			s, _ := a.reg(src, val.AbiTy)
+			fn.emit("mov%s %s, %s", suffix, s, r)
+
+			return nil
+		}
+
+		off, err := fn.slot(val.Ident)
+		if err != nil {
+			return err
+		}
+
+		r, suffix := a.reg(reg, val.AbiTy)
+		fn.emit("mov%s -%d(%%rbp), %s", suffix, off, r)
+	case val.DynConst.Type == ir.DynConstThread:
+		return val.Loc.Errorf("thread-local data isn't supported by the native backend")
+	case val.DynConst.Const.Type == ir.ConstIdent:
+		fn.emit("leaq %s(%%rip), %%%s", val.DynConst.Const.Ident, reg)
+	case val.DynConst.Const.Type == ir.ConstInteger:
+		if i := val.DynConst.Const.I64; i >= math.MinInt32 && i <= math.MaxInt32 {
+			fn.emit("movq $%d, %%%s", i, reg)
+		} else {
+			fn.emit("movabsq $%d, %%%s", i, reg)
+		}
+	default:
+		return val.Loc.Errorf("constant %s isn't supported by the native backend", val.DynConst.Const.Type)
+	}
+
+	return nil
+}
+
+// store stores a register in the register or slot of a temporary of type ty.
+func (a amd64) store(fn *function, reg string, dst ir.Ident, ty ir.AbiTy) error {
+	r, suffix := a.reg(reg, ty)
+
+	if d, ok := fn.register(dst); ok {
+		d, _ = a.reg(d, ty)
+		fn.emit("mov%s %s, %s", suffix, r, d)
+
+		return nil
+	}
+
+	off, err := fn.slot(dst)
+	if err != nil {
+		return err
+	}
+
+	fn.emit("mov%s %s, -%d(%%rbp)", suffix, r, off)
+
+	return nil
+}
+
+func (amd64) comment() string {
+	return "#"
+}
+
+func (amd64) abi() abi {
+	return abi{args: amd64Args, ret: [2]string{"rax", "rdx"}, fill: true}
+}
+
+func (amd64) preserved() []string {
+	return amd64Preserved
+}
+
+func (a amd64) prologue(fn *function) error {
+	switch fn.fd.CallConv {
+	case ir.CallConvNaked:
+		return nil
+	case ir.CallConvInterrupt:
+		// The CPU pushes 5 words, so the odd number of registers and the
+		// padding leave the stack aligned like after a call. The functions
+		// the handler calls expect the direction flag to be clear.
+		for _, reg := range amd64Volatile {
+			fn.emit("pushq %%%s", reg)
+		}
+
+		fn.emit("subq $8, %%rsp")
+		fn.emit("cld")
+	}
+
+	fn.emit("pushq %%rbp")
+	fn.emit("movq %%rsp, %%rbp")
+
+	if fn.frame.size > 0 {
+		fn.emit("subq $%d, %%rsp", fn.frame.size)
+	}
+
+	for _, reg := range fn.frame.saved {
+		if err := a.store(fn, reg, saved(reg), ir.NewAbiTyBase(ir.BaseLong)); err != nil {
+			return err
+		}
+	}
+
+	if fn.conv.sret {
+		if err := a.store(fn, fn.conv.sretReg(), sret, ir.NewAbiTyBase(ir.BaseLong)); err != nil {
+			return err
+		}
+	}
+
+	for i, param := range fn.fd.Params {
+		p := fn.conv.params[i]
+
+		if p.agg {
+			a.aggregateParam(fn, param.Ident, p)
+
+			continue
+		}
+
+		if err := a.store(fn, a.param(fn, p.words[0]), param.Ident, param.AbiTy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// param returns the register a word of a parameter is passed in. The words on
+// the stack, above the return address and the saved frame pointer, are loaded
+// into %rax.
+func (amd64) param(fn *function, w word) string {
+	if w.reg != "" {
+		return w.reg
+	}
+
+	fn.emit("movq %d(%%rbp), %%rax", 16+w.stack)
+
+	return "rax"
+}
+
+// aggregateParam copies an aggregate parameter to its memory in the frame.
+func (a amd64) aggregateParam(fn *function, ident ir.Ident, p param) {
+	off, _ := fn.area(ident)
+	fn.emit("leaq -%d(%%rbp), %%r11", off)
+
+	if p.ref {
+		if reg := a.param(fn, p.words[0]); reg != "rax" {
+			fn.emit("movq %%%s, %%rax", reg)
+		}
+
+		a.copy(fn, "r11", "rax", p.size)
+
+		return
+	}
+
+	for i, w := range p.words {
+		fn.emit("movq %%%s, %d(%%r11)", a.param(fn, w), 8*i)
+	}
+}
+
+// amd64Scratch are the sizes of the registers copies move memory through.
+var amd64Scratch = map[int][2]string{8: {"r10", "q"}, 4: {"r10d", "l"}, 2: {"r10w", "w"}, 1: {"r10b", "b"}}
+
+// copy copies size bytes from the address in src to the address in dst.
+func (amd64) copy(fn *function, dst, src string, size int) {
+	for _, c := range chunks(size) {
+		r := amd64Scratch[c.size]
+		fn.emit("mov%s %d(%%%s), %%%s", r[1], c.off, src, r[0])
+		fn.emit("mov%s %%%s, %d(%%%s)", r[1], r[0], c.off, dst)
+	}
+}
+
+func (a amd64) instr(fn *function, instr ir.Instruction) error {
+	switch instr := instr.(type) {
+	case *ir.Binop:
+		return a.binop(fn, instr)
+	case *ir.Load:
+		if err := a.load(fn, "rax", instr.Addr); err != nil {
+			return err
+		}
+
+		// The load for a word result, and for a long result. Writing the
+		// 32-bit half of a register clears the upper half.
+		ops := map[string][2]string{
+			"ub": {"movzbl", "movzbl"},
+			"sb": {"movsbl", "movsbq"},
+			"uh": {"movzwl", "movzwl"},
+			"sh": {"movswl", "movswq"},
+			"w":  {"movl", "movslq"},
+			"l":  {"movl", "movq"},
+		}[memTy(instr.Ty)]
+
+		op, reg := ops[0], "%eax"
+		if long(instr.Ret.AbiTy) {
+			op = ops[1]
+		}
+
+		if strings.HasSuffix(op, "q") {
+			reg = "%rax"
+		}
+
+		fn.emit("%s (%%rax), %s", op, reg)
+
+		return a.store(fn, "rax", instr.Ret.Ident, instr.Ret.AbiTy)
+	case *ir.Store:
+		if err := a.load(fn, "rax", instr.Addr); err != nil {
+			return err
+		}
+
+		if err := a.load(fn, "rcx", instr.Val); err != nil {
+			return err
+		}
+
+		switch memTy(instr.Ty) {
+		case "ub", "sb":
+			fn.emit("movb %%cl, (%%rax)")
+		case "uh", "sh":
+			fn.emit("movw %%cx, (%%rax)")
+		case "l":
+			fn.emit("movq %%rcx, (%%rax)")
+		default:
+			fn.emit("movl %%ecx, (%%rax)")
+		}
+
+		return nil
+	case *ir.Convert:
+		if err := a.load(fn, "rax", instr.Val); err != nil {
+			return err
+		}
+
+		if long(instr.Ret.AbiTy) {
+			switch memTy(instr.Val.AbiTy) {
+			case "w":
+				fn.emit("movslq %%eax, %%rax")
+			case "sb":
+				fn.emit("movsbq %%al, %%rax")
+			case "ub":
+				fn.emit("movzbq %%al, %%rax")
+			case "sh":
+				fn.emit("movswq %%ax, %%rax")
+			case "uh":
+				fn.emit("movzwq %%ax, %%rax")
+			}
+		}
+
+		return a.store(fn, "rax", instr.Ret.Ident, instr.Ret.AbiTy)
+	case *ir.Call:
+		return a.call(fn, instr)
+	case *ir.Alloc:
+		// The memory of the slot is part of the frame.
+		return nil
+	default:
+		return fmt.Errorf("%s: instruction %T isn't supported by the native backend", instr.Location(), instr)
+	}
+}
+
+func (a amd64) binop(fn *function, b *ir.Binop) error {
+	if err := a.load(fn, "rax", b.Lhs); err != nil {
+		return err
+	}
+
+	if err := a.load(fn, "rcx", b.Rhs); err != nil {
+		return err
+	}
+
+	// Comparisons operate on the type of their operands, all other operations
+	// on the type of their result.
+	ty := b.Ret.AbiTy
+	if b.Op.IsComparison() {
+		ty = b.Lhs.AbiTy
+	}
+
+	rax, suffix := a.reg("rax", ty)
+	rcx, _ := a.reg("rcx", ty)
+	result := "rax"
+
+	switch b.Op {
+	case ir.BinOpAdd, ir.BinOpSub, ir.BinOpAnd, ir.BinOpOr:
+		fn.emit("%s%s %s, %s", b.Op, suffix, rcx, rax)
+	case ir.BinOpMul:
+		fn.emit("imul%s %s, %s", suffix, rcx, rax)
+	case ir.BinOpDiv, ir.BinOpMod:
+		if suffix == "q" {
+			fn.emit("cqto")
+		} else {
+			fn.emit("cltd")
+		}
+
+		fn.emit("idiv%s %s", suffix, rcx)
+	case ir.BinOpUDiv, ir.BinOpUMod:
+		fn.emit("xorl %%edx, %%edx")
+		fn.emit("div%s %s", suffix, rcx)
+	case ir.BinOpShl:
+		fn.emit("shl%s %%cl, %s", suffix, rax)
+	case ir.BinOpShr:
+		fn.emit("shr%s %%cl, %s", suffix, rax)
+	default:
+		cond, ok := amd64Conds[b.Op]
+		if !ok {
+			return b.Loc.Errorf("unknown binop: %s", b.Op)
+		}
+
+		fn.emit("cmp%s %s, %s", suffix, rcx, rax)
+		fn.emit("set%s %%al", cond)
+		fn.emit("movzbl %%al, %%eax")
+	}
+
+	if b.Op == ir.BinOpMod || b.Op == ir.BinOpUMod {
+		result = "rdx"
+	}
+
+	return a.store(fn, result, b.Ret.Ident, b.Ret.AbiTy)
+}
+
+func (a amd64) call(fn *function, c *ir.Call) error {
+	args, conv, err := fn.arguments(c)
+	if err != nil {
+		return err
+	}
+
+	// The arguments on the stack and the copies of the aggregates passed by
+	// reference are at the bottom of the stack, which stays aligned to 16
+	// bytes.
+	result := fn.result(c)
+	size := conv.stack + conv.copies
+
+	if size > 0 {
+		fn.emit("subq $%d, %%rsp", size)
+	}
+
+	// The words on the stack are stored first, as loading them uses %rax,
+	// and then the registers are loaded.
+	for i, p := range conv.params {
+		for j, w := range p.words {
+			if w.reg != "" {
+				continue
+			}
+
+			if err := a.arg(fn, "rax", args[i], conv, p, j); err != nil {
+				return err
+			}
+
+			fn.emit("movq %%rax, %d(%%rsp)", w.stack)
+		}
+	}
+
+	for i, p := range conv.params {
+		for j, w := range p.words {
+			if w.reg == "" {
+				continue
+			}
+
+			if err := a.arg(fn, w.reg, args[i], conv, p, j); err != nil {
+				return err
+			}
+		}
+	}
+
+	if conv.sret {
+		if err := a.load(fn, conv.sretReg(), result); err != nil {
+			return err
+		}
+	}
+
+	target := ""
+
+	if c.Val.Type == ir.ValDynConst && c.Val.DynConst.Const.Type == ir.ConstIdent {
+		target = string(c.Val.DynConst.Const.Ident)
+	} else {
+		if err := a.load(fn, "r11", c.Val); err != nil {
+			return err
+		}
+
+		target = "*%r11"
+	}
+
+	// A variadic callee expects the number of vector registers used in %al.
+	fn.emit("xorl %%eax, %%eax")
+	fn.emit("call %s", target)
+
+	if size > 0 {
+		fn.emit("addq $%d, %%rsp", size)
+	}
+
+	switch {
+	case result == nil || conv.sret:
+		return nil
+	case conv.ret > 0:
+		if err := a.load(fn, "r11", result); err != nil {
+			return err
+		}
+
+		for i := range conv.retWords() {
+			fn.emit("movq %%%s, %d(%%r11)", conv.abi.ret[i], 8*i)
+		}
+
+		return nil
+	default:
+		return a.store(fn, "rax", *c.LHS, *c.RetTy)
+	}
+}
+
+// arg loads the i-th word of the argument val, passed as p, into reg. An
+// aggregate passed by reference is first copied below the arguments on the
+// stack.
+func (a amd64) arg(fn *function, reg string, val *ir.Val, conv *convention, p param, i int) error {
+	switch {
+	case p.ref:
+		if err := a.load(fn, "r11", val); err != nil {
+			return err
+		}
+
+		fn.emit("leaq %d(%%rsp), %%%s", conv.stack+p.copy, reg)
+		a.copy(fn, reg, "r11", p.size)
+	case p.agg:
+		if err := a.load(fn, "r11", val); err != nil {
+			return err
+		}
+
+		fn.emit("movq %d(%%r11), %%%s", 8*i, reg)
+	default:
+		return a.load(fn, reg, val)
+	}
+
+	return nil
+}
+
+func (a amd64) move(fn *function, dst ir.Ident, ty ir.AbiTy, val *ir.Val) error {
+	if err := a.load(fn, "rax", val); err != nil {
+		return err
+	}
+
+	return a.store(fn, "rax", dst, ty)
+}
+
+func (amd64) jump(fn *function, label string) {
+	fn.emit("jmp %s", label)
+}
+
+func (a amd64) branch(fn *function, cond *ir.Val, label string) error {
+	if err := a.load(fn, "rax", cond); err != nil {
+		return err
+	}
+
+	rax, suffix := a.reg("rax", cond.AbiTy)
+	fn.emit("test%s %s, %s", suffix, rax, rax)
+	fn.emit("jnz %s", label)
+
+	return nil
+}
+
+func (a amd64) ret(fn *function, val *ir.Val) error {
+	switch {
+	case val == nil:
+	case fn.conv.sret:
+		// The address of the result is returned too.
+		if err := a.load(fn, "rax", ir.NewValIdent(val.Loc, sret, ir.NewAbiTyBase(ir.BaseLong))); err != nil {
+			return err
+		}
+
+		if err := a.load(fn, "r11", val); err != nil {
+			return err
+		}
+
+		a.copy(fn, "rax", "r11", fn.conv.ret)
+	case fn.conv.ret > 0:
+		if err := a.load(fn, "r11", val); err != nil {
+			return err
+		}
+
+		for i := range fn.conv.retWords() {
+			fn.emit("movq %d(%%r11), %%%s", 8*i, fn.conv.abi.ret[i])
+		}
+	default:
+		if err := a.load(fn, "rax", val); err != nil {
+			return err
+		}
+	}
+
+	for _, reg := range fn.frame.saved {
+		if err := a.load(fn, reg, ir.NewValIdent(fn.fd.Loc, saved(reg), ir.NewAbiTyBase(ir.BaseLong))); err != nil {
+			return err
+		}
+	}
+
+	switch fn.fd.CallConv {
+	case ir.CallConvNaked:
+		fn.emit("ret")
+	case ir.CallConvInterrupt:
+		fn.emit("leave")
+		fn.emit("addq $8, %%rsp")
+
+		for i := len(amd64Volatile) - 1; i >= 0; i-- {
+			fn.emit("popq %%%s", amd64Volatile[i])
+		}
+
+		fn.emit("iretq")
+	default:
+		fn.emit("leave")
+		fn.emit("ret")
+	}
+
+	return nil
+}
+
+func (amd64) trap(fn *function) {
+	fn.emit("ud2")
+}
+
+// memTy returns the name of an integer type: "ub", "sb", "uh", "sh", "w" or
+// "l".
+func memTy(ty ir.AbiTy) string {
+	if ty.Type == ir.AbiTySubW {
+		return string(ty.SubWTy)
+	}
+
+	if long(ty) {
+		return "l"
+	}
+
+	return "w"
+}
@@ -0,0 +1,417 @@
+package native
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// amd64Regs are the numbers and sizes in bits of the registers the amd64
+// generator uses.
+var amd64Regs = func() map[string][2]int {
+	regs := make(map[string][2]int)
+
+	for i, name := range []string{"rax", "rcx", "rdx", "rbx", "rsp", "rbp", "rsi", "rdi"} {
+		regs[name] = [2]int{i, 64}
+		regs["e"+name[1:]] = [2]int{i, 32}
+	}
+
+	for i := 8; i < 16; i++ {
+		regs[fmt.Sprintf("r%d", i)] = [2]int{i, 64}
+		regs[fmt.Sprintf("r%dd", i)] = [2]int{i, 32}
+		regs[fmt.Sprintf("r%dw", i)] = [2]int{i, 16}
+		regs[fmt.Sprintf("r%db", i)] = [2]int{i, 8}
+	}
+
+	for i, name := range []string{"ax", "cx", "dx", "bx"} {
+		regs[name] = [2]int{i, 16}
+		regs[name[:1]+"l"] = [2]int{i, 8}
+	}
+
+	return regs
+}()
+
+// amd64CondCodes are the condition codes of the setcc and jcc instructions.
+var amd64CondCodes = map[string]byte{
+	"e": 0x4, "ne": 0x5, "b": 0x2, "ae": 0x3, "be": 0x6, "a": 0x7,
+	"l": 0xc, "ge": 0xd, "le": 0xe, "g": 0xf,
+}
+
+// amd64ALU are the opcodes of the arithmetic instructions with a register
+// source, and the extension of their form with a 32-bit immediate.
+var amd64ALU = map[string][2]byte{
+	"add": {0x01, 0}, "or": {0x09, 1}, "and": {0x21, 4}, "sub": {0x29, 5},
+	"xor": {0x31, 6}, "cmp": {0x39, 7}, "test": {0x85, 0xff},
+}
+
+// amd64Ext are the opcodes of the loads that extend their source, and the
+// size of their destination.
+var amd64Ext = map[string]struct {
+	opcode []byte
+	size   int
+}{
+	"movzbl": {[]byte{0x0f, 0xb6}, 32}, "movzbq": {[]byte{0x0f, 0xb6}, 64},
+	"movsbl": {[]byte{0x0f, 0xbe}, 32}, "movsbq": {[]byte{0x0f, 0xbe}, 64},
+	"movzwl": {[]byte{0x0f, 0xb7}, 32}, "movzwq": {[]byte{0x0f, 0xb7}, 64},
+	"movswl": {[]byte{0x0f, 0xbf}, 32}, "movswq": {[]byte{0x0f, 0xbf}, 64},
+	"movslq": {[]byte{0x63}, 64},
+}
+
+// ripBase is the base of operands relative to the instruction pointer.
+const ripBase = -1
+
+// operand is an operand of an instruction in AT&T syntax: a register, an
+// immediate, memory at a base register plus a displacement, memory at a
+// symbol relative to the instruction pointer, or a label.
+type operand struct {
+	reg, size int
+	imm       int64
+	isReg     bool
+	isImm     bool
+	isMem     bool
+	base      int
+	disp      int64
+	sym       string // symbol of a memory operand relative to %rip, or a label
+}
+
+func parseOperand(s string) (operand, error) {
+	switch {
+	case strings.HasPrefix(s, "%"):
+		reg, ok := amd64Regs[s[1:]]
+		if !ok {
+			return operand{}, fmt.Errorf("unknown register %s", s)
+		}
+
+		return operand{isReg: true, reg: reg[0], size: reg[1]}, nil
+	case strings.HasPrefix(s, "$"):
+		imm, err := strconv.ParseInt(s[1:], 10, 64)
+
+		return operand{isImm: true, imm: imm}, err
+	case strings.HasSuffix(s, ")"):
+		disp, base, _ := strings.Cut(strings.TrimSuffix(s, ")"), "(")
+
+		if base == "%rip" {
+			return operand{isMem: true, base: ripBase, sym: disp}, nil
+		}
+
+		reg, ok := amd64Regs[strings.TrimPrefix(base, "%")]
+		if !ok || reg[1] != 64 {
+			return operand{}, fmt.Errorf("unknown base register %s", base)
+		}
+
+		op := operand{isMem: true, base: reg[0]}
+
+		if disp != "" {
+			var err error
+			if op.disp, err = strconv.ParseInt(disp, 10, 64); err != nil {
+				return operand{}, err
+			}
+		}
+
+		return op, nil
+	default:
+		return operand{sym: s}, nil
+	}
+}
+
+// instruction appends an instruction with a ModR/M byte: the operand size
+// prefix for 16 bits, the REX prefix, the opcode, the ModR/M byte for reg
+// and rm with the displacement of rm, and the immediate.
+func instruction(obj *object, size int, opcode []byte, reg int, rm operand, imm []byte) {
+	var code []byte
+
+	if size == 16 {
+		code = append(code, 0x66)
+	}
+
+	rex := byte(0)
+	if size == 64 {
+		rex |= 0x8
+	}
+
+	if reg >= 8 {
+		rex |= 0x4
+	}
+
+	if rm.isReg && rm.reg >= 8 || rm.isMem && rm.base >= 8 {
+		rex |= 0x1
+	}
+
+	if rex != 0 {
+		code = append(code, 0x40|rex)
+	}
+
+	code = append(code, opcode...)
+	modrm := byte(reg&7) << 3
+	reloc := -1
+
+	switch {
+	case rm.isReg:
+		code = append(code, 0xc0|modrm|byte(rm.reg&7))
+	case rm.base == ripBase:
+		code = append(code, modrm|0x5)
+		reloc = len(code)
+		code = append(code, 0, 0, 0, 0)
+	default:
+		base := byte(rm.base & 7)
+
+		switch {
+		case rm.disp == 0 && base != 5:
+			code = append(code, modrm|base)
+		case rm.disp >= math.MinInt8 && rm.disp <= math.MaxInt8:
+			code = append(code, 0x40|modrm|base)
+		default:
+			code = append(code, 0x80|modrm|base)
+		}
+
+		// A base of %rsp or %r12 needs a SIB byte.
+		if base == 4 {
+			code = append(code, 0x24)
+		}
+
+		switch {
+		case rm.disp == 0 && base != 5:
+		case rm.disp >= math.MinInt8 && rm.disp <= math.MaxInt8:
+			code = append(code, byte(int8(rm.disp)))
+		default:
+			code = binary.LittleEndian.AppendUint32(code, uint32(int32(rm.disp)))
+		}
+	}
+
+	code = append(code, imm...)
+
+	if reloc >= 0 {
+		// The displacement is relative to the end of the instruction.
+		obj.relocate(obj.offset()+reloc, rm.sym, uint32(elf.R_X86_64_PC32), int64(-4-len(imm)))
+	}
+
+	obj.emit(code...)
+}
+
+// suffixSize returns the operand size of the suffix of a mnemonic.
+func suffixSize(mnemonic string) (string, int, bool) {
+	if len(mnemonic) < 2 {
+		return "", 0, false
+	}
+
+	size, ok := map[byte]int{'b': 8, 'w': 16, 'l': 32, 'q': 64}[mnemonic[len(mnemonic)-1]]
+
+	return mnemonic[:len(mnemonic)-1], size, ok
+}
+
+// encodeAMD64 encodes an instruction the amd64 generator writes. Unlike an
+// assembler, jumps always get a 32-bit displacement, so no relaxation is
+// needed.
+func encodeAMD64(obj *object, mnemonic string, args []string) error {
+	ops := make([]operand, len(args))
+
+	for i, arg := range args {
+		if mnemonic == "call" && strings.HasPrefix(arg, "*") {
+			arg = arg[1:]
+		}
+
+		op, err := parseOperand(arg)
+		if err != nil {
+			return err
+		}
+
+		ops[i] = op
+	}
+
+	expect := func(n int) error {
+		if len(ops) != n {
+			return fmt.Errorf("%s expects %d operands, got %d", mnemonic, n, len(ops))
+		}
+
+		return nil
+	}
+
+	imm32 := func(i int64) ([]byte, error) {
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return nil, fmt.Errorf("immediate %d doesn't fit in 32 bits", i)
+		}
+
+		return binary.LittleEndian.AppendUint32(nil, uint32(int32(i))), nil
+	}
+
+	switch mnemonic {
+	case "leave":
+		obj.emit(0xc9)
+	case "ret":
+		obj.emit(0xc3)
+	case "ud2":
+		obj.emit(0x0f, 0x0b)
+	case "cltd":
+		obj.emit(0x99)
+	case "cqto":
+		obj.emit(0x48, 0x99)
+	case "cld":
+		obj.emit(0xfc)
+	case "iretq":
+		obj.emit(0x48, 0xcf)
+	case "pushq", "popq":
+		if err := expect(1); err != nil {
+			return err
+		}
+
+		if ops[0].reg >= 8 {
+			obj.emit(0x41)
+		}
+
+		op := byte(0x50)
+		if mnemonic == "popq" {
+			op = 0x58
+		}
+
+		obj.emit(op + byte(ops[0].reg&7))
+	case "movabsq":
+		if err := expect(2); err != nil {
+			return err
+		}
+
+		rex := byte(0x48)
+		if ops[1].reg >= 8 {
+			rex |= 0x1
+		}
+
+		obj.emit(rex, 0xb8+byte(ops[1].reg&7))
+		obj.emit(binary.LittleEndian.AppendUint64(nil, uint64(ops[0].imm))...)
+	case "leaq":
+		if err := expect(2); err != nil {
+			return err
+		}
+
+		instruction(obj, 64, []byte{0x8d}, ops[1].reg, ops[0], nil)
+	case "jmp", "jnz":
+		if err := expect(1); err != nil {
+			return err
+		}
+
+		if mnemonic == "jmp" {
+			obj.emit(0xe9)
+		} else {
+			obj.emit(0x0f, 0x85)
+		}
+
+		obj.jump(obj.offset(), obj.offset()+4, ops[0].sym)
+		obj.emit(0, 0, 0, 0)
+	case "call":
+		if err := expect(1); err != nil {
+			return err
+		}
+
+		if ops[0].isReg {
+			instruction(obj, 32, []byte{0xff}, 2, ops[0], nil)
+
+			break
+		}
+
+		obj.emit(0xe8)
+		obj.relocate(obj.offset(), ops[0].sym, uint32(elf.R_X86_64_PLT32), -4)
+		obj.emit(0, 0, 0, 0)
+	default:
+		return encodeAMD64Sized(obj, mnemonic, ops, imm32)
+	}
+
+	return nil
+}
+
+// encodeAMD64Sized encodes an instruction whose mnemonic has a suffix for the
+// size of its operands, or that extends its source.
+func encodeAMD64Sized(obj *object, mnemonic string, ops []operand, imm32 func(int64) ([]byte, error)) error {
+	if ext, ok := amd64Ext[mnemonic]; ok && len(ops) == 2 {
+		instruction(obj, ext.size, ext.opcode, ops[1].reg, ops[0], nil)
+
+		return nil
+	}
+
+	if cond, ok := strings.CutPrefix(mnemonic, "set"); ok && len(ops) == 1 {
+		cc, ok := amd64CondCodes[cond]
+		if !ok {
+			return fmt.Errorf("unknown condition %s", cond)
+		}
+
+		instruction(obj, 8, []byte{0x0f, 0x90 + cc}, 0, ops[0], nil)
+
+		return nil
+	}
+
+	name, size, ok := suffixSize(mnemonic)
+	if !ok {
+		return fmt.Errorf("unknown instruction %s", mnemonic)
+	}
+
+	switch {
+	case name == "mov" && len(ops) == 2:
+		src, dst := ops[0], ops[1]
+		opcode := byte(0x89)
+
+		if size == 8 {
+			opcode = 0x88
+		}
+
+		switch {
+		case src.isImm && dst.isReg:
+			imm, err := imm32(src.imm)
+			if err != nil {
+				return err
+			}
+
+			instruction(obj, size, []byte{0xc7}, 0, dst, imm)
+		case src.isReg:
+			instruction(obj, size, []byte{opcode}, src.reg, dst, nil)
+		case src.isMem && dst.isReg:
+			instruction(obj, size, []byte{opcode + 2}, dst.reg, src, nil)
+		default:
+			return fmt.Errorf("unsupported operands for %s", mnemonic)
+		}
+	case amd64ALU[name] != [2]byte{} && len(ops) == 2:
+		alu := amd64ALU[name]
+
+		if ops[0].isImm {
+			if alu[1] == 0xff {
+				return fmt.Errorf("unsupported operands for %s", mnemonic)
+			}
+
+			if ops[0].imm >= math.MinInt8 && ops[0].imm <= math.MaxInt8 {
+				instruction(obj, size, []byte{0x83}, int(alu[1]), ops[1], []byte{byte(int8(ops[0].imm))})
+
+				break
+			}
+
+			imm, err := imm32(ops[0].imm)
+			if err != nil {
+				return err
+			}
+
+			instruction(obj, size, []byte{0x81}, int(alu[1]), ops[1], imm)
+
+			break
+		}
+
+		instruction(obj, size, []byte{alu[0]}, ops[0].reg, ops[1], nil)
+	case name == "imul" && len(ops) == 2:
+		instruction(obj, size, []byte{0x0f, 0xaf}, ops[1].reg, ops[0], nil)
+	case (name == "idiv" || name == "div") && len(ops) == 1:
+		ext := 6
+		if name == "idiv" {
+			ext = 7
+		}
+
+		instruction(obj, size, []byte{0xf7}, ext, ops[0], nil)
+	case (name == "shl" || name == "shr") && len(ops) == 2:
+		ext := 4
+		if name == "shr" {
+			ext = 5
+		}
+
+		instruction(obj, size, []byte{0xd3}, ext, ops[1], nil)
+	default:
+		return fmt.Errorf("unknown instruction %s", mnemonic)
+	}
+
+	return nil
+}
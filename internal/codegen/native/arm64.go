@@ -0,0 +1,601 @@
+package native
+
+import (
+	"fmt"
+
+	"github.com/corani/cubit/internal/ir"
+)
+
+// arm64 generates code for AArch64 with the AAPCS64 calling convention.
+// Operands are loaded into x0 and x1, and the result is stored from x0. x16
+// holds addresses of slots that are too far from the frame pointer for an
+// offset, x9 to x12 move arguments and aggregates, and x19 to x28 hold
+// temporaries.
+type arm64 struct{}
+
+// arm64Args are the registers the first integer arguments are passed in.
+var arm64Args = []string{"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7"}
+
+// arm64Preserved are the registers calls preserve, except for the frame
+// pointer and the platform register x18.
+var arm64Preserved = []string{"x19", "x20", "x21", "x22", "x23", "x24", "x25", "x26", "x27", "x28"}
+
+// arm64Volatile are the registers a function may change without saving them,
+// which an interrupt handler saves for the code it interrupts, in pairs.
+var arm64Volatile = []string{
+	"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7", "x8", "x9",
+	"x10", "x11", "x12", "x13", "x14", "x15", "x16", "x17", "x18", "x30",
+}
+
+// arm64Conds are the condition codes of the comparisons.
+var arm64Conds = map[ir.BinOpKind]string{
+	ir.BinOpEq: "eq", ir.BinOpNe: "ne",
+	ir.BinOpLt: "lt", ir.BinOpULt: "lo",
+	ir.BinOpLe: "le", ir.BinOpULe: "ls",
+	ir.BinOpGt: "gt", ir.BinOpUGt: "hi",
+	ir.BinOpGe: "ge", ir.BinOpUGe: "hs",
+}
+
+// reg returns the name of a 64-bit register for values of type ty: the
+// register itself for longs and its 32-bit half for words.
+func (arm64) reg(reg string, ty ir.AbiTy) string {
+	if long(ty) {
+		return reg
+	}
+
+	return "w" + reg[1:]
+}
+
+// constant loads an integer into a register, 16 bits at a time if it doesn't
+// fit in a single move.
+func (arm64) constant(fn *function, reg string, i int64) {
+	if i >= 0 && i < 1<<16 || i < 0 && ^i < 1<<16 {
+		fn.emit("mov %s, #%d", reg, i)
+
+		return
+	}
+
+	op := "movz"
+
+	for shift := 0; shift < 64; shift += 16 {
+		if chunk := uint64(i) >> shift & 0xffff; chunk != 0 {
+			fn.emit("%s %s, #%d, lsl #%d", op, reg, chunk, shift)
+			op = "movk"
+		}
+	}
+}
+
+// below computes the address off bytes below the frame pointer into reg.
+func (a arm64) below(fn *function, reg string, off int) {
+	if off < 1<<12 {
+		fn.emit("sub %s, x29, #%d", reg, off)
+
+		return
+	}
+
+	a.constant(fn, "x16", int64(off))
+	fn.emit("sub %s, x29, x16", reg)
+}
+
+// slot returns the memory operand of the slot of a temporary. Offsets below
+// the frame pointer fit in a load or store up to 256 bytes.
+func (a arm64) slot(fn *function, ident ir.Ident) (string, error) {
+	off, err := fn.slot(ident)
+	if err != nil {
+		return "", err
+	}
+
+	if off <= 256 {
+		return fmt.Sprintf("[x29, #-%d]", off), nil
+	}
+
+	a.below(fn, "x16", off)
+
+	return "[x16]", nil
+}
+
+// load loads val into a register.
+func (a arm64) load(fn *function, reg string, val *ir.Val) error {
+	if val.AbiTy.IsFloat() {
+		return val.Loc.Errorf("floating point values aren't supported by the native backend")
+	}
+
+	switch {
+	case val.Type == ir.ValIdent:
+		if off, ok := fn.area(val.Ident); ok {
+			a.below(fn, reg, off)
+
+			return nil
+		}
+
+		if src, ok := fn.register(val.Ident); ok {
+			fn.emit("mov %s, %s", a.reg(reg, val.AbiTy), a.reg(src, val.AbiTy))
+
+			return nil
+		}
+
+		mem, err := a.slot(fn, val.Ident)
+		if err != nil {
+			return err
+		}
+
+		fn.emit("ldur %s, %s", a.reg(reg, val.AbiTy), mem)
+	case val.DynConst.Type == ir.DynConstThread:
+		return val.Loc.Errorf("thread-local data isn't supported by the native backend")
+	case val.DynConst.Const.Type == ir.ConstIdent:
+		fn.emit("adrp %s, %s", reg, val.DynConst.Const.Ident)
+		fn.emit("add %s, %s, :lo12:%s", reg, reg, val.DynConst.Const.Ident)
+	case val.DynConst.Const.Type == ir.ConstInteger:
+		a.constant(fn, reg, val.DynConst.Const.I64)
+	default:
+		return val.Loc.Errorf("constant %s isn't supported by the native backend", val.DynConst.Const.Type)
+	}
+
+	return nil
+}
+
+// store stores a register in the register or slot of a temporary of type ty.
+func (a arm64) store(fn *function, reg string, dst ir.Ident, ty ir.AbiTy) error {
+	if d, ok := fn.register(dst); ok {
+		fn.emit("mov %s, %s", a.reg(d, ty), a.reg(reg, ty))
+
+		return nil
+	}
+
+	mem, err := a.slot(fn, dst)
+	if err != nil {
+		return err
+	}
+
+	fn.emit("stur %s, %s", a.reg(reg, ty), mem)
+
+	return nil
+}
+
+func (arm64) comment() string {
+	return "//"
+}
+
+func (arm64) abi() abi {
+	return abi{args: arm64Args, ret: [2]string{"x0", "x1"}, sret: "x8", byRef: true}
+}
+
+func (arm64) preserved() []string {
+	return arm64Preserved
+}
+
+func (a arm64) prologue(fn *function) error {
+	switch fn.fd.CallConv {
+	case ir.CallConvNaked:
+		return nil
+	case ir.CallConvInterrupt:
+		for i := 0; i < len(arm64Volatile); i += 2 {
+			fn.emit("stp %s, %s, [sp, #-16]!", arm64Volatile[i], arm64Volatile[i+1])
+		}
+	}
+
+	fn.emit("stp x29, x30, [sp, #-16]!")
+	fn.emit("mov x29, sp")
+
+	switch size := fn.frame.size; {
+	case size >= 1<<12:
+		a.constant(fn, "x16", int64(size))
+		fn.emit("sub sp, sp, x16")
+	case size > 0:
+		fn.emit("sub sp, sp, #%d", size)
+	}
+
+	for _, reg := range fn.frame.saved {
+		if err := a.store(fn, reg, saved(reg), ir.NewAbiTyBase(ir.BaseLong)); err != nil {
+			return err
+		}
+	}
+
+	if fn.conv.sret {
+		if err := a.store(fn, fn.conv.sretReg(), sret, ir.NewAbiTyBase(ir.BaseLong)); err != nil {
+			return err
+		}
+	}
+
+	for i, param := range fn.fd.Params {
+		p := fn.conv.params[i]
+
+		if p.agg {
+			a.aggregateParam(fn, param.Ident, p)
+
+			continue
+		}
+
+		if err := a.store(fn, a.param(fn, p.words[0]), param.Ident, param.AbiTy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// param returns the register a word of a parameter is passed in. The words on
+// the stack, above the saved frame pointer and link register, are loaded into
+// x9.
+func (arm64) param(fn *function, w word) string {
+	if w.reg != "" {
+		return w.reg
+	}
+
+	fn.emit("ldr x9, [x29, #%d]", 16+w.stack)
+
+	return "x9"
+}
+
+// aggregateParam copies an aggregate parameter to its memory in the frame.
+func (a arm64) aggregateParam(fn *function, ident ir.Ident, p param) {
+	off, _ := fn.area(ident)
+	a.below(fn, "x10", off)
+
+	if p.ref {
+		if reg := a.param(fn, p.words[0]); reg != "x9" {
+			fn.emit("mov x9, %s", reg)
+		}
+
+		a.copy(fn, "x10", "x9", p.size)
+
+		return
+	}
+
+	for i, w := range p.words {
+		fn.emit("str %s, [x10, #%d]", a.param(fn, w), 8*i)
+	}
+}
+
+// arm64Moves are the loads and stores of the chunks of a copy, and the
+// register they move through.
+var arm64Moves = map[int][3]string{
+	8: {"ldr", "str", "x11"}, 4: {"ldr", "str", "w11"},
+	2: {"ldrh", "strh", "w11"}, 1: {"ldrb", "strb", "w11"},
+}
+
+// copy copies size bytes from the address in src to the address in dst. The
+// offsets of loads and stores are limited, so for large copies, src and dst
+// are moved along.
+func (arm64) copy(fn *function, dst, src string, size int) {
+	base := 0
+
+	for _, c := range chunks(size) {
+		if c.off-base >= 4096-8 {
+			fn.emit("add %s, %s, #%d", src, src, c.off-base)
+			fn.emit("add %s, %s, #%d", dst, dst, c.off-base)
+			base = c.off
+		}
+
+		m := arm64Moves[c.size]
+		fn.emit("%s %s, [%s, #%d]", m[0], m[2], src, c.off-base)
+		fn.emit("%s %s, [%s, #%d]", m[1], m[2], dst, c.off-base)
+	}
+}
+
+func (a arm64) instr(fn *function, instr ir.Instruction) error {
+	switch instr := instr.(type) {
+	case *ir.Binop:
+		return a.binop(fn, instr)
+	case *ir.Load:
+		if err := a.load(fn, "x0", instr.Addr); err != nil {
+			return err
+		}
+
+		// The load for a word result, and for a long result. Writing a 32-bit
+		// register clears the upper half.
+		ops := map[string][2]string{
+			"ub": {"ldrb", "ldrb"},
+			"sb": {"ldrsb", "ldrsb"},
+			"uh": {"ldrh", "ldrh"},
+			"sh": {"ldrsh", "ldrsh"},
+			"w":  {"ldr", "ldrsw"},
+			"l":  {"ldr", "ldr"},
+		}[memTy(instr.Ty)]
+
+		op, reg := ops[0], "w0"
+		if long(instr.Ret.AbiTy) {
+			op = ops[1]
+
+			if op != "ldrb" && op != "ldrh" {
+				reg = "x0"
+			}
+		}
+
+		fn.emit("%s %s, [x0]", op, reg)
+
+		return a.store(fn, "x0", instr.Ret.Ident, instr.Ret.AbiTy)
+	case *ir.Store:
+		if err := a.load(fn, "x0", instr.Addr); err != nil {
+			return err
+		}
+
+		if err := a.load(fn, "x1", instr.Val); err != nil {
+			return err
+		}
+
+		switch memTy(instr.Ty) {
+		case "ub", "sb":
+			fn.emit("strb w1, [x0]")
+		case "uh", "sh":
+			fn.emit("strh w1, [x0]")
+		case "l":
+			fn.emit("str x1, [x0]")
+		default:
+			fn.emit("str w1, [x0]")
+		}
+
+		return nil
+	case *ir.Convert:
+		if err := a.load(fn, "x0", instr.Val); err != nil {
+			return err
+		}
+
+		if long(instr.Ret.AbiTy) {
+			switch memTy(instr.Val.AbiTy) {
+			case "w":
+				fn.emit("sxtw x0, w0")
+			case "sb":
+				fn.emit("sxtb x0, w0")
+			case "ub":
+				fn.emit("uxtb w0, w0")
+			case "sh":
+				fn.emit("sxth x0, w0")
+			case "uh":
+				fn.emit("uxth w0, w0")
+			}
+		}
+
+		return a.store(fn, "x0", instr.Ret.Ident, instr.Ret.AbiTy)
+	case *ir.Call:
+		return a.call(fn, instr)
+	case *ir.Alloc:
+		// The memory of the slot is part of the frame.
+		return nil
+	default:
+		return fmt.Errorf("%s: instruction %T isn't supported by the native backend", instr.Location(), instr)
+	}
+}
+
+func (a arm64) binop(fn *function, b *ir.Binop) error {
+	if err := a.load(fn, "x0", b.Lhs); err != nil {
+		return err
+	}
+
+	if err := a.load(fn, "x1", b.Rhs); err != nil {
+		return err
+	}
+
+	// Comparisons operate on the type of their operands, all other operations
+	// on the type of their result.
+	ty := b.Ret.AbiTy
+	if b.Op.IsComparison() {
+		ty = b.Lhs.AbiTy
+	}
+
+	r0, r1, r2 := a.reg("x0", ty), a.reg("x1", ty), a.reg("x2", ty)
+
+	ops := map[ir.BinOpKind]string{
+		ir.BinOpAdd: "add", ir.BinOpSub: "sub", ir.BinOpMul: "mul",
+		ir.BinOpAnd: "and", ir.BinOpOr: "orr",
+		ir.BinOpDiv: "sdiv", ir.BinOpUDiv: "udiv",
+		ir.BinOpShl: "lsl", ir.BinOpShr: "lsr",
+	}
+
+	switch b.Op {
+	case ir.BinOpMod, ir.BinOpUMod:
+		// The remainder is the dividend minus the quotient times the divisor.
+		div := "sdiv"
+		if b.Op == ir.BinOpUMod {
+			div = "udiv"
+		}
+
+		fn.emit("%s %s, %s, %s", div, r2, r0, r1)
+		fn.emit("msub %s, %s, %s, %s", r0, r2, r1, r0)
+	default:
+		if op, ok := ops[b.Op]; ok {
+			fn.emit("%s %s, %s, %s", op, r0, r0, r1)
+
+			break
+		}
+
+		cond, ok := arm64Conds[b.Op]
+		if !ok {
+			return b.Loc.Errorf("unknown binop: %s", b.Op)
+		}
+
+		fn.emit("cmp %s, %s", r0, r1)
+		fn.emit("cset w0, %s", cond)
+	}
+
+	return a.store(fn, "x0", b.Ret.Ident, b.Ret.AbiTy)
+}
+
+func (a arm64) call(fn *function, c *ir.Call) error {
+	args, conv, err := fn.arguments(c)
+	if err != nil {
+		return err
+	}
+
+	// The arguments on the stack and the copies of the aggregates passed by
+	// reference are at the bottom of the stack, which stays aligned to 16
+	// bytes.
+	result := fn.result(c)
+	size := conv.stack + conv.copies
+
+	if size > 0 {
+		fn.emit("sub sp, sp, #%d", size)
+	}
+
+	for i, p := range conv.params {
+		for j, w := range p.words {
+			if w.reg != "" {
+				continue
+			}
+
+			if err := a.arg(fn, "x9", args[i], conv, p, j); err != nil {
+				return err
+			}
+
+			fn.emit("str x9, [sp, #%d]", w.stack)
+		}
+	}
+
+	for i, p := range conv.params {
+		for j, w := range p.words {
+			if w.reg == "" {
+				continue
+			}
+
+			if err := a.arg(fn, w.reg, args[i], conv, p, j); err != nil {
+				return err
+			}
+		}
+	}
+
+	if conv.sret {
+		if err := a.load(fn, conv.sretReg(), result); err != nil {
+			return err
+		}
+	}
+
+	if c.Val.Type == ir.ValDynConst && c.Val.DynConst.Const.Type == ir.ConstIdent {
+		fn.emit("bl %s", c.Val.DynConst.Const.Ident)
+	} else {
+		if err := a.load(fn, "x9", c.Val); err != nil {
+			return err
+		}
+
+		fn.emit("blr x9")
+	}
+
+	if size > 0 {
+		fn.emit("add sp, sp, #%d", size)
+	}
+
+	switch {
+	case result == nil || conv.sret:
+		return nil
+	case conv.ret > 0:
+		if err := a.load(fn, "x10", result); err != nil {
+			return err
+		}
+
+		for i := range conv.retWords() {
+			fn.emit("str %s, [x10, #%d]", conv.abi.ret[i], 8*i)
+		}
+
+		return nil
+	default:
+		return a.store(fn, "x0", *c.LHS, *c.RetTy)
+	}
+}
+
+// arg loads the i-th word of the argument val, passed as p, into reg. An
+// aggregate passed by reference is first copied below the arguments on the
+// stack.
+func (a arm64) arg(fn *function, reg string, val *ir.Val, conv *convention, p param, i int) error {
+	switch {
+	case p.ref:
+		if err := a.load(fn, "x10", val); err != nil {
+			return err
+		}
+
+		fn.emit("add x12, sp, #%d", conv.stack+p.copy)
+		a.copy(fn, "x12", "x10", p.size)
+		fn.emit("add %s, sp, #%d", reg, conv.stack+p.copy)
+	case p.agg:
+		if err := a.load(fn, "x10", val); err != nil {
+			return err
+		}
+
+		fn.emit("ldr %s, [x10, #%d]", reg, 8*i)
+	default:
+		return a.load(fn, reg, val)
+	}
+
+	return nil
+}
+
+func (a arm64) move(fn *function, dst ir.Ident, ty ir.AbiTy, val *ir.Val) error {
+	if err := a.load(fn, "x0", val); err != nil {
+		return err
+	}
+
+	return a.store(fn, "x0", dst, ty)
+}
+
+func (arm64) jump(fn *function, label string) {
+	fn.emit("b %s", label)
+}
+
+func (a arm64) branch(fn *function, cond *ir.Val, label string) error {
+	if err := a.load(fn, "x0", cond); err != nil {
+		return err
+	}
+
+	fn.emit("cbnz %s, %s", a.reg("x0", cond.AbiTy), label)
+
+	return nil
+}
+
+func (a arm64) ret(fn *function, val *ir.Val) error {
+	switch {
+	case val == nil:
+	case fn.conv.sret:
+		if err := a.load(fn, "x10", ir.NewValIdent(val.Loc, sret, ir.NewAbiTyBase(ir.BaseLong))); err != nil {
+			return err
+		}
+
+		if err := a.load(fn, "x9", val); err != nil {
+			return err
+		}
+
+		a.copy(fn, "x10", "x9", fn.conv.ret)
+	case fn.conv.ret > 0:
+		if err := a.load(fn, "x9", val); err != nil {
+			return err
+		}
+
+		for i := range fn.conv.retWords() {
+			fn.emit("ldr %s, [x9, #%d]", fn.conv.abi.ret[i], 8*i)
+		}
+	default:
+		if err := a.load(fn, "x0", val); err != nil {
+			return err
+		}
+	}
+
+	if fn.fd.CallConv == ir.CallConvNaked {
+		fn.emit("ret")
+
+		return nil
+	}
+
+	for _, reg := range fn.frame.saved {
+		if err := a.load(fn, reg, ir.NewValIdent(fn.fd.Loc, saved(reg), ir.NewAbiTyBase(ir.BaseLong))); err != nil {
+			return err
+		}
+	}
+
+	fn.emit("mov sp, x29")
+	fn.emit("ldp x29, x30, [sp], #16")
+
+	if fn.fd.CallConv == ir.CallConvInterrupt {
+		for i := len(arm64Volatile) - 2; i >= 0; i -= 2 {
+			fn.emit("ldp %s, %s, [sp], #16", arm64Volatile[i], arm64Volatile[i+1])
+		}
+
+		fn.emit("eret")
+
+		return nil
+	}
+
+	fn.emit("ret")
+
+	return nil
+}
+
+func (arm64) trap(fn *function) {
+	fn.emit("brk #1000")
+}
@@ -0,0 +1,144 @@
+package native
+
+import (
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// slotSize is the size of the slot of a temporary. A slot holds a long, so a
+// slot fits every temporary.
+const slotSize = 8
+
+// frame is the layout of the stack frame of a function, below the frame
+// pointer: a slot for every temporary without a register, including the
+// parameters, a slot for every register the function saves, and the memory of
+// the stack slots the function allocates. Aggregate parameters and the
+// aggregate results of calls have memory like stack slots, so their value is
+// its address.
+type frame struct {
+	slots map[ir.Ident]int    // temporary -> offset of its slot below the frame pointer
+	areas map[ir.Ident]int    // stack slot -> offset of its memory below the frame pointer
+	regs  map[ir.Ident]string // temporary -> the register it's allocated to
+	saved []string            // registers the function saves, as it uses them
+	size  int                 // size of the frame, a multiple of 16
+}
+
+// incoming returns the name of the slot the predecessors of a phi move its
+// argument to. It can't clash with the name of a temporary.
+func incoming(phi ir.Ident) ir.Ident {
+	return phi + "#in"
+}
+
+// sret is the name of the slot of the address a function writes its result
+// to, if the caller passes it. It can't clash with the name of a temporary.
+const sret ir.Ident = "#sret"
+
+// newFrame lays out the frame of fd, with the temporaries that are local to a
+// block allocated to the registers in pool.
+func newFrame(fd *ir.FuncDef, unit *ir.CompilationUnit, pool []string) (*frame, error) {
+	f := &frame{
+		slots: make(map[ir.Ident]int),
+		areas: make(map[ir.Ident]int),
+		regs:  allocate(fd, pool),
+	}
+
+	temp := func(ident ir.Ident) {
+		if _, ok := f.regs[ident]; ok {
+			return
+		}
+
+		if _, ok := f.slots[ident]; !ok {
+			f.size += slotSize
+			f.slots[ident] = f.size
+		}
+	}
+
+	// value gives ident of type ty a slot, or memory if it's an aggregate.
+	value := func(loc lexer.Location, ident ir.Ident, ty ir.AbiTy) error {
+		if ty.Type != ir.AbiTyIdent {
+			temp(ident)
+
+			return nil
+		}
+
+		l, err := unit.Layout(ty.Ident)
+		if err != nil {
+			return loc.Errorf("%v", err)
+		}
+
+		f.size = align(f.size+l.Size, 8)
+		f.areas[ident] = f.size
+
+		return nil
+	}
+
+	if fd.RetTy != nil && fd.RetTy.Type == ir.AbiTyIdent {
+		temp(sret)
+	}
+
+	for _, param := range fd.Params {
+		if param.Type != ir.ParamRegular {
+			return nil, param.Loc.Errorf("%s parameters aren't supported by the native backend", param.Type)
+		}
+
+		if err := value(param.Loc, param.Ident, param.AbiTy); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, block := range fd.Blocks {
+		for _, instr := range block.Instructions {
+			switch instr := instr.(type) {
+			case *ir.Alloc:
+				size, ok := instr.Size.IntConst()
+				if !ok {
+					return nil, instr.Loc.Errorf("stack slot %%%s has a size that isn't constant", instr.Ret.Ident)
+				}
+
+				// The frame pointer is aligned to 16 bytes, so an offset that
+				// is a multiple of the alignment gives an aligned address.
+				f.size = align(f.size+int(size), instr.Align)
+				f.areas[instr.Ret.Ident] = f.size
+			case *ir.Phi:
+				temp(instr.Ret.Ident)
+				temp(incoming(instr.Ret.Ident))
+			case *ir.Binop:
+				temp(instr.Ret.Ident)
+			case *ir.Load:
+				temp(instr.Ret.Ident)
+			case *ir.Convert:
+				temp(instr.Ret.Ident)
+			case *ir.Call:
+				if instr.LHS != nil && instr.RetTy != nil {
+					if err := value(instr.Loc, *instr.LHS, *instr.RetTy); err != nil {
+						return nil, err
+					}
+				} else if instr.LHS != nil {
+					temp(*instr.LHS)
+				}
+			}
+		}
+	}
+
+	// The registers the function uses are saved below everything else.
+	used := make(map[string]bool)
+	for _, reg := range f.regs {
+		used[reg] = true
+	}
+
+	for _, reg := range pool {
+		if used[reg] {
+			f.saved = append(f.saved, reg)
+			temp(saved(reg))
+		}
+	}
+
+	f.size = align(f.size, 16)
+
+	return f, nil
+}
+
+// align rounds n up to a multiple of a.
+func align(n, a int) int {
+	return (n + a - 1) / a * a
+}
@@ -0,0 +1,537 @@
+// Package native generates assembly for the GNU assembler from the IR, without
+// QBE. Temporaries that are only used in the block that defines them are
+// allocated to the registers calls preserve, by a linear scan; all others have
+// a slot in the stack frame. Instructions load their operands into scratch
+// registers and store their result back to its register or slot. The code is
+// slower than QBE's, but building only needs an assembler and a linker.
+package native
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/parallel"
+)
+
+// Options control the generated code.
+type Options struct {
+	// DebugInfo emits the source file and lines of the code, so the assembly
+	// has line tables for debuggers.
+	DebugInfo bool
+	// Jobs is the number of functions generated at once, GOMAXPROCS if it's
+	// 0. The assembly is the same for any number.
+	Jobs int
+}
+
+// arch generates the instructions of one architecture. Generate lays out the
+// frame, walks the blocks and resolves the phis; the architecture emits the
+// code for every instruction.
+type arch interface {
+	// comment returns the characters that start a comment.
+	comment() string
+	// abi returns how the arguments and results of calls are passed.
+	abi() abi
+	// preserved returns the registers calls preserve, which temporaries are
+	// allocated to.
+	preserved() []string
+	// prologue sets up the frame of fn, saves the registers fn uses that
+	// calls preserve, and stores the parameters of fn in their slots. An
+	// interrupt handler saves the registers the code it interrupts uses
+	// first, and a naked function has no prologue.
+	prologue(fn *function) error
+	// instr emits an instruction that isn't a phi or a terminator.
+	instr(fn *function, instr ir.Instruction) error
+	// move stores val in the slot of dst.
+	move(fn *function, dst ir.Ident, ty ir.AbiTy, val *ir.Val) error
+	// jump jumps to label.
+	jump(fn *function, label string)
+	// branch jumps to label if cond isn't zero.
+	branch(fn *function, cond *ir.Val, label string) error
+	// ret returns val, or nothing if val is nil, after restoring the
+	// registers the prologue saved. An interrupt handler restores the
+	// registers and returns from the interrupt.
+	ret(fn *function, val *ir.Val) error
+	// trap stops the program.
+	trap(fn *function)
+}
+
+// archs are the architectures there's a native backend for, by GOARCH.
+var archs = map[string]arch{
+	"amd64":   amd64{},
+	"arm64":   arm64{},
+	"riscv64": riscv64{},
+}
+
+// Supported reports whether there's a native backend for goarch.
+func Supported(goarch string) bool {
+	_, ok := archs[goarch]
+
+	return ok
+}
+
+// Generate writes the assembly of unit for goarch to w. Extern functions are
+// left to the linker.
+func Generate(w io.Writer, unit *ir.CompilationUnit, goarch string, opts Options) error {
+	a, ok := archs[goarch]
+	if !ok {
+		return fmt.Errorf("no native backend for %s", goarch)
+	}
+
+	g := &generator{arch: a, opts: opts, unit: unit, files: make(map[string]int)}
+
+	fmt.Fprintf(&g.out, "%s package %s (%s)\n", a.comment(), unit.Package, unit.Loc)
+
+	// The functions are generated in parallel, each by a generator of its
+	// own, and written in order. The source files are numbered up front,
+	// and declared by the first function with lines in them.
+	declares := g.numberFiles()
+	code := make([]string, len(unit.FuncDefs))
+
+	if err := parallel.Do(len(unit.FuncDefs), opts.Jobs, nil, func(i int) error {
+		if unit.FuncDefs[i].Blocks == nil {
+			return nil
+		}
+
+		fg := &generator{arch: a, opts: opts, unit: unit, files: g.files, declare: declares[i]}
+		err := fg.function(&unit.FuncDefs[i])
+		code[i] = fg.out.String()
+
+		return err
+	}); err != nil {
+		return err
+	}
+
+	for _, c := range code {
+		g.out.WriteString(c)
+	}
+
+	for _, dd := range unit.DataDefs {
+		if err := g.data(dd); err != nil {
+			return err
+		}
+	}
+
+	g.out.WriteString("\t.section .note.GNU-stack,\"\",%progbits\n")
+
+	_, err := io.WriteString(w, g.out.String())
+
+	return err
+}
+
+type generator struct {
+	arch  arch
+	opts  Options
+	unit  *ir.CompilationUnit
+	out   strings.Builder
+	files map[string]int // source files, by their number in the line tables
+
+	// declare is the source file the function being generated declares, as
+	// the first one with lines in it.
+	declare string
+}
+
+// function is the function being generated.
+type function struct {
+	*generator
+
+	fd    *ir.FuncDef
+	frame *frame
+	conv  *convention // how the parameters and the result are passed
+	stubs []stub      // edges with phi moves, emitted after the block
+}
+
+// stub is the code on an edge that moves the phi arguments for the target,
+// when the edge starts at a conditional jump.
+type stub struct {
+	label string
+	from  *ir.Block
+	to    string
+}
+
+// emit writes an instruction.
+func (fn *function) emit(format string, args ...any) {
+	fmt.Fprintf(&fn.out, "\t"+format+"\n", args...)
+}
+
+// label returns the assembly label of a block. Labels are local to the file,
+// so they're prefixed with the function.
+func (fn *function) label(block string) string {
+	return fmt.Sprintf(".L%s.%s", fn.fd.Ident, block)
+}
+
+// arguments returns the arguments of a call, and how they're passed.
+func (fn *function) arguments(c *ir.Call) ([]*ir.Val, *convention, error) {
+	var (
+		args  []*ir.Val
+		types []ir.AbiTy
+	)
+
+	for _, arg := range c.Args {
+		switch arg.Type {
+		case ir.ArgRegular:
+			args = append(args, arg.Val)
+			types = append(types, arg.Val.AbiTy)
+		case ir.ArgVariadic:
+			// Variadic arguments are passed like the others.
+		default:
+			return nil, nil, arg.Loc.Errorf("%s arguments aren't supported by the native backend", arg.Type)
+		}
+	}
+
+	conv, err := fn.convention(c.Loc, types, c.RetTy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return args, conv, nil
+}
+
+// result returns the value of the result of a call, which is the address of
+// its memory if it's an aggregate, or nil if the result isn't used.
+func (fn *function) result(c *ir.Call) *ir.Val {
+	if c.LHS == nil || c.RetTy == nil {
+		return nil
+	}
+
+	return ir.NewValIdent(c.Loc, *c.LHS, *c.RetTy)
+}
+
+// register returns the register a temporary is allocated to, if it has one.
+func (fn *function) register(ident ir.Ident) (string, bool) {
+	reg, ok := fn.frame.regs[ident]
+
+	return reg, ok
+}
+
+// slot returns the offset of the slot of a temporary below the frame pointer.
+func (fn *function) slot(ident ir.Ident) (int, error) {
+	off, ok := fn.frame.slots[ident]
+	if !ok {
+		return 0, fmt.Errorf("%s: temporary %%%s has no slot", fn.fd.Ident, ident)
+	}
+
+	return off, nil
+}
+
+// area returns the offset below the frame pointer of the memory a stack slot
+// allocated, if ident is the address of one.
+func (fn *function) area(ident ir.Ident) (int, bool) {
+	off, ok := fn.frame.areas[ident]
+
+	return off, ok
+}
+
+func (g *generator) function(fd *ir.FuncDef) error {
+	// A naked function has no frame to save the registers in.
+	var pool []string
+	if fd.CallConv != ir.CallConvNaked {
+		pool = g.arch.preserved()
+	}
+
+	frame, err := newFrame(fd, g.unit, pool)
+	if err != nil {
+		return err
+	}
+
+	params := make([]ir.AbiTy, len(fd.Params))
+	for i, param := range fd.Params {
+		params[i] = param.AbiTy
+	}
+
+	conv, err := g.convention(fd.Loc, params, fd.RetTy)
+	if err != nil {
+		return err
+	}
+
+	// A naked function has no frame, so it can only call other functions,
+	// without arguments that need a temporary.
+	if fd.CallConv == ir.CallConvNaked && frame.size > 0 {
+		return fd.Loc.Errorf("naked function %s needs a stack frame for its parameters and temporaries", fd.Ident)
+	}
+
+	fn := &function{generator: g, fd: fd, frame: frame, conv: conv}
+
+	fmt.Fprintf(&g.out, "\n%s %s\n\t.text\n\t.balign 16\n", g.arch.comment(), fd.Loc)
+	g.linkage(fd.Linkage, string(fd.Ident), "function")
+	fmt.Fprintf(&g.out, "%s:\n", fd.Ident)
+
+	if err := g.arch.prologue(fn); err != nil {
+		return err
+	}
+
+	line := 0
+
+	for i := range fd.Blocks {
+		block := &fd.Blocks[i]
+		next := ""
+
+		if i+1 < len(fd.Blocks) {
+			next = fd.Blocks[i+1].Label
+		}
+
+		fmt.Fprintf(&g.out, "%s:\n", fn.label(block.Label))
+
+		for _, instr := range block.Instructions {
+			if loc := instr.Location(); g.opts.DebugInfo && loc.Filename == fd.Loc.Filename && loc.Line > 0 && loc.Line != line {
+				line = loc.Line
+				fn.emit(".loc %d %d", g.file(loc.Filename), line)
+			}
+
+			if err := fn.instruction(block, instr, next); err != nil {
+				return err
+			}
+		}
+
+		for _, s := range fn.stubs {
+			fmt.Fprintf(&g.out, "%s:\n", s.label)
+
+			if err := fn.moves(s.from, s.to); err != nil {
+				return err
+			}
+
+			g.arch.jump(fn, fn.label(s.to))
+		}
+
+		fn.stubs = nil
+	}
+
+	fmt.Fprintf(&g.out, "\t.size %s, .-%s\n", fd.Ident, fd.Ident)
+
+	return nil
+}
+
+// instruction generates an instruction of block. Jumps to next are left out,
+// as the code of next follows.
+func (fn *function) instruction(block *ir.Block, instr ir.Instruction, next string) error {
+	switch instr := instr.(type) {
+	case *ir.Phi:
+		// The predecessors moved the argument to the incoming slot of the phi.
+		in := ir.NewValIdent(instr.Loc, incoming(instr.Ret.Ident), instr.Ret.AbiTy)
+
+		return fn.arch.move(fn, instr.Ret.Ident, instr.Ret.AbiTy, in)
+	case *ir.Jmp:
+		if err := fn.moves(block, instr.Label); err != nil {
+			return err
+		}
+
+		if instr.Label != next {
+			fn.arch.jump(fn, fn.label(instr.Label))
+		}
+
+		return nil
+	case *ir.Jnz:
+		if err := fn.arch.branch(fn, instr.Cond, fn.edge(block, instr.True)); err != nil {
+			return err
+		}
+
+		// The stubs are emitted after the jump, so with stubs, the code of
+		// next doesn't follow.
+		if label := fn.edge(block, instr.False); label != fn.label(next) || len(fn.stubs) > 0 {
+			fn.arch.jump(fn, label)
+		}
+
+		return nil
+	case *ir.Ret:
+		return fn.arch.ret(fn, instr.Val)
+	case *ir.Hlt:
+		fn.arch.trap(fn)
+
+		return nil
+	default:
+		return fn.arch.instr(fn, instr)
+	}
+}
+
+// edge returns the label to jump to from block to the block labeled to. If to
+// has phis, that's a stub that moves their arguments first.
+func (fn *function) edge(block *ir.Block, to string) string {
+	if !slices.ContainsFunc(fn.fd.Blocks, func(b ir.Block) bool {
+		return b.Label == to && slices.ContainsFunc(b.Instructions, func(instr ir.Instruction) bool {
+			_, ok := instr.(*ir.Phi)
+			return ok
+		})
+	}) {
+		return fn.label(to)
+	}
+
+	label := fmt.Sprintf("%s.%s", fn.label(block.Label), to)
+
+	if !slices.ContainsFunc(fn.stubs, func(s stub) bool { return s.label == label }) {
+		fn.stubs = append(fn.stubs, stub{label: label, from: block, to: to})
+	}
+
+	return label
+}
+
+// moves moves the arguments the phis of the block labeled to receive from
+// block to their incoming slots. The phis read them at the start of the block,
+// so phis that use each other see the values from before the jump.
+func (fn *function) moves(block *ir.Block, to string) error {
+	for _, b := range fn.fd.Blocks {
+		if b.Label != to {
+			continue
+		}
+
+		for _, instr := range b.Instructions {
+			phi, ok := instr.(*ir.Phi)
+			if !ok {
+				break
+			}
+
+			for _, arg := range phi.Args {
+				if arg.Label != block.Label {
+					continue
+				}
+
+				if err := fn.arch.move(fn, incoming(phi.Ret.Ident), phi.Ret.AbiTy, arg.Val); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// numberFiles numbers the source files of the functions with lines, in the
+// order of the functions, and returns the file every function declares.
+func (g *generator) numberFiles() []string {
+	declares := make([]string, len(g.unit.FuncDefs))
+
+	if !g.opts.DebugInfo {
+		return declares
+	}
+
+	for i, fd := range g.unit.FuncDefs {
+		if _, ok := g.files[fd.Loc.Filename]; ok {
+			continue
+		}
+
+		// Only the lines in the file of the function are emitted.
+		if slices.ContainsFunc(fd.Blocks, func(block ir.Block) bool {
+			return slices.ContainsFunc(block.Instructions, func(instr ir.Instruction) bool {
+				loc := instr.Location()
+				return loc.Filename == fd.Loc.Filename && loc.Line > 0
+			})
+		}) {
+			g.files[fd.Loc.Filename] = len(g.files) + 1
+			declares[i] = fd.Loc.Filename
+		}
+	}
+
+	return declares
+}
+
+// file returns the number of a source file in the line tables, and declares
+// it the first time, if the function being generated declares it.
+func (g *generator) file(name string) int {
+	if name == g.declare {
+		fmt.Fprintf(&g.out, "\t.file %d %q\n", g.files[name], name)
+		g.declare = ""
+	}
+
+	return g.files[name]
+}
+
+// linkage emits the directives for the linkage of a symbol of the given type.
+func (g *generator) linkage(linkage *ir.Linkage, name, ty string) {
+	if linkage != nil {
+		switch linkage.Type {
+		case ir.LinkageExport:
+			fmt.Fprintf(&g.out, "\t.globl %s\n", name)
+		case ir.LinkageSection:
+			if linkage.SecFlags == "" {
+				fmt.Fprintf(&g.out, "\t.section %s\n", linkage.SecName)
+			} else {
+				fmt.Fprintf(&g.out, "\t.section %s, %q\n", linkage.SecName, linkage.SecFlags)
+			}
+		}
+	}
+
+	fmt.Fprintf(&g.out, "\t.type %s, %%%s\n", name, ty)
+}
+
+func (g *generator) data(dd ir.DataDef) error {
+	if dd.Linkage != nil && dd.Linkage.Type == ir.LinkageThread {
+		return dd.Loc.Errorf("thread-local data $%s isn't supported by the native backend", dd.Ident)
+	}
+
+	align := dd.Align
+	if align == 0 {
+		align = 8
+	}
+
+	fmt.Fprintf(&g.out, "\n\t.data\n\t.balign %d\n", align)
+	g.linkage(dd.Linkage, string(dd.Ident), "object")
+	fmt.Fprintf(&g.out, "%s:\n", dd.Ident)
+
+	directives := map[int]string{1: ".byte", 2: ".2byte", 4: ".4byte", 8: ".8byte"}
+
+	for _, init := range dd.Initializer {
+		if init.Type == ir.DataInitZero {
+			fmt.Fprintf(&g.out, "\t.zero %d\n", init.Size)
+
+			continue
+		}
+
+		for _, item := range init.Items {
+			switch item.Type {
+			case ir.DataItemString:
+				fmt.Fprintf(&g.out, "\t.ascii \"%s\"\n", escape(ir.Unescape(item.StringVal)))
+			case ir.DataItemSymbol:
+				fmt.Fprintf(&g.out, "\t%s %s+%d\n", directives[init.ExtTy.Size()], item.Ident, item.Offset)
+			default:
+				value, err := constant(item.Const)
+				if err != nil {
+					return item.Loc.Errorf("%v", err)
+				}
+
+				fmt.Fprintf(&g.out, "\t%s %s\n", directives[init.ExtTy.Size()], value)
+			}
+		}
+	}
+
+	fmt.Fprintf(&g.out, "\t.size %s, .-%s\n", dd.Ident, dd.Ident)
+
+	return nil
+}
+
+// constant returns the assembly of a constant in data.
+func constant(c ir.Const) (string, error) {
+	switch c.Type {
+	case ir.ConstInteger:
+		return fmt.Sprint(c.I64), nil
+	case ir.ConstSingle:
+		return fmt.Sprint(math.Float32bits(c.F32)), nil
+	case ir.ConstDouble:
+		return fmt.Sprint(math.Float64bits(c.F64)), nil
+	case ir.ConstIdent:
+		return string(c.Ident), nil
+	default:
+		return "", fmt.Errorf("unknown constant type: %s", c.Type)
+	}
+}
+
+// escape escapes a string for .ascii: printable characters are kept, all
+// others are written in octal.
+func escape(s string) string {
+	var sb strings.Builder
+
+	for _, b := range []byte(s) {
+		switch {
+		case b == '"' || b == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case b >= ' ' && b <= '~':
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "\\%03o", b)
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,590 @@
+package native
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/ir/passes"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/corani/cubit/internal/testutil/irtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	unit := irtest.LowerWithPrintf(t, `
+@(export)
+main :: func() -> int {
+    printf("hello\n")
+
+    return 0
+}`)
+
+	tt := []struct {
+		goarch   string
+		expected []string
+	}{
+		{
+			goarch:   "amd64",
+			expected: []string{"# package main", "\tcall printf\n", "\tleaq _str_0001(%rip), %rdi\n"},
+		},
+		{
+			goarch:   "arm64",
+			expected: []string{"// package main", "\tbl printf\n", "\tadd x0, x0, :lo12:_str_0001\n"},
+		},
+		{
+			goarch:   "riscv64",
+			expected: []string{"# package main", "\tcall printf\n", "\tlla a0, _str_0001\n"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.goarch, func(t *testing.T) {
+			t.Parallel()
+
+			var out bytes.Buffer
+			require.NoError(t, Generate(&out, unit, tc.goarch, Options{}))
+
+			asm := out.String()
+			require.Contains(t, asm, "\t.globl main\n")
+			require.Contains(t, asm, "\t.ascii \"hello\\012\"\n")
+			require.True(t, strings.HasSuffix(asm, "\t.section .note.GNU-stack,\"\",%progbits\n"))
+
+			for _, expected := range tc.expected {
+				require.Contains(t, asm, expected)
+			}
+		})
+	}
+
+	require.Error(t, Generate(&bytes.Buffer{}, unit, "mips", Options{}))
+}
+
+func TestGenerate_CallConv(t *testing.T) {
+	t.Parallel()
+
+	unit := irtest.LowerWithPrintf(t, `
+@(extern)
+boot :: func()
+
+@(extern)
+tick :: func(n: int)
+
+@(export, callconv="naked")
+_start :: func() {
+    boot()
+}
+
+@(export, callconv="interrupt")
+timer :: func() {
+    tick(1)
+}`)
+
+	tt := []struct {
+		goarch   string
+		expected []string
+	}{
+		{
+			goarch: "amd64",
+			expected: []string{
+				"_start:\n.L_start.start:\n\txorl %eax, %eax\n\tcall boot\n\tret\n",
+				"timer:\n\tpushq %rax\n\tpushq %rcx\n",
+				"\tpushq %r11\n\tsubq $8, %rsp\n\tcld\n\tpushq %rbp\n",
+				"\tleave\n\taddq $8, %rsp\n\tpopq %r11\n",
+				"\tpopq %rax\n\tiretq\n",
+			},
+		},
+		{
+			goarch: "arm64",
+			expected: []string{
+				"_start:\n.L_start.start:\n\tbl boot\n\tret\n",
+				"timer:\n\tstp x0, x1, [sp, #-16]!\n",
+				"\tldp x0, x1, [sp], #16\n\teret\n",
+			},
+		},
+		{
+			goarch: "riscv64",
+			expected: []string{
+				"_start:\n.L_start.start:\n\tcall boot\n\tret\n",
+				"timer:\n\taddi sp, sp, -128\n\tsd ra, 0(sp)\n",
+				"\tld a7, 120(sp)\n\taddi sp, sp, 128\n\tmret\n",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.goarch, func(t *testing.T) {
+			t.Parallel()
+
+			var out bytes.Buffer
+			require.NoError(t, Generate(&out, unit, tc.goarch, Options{}))
+
+			for _, expected := range tc.expected {
+				require.Contains(t, out.String(), expected)
+			}
+		})
+	}
+
+	// The object writer encodes the instructions of the handler.
+	require.NoError(t, Object(&bytes.Buffer{}, unit, "amd64", Options{}))
+
+	naked := irtest.LowerWithPrintf(t, `
+@(extern)
+count :: func() -> int
+
+@(export, callconv="naked")
+_start :: func() {
+    printf("%d\n", count())
+}`)
+
+	err := Generate(&bytes.Buffer{}, naked, "amd64", Options{})
+	require.EqualError(t, err, "test.in:10:1: naked function _start needs a stack frame for its parameters and temporaries")
+}
+
+// aggregates returns a unit whose functions pass aggregates in registers, on
+// the stack and in memory, to each other and to the C functions in
+// aggregatesC.
+func aggregates() *ir.CompilationUnit {
+	loc := lexer.Location{Filename: "test.ssa"}
+	l, w := ir.NewAbiTyBase(ir.BaseLong), ir.NewAbiTyBase(ir.BaseWord)
+	pair, small, big, tiny := ir.NewAbiTyIdent("pair"), ir.NewAbiTyIdent("small"), ir.NewAbiTyIdent("big"), ir.NewAbiTyIdent("tiny")
+
+	val := func(ident string, ty ir.AbiTy) *ir.Val { return ir.NewValIdent(loc, ir.Ident(ident), ty) }
+	num := func(i int64) *ir.Val { return ir.NewValInteger(loc, i, l) }
+	fun := func(ident string) *ir.Val { return ir.NewValGlobal(loc, ir.Ident(ident), l) }
+	arg := func(v *ir.Val) ir.Arg { return ir.NewArgRegular(loc, v) }
+	add := func(ret string, lhs, rhs *ir.Val) *ir.Binop {
+		return ir.NewBinop(loc, ir.BinOpAdd, val(ret, l), lhs, rhs)
+	}
+	mul := func(ret string, lhs, rhs *ir.Val) *ir.Binop {
+		return ir.NewBinop(loc, ir.BinOpMul, val(ret, l), lhs, rhs)
+	}
+	export := ir.NewLinkageExport(loc)
+
+	// swap returns a pair with the fields of p swapped.
+	swap := ir.NewFuncDef(loc, "swap", ir.NewParamRegular(loc, pair, "p")).
+		WithLinkage(export).WithRetTy(pair).
+		WithBlocks(ir.NewBlock(loc, "start", []ir.Instruction{
+			ir.NewAlloc(loc, val("r", l), num(8)),
+			ir.NewLoad(loc, val("a", w), val("p", pair)),
+			add("pb", val("p", pair), num(4)),
+			ir.NewLoad(loc, val("b", w), val("pb", l)),
+			ir.NewStore(loc, val("r", l), val("b", w)),
+			add("rb", val("r", l), num(4)),
+			ir.NewStore(loc, val("rb", l), val("a", w)),
+			ir.NewRet(loc, val("r", pair)),
+		}))
+
+	// scale returns g * k + {s.a, s.b, a + b + c + d + e + f}. The result is
+	// returned in memory, so s doesn't fit in the registers left.
+	scale := ir.NewFuncDef(loc, "scale",
+		ir.NewParamRegular(loc, l, "a"), ir.NewParamRegular(loc, l, "b"), ir.NewParamRegular(loc, l, "c"),
+		ir.NewParamRegular(loc, l, "d"), ir.NewParamRegular(loc, l, "e"), ir.NewParamRegular(loc, l, "f"),
+		ir.NewParamRegular(loc, small, "s"), ir.NewParamRegular(loc, big, "g"), ir.NewParamRegular(loc, l, "k")).
+		WithLinkage(export).WithRetTy(big).
+		WithBlocks(ir.NewBlock(loc, "start", []ir.Instruction{
+			ir.NewAlloc(loc, val("r", l), num(24)),
+			add("sum1", val("a", l), val("b", l)),
+			add("sum2", val("sum1", l), val("c", l)),
+			add("sum3", val("sum2", l), val("d", l)),
+			add("sum4", val("sum3", l), val("e", l)),
+			add("sum", val("sum4", l), val("f", l)),
+			ir.NewLoad(loc, val("s0", l), val("s", small)),
+			add("s8", val("s", small), num(8)),
+			ir.NewLoad(loc, val("s1", l), val("s8", l)),
+			ir.NewLoad(loc, val("g0", l), val("g", big)),
+			add("g8", val("g", big), num(8)),
+			ir.NewLoad(loc, val("g1", l), val("g8", l)),
+			add("g16", val("g", big), num(16)),
+			ir.NewLoad(loc, val("g2", l), val("g16", l)),
+			mul("x0", val("g0", l), val("k", l)),
+			add("y0", val("x0", l), val("s0", l)),
+			mul("x1", val("g1", l), val("k", l)),
+			add("y1", val("x1", l), val("s1", l)),
+			mul("x2", val("g2", l), val("k", l)),
+			add("y2", val("x2", l), val("sum", l)),
+			ir.NewStore(loc, val("r", l), val("y0", l)),
+			add("r8", val("r", l), num(8)),
+			ir.NewStore(loc, val("r8", l), val("y1", l)),
+			add("r16", val("r", l), num(16)),
+			ir.NewStore(loc, val("r16", l), val("y2", l)),
+			ir.NewRet(loc, val("r", big)),
+		}))
+
+	// relay passes the results of C functions to another one, and adds the
+	// last byte of a tiny.
+	relay := ir.NewFuncDef(loc, "relay", ir.NewParamRegular(loc, l, "x")).
+		WithLinkage(export).WithRetTy(l).
+		WithBlocks(ir.NewBlock(loc, "start", []ir.Instruction{
+			ir.NewCall(loc, fun("mkbig"), arg(val("x", l))).WithRet("g", big),
+			ir.NewCall(loc, fun("mksmall"), arg(val("x", l))).WithRet("s", small),
+			ir.NewCall(loc, fun("mktiny"), arg(val("x", l))).WithRet("t", tiny),
+			ir.NewCall(loc, fun("sumall"),
+				arg(num(1)), arg(num(2)), arg(num(3)), arg(num(4)), arg(num(5)),
+				arg(val("s", small)), arg(val("g", big)), arg(num(7)), arg(val("t", tiny))).WithRet("r", l),
+			add("t2", val("t", tiny), num(2)),
+			ir.NewLoad(loc, val("c", l), val("t2", l)).WithTy(ir.NewAbiTySubW(ir.SubWUB)),
+			add("z", val("r", l), val("c", l)),
+			ir.NewRet(loc, val("z", l)),
+		}))
+
+	return ir.NewCompilationUnit().WithPackage("main", loc).
+		WithTypes(
+			ir.NewTypeDefRegular(loc, "pair", ir.NewSubTyExtSize(ir.ExtWord, 2)),
+			ir.NewTypeDefRegular(loc, "small", ir.NewSubTyExtSize(ir.ExtLong, 2)),
+			ir.NewTypeDefRegular(loc, "big", ir.NewSubTyExtSize(ir.ExtLong, 3)),
+			ir.NewTypeDefRegular(loc, "tiny", ir.NewSubTyExtSize(ir.ExtByte, 3)),
+		).
+		WithFuncDefs(swap, scale, relay)
+}
+
+// aggregatesC calls the functions of aggregates, and defines the functions
+// they call.
+const aggregatesC = `#include <stdio.h>
+
+typedef struct { int a, b; } pair;
+typedef struct { long a, b; } small;
+typedef struct { long a, b, c; } big;
+typedef struct { unsigned char c[3]; } tiny;
+
+pair swap(pair p);
+big scale(long a, long b, long c, long d, long e, long f, small s, big g, long k);
+long relay(long x);
+
+big mkbig(long x) { return (big){x, x + 1, x + 2}; }
+small mksmall(long x) { return (small){10 * x, 20 * x}; }
+tiny mktiny(long x) { return (tiny){{x, x + 1, x + 2}}; }
+
+long sumall(long a, long b, long c, long d, long e, small s, big g, long k, tiny t) {
+    return a + b + c + d + e + s.a + s.b + g.a * k + g.b + g.c + t.c[0] * 1000 + t.c[2] * 100000;
+}
+
+int main(void) {
+    pair p = swap((pair){1, 2});
+    big g = scale(1, 2, 3, 4, 5, 6, (small){6, 7}, (big){8, 9, 10}, 11);
+
+    printf("%d %d %ld %ld %ld %ld\n", p.a, p.b, g.a, g.b, g.c, relay(3));
+
+    return 0;
+}
+`
+
+func TestGenerate_Aggregates(t *testing.T) {
+	t.Parallel()
+
+	unit := aggregates()
+
+	tt := []struct {
+		goarch   string
+		expected []string
+	}{
+		{
+			goarch: "amd64",
+			expected: []string{
+				// A pair is passed and returned in a register.
+				"\tleaq -16(%rbp), %r11\n\tmovq %rdi, 0(%r11)\n",
+				"\tleaq -24(%rbp), %r11\n\tmovq 0(%r11), %rax\n\tmovq -32(%rbp), %rbx\n",
+				// The temporaries of swap are in registers, which are saved
+				// below its memory, and restored before it returns.
+				"\tmovq %rbx, -32(%rbp)\n\tmovq %r12, -40(%rbp)\n",
+				"\tmovl (%rax), %eax\n\tmovl %eax, %ebx\n",
+				"\tmovq -56(%rbp), %r14\n\tleave\n",
+				// s and g are on the stack.
+				"\tleaq -72(%rbp), %r11\n\tmovq 24(%rbp), %rax\n\tmovq %rax, 0(%r11)\n",
+				// The result of scale is copied to the address the caller
+				// passed, which is returned.
+				"\tmovq -8(%rbp), %rax\n\tleaq -128(%rbp), %r11\n\tmovq 0(%r11), %r10\n\tmovq %r10, 0(%rax)\n",
+				"\tleaq -32(%rbp), %rdi\n\txorl %eax, %eax\n\tcall mkbig\n",
+				"\tcall mksmall\n\tleaq -48(%rbp), %r11\n\tmovq %rax, 0(%r11)\n\tmovq %rdx, 8(%r11)\n",
+				// s doesn't fit in the last register, but 7 does.
+				"\tmovq $5, %r8\n\tmovq $7, %r9\n",
+			},
+		},
+		{
+			goarch: "arm64",
+			expected: []string{
+				"\tsub x10, x29, #16\n\tstr x0, [x10, #0]\n",
+				"\tsub x9, x29, #24\n\tldr x0, [x9, #0]\n",
+				"\tstur x8, [x29, #-8]\n",
+				// g is passed as the address of a copy.
+				"\tldr x9, [x29, #16]\n\tldr x11, [x9, #0]\n",
+				"\tadd x12, sp, #16\n",
+				"\tadd x7, sp, #16\n\tbl sumall\n",
+				"\tsub x8, x29, #32\n\tbl mkbig\n",
+				"\tstur x19, [x29, #-32]\n\tstur x20, [x29, #-40]\n",
+				"\tldr w0, [x0]\n\tmov w19, w0\n",
+				"\tldur x22, [x29, #-56]\n\tmov sp, x29\n",
+			},
+		},
+		{
+			goarch: "riscv64",
+			expected: []string{
+				"\taddi t1, s0, -16\n\tsd a0, 0(t1)\n",
+				// s takes the last register and the stack.
+				"\taddi t1, s0, -72\n\tsd a7, 0(t1)\n\tld t0, 16(s0)\n\tsd t0, 8(t1)\n",
+				"\tld t0, 24(s0)\n\tld t2, 0(t0)\n",
+				"\taddi a0, s0, -32\n\tcall mkbig\n",
+				// A word is kept sign-extended in its register.
+				"\tlw a0, 0(a0)\n\tsext.w s1, a0\n",
+				"\tsd s1, -32(s0)\n\tsd s2, -40(s0)\n",
+				"\tld s4, -56(s0)\n\tmv sp, s0\n",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.goarch, func(t *testing.T) {
+			t.Parallel()
+
+			var out bytes.Buffer
+			require.NoError(t, Generate(&out, unit, tc.goarch, Options{}))
+
+			for _, expected := range tc.expected {
+				require.Contains(t, out.String(), expected)
+			}
+		})
+	}
+
+	loc := lexer.Location{Filename: "test.ssa", Line: 1}
+	floats := ir.NewCompilationUnit().
+		WithTypes(ir.NewTypeDefRegular(loc, "vec", ir.NewSubTyExtSize(ir.ExtDouble, 2))).
+		WithFuncDefs(ir.NewFuncDef(loc, "f", ir.NewParamRegular(loc, ir.NewAbiTyIdent("vec"), "v")).
+			WithBlocks(ir.NewBlock(loc, "start", []ir.Instruction{ir.NewRet(loc)})))
+
+	err := Generate(&bytes.Buffer{}, floats, "amd64", Options{})
+	require.EqualError(t, err, "test.ssa:1:0: aggregate :vec with floating point fields isn't supported by the native backend")
+
+	if runtime.GOARCH != "amd64" {
+		t.Skipf("can't run the aggregates on %s", runtime.GOARCH)
+	}
+
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc not found")
+	}
+
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.c")
+	require.NoError(t, os.WriteFile(main, []byte(aggregatesC), 0o644))
+
+	for ext, generate := range map[string]func(io.Writer, *ir.CompilationUnit, string, Options) error{".s": Generate, ".o": Object} {
+		var out bytes.Buffer
+		require.NoError(t, generate(&out, unit, "amd64", Options{}))
+
+		src := filepath.Join(dir, "test"+ext)
+		bin := filepath.Join(dir, "test")
+
+		require.NoError(t, os.WriteFile(src, out.Bytes(), 0o644))
+
+		build, err := exec.Command(cc, "-o", bin, main, src).CombinedOutput()
+		require.NoError(t, err, string(build))
+
+		stdout, err := exec.Command(bin).Output()
+		require.NoError(t, err, ext)
+		require.Equal(t, "2 1 94 106 131 503140\n", string(stdout), ext)
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.ssa"}
+	l := ir.NewAbiTyBase(ir.BaseLong)
+
+	val := func(ident string) *ir.Val { return ir.NewValIdent(loc, ir.Ident(ident), l) }
+	num := func(i int64) *ir.Val { return ir.NewValInteger(loc, i, l) }
+	add := func(ret string, lhs, rhs *ir.Val) *ir.Binop {
+		return ir.NewBinop(loc, ir.BinOpAdd, val(ret), lhs, rhs)
+	}
+
+	tt := []struct {
+		name     string
+		pool     []string
+		blocks   []ir.Block
+		expected map[ir.Ident]string
+	}{
+		{
+			name: "blocks",
+			pool: []string{"r0", "r1"},
+			blocks: []ir.Block{
+				ir.NewBlock(loc, "start", []ir.Instruction{
+					add("a", val("n"), num(1)),
+					// b takes the register a frees after its last use.
+					add("b", val("a"), val("a")),
+					// c is used in next, and e by a phi.
+					add("c", val("b"), val("n")),
+					add("d", val("b"), num(1)),
+					add("e", val("b"), val("d")),
+					ir.NewJmp(loc, "next"),
+				}),
+				ir.NewBlock(loc, "next", []ir.Instruction{
+					ir.NewPhi(loc, val("p"), ir.NewPhiArg("start", val("e"))),
+					add("r", val("c"), val("p")),
+					ir.NewRet(loc, val("r")),
+				}),
+			},
+			expected: map[ir.Ident]string{"a": "r0", "b": "r1", "d": "r0", "p": "r0", "r": "r1"},
+		},
+		{
+			name: "out of registers",
+			pool: []string{"r0"},
+			blocks: []ir.Block{
+				ir.NewBlock(loc, "start", []ir.Instruction{
+					// a lives longest, so it gives its register to b.
+					add("a", val("n"), num(1)),
+					add("b", val("n"), num(2)),
+					add("c", val("b"), val("b")),
+					add("d", val("a"), val("c")),
+					ir.NewRet(loc, val("d")),
+				}),
+			},
+			expected: map[ir.Ident]string{"b": "r0", "c": "r0", "d": "r0"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fd := ir.NewFuncDef(loc, "f", ir.NewParamRegular(loc, l, "n")).WithRetTy(l).WithBlocks(tc.blocks...)
+
+			require.Equal(t, tc.expected, allocate(&fd, tc.pool))
+		})
+	}
+}
+
+func TestGenerate_Run(t *testing.T) {
+	t.Parallel()
+
+	if !Supported(runtime.GOARCH) {
+		t.Skipf("no native backend for %s", runtime.GOARCH)
+	}
+
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc not found")
+	}
+
+	tt := []struct {
+		name     string
+		src      string
+		expected string
+		exit     int
+	}{
+		{
+			name: "recursion",
+			src: `
+fib :: func(n: int) -> int {
+    if n <= 1 {
+        return n
+    }
+
+    return fib(n - 1) + fib(n - 2)
+}
+
+@(export)
+main :: func() -> int {
+    for n := 1; n <= 5; n = n + 1 {
+        printf("fib(%d) = %d\n", n, fib(n))
+    }
+
+    return fib(10)
+}`,
+			expected: "fib(1) = 1\nfib(2) = 1\nfib(3) = 2\nfib(4) = 3\nfib(5) = 5\n",
+			exit:     55,
+		},
+		{
+			name: "phis on both edges",
+			src: `
+@(export)
+main :: func() -> int {
+    a := 0
+    b := 0
+
+    for i := 0; i < 10; i = i + 1 {
+        if i % 3 == 0 || i % 5 == 0 {
+            a = a + i
+        } else {
+            b = b + i
+        }
+    }
+
+    printf("%d %d\n", a, b)
+
+    return 0
+}`,
+			expected: "23 22\n",
+		},
+		{
+			name: "arrays and stack arguments",
+			src: `
+sum :: func(a: int, b: int, c: int, d: int, e: int, f: int, g: int, h: int) -> int {
+    return a + b + c + d + e + f + g * 10 + h * 100
+}
+
+@(export)
+main :: func() -> int {
+    xs := [4]int{}
+    for i := 0; i < 4; i = i + 1 {
+        xs[i] = i * i
+    }
+
+    printf("%d %d\n", xs[3], sum(1, 2, 3, 4, 5, 6, 7, 8))
+
+    return 0
+}`,
+			expected: "9 891\n",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			unit := irtest.LowerWithPrintf(t, tc.src)
+			require.NoError(t, passes.Run(unit, passes.Default(passes.Options{})...))
+			unit.RemoveDeadFuncs()
+
+			// Build from the assembly, and from the object if there's an
+			// object writer for the host.
+			generators := map[string]func(io.Writer, *ir.CompilationUnit, string, Options) error{".s": Generate}
+			if _, ok := encoders[runtime.GOARCH]; ok {
+				generators[".o"] = Object
+			}
+
+			for ext, generate := range generators {
+				var out bytes.Buffer
+				require.NoError(t, generate(&out, unit, runtime.GOARCH, Options{DebugInfo: true}))
+
+				dir := t.TempDir()
+				src := filepath.Join(dir, "test"+ext)
+				bin := filepath.Join(dir, "test")
+
+				require.NoError(t, os.WriteFile(src, out.Bytes(), 0o644))
+
+				build, err := exec.Command(cc, "-o", bin, src).CombinedOutput()
+				require.NoError(t, err, string(build))
+
+				stdout, err := exec.Command(bin).Output()
+
+				var exit *exec.ExitError
+				if errors.As(err, &exit) {
+					require.Equal(t, tc.exit, exit.ExitCode(), ext)
+				} else {
+					require.NoError(t, err, ext)
+					require.Equal(t, 0, tc.exit, ext)
+				}
+
+				require.Equal(t, tc.expected, string(stdout), ext)
+			}
+		})
+	}
+}
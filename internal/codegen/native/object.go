@@ -0,0 +1,541 @@
+package native
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/corani/cubit/internal/ir"
+)
+
+// encoder encodes the instructions of an architecture into an object.
+type encoder struct {
+	machine elf.Machine
+	// encode appends the machine code of an instruction to the current
+	// section of obj.
+	encode func(obj *object, mnemonic string, operands []string) error
+	// abs64 is the relocation of a 64-bit address in data.
+	abs64 uint32
+}
+
+// encoders are the architectures an object can be written for, by GOARCH.
+var encoders = map[string]encoder{
+	"amd64": {machine: elf.EM_X86_64, encode: encodeAMD64, abs64: uint32(elf.R_X86_64_64)},
+}
+
+// Object writes an ELF relocatable object of unit for goarch to w, so the
+// code can be linked without running an assembler. The assembly Generate
+// writes is encoded by an assembler for the directives and instructions the
+// backend uses. Objects don't have line tables, so opts.DebugInfo is ignored.
+func Object(w io.Writer, unit *ir.CompilationUnit, goarch string, opts Options) error {
+	enc, ok := encoders[goarch]
+	if !ok {
+		return fmt.Errorf("no object writer for %s", goarch)
+	}
+
+	var asm bytes.Buffer
+
+	if err := Generate(&asm, unit, goarch, Options{}); err != nil {
+		return err
+	}
+
+	obj := &object{enc: enc, symbols: make(map[string]*symbol)}
+
+	if err := obj.assemble(asm.String()); err != nil {
+		return err
+	}
+
+	return obj.write(w)
+}
+
+// object is an ELF relocatable object being assembled.
+type object struct {
+	enc      encoder
+	sections []*section
+	current  *section
+	symbols  map[string]*symbol
+	names    []string // symbols in the order they're first seen
+	fixups   []fixup
+}
+
+type section struct {
+	name   string
+	typ    elf.SectionType
+	flags  elf.SectionFlag
+	align  uint64
+	data   []byte
+	relocs []reloc
+}
+
+type symbol struct {
+	name    string
+	section *section
+	value   uint64
+	size    uint64
+	typ     elf.SymType
+	global  bool
+	defined bool
+}
+
+// reloc is a relocation: the linker stores the address of the symbol plus
+// the addend at the offset, in the way given by the type.
+type reloc struct {
+	offset uint64
+	symbol string
+	typ    uint32
+	addend int64
+}
+
+// fixup is a 32-bit displacement to a local label, which is resolved once
+// all labels are known. The displacement is relative to the end of the
+// instruction.
+type fixup struct {
+	section *section
+	offset  int
+	end     int
+	label   string
+}
+
+// local reports whether a label is local to the assembly, and not a symbol.
+func local(name string) bool {
+	return strings.HasPrefix(name, ".L")
+}
+
+// symbol returns the symbol with the given name, declaring it if it's new.
+func (obj *object) symbol(name string) *symbol {
+	s, ok := obj.symbols[name]
+	if !ok {
+		s = &symbol{name: name}
+		obj.symbols[name] = s
+		obj.names = append(obj.names, name)
+	}
+
+	return s
+}
+
+// section switches to the section with the given name, creating it with the
+// given type and flags if it's new.
+func (obj *object) section(name string, typ elf.SectionType, flags elf.SectionFlag) {
+	for _, s := range obj.sections {
+		if s.name == name {
+			obj.current = s
+
+			return
+		}
+	}
+
+	obj.current = &section{name: name, typ: typ, flags: flags, align: 1}
+	obj.sections = append(obj.sections, obj.current)
+}
+
+// emit appends code to the current section.
+func (obj *object) emit(code ...byte) {
+	obj.current.data = append(obj.current.data, code...)
+}
+
+// offset returns the offset of the end of the current section.
+func (obj *object) offset() int {
+	return len(obj.current.data)
+}
+
+// relocate adds a relocation at the given offset of the current section.
+func (obj *object) relocate(offset int, sym string, typ uint32, addend int64) {
+	obj.symbol(sym)
+	obj.current.relocs = append(obj.current.relocs, reloc{
+		offset: uint64(offset), symbol: sym, typ: typ, addend: addend,
+	})
+}
+
+// jump adds a fixup for a displacement to a label at the given offset of
+// the current section.
+func (obj *object) jump(offset, end int, label string) {
+	obj.fixups = append(obj.fixups, fixup{section: obj.current, offset: offset, end: end, label: label})
+}
+
+func (obj *object) assemble(asm string) error {
+	obj.section(".text", elf.SHT_PROGBITS, elf.SHF_ALLOC|elf.SHF_EXECINSTR)
+
+	for i, line := range strings.Split(asm, "\n") {
+		line = strings.TrimSpace(line)
+
+		var err error
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasSuffix(line, ":"):
+			err = obj.label(strings.TrimSuffix(line, ":"))
+		case strings.HasPrefix(line, "."):
+			name, args, _ := strings.Cut(line, " ")
+			err = obj.directive(name, strings.TrimSpace(args))
+		default:
+			mnemonic, args, _ := strings.Cut(line, " ")
+
+			var operands []string
+			if args = strings.TrimSpace(args); args != "" {
+				operands = strings.Split(args, ", ")
+			}
+
+			err = obj.enc.encode(obj, mnemonic, operands)
+		}
+
+		if err != nil {
+			return fmt.Errorf("line %d: %s: %w", i+1, line, err)
+		}
+	}
+
+	for _, f := range obj.fixups {
+		s, ok := obj.symbols[f.label]
+		if !ok || !s.defined || s.section != f.section {
+			return fmt.Errorf("jump to undefined label %s", f.label)
+		}
+
+		binary.LittleEndian.PutUint32(f.section.data[f.offset:], uint32(int32(int(s.value)-f.end)))
+	}
+
+	return nil
+}
+
+func (obj *object) label(name string) error {
+	s := obj.symbol(name)
+	if s.defined {
+		return fmt.Errorf("label %s is defined twice", name)
+	}
+
+	s.section, s.value, s.defined = obj.current, uint64(obj.offset()), true
+
+	return nil
+}
+
+func (obj *object) directive(name, args string) error {
+	switch name {
+	case ".text":
+		obj.section(".text", elf.SHT_PROGBITS, elf.SHF_ALLOC|elf.SHF_EXECINSTR)
+	case ".data":
+		obj.section(".data", elf.SHT_PROGBITS, elf.SHF_ALLOC|elf.SHF_WRITE)
+	case ".section":
+		return obj.sectionDirective(args)
+	case ".file", ".loc":
+		// Objects don't have line tables.
+	case ".globl":
+		obj.symbol(args).global = true
+	case ".type":
+		sym, ty, _ := strings.Cut(args, ", ")
+
+		switch ty {
+		case "%function":
+			obj.symbol(sym).typ = elf.STT_FUNC
+		case "%object":
+			obj.symbol(sym).typ = elf.STT_OBJECT
+		default:
+			return fmt.Errorf("unknown symbol type %s", ty)
+		}
+	case ".size":
+		sym, _, _ := strings.Cut(args, ", ")
+
+		s := obj.symbol(sym)
+		if !s.defined {
+			return fmt.Errorf("size of undefined symbol %s", sym)
+		}
+
+		s.size = uint64(obj.offset()) - s.value
+	case ".balign":
+		align, err := strconv.Atoi(args)
+		if err != nil {
+			return err
+		}
+
+		obj.current.align = max(obj.current.align, uint64(align))
+
+		// Padding in code is never executed, but it's filled with nops
+		// like an assembler does.
+		pad := byte(0)
+		if obj.current.flags&elf.SHF_EXECINSTR != 0 {
+			pad = 0x90
+		}
+
+		for obj.offset()%align != 0 {
+			obj.emit(pad)
+		}
+	case ".ascii":
+		s, err := strconv.Unquote(args)
+		if err != nil {
+			return err
+		}
+
+		obj.emit([]byte(s)...)
+	case ".zero":
+		n, err := strconv.Atoi(args)
+		if err != nil {
+			return err
+		}
+
+		obj.emit(make([]byte, n)...)
+	case ".byte", ".2byte", ".4byte", ".8byte":
+		return obj.value(map[string]int{".byte": 1, ".2byte": 2, ".4byte": 4, ".8byte": 8}[name], args)
+	default:
+		return fmt.Errorf("unknown directive %s", name)
+	}
+
+	return nil
+}
+
+// sectionDirective switches to the section of a .section directive, with
+// the flags given as a string of letters: a for allocated, w for writable
+// and x for executable.
+func (obj *object) sectionDirective(args string) error {
+	name, rest, _ := strings.Cut(args, ",")
+	name, rest = strings.TrimSpace(name), strings.TrimSpace(rest)
+
+	flags := elf.SectionFlag(0)
+
+	if rest != "" {
+		letters, _, _ := strings.Cut(rest, ",")
+
+		unquoted, err := strconv.Unquote(strings.TrimSpace(letters))
+		if err != nil {
+			return err
+		}
+
+		for _, c := range unquoted {
+			switch c {
+			case 'a':
+				flags |= elf.SHF_ALLOC
+			case 'w':
+				flags |= elf.SHF_WRITE
+			case 'x':
+				flags |= elf.SHF_EXECINSTR
+			default:
+				return fmt.Errorf("unknown section flag %c", c)
+			}
+		}
+	} else {
+		// Without flags, the flags follow from the name, like for an
+		// assembler.
+		switch {
+		case strings.HasPrefix(name, ".text"):
+			flags = elf.SHF_ALLOC | elf.SHF_EXECINSTR
+		case strings.HasPrefix(name, ".rodata"):
+			flags = elf.SHF_ALLOC
+		default:
+			flags = elf.SHF_ALLOC | elf.SHF_WRITE
+		}
+	}
+
+	obj.section(name, elf.SHT_PROGBITS, flags)
+
+	return nil
+}
+
+// value appends an integer, or the address of a symbol plus an offset, of
+// size bytes.
+func (obj *object) value(size int, arg string) error {
+	buf := make([]byte, 8)
+
+	if i, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		binary.LittleEndian.PutUint64(buf, uint64(i))
+		obj.emit(buf[:size]...)
+
+		return nil
+	}
+
+	if size != 8 {
+		return fmt.Errorf("address of %s doesn't fit in %d bytes", arg, size)
+	}
+
+	sym, off, _ := strings.Cut(arg, "+")
+
+	addend := int64(0)
+	if off != "" {
+		var err error
+		if addend, err = strconv.ParseInt(off, 10, 64); err != nil {
+			return err
+		}
+	}
+
+	obj.relocate(obj.offset(), sym, obj.enc.abs64, addend)
+	obj.emit(buf...)
+
+	return nil
+}
+
+// write writes the object: the ELF header, the contents of the sections,
+// their relocations, the symbol table and the section headers.
+func (obj *object) write(w io.Writer) error {
+	// Local symbols come first in the symbol table, the labels local to the
+	// assembly are left out.
+	var syms []*symbol
+
+	for _, name := range obj.names {
+		if s := obj.symbols[name]; !local(name) && s.defined && !s.global {
+			syms = append(syms, s)
+		}
+	}
+
+	firstGlobal := len(syms) + 1
+
+	for _, name := range obj.names {
+		if s := obj.symbols[name]; !local(name) && (s.global || !s.defined) {
+			syms = append(syms, s)
+		}
+	}
+
+	index := make(map[string]int)
+	for i, s := range syms {
+		index[s.name] = i + 1
+	}
+
+	var strtab, shstrtab bytes.Buffer
+
+	str := func(buf *bytes.Buffer, s string) uint32 {
+		if s == "" {
+			return 0
+		}
+
+		off := uint32(buf.Len())
+		buf.WriteString(s)
+		buf.WriteByte(0)
+
+		return off
+	}
+
+	strtab.WriteByte(0)
+	shstrtab.WriteByte(0)
+
+	// The section headers, in the order of their indexes.
+	headers := []elf.Section64{{}}
+	contents := [][]byte{nil}
+
+	add := func(name string, hdr elf.Section64, data []byte) int {
+		hdr.Name = str(&shstrtab, name)
+		hdr.Size = uint64(len(data))
+		headers = append(headers, hdr)
+		contents = append(contents, data)
+
+		return len(headers) - 1
+	}
+
+	sectionIndex := make(map[*section]int)
+
+	for _, s := range obj.sections {
+		sectionIndex[s] = add(s.name, elf.Section64{
+			Type: uint32(s.typ), Flags: uint64(s.flags), Addralign: s.align,
+		}, s.data)
+	}
+
+	symtabIndex := len(headers) + len(slices.DeleteFunc(slices.Clone(obj.sections), func(s *section) bool {
+		return len(s.relocs) == 0
+	}))
+
+	for _, s := range obj.sections {
+		if len(s.relocs) == 0 {
+			continue
+		}
+
+		var data bytes.Buffer
+
+		for _, r := range s.relocs {
+			_ = binary.Write(&data, binary.LittleEndian, elf.Rela64{
+				Off:    r.offset,
+				Info:   elf.R_INFO(uint32(index[r.symbol]), r.typ),
+				Addend: r.addend,
+			})
+		}
+
+		add(".rela"+s.name, elf.Section64{
+			Type: uint32(elf.SHT_RELA), Flags: uint64(elf.SHF_INFO_LINK), Addralign: 8, Entsize: 24,
+			Link: uint32(symtabIndex), Info: uint32(sectionIndex[s]),
+		}, data.Bytes())
+	}
+
+	var symtab bytes.Buffer
+
+	_ = binary.Write(&symtab, binary.LittleEndian, elf.Sym64{})
+
+	for _, s := range syms {
+		bind := elf.STB_LOCAL
+		if s.global || !s.defined {
+			bind = elf.STB_GLOBAL
+		}
+
+		sym := elf.Sym64{
+			Name:  str(&strtab, s.name),
+			Info:  elf.ST_INFO(bind, s.typ),
+			Value: s.value,
+			Size:  s.size,
+		}
+
+		if s.defined {
+			sym.Shndx = uint16(sectionIndex[s.section])
+		}
+
+		_ = binary.Write(&symtab, binary.LittleEndian, sym)
+	}
+
+	strtabIndex := symtabIndex + 1
+
+	add(".symtab", elf.Section64{
+		Type: uint32(elf.SHT_SYMTAB), Addralign: 8, Entsize: 24,
+		Link: uint32(strtabIndex), Info: uint32(firstGlobal),
+	}, symtab.Bytes())
+	add(".strtab", elf.Section64{Type: uint32(elf.SHT_STRTAB), Addralign: 1}, strtab.Bytes())
+
+	shstrndx := add(".shstrtab", elf.Section64{Type: uint32(elf.SHT_STRTAB), Addralign: 1}, nil)
+	// The name of .shstrtab is in .shstrtab itself, so it's complete now.
+	headers[shstrndx].Size = uint64(shstrtab.Len())
+	contents[shstrndx] = shstrtab.Bytes()
+
+	// Lay out the contents after the ELF header, and the section headers
+	// after the contents.
+	var body bytes.Buffer
+
+	const headerSize = 64
+
+	for i := 1; i < len(headers); i++ {
+		align := max(int(headers[i].Addralign), 1)
+		for (headerSize+body.Len())%align != 0 {
+			body.WriteByte(0)
+		}
+
+		if headers[i].Type != uint32(elf.SHT_NOBITS) {
+			headers[i].Off = uint64(headerSize + body.Len())
+		}
+
+		body.Write(contents[i])
+	}
+
+	for (headerSize+body.Len())%8 != 0 {
+		body.WriteByte(0)
+	}
+
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_REL),
+		Machine:   uint16(obj.enc.machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     uint64(headerSize + body.Len()),
+		Ehsize:    headerSize,
+		Shentsize: 64,
+		Shnum:     uint16(len(headers)),
+		Shstrndx:  uint16(shstrndx),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	hdr.Ident[elf.EI_OSABI] = byte(elf.ELFOSABI_NONE)
+
+	var out bytes.Buffer
+
+	_ = binary.Write(&out, binary.LittleEndian, hdr)
+	out.Write(body.Bytes())
+	_ = binary.Write(&out, binary.LittleEndian, headers)
+
+	_, err := w.Write(out.Bytes())
+
+	return err
+}
@@ -0,0 +1,98 @@
+package native
+
+import (
+	"bytes"
+	"debug/elf"
+	"testing"
+
+	"github.com/corani/cubit/internal/testutil/irtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObject(t *testing.T) {
+	t.Parallel()
+
+	unit := irtest.LowerWithPrintf(t, `
+@(export)
+main :: func() -> int {
+    printf("hello\n")
+
+    return 0
+}`)
+
+	var out bytes.Buffer
+	require.NoError(t, Object(&out, unit, "amd64", Options{}))
+
+	f, err := elf.NewFile(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, elf.ET_REL, f.Type)
+	require.Equal(t, elf.EM_X86_64, f.Machine)
+
+	symbols, err := f.Symbols()
+	require.NoError(t, err)
+
+	bind := make(map[string]elf.SymBind)
+	for _, sym := range symbols {
+		bind[sym.Name] = elf.ST_BIND(sym.Info)
+	}
+
+	require.Equal(t, elf.STB_GLOBAL, bind["main"])
+	require.Equal(t, elf.STB_GLOBAL, bind["printf"])
+	require.Equal(t, elf.STB_LOCAL, bind["_str_0001"])
+
+	text := f.Section(".text")
+	require.NotNil(t, text)
+	require.NotNil(t, f.Section(".rela.text"))
+
+	code, err := text.Data()
+	require.NoError(t, err)
+	// push %rbp; mov %rsp, %rbp
+	require.Equal(t, []byte{0x55, 0x48, 0x89, 0xe5}, code[:4])
+
+	require.Error(t, Object(&bytes.Buffer{}, unit, "riscv64", Options{}))
+}
+
+func TestEncodeAMD64(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		instr    string
+		expected []byte
+	}{
+		{"pushq %rbp", []byte{0x55}},
+		{"popq %r11", []byte{0x41, 0x5b}},
+		{"cld", []byte{0xfc}},
+		{"iretq", []byte{0x48, 0xcf}},
+		{"movq %rsp, %rbp", []byte{0x48, 0x89, 0xe5}},
+		{"subq $80, %rsp", []byte{0x48, 0x83, 0xec, 0x50}},
+		{"subq $4096, %rsp", []byte{0x48, 0x81, 0xec, 0x00, 0x10, 0x00, 0x00}},
+		{"movl %eax, -16(%rbp)", []byte{0x89, 0x45, 0xf0}},
+		{"movq -200(%rbp), %rax", []byte{0x48, 0x8b, 0x85, 0x38, 0xff, 0xff, 0xff}},
+		{"movq 16(%rbp), %rax", []byte{0x48, 0x8b, 0x45, 0x10}},
+		{"movq $1, %rax", []byte{0x48, 0xc7, 0xc0, 0x01, 0x00, 0x00, 0x00}},
+		{"movabsq $4294967296, %rcx", []byte{0x48, 0xb9, 0, 0, 0, 0, 1, 0, 0, 0}},
+		{"movb %cl, (%rax)", []byte{0x88, 0x08}},
+		{"movw %cx, (%rax)", []byte{0x66, 0x89, 0x08}},
+		{"movzbl %al, %eax", []byte{0x0f, 0xb6, 0xc0}},
+		{"movslq %eax, %rax", []byte{0x48, 0x63, 0xc0}},
+		{"movl %eax, %r8d", []byte{0x41, 0x89, 0xc0}},
+		{"cmpl %ecx, %eax", []byte{0x39, 0xc8}},
+		{"setle %al", []byte{0x0f, 0x9e, 0xc0}},
+		{"imulq %rcx, %rax", []byte{0x48, 0x0f, 0xaf, 0xc1}},
+		{"idivl %ecx", []byte{0xf7, 0xf9}},
+		{"shlq %cl, %rax", []byte{0x48, 0xd3, 0xe0}},
+		{"call *%r11", []byte{0x41, 0xff, 0xd3}},
+		{"cqto", []byte{0x48, 0x99}},
+		{"leave", []byte{0xc9}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.instr, func(t *testing.T) {
+			t.Parallel()
+
+			obj := &object{enc: encoders["amd64"], symbols: make(map[string]*symbol)}
+			require.NoError(t, obj.assemble(tc.instr))
+			require.Equal(t, tc.expected, obj.current.data)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package native
+
+import (
+	"slices"
+
+	"github.com/corani/cubit/internal/ir"
+)
+
+// interval is the part of a block a temporary lives in: from the instruction
+// that defines it to the last one that uses it.
+type interval struct {
+	ident      ir.Ident
+	start, end int
+}
+
+// allocate assigns registers of pool to the temporaries that are only used in
+// the block that defines them, by a linear scan over the intervals of every
+// block. When the registers run out, the temporary that lives longest keeps
+// its slot. Temporaries that are used in other blocks or by phis keep their
+// slots.
+//
+// The registers in pool are the ones calls preserve, so a temporary keeps its
+// register across calls, and the function saves the registers it uses.
+func allocate(fd *ir.FuncDef, pool []string) map[ir.Ident]string {
+	regs := make(map[ir.Ident]string)
+
+	if len(pool) == 0 {
+		return regs
+	}
+
+	params := make([]ir.Ident, len(fd.Params))
+	for i, param := range fd.Params {
+		params[i] = param.Ident
+	}
+
+	// The block that defines each temporary that may get a register. A
+	// temporary that's defined twice, e.g. after the SSA form was undone,
+	// isn't local to one block.
+	defs := make(map[ir.Ident]int)
+	local := make(map[ir.Ident]bool)
+
+	for b, block := range fd.Blocks {
+		for _, instr := range block.Instructions {
+			if ident, ok := defined(instr); ok {
+				_, twice := defs[ident]
+				defs[ident] = b
+				local[ident] = !twice && !slices.Contains(params, ident)
+			}
+		}
+	}
+
+	intervals := make([][]interval, len(fd.Blocks))
+	index := make(map[ir.Ident]int) // temporary -> its interval in its block
+
+	for b, block := range fd.Blocks {
+		for i, instr := range block.Instructions {
+			if ident, ok := defined(instr); ok && local[ident] {
+				index[ident] = len(intervals[b])
+				intervals[b] = append(intervals[b], interval{ident: ident, start: i, end: i})
+			}
+
+			_, phi := instr.(*ir.Phi)
+
+			for _, op := range ir.Operands(instr) {
+				if (*op).Type != ir.ValIdent || !local[(*op).Ident] {
+					continue
+				}
+
+				// A phi uses its arguments at the end of the predecessors.
+				at, ok := index[(*op).Ident]
+				if phi || !ok || defs[(*op).Ident] != b {
+					local[(*op).Ident] = false
+
+					continue
+				}
+
+				intervals[b][at].end = i
+			}
+		}
+	}
+
+	for b := range fd.Blocks {
+		scan(intervals[b], local, pool, regs)
+	}
+
+	return regs
+}
+
+// scan assigns the registers of pool to the intervals of a block, which are
+// ordered by their start.
+func scan(intervals []interval, local map[ir.Ident]bool, pool []string, regs map[ir.Ident]string) {
+	var active []interval // ordered by their end
+
+	free := slices.Clone(pool)
+
+	for _, it := range intervals {
+		if !local[it.ident] {
+			continue
+		}
+
+		// The operands are loaded before the result is stored, so the result
+		// can take the register of an operand that isn't used after.
+		for len(active) > 0 && active[0].end <= it.start {
+			free = append(free, regs[active[0].ident])
+			active = active[1:]
+		}
+
+		if len(free) == 0 {
+			// The temporary that ends last gives up its register, if that's
+			// not this one.
+			last := active[len(active)-1]
+			if last.end <= it.end {
+				continue
+			}
+
+			regs[it.ident] = regs[last.ident]
+			delete(regs, last.ident)
+			active = active[:len(active)-1]
+		} else {
+			regs[it.ident] = free[0]
+			free = free[1:]
+		}
+
+		at, _ := slices.BinarySearchFunc(active, it.end, func(a interval, end int) int {
+			return a.end - end
+		})
+		active = slices.Insert(active, at, it)
+	}
+}
+
+// defined returns the temporary an instruction defines, if it isn't an
+// aggregate, as the value of an aggregate is the address of its memory.
+func defined(instr ir.Instruction) (ir.Ident, bool) {
+	switch instr := instr.(type) {
+	case *ir.Binop:
+		return instr.Ret.Ident, true
+	case *ir.Load:
+		return instr.Ret.Ident, true
+	case *ir.Convert:
+		return instr.Ret.Ident, true
+	case *ir.Phi:
+		return instr.Ret.Ident, true
+	case *ir.Call:
+		if instr.LHS != nil && (instr.RetTy == nil || instr.RetTy.Type != ir.AbiTyIdent) {
+			return *instr.LHS, true
+		}
+	}
+
+	return "", false
+}
+
+// saved returns the name of the slot a register calls preserve is saved in.
+// It can't clash with the name of a temporary.
+func saved(reg string) ir.Ident {
+	return ir.Ident("#" + reg)
+}
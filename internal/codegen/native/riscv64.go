@@ -0,0 +1,602 @@
+package native
+
+import (
+	"fmt"
+
+	"github.com/corani/cubit/internal/ir"
+)
+
+// riscv64 generates code for RV64GC with the LP64 calling convention.
+// Operands are loaded into a0 and a1, and the result is stored from a0. t6
+// holds addresses of slots that are too far from the frame pointer for an
+// offset, t0 to t3 move arguments and aggregates, and s1 to s11 hold
+// temporaries. Symbols are only addressed relative to the program counter, so
+// the code can be linked at any address.
+type riscv64 struct{}
+
+// riscv64Args are the registers the first integer arguments are passed in.
+var riscv64Args = []string{"a0", "a1", "a2", "a3", "a4", "a5", "a6", "a7"}
+
+// riscv64Preserved are the registers calls preserve, except for the frame
+// pointer s0.
+var riscv64Preserved = []string{"s1", "s2", "s3", "s4", "s5", "s6", "s7", "s8", "s9", "s10", "s11"}
+
+// riscv64Volatile are the registers a function may change without saving
+// them, which an interrupt handler saves for the code it interrupts.
+var riscv64Volatile = []string{
+	"ra", "t0", "t1", "t2", "t3", "t4", "t5", "t6",
+	"a0", "a1", "a2", "a3", "a4", "a5", "a6", "a7",
+}
+
+// riscv64Ops are the instructions of the arithmetic operations on longs. The
+// operations on words add a "w" suffix, except for the bitwise ones, which
+// keep words sign-extended.
+var riscv64Ops = map[ir.BinOpKind]string{
+	ir.BinOpAdd: "add", ir.BinOpSub: "sub", ir.BinOpMul: "mul",
+	ir.BinOpDiv: "div", ir.BinOpUDiv: "divu",
+	ir.BinOpMod: "rem", ir.BinOpUMod: "remu",
+	ir.BinOpShl: "sll", ir.BinOpShr: "srl",
+	ir.BinOpAnd: "and", ir.BinOpOr: "or",
+}
+
+// fits reports whether n fits in the 12-bit immediate of an instruction.
+func fits(n int) bool {
+	return n >= -2048 && n < 2048
+}
+
+// adjust adds n to reg.
+func (riscv64) adjust(fn *function, reg string, n int) {
+	if fits(n) {
+		fn.emit("addi %s, %s, %d", reg, reg, n)
+
+		return
+	}
+
+	fn.emit("li t6, %d", n)
+	fn.emit("add %s, %s, t6", reg, reg)
+}
+
+// below computes the address off bytes below the frame pointer into reg.
+func (riscv64) below(fn *function, reg string, off int) {
+	if fits(-off) {
+		fn.emit("addi %s, s0, %d", reg, -off)
+
+		return
+	}
+
+	fn.emit("li t6, %d", off)
+	fn.emit("sub %s, s0, t6", reg)
+}
+
+// slot returns the memory operand of the slot of a temporary.
+func (r riscv64) slot(fn *function, ident ir.Ident) (string, error) {
+	off, err := fn.slot(ident)
+	if err != nil {
+		return "", err
+	}
+
+	if fits(-off) {
+		return fmt.Sprintf("%d(s0)", -off), nil
+	}
+
+	r.below(fn, "t6", off)
+
+	return "0(t6)", nil
+}
+
+// load loads val into a register. Words are kept sign-extended to 64 bits, so
+// the comparisons of longs work for them.
+func (r riscv64) load(fn *function, reg string, val *ir.Val) error {
+	if val.AbiTy.IsFloat() {
+		return val.Loc.Errorf("floating point values aren't supported by the native backend")
+	}
+
+	switch {
+	case val.Type == ir.ValIdent:
+		if off, ok := fn.area(val.Ident); ok {
+			r.below(fn, reg, off)
+
+			return nil
+		}
+
+		if src, ok := fn.register(val.Ident); ok {
+			fn.emit("mv %s, %s", reg, src)
+
+			return nil
+		}
+
+		mem, err := r.slot(fn, val.Ident)
+		if err != nil {
+			return err
+		}
+
+		if long(val.AbiTy) {
+			fn.emit("ld %s, %s", reg, mem)
+		} else {
+			fn.emit("lw %s, %s", reg, mem)
+		}
+	case val.DynConst.Type == ir.DynConstThread:
+		return val.Loc.Errorf("thread-local data isn't supported by the native backend")
+	case val.DynConst.Const.Type == ir.ConstIdent:
+		fn.emit("lla %s, %s", reg, val.DynConst.Const.Ident)
+	case val.DynConst.Const.Type == ir.ConstInteger:
+		i := val.DynConst.Const.I64
+		if !long(val.AbiTy) {
+			i = int64(int32(i))
+		}
+
+		fn.emit("li %s, %d", reg, i)
+	default:
+		return val.Loc.Errorf("constant %s isn't supported by the native backend", val.DynConst.Const.Type)
+	}
+
+	return nil
+}
+
+// store stores a register in the register or slot of a temporary of type ty.
+// A word is sign-extended in a register, like a load of it from its slot.
+func (r riscv64) store(fn *function, reg string, dst ir.Ident, ty ir.AbiTy) error {
+	if d, ok := fn.register(dst); ok {
+		if long(ty) {
+			fn.emit("mv %s, %s", d, reg)
+		} else {
+			fn.emit("sext.w %s, %s", d, reg)
+		}
+
+		return nil
+	}
+
+	mem, err := r.slot(fn, dst)
+	if err != nil {
+		return err
+	}
+
+	if long(ty) {
+		fn.emit("sd %s, %s", reg, mem)
+	} else {
+		fn.emit("sw %s, %s", reg, mem)
+	}
+
+	return nil
+}
+
+func (riscv64) comment() string {
+	return "#"
+}
+
+func (riscv64) abi() abi {
+	return abi{args: riscv64Args, ret: [2]string{"a0", "a1"}, byRef: true, split: true}
+}
+
+func (riscv64) preserved() []string {
+	return riscv64Preserved
+}
+
+func (r riscv64) prologue(fn *function) error {
+	switch fn.fd.CallConv {
+	case ir.CallConvNaked:
+		return nil
+	case ir.CallConvInterrupt:
+		fn.emit("addi sp, sp, -%d", 8*len(riscv64Volatile))
+
+		for i, reg := range riscv64Volatile {
+			fn.emit("sd %s, %d(sp)", reg, 8*i)
+		}
+	}
+
+	fn.emit("addi sp, sp, -16")
+	fn.emit("sd ra, 8(sp)")
+	fn.emit("sd s0, 0(sp)")
+	fn.emit("mv s0, sp")
+
+	if fn.frame.size > 0 {
+		r.adjust(fn, "sp", -fn.frame.size)
+	}
+
+	for _, reg := range fn.frame.saved {
+		if err := r.store(fn, reg, saved(reg), ir.NewAbiTyBase(ir.BaseLong)); err != nil {
+			return err
+		}
+	}
+
+	if fn.conv.sret {
+		if err := r.store(fn, fn.conv.sretReg(), sret, ir.NewAbiTyBase(ir.BaseLong)); err != nil {
+			return err
+		}
+	}
+
+	for i, param := range fn.fd.Params {
+		p := fn.conv.params[i]
+
+		if p.agg {
+			r.aggregateParam(fn, param.Ident, p)
+
+			continue
+		}
+
+		if err := r.store(fn, r.param(fn, p.words[0]), param.Ident, param.AbiTy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// param returns the register a word of a parameter is passed in. The words on
+// the stack, above the saved frame pointer and return address, are loaded
+// into t0.
+func (riscv64) param(fn *function, w word) string {
+	if w.reg != "" {
+		return w.reg
+	}
+
+	fn.emit("ld t0, %d(s0)", 16+w.stack)
+
+	return "t0"
+}
+
+// aggregateParam copies an aggregate parameter to its memory in the frame.
+func (r riscv64) aggregateParam(fn *function, ident ir.Ident, p param) {
+	off, _ := fn.area(ident)
+	r.below(fn, "t1", off)
+
+	if p.ref {
+		if reg := r.param(fn, p.words[0]); reg != "t0" {
+			fn.emit("mv t0, %s", reg)
+		}
+
+		r.copy(fn, "t1", "t0", p.size)
+
+		return
+	}
+
+	for i, w := range p.words {
+		fn.emit("sd %s, %d(t1)", r.param(fn, w), 8*i)
+	}
+}
+
+// riscv64Moves are the loads and stores of the chunks of a copy.
+var riscv64Moves = map[int][2]string{8: {"ld", "sd"}, 4: {"lw", "sw"}, 2: {"lh", "sh"}, 1: {"lb", "sb"}}
+
+// copy copies size bytes from the address in src to the address in dst,
+// through t2. For large copies, src and dst are moved along, so the offsets
+// fit in the loads and stores.
+func (riscv64) copy(fn *function, dst, src string, size int) {
+	base := 0
+
+	for _, c := range chunks(size) {
+		if c.off-base >= 2040 {
+			fn.emit("addi %s, %s, %d", src, src, c.off-base)
+			fn.emit("addi %s, %s, %d", dst, dst, c.off-base)
+			base = c.off
+		}
+
+		m := riscv64Moves[c.size]
+		fn.emit("%s t2, %d(%s)", m[0], c.off-base, src)
+		fn.emit("%s t2, %d(%s)", m[1], c.off-base, dst)
+	}
+}
+
+func (r riscv64) instr(fn *function, instr ir.Instruction) error {
+	switch instr := instr.(type) {
+	case *ir.Binop:
+		return r.binop(fn, instr)
+	case *ir.Load:
+		if err := r.load(fn, "a0", instr.Addr); err != nil {
+			return err
+		}
+
+		op := map[string]string{
+			"ub": "lbu", "sb": "lb", "uh": "lhu", "sh": "lh", "w": "lw", "l": "ld",
+		}[memTy(instr.Ty)]
+
+		fn.emit("%s a0, 0(a0)", op)
+
+		return r.store(fn, "a0", instr.Ret.Ident, instr.Ret.AbiTy)
+	case *ir.Store:
+		if err := r.load(fn, "a0", instr.Addr); err != nil {
+			return err
+		}
+
+		if err := r.load(fn, "a1", instr.Val); err != nil {
+			return err
+		}
+
+		op := map[string]string{
+			"ub": "sb", "sb": "sb", "uh": "sh", "sh": "sh", "w": "sw", "l": "sd",
+		}[memTy(instr.Ty)]
+
+		fn.emit("%s a1, 0(a0)", op)
+
+		return nil
+	case *ir.Convert:
+		if err := r.load(fn, "a0", instr.Val); err != nil {
+			return err
+		}
+
+		if long(instr.Ret.AbiTy) {
+			switch memTy(instr.Val.AbiTy) {
+			case "w":
+				fn.emit("sext.w a0, a0")
+			case "sb":
+				fn.emit("slli a0, a0, 56")
+				fn.emit("srai a0, a0, 56")
+			case "ub":
+				fn.emit("andi a0, a0, 255")
+			case "sh":
+				fn.emit("slli a0, a0, 48")
+				fn.emit("srai a0, a0, 48")
+			case "uh":
+				fn.emit("slli a0, a0, 48")
+				fn.emit("srli a0, a0, 48")
+			}
+		}
+
+		return r.store(fn, "a0", instr.Ret.Ident, instr.Ret.AbiTy)
+	case *ir.Call:
+		return r.call(fn, instr)
+	case *ir.Alloc:
+		// The memory of the slot is part of the frame.
+		return nil
+	default:
+		return fmt.Errorf("%s: instruction %T isn't supported by the native backend", instr.Location(), instr)
+	}
+}
+
+func (r riscv64) binop(fn *function, b *ir.Binop) error {
+	if err := r.load(fn, "a0", b.Lhs); err != nil {
+		return err
+	}
+
+	if err := r.load(fn, "a1", b.Rhs); err != nil {
+		return err
+	}
+
+	if op, ok := riscv64Ops[b.Op]; ok {
+		if !long(b.Ret.AbiTy) && b.Op != ir.BinOpAnd && b.Op != ir.BinOpOr {
+			op += "w"
+		}
+
+		fn.emit("%s a0, a0, a1", op)
+
+		return r.store(fn, "a0", b.Ret.Ident, b.Ret.AbiTy)
+	}
+
+	// There are only "set if less than" comparisons: greater than swaps the
+	// operands, and the others negate the opposite comparison.
+	switch b.Op {
+	case ir.BinOpEq:
+		fn.emit("xor a0, a0, a1")
+		fn.emit("seqz a0, a0")
+	case ir.BinOpNe:
+		fn.emit("xor a0, a0, a1")
+		fn.emit("snez a0, a0")
+	case ir.BinOpLt:
+		fn.emit("slt a0, a0, a1")
+	case ir.BinOpULt:
+		fn.emit("sltu a0, a0, a1")
+	case ir.BinOpGt:
+		fn.emit("slt a0, a1, a0")
+	case ir.BinOpUGt:
+		fn.emit("sltu a0, a1, a0")
+	case ir.BinOpLe:
+		fn.emit("slt a0, a1, a0")
+		fn.emit("xori a0, a0, 1")
+	case ir.BinOpULe:
+		fn.emit("sltu a0, a1, a0")
+		fn.emit("xori a0, a0, 1")
+	case ir.BinOpGe:
+		fn.emit("slt a0, a0, a1")
+		fn.emit("xori a0, a0, 1")
+	case ir.BinOpUGe:
+		fn.emit("sltu a0, a0, a1")
+		fn.emit("xori a0, a0, 1")
+	default:
+		return b.Loc.Errorf("unknown binop: %s", b.Op)
+	}
+
+	return r.store(fn, "a0", b.Ret.Ident, b.Ret.AbiTy)
+}
+
+func (r riscv64) call(fn *function, c *ir.Call) error {
+	args, conv, err := fn.arguments(c)
+	if err != nil {
+		return err
+	}
+
+	// The arguments on the stack and the copies of the aggregates passed by
+	// reference are at the bottom of the stack, which stays aligned to 16
+	// bytes.
+	result := fn.result(c)
+	size := conv.stack + conv.copies
+
+	if size > 0 {
+		r.adjust(fn, "sp", -size)
+	}
+
+	for i, p := range conv.params {
+		for j, w := range p.words {
+			if w.reg != "" {
+				continue
+			}
+
+			if err := r.arg(fn, "t0", args[i], conv, p, j); err != nil {
+				return err
+			}
+
+			fn.emit("sd t0, %d(sp)", w.stack)
+		}
+	}
+
+	for i, p := range conv.params {
+		for j, w := range p.words {
+			if w.reg == "" {
+				continue
+			}
+
+			if err := r.arg(fn, w.reg, args[i], conv, p, j); err != nil {
+				return err
+			}
+		}
+	}
+
+	if conv.sret {
+		if err := r.load(fn, conv.sretReg(), result); err != nil {
+			return err
+		}
+	}
+
+	if c.Val.Type == ir.ValDynConst && c.Val.DynConst.Const.Type == ir.ConstIdent {
+		fn.emit("call %s", c.Val.DynConst.Const.Ident)
+	} else {
+		if err := r.load(fn, "t5", c.Val); err != nil {
+			return err
+		}
+
+		fn.emit("jalr t5")
+	}
+
+	if size > 0 {
+		r.adjust(fn, "sp", size)
+	}
+
+	switch {
+	case result == nil || conv.sret:
+		return nil
+	case conv.ret > 0:
+		if err := r.load(fn, "t1", result); err != nil {
+			return err
+		}
+
+		for i := range conv.retWords() {
+			fn.emit("sd %s, %d(t1)", conv.abi.ret[i], 8*i)
+		}
+
+		return nil
+	default:
+		return r.store(fn, "a0", *c.LHS, *c.RetTy)
+	}
+}
+
+// above computes the address off bytes above the stack pointer into reg.
+func (r riscv64) above(fn *function, reg string, off int) {
+	fn.emit("mv %s, sp", reg)
+	r.adjust(fn, reg, off)
+}
+
+// arg loads the i-th word of the argument val, passed as p, into reg. An
+// aggregate passed by reference is first copied below the arguments on the
+// stack.
+func (r riscv64) arg(fn *function, reg string, val *ir.Val, conv *convention, p param, i int) error {
+	switch {
+	case p.ref:
+		if err := r.load(fn, "t1", val); err != nil {
+			return err
+		}
+
+		r.above(fn, "t3", conv.stack+p.copy)
+		r.copy(fn, "t3", "t1", p.size)
+		r.above(fn, reg, conv.stack+p.copy)
+	case p.agg:
+		if err := r.load(fn, "t1", val); err != nil {
+			return err
+		}
+
+		fn.emit("ld %s, %d(t1)", reg, 8*i)
+	default:
+		return r.load(fn, reg, val)
+	}
+
+	return nil
+}
+
+func (r riscv64) move(fn *function, dst ir.Ident, ty ir.AbiTy, val *ir.Val) error {
+	if err := r.load(fn, "a0", val); err != nil {
+		return err
+	}
+
+	return r.store(fn, "a0", dst, ty)
+}
+
+func (riscv64) jump(fn *function, label string) {
+	fn.emit("j %s", label)
+}
+
+// branch skips a jump instead of branching to label, as a conditional branch
+// only reaches 4 KiB.
+func (r riscv64) branch(fn *function, cond *ir.Val, label string) error {
+	if err := r.load(fn, "a0", cond); err != nil {
+		return err
+	}
+
+	fn.emit("beqz a0, 1f")
+	fn.emit("j %s", label)
+	fmt.Fprintf(&fn.out, "1:\n")
+
+	return nil
+}
+
+func (r riscv64) ret(fn *function, val *ir.Val) error {
+	switch {
+	case val == nil:
+	case fn.conv.sret:
+		if err := r.load(fn, "t3", ir.NewValIdent(val.Loc, sret, ir.NewAbiTyBase(ir.BaseLong))); err != nil {
+			return err
+		}
+
+		if err := r.load(fn, "t1", val); err != nil {
+			return err
+		}
+
+		r.copy(fn, "t3", "t1", fn.conv.ret)
+	case fn.conv.ret > 0:
+		if err := r.load(fn, "t1", val); err != nil {
+			return err
+		}
+
+		for i := range fn.conv.retWords() {
+			fn.emit("ld %s, %d(t1)", fn.conv.abi.ret[i], 8*i)
+		}
+	default:
+		if err := r.load(fn, "a0", val); err != nil {
+			return err
+		}
+	}
+
+	if fn.fd.CallConv == ir.CallConvNaked {
+		fn.emit("ret")
+
+		return nil
+	}
+
+	for _, reg := range fn.frame.saved {
+		if err := r.load(fn, reg, ir.NewValIdent(fn.fd.Loc, saved(reg), ir.NewAbiTyBase(ir.BaseLong))); err != nil {
+			return err
+		}
+	}
+
+	fn.emit("mv sp, s0")
+	fn.emit("ld ra, 8(sp)")
+	fn.emit("ld s0, 0(sp)")
+	fn.emit("addi sp, sp, 16")
+
+	// A machine-mode trap handler returns to the interrupted code.
+	if fn.fd.CallConv == ir.CallConvInterrupt {
+		for i, reg := range riscv64Volatile {
+			fn.emit("ld %s, %d(sp)", reg, 8*i)
+		}
+
+		fn.emit("addi sp, sp, %d", 8*len(riscv64Volatile))
+		fn.emit("mret")
+
+		return nil
+	}
+
+	fn.emit("ret")
+
+	return nil
+}
+
+func (riscv64) trap(fn *function) {
+	fn.emit("ebreak")
+}
@@ -2,19 +2,43 @@ package codegen
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/corani/cubit/internal/ir"
 )
 
 // SsaGen implements ast.Visitor and generates SSA code.
-type SsaGen struct{}
+type SsaGen struct {
+	debugInfo bool    // emit dbgfile and dbgloc, for line tables
+	source    bool    // interleave the source lines as comments
+	sources   sources // lines of the source files, read when needed
+	file      string  // source file of the function being generated
+	line      int     // last source line of the function emitted
+}
 
 // NewSSAVisitor returns a new SSAVisitor.
 func NewSSAVisitor() *SsaGen {
 	return &SsaGen{}
 }
 
+// WithDebugInfo makes the visitor emit the source file of every function and
+// the source line of its instructions, so the backend generates line tables.
+func (v *SsaGen) WithDebugInfo() *SsaGen {
+	v.debugInfo = true
+
+	return v
+}
+
+// WithSource makes the visitor interleave the source lines the instructions
+// come from as comments. Lines of files that can't be read are left out.
+func (v *SsaGen) WithSource() *SsaGen {
+	v.source = true
+	v.sources = make(sources)
+
+	return v
+}
+
 func (v *SsaGen) VisitCompilationUnit(cu *ir.CompilationUnit) string {
 	var sb strings.Builder
 
@@ -89,7 +113,10 @@ func (v *SsaGen) VisitDataDef(dd *ir.DataDef) string {
 }
 
 func (v *SsaGen) VisitFuncDef(fd *ir.FuncDef) string {
-	var linkage, retTy string
+	var dbgfile, linkage, retTy string
+	if v.debugInfo && fd.Loc.Filename != "" {
+		dbgfile = fmt.Sprintf("dbgfile %q\n", fd.Loc.Filename)
+	}
 	if fd.Linkage != nil {
 		linkage = v.VisitLinkage(*fd.Linkage) + " "
 	}
@@ -101,13 +128,25 @@ func (v *SsaGen) VisitFuncDef(fd *ir.FuncDef) string {
 	for i, param := range fd.Params {
 		params[i] = v.VisitParam(*param)
 	}
+	v.file, v.line = fd.Loc.Filename, 0
 	for i, block := range fd.Blocks {
+		// QBE continues with the next block when a block doesn't end with a
+		// jump, so a jump to it is left out.
+		if i+1 < len(fd.Blocks) {
+			if jmp, ok := block.Terminator().(*ir.Jmp); ok && jmp.Label == fd.Blocks[i+1].Label {
+				block.Instructions = block.Instructions[:len(block.Instructions)-1]
+			}
+		}
 		blocks[i] = v.VisitBlock(block)
 	}
-	return fmt.Sprintf("\n# %s\n%sfunction %s$%s(%s) {%s}",
-		fd.Loc, linkage, retTy, fd.Ident,
+	body := strings.Join(blocks, "")
+	if body != "" {
+		body += "\n"
+	}
+	return fmt.Sprintf("\n# %s\n%s%sfunction %s$%s(%s) {%s}",
+		fd.Loc, dbgfile, linkage, retTy, fd.Ident,
 		strings.Join(params, ", "),
-		strings.Join(blocks, "\n"))
+		body)
 }
 
 // --- Helper visitor methods for nested types ---
@@ -184,9 +223,9 @@ func (v *SsaGen) VisitConst(c ir.Const) string {
 	case ir.ConstInteger:
 		return fmt.Sprintf("%d", c.I64)
 	case ir.ConstSingle:
-		return fmt.Sprintf("s_%f", c.F32)
+		return "s_" + strconv.FormatFloat(float64(c.F32), 'g', -1, 32)
 	case ir.ConstDouble:
-		return fmt.Sprintf("d_%f", c.F64)
+		return "d_" + strconv.FormatFloat(c.F64, 'g', -1, 64)
 	case ir.ConstIdent:
 		return fmt.Sprintf("$%s", c.Ident)
 	default:
@@ -229,26 +268,56 @@ func (v *SsaGen) VisitBlock(b ir.Block) string {
 		label = fmt.Sprintf("@%s\n", b.Label)
 	}
 
-	instructions := make([]string, len(b.Instructions))
+	var instructions []string
 
-	for i, instr := range b.Instructions {
+	for _, instr := range b.Instructions {
+		instructions = append(instructions, v.location(instr)...)
 		// TODO(daniel): we need something better for indentation...
-		if _, ok := instr.(*ir.Label); ok {
-			instructions[i] = instr.Accept(v)
-		} else {
-			instructions[i] = "\t" + instr.Accept(v)
-		}
+		instructions = append(instructions, "\t"+instr.Accept(v))
+	}
+
+	if len(instructions) == 0 {
+		// A block that only falls through to the next one.
+		return "\n" + strings.TrimSuffix(label, "\n")
+	}
+
+	return fmt.Sprintf("\n%s%s", label, strings.Join(instructions, "\n"))
+}
+
+// location returns the lines to emit before an instruction that starts a new
+// source line. Only lines of the function's own file are emitted; code inlined
+// from other files keeps the last line. Phis have to come first in their block,
+// so they never start a line.
+func (v *SsaGen) location(instr ir.Instruction) []string {
+	loc := instr.Location()
+
+	if _, ok := instr.(*ir.Phi); ok || loc.Filename != v.file || loc.Line <= 0 || loc.Line == v.line {
+		return nil
+	}
+
+	v.line = loc.Line
+
+	var lines []string
+
+	if text := v.sourceLine(loc.Filename, loc.Line); text != "" {
+		lines = append(lines, fmt.Sprintf("\t# %d: %s", loc.Line, text))
+	}
+
+	if v.debugInfo {
+		lines = append(lines, fmt.Sprintf("\tdbgloc %d", loc.Line))
 	}
 
-	return fmt.Sprintf("\n%s%s\n", label, strings.Join(instructions, "\n"))
+	return lines
 }
 
-func (v *SsaGen) VisitLabel(l *ir.Label) string {
-	if l.Name == "" {
+// sourceLine returns the text of a line of a source file, without surrounding
+// whitespace, or "" if the source isn't interleaved or can't be read.
+func (v *SsaGen) sourceLine(filename string, line int) string {
+	if !v.source {
 		return ""
 	}
 
-	return fmt.Sprintf("@%s", l.Name)
+	return v.sources.line(filename, line)
 }
 
 func (v *SsaGen) VisitRet(r *ir.Ret) string {
@@ -259,6 +328,10 @@ func (v *SsaGen) VisitRet(r *ir.Ret) string {
 	return fmt.Sprintf("ret %s", v.VisitVal(r.Val))
 }
 
+func (v *SsaGen) VisitHlt(h *ir.Hlt) string {
+	return "hlt"
+}
+
 func (v *SsaGen) VisitCall(c *ir.Call) string {
 	var lhs string
 
@@ -278,24 +351,51 @@ func (v *SsaGen) VisitCall(c *ir.Call) string {
 func (v *SsaGen) VisitBinop(b *ir.Binop) string {
 	// Map ir.BinOpKind to SSA op string
 	opMap := map[ir.BinOpKind]string{
-		ir.BinOpAdd: "add",
-		ir.BinOpSub: "sub",
-		ir.BinOpMul: "mul",
-		ir.BinOpDiv: "div",
-		ir.BinOpMod: "rem",
-		ir.BinOpEq:  "ceqw",
-		ir.BinOpNe:  "cnew",
-		ir.BinOpLt:  "csltw",
-		ir.BinOpLe:  "cslew",
-		ir.BinOpGt:  "csgtw",
-		ir.BinOpGe:  "csgew",
-		ir.BinOpShl: "shl",
-		ir.BinOpShr: "shr",
-		ir.BinOpAnd: "and",
-		ir.BinOpOr:  "or",
+		ir.BinOpAdd:  "add",
+		ir.BinOpSub:  "sub",
+		ir.BinOpMul:  "mul",
+		ir.BinOpDiv:  "div",
+		ir.BinOpUDiv: "udiv",
+		ir.BinOpMod:  "rem",
+		ir.BinOpUMod: "urem",
+		ir.BinOpShl:  "shl",
+		ir.BinOpShr:  "shr",
+		ir.BinOpAnd:  "and",
+		ir.BinOpOr:   "or",
+	}
+
+	// Comparisons are suffixed with the type of their operands. Floating point
+	// comparisons have no signedness.
+	cmpMap := map[ir.BinOpKind][2]string{
+		ir.BinOpEq:  {"ceq", "ceq"},
+		ir.BinOpNe:  {"cne", "cne"},
+		ir.BinOpLt:  {"cslt", "clt"},
+		ir.BinOpULt: {"cult", "clt"},
+		ir.BinOpLe:  {"csle", "cle"},
+		ir.BinOpULe: {"cule", "cle"},
+		ir.BinOpGt:  {"csgt", "cgt"},
+		ir.BinOpUGt: {"cugt", "cgt"},
+		ir.BinOpGe:  {"csge", "cge"},
+		ir.BinOpUGe: {"cuge", "cge"},
 	}
 
 	op, ok := opMap[b.Op]
+	if cmp, isCmp := cmpMap[b.Op]; isCmp {
+		ty, float := "w", false
+
+		if t := b.Lhs.AbiTy; t.Type == ir.AbiTyBase {
+			ty, float = string(t.BaseTy), t.IsFloat()
+		}
+
+		if float {
+			op = cmp[1] + ty
+		} else {
+			op = cmp[0] + ty
+		}
+
+		ok = true
+	}
+
 	if !ok {
 		panic("unknown binop: " + string(b.Op))
 	}
@@ -358,9 +458,9 @@ func (v *SsaGen) VisitLoad(l *ir.Load) string {
 	addr := v.VisitVal(l.Addr)
 	typeStr := v.VisitAbiTy(l.Ret.AbiTy)
 
-	// Determine the correct load instruction suffix based on the type
+	// Determine the correct load instruction suffix based on the type in memory
 	loadInstr := "loadw" // default
-	switch typeStr {
+	switch v.VisitAbiTy(l.Ty) {
 	case "w":
 		loadInstr = "loadw"
 	case "l":
@@ -388,10 +488,9 @@ func (v *SsaGen) VisitStore(s *ir.Store) string {
 	// QBE: store<suffix> %val, %addr
 	val := v.VisitVal(s.Val)
 	addr := v.VisitVal(s.Addr)
-	typeStr := v.VisitAbiTy(s.Val.AbiTy)
 
 	storeInstr := "storew" // default
-	switch typeStr {
+	switch v.VisitAbiTy(s.Ty) {
 	case "w":
 		storeInstr = "storew"
 	case "l":
@@ -439,36 +538,12 @@ func (v *SsaGen) VisitConvert(c *ir.Convert) string {
 	// Extend single to double
 	case retType == "d" && valType == "s":
 		convertInstr = "exts"
-	// Integer to float/double
+	// Integer to float/double, as signed integers
 	case (retType == "s" || retType == "d") && (valType == "w" || valType == "l"):
-		if retType == "s" {
-			if valType == "w" {
-				convertInstr = "swtof"
-			} else {
-				convertInstr = "sltof"
-			}
-		} else {
-			if valType == "w" {
-				convertInstr = "uwtof"
-			} else {
-				convertInstr = "ultof"
-			}
-		}
-	// Float/double to integer
+		convertInstr = "s" + valType + "tof"
+	// Float/double to a signed integer, of the type of the result
 	case (retType == "w" || retType == "l") && (valType == "s" || valType == "d"):
-		if valType == "s" {
-			if retType == "w" {
-				convertInstr = "stosi"
-			} else {
-				convertInstr = "stoui"
-			}
-		} else {
-			if retType == "w" {
-				convertInstr = "dtosi"
-			} else {
-				convertInstr = "dtoui"
-			}
-		}
+		convertInstr = valType + "tosi"
 	// Default: sign-extend word to long
 	default:
 		convertInstr = "extsw"
@@ -479,34 +554,16 @@ func (v *SsaGen) VisitConvert(c *ir.Convert) string {
 
 func (v *SsaGen) VisitAlloc(a *ir.Alloc) string {
 	// QBE: %ret =l allocN size, where N is the alignment, and result is always a pointer (long)
-	ret := v.VisitVal(a.Ret)
-	size := v.VisitVal(a.Size)
-	align := 4 // default alignment
+	return fmt.Sprintf("%s =l alloc%d %s", v.VisitVal(a.Ret), a.Align, v.VisitVal(a.Size))
+}
 
-	// Determine alignment based on the AbiTy of the allocated type
-	switch t := a.Ret.AbiTy; t.Type {
-	case ir.AbiTyBase:
-		switch t.BaseTy {
-		case ir.BaseWord:
-			align = 4
-		case ir.BaseLong:
-			align = 8
-		case ir.BaseSingle:
-			align = 4
-		case ir.BaseDouble:
-			align = 8
-		}
-	case ir.AbiTySubW:
-		switch t.SubWTy {
-		case "sb", "ub":
-			align = 1
-		case "sh", "uh":
-			align = 2
-		case "sw", "uw":
-			align = 4
-		}
-		// Add more cases as needed for other types
+// Implements QBE-style phi: %ret =w phi @a %x, @b %y
+func (v *SsaGen) VisitPhi(p *ir.Phi) string {
+	args := make([]string, len(p.Args))
+
+	for i, arg := range p.Args {
+		args[i] = fmt.Sprintf("@%s %s", arg.Label, v.VisitVal(arg.Val))
 	}
 
-	return fmt.Sprintf("%s =l alloc%d %s", ret, align, size)
+	return fmt.Sprintf("%s =%s phi %s", v.VisitVal(p.Ret), v.VisitAbiTy(p.Ret.AbiTy), strings.Join(args, ", "))
 }
@@ -1,6 +1,8 @@
 package codegen
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/corani/cubit/internal/ir"
@@ -190,6 +192,21 @@ func TestAST_DataDef(t *testing.T) {
 			),
 			expected: "data $c = { l -1, l $c }",
 		},
+		{
+			name: "exported and aligned",
+			input: ir.NewDataDef(loc, ir.Ident("d"),
+				ir.NewDataInitZero(loc, 16),
+			).WithLinkage(ir.NewLinkageExport(loc)).WithAlign(8),
+			expected: "export data $d = align 8 { z 16 }",
+		},
+		{
+			name: "in a section",
+			input: ir.NewDataDef(loc, ir.Ident("e"),
+				ir.NewDataInitExt(loc, ir.ExtWord,
+					ir.NewDataItemConst(loc, ir.NewConstInteger(loc, 1))),
+			).WithLinkage(ir.NewLinkageSection(loc, ".rodata", "")),
+			expected: `section ".rodata" data $e = { w 1 }`,
+		},
 	}
 
 	for _, tc := range tt {
@@ -251,3 +268,141 @@ func TestAST_FuncDef(t *testing.T) {
 		})
 	}
 }
+
+func TestSSAVisitor_Locations(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "test.in")
+	require.NoError(t, os.WriteFile(filename, []byte("f :: func() -> int {\n    x := 1\n    return x\n}\n"), 0644))
+
+	at := func(line int) lexer.Location {
+		return lexer.Location{Filename: filename, Line: line, Column: 5}
+	}
+
+	word := ir.NewAbiTyBase(ir.BaseWord)
+	x := ir.NewValIdent(at(2), "x", word)
+
+	fd := ir.NewFuncDef(at(1), "f").WithRetTy(word).WithBlocks(
+		ir.NewBlock(at(1), "start", []ir.Instruction{
+			ir.NewBinop(at(2), ir.BinOpAdd, x, ir.NewValInteger(at(2), 0, word), ir.NewValInteger(at(2), 1, word)),
+			ir.NewJmp(at(2), "end"),
+		}),
+		ir.NewBlock(at(3), "end", []ir.Instruction{
+			ir.NewPhi(at(3), ir.NewValIdent(at(3), "y", word), ir.NewPhiArg("start", x)),
+			ir.NewRet(at(3), x),
+		}),
+	)
+
+	tt := []struct {
+		name     string
+		visitor  *SsaGen
+		expected string
+	}{
+		{
+			name:    "debug info",
+			visitor: NewSSAVisitor().WithDebugInfo(),
+			expected: "dbgfile \"" + filename + "\"\nfunction w $f() {\n" +
+				"@start\n\tdbgloc 2\n\t%x =w add 0, 1\n" +
+				"@end\n\t%y =w phi @start %x\n\tdbgloc 3\n\tret %x\n}",
+		},
+		{
+			name:    "source",
+			visitor: NewSSAVisitor().WithSource(),
+			expected: "function w $f() {\n" +
+				"@start\n\t# 2: x := 1\n\t%x =w add 0, 1\n" +
+				"@end\n\t%y =w phi @start %x\n\t# 3: return x\n\tret %x\n}",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual := fd.Accept(tc.visitor)
+			require.Equal(t, "\n# "+at(1).String()+"\n"+tc.expected, actual)
+		})
+	}
+}
+
+func TestAST_Memory(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{
+		Line:     1,
+		Column:   1,
+		Filename: "test.in",
+	}
+
+	word, long, ub := ir.NewAbiTyBase(ir.BaseWord), ir.NewAbiTyBase(ir.BaseLong), ir.NewAbiTySubW(ir.SubWUB)
+	addr := ir.NewValIdent(loc, "p", long)
+	x := ir.NewValIdent(loc, "x", word)
+
+	tt := []struct {
+		name     string
+		input    ir.Instruction
+		expected string
+	}{
+		{"load word", ir.NewLoad(loc, x, addr), "%x =w loadw %p"},
+		{"load byte", ir.NewLoad(loc, x, addr).WithTy(ub), "%x =w loadub %p"},
+		{"store long", ir.NewStore(loc, addr, addr), "storel %p, %p"},
+		{"store byte", ir.NewStore(loc, addr, x).WithTy(ub), "storeb %x, %p"},
+		{"alloc", ir.NewAlloc(loc, addr, ir.NewValInteger(loc, 8, long)), "%p =l alloc8 8"},
+		{"alloc aligned", ir.NewAlloc(loc, addr, ir.NewValInteger(loc, 3, long)).WithAlign(4), "%p =l alloc4 3"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			visitor := NewSSAVisitor()
+			actual := tc.input.Accept(visitor)
+
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestAST_Arithmetic(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{
+		Line:     1,
+		Column:   1,
+		Filename: "test.in",
+	}
+
+	word, long := ir.NewAbiTyBase(ir.BaseWord), ir.NewAbiTyBase(ir.BaseLong)
+	single, double := ir.NewAbiTyBase(ir.BaseSingle), ir.NewAbiTyBase(ir.BaseDouble)
+	x := ir.NewValIdent(loc, "x", word)
+	p := ir.NewValIdent(loc, "p", long)
+	d := ir.NewValIdent(loc, "d", double)
+
+	tt := []struct {
+		name     string
+		input    ir.Instruction
+		expected string
+	}{
+		{"word comparison", ir.NewBinop(loc, ir.BinOpLt, x, x, ir.NewValInteger(loc, 1, word)), "%x =w csltw %x, 1"},
+		{"long comparison", ir.NewBinop(loc, ir.BinOpEq, x, p, p), "%x =w ceql %p, %p"},
+		{"unsigned comparison", ir.NewBinop(loc, ir.BinOpULt, x, p, p), "%x =w cultl %p, %p"},
+		{"unsigned division", ir.NewBinop(loc, ir.BinOpUDiv, p, p, ir.NewValInteger(loc, 8, long)), "%p =l udiv %p, 8"},
+		{"unsigned remainder", ir.NewBinop(loc, ir.BinOpUMod, x, x, x), "%x =w urem %x, %x"},
+		{"double comparison", ir.NewBinop(loc, ir.BinOpGe, x, d, ir.NewValDouble(loc, 0.5)), "%x =w cged %d, d_0.5"},
+		{"single arithmetic", ir.NewBinop(loc, ir.BinOpMul, ir.NewValIdent(loc, "s", single),
+			ir.NewValSingle(loc, 1.25), ir.NewValSingle(loc, 1e-7)), "%s =s mul s_1.25, s_1e-07"},
+		{"word to double", ir.NewConvert(loc, d, x), "%d =d swtof %x"},
+		{"long to single", ir.NewConvert(loc, ir.NewValIdent(loc, "s", single), p), "%s =s sltof %p"},
+		{"double to long", ir.NewConvert(loc, p, d), "%p =l dtosi %d"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			visitor := NewSSAVisitor()
+			actual := tc.input.Accept(visitor)
+
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
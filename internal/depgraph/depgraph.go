@@ -0,0 +1,222 @@
+// Package depgraph builds the dependency graph of a program for cubit graph:
+// the packages and the packages they import, and the functions and the
+// functions they call. It's written as DOT, for Graphviz, or as JSON, for
+// tools like a build scheduler.
+package depgraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/corani/cubit/internal/ast"
+)
+
+// Graph is the dependency graph of a program.
+type Graph struct {
+	Packages []*Package `json:"packages"`
+	Funcs    []*Func    `json:"funcs"`
+}
+
+// Package is a package of the program.
+type Package struct {
+	Name string `json:"name"`
+	// Files are the source files of the package.
+	Files []string `json:"files"`
+	// Imports are the packages the files import, in lexical order.
+	Imports []string `json:"imports"`
+}
+
+// Func is a function of the program.
+type Func struct {
+	// ID is the name of the function in its package, with the types of its
+	// parameters if the name is overloaded, e.g. main.add(int, int).
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Package string `json:"package"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Extern  bool   `json:"extern,omitempty"`
+	Builtin bool   `json:"builtin,omitempty"`
+	// Calls are the IDs of the functions it calls, in the order of their
+	// first call.
+	Calls []string `json:"calls"`
+}
+
+// Build returns the graph of files, the files of a program as the loader
+// parsed them, once the program is type checked, so the calls are resolved.
+// The packages and the functions are in the order of the files.
+func Build(files []*ast.CompilationUnit) *Graph {
+	g := &Graph{Packages: []*Package{}, Funcs: []*Func{}}
+
+	packages := make(map[string]*Package)
+	overloads := make(map[string]int)
+
+	for _, cu := range files {
+		pkg, ok := packages[cu.Ident]
+		if !ok {
+			pkg = &Package{Name: cu.Ident, Files: []string{}, Imports: []string{}}
+			packages[cu.Ident] = pkg
+			g.Packages = append(g.Packages, pkg)
+		}
+
+		pkg.Files = append(pkg.Files, cu.Loc.Filename)
+
+		for _, importPath := range cu.Imports {
+			if !slices.Contains(pkg.Imports, importPath) {
+				pkg.Imports = append(pkg.Imports, importPath)
+			}
+		}
+
+		slices.Sort(pkg.Imports)
+
+		for _, fd := range cu.Funcs {
+			overloads[cu.Ident+"."+fd.Ident]++
+		}
+	}
+
+	ids := make(map[*ast.FuncDef]string)
+
+	for _, cu := range files {
+		for _, fd := range cu.Funcs {
+			id := cu.Ident + "." + fd.Ident
+			if overloads[id] > 1 {
+				id += signature(fd)
+			}
+
+			ids[fd] = id
+
+			g.Funcs = append(g.Funcs, &Func{
+				ID:      id,
+				Name:    fd.Ident,
+				Package: cu.Ident,
+				File:    fd.Loc.Filename,
+				Line:    fd.Loc.Line,
+				Extern:  fd.Attributes.Has(ast.AttrKeyExtern),
+				Builtin: fd.Attributes.Has(ast.AttrKeyBuiltin),
+			})
+		}
+	}
+
+	i := 0
+
+	for _, cu := range files {
+		for _, fd := range cu.Funcs {
+			g.Funcs[i].Calls = calls(fd, ids)
+			i++
+		}
+	}
+
+	return g
+}
+
+// signature returns the types of the parameters of fd, in parentheses.
+func signature(fd *ast.FuncDef) string {
+	params := make([]string, len(fd.Params))
+	for i, p := range fd.Params {
+		params[i] = p.Type.String()
+	}
+
+	return "(" + strings.Join(params, ", ") + ")"
+}
+
+// calls returns the IDs of the functions fd calls, without the ones that
+// aren't in the graph.
+func calls(fd *ast.FuncDef, ids map[*ast.FuncDef]string) []string {
+	callees := []string{}
+
+	if fd.Body == nil {
+		return callees
+	}
+
+	ast.Walk(fd.Body, func(n ast.Node) bool {
+		if call, ok := n.(*ast.Call); ok && call.FuncDef != nil {
+			if id, ok := ids[call.FuncDef]; ok && !slices.Contains(callees, id) {
+				callees = append(callees, id)
+			}
+		}
+
+		return true
+	})
+
+	return callees
+}
+
+// PackagesOnly returns the graph of the packages, without the functions.
+func (g *Graph) PackagesOnly() *Graph {
+	return &Graph{Packages: g.Packages, Funcs: []*Func{}}
+}
+
+// WriteJSON writes the graph as one JSON document.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(g)
+}
+
+// WriteDOT writes the graph in the DOT language of Graphviz. The functions
+// are grouped in a cluster per package, with an edge per call; extern
+// functions are dashed and builtins dotted. A graph without functions has a
+// node per package instead, with an edge per import.
+//
+//	dot -Tsvg graph.dot -o graph.svg
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var sb strings.Builder
+
+	sb.WriteString("digraph {\n")
+	sb.WriteString("\trankdir=LR;\n")
+	sb.WriteString("\tnode [shape=box];\n")
+
+	if len(g.Funcs) == 0 {
+		for _, pkg := range g.Packages {
+			fmt.Fprintf(&sb, "\t%q;\n", pkg.Name)
+		}
+
+		for _, pkg := range g.Packages {
+			for _, imp := range pkg.Imports {
+				fmt.Fprintf(&sb, "\t%q -> %q;\n", pkg.Name, imp)
+			}
+		}
+	} else {
+		for _, pkg := range g.Packages {
+			fmt.Fprintf(&sb, "\tsubgraph %q {\n", "cluster_"+pkg.Name)
+			fmt.Fprintf(&sb, "\t\tlabel=%q;\n", "package "+pkg.Name)
+
+			for _, fn := range g.Funcs {
+				if fn.Package != pkg.Name {
+					continue
+				}
+
+				fmt.Fprintf(&sb, "\t\t%q [label=%q%s];\n", fn.ID, strings.TrimPrefix(fn.ID, fn.Package+"."), style(fn))
+			}
+
+			sb.WriteString("\t}\n")
+		}
+
+		for _, fn := range g.Funcs {
+			for _, callee := range fn.Calls {
+				fmt.Fprintf(&sb, "\t%q -> %q;\n", fn.ID, callee)
+			}
+		}
+	}
+
+	sb.WriteString("}\n")
+
+	_, err := io.WriteString(w, sb.String())
+
+	return err
+}
+
+func style(fn *Func) string {
+	switch {
+	case fn.Extern:
+		return ", style=dashed"
+	case fn.Builtin:
+		return ", style=dotted"
+	default:
+		return ""
+	}
+}
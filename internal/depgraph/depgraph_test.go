@@ -0,0 +1,161 @@
+package depgraph
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/corani/cubit/internal/typecheck"
+	"github.com/stretchr/testify/require"
+)
+
+const mainSrc = `package main
+
+import "lib"
+
+add :: func(a: int, b: int) -> int {
+    return a + b
+}
+
+add :: func(a: bool, b: bool) -> bool {
+    return a || b
+}
+
+@(export)
+main :: func() -> int {
+    printf("%d\n", add(1, 2))
+    printf("%d\n", add(true, false))
+    return add(1, 2)
+}
+`
+
+const libSrc = `package lib
+
+@(extern)
+printf :: func(format: string, args: ..any)
+`
+
+func parse(t *testing.T, filename, src string) *ast.CompilationUnit {
+	t.Helper()
+
+	return testutil.Parse(t, filename, src)
+}
+
+// build returns the graph of the two files, type checked as one program like
+// the loader merges a package and its imports.
+func build(t *testing.T) *Graph {
+	t.Helper()
+
+	files := []*ast.CompilationUnit{parse(t, "main.in", mainSrc), parse(t, "lib.in", libSrc)}
+
+	unit := ast.NewCompilationUnit(files[0].Loc)
+	unit.Ident = files[0].Ident
+
+	for _, cu := range files {
+		unit.Funcs = append(unit.Funcs, cu.Funcs...)
+	}
+
+	require.NoError(t, typecheck.NewChecker(typecheck.Options{}).Check(unit))
+
+	return Build(files)
+}
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+
+	g := build(t)
+
+	require.Equal(t, []*Package{
+		{Name: "main", Files: []string{"main.in"}, Imports: []string{"lib"}},
+		{Name: "lib", Files: []string{"lib.in"}, Imports: []string{}},
+	}, g.Packages)
+
+	require.Equal(t, []*Func{
+		{ID: "main.add(int, int)", Name: "add", Package: "main", File: "main.in", Line: 5, Calls: []string{}},
+		{ID: "main.add(bool, bool)", Name: "add", Package: "main", File: "main.in", Line: 9, Calls: []string{}},
+		{ID: "main.main", Name: "main", Package: "main", File: "main.in", Line: 14,
+			Calls: []string{"lib.printf", "main.add(int, int)", "main.add(bool, bool)"}},
+		{ID: "lib.printf", Name: "printf", Package: "lib", File: "lib.in", Line: 4, Extern: true, Calls: []string{}},
+	}, g.Funcs)
+}
+
+func TestGraph_Write(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		packages bool
+		write    func(g *Graph, w *bytes.Buffer) error
+		expected string
+	}{
+		{
+			name:  "dot",
+			write: func(g *Graph, w *bytes.Buffer) error { return g.WriteDOT(w) },
+			expected: `digraph {
+	rankdir=LR;
+	node [shape=box];
+	subgraph "cluster_main" {
+		label="package main";
+		"main.add(int, int)" [label="add(int, int)"];
+		"main.add(bool, bool)" [label="add(bool, bool)"];
+		"main.main" [label="main"];
+	}
+	subgraph "cluster_lib" {
+		label="package lib";
+		"lib.printf" [label="printf", style=dashed];
+	}
+	"main.main" -> "lib.printf";
+	"main.main" -> "main.add(int, int)";
+	"main.main" -> "main.add(bool, bool)";
+}
+`,
+		},
+		{
+			name:     "dot packages",
+			packages: true,
+			write:    func(g *Graph, w *bytes.Buffer) error { return g.WriteDOT(w) },
+			expected: `digraph {
+	rankdir=LR;
+	node [shape=box];
+	"main";
+	"lib";
+	"main" -> "lib";
+}
+`,
+		},
+		{
+			name:     "json packages",
+			packages: true,
+			write:    func(g *Graph, w *bytes.Buffer) error { return g.WriteJSON(w) },
+			expected: `{
+  "packages": [
+    {"name": "main", "files": ["main.in"], "imports": ["lib"]},
+    {"name": "lib", "files": ["lib.in"], "imports": []}
+  ],
+  "funcs": []
+}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			g := build(t)
+			if tc.packages {
+				g = g.PackagesOnly()
+			}
+
+			var buf bytes.Buffer
+			require.NoError(t, tc.write(g, &buf))
+
+			if strings.HasPrefix(tc.expected, "{") {
+				require.JSONEq(t, tc.expected, buf.String())
+			} else {
+				require.Equal(t, tc.expected, buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package diag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Config selects the diagnostics a Bag keeps.
+type Config struct {
+	// Strict reports warnings as errors.
+	Strict bool
+	// Disable lists the codes that aren't reported.
+	Disable []*Code
+	// Enable lists the codes that are reported, even if they're disabled.
+	Enable []*Code
+}
+
+// Bag collects the diagnostics of a compilation. All passes report to the same
+// bag, so the diagnostics can be written in one go, as text, JSON or SARIF, or
+// rendered for a terminal, once compilation stops.
+type Bag struct {
+	cfg      Config
+	disabled map[*Code]bool
+	diags    []Diagnostic
+}
+
+func NewBag(cfg Config) *Bag {
+	disabled := make(map[*Code]bool)
+
+	for _, code := range cfg.Disable {
+		disabled[code] = true
+	}
+
+	for _, code := range cfg.Enable {
+		delete(disabled, code)
+	}
+
+	return &Bag{
+		cfg:      cfg,
+		disabled: disabled,
+	}
+}
+
+// Enabled reports whether diagnostics with the given code are reported.
+func (b *Bag) Enabled(code *Code) bool {
+	return !b.disabled[code]
+}
+
+// Report records d, unless its code is disabled. In strict mode, warnings are
+// promoted to errors. It returns the diagnostic as recorded, and false if it was
+// dropped.
+func (b *Bag) Report(d Diagnostic) (Diagnostic, bool) {
+	if d.Code != nil && !b.Enabled(d.Code) {
+		return d, false
+	}
+
+	if d.Severity == SeverityWarning && b.cfg.Strict {
+		d.Severity = SeverityError
+	}
+
+	b.diags = append(b.diags, d)
+
+	return d, true
+}
+
+// Add records err as an error. Diagnostics keep their location and notes, other
+// errors are recorded without a location. It returns the recorded diagnostic.
+func (b *Bag) Add(err error) Diagnostic {
+	var d Diagnostic
+	if !errors.As(err, &d) {
+		d = Diagnostic{Severity: SeverityError, Message: err.Error()}
+	}
+
+	d, _ = b.Report(d)
+
+	return d
+}
+
+// Errorf reports an error at loc.
+func (b *Bag) Errorf(loc lexer.Location, format string, args ...any) Diagnostic {
+	return b.Add(Errorf(loc, format, args...))
+}
+
+// Reportf reports a diagnostic with the given code at loc, see Report.
+func (b *Bag) Reportf(code *Code, loc lexer.Location, format string, args ...any) (Diagnostic, bool) {
+	return b.Report(Newf(code, loc, format, args...))
+}
+
+// Diagnostics returns the recorded diagnostics, in the order they were reported.
+func (b *Bag) Diagnostics() []Diagnostic {
+	return b.diags
+}
+
+// Filter returns the recorded diagnostics of the given severity as errors.
+func (b *Bag) Filter(severity Severity) []error {
+	var errs []error
+
+	for _, d := range b.diags {
+		if d.Severity == severity {
+			errs = append(errs, d)
+		}
+	}
+
+	return errs
+}
+
+// HasErrors reports whether any errors were recorded.
+func (b *Bag) HasErrors() bool {
+	return len(b.Filter(SeverityError)) > 0
+}
+
+// Err returns the recorded errors joined into one, or nil if there are none.
+func (b *Bag) Err() error {
+	return errors.Join(b.Filter(SeverityError)...)
+}
+
+// WriteText writes the diagnostics one per line, in the same format as
+// lexer.Location.Errorf, followed by their notes.
+func (b *Bag) WriteText(w io.Writer) error {
+	for _, d := range b.diags {
+		var err error
+
+		if d.Span.IsZero() {
+			_, err = fmt.Fprintf(w, "[%s] %s\n", d.Severity.tag(), d.message())
+		} else {
+			_, err = fmt.Fprintf(w, "%s: [%s] %s\n", d.Span, d.Severity.tag(), d.message())
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type jsonLocation struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+type jsonSpan struct {
+	Start jsonLocation  `json:"start"`
+	End   *jsonLocation `json:"end,omitempty"`
+}
+
+type jsonNote struct {
+	Span    *jsonSpan `json:"span,omitempty"`
+	Message string    `json:"message"`
+}
+
+type jsonDiagnostic struct {
+	Code     string     `json:"code,omitempty"`
+	Name     string     `json:"name,omitempty"`
+	Severity string     `json:"severity"`
+	Span     *jsonSpan  `json:"span,omitempty"`
+	Message  string     `json:"message"`
+	Notes    []jsonNote `json:"notes,omitempty"`
+}
+
+func newJSONSpan(s Span) *jsonSpan {
+	if s.IsZero() {
+		return nil
+	}
+
+	js := &jsonSpan{
+		Start: jsonLocation{File: s.Start.Filename, Line: s.Start.Line, Column: s.Start.Column},
+	}
+
+	if s.End != (lexer.Location{}) {
+		js.End = &jsonLocation{File: s.End.Filename, Line: s.End.Line, Column: s.End.Column}
+	}
+
+	return js
+}
+
+// WriteJSON writes the diagnostics as JSON, one object per line, for editors and
+// other tools.
+func (b *Bag) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	for _, d := range b.diags {
+		jd := jsonDiagnostic{
+			Severity: d.Severity.String(),
+			Span:     newJSONSpan(d.Span),
+			Message:  d.Message,
+		}
+
+		if d.Code != nil {
+			jd.Code, jd.Name = d.Code.ID, d.Code.Name
+		}
+
+		for _, note := range d.Notes {
+			jd.Notes = append(jd.Notes, jsonNote{Span: newJSONSpan(note.Span), Message: note.Message})
+		}
+
+		if err := enc.Encode(jd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
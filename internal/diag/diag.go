@@ -0,0 +1,233 @@
+// Package diag implements the diagnostics reported by the compiler passes:
+// errors, warnings and notes, the stable codes that identify the kinds of
+// warnings, and the bag that collects the diagnostics of all passes.
+package diag
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Severity is the level of a diagnostic.
+type Severity int
+
+const (
+	SeverityError   Severity = iota // compilation fails
+	SeverityWarning                 // likely a mistake, but the code compiles
+	SeverityNote                    // informational
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// tag returns the marker printed in front of the message, consistent with
+// lexer.Location.Errorf and friends.
+func (s Severity) tag() string {
+	switch s {
+	case SeverityError:
+		return "ERRO"
+	case SeverityWarning:
+		return "WARN"
+	default:
+		return "NOTE"
+	}
+}
+
+// Code identifies a kind of diagnostic that can be enabled, disabled or
+// suppressed in the source. IDs never change, so they're safe to use in build
+// scripts and attributes. Names are a readable alternative, and a group selects
+// all related codes at once.
+type Code struct {
+	ID       string   // e.g. CB0001
+	Name     string   // e.g. unused-variable
+	Group    string   // e.g. unused
+	Severity Severity // severity when reported
+	Summary  string
+}
+
+var (
+	UnusedVariable = &Code{
+		ID: "CB0001", Name: "unused-variable", Group: "unused", Severity: SeverityWarning,
+		Summary: "a local variable is declared but never read",
+	}
+	UnusedParameter = &Code{
+		ID: "CB0002", Name: "unused-parameter", Group: "unused", Severity: SeverityWarning,
+		Summary: "a parameter of a function with a body is never read",
+	}
+	ShadowedVariable = &Code{
+		ID: "CB0003", Name: "shadowed-variable", Group: "shadow", Severity: SeverityWarning,
+		Summary: "a declaration in an inner scope hides a variable or parameter",
+	}
+	UnusedResult = &Code{
+		ID: "CB0004", Name: "unused-result", Group: "unused", Severity: SeverityWarning,
+		Summary: "the result of a call to a pure function is discarded",
+	}
+	Deprecated = &Code{
+		ID: "CB0005", Name: "deprecated", Group: "deprecated", Severity: SeverityWarning,
+		Summary: "a call to a function marked deprecated",
+	}
+)
+
+// Codes lists the known codes, ordered by ID.
+var Codes = []*Code{
+	UnusedVariable,
+	UnusedParameter,
+	ShadowedVariable,
+	UnusedResult,
+	Deprecated,
+}
+
+func (c *Code) String() string {
+	return fmt.Sprintf("%s (%s)", c.ID, c.Name)
+}
+
+// Matches reports whether name selects the code: its ID, name or group.
+func (c *Code) Matches(name string) bool {
+	return strings.EqualFold(name, c.ID) || name == c.Name || name == c.Group
+}
+
+// Lookup returns the codes selected by name, see Code.Matches.
+func Lookup(name string) ([]*Code, error) {
+	var codes []*Code
+
+	for _, code := range Codes {
+		if code.Matches(name) {
+			codes = append(codes, code)
+		}
+	}
+
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("unknown diagnostic '%s'", name)
+	}
+
+	return codes, nil
+}
+
+// ParseList returns the codes selected by a comma-separated list of IDs, names
+// and groups, e.g. "unused,CB0003". Empty entries are ignored.
+func ParseList(s string) ([]*Code, error) {
+	var codes []*Code
+
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		selected, err := Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, selected...)
+	}
+
+	return codes, nil
+}
+
+// Span is the range of source a diagnostic refers to. The AST only records
+// where nodes start, so End is often the zero Location.
+type Span struct {
+	Start, End lexer.Location
+}
+
+// At returns the span that starts at loc.
+func At(loc lexer.Location) Span {
+	return Span{Start: loc}
+}
+
+// IsZero reports whether the span doesn't refer to any source, e.g. for errors
+// reading a file.
+func (s Span) IsZero() bool {
+	return s.Start == lexer.Location{}
+}
+
+func (s Span) String() string {
+	return s.Start.String()
+}
+
+// Note adds information to a diagnostic, usually about a related location, e.g.
+// where a redeclared name was first declared.
+type Note struct {
+	Span    Span
+	Message string
+}
+
+// Diagnostic is a single message reported at a location in the source. It
+// implements error, so diagnostics can be joined and returned like any other
+// error.
+type Diagnostic struct {
+	Code     *Code // nil for errors, which can't be disabled
+	Severity Severity
+	Span     Span
+	Message  string
+	Notes    []Note
+}
+
+// Errorf returns an error at loc. Use a Bag to report it.
+func Errorf(loc lexer.Location, format string, args ...any) Diagnostic {
+	return Diagnostic{
+		Severity: SeverityError,
+		Span:     At(loc),
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// Newf returns a diagnostic with the given code at loc, with the severity of
+// the code. Use a Bag to report it.
+func Newf(code *Code, loc lexer.Location, format string, args ...any) Diagnostic {
+	return Diagnostic{
+		Code:     code,
+		Severity: code.Severity,
+		Span:     At(loc),
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// WithNote returns a copy of d with a note at loc.
+func (d Diagnostic) WithNote(loc lexer.Location, format string, args ...any) Diagnostic {
+	d.Notes = append(d.Notes[:len(d.Notes):len(d.Notes)], Note{
+		Span:    At(loc),
+		Message: fmt.Sprintf(format, args...),
+	})
+
+	return d
+}
+
+func (d Diagnostic) Error() string {
+	if d.Span.IsZero() {
+		return d.message()
+	}
+
+	return fmt.Sprintf("%s: %s", d.Span, d.message())
+}
+
+// message returns the message, followed by the code if there is one and the
+// notes, each on a line of its own.
+func (d Diagnostic) message() string {
+	var sb strings.Builder
+
+	sb.WriteString(d.Message)
+
+	if d.Code != nil {
+		fmt.Fprintf(&sb, " [%s]", d.Code.ID)
+	}
+
+	for _, note := range d.Notes {
+		fmt.Fprintf(&sb, "\n\t%s: %s", note.Span, note.Message)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,288 @@
+package diag
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseList(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		list     string
+		expected []*Code
+		err      string
+	}{
+		{name: "empty", list: ""},
+		{name: "id", list: "CB0002", expected: []*Code{UnusedParameter}},
+		{name: "lowercase id", list: "cb0002", expected: []*Code{UnusedParameter}},
+		{name: "name", list: "unused-variable", expected: []*Code{UnusedVariable}},
+		{name: "group", list: "unused", expected: []*Code{UnusedVariable, UnusedParameter, UnusedResult}},
+		{name: "list", list: " CB0001, ,unused-parameter ", expected: []*Code{UnusedVariable, UnusedParameter}},
+		{name: "unknown", list: "unused,bogus", err: "unknown diagnostic 'bogus'"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			codes, err := ParseList(tc.list)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, codes)
+		})
+	}
+}
+
+func TestBag(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 1, Column: 2}
+
+	tt := []struct {
+		name     string
+		cfg      Config
+		expected []Diagnostic
+	}{
+		{
+			name: "default",
+			expected: []Diagnostic{
+				{Code: UnusedVariable, Severity: SeverityWarning, Span: At(loc), Message: "warning"},
+				{Severity: SeverityError, Span: At(loc), Message: "error"},
+			},
+		},
+		{
+			name: "strict",
+			cfg:  Config{Strict: true},
+			expected: []Diagnostic{
+				{Code: UnusedVariable, Severity: SeverityError, Span: At(loc), Message: "warning"},
+				{Severity: SeverityError, Span: At(loc), Message: "error"},
+			},
+		},
+		{
+			name: "disabled",
+			cfg:  Config{Disable: []*Code{UnusedVariable}},
+			expected: []Diagnostic{
+				{Severity: SeverityError, Span: At(loc), Message: "error"},
+			},
+		},
+		{
+			name: "enabled",
+			cfg:  Config{Disable: []*Code{UnusedVariable}, Enable: []*Code{UnusedVariable}},
+			expected: []Diagnostic{
+				{Code: UnusedVariable, Severity: SeverityWarning, Span: At(loc), Message: "warning"},
+				{Severity: SeverityError, Span: At(loc), Message: "error"},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			b := NewBag(tc.cfg)
+			b.Reportf(UnusedVariable, loc, "%s", "warning")
+			b.Errorf(loc, "%s", "error")
+
+			require.Equal(t, tc.expected, b.Diagnostics())
+			require.True(t, b.HasErrors())
+		})
+	}
+}
+
+func TestDiagnostic_Error(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 4}
+	prev := lexer.Location{Filename: "test.in", Line: 1, Column: 1}
+
+	require.EqualError(t, Errorf(loc, "oops"), "test.in:3:4: oops")
+	require.EqualError(t, Diagnostic{Code: UnusedParameter, Span: At(loc), Message: "oops"}, "test.in:3:4: oops [CB0002]")
+	require.EqualError(t, Errorf(loc, "'%s' redeclared", "x").WithNote(prev, "previously declared here"),
+		"test.in:3:4: 'x' redeclared\n\ttest.in:1:1: previously declared here")
+}
+
+func TestBag_Write(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 4}
+	prev := lexer.Location{Filename: "test.in", Line: 1, Column: 1}
+
+	b := NewBag(Config{})
+	b.Add(Errorf(loc, "'x' redeclared").WithNote(prev, "previously declared here"))
+	b.Reportf(UnusedVariable, loc, "variable 'x' is declared but never used")
+	b.Add(Errorf(lexer.Location{}, "no such file"))
+
+	t.Run("text", func(t *testing.T) {
+		t.Parallel()
+
+		var sb strings.Builder
+		require.NoError(t, b.WriteText(&sb))
+		require.Equal(t, "test.in:3:4: [ERRO] 'x' redeclared\n"+
+			"\ttest.in:1:1: previously declared here\n"+
+			"test.in:3:4: [WARN] variable 'x' is declared but never used [CB0001]\n"+
+			"[ERRO] no such file\n", sb.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+
+		var sb strings.Builder
+		require.NoError(t, b.WriteJSON(&sb))
+		require.Equal(t, `{"severity":"error","span":{"start":{"file":"test.in","line":3,"column":4}},`+
+			`"message":"'x' redeclared","notes":[{"span":{"start":{"file":"test.in","line":1,"column":1}},`+
+			`"message":"previously declared here"}]}`+"\n"+
+			`{"code":"CB0001","name":"unused-variable","severity":"warning",`+
+			`"span":{"start":{"file":"test.in","line":3,"column":4}},"message":"variable 'x' is declared but never used"}`+"\n"+
+			`{"severity":"error","message":"no such file"}`+"\n", sb.String())
+	})
+
+	t.Run("sarif", func(t *testing.T) {
+		t.Parallel()
+
+		var sb strings.Builder
+		require.NoError(t, b.WriteSARIF(&sb))
+
+		var log struct {
+			Version string `json:"version"`
+			Runs    []struct {
+				Tool struct {
+					Driver struct {
+						Name  string           `json:"name"`
+						Rules []map[string]any `json:"rules"`
+					} `json:"driver"`
+				} `json:"tool"`
+				Results []json.RawMessage `json:"results"`
+			} `json:"runs"`
+		}
+
+		require.NoError(t, json.Unmarshal([]byte(sb.String()), &log))
+		require.Equal(t, "2.1.0", log.Version)
+		require.Len(t, log.Runs, 1)
+
+		run := log.Runs[0]
+		require.Equal(t, "cubit", run.Tool.Driver.Name)
+		require.Len(t, run.Tool.Driver.Rules, len(Codes))
+		require.Equal(t, "unused-variable", run.Tool.Driver.Rules[0]["name"])
+		require.Len(t, run.Results, 3)
+		require.JSONEq(t, `{"level":"error","message":{"text":"'x' redeclared"},
+			"locations":[{"physicalLocation":{"artifactLocation":{"uri":"test.in"},"region":{"startLine":3,"startColumn":4}}}],
+			"relatedLocations":[{"physicalLocation":{"artifactLocation":{"uri":"test.in"},"region":{"startLine":1,"startColumn":1}},
+				"message":{"text":"previously declared here"}}]}`, string(run.Results[0]))
+		require.JSONEq(t, `{"ruleId":"CB0001","level":"warning","message":{"text":"variable 'x' is declared but never used"},
+			"locations":[{"physicalLocation":{"artifactLocation":{"uri":"test.in"},"region":{"startLine":3,"startColumn":4}}}]}`,
+			string(run.Results[1]))
+		require.JSONEq(t, `{"level":"error","message":{"text":"no such file"}}`, string(run.Results[2]))
+	})
+}
+
+func TestSARIFURI(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "src/main.in", sarifURI(filepath.Join("src", "main.in")))
+	require.Equal(t, "file:///home/user/my%20project/main.in", sarifURI("/home/user/my project/main.in"))
+}
+
+func TestBag_Render(t *testing.T) {
+	t.Parallel()
+
+	src := "package main\n\nmain :: func() {\n\tcount := 1\n\tcount := 2\n}\n"
+	readFile := func(filename string) ([]byte, error) {
+		if filename != "test.in" {
+			return nil, errors.New("not found")
+		}
+
+		return []byte(src), nil
+	}
+
+	b := NewBag(Config{})
+	b.Add(Errorf(lexer.Location{Filename: "test.in", Line: 5, Column: 2}, "'count' redeclared in this block").
+		WithNote(lexer.Location{Filename: "test.in", Line: 4, Column: 2}, "previously declared here"))
+	b.Reportf(UnusedVariable, lexer.Location{Filename: "other.in", Line: 1, Column: 1}, "variable 'x' is declared but never used")
+	b.Add(errors.New("no such file"))
+
+	tt := []struct {
+		name     string
+		opts     RenderOptions
+		expected string
+	}{
+		{
+			name: "plain",
+			expected: "error: 'count' redeclared in this block\n" +
+				" --> test.in:5:2\n" +
+				"  |\n" +
+				"5 | \tcount := 2\n" +
+				"  | \t^^^^^\n" +
+				"note: previously declared here\n" +
+				" --> test.in:4:2\n" +
+				"  |\n" +
+				"4 | \tcount := 1\n" +
+				"  | \t^^^^^\n" +
+				"warning[CB0001]: variable 'x' is declared but never used\n" +
+				" --> other.in:1:1\n" +
+				"error: no such file\n",
+		},
+		{
+			name: "wrapped",
+			opts: RenderOptions{Width: 30},
+			expected: "error: 'count' redeclared in\n" +
+				"       this block\n" +
+				" --> test.in:5:2\n" +
+				"  |\n" +
+				"5 | \tcount := 2\n" +
+				"  | \t^^^^^\n" +
+				"note: previously declared here\n" +
+				" --> test.in:4:2\n" +
+				"  |\n" +
+				"4 | \tcount := 1\n" +
+				"  | \t^^^^^\n" +
+				"warning[CB0001]: variable 'x'\n" +
+				"                 is declared\n" +
+				"                 but never\n" +
+				"                 used\n" +
+				" --> other.in:1:1\n" +
+				"error: no such file\n",
+		},
+		{
+			name: "color",
+			opts: RenderOptions{Color: true},
+			expected: "\x1b[1;31merror:\x1b[0m \x1b[1m'count' redeclared in this block\x1b[0m\n" +
+				" \x1b[1;34m-->\x1b[0m test.in:5:2\n" +
+				"  \x1b[1;34m|\x1b[0m\n" +
+				"\x1b[1;34m5\x1b[0m \x1b[1;34m|\x1b[0m \tcount := 2\n" +
+				"  \x1b[1;34m|\x1b[0m \t\x1b[1;31m^^^^^\x1b[0m\n" +
+				"\x1b[1;36mnote:\x1b[0m \x1b[1mpreviously declared here\x1b[0m\n" +
+				" \x1b[1;34m-->\x1b[0m test.in:4:2\n" +
+				"  \x1b[1;34m|\x1b[0m\n" +
+				"\x1b[1;34m4\x1b[0m \x1b[1;34m|\x1b[0m \tcount := 1\n" +
+				"  \x1b[1;34m|\x1b[0m \t\x1b[1;36m^^^^^\x1b[0m\n" +
+				"\x1b[1;33mwarning[CB0001]:\x1b[0m \x1b[1mvariable 'x' is declared but never used\x1b[0m\n" +
+				" \x1b[1;34m-->\x1b[0m other.in:1:1\n" +
+				"\x1b[1;31merror:\x1b[0m \x1b[1mno such file\x1b[0m\n",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tc.opts.ReadFile = readFile
+
+			var sb strings.Builder
+			require.NoError(t, b.Render(&sb, tc.opts))
+			require.Equal(t, tc.expected, sb.String())
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package diag
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The ANSI escapes Render colors with.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[1;31m"
+	ansiYellow = "\x1b[1;33m"
+	ansiCyan   = "\x1b[1;36m"
+	ansiBlue   = "\x1b[1;34m"
+)
+
+// RenderOptions configure Render.
+type RenderOptions struct {
+	// Color colors the severities, the underlines and the gutter with ANSI
+	// escapes.
+	Color bool
+	// Width is the width of the terminal, which the messages are wrapped
+	// to; 0 doesn't wrap them.
+	Width int
+	// ReadFile reads the source of the spans, os.ReadFile if it's nil.
+	ReadFile func(filename string) ([]byte, error)
+}
+
+// renderer writes the diagnostics of a bag for a terminal.
+type renderer struct {
+	w       io.Writer
+	opts    RenderOptions
+	sources map[string][]string // the lines of the files, nil if unreadable
+	err     error
+}
+
+// Render writes the diagnostics for a person at a terminal: the severity and
+// the message, wrapped to the width, and the line of source of the span with
+// the span underlined, followed by the notes in the same way.
+//
+//	error: 'x' redeclared
+//	 --> test.in:3:5
+//	  |
+//	3 |     x := 2
+//	  |     ^
+//	note: previously declared here
+//	 --> test.in:2:5
+//	  |
+//	2 |     x := 1
+//	  |     ^
+func (b *Bag) Render(w io.Writer, opts RenderOptions) error {
+	if opts.ReadFile == nil {
+		opts.ReadFile = os.ReadFile
+	}
+
+	r := &renderer{w: w, opts: opts, sources: make(map[string][]string)}
+
+	for _, d := range b.diags {
+		title := d.Severity.String()
+		if d.Code != nil {
+			title = fmt.Sprintf("%s[%s]", title, d.Code.ID)
+		}
+
+		r.entry(severityColor(d.Severity), title, d.Message, d.Span)
+
+		for _, note := range d.Notes {
+			r.entry(ansiCyan, SeverityNote.String(), note.Message, note.Span)
+		}
+	}
+
+	return r.err
+}
+
+func severityColor(s Severity) string {
+	switch s {
+	case SeverityError:
+		return ansiRed
+	case SeverityWarning:
+		return ansiYellow
+	default:
+		return ansiCyan
+	}
+}
+
+// color returns s in color, if the options ask for it.
+func (r *renderer) color(color, s string) string {
+	if !r.opts.Color || s == "" {
+		return s
+	}
+
+	return color + s + ansiReset
+}
+
+func (r *renderer) printf(format string, args ...any) {
+	if r.err == nil {
+		_, r.err = fmt.Fprintf(r.w, format, args...)
+	}
+}
+
+// entry writes a diagnostic or a note: its title and message, and the source
+// of its span.
+func (r *renderer) entry(color, title, message string, span Span) {
+	prefix := title + ": "
+	lines := wrap(message, r.opts.Width-len(prefix))
+
+	r.printf("%s%s\n", r.color(color, title+":")+" ", r.color(ansiBold, lines[0]))
+
+	for _, line := range lines[1:] {
+		r.printf("%s%s\n", strings.Repeat(" ", len(prefix)), r.color(ansiBold, line))
+	}
+
+	if span.IsZero() {
+		return
+	}
+
+	line, ok := r.line(span.Start.Filename, span.Start.Line)
+	number := strconv.Itoa(span.Start.Line)
+	gutter := strings.Repeat(" ", len(number))
+
+	r.printf("%s%s %s\n", gutter, r.color(ansiBlue, "-->"), span.Start)
+
+	if !ok {
+		return
+	}
+
+	start := min(max(span.Start.Column-1, 0), len(line))
+	width := underlineWidth(line, start, span)
+
+	// The underline keeps the tabs of the line, so it lines up with it.
+	indent := strings.Map(func(c rune) rune {
+		if c == '\t' {
+			return c
+		}
+
+		return ' '
+	}, line[:start])
+
+	r.printf("%s %s\n", gutter, r.color(ansiBlue, "|"))
+	r.printf("%s %s %s\n", r.color(ansiBlue, number), r.color(ansiBlue, "|"), line)
+	r.printf("%s %s %s%s\n", gutter, r.color(ansiBlue, "|"), indent, r.color(color, strings.Repeat("^", width)))
+}
+
+// line returns the line of source at number in filename.
+func (r *renderer) line(filename string, number int) (string, bool) {
+	lines, ok := r.sources[filename]
+	if !ok {
+		if src, err := r.opts.ReadFile(filename); err == nil {
+			lines = strings.Split(string(bytes.TrimSuffix(src, []byte("\n"))), "\n")
+		}
+
+		r.sources[filename] = lines
+	}
+
+	if number < 1 || number > len(lines) {
+		return "", false
+	}
+
+	return strings.TrimRight(lines[number-1], "\r"), true
+}
+
+// underlineWidth returns how many columns from start of line the span
+// covers: up to its end if it's on the same line, or else the word at start,
+// or one column.
+func underlineWidth(line string, start int, span Span) int {
+	if span.End.Line == span.Start.Line && span.End.Column > span.Start.Column {
+		return min(span.End.Column-span.Start.Column, max(len(line)-start, 1))
+	}
+
+	end := start
+	for end < len(line) && (line[end] == '_' || unicode.IsLetter(rune(line[end])) || unicode.IsDigit(rune(line[end]))) {
+		end++
+	}
+
+	return max(end-start, 1)
+}
+
+// wrap splits message into lines of at most width bytes, at spaces. A word
+// longer than width gets a line of its own. With a width below 1, the message
+// is one line.
+func wrap(message string, width int) []string {
+	if width < 1 || len(message) <= width {
+		return []string{message}
+	}
+
+	var (
+		lines []string
+		line  string
+	)
+
+	for _, word := range strings.Fields(message) {
+		switch {
+		case line == "":
+			line = word
+		case len(line)+1+len(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+
+	return append(lines, line)
+}
@@ -0,0 +1,169 @@
+package diag
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// The diagnostics are written as a SARIF 2.1.0 log, the format code review
+// tools and CI systems read the results of static analysis in. A log has one
+// run of the compiler, with the warnings as its rules and the diagnostics as
+// its results. The notes are related locations.
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string            `json:"id"`
+	Name                 string            `json:"name"`
+	ShortDescription     sarifMessage      `json:"shortDescription"`
+	DefaultConfiguration sarifRuleDefaults `json:"defaultConfiguration"`
+}
+
+type sarifRuleDefaults struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId,omitempty"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations,omitempty"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	Message          *sarifMessage          `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+// level returns the SARIF level of a severity, which has the same names.
+func (s Severity) level() string {
+	return s.String()
+}
+
+// sarifURI returns the URI of a file: a file URI for an absolute path, and
+// a relative reference otherwise.
+func sarifURI(filename string) string {
+	path := filepath.ToSlash(filename)
+
+	if !filepath.IsAbs(filename) {
+		return (&url.URL{Path: path}).String()
+	}
+
+	// Windows paths start with the drive letter.
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+func newSARIFPhysicalLocation(s Span) *sarifPhysicalLocation {
+	if s.IsZero() {
+		return nil
+	}
+
+	loc := &sarifPhysicalLocation{
+		ArtifactLocation: sarifArtifactLocation{URI: sarifURI(s.Start.Filename)},
+		Region:           sarifRegion{StartLine: s.Start.Line, StartColumn: s.Start.Column},
+	}
+
+	if s.End.Line > 0 {
+		loc.Region.EndLine, loc.Region.EndColumn = s.End.Line, s.End.Column
+	}
+
+	return loc
+}
+
+// WriteSARIF writes the diagnostics as a SARIF log, for code review tools and
+// CI systems. Unlike the other formats it's one document, so it's written
+// once, with all the diagnostics.
+func (b *Bag) WriteSARIF(w io.Writer) error {
+	driver := sarifDriver{Name: "cubit", Rules: []sarifRule{}}
+
+	for _, code := range Codes {
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:                   code.ID,
+			Name:                 code.Name,
+			ShortDescription:     sarifMessage{Text: code.Summary},
+			DefaultConfiguration: sarifRuleDefaults{Level: code.Severity.level()},
+		})
+	}
+
+	run := sarifRun{Tool: sarifTool{Driver: driver}, Results: []sarifResult{}}
+
+	for _, d := range b.diags {
+		result := sarifResult{
+			Level:   d.Severity.level(),
+			Message: sarifMessage{Text: d.Message},
+		}
+
+		if d.Code != nil {
+			result.RuleID = d.Code.ID
+		}
+
+		if loc := newSARIFPhysicalLocation(d.Span); loc != nil {
+			result.Locations = []sarifLocation{{PhysicalLocation: loc}}
+		}
+
+		for _, note := range d.Notes {
+			result.RelatedLocations = append(result.RelatedLocations, sarifLocation{
+				PhysicalLocation: newSARIFPhysicalLocation(note.Span),
+				Message:          &sarifMessage{Text: note.Message},
+			})
+		}
+
+		run.Results = append(run.Results, result)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(sarifLog{Version: sarifVersion, Schema: sarifSchema, Runs: []sarifRun{run}})
+}
@@ -0,0 +1,147 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around the changes in a hunk.
+const diffContext = 3
+
+// Diff returns the changes from old to new as a unified diff, like `diff -u`,
+// or nothing if they're the same. The lines are matched with a longest common
+// subsequence, which is fine for source files.
+func Diff(oldName, newName string, old, new []byte) []byte {
+	a, b := lines(old), lines(new)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	// edit is a line of the diff: ' ' if it's in both, '-' if it's only in
+	// old and '+' if it's only in new.
+	type edit struct {
+		op   byte
+		text string
+	}
+
+	var edits []edit
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+
+	var sb strings.Builder
+
+	// The hunks cover the changes with their context, and changes that are
+	// closer than twice the context share a hunk.
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+
+		end := start
+		for k := start; k < len(edits) && k <= end+2*diffContext; k++ {
+			if edits[k].op != ' ' {
+				end = k
+			}
+		}
+
+		from, to := max(start-diffContext, 0), min(end+diffContext+1, len(edits))
+
+		// The first lines of the hunk in old and new, counted from 1.
+		oldLine, newLine := 1, 1
+
+		for _, e := range edits[:from] {
+			if e.op != '+' {
+				oldLine++
+			}
+
+			if e.op != '-' {
+				newLine++
+			}
+		}
+
+		oldCount, newCount := 0, 0
+
+		for _, e := range edits[from:to] {
+			if e.op != '+' {
+				oldCount++
+			}
+
+			if e.op != '-' {
+				newCount++
+			}
+		}
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+		}
+
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+
+		for _, e := range edits[from:to] {
+			fmt.Fprintf(&sb, "%c%s\n", e.op, e.text)
+		}
+
+		start = to
+	}
+
+	return []byte(sb.String())
+}
+
+// lines splits src into lines, without their newlines. A last line without
+// one is marked like in a unified diff, so it differs from the same line with
+// one.
+func lines(src []byte) []string {
+	if len(src) == 0 {
+		return nil
+	}
+
+	text, ok := strings.CutSuffix(string(src), "\n")
+	lines := strings.Split(text, "\n")
+
+	if !ok {
+		lines[len(lines)-1] += "\n\\ No newline at end of file"
+	}
+
+	return lines
+}
+
+// hunkRange returns the range of a hunk of count lines from line, as in a
+// unified diff: an empty range is the line before it.
+func hunkRange(line, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", line-1)
+	case 1:
+		return fmt.Sprintf("%d", line)
+	default:
+		return fmt.Sprintf("%d,%d", line, count)
+	}
+}
@@ -0,0 +1,104 @@
+// Package format rewrites source files in the canonical style of the AST
+// printer, for `cubit fmt`.
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/ast/printer"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/corani/cubit/internal/parser"
+)
+
+// Source returns the source of filename in the canonical style. Syntax errors
+// are reported to diags.
+//
+// The formatted source is parsed again, and must declare the same things and
+// keep all the comments of src, or the printer has a bug. A lost comment is
+// reported to diags as well, so it can be found.
+func Source(filename string, src []byte, diags *diag.Bag) ([]byte, error) {
+	unit, comments, err := parse(filename, src, diags)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []byte(printer.SprintComments(unit, comments))
+
+	check := diag.NewBag(diag.Config{})
+
+	again, kept, err := parse(filename, out, check)
+	if err != nil {
+		return nil, fmt.Errorf("formatting %s broke it: %w", filename, errors.Join(check.Err(), err))
+	}
+
+	if lost, ok := lostComment(comments, kept); ok {
+		diags.Errorf(lost.Location, "formatting would lose this comment")
+
+		return nil, fmt.Errorf("failed to format %s", filename)
+	}
+
+	if changes := ast.Diff(unit, again); len(changes) > 0 {
+		return nil, fmt.Errorf("formatting %s changed it: %v", filename, changes[0])
+	}
+
+	return out, nil
+}
+
+// parse parses src, and returns its unit and all its comments.
+func parse(filename string, src []byte, diags *diag.Bag) (*ast.CompilationUnit, []lexer.Comment, error) {
+	scanner, err := lexer.NewScanner(filename, bytes.NewReader(src))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lex := lexer.NewLexer(scanner)
+
+	tokens, err := lex.Tokens()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var comments []lexer.Comment
+
+	for _, tok := range tokens {
+		comments = append(comments, tok.Comments...)
+	}
+
+	comments = append(comments, lex.TrailingComments()...)
+
+	unit, err := parser.NewWithDiagnostics(tokens, diags).Parse()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, err
+	}
+
+	if diags.HasErrors() {
+		return nil, nil, fmt.Errorf("failed to parse %s", filename)
+	}
+
+	return unit, comments, nil
+}
+
+// lostComment returns the first of the comments of the source that isn't one
+// of the comments kept in the formatted source. The printer may move
+// declarations, so the comments are matched by text, not by order.
+func lostComment(comments, kept []lexer.Comment) (lexer.Comment, bool) {
+	left := make(map[string]int, len(kept))
+	for _, c := range kept {
+		left[c.Text]++
+	}
+
+	for _, c := range comments {
+		if left[c.Text] == 0 {
+			return c, true
+		}
+
+		left[c.Text]--
+	}
+
+	return lexer.Comment{}, false
+}
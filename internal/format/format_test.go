@@ -0,0 +1,143 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/diag"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		input    string
+		expected string
+		err      string
+	}{
+		{
+			name: "canonical style",
+			input: `package main
+import "core"
+// doc
+@(link_name="puts",extern)
+puts :: func(s: string)
+main :: func() -> int {
+	x := (1+2)  // three
+	return x
+}`,
+			expected: `package main
+
+import "core"
+
+// doc
+@(extern, link_name="puts")
+puts :: func(s: string)
+
+main :: func() -> int {
+    x := 1 + 2 // three
+    return x
+}
+`,
+		},
+		{
+			name:  "syntax error",
+			input: "package main\nf :: func( {\n",
+			err:   "test.in:2:12: expected RightParen or At or Identifier, got LeftBrace",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			diags := diag.NewBag(diag.Config{})
+
+			out, err := Source("test.in", []byte(tc.input), diags)
+			if tc.err != "" {
+				require.Error(t, err)
+				require.Error(t, diags.Err())
+				require.Contains(t, diags.Err().Error(), tc.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(out))
+
+			// Formatting must be idempotent.
+			again, err := Source("test.in", out, diags)
+			require.NoError(t, err)
+			require.Equal(t, string(out), string(again))
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		old, new string
+		expected string
+	}{
+		{
+			name: "same",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+		},
+		{
+			name: "changed line",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\n",
+			expected: `--- old
++++ new
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+`,
+		},
+		{
+			name: "separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			new:  "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			expected: `--- old
++++ new
+@@ -1,3 +1,4 @@
++0
+ 1
+ 2
+ 3
+@@ -7,4 +8,3 @@
+ 7
+ 8
+ 9
+-10
+`,
+		},
+		{
+			name: "missing newline",
+			old:  "a\nb",
+			new:  "a\nb\n",
+			expected: `--- old
++++ new
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.expected, string(Diff("old", "new", []byte(tc.old), []byte(tc.new))))
+		})
+	}
+}
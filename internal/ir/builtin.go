@@ -2,43 +2,245 @@ package ir
 
 import "github.com/corani/cubit/internal/ast"
 
-func (v *visitor) visitBuiltinCall(c *ast.Call) {
-	switch c.Ident {
-	case "len":
-		v.visitBuiltinLen(c)
-	default:
-		c.Location().Errorf("unknown builtin function: %s", c.Ident)
+// intrinsic lowers calls to a builtin function to IR instead of a call.
+type intrinsic struct {
+	// lower lowers a call and leaves the result in v.lastVal. The type checker
+	// has checked the call against the declaration of the builtin; lower
+	// checks what the declaration can't express, like an argument that can be
+	// an array of any size.
+	lower func(v *visitor, c *ast.Call) error
+	// effects is set if the builtin writes to memory, so a call whose result
+	// is discarded can't be left out.
+	effects bool
+}
+
+// intrinsics maps the name of every builtin function to its lowering. A
+// builtin is declared in the source with @(builtin), and calls to it are
+// lowered by the intrinsic of the same name.
+var intrinsics = map[string]intrinsic{
+	"len":    {lower: (*visitor).visitBuiltinLen},
+	"sizeof": {lower: (*visitor).visitBuiltinSizeof},
+	"min":    {lower: (*visitor).visitBuiltinMin},
+	"max":    {lower: (*visitor).visitBuiltinMax},
+	"copy":   {lower: (*visitor).visitBuiltinCopy, effects: true},
+	"zero":   {lower: (*visitor).visitBuiltinZero, effects: true},
+}
+
+func (v *visitor) visitBuiltinCall(c *ast.Call) error {
+	in, ok := intrinsics[c.Ident]
+	if !ok {
+		return c.Location().Errorf("unknown builtin function: %s", c.Ident)
+	}
+
+	return in.lower(v, c)
+}
+
+// expectArgs checks that a call to a builtin has n arguments.
+func expectArgs(c *ast.Call, n int) error {
+	if len(c.Args) != n {
+		return c.Location().Errorf("builtin '%s' expects %d argument(s), got %d", c.Ident, n, len(c.Args))
 	}
+
+	return nil
 }
 
-func (v *visitor) visitBuiltinLen(c *ast.Call) {
-	if len(c.Args) != 1 {
-		c.Location().Errorf("builtin 'len' expects 1 argument, got %d", len(c.Args))
+// args lowers the arguments of a call to a builtin.
+func (v *visitor) args(c *ast.Call) ([]*Val, error) {
+	var vals []*Val
+
+	for _, arg := range c.Args {
+		v.lastVal = nil
+		if err := arg.Value.AcceptE(v); err != nil {
+			return nil, err
+		}
 
-		return
+		vals = append(vals, v.lastVal)
+	}
+
+	return vals, nil
+}
+
+func (v *visitor) visitBuiltinLen(c *ast.Call) error {
+	if err := expectArgs(c, 1); err != nil {
+		return err
 	}
 
 	arg := c.Args[0]
 	if arg.Type.Kind != ast.TypeArray {
-		c.Location().Errorf("builtin 'len' expects an array, got %s", arg.Type)
-
-		return
+		return c.Location().Errorf("builtin 'len' expects an array, got %s", arg.Type)
 	}
 
 	size := arg.Type.Size
 	if size.Kind != ast.SizeLiteral {
-		c.Location().Errorf("array size must be a literal, got %s", size)
-
-		return
+		return c.Location().Errorf("array size must be a literal, got %s", size)
 	}
 
 	loc := c.Location()
 	word := NewAbiTyBase(BaseWord)
 
-	v.lastType = ast.NewType(ast.TypeInt, loc)
 	v.lastVal = NewValIdent(loc, v.nextIdent("len"), word)
 
 	v.appendInstruction(NewBinop(loc, BinOpAdd, v.lastVal,
 		NewValInteger(loc, 0, word),
 		NewValInteger(loc, int64(size.Value), word)))
+
+	return nil
+}
+
+// visitBuiltinSizeof lowers sizeof(x) to the size of the type of x in bytes.
+// Like in C, x isn't evaluated.
+func (v *visitor) visitBuiltinSizeof(c *ast.Call) error {
+	if err := expectArgs(c, 1); err != nil {
+		return err
+	}
+
+	size, err := v.sizeOf(c.Args[0].Type)
+	if err != nil {
+		return c.Location().Errorf("%v", err)
+	}
+
+	v.lastVal = NewValInteger(c.Location(), size, NewAbiTyBase(BaseWord))
+
+	return nil
+}
+
+func (v *visitor) visitBuiltinMin(c *ast.Call) error {
+	return v.visitBuiltinSelect(c, BinOpLt)
+}
+
+func (v *visitor) visitBuiltinMax(c *ast.Call) error {
+	return v.visitBuiltinSelect(c, BinOpGt)
+}
+
+// visitBuiltinSelect lowers min(a, b) and max(a, b), which pick a if op holds
+// for a and b, and b otherwise:
+//
+//		%cmp = <op> %a, %b
+//		jnz %cmp, @pick, @end
+//	@pick:
+//		jmp @end
+//	@end:
+//		%result = phi @block %b, @pick %a
+func (v *visitor) visitBuiltinSelect(c *ast.Call, op BinOpKind) error {
+	if err := expectArgs(c, 2); err != nil {
+		return err
+	}
+
+	for _, arg := range c.Args {
+		if arg.Type.Kind != ast.TypeInt {
+			return c.Location().Errorf("builtin '%s' expects integers, got %s", c.Ident, arg.Type)
+		}
+	}
+
+	vals, err := v.args(c)
+	if err != nil {
+		return err
+	}
+
+	loc := c.Location()
+	word := NewAbiTyBase(BaseWord)
+	pickLabel := v.nextLabel("pick")
+	endLabel := v.nextLabel("end")
+
+	cmp := NewValIdent(loc, v.nextIdent("tmp"), word)
+	v.appendInstruction(NewBinop(loc, op, cmp, vals[0], vals[1]))
+
+	block := v.block.Label
+	v.appendInstruction(NewJnz(loc, cmp, pickLabel, endLabel))
+
+	v.startBlock(loc, pickLabel)
+	v.startBlock(loc, endLabel)
+
+	v.lastVal = NewValIdent(loc, v.nextIdent("tmp"), word)
+	v.appendInstruction(NewPhi(loc, v.lastVal, NewPhiArg(block, vals[1]), NewPhiArg(pickLabel, vals[0])))
+
+	return nil
+}
+
+// visitBuiltinCopy lowers copy(dst, src, count), which copies count elements
+// from src to dst, one element at a time.
+func (v *visitor) visitBuiltinCopy(c *ast.Call) error {
+	if err := expectArgs(c, 3); err != nil {
+		return err
+	}
+
+	elem, err := pointee(c, c.Args[0].Type, c.Args[1].Type)
+	if err != nil {
+		return err
+	}
+
+	vals, err := v.args(c)
+	if err != nil {
+		return err
+	}
+
+	loc := c.Location()
+	ptr := v.ptr
+
+	size, err := v.sizeOf(elem)
+	if err != nil {
+		return loc.Errorf("%v", err)
+	}
+
+	v.byteLoop(loc, "cp", v.offset(loc, vals[2], size), size, func(i *Val) {
+		src := NewValIdent(loc, v.nextIdent("cp_src"), ptr)
+		v.appendInstruction(NewBinop(loc, BinOpAdd, src, vals[1], i))
+
+		dst := NewValIdent(loc, v.nextIdent("cp_dst"), ptr)
+		v.appendInstruction(NewBinop(loc, BinOpAdd, dst, vals[0], i))
+
+		v.store(loc, dst, v.load(loc, src, elem), elem)
+	})
+
+	v.lastVal = nil
+
+	return nil
+}
+
+// visitBuiltinZero lowers zero(dst, count), which sets count elements at dst
+// to zero.
+func (v *visitor) visitBuiltinZero(c *ast.Call) error {
+	if err := expectArgs(c, 2); err != nil {
+		return err
+	}
+
+	elem, err := pointee(c, c.Args[0].Type)
+	if err != nil {
+		return err
+	}
+
+	vals, err := v.args(c)
+	if err != nil {
+		return err
+	}
+
+	size, err := v.sizeOf(elem)
+	if err != nil {
+		return c.Location().Errorf("%v", err)
+	}
+
+	v.lastVal = nil
+
+	return v.zeroInitialize(c.Location(), vals[0], v.offset(c.Location(), vals[1], size), elem)
+}
+
+// pointee returns the type the pointer arguments of a call to a builtin point
+// to, which must be the same for all of them.
+func pointee(c *ast.Call, types ...*ast.Type) (*ast.Type, error) {
+	var elem *ast.Type
+
+	for _, ty := range types {
+		if ty.Kind != ast.TypePointer {
+			return nil, c.Location().Errorf("builtin '%s' expects a pointer, got %s", c.Ident, ty)
+		}
+
+		if elem != nil && elem.String() != ty.Elem.String() {
+			return nil, c.Location().Errorf("builtin '%s' expects pointers to the same type, got %s and %s",
+				c.Ident, elem, ty.Elem)
+		}
+
+		elem = ty.Elem
+	}
+
+	return elem, nil
 }
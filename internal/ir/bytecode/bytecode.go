@@ -0,0 +1,390 @@
+// Package bytecode compiles a lowered compilation unit to a compact bytecode,
+// and executes it in a virtual machine, so a program can be shipped as a file
+// and run from a Go application without an assembler, a C compiler or the
+// compiler itself.
+//
+// The machine has a register file per call: parameters are in the first
+// registers, and every temporary of the IR has a register of its own. Phis
+// are resolved when compiling: every predecessor copies its argument into a
+// register the phi reads when its block is entered.
+package bytecode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// magic starts every encoded program.
+const magic = "CUBC"
+
+// version is written after the magic. It changes when the encoding does.
+const version = 1
+
+// Op is the operation of an instruction.
+type Op uint8
+
+const (
+	OpConst Op = iota // A = B
+	OpAddr            // A = address of symbol B
+	OpMove            // A = register B
+	OpAlloc           // A = a stack object of register B bytes
+	OpLoad            // A = Ty at register B, as a value of type C
+	OpStore           // Ty at register A = register B
+	OpExt             // A = register B of type C, as a value of type Ty
+	OpCall            // A = call register B with Args, A is -1 without a result
+	OpJmp             // continue at A
+	OpJnz             // continue at B if register A isn't zero
+	OpRet             // return register A, or nothing if A is -1
+	OpHlt             // stop the program
+
+	// The binary operations: A = register B op register C, in the width of
+	// Ty. The result of a comparison is 0 or 1.
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpUDiv
+	OpMod
+	OpUMod
+	OpAnd
+	OpOr
+	OpShl
+	OpShr
+	OpEq
+	OpNe
+	OpLt
+	OpULt
+	OpLe
+	OpULe
+	OpGt
+	OpUGt
+	OpGe
+	OpUGe
+
+	opCount
+)
+
+var opNames = [...]string{
+	"const", "addr", "move", "alloc", "load", "store", "ext", "call", "jmp", "jnz", "ret", "hlt",
+	"add", "sub", "mul", "div", "udiv", "mod", "umod", "and", "or", "shl", "shr",
+	"eq", "ne", "lt", "ult", "le", "ule", "gt", "ugt", "ge", "uge",
+}
+
+func (op Op) String() string {
+	if op < opCount {
+		return opNames[op]
+	}
+
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// Ty is the type of a value in a register or in memory. Registers hold words
+// sign-extended to 64 bits.
+type Ty uint8
+
+const (
+	TyW  Ty = iota // 32-bit word
+	TyL            // 64-bit long
+	TySB           // signed byte, in memory
+	TyUB           // unsigned byte, in memory
+	TySH           // signed half word, in memory
+	TyUH           // unsigned half word, in memory
+)
+
+// size returns the number of bytes a value of the type takes in memory.
+func (ty Ty) size() int {
+	switch ty {
+	case TyL:
+		return 8
+	case TySB, TyUB:
+		return 1
+	case TySH, TyUH:
+		return 2
+	default:
+		return 4
+	}
+}
+
+// Instr is an instruction. The meaning of the operands depends on the Op.
+type Instr struct {
+	Op      Op
+	Ty      Ty
+	A, B, C int64
+	Args    []int64 // the registers of the arguments of a call
+}
+
+// SymbolKind is the kind of a symbol.
+type SymbolKind uint8
+
+const (
+	SymData   SymbolKind = iota // data, with its contents
+	SymFunc                     // a function, with its code
+	SymExtern                   // a function the program calls, but doesn't define
+)
+
+// Symbol is a named object of a program. Instructions and relocations refer
+// to symbols by their index in Program.Symbols.
+type Symbol struct {
+	Name string
+	Kind SymbolKind
+
+	// Data and Relocs are the contents of data.
+	Data   []byte
+	Relocs []Reloc
+
+	// Params, Regs and Code are the parameters, the number of registers and
+	// the instructions of a function.
+	Params int
+	Regs   int
+	Code   []Instr
+}
+
+// Reloc stores the 64-bit address of a symbol plus an addend at an offset in
+// data, once the data is placed in memory.
+type Reloc struct {
+	Offset int64
+	Symbol int64
+	Addend int64
+}
+
+// Program is a compiled compilation unit.
+type Program struct {
+	Symbols []Symbol
+}
+
+// Lookup returns the index of the symbol with the given name.
+func (p *Program) Lookup(name string) (int, bool) {
+	for i, sym := range p.Symbols {
+		if sym.Name == name {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// operands returns the number of operands of an instruction with op, not
+// counting the arguments of a call.
+func operands(op Op) int {
+	switch op {
+	case OpJmp, OpRet, OpHlt:
+		return 1
+	case OpConst, OpAddr, OpMove, OpAlloc, OpStore, OpJnz:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// Encode writes the program to w. Integers are written as variable-length
+// values, so most instructions take a few bytes.
+func (p *Program) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, 0, binary.MaxVarintLen64)
+
+	putUint := func(n uint64) {
+		_, _ = bw.Write(binary.AppendUvarint(buf, n))
+	}
+
+	putInt := func(n int64) {
+		_, _ = bw.Write(binary.AppendVarint(buf, n))
+	}
+
+	putBytes := func(b []byte) {
+		putUint(uint64(len(b)))
+		_, _ = bw.Write(b)
+	}
+
+	_, _ = bw.WriteString(magic)
+	putUint(version)
+	putUint(uint64(len(p.Symbols)))
+
+	for _, sym := range p.Symbols {
+		putBytes([]byte(sym.Name))
+		_ = bw.WriteByte(byte(sym.Kind))
+
+		switch sym.Kind {
+		case SymData:
+			putBytes(sym.Data)
+			putUint(uint64(len(sym.Relocs)))
+
+			for _, r := range sym.Relocs {
+				putInt(r.Offset)
+				putInt(r.Symbol)
+				putInt(r.Addend)
+			}
+		case SymFunc:
+			putUint(uint64(sym.Params))
+			putUint(uint64(sym.Regs))
+			putUint(uint64(len(sym.Code)))
+
+			for _, instr := range sym.Code {
+				_ = bw.WriteByte(byte(instr.Op))
+				_ = bw.WriteByte(byte(instr.Ty))
+
+				for _, operand := range []int64{instr.A, instr.B, instr.C}[:operands(instr.Op)] {
+					putInt(operand)
+				}
+
+				if instr.Op == OpCall {
+					putUint(uint64(len(instr.Args)))
+
+					for _, arg := range instr.Args {
+						putInt(arg)
+					}
+				}
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// decoder reads an encoded program. The first error stops the decoding, all
+// reads after it return zero values.
+type decoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		d.err = err
+	}
+}
+
+func (d *decoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	n, err := binary.ReadUvarint(d.r)
+	d.fail(err)
+
+	return n
+}
+
+func (d *decoder) int() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	n, err := binary.ReadVarint(d.r)
+	d.fail(err)
+
+	return n
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+
+	b, err := d.r.ReadByte()
+	d.fail(err)
+
+	return b
+}
+
+// count reads the length of a list. Lists are appended to while they're
+// read, so a corrupt length doesn't allocate more than the input holds.
+func (d *decoder) count() int {
+	n := d.uint()
+	if n > math.MaxInt32 {
+		d.fail(fmt.Errorf("list of %d elements is too long", n))
+
+		return 0
+	}
+
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+
+	b, err := io.ReadAll(io.LimitReader(d.r, int64(n)))
+	if err == nil && len(b) < n {
+		err = io.ErrUnexpectedEOF
+	}
+
+	d.fail(err)
+
+	return b
+}
+
+// Decode reads a program written by Encode.
+func Decode(r io.Reader) (*Program, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(d.r, header); err != nil || string(header) != magic {
+		return nil, fmt.Errorf("failed to decode bytecode: not a bytecode file")
+	}
+
+	if v := d.uint(); d.err == nil && v != version {
+		return nil, fmt.Errorf("failed to decode bytecode: version %d, expected %d", v, version)
+	}
+
+	p := &Program{}
+
+	for i, n := 0, d.count(); i < n && d.err == nil; i++ {
+		sym := Symbol{Name: string(d.bytes()), Kind: SymbolKind(d.byte())}
+
+		switch sym.Kind {
+		case SymData:
+			sym.Data = d.bytes()
+
+			for j, n := 0, d.count(); j < n && d.err == nil; j++ {
+				sym.Relocs = append(sym.Relocs, Reloc{Offset: d.int(), Symbol: d.int(), Addend: d.int()})
+			}
+		case SymFunc:
+			sym.Params = d.count()
+			sym.Regs = d.count()
+
+			for j, n := 0, d.count(); j < n && d.err == nil; j++ {
+				sym.Code = append(sym.Code, d.instr())
+			}
+		case SymExtern:
+		default:
+			d.fail(fmt.Errorf("unknown kind %d of symbol %s", sym.Kind, sym.Name))
+		}
+
+		p.Symbols = append(p.Symbols, sym)
+	}
+
+	if d.err != nil {
+		return nil, fmt.Errorf("failed to decode bytecode: %w", d.err)
+	}
+
+	return p, nil
+}
+
+func (d *decoder) instr() Instr {
+	instr := Instr{Op: Op(d.byte()), Ty: Ty(d.byte())}
+
+	if instr.Op >= opCount {
+		d.fail(fmt.Errorf("unknown operation %d", instr.Op))
+
+		return instr
+	}
+
+	for _, operand := range []*int64{&instr.A, &instr.B, &instr.C}[:operands(instr.Op)] {
+		*operand = d.int()
+	}
+
+	if instr.Op == OpCall {
+		for i, n := 0, d.count(); i < n && d.err == nil; i++ {
+			instr.Args = append(instr.Args, d.int())
+		}
+	}
+
+	return instr
+}
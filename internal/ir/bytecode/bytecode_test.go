@@ -0,0 +1,212 @@
+package bytecode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/corani/cubit/internal/ir/passes"
+	"github.com/corani/cubit/internal/testutil/irtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVM(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		src      string
+		expected string
+		exit     int
+	}{
+		{
+			name: "recursion",
+			src: `
+fib :: func(n: int) -> int {
+    if n <= 1 {
+        return n
+    }
+
+    return fib(n - 1) + fib(n - 2)
+}
+
+main :: func() -> int {
+    for n := 1; n <= 5; n = n + 1 {
+        printf("fib(%d) = %2d\n", n, fib(n))
+    }
+
+    return fib(10)
+}`,
+			expected: "fib(1) =  1\nfib(2) =  1\nfib(3) =  2\nfib(4) =  3\nfib(5) =  5\n",
+			exit:     55,
+		},
+		{
+			name: "phis on both edges",
+			src: `
+main :: func() -> int {
+    a := 0
+    b := 0
+
+    for i := 0; i < 10; i = i + 1 {
+        if i % 3 == 0 || i % 5 == 0 {
+            a = a + i
+        } else {
+            b = b + i
+        }
+    }
+
+    printf("%d %d\n", a, b)
+
+    return 0
+}`,
+			expected: "23 22\n",
+		},
+		{
+			name: "arrays and pointers",
+			src: `
+@(extern)
+calloc :: func(count: int, size: int) -> ^int
+
+main :: func() -> int {
+    xs := [4]int{}
+    for i := 0; i < 4; i = i + 1 {
+        xs[i] = i * i - 5
+    }
+
+    p := calloc(2, 4)
+    (p+1)^ = xs[0] * xs[3]
+
+    printf("%d %d\n", xs[3], (p+1)^)
+
+    return 0
+}`,
+			expected: "4 -20\n",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// The program behaves the same before and after optimization, and
+			// after a round trip through the encoding.
+			for _, optimize := range []bool{false, true} {
+				unit := irtest.LowerWithPrintf(t, tc.src)
+				if optimize {
+					require.NoError(t, passes.Run(unit, passes.Default(passes.Options{})...))
+				}
+
+				prog, err := Compile(unit)
+				require.NoError(t, err)
+
+				var encoded bytes.Buffer
+				require.NoError(t, prog.Encode(&encoded))
+
+				decoded, err := Decode(&encoded)
+				require.NoError(t, err)
+				require.Equal(t, prog, decoded)
+
+				vm, err := New(decoded)
+				require.NoError(t, err)
+
+				var stdout bytes.Buffer
+
+				status, err := vm.WithStdout(&stdout).Run()
+				require.NoError(t, err)
+				require.Equal(t, tc.exit, status)
+				require.Equal(t, tc.expected, stdout.String())
+			}
+		})
+	}
+}
+
+func TestVM_Errors(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{
+			name: "division by zero",
+			src: `
+div :: func(x: int, y: int) -> int {
+    return x / y
+}
+
+main :: func() -> int {
+    return div(1, 0)
+}`,
+			expected: "div+0: division by zero",
+		},
+		{
+			name: "endless loop",
+			src: `
+main :: func() -> int {
+    for i := 0; i >= 0; i = i * 1 {
+    }
+
+    return 0
+}`,
+			expected: "more than 1000 instructions executed",
+		},
+		{
+			name: "undefined function",
+			src: `
+@(extern)
+abs :: func(x: int) -> int
+
+main :: func() -> int {
+    return abs(-3)
+}`,
+			expected: "function abs isn't defined",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			prog, err := Compile(irtest.LowerWithPrintf(t, tc.src))
+			require.NoError(t, err)
+
+			vm, err := New(prog)
+			require.NoError(t, err)
+
+			_, err = vm.WithMaxSteps(1000).Run()
+			require.EqualError(t, err, tc.expected)
+		})
+	}
+}
+
+func TestDecode_Errors(t *testing.T) {
+	t.Parallel()
+
+	prog, err := Compile(irtest.LowerWithPrintf(t, "main :: func() -> int {\n    return 0\n}"))
+	require.NoError(t, err)
+
+	var encoded bytes.Buffer
+	require.NoError(t, prog.Encode(&encoded))
+
+	_, err = Decode(strings.NewReader("ELF"))
+	require.EqualError(t, err, "failed to decode bytecode: not a bytecode file")
+
+	_, err = Decode(bytes.NewReader(append([]byte(magic), version+1)))
+	require.EqualError(t, err, "failed to decode bytecode: version 2, expected 1")
+
+	_, err = Decode(bytes.NewReader(encoded.Bytes()[:encoded.Len()-1]))
+	require.EqualError(t, err, "failed to decode bytecode: unexpected EOF")
+}
+
+func TestNew_Verify(t *testing.T) {
+	t.Parallel()
+
+	prog := &Program{Symbols: []Symbol{{
+		Name: "main", Kind: SymFunc, Regs: 1,
+		Code: []Instr{{Op: OpConst, A: 0, B: 1}, {Op: OpRet, A: 1}},
+	}}}
+
+	_, err := New(prog)
+	require.EqualError(t, err, "invalid bytecode: main+1: ret: register 1 out of range")
+}
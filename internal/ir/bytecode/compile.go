@@ -0,0 +1,506 @@
+package bytecode
+
+import (
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// binops are the operations of the IR's binary operations.
+var binops = map[ir.BinOpKind]Op{
+	ir.BinOpAdd: OpAdd, ir.BinOpSub: OpSub, ir.BinOpMul: OpMul,
+	ir.BinOpDiv: OpDiv, ir.BinOpUDiv: OpUDiv, ir.BinOpMod: OpMod, ir.BinOpUMod: OpUMod,
+	ir.BinOpAnd: OpAnd, ir.BinOpOr: OpOr, ir.BinOpShl: OpShl, ir.BinOpShr: OpShr,
+	ir.BinOpEq: OpEq, ir.BinOpNe: OpNe,
+	ir.BinOpLt: OpLt, ir.BinOpULt: OpULt, ir.BinOpLe: OpLe, ir.BinOpULe: OpULe,
+	ir.BinOpGt: OpGt, ir.BinOpUGt: OpUGt, ir.BinOpGe: OpGe, ir.BinOpUGe: OpUGe,
+}
+
+// Compile compiles unit to bytecode. The bytecode has integer registers only,
+// so floating point values and thread-local data are reported as errors.
+func Compile(unit *ir.CompilationUnit) (*Program, error) {
+	c := &compiler{prog: &Program{}, symbols: make(map[ir.Ident]int)}
+
+	for _, dd := range unit.DataDefs {
+		c.declare(dd.Ident, SymData)
+	}
+
+	for _, fd := range unit.FuncDefs {
+		kind := SymFunc
+		if fd.Blocks == nil {
+			kind = SymExtern
+		}
+
+		// Calls use the link name of a function, if it has one.
+		name := fd.Ident
+		if fd.LinkName != "" {
+			name = fd.LinkName
+		}
+
+		c.symbols[fd.Ident] = c.declare(name, kind)
+	}
+
+	for _, dd := range unit.DataDefs {
+		if err := c.data(dd); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range unit.FuncDefs {
+		if unit.FuncDefs[i].Blocks == nil {
+			continue
+		}
+
+		if err := c.function(&unit.FuncDefs[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.prog, nil
+}
+
+type compiler struct {
+	prog    *Program
+	symbols map[ir.Ident]int // symbol -> index in prog.Symbols
+}
+
+// declare adds a symbol to the program, or returns the index of the symbol
+// with the same name.
+func (c *compiler) declare(name ir.Ident, kind SymbolKind) int {
+	if i, ok := c.symbols[name]; ok {
+		return i
+	}
+
+	c.symbols[name] = len(c.prog.Symbols)
+	c.prog.Symbols = append(c.prog.Symbols, Symbol{Name: string(name), Kind: kind})
+
+	return c.symbols[name]
+}
+
+// symbol returns the index of a symbol, declaring an extern function for a
+// symbol the unit doesn't define, like the functions of the C library the
+// unit calls without declaring them.
+func (c *compiler) symbol(name ir.Ident) int {
+	return c.declare(name, SymExtern)
+}
+
+func (c *compiler) data(dd ir.DataDef) error {
+	sym := &c.prog.Symbols[c.symbols[dd.Ident]]
+	sym.Data = make([]byte, 0, dd.Size())
+
+	for _, init := range dd.Initializer {
+		if init.Type == ir.DataInitZero {
+			sym.Data = append(sym.Data, make([]byte, init.Size)...)
+
+			continue
+		}
+
+		if init.ExtTy == ir.ExtSingle || init.ExtTy == ir.ExtDouble {
+			return diag.Errorf(init.Loc, "floating point data isn't supported by the bytecode")
+		}
+
+		for _, item := range init.Items {
+			var val int64
+
+			switch {
+			case item.Type == ir.DataItemString:
+				sym.Data = append(sym.Data, ir.Unescape(item.StringVal)...)
+
+				continue
+			case item.Type == ir.DataItemSymbol:
+				sym.Relocs = append(sym.Relocs, Reloc{
+					Offset: int64(len(sym.Data)), Symbol: int64(c.symbol(item.Ident)), Addend: int64(item.Offset),
+				})
+			case item.Const.Type == ir.ConstIdent:
+				sym.Relocs = append(sym.Relocs, Reloc{
+					Offset: int64(len(sym.Data)), Symbol: int64(c.symbol(item.Const.Ident)),
+				})
+			default:
+				val = item.Const.I64
+			}
+
+			for i := range init.ExtTy.Size() {
+				sym.Data = append(sym.Data, byte(val>>(8*i)))
+			}
+		}
+	}
+
+	return nil
+}
+
+// function is the function being compiled.
+type function struct {
+	*compiler
+
+	fd     *ir.FuncDef
+	sym    *Symbol
+	regs   map[ir.Ident]int64 // temporary -> register
+	phis   map[ir.Ident]int64 // phi -> the register its predecessors write
+	next   int64              // the next free register
+	labels map[string]int     // block label -> index of its first instruction
+	jumps  []jump
+}
+
+// jump is an instruction that continues at a block, whose index is known
+// once all blocks are compiled.
+type jump struct {
+	instr int
+	label string
+}
+
+func (c *compiler) function(fd *ir.FuncDef) error {
+	fn := &function{
+		compiler: c,
+		fd:       fd,
+		sym:      &c.prog.Symbols[c.symbols[fd.Ident]],
+		regs:     make(map[ir.Ident]int64),
+		phis:     make(map[ir.Ident]int64),
+		labels:   make(map[string]int),
+	}
+
+	for _, param := range fd.Params {
+		if param.Type != ir.ParamRegular {
+			return diag.Errorf(param.Loc, "%s parameters aren't supported by the bytecode", param.Type)
+		}
+
+		fn.reg(param.Ident)
+	}
+
+	fn.sym.Params = len(fd.Params)
+
+	for _, block := range fd.Blocks {
+		fn.labels[block.Label] = len(fn.sym.Code)
+
+		for _, instr := range block.Instructions {
+			if err := fn.instr(&block, instr); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, j := range fn.jumps {
+		target, ok := fn.labels[j.label]
+		if !ok {
+			return diag.Errorf(fd.Loc, "jump to unknown block @%s in $%s", j.label, fd.Ident)
+		}
+
+		if instr := &fn.sym.Code[j.instr]; instr.Op == OpJmp {
+			instr.A = int64(target)
+		} else {
+			instr.B = int64(target)
+		}
+	}
+
+	fn.sym.Regs = int(fn.next)
+
+	return nil
+}
+
+// reg returns the register of a temporary.
+func (fn *function) reg(ident ir.Ident) int64 {
+	r, ok := fn.regs[ident]
+	if !ok {
+		r = fn.temp()
+		fn.regs[ident] = r
+	}
+
+	return r
+}
+
+// incoming returns the register the predecessors of a phi write.
+func (fn *function) incoming(phi ir.Ident) int64 {
+	r, ok := fn.phis[phi]
+	if !ok {
+		r = fn.temp()
+		fn.phis[phi] = r
+	}
+
+	return r
+}
+
+// temp returns a new register, e.g. for a constant used by one instruction.
+func (fn *function) temp() int64 {
+	fn.next++
+
+	return fn.next - 1
+}
+
+func (fn *function) emit(instr Instr) {
+	fn.sym.Code = append(fn.sym.Code, instr)
+}
+
+// jump emits a jump to the block labeled.
+func (fn *function) jump(label string) {
+	fn.jumps = append(fn.jumps, jump{instr: len(fn.sym.Code), label: label})
+	fn.emit(Instr{Op: OpJmp})
+}
+
+// ty returns the type of a value in a register, or in memory.
+func ty(loc lexer.Location, t ir.AbiTy) (Ty, error) {
+	switch {
+	case t.Type == ir.AbiTySubW:
+		return map[ir.SubWTy]Ty{ir.SubWSB: TySB, ir.SubWUB: TyUB, ir.SubWSH: TySH, ir.SubWUH: TyUH}[t.SubWTy], nil
+	case t.IsFloat():
+		return 0, diag.Errorf(loc, "floating point values aren't supported by the bytecode")
+	case t.Type == ir.AbiTyBase && t.BaseTy == ir.BaseLong:
+		return TyL, nil
+	default:
+		return TyW, nil
+	}
+}
+
+// value returns the register that holds val, loading constants and the
+// addresses of symbols into a register of their own.
+func (fn *function) value(val *ir.Val) (int64, error) {
+	if val.Type == ir.ValIdent {
+		// A block can come before the block that defines a value it uses.
+		return fn.reg(val.Ident), nil
+	}
+
+	if val.DynConst.Type != ir.DynConstConst {
+		return 0, diag.Errorf(val.Loc, "thread-local $%s isn't supported by the bytecode", val.DynConst.Ident)
+	}
+
+	r := fn.temp()
+
+	switch c := val.DynConst.Const; c.Type {
+	case ir.ConstInteger:
+		imm := c.I64
+		if t, _ := ty(val.Loc, val.AbiTy); t != TyL {
+			imm = int64(int32(imm))
+		}
+
+		fn.emit(Instr{Op: OpConst, A: r, B: imm})
+	case ir.ConstIdent:
+		fn.emit(Instr{Op: OpAddr, A: r, B: int64(fn.symbol(c.Ident))})
+	default:
+		return 0, diag.Errorf(val.Loc, "floating point values aren't supported by the bytecode")
+	}
+
+	return r, nil
+}
+
+func (fn *function) instr(block *ir.Block, instr ir.Instruction) error {
+	switch instr := instr.(type) {
+	case *ir.Phi:
+		// The predecessors wrote the argument to the incoming register.
+		fn.emit(Instr{Op: OpMove, A: fn.reg(instr.Ret.Ident), B: fn.incoming(instr.Ret.Ident)})
+	case *ir.Jmp:
+		if err := fn.moves(block, instr.Label); err != nil {
+			return err
+		}
+
+		fn.jump(instr.Label)
+	case *ir.Jnz:
+		cond, err := fn.value(instr.Cond)
+		if err != nil {
+			return err
+		}
+
+		// The moves of the edges differ, so the true edge gets a copy of
+		// its own after the false edge.
+		branch := len(fn.sym.Code)
+		fn.emit(Instr{Op: OpJnz, A: cond})
+
+		if err := fn.moves(block, instr.False); err != nil {
+			return err
+		}
+
+		fn.jump(instr.False)
+		fn.sym.Code[branch].B = int64(len(fn.sym.Code))
+
+		if err := fn.moves(block, instr.True); err != nil {
+			return err
+		}
+
+		fn.jump(instr.True)
+	case *ir.Ret:
+		if instr.Val == nil {
+			fn.emit(Instr{Op: OpRet, A: -1})
+
+			return nil
+		}
+
+		r, err := fn.value(instr.Val)
+		if err != nil {
+			return err
+		}
+
+		fn.emit(Instr{Op: OpRet, A: r})
+	case *ir.Hlt:
+		fn.emit(Instr{Op: OpHlt})
+	case *ir.Binop:
+		return fn.binop(instr)
+	case *ir.Alloc:
+		size, err := fn.value(instr.Size)
+		if err != nil {
+			return err
+		}
+
+		fn.emit(Instr{Op: OpAlloc, A: fn.reg(instr.Ret.Ident), B: size})
+	case *ir.Load:
+		addr, err := fn.value(instr.Addr)
+		if err != nil {
+			return err
+		}
+
+		mem, err := ty(instr.Loc, instr.Ty)
+		if err != nil {
+			return err
+		}
+
+		ret, err := ty(instr.Loc, instr.Ret.AbiTy)
+		if err != nil {
+			return err
+		}
+
+		fn.emit(Instr{Op: OpLoad, Ty: mem, A: fn.reg(instr.Ret.Ident), B: addr, C: int64(ret)})
+	case *ir.Store:
+		addr, err := fn.value(instr.Addr)
+		if err != nil {
+			return err
+		}
+
+		val, err := fn.value(instr.Val)
+		if err != nil {
+			return err
+		}
+
+		mem, err := ty(instr.Loc, instr.Ty)
+		if err != nil {
+			return err
+		}
+
+		fn.emit(Instr{Op: OpStore, Ty: mem, A: addr, B: val})
+	case *ir.Convert:
+		val, err := fn.value(instr.Val)
+		if err != nil {
+			return err
+		}
+
+		from, err := ty(instr.Loc, instr.Val.AbiTy)
+		if err != nil {
+			return err
+		}
+
+		to, err := ty(instr.Loc, instr.Ret.AbiTy)
+		if err != nil {
+			return err
+		}
+
+		fn.emit(Instr{Op: OpExt, Ty: to, A: fn.reg(instr.Ret.Ident), B: val, C: int64(from)})
+	case *ir.Call:
+		return fn.call(instr)
+	default:
+		return diag.Errorf(instr.Location(), "instruction %T isn't supported by the bytecode", instr)
+	}
+
+	return nil
+}
+
+// moves writes the arguments the phis of the block labeled to receive from
+// block to their incoming registers.
+func (fn *function) moves(block *ir.Block, to string) error {
+	for _, b := range fn.fd.Blocks {
+		if b.Label != to {
+			continue
+		}
+
+		for _, instr := range b.Instructions {
+			phi, ok := instr.(*ir.Phi)
+			if !ok {
+				break
+			}
+
+			for _, arg := range phi.Args {
+				if arg.Label != block.Label {
+					continue
+				}
+
+				r, err := fn.value(arg.Val)
+				if err != nil {
+					return err
+				}
+
+				fn.emit(Instr{Op: OpMove, A: fn.incoming(phi.Ret.Ident), B: r})
+			}
+		}
+	}
+
+	return nil
+}
+
+func (fn *function) binop(b *ir.Binop) error {
+	op, ok := binops[b.Op]
+	if !ok {
+		return diag.Errorf(b.Loc, "unknown binop: %s", b.Op)
+	}
+
+	lhs, err := fn.value(b.Lhs)
+	if err != nil {
+		return err
+	}
+
+	rhs, err := fn.value(b.Rhs)
+	if err != nil {
+		return err
+	}
+
+	// Comparisons operate on the type of their operands, all other operations
+	// on the type of their result.
+	width := b.Ret.AbiTy
+	if b.Op.IsComparison() {
+		width = b.Lhs.AbiTy
+	}
+
+	t, err := ty(b.Loc, width)
+	if err != nil {
+		return err
+	}
+
+	fn.emit(Instr{Op: op, Ty: t, A: fn.reg(b.Ret.Ident), B: lhs, C: rhs})
+
+	return nil
+}
+
+func (fn *function) call(c *ir.Call) error {
+	callee, err := fn.value(c.Val)
+	if err != nil {
+		return err
+	}
+
+	instr := Instr{Op: OpCall, A: -1, B: callee}
+
+	for _, arg := range c.Args {
+		switch arg.Type {
+		case ir.ArgRegular:
+			r, err := fn.value(arg.Val)
+			if err != nil {
+				return err
+			}
+
+			instr.Args = append(instr.Args, r)
+		case ir.ArgVariadic:
+			// The marker of the variadic arguments of an extern function;
+			// arguments are passed the same way on both sides of it.
+		default:
+			return diag.Errorf(arg.Loc, "%s arguments aren't supported by the bytecode", arg.Type)
+		}
+	}
+
+	if c.LHS != nil {
+		t := TyW
+
+		if c.RetTy != nil {
+			if t, err = ty(c.Loc, *c.RetTy); err != nil {
+				return err
+			}
+		}
+
+		instr.A, instr.Ty = fn.reg(*c.LHS), t
+	}
+
+	fn.emit(instr)
+
+	return nil
+}
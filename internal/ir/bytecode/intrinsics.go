@@ -0,0 +1,97 @@
+package bytecode
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/corani/cubit/internal/ir/interp"
+)
+
+// intrinsics are the functions of the C library a machine implements by
+// default, like the IR interpreter does.
+var intrinsics = map[string]Intrinsic{
+	"printf":  printf,
+	"puts":    puts,
+	"putchar": putchar,
+	"calloc":  calloc,
+	"malloc":  malloc,
+	"free":    free,
+	"exit":    exit,
+}
+
+func printf(vm *VM, args []int64) (int64, error) {
+	if len(args) == 0 {
+		return 0, fmt.Errorf("printf: missing format")
+	}
+
+	format, err := vm.String(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("printf: %w", err)
+	}
+
+	s, err := interp.Format(format, args[1:], vm.String)
+	if err != nil {
+		return 0, fmt.Errorf("printf: %w", err)
+	}
+
+	n, err := io.WriteString(vm.stdout, s)
+
+	return int64(n), err
+}
+
+func puts(vm *VM, args []int64) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("puts: expected 1 argument, got %d", len(args))
+	}
+
+	s, err := vm.String(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("puts: %w", err)
+	}
+
+	_, err = io.WriteString(vm.stdout, s+"\n")
+
+	return 0, err
+}
+
+func putchar(vm *VM, args []int64) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("putchar: expected 1 argument, got %d", len(args))
+	}
+
+	_, err := vm.stdout.Write([]byte{byte(args[0])})
+
+	return int64(byte(args[0])), err
+}
+
+func calloc(vm *VM, args []int64) (int64, error) {
+	if len(args) != 2 {
+		return 0, fmt.Errorf("calloc: expected 2 arguments, got %d", len(args))
+	}
+
+	return vm.Alloc(args[0] * args[1])
+}
+
+func malloc(vm *VM, args []int64) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("malloc: expected 1 argument, got %d", len(args))
+	}
+
+	return vm.Alloc(args[0])
+}
+
+func free(vm *VM, args []int64) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("free: expected 1 argument, got %d", len(args))
+	}
+
+	return 0, vm.Free(args[0])
+}
+
+func exit(_ *VM, args []int64) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("exit: expected 1 argument, got %d", len(args))
+	}
+
+	return 0, &interp.ExitError{Code: int(int32(args[0]))}
+}
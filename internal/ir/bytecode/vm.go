@@ -0,0 +1,453 @@
+package bytecode
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/ir/interp"
+)
+
+// Intrinsic implements an extern function of a program in Go. It receives the
+// values of the arguments, with words sign-extended.
+type Intrinsic func(vm *VM, args []int64) (int64, error)
+
+// VM executes a program. Memory is laid out like in the IR interpreter: an
+// address holds the number of its object in the upper 32 bits and the offset
+// in the lower 32 bits, so accesses outside of an object are caught, and a
+// program can't corrupt the application that runs it.
+type VM struct {
+	prog       *Program
+	addrs      []int64       // the address of every symbol
+	byAddr     map[int64]int // the symbol of a function at an address
+	intrinsics map[string]Intrinsic
+	objects    map[int64][]byte
+	next       int64 // number of the next object
+	stdout     io.Writer
+	maxSteps   int
+	steps      int
+}
+
+// opBinops are the IR's binary operations of the operations.
+var opBinops = func() map[Op]ir.BinOpKind {
+	kinds := make(map[Op]ir.BinOpKind, len(binops))
+	for kind, op := range binops {
+		kinds[op] = kind
+	}
+
+	return kinds
+}()
+
+// New returns a machine for prog, with its data laid out in memory and the
+// default intrinsics: printf, puts, putchar, calloc, malloc, free and exit.
+// The program is checked first, so a corrupt program is reported instead of
+// crashing the machine.
+func New(prog *Program) (*VM, error) {
+	if err := verify(prog); err != nil {
+		return nil, err
+	}
+
+	vm := &VM{
+		prog:       prog,
+		addrs:      make([]int64, len(prog.Symbols)),
+		byAddr:     make(map[int64]int),
+		intrinsics: make(map[string]Intrinsic),
+		objects:    make(map[int64][]byte),
+		next:       1, // the null pointer doesn't point to an object
+		stdout:     os.Stdout,
+	}
+
+	for name, fn := range intrinsics {
+		vm.intrinsics[name] = fn
+	}
+
+	for i, sym := range prog.Symbols {
+		vm.addrs[i] = vm.newObject(len(sym.Data))
+
+		if sym.Kind == SymData {
+			copy(vm.objects[vm.addrs[i]>>32], sym.Data)
+		} else {
+			vm.byAddr[vm.addrs[i]] = i
+		}
+	}
+
+	// Data can refer to other data, so all of it is placed before any of it
+	// is relocated.
+	for i, sym := range prog.Symbols {
+		obj := vm.objects[vm.addrs[i]>>32]
+
+		for _, r := range sym.Relocs {
+			binary.LittleEndian.PutUint64(obj[r.Offset:], uint64(vm.addrs[r.Symbol]+r.Addend))
+		}
+	}
+
+	return vm, nil
+}
+
+// verify checks that the operands of the instructions of prog refer to the
+// registers, instructions and symbols there are.
+func verify(prog *Program) error {
+	for _, sym := range prog.Symbols {
+		for _, r := range sym.Relocs {
+			if r.Offset < 0 || r.Offset+8 > int64(len(sym.Data)) || r.Symbol < 0 || r.Symbol >= int64(len(prog.Symbols)) {
+				return fmt.Errorf("invalid bytecode: relocation at %d in %s is out of range", r.Offset, sym.Name)
+			}
+		}
+
+		if sym.Params > sym.Regs {
+			return fmt.Errorf("invalid bytecode: %s has more parameters than registers", sym.Name)
+		}
+
+		for pc, instr := range sym.Code {
+			if err := verifyInstr(prog, &sym, instr); err != nil {
+				return fmt.Errorf("invalid bytecode: %s+%d: %s: %w", sym.Name, pc, instr.Op, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func verifyInstr(prog *Program, sym *Symbol, instr Instr) error {
+	reg := func(regs ...int64) error {
+		for _, r := range regs {
+			if r < 0 || r >= int64(sym.Regs) {
+				return fmt.Errorf("register %d out of range", r)
+			}
+		}
+
+		return nil
+	}
+
+	code := func(pc int64) error {
+		if pc < 0 || pc >= int64(len(sym.Code)) {
+			return fmt.Errorf("instruction %d out of range", pc)
+		}
+
+		return nil
+	}
+
+	if instr.Op >= opCount {
+		return fmt.Errorf("unknown operation")
+	}
+
+	if instr.Ty > TyUH {
+		return fmt.Errorf("unknown type %d", instr.Ty)
+	}
+
+	switch instr.Op {
+	case OpConst:
+		return reg(instr.A)
+	case OpAddr:
+		if instr.B < 0 || instr.B >= int64(len(prog.Symbols)) {
+			return fmt.Errorf("symbol %d out of range", instr.B)
+		}
+
+		return reg(instr.A)
+	case OpMove, OpAlloc, OpStore:
+		return reg(instr.A, instr.B)
+	case OpLoad, OpExt:
+		if instr.C < 0 || Ty(instr.C) > TyUH {
+			return fmt.Errorf("unknown type %d", instr.C)
+		}
+
+		return reg(instr.A, instr.B)
+	case OpCall:
+		if instr.A != -1 {
+			if err := reg(instr.A); err != nil {
+				return err
+			}
+		}
+
+		return reg(append([]int64{instr.B}, instr.Args...)...)
+	case OpJmp:
+		return code(instr.A)
+	case OpJnz:
+		if err := code(instr.B); err != nil {
+			return err
+		}
+
+		return reg(instr.A)
+	case OpRet:
+		if instr.A == -1 {
+			return nil
+		}
+
+		return reg(instr.A)
+	case OpHlt:
+		return nil
+	default:
+		return reg(instr.A, instr.B, instr.C)
+	}
+}
+
+// WithStdout sets where the program's output goes.
+func (vm *VM) WithStdout(w io.Writer) *VM {
+	vm.stdout = w
+	return vm
+}
+
+// WithIntrinsic implements the extern function name by fn. It replaces a
+// default intrinsic of the same name.
+func (vm *VM) WithIntrinsic(name string, fn Intrinsic) *VM {
+	vm.intrinsics[name] = fn
+	return vm
+}
+
+// WithMaxSteps limits the number of instructions executed, so a program that
+// doesn't end is stopped. The default of 0 doesn't limit them.
+func (vm *VM) WithMaxSteps(n int) *VM {
+	vm.maxSteps = n
+	return vm
+}
+
+// Stdout returns where the program's output goes, for intrinsics.
+func (vm *VM) Stdout() io.Writer {
+	return vm.stdout
+}
+
+// Call calls the function name with args and returns its result, or 0 for a
+// function that doesn't return a value.
+func (vm *VM) Call(name string, args ...int64) (int64, error) {
+	sym, ok := vm.prog.Lookup(name)
+	if !ok || vm.prog.Symbols[sym].Kind == SymData {
+		return 0, fmt.Errorf("unknown function %s", name)
+	}
+
+	return vm.call(sym, args)
+}
+
+// Run calls main without arguments, like the C runtime does, and returns the
+// exit status of the program: the result of main, or the status passed to
+// exit, truncated to a byte like by the operating system.
+func (vm *VM) Run() (int, error) {
+	ret, err := vm.Call("main")
+
+	var exit *interp.ExitError
+	if errors.As(err, &exit) {
+		return exit.Code & 0xff, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int(ret) & 0xff, nil
+}
+
+// Alloc allocates an object of size zeroed bytes and returns its address.
+func (vm *VM) Alloc(size int64) (int64, error) {
+	if size < 0 || size > math.MaxUint32 {
+		return 0, fmt.Errorf("can't allocate %d bytes", size)
+	}
+
+	return vm.newObject(int(size)), nil
+}
+
+// Free frees the object at addr, which must be the start of an object.
+func (vm *VM) Free(addr int64) error {
+	if addr == 0 {
+		return nil
+	}
+
+	if _, ok := vm.objects[addr>>32]; !ok || uint32(addr) != 0 {
+		return fmt.Errorf("free of %#x, which isn't an allocated object", addr)
+	}
+
+	delete(vm.objects, addr>>32)
+
+	return nil
+}
+
+// Bytes returns the n bytes of memory at addr.
+func (vm *VM) Bytes(addr, n int64) ([]byte, error) {
+	obj, ok := vm.objects[addr>>32]
+	off := int64(uint32(addr))
+
+	if !ok || n < 0 || off+n > int64(len(obj)) {
+		return nil, fmt.Errorf("access of %d bytes at %#x is out of bounds", n, addr)
+	}
+
+	return obj[off : off+n], nil
+}
+
+// String returns the NUL-terminated string at addr.
+func (vm *VM) String(addr int64) (string, error) {
+	obj, ok := vm.objects[addr>>32]
+	if !ok {
+		return "", fmt.Errorf("string at %#x is out of bounds", addr)
+	}
+
+	for i := int(uint32(addr)); i < len(obj); i++ {
+		if obj[i] == 0 {
+			return string(obj[uint32(addr):i]), nil
+		}
+	}
+
+	return "", fmt.Errorf("string at %#x isn't terminated", addr)
+}
+
+func (vm *VM) newObject(size int) int64 {
+	n := vm.next
+	vm.next++
+	vm.objects[n] = make([]byte, size)
+
+	return n << 32
+}
+
+func (vm *VM) call(index int, args []int64) (int64, error) {
+	sym := &vm.prog.Symbols[index]
+
+	if sym.Kind == SymExtern {
+		fn, ok := vm.intrinsics[sym.Name]
+		if !ok {
+			return 0, fmt.Errorf("function %s isn't defined", sym.Name)
+		}
+
+		return fn(vm, args)
+	}
+
+	if len(args) != sym.Params {
+		return 0, fmt.Errorf("function %s called with %d arguments, expected %d", sym.Name, len(args), sym.Params)
+	}
+
+	regs := make([]int64, sym.Regs)
+	copy(regs, args)
+
+	var allocs []int64
+
+	defer func() {
+		for _, addr := range allocs {
+			delete(vm.objects, addr>>32)
+		}
+	}()
+
+	for pc := 0; pc < len(sym.Code); {
+		instr := &sym.Code[pc]
+		pc++
+
+		if vm.maxSteps > 0 {
+			vm.steps++
+
+			if vm.steps > vm.maxSteps {
+				return 0, fmt.Errorf("more than %d instructions executed", vm.maxSteps)
+			}
+		}
+
+		switch instr.Op {
+		case OpConst:
+			regs[instr.A] = instr.B
+		case OpAddr:
+			regs[instr.A] = vm.addrs[instr.B]
+		case OpMove:
+			regs[instr.A] = regs[instr.B]
+		case OpAlloc:
+			addr, err := vm.Alloc(regs[instr.B])
+			if err != nil {
+				return 0, fmt.Errorf("%s+%d: %w", sym.Name, pc-1, err)
+			}
+
+			allocs = append(allocs, addr)
+			regs[instr.A] = addr
+		case OpLoad:
+			b, err := vm.Bytes(regs[instr.B], int64(instr.Ty.size()))
+			if err != nil {
+				return 0, fmt.Errorf("%s+%d: %w", sym.Name, pc-1, err)
+			}
+
+			regs[instr.A] = normalize(load(b, instr.Ty), Ty(instr.C))
+		case OpStore:
+			b, err := vm.Bytes(regs[instr.A], int64(instr.Ty.size()))
+			if err != nil {
+				return 0, fmt.Errorf("%s+%d: %w", sym.Name, pc-1, err)
+			}
+
+			for i := range b {
+				b[i] = byte(regs[instr.B] >> (8 * i))
+			}
+		case OpExt:
+			from := Ty(instr.C)
+			regs[instr.A] = normalize(load(binary.LittleEndian.AppendUint64(nil, uint64(regs[instr.B]))[:from.size()], from),
+				instr.Ty)
+		case OpCall:
+			callee, ok := vm.byAddr[regs[instr.B]]
+			if !ok {
+				return 0, fmt.Errorf("%s+%d: call of %#x, which isn't a function", sym.Name, pc-1, regs[instr.B])
+			}
+
+			args := make([]int64, len(instr.Args))
+			for i, r := range instr.Args {
+				args[i] = regs[r]
+			}
+
+			ret, err := vm.call(callee, args)
+			if err != nil {
+				return 0, err
+			}
+
+			if instr.A >= 0 {
+				regs[instr.A] = normalize(ret, instr.Ty)
+			}
+		case OpJmp:
+			pc = int(instr.A)
+		case OpJnz:
+			if int32(regs[instr.A]) != 0 {
+				pc = int(instr.B)
+			}
+		case OpRet:
+			if instr.A < 0 {
+				return 0, nil
+			}
+
+			return regs[instr.A], nil
+		case OpHlt:
+			return 0, fmt.Errorf("%s+%d: program halted", sym.Name, pc-1)
+		default:
+			width := ir.NewAbiTyBase(ir.BaseWord)
+			if instr.Ty == TyL {
+				width = ir.NewAbiTyBase(ir.BaseLong)
+			}
+
+			r, ok := ir.EvalBinop(opBinops[instr.Op], width, regs[instr.B], regs[instr.C])
+			if !ok {
+				return 0, fmt.Errorf("%s+%d: division by zero", sym.Name, pc-1)
+			}
+
+			regs[instr.A] = r
+		}
+	}
+
+	return 0, fmt.Errorf("%s: ran past the end of the code", sym.Name)
+}
+
+// load reads a value of type ty from b, extending sub-word values.
+func load(b []byte, ty Ty) int64 {
+	switch ty {
+	case TySB:
+		return int64(int8(b[0]))
+	case TyUB:
+		return int64(b[0])
+	case TySH:
+		return int64(int16(binary.LittleEndian.Uint16(b)))
+	case TyUH:
+		return int64(binary.LittleEndian.Uint16(b))
+	case TyL:
+		return int64(binary.LittleEndian.Uint64(b))
+	default:
+		return int64(int32(binary.LittleEndian.Uint32(b)))
+	}
+}
+
+// normalize returns val as a value of type ty in a register: everything but
+// a long is sign-extended from 32 bits.
+func normalize(val int64, ty Ty) int64 {
+	if ty == TyL {
+		return val
+	}
+
+	return int64(int32(val))
+}
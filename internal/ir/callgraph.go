@@ -0,0 +1,127 @@
+package ir
+
+import "slices"
+
+// CallGraph records the symbols every function and data definition of a unit
+// refers to: the functions it calls, and the functions and data whose address
+// it uses.
+type CallGraph struct {
+	refs map[Ident][]Ident
+}
+
+// NewCallGraph builds the call graph of unit. A function can be referred to by
+// its name and by its link name.
+func NewCallGraph(unit *CompilationUnit) *CallGraph {
+	g := &CallGraph{refs: make(map[Ident][]Ident)}
+
+	for _, fd := range unit.FuncDefs {
+		var refs []Ident
+
+		for _, block := range fd.Blocks {
+			for _, instr := range block.Instructions {
+				for _, op := range Operands(instr) {
+					if ident, ok := symbol(*op); ok && !slices.Contains(refs, ident) {
+						refs = append(refs, ident)
+					}
+				}
+			}
+		}
+
+		g.refs[fd.Ident] = refs
+
+		if fd.LinkName != "" {
+			g.refs[fd.LinkName] = refs
+		}
+	}
+
+	for _, dd := range unit.DataDefs {
+		var refs []Ident
+
+		for _, init := range dd.Initializer {
+			for _, item := range init.Items {
+				switch {
+				case item.Type == DataItemSymbol:
+					refs = append(refs, item.Ident)
+				case item.Type == DataItemConst && item.Const.Type == ConstIdent:
+					refs = append(refs, item.Const.Ident)
+				}
+			}
+		}
+
+		g.refs[dd.Ident] = refs
+	}
+
+	return g
+}
+
+// symbol returns the name of the function or data a value is the address of.
+func symbol(val *Val) (Ident, bool) {
+	switch {
+	case val.Type != ValDynConst:
+		return "", false
+	case val.DynConst.Type == DynConstThread:
+		return val.DynConst.Ident, true
+	case val.DynConst.Const.Type == ConstIdent:
+		return val.DynConst.Const.Ident, true
+	default:
+		return "", false
+	}
+}
+
+// Refs returns the symbols the function or data definition refers to, in the
+// order they're first referred to.
+func (g *CallGraph) Refs(ident Ident) []Ident {
+	return g.refs[ident]
+}
+
+// Reachable returns the symbols that can be reached from the roots by
+// following references, including the roots.
+func (g *CallGraph) Reachable(roots ...Ident) map[Ident]bool {
+	reachable := make(map[Ident]bool)
+	work := slices.Clone(roots)
+
+	for len(work) > 0 {
+		ident := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		if reachable[ident] {
+			continue
+		}
+
+		reachable[ident] = true
+		work = append(work, g.refs[ident]...)
+	}
+
+	return reachable
+}
+
+// RemoveDeadFuncs removes the functions and data of the unit that can't be
+// reached from main or from an exported function or data definition, like
+// the functions every call to was inlined.
+func (cu *CompilationUnit) RemoveDeadFuncs() {
+	roots := []Ident{"main"}
+
+	// Interrupt handlers and naked entry points are called from outside the
+	// program, like exported functions.
+	for _, fd := range cu.FuncDefs {
+		if fd.Linkage != nil && fd.Linkage.Type == LinkageExport || fd.CallConv != CallConvC {
+			roots = append(roots, fd.Ident)
+		}
+	}
+
+	for _, dd := range cu.DataDefs {
+		if dd.Linkage != nil && dd.Linkage.Type == LinkageExport {
+			roots = append(roots, dd.Ident)
+		}
+	}
+
+	reachable := NewCallGraph(cu).Reachable(roots...)
+
+	cu.FuncDefs = slices.DeleteFunc(cu.FuncDefs, func(fd FuncDef) bool {
+		return !reachable[fd.Ident] && !(fd.LinkName != "" && reachable[fd.LinkName])
+	})
+
+	cu.DataDefs = slices.DeleteFunc(cu.DataDefs, func(dd DataDef) bool {
+		return !reachable[dd.Ident]
+	})
+}
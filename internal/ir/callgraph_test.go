@@ -0,0 +1,72 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/corani/cubit/internal/typecheck"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompilationUnit_RemoveDeadFuncs(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+@(extern)
+puts :: func(s: string)
+
+@(extern)
+abs :: func(x: int) -> int
+
+used :: func() {
+    puts("used")
+}
+
+unused :: func() {
+    puts("unused")
+    ping(1)
+}
+
+ping :: func(n: int) {
+    pong(n)
+}
+
+pong :: func(n: int) {
+    ping(abs(n))
+}
+
+@(export)
+api :: func() {
+    used()
+}
+
+main :: func() -> int {
+    used()
+    return 0
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+	require.NoError(t, typecheck.Check(unit))
+
+	lowered, err := Lower(unit)
+	require.NoError(t, err)
+
+	graph := NewCallGraph(lowered)
+	require.Equal(t, []Ident{"pong"}, graph.Refs("ping"))
+	require.Equal(t, map[Ident]bool{"ping": true, "pong": true, "abs": true}, graph.Reachable("ping"))
+
+	lowered.RemoveDeadFuncs()
+
+	var funcs []Ident
+	for _, fd := range lowered.FuncDefs {
+		funcs = append(funcs, fd.Ident)
+	}
+
+	// Functions only called by other dead functions are removed too, and so
+	// are the strings only they use.
+	require.Equal(t, []Ident{"puts", "used", "api", "main"}, funcs)
+	require.Len(t, lowered.DataDefs, 1)
+	require.Equal(t, "used", lowered.DataDefs[0].Initializer[0].Items[0].StringVal)
+}
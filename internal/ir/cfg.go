@@ -0,0 +1,376 @@
+package ir
+
+import "slices"
+
+// IsTerminator reports whether instr ends a block. Every block ends with
+// exactly one terminator, and it's the block's last instruction.
+func IsTerminator(instr Instruction) bool {
+	switch instr.(type) {
+	case *Ret, *Hlt, *Jmp, *Jnz:
+		return true
+	default:
+		return false
+	}
+}
+
+// Terminator returns the last instruction of the block if it's a terminator,
+// or nil if the block isn't complete yet.
+func (b *Block) Terminator() Instruction {
+	if len(b.Instructions) == 0 {
+		return nil
+	}
+
+	if last := b.Instructions[len(b.Instructions)-1]; IsTerminator(last) {
+		return last
+	}
+
+	return nil
+}
+
+// Successors returns the labels of the blocks control can continue to after
+// the block, without duplicates.
+func (b *Block) Successors() []string {
+	switch t := b.Terminator().(type) {
+	case *Jmp:
+		return []string{t.Label}
+	case *Jnz:
+		if t.True == t.False {
+			return []string{t.True}
+		}
+
+		return []string{t.True, t.False}
+	default:
+		return nil
+	}
+}
+
+// CFG is the control-flow graph of a function: its blocks and the edges
+// between them. The first block is the entry of the function. The graph is a
+// view of the function when it was built; changing the terminator of a block
+// requires a new one.
+type CFG struct {
+	Blocks  []*Block
+	byLabel map[string]*Block
+	succs   map[*Block][]*Block
+	preds   map[*Block][]*Block
+}
+
+// NewCFG builds the control-flow graph of fd. The blocks of the graph point
+// into fd, so their instructions can be changed through it. Jumps to labels
+// that don't exist are ignored; Verify reports them.
+func NewCFG(fd *FuncDef) *CFG {
+	c := &CFG{
+		byLabel: make(map[string]*Block),
+		succs:   make(map[*Block][]*Block),
+		preds:   make(map[*Block][]*Block),
+	}
+
+	for i := range fd.Blocks {
+		b := &fd.Blocks[i]
+
+		c.Blocks = append(c.Blocks, b)
+		c.byLabel[b.Label] = b
+	}
+
+	for _, b := range c.Blocks {
+		for _, label := range b.Successors() {
+			if succ, ok := c.byLabel[label]; ok {
+				c.succs[b] = append(c.succs[b], succ)
+				c.preds[succ] = append(c.preds[succ], b)
+			}
+		}
+	}
+
+	return c
+}
+
+// Entry returns the block the function starts in, or nil if it has none.
+func (c *CFG) Entry() *Block {
+	if len(c.Blocks) == 0 {
+		return nil
+	}
+
+	return c.Blocks[0]
+}
+
+// Block returns the block with the given label, or nil if there's none.
+func (c *CFG) Block(label string) *Block {
+	return c.byLabel[label]
+}
+
+// Successors returns the blocks control can continue to after b.
+func (c *CFG) Successors(b *Block) []*Block {
+	return c.succs[b]
+}
+
+// Predecessors returns the blocks that can continue to b, in the order of the
+// function.
+func (c *CFG) Predecessors(b *Block) []*Block {
+	return c.preds[b]
+}
+
+// Reachable returns the blocks that can be reached from the entry.
+func (c *CFG) Reachable() map[*Block]bool {
+	reachable := make(map[*Block]bool)
+
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		if reachable[b] {
+			return
+		}
+
+		reachable[b] = true
+
+		for _, succ := range c.succs[b] {
+			visit(succ)
+		}
+	}
+
+	if entry := c.Entry(); entry != nil {
+		visit(entry)
+	}
+
+	return reachable
+}
+
+// RemoveUnreachable removes the blocks of fd that can't be reached from its
+// entry, like the code after a return, and the phi arguments for edges that
+// no longer exist.
+func (fd *FuncDef) RemoveUnreachable() {
+	reachable := NewCFG(fd).Reachable()
+
+	var blocks []Block
+
+	for i := range fd.Blocks {
+		if reachable[&fd.Blocks[i]] {
+			blocks = append(blocks, fd.Blocks[i])
+		}
+	}
+
+	fd.Blocks = blocks
+
+	cfg := NewCFG(fd)
+
+	for _, b := range cfg.Blocks {
+		preds := make(map[string]bool)
+		for _, pred := range cfg.Predecessors(b) {
+			preds[pred.Label] = true
+		}
+
+		for _, instr := range b.Instructions {
+			phi, ok := instr.(*Phi)
+			if !ok {
+				break
+			}
+
+			var args []PhiArg
+
+			for _, arg := range phi.Args {
+				if preds[arg.Label] {
+					args = append(args, arg)
+				}
+			}
+
+			phi.Args = args
+		}
+	}
+}
+
+// Verify checks the invariants of the blocks of fd: every block has a unique
+// label and ends with its only terminator, phis come first in their block, and
+// every jump goes to a block of the function.
+func (fd *FuncDef) Verify() error {
+	labels := make(map[string]bool)
+
+	for _, b := range fd.Blocks {
+		if b.Label == "" {
+			return b.Loc.Errorf("block in function %s has no label", fd.Ident)
+		}
+
+		if labels[b.Label] {
+			return b.Loc.Errorf("duplicate block @%s in function %s", b.Label, fd.Ident)
+		}
+
+		labels[b.Label] = true
+	}
+
+	for _, b := range fd.Blocks {
+		if b.Terminator() == nil {
+			return b.Loc.Errorf("block @%s doesn't end with a jump or return", b.Label)
+		}
+
+		phis := true
+
+		for i, instr := range b.Instructions {
+			if IsTerminator(instr) && i != len(b.Instructions)-1 {
+				return instr.Location().Errorf("block @%s continues after its terminator", b.Label)
+			}
+
+			if _, ok := instr.(*Phi); ok && !phis {
+				return instr.Location().Errorf("phi in block @%s follows other instructions", b.Label)
+			} else if !ok {
+				phis = false
+			}
+		}
+
+		for _, label := range b.Successors() {
+			if !labels[label] {
+				return b.Terminator().Location().Errorf("jump from block @%s to unknown block @%s", b.Label, label)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Dominators returns the immediate dominator of every block that can be reached
+// from the entry: the closest block every path from the entry to it goes
+// through. The entry has no immediate dominator and maps to nil.
+func (c *CFG) Dominators() map[*Block]*Block {
+	// Cooper, Harvey and Kennedy, "A Simple, Fast Dominance Algorithm".
+	var order []*Block
+
+	seen := make(map[*Block]bool)
+
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		seen[b] = true
+
+		for _, succ := range c.succs[b] {
+			if !seen[succ] {
+				visit(succ)
+			}
+		}
+
+		order = append(order, b)
+	}
+
+	entry := c.Entry()
+	if entry == nil {
+		return nil
+	}
+
+	visit(entry)
+	slices.Reverse(order)
+
+	index := make(map[*Block]int)
+	for i, b := range order {
+		index[b] = i
+	}
+
+	idom := map[*Block]*Block{entry: entry}
+
+	intersect := func(a, b *Block) *Block {
+		for a != b {
+			for index[a] > index[b] {
+				a = idom[a]
+			}
+
+			for index[b] > index[a] {
+				b = idom[b]
+			}
+		}
+
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, b := range order[1:] {
+			var dom *Block
+
+			for _, pred := range c.preds[b] {
+				if idom[pred] == nil {
+					continue
+				}
+
+				if dom == nil {
+					dom = pred
+				} else {
+					dom = intersect(pred, dom)
+				}
+			}
+
+			if idom[b] != dom {
+				idom[b] = dom
+				changed = true
+			}
+		}
+	}
+
+	idom[entry] = nil
+
+	return idom
+}
+
+// Dominates reports whether every path from the entry to b goes through a,
+// given the immediate dominators returned by Dominators. A block dominates
+// itself.
+func Dominates(idom map[*Block]*Block, a, b *Block) bool {
+	for ; b != nil; b = idom[b] {
+		if a == b {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Loop is a natural loop: a header that dominates the blocks of the loop, and
+// the blocks that jump back to it.
+type Loop struct {
+	Header *Block
+	Blocks map[*Block]bool // the blocks of the loop, including the header
+}
+
+// Loops returns the natural loops of the graph, inner loops before the loops
+// they're nested in. Back edges to the same header form a single loop.
+func (c *CFG) Loops() []Loop {
+	idom := c.Dominators()
+
+	var loops []Loop
+
+	byHeader := make(map[*Block]int)
+
+	for _, b := range c.Blocks {
+		if _, ok := idom[b]; !ok {
+			continue
+		}
+
+		for _, header := range c.succs[b] {
+			if !Dominates(idom, header, b) {
+				continue
+			}
+
+			i, ok := byHeader[header]
+			if !ok {
+				i = len(loops)
+				byHeader[header] = i
+				loops = append(loops, Loop{Header: header, Blocks: map[*Block]bool{header: true}})
+			}
+
+			// The loop is every block that reaches the back edge without
+			// going through the header.
+			work := []*Block{b}
+
+			for len(work) > 0 {
+				block := work[len(work)-1]
+				work = work[:len(work)-1]
+
+				if _, ok := idom[block]; !ok || loops[i].Blocks[block] {
+					continue
+				}
+
+				loops[i].Blocks[block] = true
+				work = append(work, c.preds[block]...)
+			}
+		}
+	}
+
+	slices.SortStableFunc(loops, func(a, b Loop) int {
+		return len(a.Blocks) - len(b.Blocks)
+	})
+
+	return loops
+}
@@ -0,0 +1,178 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCFG(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 5}
+	cond := NewValIdent(loc, "c", NewAbiTyBase(BaseWord))
+
+	fd := NewFuncDef(loc, "f").WithBlocks(
+		NewBlock(loc, "start", []Instruction{NewJnz(loc, cond, "then", "else")}),
+		NewBlock(loc, "then", []Instruction{NewJmp(loc, "end")}),
+		NewBlock(loc, "else", []Instruction{NewJmp(loc, "end")}),
+		NewBlock(loc, "dead", []Instruction{NewJmp(loc, "end")}),
+		NewBlock(loc, "end", []Instruction{NewRet(loc)}),
+	)
+	require.NoError(t, fd.Verify())
+
+	cfg := NewCFG(&fd)
+
+	labels := func(blocks []*Block) []string {
+		var names []string
+		for _, b := range blocks {
+			names = append(names, b.Label)
+		}
+
+		return names
+	}
+
+	require.Equal(t, "start", cfg.Entry().Label)
+	require.Equal(t, []string{"then", "else"}, labels(cfg.Successors(cfg.Block("start"))))
+	require.Equal(t, []string{"then", "else", "dead"}, labels(cfg.Predecessors(cfg.Block("end"))))
+	require.Empty(t, cfg.Successors(cfg.Block("end")))
+
+	reachable := cfg.Reachable()
+	require.Len(t, reachable, 4)
+	require.False(t, reachable[cfg.Block("dead")])
+}
+
+func TestFuncDef_Verify(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 5}
+	val := NewValInteger(loc, 1, NewAbiTyBase(BaseWord))
+	phi := NewPhi(loc, NewValIdent(loc, "x", NewAbiTyBase(BaseWord)))
+
+	tt := []struct {
+		name     string
+		blocks   []Block
+		expected string
+	}{
+		{
+			name:     "no label",
+			blocks:   []Block{NewBlock(loc, "", []Instruction{NewRet(loc)})},
+			expected: "test.in:3:5: block in function f has no label",
+		},
+		{
+			name: "duplicate label",
+			blocks: []Block{
+				NewBlock(loc, "start", []Instruction{NewRet(loc)}),
+				NewBlock(loc, "start", []Instruction{NewRet(loc)}),
+			},
+			expected: "test.in:3:5: duplicate block @start in function f",
+		},
+		{
+			name:     "no terminator",
+			blocks:   []Block{NewBlock(loc, "start", []Instruction{NewStore(loc, val, val)})},
+			expected: "test.in:3:5: block @start doesn't end with a jump or return",
+		},
+		{
+			name:     "continues after terminator",
+			blocks:   []Block{NewBlock(loc, "start", []Instruction{NewRet(loc), NewRet(loc)})},
+			expected: "test.in:3:5: block @start continues after its terminator",
+		},
+		{
+			name:     "late phi",
+			blocks:   []Block{NewBlock(loc, "start", []Instruction{NewStore(loc, val, val), phi, NewRet(loc)})},
+			expected: "test.in:3:5: phi in block @start follows other instructions",
+		},
+		{
+			name:     "unknown target",
+			blocks:   []Block{NewBlock(loc, "start", []Instruction{NewJmp(loc, "end")})},
+			expected: "test.in:3:5: jump from block @start to unknown block @end",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fd := NewFuncDef(loc, "f").WithBlocks(tc.blocks...)
+			require.EqualError(t, fd.Verify(), tc.expected)
+		})
+	}
+}
+
+func TestCFG_Dominators(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 5}
+	cond := NewValIdent(loc, "c", NewAbiTyBase(BaseWord))
+
+	// start -> loop -> (body -> loop | end)
+	fd := NewFuncDef(loc, "f").WithBlocks(
+		NewBlock(loc, "start", []Instruction{NewJmp(loc, "loop")}),
+		NewBlock(loc, "loop", []Instruction{NewJnz(loc, cond, "body", "end")}),
+		NewBlock(loc, "body", []Instruction{NewJnz(loc, cond, "then", "loop")}),
+		NewBlock(loc, "then", []Instruction{NewJmp(loc, "loop")}),
+		NewBlock(loc, "end", []Instruction{NewRet(loc)}),
+		NewBlock(loc, "dead", []Instruction{NewJmp(loc, "end")}),
+	)
+
+	cfg := NewCFG(&fd)
+	idom := cfg.Dominators()
+
+	expected := map[string]string{
+		"start": "",
+		"loop":  "start",
+		"body":  "loop",
+		"then":  "body",
+		"end":   "loop",
+	}
+
+	actual := make(map[string]string)
+	for b, dom := range idom {
+		actual[b.Label] = ""
+		if dom != nil {
+			actual[b.Label] = dom.Label
+		}
+	}
+
+	require.Equal(t, expected, actual)
+	require.True(t, Dominates(idom, cfg.Block("loop"), cfg.Block("then")))
+	require.False(t, Dominates(idom, cfg.Block("then"), cfg.Block("loop")))
+}
+
+func TestCFG_Loops(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 5}
+	cond := NewValIdent(loc, "c", NewAbiTyBase(BaseWord))
+
+	// start -> outer -> (inner -> (body -> inner | next -> outer) | end)
+	fd := NewFuncDef(loc, "f").WithBlocks(
+		NewBlock(loc, "start", []Instruction{NewJmp(loc, "outer")}),
+		NewBlock(loc, "outer", []Instruction{NewJnz(loc, cond, "inner", "end")}),
+		NewBlock(loc, "inner", []Instruction{NewJnz(loc, cond, "body", "next")}),
+		NewBlock(loc, "body", []Instruction{NewJmp(loc, "inner")}),
+		NewBlock(loc, "next", []Instruction{NewJmp(loc, "outer")}),
+		NewBlock(loc, "end", []Instruction{NewRet(loc)}),
+	)
+
+	var actual [][]string
+
+	for _, loop := range NewCFG(&fd).Loops() {
+		labels := []string{loop.Header.Label}
+
+		for i := range fd.Blocks {
+			if b := &fd.Blocks[i]; loop.Blocks[b] && b != loop.Header {
+				labels = append(labels, b.Label)
+			}
+		}
+
+		actual = append(actual, labels)
+	}
+
+	// The inner loop comes first.
+	require.Equal(t, [][]string{
+		{"inner", "body"},
+		{"outer", "inner", "body", "next"},
+	}, actual)
+}
@@ -0,0 +1,67 @@
+package ir
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+// encodingVersion is written in front of every encoded unit. It changes when
+// the IR does, so a cache never returns a unit in an older shape.
+const encodingVersion = 3
+
+func init() {
+	// Instructions are encoded as interface values, which gob only supports
+	// for registered types.
+	gob.Register(&Ret{})
+	gob.Register(&Hlt{})
+	gob.Register(&Call{})
+	gob.Register(&Binop{})
+	gob.Register(&Jmp{})
+	gob.Register(&Jnz{})
+	gob.Register(&Load{})
+	gob.Register(&Store{})
+	gob.Register(&Convert{})
+	gob.Register(&Alloc{})
+	gob.Register(&Phi{})
+}
+
+// Encode writes a binary encoding of unit to w, so it can be cached and read
+// back with Decode instead of lowering the source again. Values that are
+// shared between instructions are written, and read back, once per use, which
+// doesn't matter as values are replaced, never changed.
+func Encode(w io.Writer, unit *CompilationUnit) error {
+	enc := gob.NewEncoder(w)
+
+	if err := enc.Encode(encodingVersion); err != nil {
+		return fmt.Errorf("failed to encode IR: %w", err)
+	}
+
+	if err := enc.Encode(unit); err != nil {
+		return fmt.Errorf("failed to encode IR: %w", err)
+	}
+
+	return nil
+}
+
+// Decode reads a unit written by Encode. It fails if the unit was written by a
+// version of the compiler whose IR is different.
+func Decode(r io.Reader) (*CompilationUnit, error) {
+	dec := gob.NewDecoder(r)
+
+	var version int
+	if err := dec.Decode(&version); err != nil {
+		return nil, fmt.Errorf("failed to decode IR: %w", err)
+	}
+
+	if version != encodingVersion {
+		return nil, fmt.Errorf("failed to decode IR: version %d, expected %d", version, encodingVersion)
+	}
+
+	unit := NewCompilationUnit()
+	if err := dec.Decode(unit); err != nil {
+		return nil, fmt.Errorf("failed to decode IR: %w", err)
+	}
+
+	return unit, nil
+}
@@ -0,0 +1,54 @@
+package ir
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/corani/cubit/internal/typecheck"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+@(extern, link_name="printf")
+print :: func(msg: string, args: ..any)
+
+@(export)
+main :: func() -> int {
+    a := [4]bool{}
+    for i := 0; i < 4 && a[i] == false; i = i + 1 {
+        print("%d\n", i)
+    }
+
+    return 0
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+	require.NoError(t, typecheck.Check(unit))
+
+	lowered, err := Lower(unit)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, lowered))
+
+	decoded, err := Decode(&buf)
+	require.NoError(t, err)
+	require.Equal(t, lowered, decoded)
+}
+
+func TestDecode_Version(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buf).Encode(encodingVersion+1))
+
+	_, err := Decode(&buf)
+	require.EqualError(t, err, "failed to decode IR: version 4, expected 3")
+}
@@ -0,0 +1,213 @@
+package ir
+
+import (
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/ast/symbols"
+)
+
+// Escapes is the result of escape analysis: it records which values may outlive
+// the call of the function that creates them. Values that don't escape can live
+// in the stack frame of the function; values that escape need static data or,
+// eventually, the heap.
+//
+// The values tracked are the ones that hold an address: array and string
+// literals, and the parameters that receive them. The language has no
+// address-of operator yet, so the stack slots of locals never escape.
+type Escapes struct {
+	literals map[*ast.Literal]bool
+	params   map[*ast.FuncParam]bool
+}
+
+// AnalyzeEscapes runs escape analysis on a type-checked unit. A value escapes
+// if it's returned, stored through a pointer or into an array element, or passed
+// to a parameter that escapes. Parameters of extern functions always escape, as
+// their bodies can't be analyzed; parameters of builtins never do.
+//
+// The analysis is flow-insensitive: a variable holds every value assigned to it
+// anywhere in the function.
+func AnalyzeEscapes(unit *ast.CompilationUnit) *Escapes {
+	// References that don't resolve can't carry any values, so they're ignored;
+	// the type checker has reported them already.
+	table, _ := symbols.Resolve(unit)
+
+	e := &Escapes{
+		literals: make(map[*ast.Literal]bool),
+		params:   make(map[*ast.FuncParam]bool),
+	}
+
+	for _, fd := range unit.Funcs {
+		if fd.Body == nil && !fd.Attributes.Has(ast.AttrKeyBuiltin) {
+			for _, param := range fd.Params {
+				e.params[param] = true
+			}
+		}
+	}
+
+	// Each pass can make more parameters escape, which in turn makes the
+	// arguments passed to them escape, so repeat until nothing changes.
+	for changed := true; changed; {
+		changed = false
+
+		for _, fd := range unit.Funcs {
+			if fd.Body != nil && e.analyze(table, fd) {
+				changed = true
+			}
+		}
+	}
+
+	return e
+}
+
+// Escapes reports whether the value of an array or string literal escapes.
+func (e *Escapes) Escapes(lit *ast.Literal) bool {
+	return e.literals[lit]
+}
+
+// ParamEscapes reports whether the value passed to a parameter escapes.
+func (e *Escapes) ParamEscapes(param *ast.FuncParam) bool {
+	return e.params[param]
+}
+
+// escapeSet is the set of values a variable or expression may hold: literals
+// and parameters.
+type escapeSet map[ast.Node]bool
+
+// analyze marks the values that escape from fd, and reports whether it marked
+// any parameter that didn't escape before.
+func (e *Escapes) analyze(table *symbols.Table, fd *ast.FuncDef) bool {
+	vars := make(map[ast.Node]escapeSet)
+
+	for _, param := range fd.Params {
+		vars[param] = escapeSet{param: true}
+	}
+
+	// Propagate assignments between variables until the sets are stable, so
+	// values flow through any chain of assignments.
+	for changed := true; changed; {
+		changed = false
+
+		ast.Walk(fd.Body, func(n ast.Node) bool {
+			a, ok := n.(*ast.Assign)
+			if !ok {
+				return true
+			}
+
+			ref, ok := a.LHS.(*ast.VariableRef)
+			if !ok {
+				return true
+			}
+
+			decl := table.Declaration(ref)
+			if decl == nil {
+				return true
+			}
+
+			if vars[decl] == nil {
+				vars[decl] = make(escapeSet)
+			}
+
+			for v := range e.values(table, vars, a.Value) {
+				if !vars[decl][v] {
+					vars[decl][v] = true
+					changed = true
+				}
+			}
+
+			return true
+		})
+	}
+
+	escaped := false
+
+	sink := func(expr ast.Expression) {
+		for v := range e.values(table, vars, expr) {
+			switch v := v.(type) {
+			case *ast.Literal:
+				e.literals[v] = true
+			case *ast.FuncParam:
+				if !e.params[v] {
+					e.params[v] = true
+					escaped = true
+				}
+			}
+		}
+	}
+
+	ast.Walk(fd.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Return:
+			if n.Value != nil {
+				sink(n.Value)
+			}
+		case *ast.Assign:
+			if _, ok := n.LHS.(*ast.VariableRef); !ok {
+				sink(n.Value)
+			}
+		case *ast.Call:
+			if n.FuncDef == nil {
+				break
+			}
+
+			for i, arg := range n.Args {
+				if param := paramAt(n.FuncDef, i); param == nil || e.params[param] {
+					sink(arg.Value)
+				}
+			}
+		}
+
+		return true
+	})
+
+	return escaped
+}
+
+// values returns the values expr may evaluate to.
+func (e *Escapes) values(table *symbols.Table, vars map[ast.Node]escapeSet, expr ast.Expression) escapeSet {
+	switch expr := expr.(type) {
+	case *ast.Literal:
+		if t := expr.Type(); t != nil && (t.Kind == ast.TypeArray || t.Kind == ast.TypeString) {
+			return escapeSet{expr: true}
+		}
+	case *ast.VariableRef:
+		if decl := table.Declaration(expr); decl != nil {
+			return vars[decl]
+		}
+	case *ast.Binop:
+		// Pointer arithmetic yields an address into the same value.
+		if t := expr.Type(); t == nil || t.Kind != ast.TypePointer {
+			return nil
+		}
+
+		set := make(escapeSet)
+
+		for v := range e.values(table, vars, expr.Lhs) {
+			set[v] = true
+		}
+
+		for v := range e.values(table, vars, expr.Rhs) {
+			set[v] = true
+		}
+
+		return set
+	}
+
+	// Loads and calls don't yield a tracked value: anything stored in memory or
+	// returned from a call has escaped already.
+	return nil
+}
+
+// paramAt returns the parameter of fd that receives argument i, or nil if
+// there is none.
+func paramAt(fd *ast.FuncDef, i int) *ast.FuncParam {
+	for j, param := range fd.Params {
+		if param.Type != nil && param.Type.Kind == ast.TypeVararg {
+			return param
+		}
+
+		if j == i {
+			return param
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/corani/cubit/internal/typecheck"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeEscapes(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+@(extern)
+print :: func(s: string)
+
+keep :: func(a: [4]int) -> [4]int {
+    return a
+}
+
+first :: func(a: [4]int) -> int {
+    return a[0]
+}
+
+forward :: func(a: [4]int) -> [4]int {
+    b := a
+    return keep(b)
+}
+
+main :: func() -> int {
+    x := [4]int{}
+    y := x
+    z := forward(y)
+    w := [4]int{}
+    print("hello")
+    return first(w) + z[0]
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+	require.NoError(t, typecheck.Check(unit))
+
+	escapes := AnalyzeEscapes(unit)
+
+	var literals []int
+
+	ast.Walk(unit, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.Literal); ok && escapes.Escapes(lit) {
+			literals = append(literals, lit.Location().Line)
+		}
+
+		return true
+	})
+
+	// The array returned through forward and keep, and the string passed to an
+	// extern function.
+	require.Equal(t, []int{20, 24}, literals)
+
+	params := make(map[string]bool)
+
+	for _, fd := range unit.Funcs {
+		for _, param := range fd.Params {
+			params[fd.Ident+"."+param.Ident] = escapes.ParamEscapes(param)
+		}
+	}
+
+	require.Equal(t, map[string]bool{
+		"print.s":   true,
+		"keep.a":    true,
+		"first.a":   false,
+		"forward.a": true,
+	}, params)
+}
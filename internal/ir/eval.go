@@ -0,0 +1,103 @@
+package ir
+
+// EvalBinop evaluates an integer binary operation the way the target does: in
+// the width of ty, with shifts taking the shift amount modulo the width, and
+// the U variants treating the operands as unsigned. For comparisons, ty is the
+// type of the operands; otherwise it's the type of the result. Words are
+// returned sign-extended. Division by zero has no result.
+func EvalBinop(op BinOpKind, ty AbiTy, x, y int64) (int64, bool) {
+	long := ty.Type == AbiTyBase && ty.BaseTy == BaseLong
+	if !long {
+		x, y = int64(int32(x)), int64(int32(y))
+	}
+
+	// The unsigned values of the operands, in the same width.
+	ux, uy := uint64(x), uint64(y)
+	if !long {
+		ux, uy = uint64(uint32(x)), uint64(uint32(y))
+	}
+
+	width := int64(32)
+	if long {
+		width = 64
+	}
+
+	var r int64
+
+	switch op {
+	case BinOpAdd:
+		r = x + y
+	case BinOpSub:
+		r = x - y
+	case BinOpMul:
+		r = x * y
+	case BinOpDiv, BinOpMod, BinOpUDiv, BinOpUMod:
+		if y == 0 {
+			return 0, false
+		}
+
+		switch op {
+		case BinOpDiv:
+			r = x / y
+		case BinOpMod:
+			r = x % y
+		case BinOpUDiv:
+			r = int64(ux / uy)
+		default:
+			r = int64(ux % uy)
+		}
+	case BinOpAnd:
+		r = x & y
+	case BinOpOr:
+		r = x | y
+	case BinOpShl:
+		r = x << (y & (width - 1))
+	case BinOpShr:
+		r = int64(ux >> (y & (width - 1)))
+	default:
+		if !op.IsComparison() {
+			return 0, false
+		}
+
+		if compare(op, x, y, ux, uy) {
+			return 1, true
+		}
+
+		return 0, true
+	}
+
+	if !long {
+		r = int64(int32(r))
+	}
+
+	return r, true
+}
+
+// compare compares the signed values x and y, or their unsigned values ux and
+// uy for the U variants.
+func compare(op BinOpKind, x, y int64, ux, uy uint64) bool {
+	switch op {
+	case BinOpEq:
+		return x == y
+	case BinOpNe:
+		return x != y
+	case BinOpLt:
+		return x < y
+	case BinOpULt:
+		return ux < uy
+	case BinOpLe:
+		return x <= y
+	case BinOpULe:
+		return ux <= uy
+	case BinOpGt:
+		return x > y
+	case BinOpUGt:
+		return ux > uy
+	case BinOpGe:
+		return x >= y
+	case BinOpUGe:
+		return ux >= uy
+	default:
+		return false
+	}
+}
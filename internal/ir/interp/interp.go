@@ -0,0 +1,724 @@
+// Package interp executes the functions of a lowered compilation unit without
+// assembling and linking it, so tests can check what a program does, and the
+// compiler can evaluate functions at compile time.
+package interp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/ir"
+)
+
+// Intrinsic implements a function the unit declares but doesn't define, like
+// printf, in Go. It receives the values of the arguments, with words
+// sign-extended and floating point values as their IEEE 754 bits.
+type Intrinsic func(in *Interp, args []int64) (int64, error)
+
+// ExitError is returned when the program calls exit.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// Interp executes the functions of a compilation unit. Memory is a set of
+// objects: the data of the unit, the stack slots of the functions being
+// executed, and what's allocated by calloc and malloc. An address holds the
+// number of its object in the upper 32 bits and the offset in the lower 32
+// bits, so address arithmetic stays within an object, and accesses outside of
+// it, or to an object that's gone, are caught. Functions are objects without
+// data, so their addresses can be called.
+type Interp struct {
+	funcs      map[ir.Ident]*ir.FuncDef
+	byAddr     map[int64]*ir.FuncDef
+	intrinsics map[ir.Ident]Intrinsic
+	globals    map[ir.Ident]int64
+	objects    map[int64][]byte
+	next       int64 // number of the next object
+	stdout     io.Writer
+	maxSteps   int
+	steps      int
+}
+
+// New returns an interpreter for unit, with its data laid out in memory and
+// the default intrinsics: printf, puts, putchar, calloc, malloc, free and exit.
+func New(unit *ir.CompilationUnit) (*Interp, error) {
+	in := &Interp{
+		funcs:      make(map[ir.Ident]*ir.FuncDef),
+		byAddr:     make(map[int64]*ir.FuncDef),
+		intrinsics: make(map[ir.Ident]Intrinsic),
+		globals:    make(map[ir.Ident]int64),
+		objects:    make(map[int64][]byte),
+		next:       1, // the null pointer doesn't point to an object
+		stdout:     os.Stdout,
+	}
+
+	for name, fn := range intrinsics {
+		in.intrinsics[name] = fn
+	}
+
+	for i := range unit.FuncDefs {
+		fd := &unit.FuncDefs[i]
+		addr := in.newObject(0)
+
+		in.funcs[fd.Ident] = fd
+		in.byAddr[addr] = fd
+		in.globals[fd.Ident] = addr
+
+		// Calls use the link name of a function, if it has one.
+		if fd.LinkName != "" {
+			in.funcs[fd.LinkName] = fd
+			in.globals[fd.LinkName] = addr
+		}
+	}
+
+	// Data can refer to other data, so all of it is placed before any of it
+	// is initialized.
+	for _, dd := range unit.DataDefs {
+		in.globals[dd.Ident] = in.newObject(dd.Size())
+	}
+
+	for _, dd := range unit.DataDefs {
+		if err := in.initData(dd); err != nil {
+			return nil, err
+		}
+	}
+
+	return in, nil
+}
+
+// WithStdout sets where the program's output goes.
+func (in *Interp) WithStdout(w io.Writer) *Interp {
+	in.stdout = w
+	return in
+}
+
+// WithIntrinsic implements the function name, which the unit declares but
+// doesn't define, by fn. It replaces a default intrinsic of the same name.
+func (in *Interp) WithIntrinsic(name ir.Ident, fn Intrinsic) *Interp {
+	in.intrinsics[name] = fn
+	return in
+}
+
+// WithMaxSteps limits the number of instructions executed, so a program that
+// doesn't end is stopped. The default of 0 doesn't limit them.
+func (in *Interp) WithMaxSteps(n int) *Interp {
+	in.maxSteps = n
+	return in
+}
+
+// Stdout returns where the program's output goes, for intrinsics.
+func (in *Interp) Stdout() io.Writer {
+	return in.stdout
+}
+
+// Call calls the function name with args and returns its result, or 0 for a
+// function that doesn't return a value.
+func (in *Interp) Call(name ir.Ident, args ...int64) (int64, error) {
+	fd, ok := in.funcs[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown function $%s", name)
+	}
+
+	return in.call(fd, args)
+}
+
+// Run calls main without arguments, like the C runtime does, and returns
+// the exit status of the program: the result of main, or the status passed
+// to exit, truncated to a byte like by the operating system.
+func (in *Interp) Run() (int, error) {
+	ret, err := in.Call("main")
+
+	var exit *ExitError
+	if errors.As(err, &exit) {
+		return exit.Code & 0xff, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int(ret) & 0xff, nil
+}
+
+// Alloc allocates an object of size zeroed bytes and returns its address.
+func (in *Interp) Alloc(size int64) (int64, error) {
+	if size < 0 || size > math.MaxUint32 {
+		return 0, fmt.Errorf("can't allocate %d bytes", size)
+	}
+
+	return in.newObject(int(size)), nil
+}
+
+// Free releases the object at addr.
+func (in *Interp) Free(addr int64) error {
+	if addr == 0 {
+		return nil
+	}
+
+	if _, ok := in.objects[addr>>32]; !ok || int32(addr) != 0 {
+		return fmt.Errorf("free of invalid pointer %#x", addr)
+	}
+
+	delete(in.objects, addr>>32)
+
+	return nil
+}
+
+// Bytes returns the n bytes at addr. Changing them changes memory.
+func (in *Interp) Bytes(addr, n int64) ([]byte, error) {
+	obj, ok := in.objects[addr>>32]
+	if !ok {
+		return nil, fmt.Errorf("access to invalid address %#x", addr)
+	}
+
+	off := int64(uint32(addr))
+	if n < 0 || off+n > int64(len(obj)) {
+		return nil, fmt.Errorf("access of %d bytes at %#x is out of bounds", n, addr)
+	}
+
+	return obj[off : off+n], nil
+}
+
+// String returns the zero-terminated string at addr.
+func (in *Interp) String(addr int64) (string, error) {
+	obj, ok := in.objects[addr>>32]
+	if !ok {
+		return "", fmt.Errorf("access to invalid address %#x", addr)
+	}
+
+	for i := int(uint32(addr)); i < len(obj); i++ {
+		if obj[i] == 0 {
+			return string(obj[uint32(addr):i]), nil
+		}
+	}
+
+	return "", fmt.Errorf("string at %#x isn't terminated", addr)
+}
+
+func (in *Interp) newObject(size int) int64 {
+	n := in.next
+	in.next++
+	in.objects[n] = make([]byte, size)
+
+	return n << 32
+}
+
+// frame is the state of a function being executed.
+type frame struct {
+	values map[ir.Ident]int64
+	allocs []int64 // stack slots, freed when the function returns
+}
+
+func (in *Interp) call(fd *ir.FuncDef, args []int64) (int64, error) {
+	if fd.Blocks == nil {
+		name := fd.Ident
+		if fd.LinkName != "" {
+			name = fd.LinkName
+		}
+
+		fn, ok := in.intrinsics[name]
+		if !ok {
+			return 0, diag.Errorf(fd.Loc, "function $%s isn't defined", name)
+		}
+
+		return fn(in, args)
+	}
+
+	f := &frame{values: make(map[ir.Ident]int64)}
+
+	defer func() {
+		for _, addr := range f.allocs {
+			delete(in.objects, addr>>32)
+		}
+	}()
+
+	var regular int
+
+	for _, param := range fd.Params {
+		if param.Type == ir.ParamVariadic {
+			return 0, diag.Errorf(param.Loc, "variadic function $%s can't be interpreted", fd.Ident)
+		}
+
+		if regular == len(args) {
+			return 0, diag.Errorf(fd.Loc, "function $%s called with %d arguments", fd.Ident, len(args))
+		}
+
+		f.values[param.Ident] = normalize(args[regular], param.AbiTy)
+		regular++
+	}
+
+	blocks := make(map[string]*ir.Block)
+	for i := range fd.Blocks {
+		blocks[fd.Blocks[i].Label] = &fd.Blocks[i]
+	}
+
+	block, prev := &fd.Blocks[0], ""
+
+	for {
+		next, ret, done, err := in.execBlock(f, block, prev)
+		if err != nil || done {
+			return ret, err
+		}
+
+		target, ok := blocks[next]
+		if !ok {
+			return 0, diag.Errorf(block.Loc, "jump to unknown block @%s", next)
+		}
+
+		block, prev = target, block.Label
+	}
+}
+
+// execBlock executes block, entered from the block labeled prev. It returns
+// the label of the block to continue with, or the result of the function if
+// done.
+func (in *Interp) execBlock(f *frame, block *ir.Block, prev string) (next string, ret int64, done bool, err error) {
+	// Phis select their values at the same time, as if on the edge from prev.
+	phis := make(map[ir.Ident]int64)
+
+	for _, instr := range block.Instructions {
+		phi, ok := instr.(*ir.Phi)
+		if !ok {
+			break
+		}
+
+		found := false
+
+		for _, arg := range phi.Args {
+			if arg.Label == prev {
+				if phis[phi.Ret.Ident], err = in.value(f, arg.Val); err != nil {
+					return "", 0, false, err
+				}
+
+				found = true
+			}
+		}
+
+		if !found {
+			return "", 0, false, diag.Errorf(phi.Loc, "phi %%%s has no value for block @%s", phi.Ret.Ident, prev)
+		}
+	}
+
+	for ident, val := range phis {
+		f.values[ident] = val
+	}
+
+	for _, instr := range block.Instructions[len(phis):] {
+		if in.maxSteps > 0 {
+			in.steps++
+
+			if in.steps > in.maxSteps {
+				return "", 0, false, diag.Errorf(instr.Location(), "more than %d instructions executed", in.maxSteps)
+			}
+		}
+
+		switch instr := instr.(type) {
+		case *ir.Jmp:
+			return instr.Label, 0, false, nil
+		case *ir.Jnz:
+			cond, err := in.value(f, instr.Cond)
+			if err != nil {
+				return "", 0, false, err
+			}
+
+			if int32(cond) != 0 {
+				return instr.True, 0, false, nil
+			}
+
+			return instr.False, 0, false, nil
+		case *ir.Ret:
+			if instr.Val == nil {
+				return "", 0, true, nil
+			}
+
+			ret, err := in.value(f, instr.Val)
+
+			return "", ret, true, err
+		case *ir.Hlt:
+			return "", 0, false, diag.Errorf(instr.Loc, "program halted")
+		default:
+			if err := in.exec(f, instr); err != nil {
+				return "", 0, false, err
+			}
+		}
+	}
+
+	return "", 0, false, diag.Errorf(block.Loc, "block @%s doesn't end with a jump or return", block.Label)
+}
+
+// exec executes an instruction that isn't a terminator.
+func (in *Interp) exec(f *frame, instr ir.Instruction) error {
+	switch instr := instr.(type) {
+	case *ir.Binop:
+		x, err := in.value(f, instr.Lhs)
+		if err != nil {
+			return err
+		}
+
+		y, err := in.value(f, instr.Rhs)
+		if err != nil {
+			return err
+		}
+
+		r, err := binop(instr, x, y)
+		if err != nil {
+			return err
+		}
+
+		f.values[instr.Ret.Ident] = r
+	case *ir.Convert:
+		x, err := in.value(f, instr.Val)
+		if err != nil {
+			return err
+		}
+
+		f.values[instr.Ret.Ident] = convert(instr.Ret.AbiTy, instr.Val.AbiTy, x)
+	case *ir.Alloc:
+		size, err := in.value(f, instr.Size)
+		if err != nil {
+			return err
+		}
+
+		addr, err := in.Alloc(size)
+		if err != nil {
+			return diag.Errorf(instr.Loc, "%v", err)
+		}
+
+		f.allocs = append(f.allocs, addr)
+		f.values[instr.Ret.Ident] = addr
+	case *ir.Load:
+		addr, err := in.value(f, instr.Addr)
+		if err != nil {
+			return err
+		}
+
+		b, err := in.Bytes(addr, int64(memSize(instr.Ty)))
+		if err != nil {
+			return diag.Errorf(instr.Loc, "%v", err)
+		}
+
+		f.values[instr.Ret.Ident] = normalize(load(b, instr.Ty), instr.Ret.AbiTy)
+	case *ir.Store:
+		addr, err := in.value(f, instr.Addr)
+		if err != nil {
+			return err
+		}
+
+		val, err := in.value(f, instr.Val)
+		if err != nil {
+			return err
+		}
+
+		b, err := in.Bytes(addr, int64(memSize(instr.Ty)))
+		if err != nil {
+			return diag.Errorf(instr.Loc, "%v", err)
+		}
+
+		store(b, val)
+	case *ir.Call:
+		return in.execCall(f, instr)
+	default:
+		return diag.Errorf(instr.Location(), "can't interpret %T", instr)
+	}
+
+	return nil
+}
+
+func (in *Interp) execCall(f *frame, c *ir.Call) error {
+	addr, err := in.value(f, c.Val)
+	if err != nil {
+		return err
+	}
+
+	callee, ok := in.byAddr[addr]
+	if !ok {
+		return diag.Errorf(c.Loc, "call of %#x, which isn't a function", addr)
+	}
+
+	var args []int64
+
+	for _, arg := range c.Args {
+		if arg.Type == ir.ArgVariadic {
+			continue
+		}
+
+		val, err := in.value(f, arg.Val)
+		if err != nil {
+			return err
+		}
+
+		args = append(args, val)
+	}
+
+	r, err := in.call(callee, args)
+	if err != nil {
+		return err
+	}
+
+	if c.LHS != nil {
+		ty := ir.NewAbiTyBase(ir.BaseWord)
+		if c.RetTy != nil {
+			ty = *c.RetTy
+		}
+
+		f.values[*c.LHS] = normalize(r, ty)
+	}
+
+	return nil
+}
+
+// value returns the value of an operand.
+func (in *Interp) value(f *frame, val *ir.Val) (int64, error) {
+	if val.Type == ir.ValIdent {
+		v, ok := f.values[val.Ident]
+		if !ok {
+			return 0, diag.Errorf(val.Loc, "temporary %%%s has no value", val.Ident)
+		}
+
+		return v, nil
+	}
+
+	if val.DynConst.Type != ir.DynConstConst {
+		return 0, diag.Errorf(val.Loc, "thread-local $%s can't be interpreted", val.DynConst.Ident)
+	}
+
+	c := val.DynConst.Const
+
+	switch c.Type {
+	case ir.ConstInteger:
+		return normalize(c.I64, val.AbiTy), nil
+	case ir.ConstSingle:
+		return int64(math.Float32bits(c.F32)), nil
+	case ir.ConstDouble:
+		return int64(math.Float64bits(c.F64)), nil
+	default:
+		addr, ok := in.globals[c.Ident]
+		if !ok {
+			return 0, diag.Errorf(val.Loc, "unknown symbol $%s", c.Ident)
+		}
+
+		return addr, nil
+	}
+}
+
+func (in *Interp) initData(dd ir.DataDef) error {
+	obj := in.objects[in.globals[dd.Ident]>>32]
+	off := 0
+
+	for _, init := range dd.Initializer {
+		if init.Type == ir.DataInitZero {
+			off += init.Size
+
+			continue
+		}
+
+		for _, item := range init.Items {
+			var val int64
+
+			switch item.Type {
+			case ir.DataItemString:
+				off += copy(obj[off:], ir.Unescape(item.StringVal))
+
+				continue
+			case ir.DataItemSymbol:
+				addr, ok := in.globals[item.Ident]
+				if !ok {
+					return diag.Errorf(item.Loc, "unknown symbol $%s in data $%s", item.Ident, dd.Ident)
+				}
+
+				val = addr + int64(item.Offset)
+			default:
+				switch item.Const.Type {
+				case ir.ConstSingle:
+					val = int64(math.Float32bits(item.Const.F32))
+				case ir.ConstDouble:
+					val = int64(math.Float64bits(item.Const.F64))
+				default:
+					val = item.Const.I64
+				}
+			}
+
+			size := init.ExtTy.Size()
+			store(obj[off:off+size], val)
+			off += size
+		}
+	}
+
+	return nil
+}
+
+// memSize returns the number of bytes a value of type ty takes in memory.
+func memSize(ty ir.AbiTy) int {
+	if ty.Type == ir.AbiTySubW {
+		switch ty.SubWTy {
+		case ir.SubWSB, ir.SubWUB:
+			return 1
+		case ir.SubWSH, ir.SubWUH:
+			return 2
+		default:
+			return 4
+		}
+	}
+
+	switch ty.BaseTy {
+	case ir.BaseLong, ir.BaseDouble:
+		return 8
+	default:
+		return 4
+	}
+}
+
+// load reads a value of type ty from b, extending sub-word values.
+func load(b []byte, ty ir.AbiTy) int64 {
+	switch len(b) {
+	case 1:
+		if ty.SubWTy == ir.SubWSB {
+			return int64(int8(b[0]))
+		}
+
+		return int64(b[0])
+	case 2:
+		if ty.SubWTy == ir.SubWSH {
+			return int64(int16(binary.LittleEndian.Uint16(b)))
+		}
+
+		return int64(binary.LittleEndian.Uint16(b))
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(b)))
+	default:
+		return int64(binary.LittleEndian.Uint64(b))
+	}
+}
+
+// store writes the low bytes of val to b.
+func store(b []byte, val int64) {
+	for i := range b {
+		b[i] = byte(val >> (8 * i))
+	}
+}
+
+// normalize returns val as a value of type ty: words are sign-extended, and
+// singles keep their 32 bits.
+func normalize(val int64, ty ir.AbiTy) int64 {
+	if ty.Type != ir.AbiTyBase {
+		return int64(int32(val))
+	}
+
+	switch ty.BaseTy {
+	case ir.BaseWord:
+		return int64(int32(val))
+	case ir.BaseSingle:
+		return int64(uint32(val))
+	default:
+		return val
+	}
+}
+
+func binop(b *ir.Binop, x, y int64) (int64, error) {
+	if b.Lhs.AbiTy.IsFloat() {
+		return floatBinop(b, x, y)
+	}
+
+	ty := b.Ret.AbiTy
+	if b.Op.IsComparison() {
+		ty = b.Lhs.AbiTy
+	}
+
+	r, ok := ir.EvalBinop(b.Op, ty, x, y)
+	if !ok {
+		if b.Op == ir.BinOpDiv || b.Op == ir.BinOpMod || b.Op == ir.BinOpUDiv || b.Op == ir.BinOpUMod {
+			return 0, diag.Errorf(b.Loc, "division by zero")
+		}
+
+		return 0, diag.Errorf(b.Loc, "can't interpret %s", b.Op)
+	}
+
+	return normalize(r, b.Ret.AbiTy), nil
+}
+
+func floatBinop(b *ir.Binop, x, y int64) (int64, error) {
+	fx, fy := toFloat(x, b.Lhs.AbiTy), toFloat(y, b.Rhs.AbiTy)
+
+	var (
+		r   float64
+		cmp bool
+	)
+
+	switch b.Op {
+	case ir.BinOpAdd:
+		r = fx + fy
+	case ir.BinOpSub:
+		r = fx - fy
+	case ir.BinOpMul:
+		r = fx * fy
+	case ir.BinOpDiv:
+		r = fx / fy
+	case ir.BinOpEq:
+		cmp = fx == fy
+	case ir.BinOpNe:
+		cmp = fx != fy
+	case ir.BinOpLt:
+		cmp = fx < fy
+	case ir.BinOpLe:
+		cmp = fx <= fy
+	case ir.BinOpGt:
+		cmp = fx > fy
+	case ir.BinOpGe:
+		cmp = fx >= fy
+	default:
+		return 0, diag.Errorf(b.Loc, "%s of floating point operands", b.Op)
+	}
+
+	if !b.Op.IsComparison() {
+		return fromFloat(r, b.Ret.AbiTy), nil
+	}
+
+	if cmp {
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// convert converts val of type from to type to, like the code generator's
+// conversions: words are sign-extended, and integers convert to and from
+// floating point as signed.
+func convert(to, from ir.AbiTy, val int64) int64 {
+	switch {
+	case from.IsFloat() && to.IsFloat():
+		return fromFloat(toFloat(val, from), to)
+	case from.IsFloat():
+		return normalize(int64(toFloat(val, from)), to)
+	case to.IsFloat():
+		return fromFloat(float64(val), to)
+	case from.Type == ir.AbiTySubW:
+		return normalize(load(binary.LittleEndian.AppendUint32(nil, uint32(val))[:memSize(from)], from), to)
+	default:
+		return normalize(val, to)
+	}
+}
+
+func toFloat(bits int64, ty ir.AbiTy) float64 {
+	if ty.BaseTy == ir.BaseSingle {
+		return float64(math.Float32frombits(uint32(bits)))
+	}
+
+	return math.Float64frombits(uint64(bits))
+}
+
+func fromFloat(f float64, ty ir.AbiTy) int64 {
+	if ty.BaseTy == ir.BaseSingle {
+		return int64(math.Float32bits(float32(f)))
+	}
+
+	return int64(math.Float64bits(f))
+}
@@ -0,0 +1,342 @@
+package interp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/corani/cubit/internal/ir/passes"
+	"github.com/corani/cubit/internal/testutil/irtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterp(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		src      string
+		expected string
+		exit     int64
+	}{
+		{
+			name: "recursion",
+			src: `
+fib :: func(n: int) -> int {
+    if n <= 1 {
+        return n
+    }
+
+    return fib(n - 1) + fib(n - 2)
+}
+
+main :: func() -> int {
+    for n := 1; n <= 5; n = n + 1 {
+        printf("fib(%d) = %2d\n", n, fib(n))
+    }
+
+    return fib(10)
+}`,
+			expected: "fib(1) =  1\nfib(2) =  1\nfib(3) =  2\nfib(4) =  3\nfib(5) =  5\n",
+			exit:     55,
+		},
+		{
+			name: "arrays",
+			src: `
+@(builtin)
+len :: func(row: [4]int) -> int
+
+main :: func() -> int {
+    a := [4]int{}
+    for i := 0; i < 4; i = i + 1 {
+        a[i] = i * i
+    }
+
+    printf("%s: %d %d\n", "squares", a[1], a[3])
+
+    return len(a)
+}`,
+			expected: "squares: 1 9\n",
+			exit:     4,
+		},
+		{
+			name: "array without value",
+			src: `
+main :: func() -> int {
+    a: [10]int
+    for i := 1; i < 10; i = i + 1 {
+        a[i] = i * 2
+    }
+
+    printf("%d %d %d\n", a[0], a[3], a[9])
+
+    return a[9]
+}`,
+			expected: "0 6 18\n",
+			exit:     18,
+		},
+		{
+			name: "pointers",
+			src: `
+@(extern)
+calloc :: func(count: int, size: int) -> ^int
+
+update :: func(a: ^int, value: int) {
+    a^ = value
+    (a+1)^ = value + 1
+}
+
+main :: func() -> int {
+    a := calloc(2, 4)
+    update(a, 34)
+
+    return a^ + (a+1)^
+}`,
+			exit: 69,
+		},
+		{
+			name: "builtins",
+			src: `
+@(extern)
+calloc :: func(count: int, size: int) -> ^int
+
+@(builtin)
+sizeof :: func(x: int) -> int
+
+@(builtin)
+min :: func(a: int, b: int) -> int
+
+@(builtin)
+max :: func(a: int, b: int) -> int
+
+@(builtin)
+copy :: func(dst: ^int, src: ^int, count: int)
+
+@(builtin)
+zero :: func(dst: ^int, count: int)
+
+main :: func() -> int {
+    a := calloc(3, sizeof(0))
+    a^ = 7
+    (a+1)^ = 8
+    (a+2)^ = 9
+
+    b := calloc(3, sizeof(0))
+    copy(b, a, 3)
+    zero(a, 2)
+
+    printf("%d %d %d, %d %d %d\n", a^, (a+1)^, (a+2)^, b^, (b+1)^, (b+2)^)
+
+    return min(3, max(b^, -1)) + max(min(-5, 2), -4)
+}`,
+			expected: "0 0 9, 7 8 9\n",
+			exit:     -1,
+		},
+		{
+			name: "short-circuit",
+			src: `
+check :: func(x: int) -> bool {
+    printf("check %d\n", x)
+    return x > 0
+}
+
+main :: func() -> int {
+    if check(0) && check(1) {
+        return 1
+    }
+
+    if check(2) || check(3) {
+        return 2
+    }
+
+    return 3
+}`,
+			expected: "check 0\ncheck 2\n",
+			exit:     2,
+		},
+		{
+			name: "shadowing",
+			src: `
+main :: func() -> int {
+    x := 1
+    if x == 1 {
+        x := 5
+        printf("if %d\n", x)
+    }
+
+    for i := 0; i < 2; i = i + 1 {
+        x := i + 10
+        printf("for %d\n", x)
+    }
+
+    printf("outer %d\n", x)
+
+    return x
+}`,
+			expected: "if 5\nfor 10\nfor 11\nouter 1\n",
+			exit:     1,
+		},
+		{
+			name: "scope per body",
+			src: `
+main :: func() -> int {
+    c := 2
+    if c == 1 {
+        x := 1
+        printf("then %d\n", x)
+    } else {
+        x := 2
+        printf("else %d\n", x)
+    }
+
+    for i := 0; i < 2; i = i + 1 {
+        i := c + 5
+        printf("for %d\n", i)
+    }
+
+    return c
+}`,
+			expected: "else 2\nfor 7\nfor 7\n",
+			exit:     2,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// The program behaves the same before and after optimization.
+			for _, optimize := range []bool{false, true} {
+				unit := irtest.LowerWithPrintf(t, tc.src)
+				if optimize {
+					require.NoError(t, passes.Run(unit, passes.Default(passes.Options{})...))
+				}
+
+				var stdout bytes.Buffer
+
+				in, err := New(unit)
+				require.NoError(t, err)
+
+				exit, err := in.WithStdout(&stdout).Call("main")
+				require.NoError(t, err)
+				require.Equal(t, tc.exit, exit)
+				require.Equal(t, tc.expected, stdout.String())
+			}
+		})
+	}
+}
+
+func TestInterp_Errors(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{
+			name: "out of bounds",
+			src: `
+@(extern)
+calloc :: func(count: int, size: int) -> ^int
+
+main :: func() -> int {
+    a := calloc(2, 4)
+    return (a+2)^
+}`,
+			expected: "test.in:8:17: access of 4 bytes at 0x300000008 is out of bounds",
+		},
+		{
+			name: "division by zero",
+			src: `
+div :: func(x: int, y: int) -> int {
+    return x / y
+}
+
+main :: func() -> int {
+    return div(1, 0)
+}`,
+			expected: "test.in:4:12: division by zero",
+		},
+		{
+			name: "endless loop",
+			src: `
+main :: func() -> int {
+    for i := 0; i >= 0; i = i * 1 {
+    }
+
+    return 0
+}`,
+			expected: "more than 1000 instructions executed",
+		},
+		{
+			name: "exit",
+			src: `
+@(extern)
+exit :: func(code: int)
+
+main :: func() -> int {
+    exit(3)
+    return 0
+}`,
+			expected: "exit status 3",
+		},
+		{
+			name: "undefined function",
+			src: `
+@(extern)
+abs :: func(x: int) -> int
+
+main :: func() -> int {
+    return abs(-3)
+}`,
+			expected: "test.in:4:1: function $abs isn't defined",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			in, err := New(irtest.Lower(t, "package main\n"+tc.src))
+			require.NoError(t, err)
+
+			_, err = in.WithMaxSteps(1000).Call("main")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expected)
+		})
+	}
+}
+
+func TestInterp_Run(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		src      string
+		expected int
+	}{
+		{
+			name:     "result of main",
+			src:      "main :: func() -> int {\n    return 300\n}",
+			expected: 44,
+		},
+		{
+			name:     "exit",
+			src:      "@(extern)\nexit :: func(code: int)\n\nmain :: func() -> int {\n    exit(3)\n    return 0\n}",
+			expected: 3,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			in, err := New(irtest.Lower(t, "package main\n\n"+tc.src))
+			require.NoError(t, err)
+
+			status, err := in.Run()
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, status)
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/corani/cubit/internal/ir"
+)
+
+// intrinsics are the functions of the C library an interpreter implements by
+// default.
+var intrinsics = map[ir.Ident]Intrinsic{
+	"printf":  printf,
+	"puts":    puts,
+	"putchar": putchar,
+	"calloc":  calloc,
+	"malloc":  malloc,
+	"free":    free,
+	"exit":    exit,
+}
+
+func printf(in *Interp, args []int64) (int64, error) {
+	if len(args) == 0 {
+		return 0, fmt.Errorf("printf: missing format")
+	}
+
+	format, err := in.String(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("printf: %w", err)
+	}
+
+	s, err := Format(format, args[1:], in.String)
+	if err != nil {
+		return 0, fmt.Errorf("printf: %w", err)
+	}
+
+	n, err := io.WriteString(in.stdout, s)
+
+	return int64(n), err
+}
+
+func puts(in *Interp, args []int64) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("puts: expected 1 argument, got %d", len(args))
+	}
+
+	s, err := in.String(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("puts: %w", err)
+	}
+
+	_, err = io.WriteString(in.stdout, s+"\n")
+
+	return 0, err
+}
+
+func putchar(in *Interp, args []int64) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("putchar: expected 1 argument, got %d", len(args))
+	}
+
+	_, err := in.stdout.Write([]byte{byte(args[0])})
+
+	return int64(byte(args[0])), err
+}
+
+func calloc(in *Interp, args []int64) (int64, error) {
+	if len(args) != 2 {
+		return 0, fmt.Errorf("calloc: expected 2 arguments, got %d", len(args))
+	}
+
+	return in.Alloc(args[0] * args[1])
+}
+
+func malloc(in *Interp, args []int64) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("malloc: expected 1 argument, got %d", len(args))
+	}
+
+	return in.Alloc(args[0])
+}
+
+func free(in *Interp, args []int64) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("free: expected 1 argument, got %d", len(args))
+	}
+
+	return 0, in.Free(args[0])
+}
+
+func exit(_ *Interp, args []int64) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("exit: expected 1 argument, got %d", len(args))
+	}
+
+	return 0, &ExitError{Code: int(int32(args[0]))}
+}
+
+// Format formats args like printf. Flags, width and precision are passed on to
+// fmt, which interprets them the same way; length modifiers decide the width of
+// integer arguments. str reads the string at an address for %s.
+func Format(format string, args []int64, str func(addr int64) (string, error)) (string, error) {
+	var sb strings.Builder
+
+	next := func() (int64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("too few arguments for %q", format)
+		}
+
+		arg := args[0]
+		args = args[1:]
+
+		return arg, nil
+	}
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+
+			continue
+		}
+
+		start := i
+		i++
+
+		for i < len(format) && strings.IndexByte("-+ #0123456789.", format[i]) >= 0 {
+			i++
+		}
+
+		spec := "%" + format[start+1:i]
+
+		long := false
+		for i < len(format) && strings.IndexByte("hlLqjzt", format[i]) >= 0 {
+			long = long || format[i] != 'h'
+			i++
+		}
+
+		if i == len(format) {
+			return "", fmt.Errorf("incomplete conversion in %q", format)
+		}
+
+		verb := format[i]
+		if verb == '%' {
+			sb.WriteByte('%')
+
+			continue
+		}
+
+		arg, err := next()
+		if err != nil {
+			return "", err
+		}
+
+		switch verb {
+		case 'd', 'i':
+			if !long {
+				arg = int64(int32(arg))
+			}
+
+			fmt.Fprintf(&sb, spec+"d", arg)
+		case 'u', 'x', 'X', 'o':
+			if verb == 'u' {
+				verb = 'd'
+			}
+
+			if long {
+				fmt.Fprintf(&sb, spec+string(verb), uint64(arg))
+			} else {
+				fmt.Fprintf(&sb, spec+string(verb), uint32(arg))
+			}
+		case 'c':
+			fmt.Fprintf(&sb, spec+"c", rune(byte(arg)))
+		case 's':
+			s, err := str(arg)
+			if err != nil {
+				return "", err
+			}
+
+			fmt.Fprintf(&sb, spec+"s", s)
+		case 'p':
+			fmt.Fprintf(&sb, spec+"s", fmt.Sprintf("%#x", uint64(arg)))
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			// Floating point arguments are promoted to double.
+			fmt.Fprintf(&sb, spec+string(verb), math.Float64frombits(uint64(arg)))
+		default:
+			return "", fmt.Errorf("unsupported conversion %%%c in %q", verb, format)
+		}
+	}
+
+	return sb.String(), nil
+}
@@ -1,6 +1,11 @@
 package ir
 
-import "github.com/corani/cubit/internal/lexer"
+import (
+	"slices"
+	"strconv"
+
+	"github.com/corani/cubit/internal/lexer"
+)
 
 // Visitor defines the visitor interface for SSA code generation.
 type Visitor interface {
@@ -8,8 +13,8 @@ type Visitor interface {
 	VisitTypeDef(*TypeDef) string
 	VisitDataDef(*DataDef) string
 	VisitFuncDef(*FuncDef) string
-	VisitLabel(*Label) string
 	VisitRet(*Ret) string
+	VisitHlt(*Hlt) string
 	VisitCall(*Call) string
 	VisitBinop(*Binop) string
 	VisitJmp(*Jmp) string
@@ -18,6 +23,7 @@ type Visitor interface {
 	VisitStore(*Store) string
 	VisitConvert(*Convert) string
 	VisitAlloc(*Alloc) string
+	VisitPhi(*Phi) string
 }
 
 type CompilationUnit struct {
@@ -26,6 +32,9 @@ type CompilationUnit struct {
 	Types    []TypeDef
 	DataDefs []DataDef
 	FuncDefs []FuncDef
+	// Libraries are linked into the program, from the link attributes of the
+	// package and its extern functions.
+	Libraries []string
 }
 
 // Accept implements the classic visitor pattern for CompilationUnit.
@@ -66,6 +75,18 @@ func (cu *CompilationUnit) WithFuncDefs(funcDefs ...FuncDef) *CompilationUnit {
 	return cu
 }
 
+// WithExterns declares the functions of the C library that the code a pass
+// adds calls, unless the unit declares or defines them already.
+func (cu *CompilationUnit) WithExterns(loc lexer.Location, idents ...Ident) *CompilationUnit {
+	for _, ident := range idents {
+		if !slices.ContainsFunc(cu.FuncDefs, func(fd FuncDef) bool { return fd.Ident == ident || fd.LinkName == ident }) {
+			cu.FuncDefs = append(cu.FuncDefs, NewFuncDef(loc, ident))
+		}
+	}
+
+	return cu
+}
+
 type (
 	Ident  string
 	BaseTy string
@@ -80,6 +101,20 @@ const (
 
 type ExtTy string
 
+// Size returns the number of bytes an item of the type takes in data.
+func (e ExtTy) Size() int {
+	switch e {
+	case ExtByte:
+		return 1
+	case ExtHalf:
+		return 2
+	case ExtWord, ExtSingle:
+		return 4
+	default:
+		return 8
+	}
+}
+
 const (
 	ExtByte   = ExtTy("b")
 	ExtHalf   = ExtTy("h")
@@ -212,6 +247,14 @@ func NewValInteger(loc lexer.Location, i int64, abiTy AbiTy) *Val {
 	return NewValDynConst(loc, NewDynConst(loc, NewConstInteger(loc, i)), abiTy)
 }
 
+func NewValSingle(loc lexer.Location, f float32) *Val {
+	return NewValDynConst(loc, NewDynConst(loc, NewConstSingle(loc, f)), NewAbiTyBase(BaseSingle))
+}
+
+func NewValDouble(loc lexer.Location, f float64) *Val {
+	return NewValDynConst(loc, NewDynConst(loc, NewConstDouble(loc, f)), NewAbiTyBase(BaseDouble))
+}
+
 func NewValIdent(loc lexer.Location, ident Ident, abiTy AbiTy) *Val {
 	return &Val{
 		Loc:   loc,
@@ -221,6 +264,46 @@ func NewValIdent(loc lexer.Location, ident Ident, abiTy AbiTy) *Val {
 	}
 }
 
+// Equal reports whether two values are the same temporary or constant. Their
+// types and locations don't matter.
+func (v *Val) Equal(other *Val) bool {
+	if v.Type != other.Type {
+		return false
+	}
+
+	if v.Type == ValIdent {
+		return v.Ident == other.Ident
+	}
+
+	x, y := v.DynConst, other.DynConst
+
+	return x.Type == y.Type && x.Ident == y.Ident && x.Const.Type == y.Const.Type &&
+		x.Const.I64 == y.Const.I64 && x.Const.F32 == y.Const.F32 && x.Const.F64 == y.Const.F64 &&
+		x.Const.Ident == y.Const.Ident
+}
+
+// IntConst returns the value of an integer constant.
+func (v *Val) IntConst() (int64, bool) {
+	if v.Type != ValDynConst || v.DynConst.Type != DynConstConst || v.DynConst.Const.Type != ConstInteger {
+		return 0, false
+	}
+
+	return v.DynConst.Const.I64, true
+}
+
+// WithAbiTy returns the value with the given type. A value that replaces a
+// temporary takes its type, which decides e.g. the type of a call argument.
+func (v *Val) WithAbiTy(abiTy AbiTy) *Val {
+	if v.AbiTy == abiTy {
+		return v
+	}
+
+	copied := *v
+	copied.AbiTy = abiTy
+
+	return &copied
+}
+
 type Linkage struct {
 	Loc      lexer.Location
 	Type     LinkageType
@@ -323,6 +406,39 @@ func (dd DataDef) WithAlign(align int) DataDef {
 	return dd
 }
 
+// Size returns the number of bytes the data takes.
+func (dd DataDef) Size() int {
+	var size int
+
+	for _, init := range dd.Initializer {
+		if init.Type == DataInitZero {
+			size += init.Size
+
+			continue
+		}
+
+		for _, item := range init.Items {
+			if item.Type == DataItemString {
+				size += len(Unescape(item.StringVal))
+			} else {
+				size += init.ExtTy.Size()
+			}
+		}
+	}
+
+	return size
+}
+
+// Unescape returns the bytes of a string in data. Its escape sequences, like
+// \n, are interpreted by the assembler.
+func Unescape(s string) string {
+	if unquoted, err := strconv.Unquote(`"` + s + `"`); err == nil {
+		return unquoted
+	}
+
+	return s
+}
+
 type DataInit struct {
 	Loc   lexer.Location
 	Type  DataInitType
@@ -388,6 +504,15 @@ const (
 	DataItemConst  DataItemType = "const"
 )
 
+// CallConv is the calling convention of a function.
+type CallConv string
+
+const (
+	CallConvC         CallConv = ""          // the C ABI of the target
+	CallConvNaked     CallConv = "naked"     // no prologue and epilogue
+	CallConvInterrupt CallConv = "interrupt" // saves every register, returns from an interrupt
+)
+
 type FuncDef struct {
 	Loc      lexer.Location
 	Linkage  *Linkage
@@ -396,6 +521,8 @@ type FuncDef struct {
 	LinkName Ident
 	Params   []*Param
 	Blocks   []Block
+	Inline   bool     // marked @(inline): inline calls regardless of size
+	CallConv CallConv // from @(callconv), only native backends support others than C
 }
 
 func NewFuncDef(loc lexer.Location, ident Ident, params ...*Param) FuncDef {
@@ -467,6 +594,11 @@ func NewAbiTyIdent(ident Ident) AbiTy {
 	return AbiTy{Type: AbiTyIdent, Ident: ident}
 }
 
+// IsFloat reports whether the type is a floating point type.
+func (a AbiTy) IsFloat() bool {
+	return a.Type == AbiTyBase && (a.BaseTy == BaseSingle || a.BaseTy == BaseDouble)
+}
+
 type AbiTyType string
 
 const (
@@ -484,6 +616,8 @@ const (
 	SubWUH SubWTy = "uh"
 )
 
+// Block is a basic block: a sequence of instructions that's only entered at
+// the top, and left by the terminator at the bottom.
 type Block struct {
 	Loc          lexer.Location
 	Label        string
@@ -505,9 +639,51 @@ type Instruction interface {
 	Location() lexer.Location
 }
 
+// Operands returns pointers to the values an instruction uses, so passes can
+// inspect and replace them.
+func Operands(instr Instruction) []**Val {
+	var ops []**Val
+
+	switch instr := instr.(type) {
+	case *Ret:
+		ops = append(ops, &instr.Val)
+	case *Call:
+		ops = append(ops, &instr.Val)
+		for i := range instr.Args {
+			ops = append(ops, &instr.Args[i].Val)
+		}
+	case *Binop:
+		ops = append(ops, &instr.Lhs, &instr.Rhs)
+	case *Jnz:
+		ops = append(ops, &instr.Cond)
+	case *Load:
+		ops = append(ops, &instr.Addr)
+	case *Store:
+		ops = append(ops, &instr.Addr, &instr.Val)
+	case *Convert:
+		ops = append(ops, &instr.Val)
+	case *Alloc:
+		ops = append(ops, &instr.Size)
+	case *Phi:
+		for i := range instr.Args {
+			ops = append(ops, &instr.Args[i].Val)
+		}
+	}
+
+	var nonNil []**Val
+
+	for _, op := range ops {
+		if *op != nil {
+			nonNil = append(nonNil, op)
+		}
+	}
+
+	return nonNil
+}
+
 var _ = []Instruction{
-	(*Label)(nil),
 	(*Ret)(nil),
+	(*Hlt)(nil),
 	(*Call)(nil),
 	(*Binop)(nil),
 	(*Jmp)(nil),
@@ -516,26 +692,7 @@ var _ = []Instruction{
 	(*Store)(nil),
 	(*Convert)(nil),
 	(*Alloc)(nil),
-}
-
-// Label represents an SSA label.
-type Label struct {
-	Loc  lexer.Location
-	Name string
-}
-
-func NewLabel(loc lexer.Location, name string) *Label {
-	return &Label{Loc: loc, Name: name}
-}
-
-func (l *Label) isInstruction() {}
-
-func (l *Label) Accept(visitor Visitor) string {
-	return visitor.VisitLabel(l)
-}
-
-func (l *Label) Location() lexer.Location {
-	return l.Loc
+	(*Phi)(nil),
 }
 
 // Ret represents an SSA return instruction.
@@ -560,12 +717,32 @@ func NewRet(loc lexer.Location, val ...*Val) *Ret {
 	}
 
 	if len(val) == 0 {
-		return &Ret{}
+		return &Ret{Loc: loc}
 	}
 
 	return &Ret{Loc: loc, Val: val[0]}
 }
 
+// Hlt represents an SSA halt instruction. It terminates the program and marks
+// code that can't be reached, e.g. after a call to a noreturn function.
+type Hlt struct {
+	Loc lexer.Location
+}
+
+func NewHlt(loc lexer.Location) *Hlt {
+	return &Hlt{Loc: loc}
+}
+
+func (h *Hlt) isInstruction() {}
+
+func (h *Hlt) Accept(visitor Visitor) string {
+	return visitor.VisitHlt(h)
+}
+
+func (h *Hlt) Location() lexer.Location {
+	return h.Loc
+}
+
 // Call represents an SSA call instruction.
 type Call struct {
 	Loc   lexer.Location
@@ -596,27 +773,67 @@ func (c *Call) WithRet(lhs Ident, retTy AbiTy) *Call {
 	return c
 }
 
-// BinOpKind represents the kind of binary operation.
+// BinOpKind represents the kind of binary operation. Division, remainder and
+// ordered comparisons treat their operands as signed; their U variants treat
+// them as unsigned.
 type BinOpKind string
 
 const (
-	BinOpAdd BinOpKind = "add"
-	BinOpSub BinOpKind = "sub"
-	BinOpMul BinOpKind = "mul"
-	BinOpDiv BinOpKind = "div"
-	BinOpMod BinOpKind = "mod"
-	BinOpEq  BinOpKind = "eq"
-	BinOpNe  BinOpKind = "ne"
-	BinOpLt  BinOpKind = "lt"
-	BinOpLe  BinOpKind = "le"
-	BinOpGt  BinOpKind = "gt"
-	BinOpGe  BinOpKind = "ge"
-	BinOpShl BinOpKind = "shl"
-	BinOpShr BinOpKind = "shr"
-	BinOpAnd BinOpKind = "and"
-	BinOpOr  BinOpKind = "or"
+	BinOpAdd  BinOpKind = "add"
+	BinOpSub  BinOpKind = "sub"
+	BinOpMul  BinOpKind = "mul"
+	BinOpDiv  BinOpKind = "div"
+	BinOpUDiv BinOpKind = "udiv"
+	BinOpMod  BinOpKind = "mod"
+	BinOpUMod BinOpKind = "umod"
+	BinOpEq   BinOpKind = "eq"
+	BinOpNe   BinOpKind = "ne"
+	BinOpLt   BinOpKind = "lt"
+	BinOpULt  BinOpKind = "ult"
+	BinOpLe   BinOpKind = "le"
+	BinOpULe  BinOpKind = "ule"
+	BinOpGt   BinOpKind = "gt"
+	BinOpUGt  BinOpKind = "ugt"
+	BinOpGe   BinOpKind = "ge"
+	BinOpUGe  BinOpKind = "uge"
+	BinOpShl  BinOpKind = "shl"
+	BinOpShr  BinOpKind = "shr"
+	BinOpAnd  BinOpKind = "and"
+	BinOpOr   BinOpKind = "or"
 )
 
+// IsComparison reports whether the operation compares its operands, giving 1
+// if the comparison holds and 0 otherwise.
+func (op BinOpKind) IsComparison() bool {
+	switch op {
+	case BinOpEq, BinOpNe, BinOpLt, BinOpULt, BinOpLe, BinOpULe, BinOpGt, BinOpUGt, BinOpGe, BinOpUGe:
+		return true
+	default:
+		return false
+	}
+}
+
+// Unsigned returns the variant of the operation that treats its operands as
+// unsigned, or the operation itself if signedness doesn't matter to it.
+func (op BinOpKind) Unsigned() BinOpKind {
+	switch op {
+	case BinOpDiv:
+		return BinOpUDiv
+	case BinOpMod:
+		return BinOpUMod
+	case BinOpLt:
+		return BinOpULt
+	case BinOpLe:
+		return BinOpULe
+	case BinOpGt:
+		return BinOpUGt
+	case BinOpGe:
+		return BinOpUGe
+	default:
+		return op
+	}
+}
+
 // Binop represents an SSA binary operation instruction (add, sub, etc).
 type Binop struct {
 	Loc      lexer.Location
@@ -708,12 +925,20 @@ func (j *Jnz) Location() lexer.Location {
 // Load represents a load from memory (e.g., x = p^)
 type Load struct {
 	Loc  lexer.Location
-	Ret  *Val // destination (SSA temp)
-	Addr *Val // address to load from
+	Ret  *Val  // destination (SSA temp)
+	Addr *Val  // address to load from
+	Ty   AbiTy // type in memory; a sub-word type is extended to the type of Ret
 }
 
 func NewLoad(loc lexer.Location, ret, addr *Val) *Load {
-	return &Load{Loc: loc, Ret: ret, Addr: addr}
+	return &Load{Loc: loc, Ret: ret, Addr: addr, Ty: ret.AbiTy}
+}
+
+// WithTy sets the type of the value in memory, e.g. a byte for a bool.
+func (l *Load) WithTy(ty AbiTy) *Load {
+	l.Ty = ty
+
+	return l
 }
 
 func (l *Load) isInstruction() {}
@@ -729,12 +954,20 @@ func (l *Load) Location() lexer.Location {
 // Store represents a store to memory (e.g., p^ = x)
 type Store struct {
 	Loc  lexer.Location
-	Addr *Val // address to store to
-	Val  *Val // value to store
+	Addr *Val  // address to store to
+	Val  *Val  // value to store
+	Ty   AbiTy // type in memory; a sub-word type keeps the low bits of Val
 }
 
 func NewStore(loc lexer.Location, addr, val *Val) *Store {
-	return &Store{Loc: loc, Addr: addr, Val: val}
+	return &Store{Loc: loc, Addr: addr, Val: val, Ty: val.AbiTy}
+}
+
+// WithTy sets the type of the value in memory, e.g. a byte for a bool.
+func (s *Store) WithTy(ty AbiTy) *Store {
+	s.Ty = ty
+
+	return s
 }
 
 func (s *Store) isInstruction() {}
@@ -768,15 +1001,25 @@ func (c *Convert) Location() lexer.Location {
 	return c.Loc
 }
 
-// Alloc represents stack allocation (e.g., for arrays or structs)
+// Alloc represents stack allocation (e.g., for arrays or structs). The result
+// is the address of the slot.
 type Alloc struct {
-	Loc  lexer.Location
-	Ret  *Val // destination (SSA temp)
-	Size *Val // size in bytes (word or long)
+	Loc   lexer.Location
+	Ret   *Val // destination (SSA temp)
+	Size  *Val // size in bytes (word or long)
+	Align int  // alignment in bytes: 4, 8 or 16
 }
 
+// NewAlloc returns a stack allocation aligned to 8 bytes, which suits every
+// scalar type.
 func NewAlloc(loc lexer.Location, ret, size *Val) *Alloc {
-	return &Alloc{Loc: loc, Ret: ret, Size: size}
+	return &Alloc{Loc: loc, Ret: ret, Size: size, Align: 8}
+}
+
+// WithAlign sets the alignment of the slot.
+func (a *Alloc) WithAlign(align int) *Alloc {
+	a.Align = align
+	return a
 }
 
 func (a *Alloc) isInstruction() {}
@@ -788,3 +1031,59 @@ func (a *Alloc) Accept(visitor Visitor) string {
 func (a *Alloc) Location() lexer.Location {
 	return a.Loc
 }
+
+// Phi selects a value depending on the block control came from. Phis come
+// first in their block, and there's one argument per predecessor.
+type Phi struct {
+	Loc  lexer.Location
+	Ret  *Val // destination (SSA temp)
+	Args []PhiArg
+}
+
+type PhiArg struct {
+	Label string // predecessor block
+	Val   *Val
+}
+
+func NewPhi(loc lexer.Location, ret *Val, args ...PhiArg) *Phi {
+	return &Phi{Loc: loc, Ret: ret, Args: args}
+}
+
+func NewPhiArg(label string, val *Val) PhiArg {
+	return PhiArg{Label: label, Val: val}
+}
+
+// Trivial returns the value a phi always selects, if its arguments are all
+// the same value or the phi itself, and nil otherwise. A phi that only selects
+// itself is never assigned a value, so zero will do.
+func (p *Phi) Trivial() *Val {
+	var same *Val
+
+	for _, arg := range p.Args {
+		if arg.Val.Equal(p.Ret) || (same != nil && arg.Val.Equal(same)) {
+			continue
+		}
+
+		if same != nil {
+			return nil
+		}
+
+		same = arg.Val
+	}
+
+	if same == nil {
+		return NewValInteger(p.Loc, 0, p.Ret.AbiTy)
+	}
+
+	return same.WithAbiTy(p.Ret.AbiTy)
+}
+
+func (p *Phi) isInstruction() {}
+
+func (p *Phi) Accept(visitor Visitor) string {
+	return visitor.VisitPhi(p)
+}
+
+func (p *Phi) Location() lexer.Location {
+	return p.Loc
+}
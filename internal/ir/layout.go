@@ -0,0 +1,120 @@
+package ir
+
+import "fmt"
+
+// Layout is where an aggregate type and its fields are in memory.
+type Layout struct {
+	Size    int
+	Align   int
+	Offsets []int // offset of every field of a regular type, in order
+}
+
+// Layout computes the layout of the type named ident the way QBE does: every
+// field is aligned to its own alignment, and the type to that of its largest
+// field, unless it has an explicit alignment. The size is rounded up to the
+// alignment, so the type can be repeated in an array. The fields of a union
+// all start at offset 0.
+func (cu *CompilationUnit) Layout(ident Ident) (Layout, error) {
+	return cu.layout(ident, make(map[Ident]bool))
+}
+
+func (cu *CompilationUnit) layout(ident Ident, visiting map[Ident]bool) (Layout, error) {
+	var td *TypeDef
+
+	for i := range cu.Types {
+		if cu.Types[i].Ident == ident {
+			td = &cu.Types[i]
+		}
+	}
+
+	if td == nil {
+		return Layout{}, fmt.Errorf("unknown type :%s", ident)
+	}
+
+	if visiting[ident] {
+		return Layout{}, td.Loc.Errorf("type :%s contains itself", ident)
+	}
+
+	visiting[ident] = true
+	defer delete(visiting, ident)
+
+	var l Layout
+
+	// fields lays out a sequence of fields starting at offset 0, and returns
+	// the offsets of the fields and the size they take.
+	fields := func(subs []SubTySize) ([]int, int, error) {
+		var offsets []int
+
+		offset := 0
+
+		for _, sub := range subs {
+			size, align, err := cu.subTyLayout(sub.SubTy, visiting)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			offset = alignUp(offset, align)
+			offsets = append(offsets, offset)
+			offset += size * max(sub.Size, 1)
+			l.Align = max(l.Align, align)
+		}
+
+		return offsets, offset, nil
+	}
+
+	switch td.Type {
+	case TypeDefRegular:
+		offsets, size, err := fields(td.Fields)
+		if err != nil {
+			return Layout{}, err
+		}
+
+		l.Offsets, l.Size = offsets, size
+	case TypeDefUnion:
+		for _, variant := range td.UnionFields {
+			_, size, err := fields(variant)
+			if err != nil {
+				return Layout{}, err
+			}
+
+			l.Size = max(l.Size, size)
+		}
+	case TypeDefOpaque:
+		l.Size = td.OpaqueSize
+	}
+
+	if td.Align > 0 {
+		l.Align = td.Align
+	}
+
+	l.Align = max(l.Align, 1)
+	l.Size = alignUp(l.Size, l.Align)
+
+	return l, nil
+}
+
+// subTyLayout returns the size and alignment of a field type.
+func (cu *CompilationUnit) subTyLayout(st SubTy, visiting map[Ident]bool) (int, int, error) {
+	if st.Type == SubTyIdent {
+		l, err := cu.layout(st.Ident, visiting)
+
+		return l.Size, l.Align, err
+	}
+
+	switch st.ExtTy {
+	case ExtByte:
+		return 1, 1, nil
+	case ExtHalf:
+		return 2, 2, nil
+	case ExtWord, ExtSingle:
+		return 4, 4, nil
+	case ExtLong, ExtDouble:
+		return 8, 8, nil
+	default:
+		return 0, 0, fmt.Errorf("unknown field type %s", st.ExtTy)
+	}
+}
+
+func alignUp(offset, align int) int {
+	return (offset + align - 1) / align * align
+}
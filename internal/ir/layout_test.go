@@ -0,0 +1,54 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompilationUnit_Layout(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 5}
+
+	unit := NewCompilationUnit().WithTypes(
+		// { b, l, h 3 }
+		NewTypeDefRegular(loc, "mixed",
+			NewSubTyExtSize(ExtByte, 1), NewSubTyExtSize(ExtLong, 1), NewSubTyExtSize(ExtHalf, 3)),
+		// { w, :mixed }
+		NewTypeDefRegular(loc, "nested", NewSubTyExtSize(ExtWord, 1), NewSubTyIdentSize("mixed", 1)),
+		NewTypeDefRegular(loc, "aligned", NewSubTyExtSize(ExtByte, 1)).WithAlign(16),
+		NewTypeDefUnion(loc, "union",
+			[]SubTySize{NewSubTyExtSize(ExtByte, 5)}, []SubTySize{NewSubTyExtSize(ExtWord, 1)}),
+		NewTypeDefOpaque(loc, "opaque", 6).WithAlign(4),
+		NewTypeDefRegular(loc, "self", NewSubTyIdentSize("self", 1)),
+	)
+
+	tt := []struct {
+		ident    Ident
+		expected Layout
+	}{
+		{"mixed", Layout{Size: 24, Align: 8, Offsets: []int{0, 8, 16}}},
+		{"nested", Layout{Size: 32, Align: 8, Offsets: []int{0, 8}}},
+		{"aligned", Layout{Size: 16, Align: 16, Offsets: []int{0}}},
+		{"union", Layout{Size: 8, Align: 4}},
+		{"opaque", Layout{Size: 8, Align: 4}},
+	}
+
+	for _, tc := range tt {
+		t.Run(string(tc.ident), func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := unit.Layout(tc.ident)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+
+	_, err := unit.Layout("self")
+	require.EqualError(t, err, "test.in:3:5: type :self contains itself")
+
+	_, err = unit.Layout("missing")
+	require.EqualError(t, err, "unknown type :missing")
+}
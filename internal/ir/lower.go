@@ -2,78 +2,249 @@ package ir
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/corani/cubit/internal/ast"
 	"github.com/corani/cubit/internal/lexer"
+	"github.com/corani/cubit/internal/parallel"
+	"github.com/corani/cubit/internal/target"
 )
 
+// LowerOptions configure lowering.
+type LowerOptions struct {
+	// SourceNames names the temporaries that compute the value assigned to a
+	// variable after the variable, e.g. `_count_0003` instead of `_tmp_0003`,
+	// which makes the IR easier to follow.
+	SourceNames bool
+	// Target is the machine the IR is lowered for, which sets the size and
+	// the type of pointers. The default is the host.
+	Target target.Target
+	// TrapOverflow makes addition, subtraction, multiplication and negation
+	// of ints stop the program when the result overflows, instead of
+	// wrapping around.
+	TrapOverflow bool
+	// Jobs is the number of functions lowered at once, GOMAXPROCS if it's 0.
+	// The IR is the same for any number.
+	Jobs int
+}
+
+// Lower translates a type-checked compilation unit to IR. It stops at the first
+// construct that can't be lowered and returns an error with its location.
 func Lower(unit *ast.CompilationUnit) (*CompilationUnit, error) {
-	visitor := newVisitor()
+	return LowerWithOptions(unit, LowerOptions{})
+}
 
-	unit.Accept(visitor)
+// LowerWithOptions is Lower, configured by opts. Temporaries and labels are
+// numbered per function, so the IR of a function doesn't depend on the
+// functions lowered before it.
+func LowerWithOptions(unit *ast.CompilationUnit, opts LowerOptions) (*CompilationUnit, error) {
+	visitor := newVisitor(AnalyzeEscapes(unit), opts.Target)
+	visitor.sourceNames = opts.SourceNames
+	visitor.trapOverflow = opts.TrapOverflow
+	visitor.jobs = opts.Jobs
+
+	if err := unit.AcceptE(visitor); err != nil {
+		return nil, err
+	}
 
 	return visitor.unit, nil
 }
 
-// visitor implements ast.Visitor and produces IR nodes.
+// visitor implements ast.VisitorE and produces IR nodes.
 type visitor struct {
-	unit             *CompilationUnit
-	lastVal          *Val          // holds the result of lowering the last value (for expressions)
-	lastType         *ast.Type     // holds the type of the last value (for expressions)
-	lastParam        *Param        // holds the result of lowering the last parameter
-	lastInstructions []Instruction // holds the result of lowering a body
-	tmpCounter       int           // for unique temp and string literal names
-	labelCounter     int
-	localSlots       map[string]*Val // variable/param name -> stack slot (function-local)
-	lvalue           bool
-}
-
-func newVisitor() *visitor {
+	unit         *CompilationUnit
+	lastVal      *Val                  // holds the result of lowering the last value (for expressions)
+	lastParam    *Param                // holds the result of lowering the last parameter
+	blocks       []Block               // the finished blocks of the function being lowered
+	block        *Block                // the block instructions are appended to
+	allocs       []Instruction         // stack slots of the function, hoisted to its start
+	tmpCounter   int                   // for unique temp names (function-local)
+	labelCounter int                   // for unique labels (function-local)
+	dataCounter  int                   // for unique string and array literal names
+	sourceNames  bool                  // name temps after the variable they're assigned to
+	trapOverflow bool                  // arithmetic on ints traps when it overflows
+	jobs         int                   // functions lowered at once
+	variable     string                // variable the value being lowered is assigned to
+	localSlots   []map[string]*Val     // variable/param name -> stack slot, per scope (function-local)
+	slotCount    map[string]int        // declarations of each name (function-local)
+	bareArrays   map[*ast.Declare]bool // arrays declared without a value (function-local)
+	strings      map[string]Ident      // string literal contents -> data definition
+	overloaded   map[string]bool       // names shared by several functions
+	escapes      *Escapes              // values that outlive the function creating them
+	ptr          AbiTy                 // type of addresses, a word on 32-bit targets
+	ptrSize      int64                 // size of a pointer in memory
+	lvalue       bool
+}
+
+func newVisitor(escapes *Escapes, t target.Target) *visitor {
+	ptr := NewAbiTyBase(BaseLong)
+	if t.PtrSize() == 4 {
+		ptr = NewAbiTyBase(BaseWord)
+	}
+
 	return &visitor{
-		unit: NewCompilationUnit(),
+		unit:    NewCompilationUnit(),
+		strings: make(map[string]Ident),
+		escapes: escapes,
+		ptr:     ptr,
+		ptrSize: int64(t.PtrSize()),
 	}
 }
 
-func (v *visitor) VisitCompilationUnit(cu *ast.CompilationUnit) {
+func (v *visitor) VisitCompilationUnit(cu *ast.CompilationUnit) error {
 	v.unit.WithPackage(cu.Ident, cu.Location())
 
+	count := make(map[string]int)
+	for _, fd := range cu.Funcs {
+		count[fd.Ident]++
+	}
+
+	v.overloaded = make(map[string]bool)
+	for name, n := range count {
+		v.overloaded[name] = n > 1
+	}
+
+	libraries := cu.Attributes.Libraries()
+	for _, fd := range cu.Funcs {
+		libraries = append(libraries, fd.Attributes.Libraries()...)
+	}
+
+	for _, lib := range libraries {
+		if !slices.Contains(v.unit.Libraries, lib) {
+			v.unit.Libraries = append(v.unit.Libraries, lib)
+		}
+	}
+
 	// Lower types
 	for i := range cu.Types {
-		cu.Types[i].Accept(v)
+		if err := cu.Types[i].AcceptE(v); err != nil {
+			return err
+		}
 	}
 
 	// Lower data
 	for i := range cu.Data {
-		cu.Data[i].Accept(v)
+		if err := cu.Data[i].AcceptE(v); err != nil {
+			return err
+		}
 	}
 
-	// Lower functions
-	for i := range cu.Funcs {
-		cu.Funcs[i].Accept(v)
+	// Lower functions. They're independent, so they're lowered in parallel,
+	// each by a visitor of its own, and joined in order.
+	forks := make([]*visitor, len(cu.Funcs))
+
+	if err := parallel.Do(len(cu.Funcs), v.jobs, nil, func(i int) error {
+		forks[i] = v.fork()
+
+		return cu.Funcs[i].AcceptE(forks[i])
+	}); err != nil {
+		return err
+	}
+
+	for _, fv := range forks {
+		v.join(fv)
+	}
+
+	return nil
+}
+
+// fork returns a visitor for lowering one function, with a unit of its own.
+func (v *visitor) fork() *visitor {
+	return &visitor{
+		unit:         NewCompilationUnit(),
+		strings:      make(map[string]Ident),
+		sourceNames:  v.sourceNames,
+		trapOverflow: v.trapOverflow,
+		overloaded:   v.overloaded,
+		escapes:      v.escapes,
+		ptr:          v.ptr,
+		ptrSize:      v.ptrSize,
 	}
 }
 
-// TODO(daniel): TypeDef lowering is not implemented yet.
-func (v *visitor) VisitTypeDef(td *ast.TypeDef) {}
+// join adds the functions and the data that fv lowered to the unit of v. The
+// data is renamed to continue the numbering of v, and the string literals v
+// has already are shared, so the IR is the same as if v had lowered the
+// functions itself.
+func (v *visitor) join(fv *visitor) {
+	literals := make(map[Ident]string, len(fv.strings))
+	for s, ident := range fv.strings {
+		literals[ident] = s
+	}
+
+	renamed := make(map[Ident]Ident, len(fv.unit.DataDefs))
+
+	for _, dd := range fv.unit.DataDefs {
+		s, literal := literals[dd.Ident]
+		if ident, ok := v.strings[s]; literal && ok {
+			renamed[dd.Ident] = ident
+			continue
+		}
+
+		// The names are _<prefix>_<number>, see nextData.
+		prefix := string(dd.Ident[1:strings.LastIndex(string(dd.Ident), "_")])
+		renamed[dd.Ident] = v.nextData(prefix)
+		dd.Ident = renamed[dd.Ident]
+
+		if literal {
+			v.strings[s] = dd.Ident
+		}
+
+		v.unit.DataDefs = append(v.unit.DataDefs, dd)
+	}
+
+	for _, fd := range fv.unit.FuncDefs {
+		for _, block := range fd.Blocks {
+			for _, instr := range block.Instructions {
+				for _, op := range Operands(instr) {
+					if ident, ok := symbol(*op); ok && renamed[ident] != "" {
+						*op = NewValGlobal((*op).Loc, renamed[ident], (*op).AbiTy)
+					}
+				}
+			}
+		}
+
+		v.unit.FuncDefs = append(v.unit.FuncDefs, fd)
+	}
+}
+
+// TODO(daniel): TypeDef lowering is not implemented yet. The language has no
+// aggregate types to lower; once it does, CompilationUnit.Layout gives the
+// offsets to access their fields at.
+func (v *visitor) VisitTypeDef(td *ast.TypeDef) error {
+	return nil
+}
 
 // TODO(daniel): DataDef lowering is not implemented yet.
-func (v *visitor) VisitDataDef(dd *ast.DataDef) {}
+func (v *visitor) VisitDataDef(dd *ast.DataDef) error {
+	return nil
+}
 
-func (v *visitor) VisitFuncDef(fd *ast.FuncDef) {
+func (v *visitor) VisitFuncDef(fd *ast.FuncDef) error {
 	// TODO(daniel): This will fail for nested functions like lambdas!
-	// Labels are function-local, so we can reset the counter for each function
+	// Temps and labels are function-local, so we can reset the counters for
+	// each function
+	v.tmpCounter = 0
 	v.labelCounter = 0
-	v.lastInstructions = nil
+	v.blocks = nil
+	v.allocs = nil
 
 	// Lower parameters using VisitFuncParam
 	var params []*Param
-	v.localSlots = make(map[string]*Val) // function-local slot map
+	var paramTypes []*ast.Type
+	v.localSlots = []map[string]*Val{{}} // function-local slot map
+	v.slotCount = make(map[string]int)
+	v.bareArrays = bareArrays(fd.Body)
 
 	for _, param := range fd.Params {
 		v.lastParam = nil
-		param.Accept(v)
+		if err := param.AcceptE(v); err != nil {
+			return err
+		}
 		if v.lastParam != nil {
 			params = append(params, v.lastParam)
+			paramTypes = append(paramTypes, param.Type)
 		}
 	}
 
@@ -81,20 +252,26 @@ func (v *visitor) VisitFuncDef(fd *ast.FuncDef) {
 		Filename: fd.Loc.Filename,
 		Line:     fd.Loc.Line,
 		Column:   fd.Loc.Column,
-	}, Ident(fd.Ident), params...)
+	}, v.funcIdent(fd), params...)
 
-	if v, ok := fd.Attributes[ast.AttrKeyLinkname]; ok {
-		if v.Type() != ast.AttrStringType {
-			panic("link_name attribute must be a string")
+	if attr, ok := fd.Attributes[ast.AttrKeyLinkname]; ok {
+		if attr.Type() != ast.AttrStringType {
+			return fd.Location().Errorf("link_name attribute must be a string")
 		}
 
-		irFunc.LinkName = Ident(string(v.(ast.AttrString)))
+		irFunc.LinkName = Ident(string(attr.(ast.AttrString)))
 	}
 
 	if fd.ReturnType != nil && fd.ReturnType.Kind != ast.TypeVoid {
 		irFunc = irFunc.WithRetTy(v.mapTypeToAbiTy(fd.ReturnType))
 	}
 
+	irFunc.Inline = fd.Attributes.Has(ast.AttrKeyInline)
+
+	if callconv := fd.Attributes.CallConv(); callconv != ast.CallConvC {
+		irFunc.CallConv = CallConv(callconv)
+	}
+
 	// Set linkage to export if the function has the export attribute
 	if _, ok := fd.Attributes[ast.AttrKeyExport]; ok {
 		irFunc = irFunc.WithLinkage(NewLinkageExport(fd.Location()))
@@ -102,11 +279,10 @@ func (v *visitor) VisitFuncDef(fd *ast.FuncDef) {
 
 	// --- Stack-allocate all parameters at function entry ---
 	var paramInitInstrs []Instruction
-	for _, param := range params {
+	for i, param := range params {
 		// Create a stack slot for the parameter
-		slotName := Ident(string(param.Ident) + "_slot")
-		slotVal := NewValIdent(param.Loc, slotName, NewAbiTyBase(BaseLong))
-		// Assume 4 bytes for int/bool, 8 for long/pointer
+		slotVal := NewValIdent(param.Loc, v.slotName(string(param.Ident)), v.ptr)
+		// 4 bytes for int/bool, 8 for long/pointer on 64-bit targets
 		var size int64 = 4
 		switch param.AbiTy.BaseTy {
 		case BaseLong:
@@ -115,253 +291,425 @@ func (v *visitor) VisitFuncDef(fd *ast.FuncDef) {
 			size = 4
 			// Add more cases as needed
 		}
-		sizeVal := NewValInteger(param.Loc, size, NewAbiTyBase(BaseLong))
-		paramInitInstrs = append(paramInitInstrs, NewAlloc(param.Loc, slotVal, sizeVal))
+		sizeVal := NewValInteger(param.Loc, size, v.ptr)
+		paramInitInstrs = append(paramInitInstrs, NewAlloc(param.Loc, slotVal, sizeVal).WithAlign(v.alignOf(paramTypes[i])))
 		// Store the incoming parameter value into the slot
 		paramVal := NewValIdent(param.Loc, param.Ident, param.AbiTy)
-		paramInitInstrs = append(paramInitInstrs, NewStore(param.Loc, slotVal, paramVal))
-		v.localSlots[string(param.Ident)] = slotVal
+		paramInitInstrs = append(paramInitInstrs, NewStore(param.Loc, slotVal, paramVal).WithTy(v.memTy(paramTypes[i])))
+		v.declareSlot(string(param.Ident), slotVal)
 	}
 
 	// Lower function body (blocks)
 	if fd.Body != nil {
-		fd.Body.Accept(v)
-		// Prepend paramInitInstrs to the function's instructions
-		allInstrs := append(paramInitInstrs, v.lastInstructions...)
-		irFunc = irFunc.WithBlocks(
-			NewBlock(fd.Body.Location(), "start", allInstrs))
+		start := NewBlock(fd.Body.Location(), "start", nil)
+		v.block = &start
+
+		if err := fd.Body.AcceptE(v); err != nil {
+			return err
+		}
+		// The type checker makes sure the end of the body can't be reached if
+		// the function doesn't return void, but QBE requires every block to end
+		// with a jump.
+		if v.block.Terminator() == nil {
+			v.appendInstruction(NewHlt(fd.Body.Location()))
+		}
+
+		v.blocks = append(v.blocks, *v.block)
+
+		// Prepend paramInitInstrs and the stack slots to the start block. Slots
+		// are allocated once, in the start block; an alloc in a loop would grow
+		// the stack on every iteration.
+		entry := &v.blocks[0]
+		entry.Instructions = append(append(paramInitInstrs, v.allocs...), entry.Instructions...)
+		irFunc = irFunc.WithBlocks(v.blocks...)
+
+		buildSSA(&irFunc)
 	}
 
 	v.unit.FuncDefs = append(v.unit.FuncDefs, irFunc)
+
+	return nil
+}
+
+// funcIdent returns the name of the IR function for fd, which is mangled if the
+// function is overloaded.
+func (v *visitor) funcIdent(fd *ast.FuncDef) Ident {
+	if v.overloaded[fd.Ident] {
+		return mangle(fd)
+	}
+
+	return Ident(fd.Ident)
 }
 
-func (v *visitor) VisitGenericParam(gp *ast.GenericParam) {
+func (v *visitor) VisitGenericParam(gp *ast.GenericParam) error {
 	// TODO: implementation
+	return nil
 }
 
-func (v *visitor) VisitFuncParam(fp *ast.FuncParam) {
+func (v *visitor) VisitFuncParam(fp *ast.FuncParam) error {
 	v.lastParam = NewParamRegular(fp.Location(), v.mapTypeToAbiTy(fp.Type), Ident(fp.Ident))
+
+	return nil
 }
 
-func (v *visitor) VisitBody(b *ast.Body) {
+func (v *visitor) VisitBody(b *ast.Body) error {
 	for _, instr := range b.Instructions {
-		instr.Accept(v)
+		// A call to a pure function whose result is discarded has no effect.
+		if call, ok := instr.(*ast.Call); ok && isPure(call) {
+			continue
+		}
+
+		if err := instr.AcceptE(v); err != nil {
+			return err
+		}
 	}
+
+	return nil
+}
+
+// VisitAssert emits nothing, as assertions are checked at compile time.
+func (v *visitor) VisitAssert(a *ast.Assert) error {
+	return nil
 }
 
 // VisitDeclare handles variable declarations (no IR emitted, but needed for IR lowering).
-func (v *visitor) VisitDeclare(d *ast.Declare) {
+func (v *visitor) VisitDeclare(d *ast.Declare) error {
 	// Stack-allocate all locals (scalars and arrays)
 	var size int64 = 4
 	abiTy := v.mapTypeToAbiTy(d.Type)
 	if d.Type != nil && d.Type.Kind == ast.TypeArray {
-		size = 1
-		tmpType := d.Type
-		for tmpType != nil && tmpType.Kind == ast.TypeArray {
-			// TODO: support symbolic sizes?
-			if tmpType.Size.Kind != ast.SizeLiteral {
-				panic("array size must be a literal")
-			}
-
-			size *= int64(tmpType.Size.Value)
-			tmpType = tmpType.Elem
+		// TODO: support symbolic sizes?
+		var err error
+		if size, err = v.sizeOf(d.Type); err != nil {
+			return d.Location().Errorf("%v", err)
 		}
-		// Assume only int arrays for now (4 bytes per int)
-		eleSize := int64(4)
-		size *= eleSize
 	} else if abiTy.BaseTy == BaseLong {
 		size = 8
 	}
-	sizeVal := NewValInteger(d.Location(), size, NewAbiTyBase(BaseLong))
-	slotName := Ident(string(d.Ident) + "_slot")
-	slotVal := NewValIdent(d.Location(), slotName, NewAbiTyBase(BaseLong))
-	v.appendInstruction(NewAlloc(d.Location(), slotVal, sizeVal))
-	v.localSlots[string(d.Ident)] = slotVal
+	sizeVal := NewValInteger(d.Location(), size, v.ptr)
+	slotVal := NewValIdent(d.Location(), v.slotName(d.Ident), v.ptr)
+	v.alloc(NewAlloc(d.Location(), slotVal, sizeVal).WithAlign(v.alignOf(d.Type)))
+	v.declareSlot(d.Ident, slotVal)
+
+	// An array declared without a value gets zeroed storage, like an empty
+	// array literal, as the variable holds the address of its elements.
+	if v.bareArrays[d] {
+		if err := v.VisitLiteral(ast.NewArrayLiteral(d.Type, nil, d.Location())); err != nil {
+			return err
+		}
+
+		v.store(d.Location(), slotVal, v.lastVal, d.Type)
+	}
+
 	v.lastVal = slotVal
-	v.lastType = d.Type
+
+	return nil
+}
+
+// bareArrays returns the declarations of arrays in body that aren't assigned
+// a value where they're declared, like `arr: [10]int`.
+func bareArrays(body *ast.Body) map[*ast.Declare]bool {
+	bare := make(map[*ast.Declare]bool)
+
+	find := func(instrs []ast.Instruction) {
+		for i, instr := range instrs {
+			d, ok := instr.(*ast.Declare)
+			if !ok || d.Type == nil || d.Type.Kind != ast.TypeArray {
+				continue
+			}
+
+			if i+1 < len(instrs) {
+				if a, ok := instrs[i+1].(*ast.Assign); ok {
+					if ref, ok := a.LHS.(*ast.VariableRef); ok && ref.Ident == d.Ident {
+						continue
+					}
+				}
+			}
+
+			bare[d] = true
+		}
+	}
+
+	if body == nil {
+		return bare
+	}
+
+	ast.Walk(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Body:
+			find(n.Instructions)
+		case *ast.If:
+			find(n.Init)
+		case *ast.For:
+			find(n.Init)
+		}
+
+		return true
+	})
+
+	return bare
 }
 
-// zeroInitialize emits IR to zero out a memory region [addr, addr+size)
-func (v *visitor) zeroInitialize(loc lexer.Location, addr *Val, size *Val) {
-	// We'll emit a simple loop:
-	//   i = 0
-	//   loop:
-	//     if i >= size goto end
-	//     storew 0, addr + i
-	//     i += 4
-	//     goto loop
-	//   end:
+// zeroInitialize emits IR to zero out a memory region [addr, addr+size) of
+// elements of type elem.
+func (v *visitor) zeroInitialize(loc lexer.Location, addr *Val, size *Val, elem *ast.Type) error {
+	elemSize, err := v.sizeOf(elem)
+	if err != nil {
+		return loc.Errorf("%v", err)
+	}
+
+	zero := NewValInteger(loc, 0, v.mapTypeToAbiTy(elem))
 
-	idx := NewValIdent(loc, v.nextIdent("zi_idx"), NewAbiTyBase(BaseLong))
-	zero := NewValInteger(loc, 0, NewAbiTyBase(BaseWord))
-	step := NewValInteger(loc, 4, NewAbiTyBase(BaseLong))
+	v.byteLoop(loc, "zi", size, elemSize, func(idx *Val) {
+		// store 0, addr + i
+		addrPlusIdx := NewValIdent(loc, v.nextIdent("zi_addr"), v.ptr)
+		v.appendInstruction(NewBinop(loc, BinOpAdd, addrPlusIdx, addr, idx))
+		v.store(loc, addrPlusIdx, zero, elem)
+	})
+
+	return nil
+}
 
-	loopLabel := v.nextLabel("zi_loop")
-	endLabel := v.nextLabel("zi_end")
-	falseLabel := v.nextLabel("zi_tmp")
+// byteLoop emits a loop over the first size bytes of memory, step bytes at a
+// time, with i in a stack slot. body emits the instructions for the step at
+// offset i. Labels and temps are prefixed with tag:
+//
+//	  i = 0
+//	loop:
+//	  if i >= size goto end
+//	  body(i)
+//	  i += step
+//	  goto loop
+//	end:
+func (v *visitor) byteLoop(loc lexer.Location, tag string, size *Val, step int64, body func(i *Val)) {
+	ptr := v.ptr
+	slot := v.slot(loc, ptr)
+
+	loopLabel := v.nextLabel(tag + "_loop")
+	endLabel := v.nextLabel(tag + "_end")
+	falseLabel := v.nextLabel(tag + "_tmp")
 
 	// i = 0
-	v.appendInstruction(NewBinop(loc, BinOpAdd, idx, zero, NewValInteger(loc, 0, NewAbiTyBase(BaseLong))))
+	v.appendInstruction(NewStore(loc, slot, NewValInteger(loc, 0, ptr)))
 	// loop:
-	v.appendInstruction(NewLabel(loc, loopLabel))
+	v.startBlock(loc, loopLabel)
+	idx := NewValIdent(loc, v.nextIdent(tag+"_idx"), ptr)
+	v.appendInstruction(NewLoad(loc, idx, slot))
 	// if i >= size goto end
-	cmp := NewValIdent(loc, v.nextIdent("zi_cmp"), NewAbiTyBase(BaseWord))
-	v.appendInstruction(NewBinop(loc, BinOpGe, cmp, idx, size))
+	cmp := NewValIdent(loc, v.nextIdent(tag+"_cmp"), NewAbiTyBase(BaseWord))
+	v.appendInstruction(NewBinop(loc, BinOpUGe, cmp, idx, size))
 	v.appendInstruction(NewJnz(loc, cmp, endLabel, falseLabel))
-	v.appendInstruction(NewLabel(loc, falseLabel))
-	// addr + i
-	addrPlusIdx := NewValIdent(loc, v.nextIdent("zi_addr"), NewAbiTyBase(BaseLong))
-	v.appendInstruction(NewBinop(loc, BinOpAdd, addrPlusIdx, addr, idx))
-	// storew 0, addr + i
-	v.appendInstruction(NewStore(loc, addrPlusIdx, zero))
-	// i += 4
-	v.appendInstruction(NewBinop(loc, BinOpAdd, idx, idx, step))
+	v.startBlock(loc, falseLabel)
+	body(idx)
+	// i += step
+	next := NewValIdent(loc, v.nextIdent(tag+"_idx"), ptr)
+	v.appendInstruction(NewBinop(loc, BinOpAdd, next, idx, NewValInteger(loc, step, ptr)))
+	v.appendInstruction(NewStore(loc, slot, next))
 	// goto loop
 	v.appendInstruction(NewJmp(loc, loopLabel))
 	// end:
-	v.appendInstruction(NewLabel(loc, endLabel))
+	v.startBlock(loc, endLabel)
 }
 
-func (v *visitor) VisitAssign(a *ast.Assign) {
+func (v *visitor) VisitAssign(a *ast.Assign) error {
 	// Lower the right-hand side expression
 	v.lastVal = nil
-	a.Value.Accept(v)
 
-	v.acceptLValue(a.LHS)
+	if ref, ok := a.LHS.(*ast.VariableRef); ok && v.sourceNames {
+		v.variable = ref.Ident
+	}
+
+	err := a.Value.AcceptE(v)
+	v.variable = ""
+
+	if err != nil {
+		return err
+	}
+
+	return v.acceptLValue(a.LHS)
 }
 
-func (v *visitor) VisitCall(c *ast.Call) {
-	if c.FuncDef.Attributes.Has(ast.AttrKeyBuiltin) {
-		v.visitBuiltinCall(c)
+func (v *visitor) VisitCall(c *ast.Call) error {
+	if c.FuncDef == nil {
+		return c.Location().Errorf("call to unresolved function: %s", c.Ident)
+	}
 
-		return
+	if c.FuncDef.Attributes.Has(ast.AttrKeyBuiltin) {
+		return v.visitBuiltinCall(c)
 	}
 
-	// Lower the callee (function name)
-	ident := Ident(c.Ident)
+	// Lower the callee (function name). If the function has a link name, use
+	// that instead.
+	ident := v.funcIdent(c.FuncDef)
 
-	for _, fd := range v.unit.FuncDefs {
-		if fd.Ident == ident && fd.LinkName != "" {
-			// If the function has a link name, use that instead
-			ident = fd.LinkName
-			break
-		}
+	if attr, ok := c.FuncDef.Attributes[ast.AttrKeyLinkname].(ast.AttrString); ok {
+		ident = Ident(string(attr))
 	}
 
-	calleeVal := NewValGlobal(c.Location(), ident, v.mapTypeToAbiTy(c.Type))
+	calleeVal := NewValGlobal(c.Location(), ident, v.mapTypeToAbiTy(c.Type()))
 
 	// Lower arguments
 	var args []Arg
 
 	for _, arg := range c.Args {
 		v.lastVal = nil
-		arg.Value.Accept(v)
+		if err := arg.Value.AcceptE(v); err != nil {
+			return err
+		}
 		args = append(args, NewArgRegular(arg.Location(), v.lastVal))
 	}
 
 	// Create a temporary for the return value
-	retVal := NewValIdent(c.Location(), v.nextIdent("tmp"), v.mapTypeToAbiTy(c.Type))
+	retVal := NewValIdent(c.Location(), v.nextIdent("tmp"), v.mapTypeToAbiTy(c.Type()))
 
 	// Emit the Call instruction
 	call := NewCall(c.Location(), calleeVal, args...)
 
-	if c.Type != nil && c.Type.Kind != ast.TypeVoid {
-		call.WithRet(retVal.Ident, v.mapTypeToAbiTy(c.Type))
+	if c.Type() != nil && c.Type().Kind != ast.TypeVoid {
+		call.WithRet(retVal.Ident, v.mapTypeToAbiTy(c.Type()))
 	}
 
 	v.appendInstruction(call)
 	v.lastVal = retVal
-	v.lastType = c.Type
+
+	// The call doesn't return, so the code after it can't be reached.
+	if c.FuncDef.Attributes.Has(ast.AttrKeyNoReturn) {
+		v.appendInstruction(NewHlt(c.Location()))
+	}
+
+	return nil
 }
 
-func (v *visitor) VisitReturn(r *ast.Return) {
+// isPure reports whether evaluating the call has no side effects: the callee
+// and all calls in its arguments are pure, or builtins without effects.
+func isPure(call *ast.Call) bool {
+	pure := true
+
+	ast.Walk(call, func(n ast.Node) bool {
+		if c, ok := n.(*ast.Call); ok {
+			pure = c.FuncDef != nil && (c.FuncDef.Attributes.Has(ast.AttrKeyPure) ||
+				c.FuncDef.Attributes.Has(ast.AttrKeyBuiltin) && !intrinsics[c.Ident].effects)
+		}
+
+		return pure
+	})
+
+	return pure
+}
+
+func (v *visitor) VisitReturn(r *ast.Return) error {
 	if r.Value == nil {
 		v.appendInstruction(NewRet(r.Location()))
 	} else {
 		v.lastVal = nil
-		r.Value.Accept(v)
+		if err := r.Value.AcceptE(v); err != nil {
+			return err
+		}
 		val := v.lastVal
 
 		v.appendInstruction(NewRet(r.Location(), val))
 	}
+
+	return nil
 }
 
-func (v *visitor) VisitLiteral(l *ast.Literal) {
-	if l.Type == nil {
-		panic("literal has nil type")
+func (v *visitor) VisitLiteral(l *ast.Literal) error {
+	if l.Type() == nil {
+		return l.Location().Errorf("literal has no type")
 	}
 
-	switch l.Type.Kind {
+	switch l.Type().Kind {
 	case ast.TypeInt:
-		v.lastVal = NewValInteger(l.Location(), int64(l.IntValue), v.mapTypeToAbiTy(l.Type))
+		v.lastVal = NewValInteger(l.Location(), int64(l.IntValue), v.mapTypeToAbiTy(l.Type()))
 	case ast.TypeBool:
 		if l.BoolValue {
-			v.lastVal = NewValInteger(l.Location(), 1, v.mapTypeToAbiTy(l.Type))
+			v.lastVal = NewValInteger(l.Location(), 1, v.mapTypeToAbiTy(l.Type()))
 		} else {
-			v.lastVal = NewValInteger(l.Location(), 0, v.mapTypeToAbiTy(l.Type))
+			v.lastVal = NewValInteger(l.Location(), 0, v.mapTypeToAbiTy(l.Type()))
 		}
 	case ast.TypeString:
-		// TODO(daniel): This does not deduplicate identical string literals. Consider interning/deduplicating.
-		ident := v.nextIdent("str")
-		v.unit.DataDefs = append(v.unit.DataDefs, NewDataDefStringZ(l.Location(), ident, l.StringValue))
-		v.lastVal = NewValGlobal(l.Location(), ident, v.mapTypeToAbiTy(l.Type))
+		// String literals are immutable, so they're static data whether they
+		// escape or not, and identical literals share their data.
+		ident, ok := v.strings[l.StringValue]
+		if !ok {
+			ident = v.nextData("str")
+			v.strings[l.StringValue] = ident
+			v.unit.DataDefs = append(v.unit.DataDefs, NewDataDefStringZ(l.Location(), ident, l.StringValue))
+		}
+
+		v.lastVal = NewValGlobal(l.Location(), ident, v.mapTypeToAbiTy(l.Type()))
 	case ast.TypeArray:
 		// Only support zero-initialized array literals for now
 		if len(l.ArrayValue) != 0 {
 			l.Location().Errorf("non-empty array literals are not supported in IR lowering yet")
 		}
-		size := int64(1)
-		tmpType := l.Type
-		for tmpType != nil && tmpType.Kind == ast.TypeArray {
-			// TODO: support symbolic sizes?
-			if tmpType.Size.Kind != ast.SizeLiteral {
-				panic("array size must be a literal")
-			}
+		// TODO: support symbolic sizes?
+		totalBytes, err := v.sizeOf(l.Type())
+		if err != nil {
+			return l.Location().Errorf("%v", err)
+		}
+
+		elem := l.Type()
+		for elem.Kind == ast.TypeArray {
+			elem = elem.Elem
+		}
 
-			size *= int64(tmpType.Size.Value)
-			tmpType = tmpType.Elem
+		sizeVal := NewValInteger(l.Location(), totalBytes, v.ptr)
+		var retVal *Val
+		if v.escapes.Escapes(l) {
+			// The array outlives the function, so it can't live in its stack frame.
+			// TODO(daniel): Allocate on the heap, as static data is shared by all calls.
+			ident := v.nextData("arr")
+			v.unit.DataDefs = append(v.unit.DataDefs,
+				NewDataDef(l.Location(), ident, NewDataInitZero(l.Location(), int(totalBytes))))
+			retVal = NewValGlobal(l.Location(), ident, v.ptr)
+		} else {
+			retVal = NewValIdent(l.Location(), v.nextIdent("arr"), v.ptr)
+			v.alloc(NewAlloc(l.Location(), retVal, sizeVal).WithAlign(v.alignOf(elem)))
+		}
+		if err := v.zeroInitialize(l.Location(), retVal, sizeVal, elem); err != nil {
+			return err
 		}
-		// Assume only int arrays for now (4 bytes per int)
-		eleSize := int64(4)
-		totalBytes := size * eleSize
-		sizeVal := NewValInteger(l.Location(), totalBytes, NewAbiTyBase(BaseLong))
-		retVal := NewValIdent(l.Location(), v.nextIdent("arr"), NewAbiTyBase(BaseLong))
-		v.appendInstruction(NewAlloc(l.Location(), retVal, sizeVal))
-		v.zeroInitialize(l.Location(), retVal, sizeVal)
+
 		v.lastVal = retVal
 	default:
-		panic("unsupported literal type: " + l.Type.String())
+		return l.Location().Errorf("unsupported literal type: %s", l.Type())
 	}
 
-	v.lastType = l.Type
+	return nil
 }
 
-func (v *visitor) VisitBinop(b *ast.Binop) {
+func (v *visitor) VisitBinop(b *ast.Binop) error {
+	if lit := b.Const(); lit != nil {
+		return v.VisitLiteral(lit)
+	}
+
 	// Lower left and right operands
-	v.lastVal, v.lastType = nil, nil
-	b.Lhs.Accept(v)
-	left, leftType := v.lastVal, v.lastType
+	v.lastVal = nil
+	if err := b.Lhs.AcceptE(v); err != nil {
+		return err
+	}
+	left, leftType := v.lastVal, b.Lhs.Type()
 
 	// Create a new temporary for the result
-	result := NewValIdent(b.Location(), v.nextIdent("tmp"), v.mapTypeToAbiTy(b.Type))
+	result := NewValIdent(b.Location(), v.nextIdent("tmp"), v.mapTypeToAbiTy(b.Type()))
 
 	// Handle logical operations separately using compare and jump.
-	switch b.Operation {
-	case ast.BinOpLogAnd:
-		v.visitBinOpLogAnd(left, b, result)
+	if b.Operation == ast.BinOpLogAnd || b.Operation == ast.BinOpLogOr {
+		if err := v.visitBinOpLogical(left, b, result); err != nil {
+			return err
+		}
 
 		v.lastVal = result
-		return
-	case ast.BinOpLogOr:
-		v.visitBinOpLogOr(left, b, result)
 
-		v.lastVal = result
-		return
+		return nil
 	}
 
-	v.lastVal, v.lastType = nil, nil
-	b.Rhs.Accept(v)
-	right, rightType := v.lastVal, v.lastType
+	v.lastVal = nil
+	if err := b.Rhs.AcceptE(v); err != nil {
+		return err
+	}
+	right, rightType := v.lastVal, b.Rhs.Type()
 
 	// Map ast.BinOpKind to ir.BinOpKind using a map for maintainability
 	binOpMap := map[ast.BinOpKind]BinOpKind{
@@ -384,136 +732,316 @@ func (v *visitor) VisitBinop(b *ast.Binop) {
 
 	irOp, ok := binOpMap[b.Operation]
 	if !ok {
-		panic("unsupported binary operation: " + b.Operation)
-	}
-
-	// Pointer arithmetic scaling
-	if b.Operation == ast.BinOpAdd || b.Operation == ast.BinOpSub {
-		var ptrSide *Val
-		var intSide *Val
-		var ptrType *ast.Type
-		isLhsPtr := leftType != nil && leftType.Kind == ast.TypePointer
-		isRhsPtr := rightType != nil && rightType.Kind == ast.TypePointer
-		if isLhsPtr != isRhsPtr {
-			var elemSize int64 = 4
-			if isLhsPtr {
-				ptrSide = left
-				intSide = right
-				ptrType = leftType
-			} else {
-				ptrSide = right
-				intSide = left
-				ptrType = rightType
-			}
-			if ptrType != nil && ptrType.Elem != nil && ptrType.Elem.Kind == ast.TypeInt {
-				elemSize = 4
-			}
+		return b.Location().Errorf("unsupported binary operation: %s", b.Operation)
+	}
 
-			// TODO: handle other element types
-			if elemSize != 1 {
-				tmpScaled := NewValIdent(b.Location(), v.nextIdent("idx"), intSide.AbiTy)
-				v.appendInstruction(NewBinop(b.Location(), BinOpMul, tmpScaled, intSide, NewValInteger(b.Location(), elemSize, intSide.AbiTy)))
-				// Convert word to long
-				if tmpScaled.AbiTy.BaseTy != BaseLong {
-					tmpLong := NewValIdent(b.Location(), v.nextIdent("tmp"), NewAbiTyBase(BaseLong))
-					v.appendInstruction(NewConvert(b.Location(), tmpLong, tmpScaled))
-					tmpScaled = tmpLong
-				}
+	isLhsPtr := leftType != nil && leftType.Kind == ast.TypePointer
+	isRhsPtr := rightType != nil && rightType.Kind == ast.TypePointer
 
-				// Perform the pointer arithmetic
-				v.appendInstruction(NewBinop(b.Location(), irOp, result, ptrSide, tmpScaled))
-				v.lastVal = result
-				v.lastType = b.Type
-				return
-			}
-		}
+	// Pointer arithmetic, as allowed by the type checker: offsetting a pointer
+	// by an int, or subtracting two pointers of the same type.
+	if (isLhsPtr || isRhsPtr) && (b.Operation == ast.BinOpAdd || b.Operation == ast.BinOpSub) {
+		return v.visitPointerArithmetic(b, irOp, result, left, right)
 	}
 
 	if rightType.Kind != leftType.Kind {
-		// If types differ, we need to extend the small one
-		if leftType.Kind == ast.TypeInt && rightType.Kind == ast.TypePointer {
-			// Extend int to pointer
-			tmp := NewValIdent(b.Lhs.Location(), v.nextIdent("tmp"), v.mapTypeToAbiTy(rightType))
-			v.appendInstruction(NewConvert(b.Lhs.Location(), tmp, left))
-			left = tmp
-			leftType = rightType // now both are pointer
-		} else if leftType.Kind == ast.TypePointer && rightType.Kind == ast.TypeInt {
-			tmp := NewValIdent(b.Lhs.Location(), v.nextIdent("tmp"), v.mapTypeToAbiTy(leftType))
-			v.appendInstruction(NewConvert(b.Rhs.Location(), tmp, right))
-			right = tmp
-			rightType = leftType // now both are pointer
-		} else {
-			panic("type mismatch in binary operation: " + leftType.String() + " vs " + rightType.String())
+		return b.Location().Errorf("type mismatch in binary operation: %s vs %s", leftType, rightType)
+	}
+
+	// Addresses are unsigned, so pointers (and strings) compare as such.
+	if isLhsPtr || leftType.Kind == ast.TypeString {
+		irOp = irOp.Unsigned()
+	}
+
+	if v.trapOverflow && leftType.Kind == ast.TypeInt && (irOp == BinOpAdd || irOp == BinOpSub || irOp == BinOpMul) {
+		v.checkedBinop(b.Location(), irOp, result, left, right)
+	} else {
+		v.appendInstruction(NewBinop(b.Location(), irOp, result, left, right))
+	}
+
+	v.lastVal = result
+
+	return nil
+}
+
+// checkedBinop appends an operation on ints that stops the program if the
+// result overflows. The operation is repeated on the operands extended to
+// longs, where it can't overflow, and the results must be the same.
+func (v *visitor) checkedBinop(loc lexer.Location, op BinOpKind, result, left, right *Val) {
+	long := NewAbiTyBase(BaseLong)
+
+	// widen sign-extends an operand to a long.
+	widen := func(val *Val) *Val {
+		if _, ok := val.IntConst(); ok {
+			return val.WithAbiTy(long)
 		}
+
+		wide := NewValIdent(loc, v.nextIdent("tmp"), long)
+		v.appendInstruction(NewConvert(loc, wide, val))
+
+		return wide
 	}
 
-	v.appendInstruction(NewBinop(b.Location(), irOp, result, left, right))
+	wideLeft, wideRight := widen(left), widen(right)
+
+	v.appendInstruction(NewBinop(loc, op, result, left, right))
+
+	wide := NewValIdent(loc, v.nextIdent("tmp"), long)
+	v.appendInstruction(NewBinop(loc, op, wide, wideLeft, wideRight))
+
+	ok := NewValIdent(loc, v.nextIdent("tmp"), result.AbiTy)
+	v.appendInstruction(NewBinop(loc, BinOpEq, ok, wide, widen(result)))
+
+	okLabel := v.nextLabel("ok")
+	overflowLabel := v.nextLabel("overflow")
+	v.appendInstruction(NewJnz(loc, ok, okLabel, overflowLabel))
+
+	// @overflow:
+	v.startBlock(loc, overflowLabel)
+	v.appendInstruction(NewHlt(loc))
+
+	// @ok:
+	v.startBlock(loc, okLabel)
+}
+
+// visitPointerArithmetic lowers `p + n`, `n + p`, `p - n` and `p - q`. Offsets
+// are scaled by the size of the element type of the pointer.
+func (v *visitor) visitPointerArithmetic(b *ast.Binop, irOp BinOpKind, result, left, right *Val) error {
+	leftType, rightType := b.Lhs.Type(), b.Rhs.Type()
+
+	ptrType := leftType
+	if ptrType.Kind != ast.TypePointer {
+		ptrType = rightType
+	}
+
+	elemSize, err := v.sizeOf(ptrType.Elem)
+	if err != nil {
+		return b.Location().Errorf("pointer arithmetic on %s: %v", ptrType, err)
+	}
+
+	// p - q: the byte distance divided by the element size.
+	if leftType.Kind == ast.TypePointer && rightType.Kind == ast.TypePointer {
+		if b.Operation != ast.BinOpSub {
+			return b.Location().Errorf("invalid operands for pointer arithmetic: %s %s %s",
+				leftType, b.Operation, rightType)
+		}
+
+		ptr := v.ptr
+		diff := NewValIdent(b.Location(), v.nextIdent("tmp"), ptr)
+		v.appendInstruction(NewBinop(b.Location(), BinOpSub, diff, left, right))
+
+		count := NewValIdent(b.Location(), v.nextIdent("tmp"), ptr)
+		v.appendInstruction(NewBinop(b.Location(), BinOpDiv, count, diff, NewValInteger(b.Location(), elemSize, ptr)))
+
+		// The result is an int, which takes the lower word of the long.
+		v.appendInstruction(NewBinop(b.Location(), BinOpAdd, result, count, NewValInteger(b.Location(), 0, result.AbiTy)))
+		v.lastVal = result
+
+		return nil
+	}
+
+	ptrSide, intSide := left, right
+	if leftType.Kind != ast.TypePointer {
+		ptrSide, intSide = right, left
+	}
+
+	offset := v.offset(b.Location(), intSide, elemSize)
+	v.appendInstruction(NewBinop(b.Location(), irOp, result, ptrSide, offset))
 	v.lastVal = result
-	v.lastType = b.Type
+
+	return nil
 }
 
-func (v *visitor) visitBinOpLogAnd(left *Val, b *ast.Binop, result *Val) {
-	// Shape of a logical AND when lowered:
-	// 		%tmp = <left>
-	// 		jnz %tmp, @true, @false
-	//  @false:
-	// 		%result = %left
-	//		jp @end
-	// 	@true:
-	// 		%tmp = <right>
-	//		%result = %tmp
-	//  @end:
-	trueLabel := v.nextLabel("true")
-	falseLabel := v.nextLabel("false")
-	endLabel := v.nextLabel("end")
+// checkCondition makes sure a branch condition is a bool, as `jnz` would treat
+// any non-zero word as true.
+func checkCondition(cond ast.Expression) error {
+	if t := cond.Type(); t == nil || t.Kind != ast.TypeBool {
+		return cond.Location().Errorf("condition must be bool, got %s", t)
+	}
 
-	v.appendInstruction(NewJnz(b.Location(), left, trueLabel, falseLabel))
-	// @false:
-	v.appendInstruction(NewLabel(b.Location(), falseLabel))
-	v.appendInstruction(NewBinop(b.Location(), BinOpAdd, result, left, NewValInteger(b.Location(), 0, left.AbiTy)))
-	v.appendInstruction(NewJmp(b.Location(), endLabel))
-	// @true:
-	v.appendInstruction(NewLabel(b.Location(), trueLabel))
-	b.Rhs.Accept(v)
-	right := v.lastVal
-	v.appendInstruction(NewBinop(b.Location(), BinOpAdd, result, right, NewValInteger(b.Location(), 0, right.AbiTy)))
-	// @end:
-	v.appendInstruction(NewLabel(b.Location(), endLabel))
+	return nil
 }
 
-func (v *visitor) visitBinOpLogOr(left *Val, b *ast.Binop, result *Val) {
-	// Shape of a logical OR when lowered:
-	// 		%tmp = <left>
-	// 		jnz %tmp, @true, @false
-	//  @true:
-	//		%result = %left
-	//		jp @end
-	// 	@false:
-	// 		%tmp = <right>
-	// 		%result = %tmp
-	//  @end:
-	trueLabel := v.nextLabel("true")
-	falseLabel := v.nextLabel("false")
+// sizeOf returns the size in bytes of a value of type ty in memory.
+func (v *visitor) sizeOf(ty *ast.Type) (int64, error) {
+	if ty == nil {
+		return 0, fmt.Errorf("missing type")
+	}
+
+	switch ty.Kind {
+	case ast.TypeBool:
+		return 1, nil
+	case ast.TypeInt:
+		return 4, nil
+	case ast.TypeString, ast.TypePointer:
+		return v.ptrSize, nil
+	case ast.TypeArray:
+		if ty.Size == nil || ty.Size.Kind != ast.SizeLiteral {
+			return 0, fmt.Errorf("array size must be a literal, got %s", ty.Size)
+		}
+
+		elem, err := v.sizeOf(ty.Elem)
+		if err != nil {
+			return 0, err
+		}
+
+		return int64(ty.Size.Value) * elem, nil
+	default:
+		return 0, fmt.Errorf("type %s has no size", ty)
+	}
+}
+
+// alignOf returns the alignment of a stack slot for a value of type ty. Slots
+// are aligned to at least 4 bytes; arrays are aligned like their elements.
+func (v *visitor) alignOf(ty *ast.Type) int {
+	for ty != nil && ty.Kind == ast.TypeArray {
+		ty = ty.Elem
+	}
+
+	if abiTy := v.mapTypeToAbiTy(ty); abiTy.Type == AbiTyBase && abiTy.BaseTy == BaseLong {
+		return 8
+	}
+
+	return 4
+}
+
+// memTy returns the type of a value of type ty in memory. A bool takes a single
+// byte, which is zero-extended when it's loaded.
+func (v *visitor) memTy(ty *ast.Type) AbiTy {
+	if ty != nil && ty.Kind == ast.TypeBool {
+		return NewAbiTySubW(SubWUB)
+	}
+
+	return v.mapTypeToAbiTy(ty)
+}
+
+// load returns a new temporary holding the value of type ty at addr.
+func (v *visitor) load(loc lexer.Location, addr *Val, ty *ast.Type) *Val {
+	tmp := NewValIdent(loc, v.nextIdent("tmp"), v.mapTypeToAbiTy(ty))
+	v.appendInstruction(NewLoad(loc, tmp, addr).WithTy(v.memTy(ty)))
+
+	return tmp
+}
+
+// store stores val, a value of type ty, at addr.
+func (v *visitor) store(loc lexer.Location, addr, val *Val, ty *ast.Type) {
+	v.appendInstruction(NewStore(loc, addr, val).WithTy(v.memTy(ty)))
+}
+
+// offset returns the offset in bytes of element index of an array whose
+// elements take size bytes, as an address.
+func (v *visitor) offset(loc lexer.Location, index *Val, size int64) *Val {
+	if i, ok := index.IntConst(); ok {
+		return NewValInteger(loc, i*size, v.ptr)
+	}
+
+	if index.AbiTy.BaseTy != v.ptr.BaseTy {
+		tmp := NewValIdent(loc, v.nextIdent("idx"), v.ptr)
+		v.appendInstruction(NewConvert(loc, tmp, index))
+		index = tmp
+	}
+
+	scaled := NewValIdent(loc, v.nextIdent("idx"), index.AbiTy)
+	v.appendInstruction(NewBinop(loc, BinOpMul, scaled, index, NewValInteger(loc, size, index.AbiTy)))
+
+	return scaled
+}
+
+// elemAddr returns the address of element index of the array at base, whose
+// elements are of type elem.
+func (v *visitor) elemAddr(loc lexer.Location, base, index *Val, elem *ast.Type) (*Val, error) {
+	size, err := v.sizeOf(elem)
+	if err != nil {
+		return nil, loc.Errorf("%v", err)
+	}
+
+	addr := NewValIdent(loc, v.nextIdent("addr"), v.ptr)
+	v.appendInstruction(NewBinop(loc, BinOpAdd, addr, base, v.offset(loc, index, size)))
+
+	return addr, nil
+}
+
+// visitBinOpLogical lowers a logical AND or OR. The right operand is only
+// evaluated if the left one doesn't decide the result, and a phi picks the
+// result of the branch that was taken:
+//
+//		jnz %left, @rhs, @end	(or: jnz %left, @end, @rhs)
+//	@rhs:
+//		%right = <right>
+//		jmp @end
+//	@end:
+//		%result = phi @left 0, @right %right	(or: phi @left 1, @right %right)
+func (v *visitor) visitBinOpLogical(left *Val, b *ast.Binop, result *Val) error {
+	rhsLabel := v.nextLabel("rhs")
 	endLabel := v.nextLabel("end")
 
-	v.appendInstruction(NewJnz(b.Location(), left, trueLabel, falseLabel))
-	// @true:
-	v.appendInstruction(NewLabel(b.Location(), trueLabel))
-	v.appendInstruction(NewBinop(b.Location(), BinOpAdd, result, left, NewValInteger(b.Location(), 0, left.AbiTy)))
-	v.appendInstruction(NewJmp(b.Location(), endLabel))
-	// @false:
-	v.appendInstruction(NewLabel(b.Location(), falseLabel))
-	b.Rhs.Accept(v)
+	// The value of the operation if the left operand decides it.
+	decided := NewValInteger(b.Location(), 0, result.AbiTy)
+	leftLabel := v.block.Label
+
+	if b.Operation == ast.BinOpLogAnd {
+		v.appendInstruction(NewJnz(b.Location(), left, rhsLabel, endLabel))
+	} else {
+		decided = NewValInteger(b.Location(), 1, result.AbiTy)
+		v.appendInstruction(NewJnz(b.Location(), left, endLabel, rhsLabel))
+	}
+
+	// @rhs:
+	v.startBlock(b.Location(), rhsLabel)
+
+	v.lastVal = nil
+	if err := b.Rhs.AcceptE(v); err != nil {
+		return err
+	}
+
 	right := v.lastVal
-	v.appendInstruction(NewBinop(b.Location(), BinOpAdd, result, right, NewValInteger(b.Location(), 0, right.AbiTy)))
+	if !isBoolean(b.Rhs) {
+		// Any non-zero value is true, but the result must be 1.
+		right = NewValIdent(b.Location(), v.nextIdent("tmp"), result.AbiTy)
+		v.appendInstruction(NewBinop(b.Location(), BinOpNe, right, v.lastVal, NewValInteger(b.Location(), 0, v.lastVal.AbiTy)))
+	}
+
+	// The right operand may have started blocks of its own.
+	rightLabel := v.block.Label
+	v.appendInstruction(NewJmp(b.Location(), endLabel))
+
 	// @end:
-	v.appendInstruction(NewLabel(b.Location(), endLabel))
+	v.startBlock(b.Location(), endLabel)
+	v.appendInstruction(NewPhi(b.Location(), result,
+		NewPhiArg(leftLabel, decided),
+		NewPhiArg(rightLabel, right),
+	))
+
+	return nil
+}
+
+// isBoolean reports whether an expression always evaluates to 0 or 1: a
+// literal, a comparison or a logical operation.
+func isBoolean(expr ast.Expression) bool {
+	switch expr := expr.(type) {
+	case *ast.Literal:
+		return true
+	case *ast.Binop:
+		switch expr.Operation {
+		case ast.BinOpEq, ast.BinOpNe, ast.BinOpLt, ast.BinOpLe, ast.BinOpGt, ast.BinOpGe,
+			ast.BinOpLogAnd, ast.BinOpLogOr:
+			return true
+		}
+	}
+
+	return false
 }
 
-func (v *visitor) VisitUnaryOp(u *ast.UnaryOp) {
-	u.Expr.Accept(v)
+func (v *visitor) VisitUnaryOp(u *ast.UnaryOp) error {
+	if lit := u.Const(); lit != nil {
+		return v.VisitLiteral(lit)
+	}
+
+	if err := u.Expr.AcceptE(v); err != nil {
+		return err
+	}
 	operand := v.lastVal
-	operandType := v.lastType
+	operandType := u.Expr.Type()
 
 	switch u.Operation {
 	case ast.UnaryOpMinus:
@@ -521,18 +1049,24 @@ func (v *visitor) VisitUnaryOp(u *ast.UnaryOp) {
 		if operandType != nil && operandType.Kind == ast.TypeInt {
 			result := NewValIdent(u.Location(), v.nextIdent("tmp"), v.mapTypeToAbiTy(operandType))
 			zero := NewValInteger(u.Location(), 0, v.mapTypeToAbiTy(operandType))
-			v.appendInstruction(NewBinop(u.Location(), BinOpSub, result, zero, operand))
+
+			if v.trapOverflow {
+				v.checkedBinop(u.Location(), BinOpSub, result, zero, operand)
+			} else {
+				v.appendInstruction(NewBinop(u.Location(), BinOpSub, result, zero, operand))
+			}
 			v.lastVal = result
-			v.lastType = operandType
 		} else {
-			panic("unsupported type for unary minus in lowering")
+			return u.Location().Errorf("unsupported type for unary minus: %s", operandType)
 		}
 	default:
-		panic("unsupported unary operator in lowering")
+		return u.Location().Errorf("unsupported unary operator: %s", u.Operation)
 	}
+
+	return nil
 }
 
-func (v *visitor) VisitIf(iff *ast.If) {
+func (v *visitor) VisitIf(iff *ast.If) error {
 	// Shape of an If statement when lowered:
 	// 		%tmp = <cond>
 	// 		jnz %tmp, @true, @false
@@ -543,37 +1077,68 @@ func (v *visitor) VisitIf(iff *ast.If) {
 	// 		<else block instructions>
 	// @end:
 
+	// The initializers are a scope, and each branch is one inside it, like
+	// the type checker sees them.
+	v.pushScope()
+	defer v.popScope()
+
+	for _, init := range iff.Init {
+		if err := init.AcceptE(v); err != nil {
+			return err
+		}
+	}
+
+	// If the condition was folded, only lower the branch that is taken.
+	if cond := ast.ConstValue(iff.Cond); cond != nil && cond.Type().Kind == ast.TypeBool {
+		switch {
+		case cond.BoolValue:
+			return v.lowerBody(iff.Then)
+		case iff.Else != nil:
+			return v.lowerBody(iff.Else)
+		default:
+			return nil
+		}
+	}
+
 	trueLabel := v.nextLabel("then")
 	falseLabel := v.nextLabel("else")
 	endLabel := v.nextLabel("end")
 
-	for _, init := range iff.Init {
-		init.Accept(v)
+	// Lower the condition
+	if err := checkCondition(iff.Cond); err != nil {
+		return err
 	}
 
-	// Lower the condition
-	iff.Cond.Accept(v)
+	if err := iff.Cond.AcceptE(v); err != nil {
+		return err
+	}
 	condVal := v.lastVal
 	v.appendInstruction(NewJnz(iff.Cond.Location(), condVal, trueLabel, falseLabel))
 
 	// Lower the 'then' block
-	v.appendInstruction(NewLabel(iff.Then.Location(), trueLabel))
-	iff.Then.Accept(v)
+	v.startBlock(iff.Then.Location(), trueLabel)
+	if err := v.lowerBody(iff.Then); err != nil {
+		return err
+	}
 	v.appendInstruction(NewJmp(iff.Then.Location(), endLabel))
 
 	// Lower the 'else' block if present
 	if iff.Else == nil {
-		v.appendInstruction(NewLabel(iff.Location(), falseLabel))
+		v.startBlock(iff.Location(), falseLabel)
 	} else {
-		v.appendInstruction(NewLabel(iff.Else.Location(), falseLabel))
-		iff.Else.Accept(v)
+		v.startBlock(iff.Else.Location(), falseLabel)
+		if err := v.lowerBody(iff.Else); err != nil {
+			return err
+		}
 	}
 
 	// End label for the If statement
-	v.appendInstruction(NewLabel(iff.Location(), endLabel))
+	v.startBlock(iff.Location(), endLabel)
+
+	return nil
 }
 
-func (v *visitor) VisitFor(f *ast.For) {
+func (v *visitor) VisitFor(f *ast.For) error {
 	// Shape of a For loop when lowered:
 	// 		<optional initializer>
 	// @start:
@@ -589,185 +1154,231 @@ func (v *visitor) VisitFor(f *ast.For) {
 	bodyLabel := v.nextLabel("body")
 	endLabel := v.nextLabel("end")
 
+	v.pushScope()
+	defer v.popScope()
+
 	// Lower the initializers if present
 	for _, init := range f.Init {
-		init.Accept(v)
+		if err := init.AcceptE(v); err != nil {
+			return err
+		}
 	}
 
 	// Lower the condition
 	{
-		v.appendInstruction(NewLabel(f.Cond.Location(), startLabel))
-		f.Cond.Accept(v)
+		if err := checkCondition(f.Cond); err != nil {
+			return err
+		}
+
+		v.startBlock(f.Cond.Location(), startLabel)
+		if err := f.Cond.AcceptE(v); err != nil {
+			return err
+		}
 		condVal := v.lastVal
 		v.appendInstruction(NewJnz(f.Cond.Location(), condVal, bodyLabel, endLabel))
 	}
 
 	// Lower the loop body
 	{
-		v.appendInstruction(NewLabel(f.Body.Location(), bodyLabel))
-		f.Body.Accept(v)
+		v.startBlock(f.Body.Location(), bodyLabel)
+		if err := v.lowerBody(f.Body); err != nil {
+			return err
+		}
 
 		// Lower the post-conditions if present
 		for _, post := range f.Post {
-			post.Accept(v)
+			if err := post.AcceptE(v); err != nil {
+				return err
+			}
 		}
 
 		v.appendInstruction(NewJmp(f.Body.Location(), startLabel))
 	}
 
 	// End label for the For loop
-	v.appendInstruction(NewLabel(f.Location(), endLabel))
+	v.startBlock(f.Location(), endLabel)
+
+	return nil
 }
 
-func (v *visitor) VisitVariableRef(vr *ast.VariableRef) {
+func (v *visitor) VisitVariableRef(vr *ast.VariableRef) error {
 	if v.lvalue {
 		val := v.lastVal
 		v.lvalue = false
 
 		// Assignment to a variable or parameter: always store to its slot
-		if slot, ok := v.localSlots[vr.Ident]; ok {
-			v.appendInstruction(NewStore(vr.Location(), slot, val))
-			return
+		if slot, ok := v.lookupSlot(vr.Ident); ok {
+			v.store(vr.Location(), slot, val, vr.Type())
+
+			return nil
 		}
 
-		panic("assignment to undeclared variable: " + vr.Ident)
+		return vr.Location().Errorf("assignment to undeclared variable: %s", vr.Ident)
 	} else {
 		// Always load from the stack slot for both parameters and locals
-		if slot, ok := v.localSlots[vr.Ident]; ok {
+		if slot, ok := v.lookupSlot(vr.Ident); ok {
 			// Load the value from the slot
-			tmp := NewValIdent(vr.Location(), v.nextIdent("tmp"), v.mapTypeToAbiTy(vr.Type))
-			v.appendInstruction(NewLoad(vr.Location(), tmp, slot))
-			v.lastVal = tmp
-			v.lastType = vr.Type
-			return
+			v.lastVal = v.load(vr.Location(), slot, vr.Type())
+
+			return nil
 		}
 
-		panic("reference to undeclared variable: " + vr.Ident)
+		return vr.Location().Errorf("reference to undeclared variable: %s", vr.Ident)
 	}
 }
 
+// slotName returns the name of the stack slot of a declaration of name. A
+// name declared again in an inner scope gets a slot of its own, so the
+// variable it shadows keeps its value.
+func (v *visitor) slotName(name string) Ident {
+	n := v.slotCount[name]
+	v.slotCount[name]++
+
+	if n == 0 {
+		return Ident(name + "_slot")
+	}
+
+	return Ident(fmt.Sprintf("%s.%d_slot", name, n))
+}
+
+// declareSlot binds name to slot in the innermost scope.
+func (v *visitor) declareSlot(name string, slot *Val) {
+	v.localSlots[len(v.localSlots)-1][name] = slot
+}
+
+// lookupSlot returns the slot of name in the innermost scope that declares it.
+func (v *visitor) lookupSlot(name string) (*Val, bool) {
+	for i := len(v.localSlots) - 1; i >= 0; i-- {
+		if slot, ok := v.localSlots[i][name]; ok {
+			return slot, true
+		}
+	}
+
+	return nil, false
+}
+
+// lowerBody lowers the body of an if or a for in a scope of its own.
+func (v *visitor) lowerBody(body *ast.Body) error {
+	v.pushScope()
+	defer v.popScope()
+
+	return body.AcceptE(v)
+}
+
+func (v *visitor) pushScope() {
+	v.localSlots = append(v.localSlots, map[string]*Val{})
+}
+
+func (v *visitor) popScope() {
+	v.localSlots = v.localSlots[:len(v.localSlots)-1]
+}
+
 // VisitDeref handles pointer dereference expressions
-func (v *visitor) VisitDeref(d *ast.Deref) {
+func (v *visitor) VisitDeref(d *ast.Deref) error {
 	if v.lvalue {
 		val := v.lastVal
 		v.lvalue = false // can't have lvalue in the expression
 
 		// Lower the pointer expression
-		d.Expr.Accept(v)
+		if err := d.Expr.AcceptE(v); err != nil {
+			return err
+		}
 		addr := v.lastVal
 
-		// Store: storew val, addr
-		v.appendInstruction(NewStore(d.Location(), addr, val))
+		v.store(d.Location(), addr, val, d.Type())
 	} else {
 		// Lower the pointer expression
-		d.Expr.Accept(v)
+		if err := d.Expr.AcceptE(v); err != nil {
+			return err
+		}
 		addr := v.lastVal
 
-		// Load: %tmp =w loadw addr
-		tmp := NewValIdent(d.Location(), v.nextIdent("tmp"), v.mapTypeToAbiTy(d.Type))
-		v.appendInstruction(NewLoad(d.Location(), tmp, addr))
-
-		v.lastVal = tmp
-		v.lastType = d.Type
+		v.lastVal = v.load(d.Location(), addr, d.Type())
 	}
+
+	return nil
 }
 
-func (v *visitor) VisitArrayIndex(a *ast.ArrayIndex) {
-	if v.lvalue {
-		val := v.lastVal
-		v.lvalue = false // can't have lvalue in the array index
-
-		// Lower the array expression
-		a.Array.Accept(v)
-		arrayAddr := v.lastVal
-
-		// Compute the offset for the array index
-		a.Index.Accept(v)
-		index := v.lastVal
-
-		// Convert the index to long if necessary
-		if index.AbiTy.BaseTy != BaseLong {
-			tmp := NewValIdent(a.Location(), v.nextIdent("idx"), NewAbiTyBase(BaseLong))
-			v.appendInstruction(NewConvert(a.Location(), tmp, index))
-			index = tmp
-		}
-
-		// Scale the index by the element size (assume 4 bytes for int)
-		elemSize := int64(4)
-		tmpScaled := NewValIdent(a.Location(), v.nextIdent("idx"), index.AbiTy)
-		v.appendInstruction(NewBinop(a.Location(), BinOpMul, tmpScaled, index, NewValInteger(a.Location(), elemSize, index.AbiTy)))
-		// Compute the address: addr = arrayAddr + index * elemSize
-		v.appendInstruction(NewBinop(a.Location(), BinOpAdd, tmpScaled, tmpScaled, arrayAddr))
-		// Store: storew val, addr
-		v.appendInstruction(NewStore(a.Location(), tmpScaled, val))
-	} else {
-		// Lower array indexing: compute address and load value
-		// 1. Lower base (array) expression
-		a.Array.Accept(v)
-		base := v.lastVal
-		baseType := v.lastType
-
-		// 2. Lower index expression
-		a.Index.Accept(v)
-		idx := v.lastVal
-
-		// 3. Compute element size
-		eleSize := int64(4) // default to 4 for int
-		if baseType != nil && baseType.Kind == ast.TypeArray && baseType.Elem != nil {
-			if baseType.Elem.Kind == ast.TypeInt {
-				eleSize = 4
-			}
-			// TODO: handle other element types
-		}
+func (v *visitor) VisitArrayIndex(a *ast.ArrayIndex) error {
+	lvalue := v.lvalue
+	val := v.lastVal
+	v.lvalue = false // can't have lvalue in the array index
 
-		// 4. Compute offset: idx * eleSize
-		tmpMul := NewValIdent(a.Location(), v.nextIdent("idx"), idx.AbiTy)
-		v.appendInstruction(NewBinop(a.Location(), BinOpMul, tmpMul, idx, NewValInteger(a.Location(), eleSize, idx.AbiTy)))
+	// Lower the array expression
+	if err := a.Array.AcceptE(v); err != nil {
+		return err
+	}
+	base := v.lastVal
 
-		// 5. Convert offset to long if needed
-		offset := tmpMul
-		if tmpMul.AbiTy.BaseTy != BaseLong {
-			tmpLong := NewValIdent(a.Location(), v.nextIdent("tmp"), NewAbiTyBase(BaseLong))
-			v.appendInstruction(NewConvert(a.Location(), tmpLong, tmpMul))
-			offset = tmpLong
-		}
+	// Lower the index expression
+	if err := a.Index.AcceptE(v); err != nil {
+		return err
+	}
+	index := v.lastVal
 
-		// 6. Compute address: base + offset
-		addr := NewValIdent(a.Location(), v.nextIdent("addr"), NewAbiTyBase(BaseLong))
-		v.appendInstruction(NewBinop(a.Location(), BinOpAdd, addr, base, offset))
+	addr, err := v.elemAddr(a.Location(), base, index, a.Type())
+	if err != nil {
+		return err
+	}
 
-		// 7. For r-value: load from address
-		result := NewValIdent(a.Location(), v.nextIdent("tmp"), NewAbiTyBase(BaseWord))
-		v.appendInstruction(NewLoad(a.Location(), result, addr))
-		v.lastVal = result
-		v.lastType = baseType.Elem
+	if lvalue {
+		v.store(a.Location(), addr, val, a.Type())
+	} else {
+		v.lastVal = v.load(a.Location(), addr, a.Type())
 	}
+
+	return nil
 }
 
-func (v *visitor) acceptLValue(node interface{ Accept(ast.Visitor) }) {
+func (v *visitor) acceptLValue(node ast.LValue) error {
 	v.lvalue = true
-	node.Accept(v)
-	v.lvalue = false
+	defer func() { v.lvalue = false }()
+
+	return node.AcceptE(v)
 }
 
-func (v *visitor) appendInstruction(instr Instruction) {
-	if _, ok := instr.(*Label); ok {
-		v.lastInstructions = append(v.lastInstructions, instr)
+// alloc adds a stack slot to the start of the function being lowered.
+func (v *visitor) alloc(instr *Alloc) {
+	v.allocs = append(v.allocs, instr)
+}
 
-		return
+// slot allocates a stack slot for a value that is assigned on more than one
+// path, e.g. the result of a logical operator. IR temporaries are assigned
+// once, so values that change are lowered to memory; buildSSA turns the slots
+// that don't need to stay in memory back into temporaries.
+func (v *visitor) slot(loc lexer.Location, abiTy AbiTy) *Val {
+	var size int64 = 4
+	if abiTy.BaseTy == BaseLong {
+		size = 8
 	}
 
-	// If the previous instruction was a Ret, we need to add a label for the new block
-	if len(v.lastInstructions) > 0 {
-		if ret, ok := v.lastInstructions[len(v.lastInstructions)-1].(*Ret); ok {
-			// Append a label to separate instructions
-			label := v.nextLabel("block")
-			v.lastInstructions = append(v.lastInstructions, NewLabel(ret.Location(), label))
-		}
+	slot := NewValIdent(loc, v.nextIdent("slot"), v.ptr)
+	v.alloc(NewAlloc(loc, slot, NewValInteger(loc, size, v.ptr)).WithAlign(int(size)))
+
+	return slot
+}
+
+func (v *visitor) appendInstruction(instr Instruction) {
+	// Code after a jump or return can't be reached, but it still needs a block
+	// of its own.
+	if v.block.Terminator() != nil {
+		v.startBlock(instr.Location(), v.nextLabel("block"))
 	}
 
-	// Append an instruction to the last instructions
-	v.lastInstructions = append(v.lastInstructions, instr)
+	v.block.Instructions = append(v.block.Instructions, instr)
+}
+
+// startBlock finishes the current block and starts a new one with the given
+// label. A block that doesn't end with a jump falls through into the new one.
+func (v *visitor) startBlock(loc lexer.Location, label string) {
+	if v.block.Terminator() == nil {
+		v.block.Instructions = append(v.block.Instructions, NewJmp(loc, label))
+	}
+
+	v.blocks = append(v.blocks, *v.block)
+
+	block := NewBlock(loc, label, nil)
+	v.block = &block
 }
 
 func (v *visitor) nextLabel(tag string) string {
@@ -777,12 +1388,27 @@ func (v *visitor) nextLabel(tag string) string {
 }
 
 // nextIdent generates a unique identifier with the given prefix (e.g., "tmp" or "str").
+// nextIdent returns a unique name for a temp of the function being lowered.
+// With source names, temps computing the value of a variable are named after
+// it.
 func (v *visitor) nextIdent(prefix string) Ident {
 	v.tmpCounter++
 
+	if prefix == "tmp" && v.variable != "" {
+		prefix = v.variable
+	}
+
 	return Ident(fmt.Sprintf("_%s_%04d", prefix, v.tmpCounter))
 }
 
+// nextData returns a unique name for a data definition. Data is global, so the
+// counter isn't reset per function.
+func (v *visitor) nextData(prefix string) Ident {
+	v.dataCounter++
+
+	return Ident(fmt.Sprintf("_%s_%04d", prefix, v.dataCounter))
+}
+
 // mapTypeToAbiTy maps an *ast.Type to the appropriate AbiTy for IR lowering.
 func (v *visitor) mapTypeToAbiTy(ty *ast.Type) AbiTy {
 	if ty == nil {
@@ -792,11 +1418,11 @@ func (v *visitor) mapTypeToAbiTy(ty *ast.Type) AbiTy {
 	case ast.TypeInt, ast.TypeBool:
 		return NewAbiTyBase(BaseWord)
 	case ast.TypeString:
-		return NewAbiTyBase(BaseLong)
+		return v.ptr
 	case ast.TypePointer:
-		return NewAbiTyBase(BaseLong)
+		return v.ptr
 	case ast.TypeArray:
-		return NewAbiTyBase(BaseLong)
+		return v.ptr
 	default:
 		return NewAbiTyBase(BaseWord) // fallback
 	}
@@ -0,0 +1,543 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/corani/cubit/internal/target"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/corani/cubit/internal/typecheck"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLower_Errors(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 5}
+
+	tt := []struct {
+		name     string
+		instr    ast.Instruction
+		expected string
+	}{
+		{
+			name:     "undeclared variable",
+			instr:    ast.NewReturn(loc, ast.NewType(ast.TypeInt, loc), ast.NewVariableRef("x", ast.TypeInt, loc)),
+			expected: "test.in:3:5: reference to undeclared variable: x",
+		},
+		{
+			name:     "unresolved call",
+			instr:    ast.NewCall(loc, "f"),
+			expected: "test.in:3:5: call to unresolved function: f",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fd := ast.NewFuncDef("main", nil, loc)
+			fd.Body = ast.NewBody([]ast.Instruction{tc.instr}, loc)
+
+			unit := ast.NewCompilationUnit(loc)
+			unit.Ident = "main"
+			unit.Funcs = []*ast.FuncDef{fd}
+
+			_, err := Lower(unit)
+			require.EqualError(t, err, tc.expected)
+		})
+	}
+}
+
+func TestLower_FoldedIf(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 5}
+	intType := ast.NewType(ast.TypeInt, loc)
+
+	// if 2 < 1 { return 1 } else { return 2 }, with the condition folded by the
+	// type checker.
+	cond := ast.NewBinop(ast.BinOpLt, ast.NewIntLiteral(2, loc), ast.NewIntLiteral(1, loc), loc)
+	cond.SetType(ast.NewType(ast.TypeBool, loc))
+	cond.SetConst(ast.NewBoolLiteral(false, loc))
+
+	iff := ast.NewIf(loc, nil, cond,
+		ast.NewBody([]ast.Instruction{ast.NewReturn(loc, intType, ast.NewIntLiteral(1, loc))}, loc),
+		ast.NewBody([]ast.Instruction{ast.NewReturn(loc, intType, ast.NewIntLiteral(2, loc))}, loc))
+
+	fd := ast.NewFuncDef("main", nil, loc)
+	fd.ReturnType = intType
+	fd.Body = ast.NewBody([]ast.Instruction{iff}, loc)
+
+	unit := ast.NewCompilationUnit(loc)
+	unit.Ident = "main"
+	unit.Funcs = []*ast.FuncDef{fd}
+
+	lowered, err := Lower(unit)
+	require.NoError(t, err)
+
+	var rets []*Ret
+
+	for _, block := range lowered.FuncDefs[0].Blocks {
+		for _, instr := range block.Instructions {
+			require.IsType(t, &Ret{}, instr, "only the taken branch must be lowered")
+
+			rets = append(rets, instr.(*Ret))
+		}
+	}
+
+	require.Len(t, rets, 1)
+	require.Equal(t, int64(2), rets[0].Val.DynConst.Const.I64)
+}
+
+func TestLower_Overloads(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 5}
+
+	newFunc := func(params ...*ast.Type) *ast.FuncDef {
+		fd := ast.NewFuncDef("f", nil, loc)
+
+		for i, ty := range params {
+			fd.Params = append(fd.Params, ast.NewFuncParam(string(rune('a'+i)), ty, nil, nil, loc))
+		}
+
+		fd.Body = ast.NewBody([]ast.Instruction{ast.NewReturn(loc, nil)}, loc)
+
+		return fd
+	}
+
+	intType := ast.NewType(ast.TypeInt, loc)
+	overloads := []*ast.FuncDef{
+		newFunc(intType),
+		newFunc(ast.NewPointerType(ast.NewType(ast.TypeString, loc), 1, loc), intType),
+		newFunc(ast.NewArrayType(intType, ast.NewSizeLiteral(4), loc)),
+	}
+
+	call := ast.NewCall(loc, "f", ast.NewArg("", ast.NewIntLiteral(1, loc), intType, loc))
+	call.FuncDef = overloads[0]
+
+	main := ast.NewFuncDef("main", nil, loc)
+	main.Body = ast.NewBody([]ast.Instruction{call}, loc)
+
+	unit := ast.NewCompilationUnit(loc)
+	unit.Ident = "main"
+	unit.Funcs = append(overloads, main)
+
+	lowered, err := Lower(unit)
+	require.NoError(t, err)
+
+	var names []Ident
+	for _, fd := range lowered.FuncDefs {
+		names = append(names, fd.Ident)
+	}
+
+	require.Equal(t, []Ident{"f.int", "f.p_string.int", "f.a4_int", "main"}, names)
+	require.Equal(t, Ident("f.int"), lowered.FuncDefs[3].Blocks[0].Instructions[0].(*Call).Val.Ident)
+}
+
+func TestLower_Effects(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 5}
+	intType := ast.NewType(ast.TypeInt, loc)
+
+	exit := ast.NewFuncDef("exit", ast.Attributes{
+		ast.AttrKeyExtern:   ast.AttrBool(true),
+		ast.AttrKeyNoReturn: ast.AttrBool(true),
+	}, loc)
+
+	twice := ast.NewFuncDef("twice", ast.Attributes{ast.AttrKeyPure: ast.AttrBool(true)}, loc)
+	twice.ReturnType = intType
+	twice.Body = ast.NewBody([]ast.Instruction{ast.NewReturn(loc, intType, ast.NewIntLiteral(2, loc))}, loc)
+
+	// twice() is dropped, as its result is discarded, and exit() ends the
+	// function, so no return is needed after it.
+	callTwice := ast.NewCall(loc, "twice")
+	callTwice.FuncDef = twice
+
+	callExit := ast.NewCall(loc, "exit")
+	callExit.FuncDef = exit
+
+	main := ast.NewFuncDef("main", nil, loc)
+	main.ReturnType = intType
+	main.Body = ast.NewBody([]ast.Instruction{callTwice, callExit}, loc)
+
+	unit := ast.NewCompilationUnit(loc)
+	unit.Ident = "main"
+	unit.Funcs = []*ast.FuncDef{exit, twice, main}
+
+	lowered, err := Lower(unit)
+	require.NoError(t, err)
+
+	instrs := lowered.FuncDefs[2].Blocks[0].Instructions
+	require.Len(t, instrs, 2)
+	require.Equal(t, Ident("exit"), instrs[0].(*Call).Val.Ident)
+	require.IsType(t, &Hlt{}, instrs[1])
+}
+
+func TestLower_SingleAssignment(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+f :: func(a: bool, b: bool, n: int) -> bool {
+    r := false
+    for i := 0; i < n; i = i + 1 {
+        x := [4]int{}
+        x[0] = i
+        r = a && b || r
+    }
+    return r
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+	require.NoError(t, typecheck.Check(unit))
+
+	lowered, err := Lower(unit)
+	require.NoError(t, err)
+
+	require.Empty(t, Verify(lowered))
+
+	fd := lowered.FuncDefs[0]
+
+	assigned := make(map[Ident]int)
+
+	for i, block := range fd.Blocks {
+		for _, instr := range block.Instructions {
+			switch instr := instr.(type) {
+			case *Alloc:
+				require.Equal(t, 0, i, "stack slots must be allocated in the start block")
+				assigned[instr.Ret.Ident]++
+			case *Binop:
+				assigned[instr.Ret.Ident]++
+			case *Load:
+				assigned[instr.Ret.Ident]++
+			case *Phi:
+				assigned[instr.Ret.Ident]++
+			}
+		}
+	}
+
+	for ident, n := range assigned {
+		require.Equal(t, 1, n, "temporary %s is assigned more than once", ident)
+	}
+}
+
+func TestLower_InternStrings(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+@(extern)
+puts :: func(s: string)
+
+f :: func() {
+    puts("hello")
+    puts("world")
+}
+
+g :: func() {
+    puts("hello")
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+	require.NoError(t, typecheck.Check(unit))
+
+	lowered, err := Lower(unit)
+	require.NoError(t, err)
+
+	require.Len(t, lowered.DataDefs, 2)
+
+	var args []Ident
+
+	for _, fd := range lowered.FuncDefs {
+		for _, block := range fd.Blocks {
+			for _, instr := range block.Instructions {
+				if call, ok := instr.(*Call); ok {
+					args = append(args, call.Args[0].Val.Ident)
+				}
+			}
+		}
+	}
+
+	hello, world := lowered.DataDefs[0].Ident, lowered.DataDefs[1].Ident
+	require.Equal(t, []Ident{hello, world, hello}, args)
+}
+
+func TestLower_MemoryTypes(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+f :: func(p: ^int) -> bool {
+    b := [3]bool{}
+    b[1] = true
+    p^ = 2
+    return b[2]
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+	require.NoError(t, typecheck.Check(unit))
+
+	lowered, err := Lower(unit)
+	require.NoError(t, err)
+	require.Empty(t, Verify(lowered))
+
+	var (
+		sizes  []int64
+		aligns []int
+		tys    []AbiTy
+	)
+
+	for _, block := range lowered.FuncDefs[0].Blocks {
+		for _, instr := range block.Instructions {
+			switch instr := instr.(type) {
+			case *Alloc:
+				size, _ := instr.Size.IntConst()
+				sizes = append(sizes, size)
+				aligns = append(aligns, instr.Align)
+			case *Store:
+				if instr.Val.Type == ValDynConst {
+					tys = append(tys, instr.Ty)
+				}
+			case *Load:
+				tys = append(tys, instr.Ty)
+			}
+		}
+	}
+
+	ub, word := NewAbiTySubW(SubWUB), NewAbiTyBase(BaseWord)
+
+	// The array takes a byte per element, and is zeroed a byte at a time. Its
+	// slot is the only one that isn't promoted, and stack slots are aligned
+	// to at least 4 bytes.
+	require.Equal(t, []int64{3}, sizes)
+	require.Equal(t, []int{4}, aligns)
+	require.Equal(t, []AbiTy{ub, ub, word, ub}, tys)
+}
+
+func TestLower_Names(t *testing.T) {
+	t.Parallel()
+
+	f := `
+f :: func(n: int) -> int {
+    count := 0
+    for i := 0; i < n; i = i + 1 {
+        count = count + i * 2
+    }
+    return count
+}
+`
+	g := `
+g :: func(s: string) -> int {
+    puts(s)
+    puts("done")
+    return 1
+}
+`
+
+	lower := func(src string, opts LowerOptions) map[Ident][]Ident {
+		unit := testutil.Parse(t, "test.in", "package main\n\n@(extern)\nputs :: func(s: string)\n"+src)
+		require.NoError(t, typecheck.Check(unit))
+
+		lowered, err := LowerWithOptions(unit, opts)
+		require.NoError(t, err)
+		require.Empty(t, Verify(lowered))
+
+		// The temps each function assigns, in order.
+		temps := make(map[Ident][]Ident)
+
+		for _, fd := range lowered.FuncDefs {
+			for _, block := range fd.Blocks {
+				for _, instr := range block.Instructions {
+					if binop, ok := instr.(*Binop); ok {
+						temps[fd.Ident] = append(temps[fd.Ident], binop.Ret.Ident)
+					}
+				}
+			}
+		}
+
+		return temps
+	}
+
+	// The names in a function don't depend on the functions before it.
+	require.Equal(t, lower(f+g, LowerOptions{}), lower(g+f, LowerOptions{}))
+
+	require.Equal(t, []Ident{"_tmp_0002", "_tmp_0007", "_tmp_0005", "_tmp_0009"}, lower(f, LowerOptions{})["f"])
+	require.Equal(t, []Ident{"_tmp_0002", "_count_0007", "_count_0005", "_i_0009"}, lower(f, LowerOptions{SourceNames: true})["f"])
+}
+
+func TestLower_Libraries(t *testing.T) {
+	t.Parallel()
+
+	src := `@(link="c")
+package main
+
+@(extern, link="m")
+sqrt :: func(x: int) -> int
+
+@(extern, link="pthread,m")
+pthread_self :: func() -> int
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+	require.NoError(t, typecheck.Check(unit))
+
+	lowered, err := Lower(unit)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"c", "m", "pthread"}, lowered.Libraries)
+}
+
+func TestLower_Target(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+f :: func(s: string, p: ^int) -> string {
+    ss := [2]string{}
+    ss[1] = s
+    return ss[p^]
+}
+`
+
+	lower := func(triple string) (*FuncDef, []int64) {
+		unit := testutil.Parse(t, "test.in", src)
+		require.NoError(t, typecheck.Check(unit))
+
+		tgt, err := target.Parse(triple)
+		require.NoError(t, err)
+
+		lowered, err := LowerWithOptions(unit, LowerOptions{Target: tgt})
+		require.NoError(t, err)
+		require.Empty(t, Verify(lowered))
+
+		var sizes []int64
+
+		for _, block := range lowered.FuncDefs[0].Blocks {
+			for _, instr := range block.Instructions {
+				if alloc, ok := instr.(*Alloc); ok {
+					size, _ := alloc.Size.IntConst()
+					sizes = append(sizes, size)
+				}
+			}
+		}
+
+		return &lowered.FuncDefs[0], sizes
+	}
+
+	long, word := NewAbiTyBase(BaseLong), NewAbiTyBase(BaseWord)
+
+	// Strings and pointers are addresses, which take the size of a pointer
+	// of the target, and so do the elements of arrays of strings.
+	fd, sizes := lower("linux/amd64")
+	require.Equal(t, long, *fd.RetTy)
+	require.Equal(t, []AbiTy{long, long}, []AbiTy{fd.Params[0].AbiTy, fd.Params[1].AbiTy})
+	require.Equal(t, []int64{16}, sizes)
+
+	fd, sizes = lower("wasm32")
+	require.Equal(t, word, *fd.RetTy)
+	require.Equal(t, []AbiTy{word, word}, []AbiTy{fd.Params[0].AbiTy, fd.Params[1].AbiTy})
+	require.Equal(t, []int64{8}, sizes)
+}
+
+func TestLower_TrapOverflow(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+f :: func(x: int, y: int, p: ^int) -> int {
+    return -(x * y + 1) - (p + 1)^
+}
+`
+
+	// lower returns the operations of f on longs, which check the ones on
+	// ints, and the number of blocks that stop the program.
+	lower := func(trap bool) ([]BinOpKind, int) {
+		unit := testutil.Parse(t, "test.in", src)
+		require.NoError(t, typecheck.Check(unit))
+
+		lowered, err := LowerWithOptions(unit, LowerOptions{TrapOverflow: trap})
+		require.NoError(t, err)
+		require.Empty(t, Verify(lowered))
+
+		var (
+			ops   []BinOpKind
+			traps int
+		)
+
+		for _, block := range lowered.FuncDefs[0].Blocks {
+			for _, instr := range block.Instructions {
+				if b, ok := instr.(*Binop); ok && b.Ret.AbiTy == NewAbiTyBase(BaseLong) && !b.Op.IsComparison() {
+					ops = append(ops, b.Op)
+				}
+			}
+
+			if _, ok := block.Terminator().(*Hlt); ok {
+				traps++
+			}
+		}
+
+		return ops, traps
+	}
+
+	// The pointer arithmetic isn't checked, only the arithmetic on ints.
+	ops, traps := lower(false)
+	require.Equal(t, []BinOpKind{BinOpAdd}, ops)
+	require.Equal(t, 0, traps)
+
+	ops, traps = lower(true)
+	require.Equal(t, []BinOpKind{BinOpMul, BinOpAdd, BinOpSub, BinOpAdd, BinOpSub}, ops)
+	require.Equal(t, 4, traps)
+}
+
+func TestLower_Jobs(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+@(extern)
+puts :: func(s: string)
+
+f :: func() {
+    puts("hello")
+    puts("world")
+}
+
+g :: func() {
+    puts("world")
+    puts("again")
+}
+
+h :: func() {
+    puts("hello")
+}
+`
+
+	lower := func(jobs int) *CompilationUnit {
+		unit := testutil.Parse(t, "test.in", src)
+		require.NoError(t, typecheck.Check(unit))
+
+		lowered, err := LowerWithOptions(unit, LowerOptions{Jobs: jobs})
+		require.NoError(t, err)
+
+		return lowered
+	}
+
+	// The data is numbered in the order of the functions, however many are
+	// lowered at once.
+	sequential := lower(1)
+
+	var idents []Ident
+	for _, dd := range sequential.DataDefs {
+		idents = append(idents, dd.Ident)
+	}
+
+	require.Equal(t, []Ident{"_str_0001", "_str_0002", "_str_0003"}, idents)
+	require.Equal(t, sequential, lower(4))
+}
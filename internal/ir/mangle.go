@@ -0,0 +1,46 @@
+package ir
+
+import (
+	"strings"
+
+	"github.com/corani/cubit/internal/ast"
+)
+
+// mangle returns the symbol name of an overloaded function: its name followed
+// by its parameter types, e.g. `foo.int.p_string` for `foo(x: int, s: ^string)`.
+func mangle(fd *ast.FuncDef) Ident {
+	var sb strings.Builder
+
+	sb.WriteString(fd.Ident)
+
+	for _, param := range fd.Params {
+		sb.WriteString(".")
+		mangleType(&sb, param.Type)
+	}
+
+	return Ident(sb.String())
+}
+
+func mangleType(sb *strings.Builder, ty *ast.Type) {
+	if ty == nil {
+		sb.WriteString("unknown")
+
+		return
+	}
+
+	switch ty.Kind {
+	case ast.TypePointer:
+		sb.WriteString("p_")
+		mangleType(sb, ty.Elem)
+	case ast.TypeArray:
+		sb.WriteString("a")
+		sb.WriteString(ty.Size.String())
+		sb.WriteString("_")
+		mangleType(sb, ty.Elem)
+	case ast.TypeVararg:
+		sb.WriteString("v_")
+		mangleType(sb, ty.Elem)
+	default:
+		sb.WriteString(ty.String())
+	}
+}
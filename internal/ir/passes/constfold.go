@@ -0,0 +1,121 @@
+package passes
+
+import (
+	"github.com/corani/cubit/internal/ir"
+)
+
+// ConstFold evaluates binary operations whose operands are constants, and
+// turns conditional jumps on a constant into plain jumps. The blocks that can
+// no longer be reached are removed, and so are the phi arguments for the edges
+// that are gone; a phi that's left with a single value is replaced by it.
+type ConstFold struct{}
+
+func (ConstFold) Name() string {
+	return "constfold"
+}
+
+func (ConstFold) Run(fd *ir.FuncDef) bool {
+	values := make(map[ir.Ident]*ir.Val)
+	changed, jumps := false, false
+
+	for i := range fd.Blocks {
+		block := &fd.Blocks[i]
+
+		var instrs []ir.Instruction
+
+		for _, instr := range block.Instructions {
+			// Operands defined earlier may have been folded already, so a
+			// chain of operations folds in a single run.
+			for _, op := range ir.Operands(instr) {
+				*op = resolve(values, *op)
+			}
+
+			switch instr := instr.(type) {
+			case *ir.Binop:
+				if val, ok := foldBinop(instr); ok {
+					values[instr.Ret.Ident] = val
+					changed = true
+
+					continue
+				}
+			case *ir.Jnz:
+				if cond, ok := instr.Cond.IntConst(); ok {
+					target := instr.False
+					if cond != 0 {
+						target = instr.True
+					}
+
+					instrs = append(instrs, ir.NewJmp(instr.Loc, target))
+					changed, jumps = true, true
+
+					continue
+				}
+			}
+
+			instrs = append(instrs, instr)
+		}
+
+		block.Instructions = instrs
+	}
+
+	if jumps {
+		fd.RemoveUnreachable()
+	}
+
+	for i := range fd.Blocks {
+		block := &fd.Blocks[i]
+
+		var instrs []ir.Instruction
+
+		for _, instr := range block.Instructions {
+			if phi, ok := instr.(*ir.Phi); ok {
+				if val := phi.Trivial(); val != nil {
+					values[phi.Ret.Ident] = val
+					changed = true
+
+					continue
+				}
+			}
+
+			instrs = append(instrs, instr)
+		}
+
+		block.Instructions = instrs
+	}
+
+	// Phis can use values defined later, through the back edge of a loop.
+	replace(fd, values)
+
+	return changed
+}
+
+// foldBinop evaluates a binary operation on constants the way the target does,
+// see ir.EvalBinop. Comparisons are made in the width of their operands, as the
+// code generator emits them. Division by zero is left for run time.
+func foldBinop(b *ir.Binop) (*ir.Val, bool) {
+	x, ok := b.Lhs.IntConst()
+	if !ok {
+		return nil, false
+	}
+
+	y, ok := b.Rhs.IntConst()
+	if !ok {
+		return nil, false
+	}
+
+	ty := b.Ret.AbiTy
+	if b.Op.IsComparison() {
+		ty = b.Lhs.AbiTy
+	}
+
+	r, ok := ir.EvalBinop(b.Op, ty, x, y)
+	if !ok {
+		return nil, false
+	}
+
+	if b.Ret.AbiTy.Type != ir.AbiTyBase || b.Ret.AbiTy.BaseTy != ir.BaseLong {
+		r = int64(int32(r))
+	}
+
+	return ir.NewValInteger(b.Loc, r, b.Ret.AbiTy), true
+}
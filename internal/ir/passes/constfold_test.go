@@ -0,0 +1,112 @@
+package passes
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/stretchr/testify/require"
+)
+
+var loc = lexer.Location{Filename: "test.in", Line: 3, Column: 5}
+
+// verify checks the invariants of the IR the passes leave, see ir.Verify. The
+// tests call it after every pass.
+func verify(t *testing.T, unit *ir.CompilationUnit) {
+	t.Helper()
+
+	require.Empty(t, ir.Verify(unit))
+}
+
+func TestFoldBinop(t *testing.T) {
+	t.Parallel()
+
+	word, long := ir.NewAbiTyBase(ir.BaseWord), ir.NewAbiTyBase(ir.BaseLong)
+
+	tt := []struct {
+		name     string
+		op       ir.BinOpKind
+		abiTy    ir.AbiTy
+		x, y     int64
+		expected int64
+		folded   bool
+	}{
+		{"add", ir.BinOpAdd, word, 1, 2, 3, true},
+		{"word overflow", ir.BinOpAdd, word, 1<<31 - 1, 1, -1 << 31, true},
+		{"long", ir.BinOpMul, long, 1 << 31, 4, 1 << 33, true},
+		{"div", ir.BinOpDiv, word, -7, 2, -3, true},
+		{"rem", ir.BinOpMod, word, -7, 2, -1, true},
+		{"div by zero", ir.BinOpDiv, word, 1, 0, 0, false},
+		{"shl modulo width", ir.BinOpShl, word, 1, 33, 2, true},
+		{"shr is logical", ir.BinOpShr, word, -1, 28, 15, true},
+		{"compare", ir.BinOpLt, word, -1, 1, 1, true},
+		{"compare words", ir.BinOpEq, word, 1 << 32, 0, 1, true},
+		{"compare longs", ir.BinOpEq, long, 1 << 32, 0, 0, true},
+		{"unsigned div", ir.BinOpUDiv, word, -8, 2, 1<<31 - 4, true},
+		{"unsigned rem", ir.BinOpUMod, word, -7, 2, 1, true},
+		{"unsigned div by zero", ir.BinOpUDiv, word, 1, 0, 0, false},
+		{"unsigned compare", ir.BinOpULt, word, -1, 1, 0, true},
+		{"unsigned compare longs", ir.BinOpUGe, long, -1, 1 << 32, 1, true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			b := ir.NewBinop(loc, tc.op, ir.NewValIdent(loc, "r", tc.abiTy),
+				ir.NewValInteger(loc, tc.x, tc.abiTy), ir.NewValInteger(loc, tc.y, tc.abiTy))
+
+			val, ok := foldBinop(b)
+			require.Equal(t, tc.folded, ok)
+
+			if ok {
+				r, _ := val.IntConst()
+				require.Equal(t, tc.expected, r)
+			}
+		})
+	}
+}
+
+func TestConstFold(t *testing.T) {
+	t.Parallel()
+
+	word := ir.NewAbiTyBase(ir.BaseWord)
+	ident := func(name ir.Ident) *ir.Val { return ir.NewValIdent(loc, name, word) }
+	integer := func(i int64) *ir.Val { return ir.NewValInteger(loc, i, word) }
+	arg := ir.NewValIdent(loc, "arg", word)
+
+	// if 2 < 1 { x = arg } else { x = 2 * 3 }; return x + 1
+	fd := ir.NewFuncDef(loc, "f", ir.NewParamRegular(loc, word, "arg")).WithRetTy(word).WithBlocks(
+		ir.NewBlock(loc, "start", []ir.Instruction{
+			ir.NewBinop(loc, ir.BinOpLt, ident("c"), integer(2), integer(1)),
+			ir.NewJnz(loc, ident("c"), "then", "else"),
+		}),
+		ir.NewBlock(loc, "then", []ir.Instruction{ir.NewJmp(loc, "end")}),
+		ir.NewBlock(loc, "else", []ir.Instruction{
+			ir.NewBinop(loc, ir.BinOpMul, ident("m"), integer(2), integer(3)),
+			ir.NewJmp(loc, "end"),
+		}),
+		ir.NewBlock(loc, "end", []ir.Instruction{
+			ir.NewPhi(loc, ident("x"), ir.NewPhiArg("then", arg), ir.NewPhiArg("else", ident("m"))),
+			ir.NewBinop(loc, ir.BinOpAdd, ident("r"), ident("x"), integer(1)),
+			ir.NewRet(loc, ident("r")),
+		}),
+	)
+
+	unit := ir.NewCompilationUnit()
+	unit.WithFuncDefs(fd)
+	require.NoError(t, Run(unit, ConstFold{}))
+	verify(t, unit)
+
+	folded := unit.FuncDefs[0]
+
+	var labels []string
+	for _, block := range folded.Blocks {
+		labels = append(labels, block.Label)
+	}
+
+	require.Equal(t, []string{"start", "else", "end"}, labels)
+	require.Equal(t, []ir.Instruction{ir.NewJmp(loc, "else")}, folded.Blocks[0].Instructions)
+	require.Equal(t, []ir.Instruction{ir.NewJmp(loc, "end")}, folded.Blocks[1].Instructions)
+	require.Equal(t, []ir.Instruction{ir.NewRet(loc, integer(7))}, folded.Blocks[2].Instructions)
+}
@@ -0,0 +1,91 @@
+package passes
+
+import (
+	"github.com/corani/cubit/internal/ir"
+)
+
+// CopyProp replaces the temporaries that are copies of another value by that
+// value. Lowering moves values with arithmetic that doesn't change them, like
+// `add %x, 0`; such an operation is removed and its uses renamed. An operation
+// that changes the type of its operand, like the `add` that truncates a long to
+// a word, isn't a copy and is kept.
+type CopyProp struct{}
+
+func (CopyProp) Name() string {
+	return "copyprop"
+}
+
+func (CopyProp) Run(fd *ir.FuncDef) bool {
+	values := make(map[ir.Ident]*ir.Val)
+	changed := false
+
+	for i := range fd.Blocks {
+		block := &fd.Blocks[i]
+
+		var instrs []ir.Instruction
+
+		for _, instr := range block.Instructions {
+			for _, op := range ir.Operands(instr) {
+				*op = resolve(values, *op)
+			}
+
+			if b, ok := instr.(*ir.Binop); ok {
+				if val := copied(b); val != nil {
+					values[b.Ret.Ident] = val
+					changed = true
+
+					continue
+				}
+			}
+
+			instrs = append(instrs, instr)
+		}
+
+		block.Instructions = instrs
+	}
+
+	// Phis can use values defined later, through the back edge of a loop.
+	replace(fd, values)
+
+	return changed
+}
+
+// copied returns the operand a binary operation copies, or nil if it computes
+// a new value.
+func copied(b *ir.Binop) *ir.Val {
+	is := func(val *ir.Val, i int64) bool {
+		c, ok := val.IntConst()
+		return ok && c == i
+	}
+
+	var val *ir.Val
+
+	switch b.Op {
+	case ir.BinOpAdd, ir.BinOpOr:
+		if is(b.Rhs, 0) {
+			val = b.Lhs
+		} else if is(b.Lhs, 0) {
+			val = b.Rhs
+		}
+	case ir.BinOpSub, ir.BinOpShl, ir.BinOpShr:
+		if is(b.Rhs, 0) {
+			val = b.Lhs
+		}
+	case ir.BinOpMul:
+		if is(b.Rhs, 1) {
+			val = b.Lhs
+		} else if is(b.Lhs, 1) {
+			val = b.Rhs
+		}
+	case ir.BinOpDiv, ir.BinOpUDiv:
+		if is(b.Rhs, 1) {
+			val = b.Lhs
+		}
+	}
+
+	if val == nil || (val.Type == ir.ValIdent && val.AbiTy != b.Ret.AbiTy) {
+		return nil
+	}
+
+	return val
+}
@@ -0,0 +1,56 @@
+package passes
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyProp(t *testing.T) {
+	t.Parallel()
+
+	word, long := ir.NewAbiTyBase(ir.BaseWord), ir.NewAbiTyBase(ir.BaseLong)
+	x := ir.NewValIdent(loc, "x", word)
+	p := ir.NewValIdent(loc, "p", long)
+
+	tt := []struct {
+		name     string
+		op       ir.BinOpKind
+		lhs, rhs *ir.Val
+		expected *ir.Val
+	}{
+		{"add zero", ir.BinOpAdd, x, ir.NewValInteger(loc, 0, word), x},
+		{"zero add", ir.BinOpAdd, ir.NewValInteger(loc, 0, word), x, x},
+		{"sub zero", ir.BinOpSub, x, ir.NewValInteger(loc, 0, word), x},
+		{"zero sub", ir.BinOpSub, ir.NewValInteger(loc, 0, word), x, nil},
+		{"mul one", ir.BinOpMul, ir.NewValInteger(loc, 1, word), x, x},
+		{"div one", ir.BinOpDiv, x, ir.NewValInteger(loc, 1, word), x},
+		{"shift zero", ir.BinOpShl, x, ir.NewValInteger(loc, 0, word), x},
+		{"truncation", ir.BinOpAdd, p, ir.NewValInteger(loc, 0, long), nil},
+		{"not a copy", ir.BinOpAdd, x, ir.NewValInteger(loc, 1, word), nil},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ret := ir.NewRet(loc, ir.NewValIdent(loc, "r", word))
+			params := []*ir.Param{ir.NewParamRegular(loc, word, "x"), ir.NewParamRegular(loc, long, "p")}
+			fd := ir.NewFuncDef(loc, "f", params...).WithRetTy(word).WithBlocks(
+				ir.NewBlock(loc, "start", []ir.Instruction{
+					ir.NewBinop(loc, tc.op, ir.NewValIdent(loc, "r", word), tc.lhs, tc.rhs),
+					ret,
+				}))
+
+			changed := CopyProp{}.Run(&fd)
+			require.Equal(t, tc.expected != nil, changed)
+			verify(t, ir.NewCompilationUnit().WithFuncDefs(fd))
+
+			if tc.expected != nil {
+				require.Equal(t, []ir.Instruction{ret}, fd.Blocks[0].Instructions)
+				require.Equal(t, tc.expected, ret.Val)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package passes
+
+import (
+	"github.com/corani/cubit/internal/ir"
+)
+
+// DeadCode removes the blocks that can't be reached from the entry, like the
+// code after a return or the branch a constant condition never takes, and the
+// instructions whose results are never used. Calls, stores and terminators
+// have effects beyond their result, so they're always kept, together with
+// everything they use, directly or through other instructions. Values that are
+// only used by each other, like a counter in a loop whose value is never read,
+// are removed as a whole.
+type DeadCode struct{}
+
+func (DeadCode) Name() string {
+	return "dce"
+}
+
+func (DeadCode) Run(fd *ir.FuncDef) bool {
+	blocks := len(fd.Blocks)
+	fd.RemoveUnreachable()
+	changed := len(fd.Blocks) != blocks
+
+	defs := make(map[ir.Ident]ir.Instruction)
+	live := make(map[ir.Instruction]bool)
+
+	var work []ir.Instruction
+
+	for _, block := range fd.Blocks {
+		for _, instr := range block.Instructions {
+			if ret := result(instr); ret != nil {
+				defs[ret.Ident] = instr
+			} else {
+				live[instr] = true
+				work = append(work, instr)
+			}
+		}
+	}
+
+	for len(work) > 0 {
+		instr := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		for _, op := range ir.Operands(instr) {
+			if (*op).Type != ir.ValIdent {
+				continue
+			}
+
+			if def, ok := defs[(*op).Ident]; ok && !live[def] {
+				live[def] = true
+				work = append(work, def)
+			}
+		}
+	}
+
+	for i := range fd.Blocks {
+		block := &fd.Blocks[i]
+
+		var instrs []ir.Instruction
+
+		for _, instr := range block.Instructions {
+			if !live[instr] {
+				changed = true
+
+				continue
+			}
+
+			instrs = append(instrs, instr)
+		}
+
+		block.Instructions = instrs
+	}
+
+	return changed
+}
+
+// result returns the temporary an instruction without other effects assigns,
+// or nil if the instruction has effects.
+func result(instr ir.Instruction) *ir.Val {
+	switch instr := instr.(type) {
+	case *ir.Binop:
+		return instr.Ret
+	case *ir.Load:
+		return instr.Ret
+	case *ir.Convert:
+		return instr.Ret
+	case *ir.Alloc:
+		return instr.Ret
+	case *ir.Phi:
+		return instr.Ret
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,58 @@
+package passes
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadCode(t *testing.T) {
+	t.Parallel()
+
+	word, long := ir.NewAbiTyBase(ir.BaseWord), ir.NewAbiTyBase(ir.BaseLong)
+	ident := func(name ir.Ident) *ir.Val { return ir.NewValIdent(loc, name, word) }
+	integer := func(i int64) *ir.Val { return ir.NewValInteger(loc, i, word) }
+	arg := ir.NewValIdent(loc, "arg", word)
+	slot := ir.NewValIdent(loc, "slot", long)
+
+	// The counter i is only used to compute itself, the load is never used and
+	// the block after the return can't be reached. The store and everything it
+	// uses stays.
+	store := ir.NewStore(loc, slot, ident("sum"))
+	ret := ir.NewRet(loc, arg)
+
+	fd := ir.NewFuncDef(loc, "f", ir.NewParamRegular(loc, word, "arg")).WithRetTy(word).WithBlocks(
+		ir.NewBlock(loc, "start", []ir.Instruction{
+			ir.NewAlloc(loc, slot, ir.NewValInteger(loc, 4, long)),
+			ir.NewAlloc(loc, ir.NewValIdent(loc, "unused", long), ir.NewValInteger(loc, 4, long)),
+			ir.NewJmp(loc, "loop"),
+		}),
+		ir.NewBlock(loc, "loop", []ir.Instruction{
+			ir.NewPhi(loc, ident("i"), ir.NewPhiArg("start", integer(0)), ir.NewPhiArg("loop", ident("next"))),
+			ir.NewBinop(loc, ir.BinOpAdd, ident("next"), ident("i"), integer(1)),
+			ir.NewBinop(loc, ir.BinOpMul, ident("sum"), arg, arg),
+			ir.NewLoad(loc, ident("loaded"), slot),
+			store,
+			ir.NewJnz(loc, arg, "loop", "end"),
+		}),
+		ir.NewBlock(loc, "end", []ir.Instruction{ret}),
+		ir.NewBlock(loc, "after", []ir.Instruction{ir.NewRet(loc, integer(0))}),
+	)
+
+	unit := ir.NewCompilationUnit()
+	unit.WithFuncDefs(fd)
+	require.NoError(t, Run(unit, DeadCode{}))
+	verify(t, unit)
+
+	remaining := make(map[string][]ir.Instruction)
+	for _, block := range unit.FuncDefs[0].Blocks {
+		remaining[block.Label] = block.Instructions
+	}
+
+	require.Equal(t, map[string][]ir.Instruction{
+		"start": {fd.Blocks[0].Instructions[0], fd.Blocks[0].Instructions[2]},
+		"loop":  {fd.Blocks[1].Instructions[2], store, fd.Blocks[1].Instructions[5]},
+		"end":   {ret},
+	}, remaining)
+}
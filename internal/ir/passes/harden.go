@@ -0,0 +1,339 @@
+package passes
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Hardening selects the run-time checks that stop a buggy program before it
+// does more damage. They cost time and space, so they're opt-in.
+type Hardening struct {
+	// Canaries put a random value between the stack slots of a function and
+	// its return address, and check it before the function returns. A write
+	// past the end of a slot that changes it calls __stack_chk_fail. The value
+	// comes from getauxval, so it needs Linux.
+	Canaries bool
+	// ZeroSlots zeroes the stack slots of a function when it starts, so a
+	// slot that's read before it's written doesn't leak old values.
+	ZeroSlots bool
+	// TrapOverflow stops the program when arithmetic on ints overflows. It's
+	// checked while lowering, with ir.LowerOptions.TrapOverflow, before the
+	// optimizations could fold an overflowing constant expression.
+	TrapOverflow bool
+}
+
+// hardenings are the names of the checks, as given to ParseHardening.
+var hardenings = map[string]func(h *Hardening){
+	"canary":   func(h *Hardening) { h.Canaries = true },
+	"zero":     func(h *Hardening) { h.ZeroSlots = true },
+	"overflow": func(h *Hardening) { h.TrapOverflow = true },
+	"all": func(h *Hardening) {
+		*h = Hardening{Canaries: true, ZeroSlots: true, TrapOverflow: true}
+	},
+}
+
+// ParseHardening parses a comma-separated list of checks: canary, zero,
+// overflow, or all of them.
+func ParseHardening(list string) (Hardening, error) {
+	var h Hardening
+
+	for name := range strings.SplitSeq(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		enable, ok := hardenings[name]
+		if !ok {
+			return Hardening{}, fmt.Errorf("unknown check %q, want canary, zero, overflow or all", name)
+		}
+
+		enable(&h)
+	}
+
+	return h, nil
+}
+
+// Harden adds the checks of h to the functions of unit. It runs once, after
+// the optimizations, so they don't move or remove the checks, and the slots
+// that were optimized away aren't zeroed or protected.
+func Harden(unit *ir.CompilationUnit, h Hardening) error {
+	protected := false
+
+	for i := range unit.FuncDefs {
+		fd := &unit.FuncDefs[i]
+
+		// Extern functions don't have a body, and functions with another
+		// calling convention can't make calls in their prologue.
+		if fd.Blocks == nil || fd.CallConv != ir.CallConvC {
+			continue
+		}
+
+		if h.ZeroSlots {
+			zeroSlots(fd)
+		}
+
+		if h.Canaries && protectStack(fd) {
+			protected = true
+		}
+
+		if err := fd.Verify(); err != nil {
+			return fmt.Errorf("after hardening: %w", err)
+		}
+	}
+
+	if protected {
+		unit.WithExterns(unit.Loc, "getauxval", "__stack_chk_fail")
+	}
+
+	return nil
+}
+
+// zeroSlotsUnroll is the size up to which a slot is zeroed by a store per
+// word. Larger slots are zeroed in a loop.
+const zeroSlotsUnroll = 64
+
+// zeroSlots zeroes the stack slots of fd of a constant size. The allocations
+// are moved to the top of their block, which is safe as their sizes are
+// constants, and the zeroing follows them, so a loop that splits the block
+// doesn't move a slot out of the start block.
+func zeroSlots(fd *ir.FuncDef) {
+	n := 0
+
+	for i := 0; i < len(fd.Blocks); i++ {
+		block := fd.Blocks[i]
+
+		var head, allocs, rest []ir.Instruction
+
+		for _, instr := range block.Instructions {
+			switch instr := instr.(type) {
+			case *ir.Phi:
+				head = append(head, instr)
+			case *ir.Alloc:
+				allocs = append(allocs, instr)
+			default:
+				rest = append(rest, instr)
+			}
+		}
+
+		if len(allocs) == 0 {
+			continue
+		}
+
+		blocks := []ir.Block{ir.NewBlock(block.Loc, block.Label, append(head, allocs...))}
+		cur := &blocks[0]
+
+		for _, instr := range allocs {
+			alloc := instr.(*ir.Alloc)
+
+			size, ok := alloc.Size.IntConst()
+			if !ok {
+				continue
+			}
+
+			loc, ptr := alloc.Loc, alloc.Ret.AbiTy
+
+			// temp returns a new temporary for the zeroing.
+			temp := func(tag string, ty ir.AbiTy) *ir.Val {
+				n++
+
+				return ir.NewValIdent(loc, ir.Ident(fmt.Sprintf("zero.%s.%d", tag, n)), ty)
+			}
+
+			// store zeroes size bytes at off in the slot.
+			store := func(off *ir.Val, size int64) {
+				addr := alloc.Ret
+				if k, ok := off.IntConst(); !ok || k != 0 {
+					addr = temp("addr", ptr)
+					cur.Instructions = append(cur.Instructions, ir.NewBinop(loc, ir.BinOpAdd, addr, alloc.Ret, off))
+				}
+
+				cur.Instructions = append(cur.Instructions, zeroStore(loc, addr, size))
+			}
+
+			step := min(int64(alloc.Align), 8)
+			off := int64(0)
+
+			if size > zeroSlotsUnroll {
+				// @zero.N:
+				//   %off =l phi @prev 0, @zero.N %next
+				//   <store a step at %off>
+				//   %next =l add %off, step
+				//   %more =w ult %next, end
+				//   jnz %more, @zero.N, @zero.N.done
+				n++
+				loop := fmt.Sprintf("%s.zero%d", block.Label, n)
+				done := loop + ".done"
+				end := size / step * step
+
+				cur.Instructions = append(cur.Instructions, ir.NewJmp(loc, loop))
+				prev := cur.Label
+
+				blocks = append(blocks, ir.NewBlock(loc, loop, nil))
+				cur = &blocks[len(blocks)-1]
+
+				at, next, more := temp("off", ptr), temp("next", ptr), temp("more", ir.NewAbiTyBase(ir.BaseWord))
+				cur.Instructions = append(cur.Instructions, ir.NewPhi(loc, at,
+					ir.NewPhiArg(prev, ir.NewValInteger(loc, 0, ptr)),
+					ir.NewPhiArg(loop, next)))
+				store(at, step)
+				cur.Instructions = append(cur.Instructions,
+					ir.NewBinop(loc, ir.BinOpAdd, next, at, ir.NewValInteger(loc, step, ptr)),
+					ir.NewBinop(loc, ir.BinOpULt, more, next, ir.NewValInteger(loc, end, ptr)),
+					ir.NewJnz(loc, more, loop, done))
+
+				blocks = append(blocks, ir.NewBlock(loc, done, nil))
+				cur = &blocks[len(blocks)-1]
+				off = end
+			}
+
+			for _, c := range []int64{8, 4, 2, 1} {
+				if c > step {
+					continue
+				}
+
+				for ; size-off >= c; off += c {
+					store(ir.NewValInteger(loc, off, ptr), c)
+				}
+			}
+		}
+
+		cur.Instructions = append(cur.Instructions, rest...)
+
+		// The terminator of the block moved to the last one, so the phis of
+		// its successors now receive their values from there.
+		if last := blocks[len(blocks)-1].Label; last != block.Label {
+			for _, label := range block.Successors() {
+				for k := range fd.Blocks {
+					if fd.Blocks[k].Label == label {
+						relabelPhis(&fd.Blocks[k], block.Label, last)
+					}
+				}
+			}
+		}
+
+		fd.Blocks = slices.Concat(fd.Blocks[:i], blocks, fd.Blocks[i+1:])
+		i += len(blocks) - 1
+	}
+}
+
+// zeroStore returns a store of size zero bytes to addr.
+func zeroStore(loc lexer.Location, addr *ir.Val, size int64) *ir.Store {
+	word, long := ir.NewAbiTyBase(ir.BaseWord), ir.NewAbiTyBase(ir.BaseLong)
+
+	switch size {
+	case 8:
+		return ir.NewStore(loc, addr, ir.NewValInteger(loc, 0, long))
+	case 4:
+		return ir.NewStore(loc, addr, ir.NewValInteger(loc, 0, word))
+	case 2:
+		return ir.NewStore(loc, addr, ir.NewValInteger(loc, 0, word)).WithTy(ir.NewAbiTySubW(ir.SubWUH))
+	default:
+		return ir.NewStore(loc, addr, ir.NewValInteger(loc, 0, word)).WithTy(ir.NewAbiTySubW(ir.SubWUB))
+	}
+}
+
+// atRandom is the key of the address of 16 random bytes in the auxiliary
+// vector, AT_RANDOM.
+const atRandom = 25
+
+// protectStack adds a canary to fd if it has stack slots, which a write past
+// their end could overflow. The canary is the first slot, so the backends
+// that lay out slots in order put it between the others and the return
+// address. It reports whether it added one.
+func protectStack(fd *ir.FuncDef) bool {
+	hasSlots := false
+
+	for _, block := range fd.Blocks {
+		for _, instr := range block.Instructions {
+			if _, ok := instr.(*ir.Alloc); ok {
+				hasSlots = true
+			}
+		}
+	}
+
+	if !hasSlots {
+		return false
+	}
+
+	loc := fd.Loc
+	long := ir.NewAbiTyBase(ir.BaseLong)
+	slot := ir.NewValIdent(loc, "canary.slot", long)
+	guard := ir.NewValIdent(loc, "canary.guard", long)
+	aux := ir.NewValIdent(loc, "canary.aux", long)
+	random := ir.NewValIdent(loc, "canary.random", long)
+
+	// The guard is the first word of the random bytes the kernel passes to
+	// the program, like the canary of libc, with the low byte cleared so a
+	// string copy stops at it.
+	start := &fd.Blocks[0]
+	top := 0
+
+	for top < len(start.Instructions) {
+		if _, ok := start.Instructions[top].(*ir.Alloc); !ok {
+			break
+		}
+
+		top++
+	}
+
+	start.Instructions = slices.Concat(
+		[]ir.Instruction{ir.NewAlloc(loc, slot, ir.NewValInteger(loc, 8, long))},
+		start.Instructions[:top],
+		[]ir.Instruction{
+			ir.NewCall(loc, ir.NewValGlobal(loc, "getauxval", long),
+				ir.NewArgRegular(loc, ir.NewValInteger(loc, atRandom, long))).WithRet(aux.Ident, long),
+			ir.NewLoad(loc, random, aux),
+			ir.NewBinop(loc, ir.BinOpAnd, guard, random, ir.NewValInteger(loc, -256, long)),
+			ir.NewStore(loc, slot, guard),
+		},
+		start.Instructions[top:],
+	)
+
+	const fail = "canary.fail"
+
+	n := 0
+	blocks := make([]ir.Block, 0, len(fd.Blocks)+1)
+
+	for _, block := range fd.Blocks {
+		ret, ok := block.Terminator().(*ir.Ret)
+		if !ok {
+			blocks = append(blocks, block)
+			continue
+		}
+
+		// Before returning, the canary must still be the guard:
+		//   %check =l loadl %canary.slot
+		//   %ok =w ceql %check, %canary.guard
+		//   jnz %ok, @<block>.ret, @canary.fail
+		n++
+		check := ir.NewValIdent(ret.Loc, ir.Ident(fmt.Sprintf("canary.check.%d", n)), long)
+		same := ir.NewValIdent(ret.Loc, ir.Ident(fmt.Sprintf("canary.ok.%d", n)), ir.NewAbiTyBase(ir.BaseWord))
+		label := block.Label + ".ret"
+
+		block.Instructions = slices.Concat(block.Instructions[:len(block.Instructions)-1], []ir.Instruction{
+			ir.NewLoad(ret.Loc, check, slot),
+			ir.NewBinop(ret.Loc, ir.BinOpEq, same, check, guard),
+			ir.NewJnz(ret.Loc, same, label, fail),
+		})
+
+		blocks = append(blocks, block, ir.NewBlock(ret.Loc, label, []ir.Instruction{ret}))
+	}
+
+	if n == 0 {
+		fd.Blocks = blocks
+		return true
+	}
+
+	// __stack_chk_fail reports the overflow and aborts; it doesn't return.
+	fd.Blocks = append(blocks, ir.NewBlock(loc, fail, []ir.Instruction{
+		ir.NewCall(loc, ir.NewValGlobal(loc, "__stack_chk_fail", long)),
+		ir.NewHlt(loc),
+	}))
+
+	return true
+}
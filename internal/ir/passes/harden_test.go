@@ -0,0 +1,167 @@
+package passes
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHardening(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		list     string
+		expected Hardening
+		err      string
+	}{
+		{list: "", expected: Hardening{}},
+		{list: "canary", expected: Hardening{Canaries: true}},
+		{list: "zero, overflow", expected: Hardening{ZeroSlots: true, TrapOverflow: true}},
+		{list: "all", expected: Hardening{Canaries: true, ZeroSlots: true, TrapOverflow: true}},
+		{list: "canary,bounds", err: `unknown check "bounds", want canary, zero, overflow or all`},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.list, func(t *testing.T) {
+			t.Parallel()
+
+			h, err := ParseHardening(tc.list)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, h)
+		})
+	}
+}
+
+func TestHarden(t *testing.T) {
+	t.Parallel()
+
+	word, long := ir.NewAbiTyBase(ir.BaseWord), ir.NewAbiTyBase(ir.BaseLong)
+	small := ir.NewValIdent(loc, "small", long)
+	big := ir.NewValIdent(loc, "big", long)
+
+	// f has a slot of 7 bytes and one of 84, which is zeroed in a loop. The
+	// slots are allocated after the store of the parameter, and the start
+	// block jumps to a block with a phi, which must see the block the jump
+	// moved to.
+	newUnit := func() *ir.CompilationUnit {
+		unit := ir.NewCompilationUnit()
+		unit.WithFuncDefs(ir.NewFuncDef(loc, "f", ir.NewParamRegular(loc, word, "x")).WithRetTy(word).WithBlocks(
+			ir.NewBlock(loc, "start", []ir.Instruction{
+				ir.NewAlloc(loc, small, ir.NewValInteger(loc, 7, long)).WithAlign(4),
+				ir.NewStore(loc, small, ir.NewValIdent(loc, "x", word)),
+				ir.NewAlloc(loc, big, ir.NewValInteger(loc, 84, long)),
+				ir.NewJmp(loc, "end"),
+			}),
+			ir.NewBlock(loc, "end", []ir.Instruction{
+				ir.NewPhi(loc, ir.NewValIdent(loc, "y", word), ir.NewPhiArg("start", ir.NewValIdent(loc, "x", word))),
+				ir.NewRet(loc, ir.NewValIdent(loc, "y", word)),
+			}),
+		))
+
+		return unit
+	}
+
+	// shape lists the labels of the blocks of f, and the instructions of
+	// each block by type.
+	shape := func(unit *ir.CompilationUnit) ([]string, map[string][]string) {
+		var labels []string
+
+		instrs := make(map[string][]string)
+
+		for _, block := range unit.FuncDefs[0].Blocks {
+			labels = append(labels, block.Label)
+
+			for _, instr := range block.Instructions {
+				var name string
+
+				switch instr := instr.(type) {
+				case *ir.Alloc:
+					name = "alloc " + string(instr.Ret.Ident)
+				case *ir.Store:
+					name = "store" + string(instr.Ty.BaseTy) + string(instr.Ty.SubWTy)
+				case *ir.Call:
+					name = "call " + string(instr.Val.Ident)
+				case *ir.Phi:
+					name = "phi " + instr.Args[0].Label
+				case *ir.Binop:
+					name = string(instr.Op)
+				case *ir.Load:
+					name = "load"
+				case *ir.Jmp:
+					name = "jmp " + instr.Label
+				case *ir.Jnz:
+					name = "jnz " + instr.True + " " + instr.False
+				case *ir.Ret:
+					name = "ret"
+				case *ir.Hlt:
+					name = "hlt"
+				}
+
+				instrs[block.Label] = append(instrs[block.Label], name)
+			}
+		}
+
+		return labels, instrs
+	}
+
+	t.Run("zero slots", func(t *testing.T) {
+		t.Parallel()
+
+		unit := newUnit()
+		require.NoError(t, Harden(unit, Hardening{ZeroSlots: true}))
+		verify(t, unit)
+
+		labels, instrs := shape(unit)
+		require.Equal(t, []string{"start", "start.zero3", "start.zero3.done", "end"}, labels)
+		require.Equal(t, map[string][]string{
+			"start": {
+				"alloc small", "alloc big",
+				"storew", "add", "storeuh", "add", "storeub",
+				"jmp start.zero3",
+			},
+			"start.zero3":      {"phi start", "add", "storel", "add", "ult", "jnz start.zero3 start.zero3.done"},
+			"start.zero3.done": {"add", "storew", "storew", "jmp end"},
+			"end":              {"phi start.zero3.done", "ret"},
+		}, instrs)
+	})
+
+	t.Run("canaries", func(t *testing.T) {
+		t.Parallel()
+
+		unit := newUnit()
+		require.NoError(t, Harden(unit, Hardening{Canaries: true}))
+		verify(t, unit)
+
+		labels, instrs := shape(unit)
+		require.Equal(t, []string{"start", "end", "end.ret", "canary.fail"}, labels)
+		require.Equal(t, map[string][]string{
+			"start": {
+				"alloc canary.slot", "alloc small",
+				"call getauxval", "load", "and", "storel",
+				"storew", "alloc big", "jmp end",
+			},
+			"end":         {"phi start", "load", "eq", "jnz end.ret canary.fail"},
+			"end.ret":     {"ret"},
+			"canary.fail": {"call __stack_chk_fail", "hlt"},
+		}, instrs)
+	})
+
+	t.Run("no slots", func(t *testing.T) {
+		t.Parallel()
+
+		unit := ir.NewCompilationUnit()
+		unit.WithFuncDefs(ir.NewFuncDef(loc, "f").WithBlocks(
+			ir.NewBlock(loc, "start", []ir.Instruction{ir.NewRet(loc)})))
+		require.NoError(t, Harden(unit, Hardening{Canaries: true, ZeroSlots: true}))
+		verify(t, unit)
+
+		labels, _ := shape(unit)
+		require.Equal(t, []string{"start"}, labels)
+	})
+}
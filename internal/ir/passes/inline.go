@@ -0,0 +1,342 @@
+package passes
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/ir/profile"
+)
+
+// DefaultInlineThreshold is the size, in instructions, up to which functions
+// are inlined without being marked @(inline).
+const DefaultInlineThreshold = 10
+
+// HotInlineFactor is how much larger than the threshold functions called
+// from hot blocks of a profile may be to be inlined.
+const HotInlineFactor = 4
+
+// Inline replaces calls to small functions, and to functions marked
+// @(inline), by a copy of the function's body. The temporaries and labels of
+// the copy are renamed, the parameters are replaced by the arguments, and
+// every return jumps to the rest of the calling block, where a phi picks up the
+// result. Recursive functions are never inlined, as inlining them wouldn't end.
+//
+// With a profile, calls from blocks that never ran aren't inlined unless the
+// function is marked, and calls from hot blocks are inlined up to
+// HotInlineFactor times the threshold.
+type Inline struct {
+	threshold int
+	profile   *profile.Profile
+	funcs     map[ir.Ident]*ir.FuncDef
+	recursive map[ir.Ident]bool
+	sites     map[ir.Ident]int // inlined calls per function, to name the copies
+	mu        sync.Mutex       // guards sites, as functions are inlined into at once
+}
+
+// NewInline returns an inlining pass that inlines functions up to threshold
+// instructions, and functions marked @(inline). With a threshold of 0, only
+// marked functions are inlined.
+func NewInline(threshold int) *Inline {
+	return &Inline{threshold: threshold}
+}
+
+// WithProfile makes the pass decide what to inline by how often the calls
+// ran.
+func (in *Inline) WithProfile(p *profile.Profile) *Inline {
+	in.profile = p
+
+	return in
+}
+
+func (in *Inline) Name() string {
+	return "inline"
+}
+
+// Prepare collects the functions of the unit and finds the recursive ones:
+// those that can reach themselves through calls.
+func (in *Inline) Prepare(unit *ir.CompilationUnit) {
+	in.funcs = make(map[ir.Ident]*ir.FuncDef)
+	in.recursive = make(map[ir.Ident]bool)
+	in.sites = make(map[ir.Ident]int)
+
+	for i := range unit.FuncDefs {
+		in.funcs[unit.FuncDefs[i].Ident] = &unit.FuncDefs[i]
+	}
+
+	graph := ir.NewCallGraph(unit)
+
+	for ident := range in.funcs {
+		if graph.Reachable(graph.Refs(ident)...)[ident] {
+			in.recursive[ident] = true
+		}
+	}
+}
+
+// Run inlines the first call in fd that qualifies. Run is repeated until
+// nothing changes, so every call is inlined eventually, including the calls
+// that were copied in.
+func (in *Inline) Run(fd *ir.FuncDef) bool {
+	for i, block := range fd.Blocks {
+		for j, instr := range block.Instructions {
+			call, ok := instr.(*ir.Call)
+			if !ok {
+				continue
+			}
+
+			if callee := in.callee(fd, block.Label, call); callee != nil {
+				in.inline(fd, i, j, call, callee)
+
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// callee returns the function a call in the block labeled label should be
+// replaced by, or nil if it shouldn't be inlined.
+func (in *Inline) callee(fd *ir.FuncDef, label string, call *ir.Call) *ir.FuncDef {
+	if call.Val.Type != ir.ValDynConst {
+		return nil
+	}
+
+	callee := in.funcs[call.Val.Ident]
+	if callee == nil || callee.Blocks == nil || callee.Ident == fd.Ident || in.recursive[callee.Ident] {
+		return nil
+	}
+
+	if len(call.Args) != len(callee.Params) {
+		return nil
+	}
+
+	for i, param := range callee.Params {
+		if param.Type != ir.ParamRegular || call.Args[i].Type != ir.ArgRegular {
+			return nil
+		}
+	}
+
+	if callee.Inline {
+		return callee
+	}
+
+	size := 0
+	for _, block := range callee.Blocks {
+		size += len(block.Instructions)
+	}
+
+	threshold := in.threshold
+
+	if in.profile != nil {
+		if block, ok := in.profile.Block(fd.Ident, label); ok {
+			switch {
+			case block.Count == 0:
+				return nil
+			case in.profile.Hot(block.Count):
+				threshold *= HotInlineFactor
+			}
+		}
+	}
+
+	if size > threshold {
+		return nil
+	}
+
+	return callee
+}
+
+// inline replaces instruction j of block i of fd, a call to callee, by a copy
+// of callee's blocks. The calling block is split: the instructions before the
+// call jump to the copy, and the ones after it move to a new block the copy
+// returns to.
+func (in *Inline) inline(fd *ir.FuncDef, i, j int, call *ir.Call, callee *ir.FuncDef) {
+	// Names only need to be unique within fd, so the copies are numbered per
+	// function, and inlining into one function doesn't rename the copies in
+	// another.
+	in.mu.Lock()
+	in.sites[fd.Ident]++
+	suffix := fmt.Sprintf(".i%d", in.sites[fd.Ident])
+	in.mu.Unlock()
+
+	block := fd.Blocks[i]
+	after := block.Label + ".after" + suffix
+
+	args := make(map[ir.Ident]*ir.Val)
+	for k, param := range callee.Params {
+		args[param.Ident] = call.Args[k].Val
+	}
+
+	rename := func(val *ir.Val) *ir.Val {
+		if val.Type != ir.ValIdent {
+			return val
+		}
+
+		if arg, ok := args[val.Ident]; ok {
+			return arg.WithAbiTy(val.AbiTy)
+		}
+
+		renamed := *val
+		renamed.Ident += ir.Ident(suffix)
+
+		return &renamed
+	}
+
+	var (
+		copies  []ir.Block
+		allocs  []ir.Instruction
+		results []ir.PhiArg
+	)
+
+	for _, cb := range callee.Blocks {
+		nb := ir.NewBlock(cb.Loc, cb.Label+suffix, nil)
+
+		for _, instr := range cb.Instructions {
+			instr = clone(instr)
+
+			for _, op := range ir.Operands(instr) {
+				*op = rename(*op)
+			}
+
+			switch instr := instr.(type) {
+			case *ir.Binop:
+				instr.Ret = rename(instr.Ret)
+			case *ir.Load:
+				instr.Ret = rename(instr.Ret)
+			case *ir.Convert:
+				instr.Ret = rename(instr.Ret)
+			case *ir.Phi:
+				instr.Ret = rename(instr.Ret)
+				for k := range instr.Args {
+					instr.Args[k].Label += suffix
+				}
+			case *ir.Call:
+				if instr.LHS != nil {
+					lhs := *instr.LHS + ir.Ident(suffix)
+					instr.LHS = &lhs
+				}
+			case *ir.Jmp:
+				instr.Label += suffix
+			case *ir.Jnz:
+				instr.True += suffix
+				instr.False += suffix
+			case *ir.Alloc:
+				// Stack slots are allocated in the start block of the caller,
+				// so a call in a loop doesn't grow the stack.
+				instr.Ret = rename(instr.Ret)
+				allocs = append(allocs, instr)
+
+				continue
+			case *ir.Ret:
+				if instr.Val != nil {
+					results = append(results, ir.NewPhiArg(nb.Label, instr.Val))
+				}
+
+				nb.Instructions = append(nb.Instructions, ir.NewJmp(instr.Loc, after))
+
+				continue
+			}
+
+			nb.Instructions = append(nb.Instructions, instr)
+		}
+
+		copies = append(copies, nb)
+	}
+
+	before := ir.NewBlock(block.Loc, block.Label, slices.Clone(block.Instructions[:j]))
+	before.Instructions = append(before.Instructions, ir.NewJmp(call.Loc, copies[0].Label))
+
+	rest := ir.NewBlock(call.Loc, after, nil)
+	if call.LHS != nil && call.RetTy != nil {
+		rest.Instructions = append(rest.Instructions,
+			ir.NewPhi(call.Loc, ir.NewValIdent(call.Loc, *call.LHS, *call.RetTy), results...))
+	}
+
+	rest.Instructions = append(rest.Instructions, block.Instructions[j+1:]...)
+
+	// The terminator of the calling block moved to the new block, so the
+	// phis of its successors now receive their values from there.
+	for _, label := range block.Successors() {
+		for k := range fd.Blocks {
+			if fd.Blocks[k].Label != label {
+				continue
+			}
+
+			// A block that jumps to itself shares its phis with before.
+			relabelPhis(&fd.Blocks[k], block.Label, after)
+		}
+	}
+
+	blocks := slices.Clone(fd.Blocks[:i])
+	blocks = append(blocks, before)
+	blocks = append(blocks, copies...)
+	blocks = append(blocks, rest)
+	blocks = append(blocks, fd.Blocks[i+1:]...)
+
+	blocks[0].Instructions = append(allocs, blocks[0].Instructions...)
+	fd.Blocks = blocks
+}
+
+// relabelPhis makes the phis of block take the values they received from the
+// block labeled from from the block labeled to instead.
+func relabelPhis(block *ir.Block, from, to string) {
+	for _, instr := range block.Instructions {
+		phi, ok := instr.(*ir.Phi)
+		if !ok {
+			break
+		}
+
+		for k := range phi.Args {
+			if phi.Args[k].Label == from {
+				phi.Args[k].Label = to
+			}
+		}
+	}
+}
+
+// clone returns a copy of an instruction that can be changed without changing
+// the original. Values are shared; they're replaced, never changed.
+func clone(instr ir.Instruction) ir.Instruction {
+	switch instr := instr.(type) {
+	case *ir.Ret:
+		c := *instr
+		return &c
+	case *ir.Hlt:
+		c := *instr
+		return &c
+	case *ir.Call:
+		c := *instr
+		c.Args = slices.Clone(instr.Args)
+
+		return &c
+	case *ir.Binop:
+		c := *instr
+		return &c
+	case *ir.Jmp:
+		c := *instr
+		return &c
+	case *ir.Jnz:
+		c := *instr
+		return &c
+	case *ir.Load:
+		c := *instr
+		return &c
+	case *ir.Store:
+		c := *instr
+		return &c
+	case *ir.Convert:
+		c := *instr
+		return &c
+	case *ir.Alloc:
+		c := *instr
+		return &c
+	case *ir.Phi:
+		c := *instr
+		c.Args = slices.Clone(instr.Args)
+
+		return &c
+	default:
+		panic(fmt.Sprintf("unknown instruction: %T", instr))
+	}
+}
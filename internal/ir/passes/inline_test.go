@@ -0,0 +1,130 @@
+package passes
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/ir/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInline(t *testing.T) {
+	t.Parallel()
+
+	word := ir.NewAbiTyBase(ir.BaseWord)
+	ident := func(name ir.Ident) *ir.Val { return ir.NewValIdent(loc, name, word) }
+	integer := func(i int64) *ir.Val { return ir.NewValInteger(loc, i, word) }
+
+	// abs(x) = x < 0 ? 0 - x : x
+	abs := func() ir.FuncDef {
+		return ir.NewFuncDef(loc, "abs", ir.NewParamRegular(loc, word, "x")).WithRetTy(word).WithBlocks(
+			ir.NewBlock(loc, "start", []ir.Instruction{
+				ir.NewBinop(loc, ir.BinOpLt, ident("c"), ident("x"), integer(0)),
+				ir.NewJnz(loc, ident("c"), "neg", "pos"),
+			}),
+			ir.NewBlock(loc, "neg", []ir.Instruction{
+				ir.NewBinop(loc, ir.BinOpSub, ident("n"), integer(0), ident("x")),
+				ir.NewRet(loc, ident("n")),
+			}),
+			ir.NewBlock(loc, "pos", []ir.Instruction{ir.NewRet(loc, ident("x"))}),
+		)
+	}
+
+	// rec(x) = rec(x)
+	rec := ir.NewFuncDef(loc, "rec", ir.NewParamRegular(loc, word, "x")).WithRetTy(word).WithBlocks(
+		ir.NewBlock(loc, "start", []ir.Instruction{
+			ir.NewCall(loc, ir.NewValGlobal(loc, "rec", word), ir.NewArgRegular(loc, ident("x"))).WithRet("r", word),
+			ir.NewRet(loc, ident("r")),
+		}),
+	)
+
+	main := func() ir.FuncDef {
+		return ir.NewFuncDef(loc, "main").WithRetTy(word).WithBlocks(
+			ir.NewBlock(loc, "start", []ir.Instruction{
+				ir.NewCall(loc, ir.NewValGlobal(loc, "abs", word), ir.NewArgRegular(loc, integer(-3))).WithRet("a", word),
+				ir.NewCall(loc, ir.NewValGlobal(loc, "rec", word), ir.NewArgRegular(loc, ident("a"))).WithRet("b", word),
+				ir.NewRet(loc, ident("b")),
+			}),
+		)
+	}
+
+	marked := abs()
+	marked.Inline = true
+
+	// The call to abs in the start block of main ran count times, and the
+	// hottest block of the program 1000 times.
+	ran := func(count int64) *profile.Profile {
+		return profile.New().
+			WithBlock("main", "start", profile.Block{Count: count}).
+			WithBlock("rec", "start", profile.Block{Count: 1000})
+	}
+
+	tt := []struct {
+		name      string
+		abs       ir.FuncDef
+		threshold int
+		profile   *profile.Profile
+		inlined   bool
+	}{
+		{"small", abs(), DefaultInlineThreshold, nil, true},
+		{"over threshold", abs(), 3, nil, false},
+		{"marked", marked, 0, nil, true},
+		{"hot", abs(), 3, ran(10), true},
+		{"warm", abs(), 3, ran(9), false},
+		{"cold", abs(), DefaultInlineThreshold, ran(0), false},
+		{"cold and marked", marked, 0, ran(0), true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			unit := ir.NewCompilationUnit()
+			unit.WithFuncDefs(tc.abs, rec, main())
+			require.NoError(t, Run(unit, NewInline(tc.threshold).WithProfile(tc.profile)))
+			verify(t, unit)
+
+			fd := unit.FuncDefs[2]
+
+			var (
+				labels []string
+				calls  []ir.Ident
+			)
+
+			for _, block := range fd.Blocks {
+				labels = append(labels, block.Label)
+
+				for _, instr := range block.Instructions {
+					if call, ok := instr.(*ir.Call); ok {
+						calls = append(calls, call.Val.Ident)
+					}
+				}
+			}
+
+			if !tc.inlined {
+				require.Equal(t, []string{"start"}, labels)
+				require.Equal(t, []ir.Ident{"abs", "rec"}, calls)
+
+				return
+			}
+
+			// The recursive function stays a call.
+			require.Equal(t, []ir.Ident{"rec"}, calls)
+			require.Equal(t, []string{"start", "start.i1", "neg.i1", "pos.i1", "start.after.i1"}, labels)
+
+			require.Equal(t, []ir.Instruction{ir.NewJmp(loc, "start.i1")}, fd.Blocks[0].Instructions)
+			require.Equal(t, []ir.Instruction{
+				ir.NewBinop(loc, ir.BinOpLt, ident("c.i1"), integer(-3), integer(0)),
+				ir.NewJnz(loc, ident("c.i1"), "neg.i1", "pos.i1"),
+			}, fd.Blocks[1].Instructions)
+			require.Equal(t, []ir.Instruction{ir.NewJmp(loc, "start.after.i1")}, fd.Blocks[3].Instructions)
+			require.Equal(t, ir.NewPhi(loc, ident("a"),
+				ir.NewPhiArg("neg.i1", ident("n.i1")),
+				ir.NewPhiArg("pos.i1", integer(-3)),
+			), fd.Blocks[4].Instructions[0])
+
+			// The callee itself is unchanged.
+			require.Equal(t, abs().Blocks, unit.FuncDefs[0].Blocks)
+		})
+	}
+}
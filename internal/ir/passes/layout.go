@@ -0,0 +1,136 @@
+package passes
+
+import (
+	"fmt"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/ir/profile"
+)
+
+// Layout orders the blocks of the functions of unit by how often they ran, so
+// the code generator can leave out the jumps on the common path. A
+// conditional jump falls through to its second target, so one that mostly
+// went to its first target is inverted. Then every block is followed by the
+// target it falls through to, as long as that one didn't come before, and the
+// blocks that never ran move to the end.
+//
+// It runs once, after the optimizations, as the inverted conditions would be
+// folded back. Blocks that aren't in the profile, like the ones the
+// optimizations created, keep their place.
+func Layout(unit *ir.CompilationUnit, p *profile.Profile) error {
+	for i := range unit.FuncDefs {
+		fd := &unit.FuncDefs[i]
+
+		// Extern functions don't have a body.
+		if fd.Blocks == nil {
+			continue
+		}
+
+		invertBranches(fd, p)
+		orderBlocks(fd, p)
+
+		if err := fd.Verify(); err != nil {
+			return fmt.Errorf("after layout: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// branchWeights returns how often the conditional jump at the end of block
+// went to its first and its second target: from the counts of the jump if
+// the profile has them, or else from the counts of the targets.
+func branchWeights(fd *ir.FuncDef, p *profile.Profile, block *ir.Block, jnz *ir.Jnz) (int64, int64, bool) {
+	if b, ok := p.Block(fd.Ident, block.Label); ok && b.Branch {
+		first, second := b.Weights()
+
+		return first, second, true
+	}
+
+	first, ok1 := p.Block(fd.Ident, jnz.True)
+	second, ok2 := p.Block(fd.Ident, jnz.False)
+
+	return first.Count, second.Count, ok1 && ok2
+}
+
+// invertBranches makes the conditional jumps of fd that mostly went to their
+// first target jump to their second target if the condition is 0 instead.
+func invertBranches(fd *ir.FuncDef, p *profile.Profile) {
+	n := 0
+
+	for i := range fd.Blocks {
+		block := &fd.Blocks[i]
+
+		jnz, ok := block.Terminator().(*ir.Jnz)
+		if !ok || jnz.True == jnz.False {
+			continue
+		}
+
+		first, second, ok := branchWeights(fd, p, block, jnz)
+		if !ok || first <= second {
+			continue
+		}
+
+		n++
+		not := ir.NewValIdent(jnz.Loc, ir.Ident(fmt.Sprintf("layout.not.%d", n)), ir.NewAbiTyBase(ir.BaseWord))
+
+		block.Instructions = append(block.Instructions[:len(block.Instructions)-1],
+			ir.NewBinop(jnz.Loc, ir.BinOpEq, not, jnz.Cond, ir.NewValInteger(jnz.Loc, 0, jnz.Cond.AbiTy)),
+			ir.NewJnz(jnz.Loc, not, jnz.False, jnz.True))
+	}
+}
+
+// orderBlocks chains the blocks of fd that ran by their fall-through targets,
+// starting with the start block, and moves the blocks that never ran to the
+// end.
+func orderBlocks(fd *ir.FuncDef, p *profile.Profile) {
+	index := make(map[string]int, len(fd.Blocks))
+	for i, block := range fd.Blocks {
+		index[block.Label] = i
+	}
+
+	cold := func(block *ir.Block) bool {
+		b, ok := p.Block(fd.Ident, block.Label)
+
+		return ok && b.Count == 0
+	}
+
+	placed := make([]bool, len(fd.Blocks))
+	blocks := make([]ir.Block, 0, len(fd.Blocks))
+
+	for i := range fd.Blocks {
+		// The start block comes first even if it never ran.
+		if placed[i] || i > 0 && cold(&fd.Blocks[i]) {
+			continue
+		}
+
+		for j := i; ; {
+			placed[j] = true
+			blocks = append(blocks, fd.Blocks[j])
+
+			var next string
+
+			switch term := fd.Blocks[j].Terminator().(type) {
+			case *ir.Jmp:
+				next = term.Label
+			case *ir.Jnz:
+				next = term.False
+			}
+
+			k, ok := index[next]
+			if !ok || k == 0 || placed[k] || cold(&fd.Blocks[k]) {
+				break
+			}
+
+			j = k
+		}
+	}
+
+	for i := range fd.Blocks {
+		if !placed[i] {
+			blocks = append(blocks, fd.Blocks[i])
+		}
+	}
+
+	fd.Blocks = blocks
+}
@@ -0,0 +1,51 @@
+package passes
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/ir/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayout(t *testing.T) {
+	t.Parallel()
+
+	word := ir.NewAbiTyBase(ir.BaseWord)
+	ident := func(name ir.Ident) *ir.Val { return ir.NewValIdent(loc, name, word) }
+
+	unit := ir.NewCompilationUnit()
+	unit.WithFuncDefs(ir.NewFuncDef(loc, "f", ir.NewParamRegular(loc, word, "c")).WithBlocks(
+		ir.NewBlock(loc, "start", []ir.Instruction{ir.NewJnz(loc, ident("c"), "then", "else")}),
+		ir.NewBlock(loc, "else", []ir.Instruction{ir.NewJnz(loc, ident("c"), "rare", "end")}),
+		ir.NewBlock(loc, "rare", []ir.Instruction{ir.NewJmp(loc, "end")}),
+		ir.NewBlock(loc, "then", []ir.Instruction{ir.NewJmp(loc, "end")}),
+		ir.NewBlock(loc, "end", []ir.Instruction{ir.NewRet(loc)}),
+	))
+
+	// The jump of start mostly went to then, so it's inverted. The jump of
+	// else has no counts of its own, but rare never ran.
+	p := profile.New().
+		WithBlock("f", "start", profile.Block{Count: 100, Branch: true, Taken: 90}).
+		WithBlock("f", "then", profile.Block{Count: 90}).
+		WithBlock("f", "else", profile.Block{Count: 10}).
+		WithBlock("f", "rare", profile.Block{Count: 0}).
+		WithBlock("f", "end", profile.Block{Count: 100})
+
+	require.NoError(t, Layout(unit, p))
+	verify(t, unit)
+
+	fd := unit.FuncDefs[0]
+
+	var labels []string
+	for _, block := range fd.Blocks {
+		labels = append(labels, block.Label)
+	}
+
+	require.Equal(t, []string{"start", "then", "end", "else", "rare"}, labels)
+	require.Equal(t, []ir.Instruction{
+		ir.NewBinop(loc, ir.BinOpEq, ident("layout.not.1"), ident("c"), ir.NewValInteger(loc, 0, word)),
+		ir.NewJnz(loc, ident("layout.not.1"), "else", "then"),
+	}, fd.Blocks[0].Instructions)
+	require.Equal(t, []ir.Instruction{ir.NewJnz(loc, ident("c"), "rare", "end")}, fd.Blocks[3].Instructions)
+}
@@ -0,0 +1,172 @@
+package passes
+
+import (
+	"slices"
+
+	"github.com/corani/cubit/internal/ir"
+)
+
+// LICM moves computations whose operands don't change inside a loop, like the
+// `mul %n, 4` of an index into an array the loop doesn't resize, out of the
+// loop, so they're computed once instead of on every iteration. They move to
+// the end of the block that enters the loop; a loop that's entered by a
+// conditional jump gets a block of its own for them first. Loads and calls
+// aren't moved, as the loop may change what they depend on, and neither are
+// divisions that may trap, as the loop may not run at all.
+type LICM struct{}
+
+func (LICM) Name() string {
+	return "licm"
+}
+
+func (LICM) Run(fd *ir.FuncDef) bool {
+	cfg := ir.NewCFG(fd)
+
+	for _, loop := range cfg.Loops() {
+		var (
+			enter   *ir.Block
+			entries int
+		)
+
+		for _, pred := range cfg.Predecessors(loop.Header) {
+			if !loop.Blocks[pred] {
+				enter = pred
+				entries++
+			}
+		}
+
+		if entries != 1 {
+			continue
+		}
+
+		invariant := invariants(fd, loop)
+		if len(invariant) == 0 {
+			continue
+		}
+
+		if _, ok := enter.Terminator().(*ir.Jmp); !ok {
+			enter = preheader(fd, enter, loop.Header)
+		}
+
+		instrs := enter.Instructions[: len(enter.Instructions)-1 : len(enter.Instructions)-1]
+		instrs = append(instrs, invariant...)
+		enter.Instructions = append(instrs, enter.Terminator())
+
+		return true
+	}
+
+	return false
+}
+
+// invariants removes the instructions of the loop that compute the same value
+// on every iteration, and returns them in the order they depend on each other.
+func invariants(fd *ir.FuncDef, loop ir.Loop) []ir.Instruction {
+	defined := make(map[ir.Ident]bool)
+
+	for i := range fd.Blocks {
+		block := &fd.Blocks[i]
+		if !loop.Blocks[block] {
+			continue
+		}
+
+		for _, instr := range block.Instructions {
+			if val := result(instr); val != nil {
+				defined[val.Ident] = true
+			} else if call, ok := instr.(*ir.Call); ok && call.LHS != nil {
+				defined[*call.LHS] = true
+			}
+		}
+	}
+
+	var hoisted []ir.Instruction
+
+	// Blocks are visited in the order of the function, in which lowering
+	// defines temporaries before they're used.
+	for moved := true; moved; {
+		moved = false
+
+		for i := range fd.Blocks {
+			block := &fd.Blocks[i]
+			if !loop.Blocks[block] {
+				continue
+			}
+
+			var instrs []ir.Instruction
+
+			for _, instr := range block.Instructions {
+				if !movable(instr, defined) {
+					instrs = append(instrs, instr)
+
+					continue
+				}
+
+				delete(defined, result(instr).Ident)
+				hoisted = append(hoisted, instr)
+				moved = true
+			}
+
+			block.Instructions = instrs
+		}
+	}
+
+	return hoisted
+}
+
+// movable reports whether instr can be computed before the loop: it has no
+// effects, and none of its operands is defined in the loop.
+func movable(instr ir.Instruction, defined map[ir.Ident]bool) bool {
+	switch instr := instr.(type) {
+	case *ir.Binop:
+		switch instr.Op {
+		case ir.BinOpDiv, ir.BinOpUDiv, ir.BinOpMod, ir.BinOpUMod:
+			if c, ok := instr.Rhs.IntConst(); !ok || c == 0 || c == -1 {
+				return false
+			}
+		}
+	case *ir.Convert:
+	default:
+		return false
+	}
+
+	for _, op := range ir.Operands(instr) {
+		if (*op).Type == ir.ValIdent && defined[(*op).Ident] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// preheader inserts a block between pred and header, which pred jumps to
+// instead of header, and returns it.
+func preheader(fd *ir.FuncDef, pred, header *ir.Block) *ir.Block {
+	label := header.Label + ".pre"
+	loc := header.Loc
+
+	switch term := pred.Terminator().(type) {
+	case *ir.Jnz:
+		if term.True == header.Label {
+			term.True = label
+		}
+
+		if term.False == header.Label {
+			term.False = label
+		}
+	}
+
+	relabelPhis(header, pred.Label, label)
+
+	headerLabel := header.Label
+
+	for i := range fd.Blocks {
+		if fd.Blocks[i].Label != headerLabel {
+			continue
+		}
+
+		fd.Blocks = slices.Insert(fd.Blocks, i, ir.NewBlock(loc, label, []ir.Instruction{ir.NewJmp(loc, headerLabel)}))
+
+		return &fd.Blocks[i]
+	}
+
+	return nil
+}
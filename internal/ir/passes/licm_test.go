@@ -0,0 +1,61 @@
+package passes
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLICM(t *testing.T) {
+	t.Parallel()
+
+	word := ir.NewAbiTyBase(ir.BaseWord)
+	ident := func(name ir.Ident) *ir.Val { return ir.NewValIdent(loc, name, word) }
+	integer := func(i int64) *ir.Val { return ir.NewValInteger(loc, i, word) }
+
+	// for i := 0; i < n; i++ { s += n * 4 + i; q := s / n; r := s / 3 }, with
+	// the loop entered by a conditional jump.
+	fd := ir.NewFuncDef(loc, "f", ir.NewParamRegular(loc, word, "n")).WithRetTy(word).WithBlocks(
+		ir.NewBlock(loc, "start", []ir.Instruction{ir.NewJnz(loc, ident("n"), "loop", "end")}),
+		ir.NewBlock(loc, "loop", []ir.Instruction{
+			ir.NewPhi(loc, ident("i"), ir.NewPhiArg("start", integer(0)), ir.NewPhiArg("loop", ident("i2"))),
+			ir.NewPhi(loc, ident("s"), ir.NewPhiArg("start", integer(0)), ir.NewPhiArg("loop", ident("s2"))),
+			ir.NewBinop(loc, ir.BinOpMul, ident("a"), ident("n"), integer(4)),
+			ir.NewBinop(loc, ir.BinOpAdd, ident("b"), ident("a"), integer(1)),
+			ir.NewBinop(loc, ir.BinOpAdd, ident("s2"), ident("s"), ident("b")),
+			ir.NewBinop(loc, ir.BinOpDiv, ident("q"), integer(8), ident("n")),
+			ir.NewBinop(loc, ir.BinOpDiv, ident("r"), ident("n"), integer(3)),
+			ir.NewBinop(loc, ir.BinOpAdd, ident("i2"), ident("i"), ident("q")),
+			ir.NewBinop(loc, ir.BinOpLt, ident("c"), ident("i2"), ident("r")),
+			ir.NewJnz(loc, ident("c"), "loop", "end"),
+		}),
+		ir.NewBlock(loc, "end", []ir.Instruction{ir.NewRet(loc, ident("n"))}),
+	)
+
+	unit := ir.NewCompilationUnit()
+	unit.WithFuncDefs(fd)
+	require.NoError(t, Run(unit, LICM{}))
+	verify(t, unit)
+
+	blocks := unit.FuncDefs[0].Blocks
+
+	// The loop gets a block to enter it through, and the computations that
+	// only depend on n move there. Dividing by n may trap, so it stays.
+	require.Equal(t, "loop.pre", blocks[1].Label)
+	require.Equal(t, []ir.Instruction{
+		ir.NewBinop(loc, ir.BinOpMul, ident("a"), ident("n"), integer(4)),
+		ir.NewBinop(loc, ir.BinOpAdd, ident("b"), ident("a"), integer(1)),
+		ir.NewBinop(loc, ir.BinOpDiv, ident("r"), ident("n"), integer(3)),
+		ir.NewJmp(loc, "loop"),
+	}, blocks[1].Instructions)
+	require.Equal(t, []ir.Instruction{
+		ir.NewPhi(loc, ident("i"), ir.NewPhiArg("loop.pre", integer(0)), ir.NewPhiArg("loop", ident("i2"))),
+		ir.NewPhi(loc, ident("s"), ir.NewPhiArg("loop.pre", integer(0)), ir.NewPhiArg("loop", ident("s2"))),
+		ir.NewBinop(loc, ir.BinOpAdd, ident("s2"), ident("s"), ident("b")),
+		ir.NewBinop(loc, ir.BinOpDiv, ident("q"), integer(8), ident("n")),
+		ir.NewBinop(loc, ir.BinOpAdd, ident("i2"), ident("i"), ident("q")),
+		ir.NewBinop(loc, ir.BinOpLt, ident("c"), ident("i2"), ident("r")),
+		ir.NewJnz(loc, ident("c"), "loop", "end"),
+	}, blocks[2].Instructions)
+}
@@ -0,0 +1,280 @@
+// Package passes transforms IR functions, e.g. to optimize them. Passes run
+// after lowering, on functions in SSA form.
+package passes
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/ir/profile"
+	"github.com/corani/cubit/internal/parallel"
+)
+
+// Pass is a transformation of IR functions. Run changes fd in place and
+// reports whether it changed anything.
+type Pass interface {
+	Name() string
+	Run(fd *ir.FuncDef) bool
+}
+
+// UnitPass is a pass that needs to see the whole compilation unit, e.g. the
+// functions a function calls. Run calls Prepare before running the passes.
+type UnitPass interface {
+	Pass
+	Prepare(unit *ir.CompilationUnit)
+}
+
+// Options control the passes the compiler runs.
+type Options struct {
+	// InlineThreshold is the size, in instructions, up to which functions are
+	// inlined without being marked @(inline). With 0, only marked functions
+	// are inlined; a negative threshold disables inlining.
+	InlineThreshold int
+	// Profile is how often the blocks ran, from a run of the program built
+	// with profile.Instrument. The inliner favors the calls that ran often.
+	Profile *profile.Profile
+	// Jobs is the number of functions optimized at once, GOMAXPROCS if it's
+	// 0, see RunJobs.
+	Jobs int
+}
+
+// Default returns the passes the compiler runs, in order.
+func Default(opts Options) []Pass {
+	var passes []Pass
+
+	if opts.InlineThreshold >= 0 {
+		passes = append(passes, NewInline(opts.InlineThreshold).WithProfile(opts.Profile))
+	}
+
+	return append(passes,
+		ConstFold{},
+		CopyProp{},
+		StrengthReduce{},
+		DeadCode{},
+		LICM{},
+		Peephole{},
+	)
+}
+
+// Level is an optimization level, which selects the passes that run.
+type Level string
+
+const (
+	LevelNone  Level = "0" // no passes
+	LevelBasic Level = "1" // the passes without inlining
+	LevelFull  Level = "2" // all the passes
+	LevelSize  Level = "s" // all the passes, but only @(inline) functions are inlined
+)
+
+// Levels are the optimization levels, from the least to the most optimized,
+// and the one for size.
+var Levels = []Level{LevelNone, LevelBasic, LevelFull, LevelSize}
+
+// ParseLevel returns the optimization level s.
+func ParseLevel(s string) (Level, error) {
+	if !slices.Contains(Levels, Level(s)) {
+		return "", fmt.Errorf("unknown optimization level %q, want 0, 1, 2 or s", s)
+	}
+
+	return Level(s), nil
+}
+
+// Pipeline returns the passes of level, in order: the Default passes, with
+// inlining limited by the level.
+func Pipeline(level Level, opts Options) []Pass {
+	switch level {
+	case LevelNone:
+		return nil
+	case LevelBasic:
+		opts.InlineThreshold = -1
+	case LevelSize:
+		// Inlining copies the callee, so only the functions that ask for it
+		// are inlined.
+		opts.InlineThreshold = min(opts.InlineThreshold, 0)
+	}
+
+	return Default(opts)
+}
+
+// Select returns the passes of available that are named in names, in the
+// order of names, for a pipeline that's given explicitly.
+func Select(available []Pass, names []string) ([]Pass, error) {
+	var selected []Pass
+
+	for _, name := range names {
+		i := slices.IndexFunc(available, func(p Pass) bool { return p.Name() == name })
+		if i < 0 {
+			known := make([]string, len(available))
+			for j, pass := range available {
+				known[j] = pass.Name()
+			}
+
+			return nil, fmt.Errorf("unknown pass %q, want one of %s", name, strings.Join(known, ", "))
+		}
+
+		selected = append(selected, available[i])
+	}
+
+	return selected, nil
+}
+
+// Observe returns pass, calling fn with every function it changes, after it
+// ran, e.g. to dump the IR between passes. fn is called for the functions
+// that are optimized at once concurrently.
+func Observe(pass Pass, fn func(pass Pass, fd *ir.FuncDef)) Pass {
+	return &observed{Pass: pass, fn: fn}
+}
+
+type observed struct {
+	Pass
+	fn func(pass Pass, fd *ir.FuncDef)
+}
+
+func (o *observed) Run(fd *ir.FuncDef) bool {
+	changed := o.Pass.Run(fd)
+	if changed {
+		o.fn(o.Pass, fd)
+	}
+
+	return changed
+}
+
+func (o *observed) Prepare(unit *ir.CompilationUnit) {
+	if pass, ok := o.Pass.(UnitPass); ok {
+		pass.Prepare(unit)
+	}
+}
+
+// Measure returns pass, calling start before it runs on a function, and the
+// function start returns after, e.g. to time the pass.
+func Measure(pass Pass, start func(pass Pass, fd *ir.FuncDef) (stop func())) Pass {
+	return &measured{Pass: pass, start: start}
+}
+
+type measured struct {
+	Pass
+	start func(pass Pass, fd *ir.FuncDef) func()
+}
+
+func (m *measured) Run(fd *ir.FuncDef) bool {
+	stop := m.start(m.Pass, fd)
+	defer stop()
+
+	return m.Pass.Run(fd)
+}
+
+func (m *measured) Prepare(unit *ir.CompilationUnit) {
+	if pass, ok := m.Pass.(UnitPass); ok {
+		pass.Prepare(unit)
+	}
+}
+
+// Run runs the passes over every function of unit, in order, and repeats them
+// until none of them changes anything, as one pass can create work for
+// another. Functions are verified after every change, so a pass that breaks
+// the invariants of the IR is caught where it happens.
+func Run(unit *ir.CompilationUnit, passes ...Pass) error {
+	return RunJobs(unit, 0, passes...)
+}
+
+// RunJobs is Run, optimizing up to jobs functions at once, or GOMAXPROCS if
+// jobs is 0. A pass may look at other functions than the one it runs on, like
+// the inliner at the callees, so a function waits for the functions before it
+// that it calls or that call it, directly or not. They see the same functions
+// as if they ran in order, so the IR is the same for any number of jobs.
+// Passes must be safe to run on unrelated functions at once.
+func RunJobs(unit *ir.CompilationUnit, jobs int, passes ...Pass) error {
+	for _, pass := range passes {
+		if pass, ok := pass.(UnitPass); ok {
+			pass.Prepare(unit)
+		}
+	}
+
+	return parallel.Do(len(unit.FuncDefs), jobs, related(unit), func(i int) error {
+		fd := &unit.FuncDefs[i]
+
+		// Extern functions don't have a body.
+		if fd.Blocks == nil {
+			return nil
+		}
+
+		for changed := true; changed; {
+			changed = false
+
+			for _, pass := range passes {
+				if !pass.Run(fd) {
+					continue
+				}
+
+				changed = true
+
+				if err := fd.Verify(); err != nil {
+					return fmt.Errorf("after pass %s: %w", pass.Name(), err)
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// related returns, for every function of unit, the functions before it that
+// it can reach through calls, or that can reach it. The names of the functions
+// are read up front, as the passes change the functions while it's called.
+func related(unit *ir.CompilationUnit) func(i int) []int {
+	graph := ir.NewCallGraph(unit)
+	reachable := make([]map[ir.Ident]bool, len(unit.FuncDefs))
+	idents := make([]ir.Ident, len(unit.FuncDefs))
+	linkNames := make([]ir.Ident, len(unit.FuncDefs))
+
+	for i, fd := range unit.FuncDefs {
+		reachable[i] = graph.Reachable(fd.Ident)
+		idents[i] = fd.Ident
+		linkNames[i] = fd.LinkName
+	}
+
+	reaches := func(i, j int) bool {
+		return reachable[i][idents[j]] || linkNames[j] != "" && reachable[i][linkNames[j]]
+	}
+
+	return func(j int) []int {
+		var before []int
+
+		for i := range j {
+			if reaches(i, j) || reaches(j, i) {
+				before = append(before, i)
+			}
+		}
+
+		return before
+	}
+}
+
+// replace substitutes values for removed temporaries in every instruction of
+// fd.
+func replace(fd *ir.FuncDef, values map[ir.Ident]*ir.Val) {
+	for i := range fd.Blocks {
+		for _, instr := range fd.Blocks[i].Instructions {
+			for _, op := range ir.Operands(instr) {
+				*op = resolve(values, *op)
+			}
+		}
+	}
+}
+
+// resolve follows the replacements of removed temporaries. The replacement
+// takes the type of the temporary it replaces.
+func resolve(values map[ir.Ident]*ir.Val, val *ir.Val) *ir.Val {
+	for val.Type == ir.ValIdent {
+		next, ok := values[val.Ident]
+		if !ok {
+			break
+		}
+
+		val = next.WithAbiTy(val.AbiTy)
+	}
+
+	return val
+}
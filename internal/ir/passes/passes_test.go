@@ -0,0 +1,201 @@
+package passes
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/stretchr/testify/require"
+)
+
+type breakJumps struct{}
+
+func (breakJumps) Name() string { return "break" }
+
+func (breakJumps) Run(fd *ir.FuncDef) bool {
+	fd.Blocks[0].Instructions = []ir.Instruction{ir.NewJmp(loc, "nowhere")}
+
+	return true
+}
+
+func TestRun_Verifies(t *testing.T) {
+	t.Parallel()
+
+	unit := ir.NewCompilationUnit()
+	unit.WithFuncDefs(ir.NewFuncDef(loc, "f").WithBlocks(
+		ir.NewBlock(loc, "start", []ir.Instruction{ir.NewRet(loc)})))
+
+	err := Run(unit, breakJumps{})
+	require.EqualError(t, err, "after pass break: test.in:3:5: jump from block @start to unknown block @nowhere")
+}
+
+// changeOnce changes every function the first time it runs on it.
+type changeOnce map[ir.Ident]bool
+
+func (changeOnce) Name() string { return "once" }
+
+func (c changeOnce) Run(fd *ir.FuncDef) bool {
+	if c[fd.Ident] {
+		return false
+	}
+
+	c[fd.Ident] = true
+
+	return true
+}
+
+func TestObserve(t *testing.T) {
+	t.Parallel()
+
+	unit := ir.NewCompilationUnit()
+	unit.WithFuncDefs(
+		ir.NewFuncDef(loc, "f").WithBlocks(ir.NewBlock(loc, "start", []ir.Instruction{ir.NewRet(loc)})),
+		ir.NewFuncDef(loc, "g").WithBlocks(ir.NewBlock(loc, "start", []ir.Instruction{ir.NewRet(loc)})))
+
+	var observed []string
+
+	pass := Observe(changeOnce{}, func(pass Pass, fd *ir.FuncDef) {
+		observed = append(observed, pass.Name()+" "+string(fd.Ident))
+	})
+
+	require.NoError(t, RunJobs(unit, 1, pass))
+	require.Equal(t, []string{"once f", "once g"}, observed)
+}
+
+func TestMeasure(t *testing.T) {
+	t.Parallel()
+
+	unit := ir.NewCompilationUnit()
+	unit.WithFuncDefs(
+		ir.NewFuncDef(loc, "f").WithBlocks(ir.NewBlock(loc, "start", []ir.Instruction{ir.NewRet(loc)})),
+		ir.NewFuncDef(loc, "g").WithBlocks(ir.NewBlock(loc, "start", []ir.Instruction{ir.NewRet(loc)})))
+
+	var measured []string
+
+	pass := Measure(changeOnce{}, func(pass Pass, fd *ir.FuncDef) func() {
+		measured = append(measured, "start "+pass.Name()+" "+string(fd.Ident))
+
+		return func() { measured = append(measured, "stop "+pass.Name()+" "+string(fd.Ident)) }
+	})
+
+	require.NoError(t, RunJobs(unit, 1, pass))
+	require.Equal(t, []string{
+		"start once f", "stop once f", "start once f", "stop once f",
+		"start once g", "stop once g", "start once g", "stop once g",
+	}, measured)
+}
+
+func TestRelated(t *testing.T) {
+	t.Parallel()
+
+	word := ir.NewAbiTyBase(ir.BaseWord)
+
+	// fn returns a function that calls the callees.
+	fn := func(ident ir.Ident, callees ...ir.Ident) ir.FuncDef {
+		var instrs []ir.Instruction
+		for _, callee := range callees {
+			instrs = append(instrs, ir.NewCall(loc, ir.NewValGlobal(loc, callee, word)))
+		}
+
+		return ir.NewFuncDef(loc, ident).WithBlocks(ir.NewBlock(loc, "start", append(instrs, ir.NewRet(loc))))
+	}
+
+	unit := ir.NewCompilationUnit()
+	unit.WithFuncDefs(
+		fn("leaf"),
+		fn("mid", "leaf"),
+		fn("other"),
+		fn("top", "mid"),
+		fn("first", "later"),
+		fn("later"),
+	)
+
+	after := related(unit)
+
+	require.Empty(t, after(0))
+	require.Equal(t, []int{0}, after(1))
+	require.Empty(t, after(2))
+	require.Equal(t, []int{0, 1}, after(3))
+	require.Equal(t, []int{4}, after(5))
+}
+
+// cloneBlocks replaces the blocks of every function, like RemoveUnreachable
+// does, without changing them.
+type cloneBlocks struct{}
+
+func (cloneBlocks) Name() string { return "clone" }
+
+func (cloneBlocks) Run(fd *ir.FuncDef) bool {
+	fd.Blocks = slices.Clone(fd.Blocks)
+
+	return false
+}
+
+// TestRunJobs_Parallel is meant for -race: the passes change functions while
+// the ones after them are scheduled.
+func TestRunJobs_Parallel(t *testing.T) {
+	t.Parallel()
+
+	unit := ir.NewCompilationUnit()
+	for i := range 32 {
+		unit.WithFuncDefs(ir.NewFuncDef(loc, ir.Ident(fmt.Sprintf("f%d", i))).WithBlocks(
+			ir.NewBlock(loc, "start", []ir.Instruction{ir.NewRet(loc)})))
+	}
+
+	require.NoError(t, RunJobs(unit, 8, cloneBlocks{}))
+}
+
+func TestPipeline(t *testing.T) {
+	t.Parallel()
+
+	names := func(passes []Pass) []string {
+		var names []string
+		for _, pass := range passes {
+			names = append(names, pass.Name())
+		}
+
+		return names
+	}
+
+	all := []string{"inline", "constfold", "copyprop", "strength", "dce", "licm", "peephole"}
+
+	tt := []struct {
+		level    string
+		expected []string
+		err      string
+	}{
+		{level: "0"},
+		{level: "1", expected: all[1:]},
+		{level: "2", expected: all},
+		{level: "s", expected: all},
+		{level: "3", err: `unknown optimization level "3", want 0, 1, 2 or s`},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.level, func(t *testing.T) {
+			t.Parallel()
+
+			level, err := ParseLevel(tc.level)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, names(Pipeline(level, Options{InlineThreshold: DefaultInlineThreshold})))
+		})
+	}
+
+	// For size, only @(inline) functions are inlined.
+	inline := Pipeline(LevelSize, Options{InlineThreshold: DefaultInlineThreshold})[0].(*Inline)
+	require.Equal(t, 0, inline.threshold)
+
+	selected, err := Select(Default(Options{}), []string{"dce", "constfold", "dce"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"dce", "constfold", "dce"}, names(selected))
+
+	_, err = Select(Default(Options{InlineThreshold: -1}), []string{"inline"})
+	require.EqualError(t, err, `unknown pass "inline", want one of constfold, copyprop, strength, dce, licm, peephole`)
+}
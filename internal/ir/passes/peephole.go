@@ -0,0 +1,161 @@
+package passes
+
+import (
+	"slices"
+
+	"github.com/corani/cubit/internal/ir"
+)
+
+// Peephole cleans up the jumps lowering leaves behind, like the chains of
+// empty blocks at the end of nested ifs. A conditional jump whose targets are
+// the same becomes a jump; a jump to a block that does nothing but jump on
+// goes to the final target directly; and a block that's only entered from the
+// block before it is merged into that block. Arithmetic that doesn't change
+// its operand, like `mul %x, 1`, is removed by CopyProp, and jumps to the next
+// block are left out by the code generator.
+type Peephole struct{}
+
+func (Peephole) Name() string {
+	return "peephole"
+}
+
+func (Peephole) Run(fd *ir.FuncDef) bool {
+	changed := false
+
+	for i := range fd.Blocks {
+		block := &fd.Blocks[i]
+
+		if jnz, ok := block.Terminator().(*ir.Jnz); ok && jnz.True == jnz.False {
+			block.Instructions[len(block.Instructions)-1] = ir.NewJmp(jnz.Loc, jnz.True)
+			changed = true
+		}
+	}
+
+	for threadJump(fd) || mergeBlock(fd) {
+		changed = true
+	}
+
+	return changed
+}
+
+// threadJump finds a block that only jumps to another block, and makes its
+// predecessors jump to that block instead. The values the phis of the target
+// receive from the block are received from each of the predecessors. A
+// predecessor that already jumps to the target would need two values for a
+// phi, so a block with such a predecessor is kept.
+func threadJump(fd *ir.FuncDef) bool {
+	cfg := ir.NewCFG(fd)
+
+	for _, block := range cfg.Blocks[1:] {
+		jmp, ok := block.Terminator().(*ir.Jmp)
+		if !ok || len(block.Instructions) != 1 {
+			continue
+		}
+
+		target := cfg.Block(jmp.Label)
+		preds := cfg.Predecessors(block)
+
+		if target == nil || target == block || len(preds) == 0 {
+			continue
+		}
+
+		if slices.ContainsFunc(preds, func(pred *ir.Block) bool {
+			return pred == block || slices.Contains(cfg.Predecessors(target), pred)
+		}) {
+			continue
+		}
+
+		for _, instr := range target.Instructions {
+			phi, ok := instr.(*ir.Phi)
+			if !ok {
+				break
+			}
+
+			var args []ir.PhiArg
+
+			for _, arg := range phi.Args {
+				if arg.Label != block.Label {
+					args = append(args, arg)
+
+					continue
+				}
+
+				for _, pred := range preds {
+					args = append(args, ir.NewPhiArg(pred.Label, arg.Val))
+				}
+			}
+
+			phi.Args = args
+		}
+
+		for _, pred := range preds {
+			switch term := pred.Terminator().(type) {
+			case *ir.Jmp:
+				term.Label = target.Label
+			case *ir.Jnz:
+				if term.True == block.Label {
+					term.True = target.Label
+				}
+
+				if term.False == block.Label {
+					term.False = target.Label
+				}
+			}
+		}
+
+		fd.RemoveUnreachable()
+
+		return true
+	}
+
+	return false
+}
+
+// mergeBlock finds a block that's only entered by a jump from another block,
+// and appends its instructions to that block. With a single predecessor, its
+// phis have a single value, which replaces them.
+func mergeBlock(fd *ir.FuncDef) bool {
+	cfg := ir.NewCFG(fd)
+
+	for _, block := range cfg.Blocks {
+		jmp, ok := block.Terminator().(*ir.Jmp)
+		if !ok {
+			continue
+		}
+
+		next := cfg.Block(jmp.Label)
+		if next == nil || next == block || next == cfg.Entry() || len(cfg.Predecessors(next)) != 1 {
+			continue
+		}
+
+		values := make(map[ir.Ident]*ir.Val)
+		instrs := block.Instructions[:len(block.Instructions)-1]
+
+		for _, instr := range next.Instructions {
+			if phi, ok := instr.(*ir.Phi); ok {
+				values[phi.Ret.Ident] = phi.Args[0].Val
+
+				continue
+			}
+
+			instrs = append(instrs, instr)
+		}
+
+		block.Instructions = instrs
+
+		for _, succ := range cfg.Successors(next) {
+			relabelPhis(succ, next.Label, block.Label)
+		}
+
+		label := next.Label
+		fd.Blocks = slices.DeleteFunc(fd.Blocks, func(b ir.Block) bool {
+			return b.Label == label
+		})
+
+		replace(fd, values)
+
+		return true
+	}
+
+	return false
+}
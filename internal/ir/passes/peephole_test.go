@@ -0,0 +1,97 @@
+package passes
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeephole(t *testing.T) {
+	t.Parallel()
+
+	word := ir.NewAbiTyBase(ir.BaseWord)
+	ident := func(name ir.Ident) *ir.Val { return ir.NewValIdent(loc, name, word) }
+	integer := func(i int64) *ir.Val { return ir.NewValInteger(loc, i, word) }
+	arg := ir.NewValIdent(loc, "arg", word)
+
+	// if arg { if arg { x = 1 } else { x = 2 } }; return x, with the empty
+	// blocks lowering leaves at the end of nested ifs.
+	fd := ir.NewFuncDef(loc, "f", ir.NewParamRegular(loc, word, "arg")).WithRetTy(word).WithBlocks(
+		ir.NewBlock(loc, "start", []ir.Instruction{ir.NewJnz(loc, arg, "outer", "end")}),
+		ir.NewBlock(loc, "outer", []ir.Instruction{ir.NewJnz(loc, arg, "then", "else")}),
+		ir.NewBlock(loc, "then", []ir.Instruction{ir.NewJmp(loc, "inner")}),
+		ir.NewBlock(loc, "else", []ir.Instruction{ir.NewJnz(loc, arg, "inner", "inner")}),
+		ir.NewBlock(loc, "inner", []ir.Instruction{
+			ir.NewPhi(loc, ident("x"), ir.NewPhiArg("then", integer(1)), ir.NewPhiArg("else", integer(2))),
+			ir.NewJmp(loc, "join"),
+		}),
+		ir.NewBlock(loc, "join", []ir.Instruction{ir.NewJmp(loc, "end")}),
+		ir.NewBlock(loc, "end", []ir.Instruction{
+			ir.NewPhi(loc, ident("r"), ir.NewPhiArg("start", integer(0)), ir.NewPhiArg("join", ident("x"))),
+			ir.NewBinop(loc, ir.BinOpAdd, ident("s"), ident("r"), integer(1)),
+			ir.NewRet(loc, ident("s")),
+		}),
+	)
+
+	unit := ir.NewCompilationUnit()
+	unit.WithFuncDefs(fd)
+	require.NoError(t, Run(unit, Peephole{}))
+	verify(t, unit)
+
+	remaining := make(map[string][]ir.Instruction)
+	for _, block := range unit.FuncDefs[0].Blocks {
+		remaining[block.Label] = block.Instructions
+	}
+
+	// Jumps to then and join, which only jump on, go to their targets, and
+	// the phis receive their values from the blocks that jump there now. The
+	// jump to else is kept, as outer already jumps to inner and the phi would
+	// need two values for it.
+	require.Equal(t, map[string][]ir.Instruction{
+		"start": {ir.NewJnz(loc, arg, "outer", "end")},
+		"outer": {ir.NewJnz(loc, arg, "inner", "else")},
+		"else":  {ir.NewJmp(loc, "inner")},
+		"inner": {
+			ir.NewPhi(loc, ident("x"), ir.NewPhiArg("outer", integer(1)), ir.NewPhiArg("else", integer(2))),
+			ir.NewJmp(loc, "end"),
+		},
+		"end": {
+			ir.NewPhi(loc, ident("r"), ir.NewPhiArg("start", integer(0)), ir.NewPhiArg("inner", ident("x"))),
+			ir.NewBinop(loc, ir.BinOpAdd, ident("s"), ident("r"), integer(1)),
+			ir.NewRet(loc, ident("s")),
+		},
+	}, remaining)
+}
+
+func TestPeephole_Merge(t *testing.T) {
+	t.Parallel()
+
+	word := ir.NewAbiTyBase(ir.BaseWord)
+	ident := func(name ir.Ident) *ir.Val { return ir.NewValIdent(loc, name, word) }
+	integer := func(i int64) *ir.Val { return ir.NewValInteger(loc, i, word) }
+
+	// A chain of blocks that are each only entered from the one before.
+	fd := ir.NewFuncDef(loc, "f").WithRetTy(word).WithBlocks(
+		ir.NewBlock(loc, "start", []ir.Instruction{
+			ir.NewBinop(loc, ir.BinOpAdd, ident("x"), integer(1), integer(2)),
+			ir.NewJmp(loc, "a"),
+		}),
+		ir.NewBlock(loc, "a", []ir.Instruction{
+			ir.NewPhi(loc, ident("y"), ir.NewPhiArg("start", ident("x"))),
+			ir.NewJmp(loc, "b"),
+		}),
+		ir.NewBlock(loc, "b", []ir.Instruction{ir.NewRet(loc, ident("y"))}),
+	)
+
+	unit := ir.NewCompilationUnit()
+	unit.WithFuncDefs(fd)
+	require.NoError(t, Run(unit, Peephole{}))
+	verify(t, unit)
+
+	require.Len(t, unit.FuncDefs[0].Blocks, 1)
+	require.Equal(t, []ir.Instruction{
+		ir.NewBinop(loc, ir.BinOpAdd, ident("x"), integer(1), integer(2)),
+		ir.NewRet(loc, ident("x")),
+	}, unit.FuncDefs[0].Blocks[0].Instructions)
+}
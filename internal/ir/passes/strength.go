@@ -0,0 +1,87 @@
+package passes
+
+import (
+	"math/bits"
+
+	"github.com/corani/cubit/internal/ir"
+)
+
+// StrengthReduce replaces arithmetic by a power of two with cheaper
+// operations: a multiplication becomes a shift left, and an unsigned division
+// and remainder become a shift right and a mask. Signed division rounds
+// towards zero, which a shift doesn't, so it's kept.
+type StrengthReduce struct{}
+
+func (StrengthReduce) Name() string {
+	return "strength"
+}
+
+func (StrengthReduce) Run(fd *ir.FuncDef) bool {
+	changed := false
+
+	for i := range fd.Blocks {
+		for _, instr := range fd.Blocks[i].Instructions {
+			if b, ok := instr.(*ir.Binop); ok && reduce(b) {
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
+
+// reduce replaces the operation of b by a cheaper one, if it has one.
+func reduce(b *ir.Binop) bool {
+	width := 32
+	if b.Ret.AbiTy.BaseTy == ir.BaseLong {
+		width = 64
+	}
+
+	val, k, ok := b.Lhs, 0, false
+
+	switch b.Op {
+	case ir.BinOpMul:
+		if k, ok = log2(b.Rhs, width); !ok {
+			val = b.Rhs
+			k, ok = log2(b.Lhs, width)
+		}
+	case ir.BinOpUDiv, ir.BinOpUMod:
+		k, ok = log2(b.Rhs, width)
+	}
+
+	// Operations by 1 are copies, which CopyProp removes.
+	if !ok || k == 0 {
+		return false
+	}
+
+	loc := b.Rhs.Loc
+
+	switch b.Op {
+	case ir.BinOpMul:
+		b.Op = ir.BinOpShl
+		b.Rhs = ir.NewValInteger(loc, int64(k), ir.NewAbiTyBase(ir.BaseWord))
+	case ir.BinOpUDiv:
+		b.Op = ir.BinOpShr
+		b.Rhs = ir.NewValInteger(loc, int64(k), ir.NewAbiTyBase(ir.BaseWord))
+	default:
+		b.Op = ir.BinOpAnd
+		b.Rhs = ir.NewValInteger(loc, int64(1)<<k-1, b.Rhs.AbiTy)
+	}
+
+	b.Lhs = val
+
+	return true
+}
+
+// log2 returns k if val is the constant 2^k, and k is less than the width of
+// the operation.
+func log2(val *ir.Val, width int) (int, bool) {
+	c, ok := val.IntConst()
+	if !ok || c <= 0 || c&(c-1) != 0 {
+		return 0, false
+	}
+
+	k := bits.TrailingZeros64(uint64(c))
+
+	return k, k < width
+}
@@ -0,0 +1,59 @@
+package passes
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrengthReduce(t *testing.T) {
+	t.Parallel()
+
+	word, long := ir.NewAbiTyBase(ir.BaseWord), ir.NewAbiTyBase(ir.BaseLong)
+
+	tt := []struct {
+		name     string
+		op       ir.BinOpKind
+		ty       ir.AbiTy
+		lhs, rhs int64 // 0 is the temporary %x
+		expected ir.BinOpKind
+		operand  int64
+	}{
+		{"multiply", ir.BinOpMul, word, 0, 8, ir.BinOpShl, 3},
+		{"multiply left", ir.BinOpMul, long, 16, 0, ir.BinOpShl, 4},
+		{"unsigned divide", ir.BinOpUDiv, long, 0, 4, ir.BinOpShr, 2},
+		{"unsigned remainder", ir.BinOpUMod, word, 0, 32, ir.BinOpAnd, 31},
+		{"not a power of two", ir.BinOpMul, word, 0, 6, ir.BinOpMul, 6},
+		{"signed divide", ir.BinOpDiv, word, 0, 4, ir.BinOpDiv, 4},
+		{"wider than a word", ir.BinOpMul, word, 0, 1 << 32, ir.BinOpMul, 1 << 32},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			val := func(i int64) *ir.Val {
+				if i == 0 {
+					return ir.NewValIdent(loc, "x", tc.ty)
+				}
+
+				return ir.NewValInteger(loc, i, tc.ty)
+			}
+
+			b := ir.NewBinop(loc, tc.op, ir.NewValIdent(loc, "r", tc.ty), val(tc.lhs), val(tc.rhs))
+			fd := ir.NewFuncDef(loc, "f", ir.NewParamRegular(loc, tc.ty, "x")).WithRetTy(tc.ty).WithBlocks(
+				ir.NewBlock(loc, "start", []ir.Instruction{b, ir.NewRet(loc, b.Ret)}),
+			)
+
+			require.Equal(t, tc.expected != tc.op, StrengthReduce{}.Run(&fd))
+			verify(t, ir.NewCompilationUnit().WithFuncDefs(fd))
+			require.Equal(t, tc.expected, b.Op)
+			require.Equal(t, ir.Ident("x"), b.Lhs.Ident)
+
+			operand, ok := b.Rhs.IntConst()
+			require.True(t, ok)
+			require.Equal(t, tc.operand, operand)
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package profile
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/corani/cubit/internal/target"
+)
+
+// The symbols Instrument adds to the program.
+const (
+	countsIdent ir.Ident = "cubit.profile.counts"
+	writeIdent  ir.Ident = "cubit.profile.write"
+)
+
+// Instrument makes the functions of unit count how often their blocks run,
+// and main register a function with atexit that writes the counts to path,
+// in the format Read reads. It runs right after lowering, so the counts are
+// keyed by the labels of the lowered blocks, and with the target the unit was
+// lowered for.
+//
+// The counters are longs in a data definition, one per block and one more per
+// conditional jump. A block adds 1 to its counter when it starts, and a
+// conditional jump adds its condition, as 0 or 1, to its own.
+func Instrument(unit *ir.CompilationUnit, path string, t target.Target) error {
+	main := slices.IndexFunc(unit.FuncDefs, func(fd ir.FuncDef) bool {
+		return fd.Ident == "main" && fd.Blocks != nil
+	})
+	if main < 0 {
+		return fmt.Errorf("instrumenting a program needs a main function")
+	}
+
+	long, ptr := ir.NewAbiTyBase(ir.BaseLong), ir.NewAbiTyBase(ir.BaseLong)
+	if t.PtrSize() == 4 {
+		ptr = ir.NewAbiTyBase(ir.BaseWord)
+	}
+
+	// counter is a counter and the block it counts, as written by the
+	// program.
+	type counter struct {
+		fn     ir.Ident
+		label  string
+		branch bool
+	}
+
+	var counters []counter
+
+	n := 0
+
+	// temp returns a new temporary of fd for the counting.
+	temp := func(loc lexer.Location, tag string, ty ir.AbiTy) *ir.Val {
+		n++
+
+		return ir.NewValIdent(loc, ir.Ident(fmt.Sprintf("profile.%s.%d", tag, n)), ty)
+	}
+
+	// increment returns the instructions that add val to the counter at
+	// index i.
+	increment := func(loc lexer.Location, i int, val *ir.Val) []ir.Instruction {
+		addr, old, sum := temp(loc, "addr", ptr), temp(loc, "old", long), temp(loc, "sum", long)
+
+		return []ir.Instruction{
+			ir.NewBinop(loc, ir.BinOpAdd, addr, ir.NewValGlobal(loc, countsIdent, ptr), ir.NewValInteger(loc, int64(8*i), ptr)),
+			ir.NewLoad(loc, old, addr),
+			ir.NewBinop(loc, ir.BinOpAdd, sum, old, val),
+			ir.NewStore(loc, addr, sum),
+		}
+	}
+
+	for i := range unit.FuncDefs {
+		fd := &unit.FuncDefs[i]
+
+		// Functions with another calling convention can't make calls or
+		// don't have a frame, so they aren't counted.
+		if fd.Blocks == nil || fd.CallConv != ir.CallConvC {
+			continue
+		}
+
+		n = 0
+
+		for j := range fd.Blocks {
+			block := &fd.Blocks[j]
+			jnz, branch := block.Terminator().(*ir.Jnz)
+
+			// Phis, and the slots of the start block, come first.
+			top := slices.IndexFunc(block.Instructions, func(instr ir.Instruction) bool {
+				switch instr.(type) {
+				case *ir.Phi, *ir.Alloc:
+					return false
+				default:
+					return true
+				}
+			})
+
+			instrs := slices.Clone(block.Instructions[:top])
+			instrs = append(instrs, increment(block.Loc, len(counters), ir.NewValInteger(block.Loc, 1, long))...)
+			instrs = append(instrs, block.Instructions[top:len(block.Instructions)-1]...)
+
+			if branch {
+				// The condition may be any non-zero value, so it's compared
+				// to 0 first.
+				taken, wide := temp(jnz.Loc, "taken", ir.NewAbiTyBase(ir.BaseWord)), temp(jnz.Loc, "taken", long)
+
+				instrs = append(instrs,
+					ir.NewBinop(jnz.Loc, ir.BinOpNe, taken, jnz.Cond, ir.NewValInteger(jnz.Loc, 0, jnz.Cond.AbiTy)),
+					ir.NewConvert(jnz.Loc, wide, taken))
+				instrs = append(instrs, increment(jnz.Loc, len(counters)+1, wide)...)
+			}
+
+			block.Instructions = append(instrs, block.Terminator())
+			counters = append(counters, counter{fn: fd.Ident, label: block.Label, branch: branch})
+
+			if branch {
+				// The second counter of the block; it's written with the
+				// first.
+				counters = append(counters, counter{})
+			}
+		}
+	}
+
+	loc := unit.FuncDefs[main].Loc
+	file := ir.NewValIdent(loc, "file", ptr)
+
+	unit.WithDataDefs(
+		ir.NewDataDef(loc, countsIdent, ir.NewDataInitZero(loc, 8*len(counters))).WithAlign(8),
+		ir.NewDataDefStringZ(loc, "cubit.profile.path", path),
+		ir.NewDataDefStringZ(loc, "cubit.profile.mode", "w"),
+	)
+
+	// cubit.profile.write opens the file and prints a line per block, with a
+	// format of its own that names the block.
+	write := []ir.Instruction{}
+
+	for i, c := range counters {
+		if c.fn == "" {
+			continue
+		}
+
+		format := ir.Ident(fmt.Sprintf("cubit.profile.format.%d", i))
+		args := []ir.Arg{
+			ir.NewArgRegular(loc, file),
+			ir.NewArgRegular(loc, ir.NewValGlobal(loc, format, ptr)),
+			ir.NewArgVariadic(loc),
+		}
+		text := fmt.Sprintf("%s %s %%lld", c.fn, c.label)
+
+		counts := 1
+		if c.branch {
+			counts = 2
+			text += " %lld"
+		}
+
+		for k := range counts {
+			addr := ir.NewValIdent(loc, ir.Ident(fmt.Sprintf("addr.%d", i+k)), ptr)
+			count := ir.NewValIdent(loc, ir.Ident(fmt.Sprintf("count.%d", i+k)), long)
+			write = append(write,
+				ir.NewBinop(loc, ir.BinOpAdd, addr, ir.NewValGlobal(loc, countsIdent, ptr), ir.NewValInteger(loc, int64(8*(i+k)), ptr)),
+				ir.NewLoad(loc, count, addr))
+			args = append(args, ir.NewArgRegular(loc, count))
+		}
+
+		unit.WithDataDefs(ir.NewDataDefStringZ(loc, format, text+"\n"))
+		write = append(write, ir.NewCall(loc, ir.NewValGlobal(loc, "fprintf", ptr), args...))
+	}
+
+	write = append(write,
+		ir.NewCall(loc, ir.NewValGlobal(loc, "fclose", ptr), ir.NewArgRegular(loc, file)),
+		ir.NewJmp(loc, "done"))
+
+	unit.WithFuncDefs(ir.NewFuncDef(loc, writeIdent).WithBlocks(
+		ir.NewBlock(loc, "start", []ir.Instruction{
+			ir.NewCall(loc, ir.NewValGlobal(loc, "fopen", ptr),
+				ir.NewArgRegular(loc, ir.NewValGlobal(loc, "cubit.profile.path", ptr)),
+				ir.NewArgRegular(loc, ir.NewValGlobal(loc, "cubit.profile.mode", ptr)),
+			).WithRet(file.Ident, ptr),
+			ir.NewJnz(loc, file, "write", "done"),
+		}),
+		ir.NewBlock(loc, "write", write),
+		ir.NewBlock(loc, "done", []ir.Instruction{ir.NewRet(loc)}),
+	))
+
+	// main registers the function first thing, after its slots.
+	fd := &unit.FuncDefs[main]
+	start := &fd.Blocks[0]
+	top := slices.IndexFunc(start.Instructions, func(instr ir.Instruction) bool {
+		_, ok := instr.(*ir.Alloc)
+		return !ok
+	})
+
+	start.Instructions = slices.Concat(start.Instructions[:top], []ir.Instruction{
+		ir.NewCall(loc, ir.NewValGlobal(loc, "atexit", ptr),
+			ir.NewArgRegular(loc, ir.NewValGlobal(loc, writeIdent, ptr))),
+	}, start.Instructions[top:])
+
+	unit.WithExterns(loc, "atexit", "fopen", "fprintf", "fclose")
+
+	return nil
+}
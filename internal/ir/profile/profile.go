@@ -0,0 +1,114 @@
+// Package profile counts how often the blocks of a program run, for
+// profile-guided optimization. Instrument makes a program count the runs of
+// its blocks, and how often their conditional jumps jump to the first target,
+// and write the counts to a file when it exits. Read reads the counts back, so
+// the compiler can inline the calls that run often and lay out the blocks so
+// the common path falls through.
+//
+// The counts are keyed by function and block label, as lowered: the program
+// must be lowered the same way when it's instrumented and when the profile is
+// used, and the counts must be looked up before the optimizations rename the
+// blocks. Blocks the optimizations create have no counts.
+package profile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/corani/cubit/internal/ir"
+)
+
+// Block is how often a block ran.
+type Block struct {
+	Count int64
+	// Branch is set if the block ends with a conditional jump, and Taken is
+	// how often it jumped to its first target.
+	Branch bool
+	Taken  int64
+}
+
+// Weights returns how often a block that ends with a conditional jump went to
+// its first and its second target.
+func (b Block) Weights() (int64, int64) {
+	return b.Taken, b.Count - b.Taken
+}
+
+// Profile is the counts of the blocks of a program.
+type Profile struct {
+	funcs map[ir.Ident]map[string]Block
+	max   int64 // the count of the block that ran most often
+}
+
+// New returns an empty profile.
+func New() *Profile {
+	return &Profile{funcs: make(map[ir.Ident]map[string]Block)}
+}
+
+// WithBlock sets the counts of the block labeled label of function fn.
+func (p *Profile) WithBlock(fn ir.Ident, label string, block Block) *Profile {
+	if p.funcs[fn] == nil {
+		p.funcs[fn] = make(map[string]Block)
+	}
+
+	p.funcs[fn][label] = block
+	p.max = max(p.max, block.Count)
+
+	return p
+}
+
+// Block returns the counts of the block labeled label of function fn, and
+// whether the profile has them.
+func (p *Profile) Block(fn ir.Ident, label string) (Block, bool) {
+	block, ok := p.funcs[fn][label]
+
+	return block, ok
+}
+
+// Hot reports whether a block that ran count times is hot: it ran at least
+// a hundredth as often as the block that ran most often.
+func (p *Profile) Hot(count int64) bool {
+	return count > 0 && count*100 >= p.max
+}
+
+// Read reads a profile written by an instrumented program. Every line has
+// the name of a function, the label of a block and how often it ran, and for
+// a block that ends with a conditional jump, how often it jumped to the first
+// target.
+func Read(r io.Reader) (*Profile, error) {
+	p := New()
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) != 3 && len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: want function, block, count and optionally taken, got %q", n, scanner.Text())
+		}
+
+		var counts []int64
+
+		for _, field := range fields[2:] {
+			count, err := strconv.ParseInt(field, 10, 64)
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("line %d: invalid count %q", n, field)
+			}
+
+			counts = append(counts, count)
+		}
+
+		block := Block{Count: counts[0]}
+		if len(counts) == 2 {
+			block.Branch, block.Taken = true, counts[1]
+		}
+
+		p.WithBlock(ir.Ident(fields[0]), fields[1], block)
+	}
+
+	return p, scanner.Err()
+}
@@ -0,0 +1,150 @@
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/ir/interp"
+	"github.com/corani/cubit/internal/target"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/corani/cubit/internal/typecheck"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRead(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{name: "valid", input: "f start 10 4\n\nf then 4\nmain start 1\n"},
+		{name: "missing count", input: "f start\n", err: `line 1: want function, block, count and optionally taken, got "f start"`},
+		{name: "invalid count", input: "f start 10\nf then -1\n", err: `line 2: invalid count "-1"`},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := Read(strings.NewReader(tc.input))
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			block, ok := p.Block("f", "start")
+			require.True(t, ok)
+			require.Equal(t, Block{Count: 10, Branch: true, Taken: 4}, block)
+
+			first, second := block.Weights()
+			require.Equal(t, []int64{4, 6}, []int64{first, second})
+
+			_, ok = p.Block("f", "else")
+			require.False(t, ok)
+
+			require.True(t, p.Hot(1))
+			require.False(t, p.Hot(0))
+		})
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+odd :: func(n: int) -> bool {
+    return n % 2 == 1
+}
+
+main :: func() -> int {
+    count := 0
+    for i := 0; i < 5; i = i + 1 {
+        if odd(i) {
+            count = count + 1
+        }
+    }
+    return count
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+	require.NoError(t, typecheck.Check(unit))
+
+	lowered, err := ir.Lower(unit)
+	require.NoError(t, err)
+	require.NoError(t, Instrument(lowered, "/tmp/test.prof", target.Host()))
+	require.Empty(t, ir.Verify(lowered))
+
+	// The program writes the profile with the C library, which the
+	// interpreter doesn't have, so the functions are faked.
+	var (
+		out    bytes.Buffer
+		path   string
+		atexit int64
+	)
+
+	in, err := interp.New(lowered)
+	require.NoError(t, err)
+
+	in.WithIntrinsic("atexit", func(_ *interp.Interp, args []int64) (int64, error) {
+		atexit = args[0]
+		return 0, nil
+	}).WithIntrinsic("fopen", func(in *interp.Interp, args []int64) (int64, error) {
+		path, err = in.String(args[0])
+		return 1, err
+	}).WithIntrinsic("fprintf", func(in *interp.Interp, args []int64) (int64, error) {
+		format, err := in.String(args[1])
+		if err != nil {
+			return 0, err
+		}
+
+		var values []any
+		for _, arg := range args[2:] {
+			values = append(values, arg)
+		}
+
+		fmt.Fprintf(&out, strings.ReplaceAll(format, "%lld", "%d"), values...)
+
+		return 0, nil
+	}).WithIntrinsic("fclose", func(*interp.Interp, []int64) (int64, error) {
+		return 0, nil
+	})
+
+	result, err := in.Call("main")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), result)
+	require.NotEqual(t, int64(0), atexit)
+
+	_, err = in.Call(writeIdent)
+	require.NoError(t, err)
+	require.Equal(t, "/tmp/test.prof", path)
+
+	p, err := Read(&out)
+	require.NoError(t, err)
+
+	// odd ran 5 times, and the loop condition 6 times; the last time, it
+	// didn't jump to the body.
+	for _, tc := range []struct {
+		fn    ir.Ident
+		label string
+		block Block
+	}{
+		{"odd", "start", Block{Count: 5}},
+		{"main", "start", Block{Count: 1}},
+		{"main", "L0001_for", Block{Count: 6, Branch: true, Taken: 5}},
+		{"main", "L0002_body", Block{Count: 5, Branch: true, Taken: 2}},
+		{"main", "L0003_end", Block{Count: 1}},
+	} {
+		block, ok := p.Block(tc.fn, tc.label)
+		require.True(t, ok, "%s %s", tc.fn, tc.label)
+		require.Equal(t, tc.block, block, "%s %s", tc.fn, tc.label)
+	}
+}
@@ -0,0 +1,355 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// buildSSA puts a lowered function into SSA form. Lowering keeps every
+// variable in a stack slot, and every value that is assigned on more than one
+// path as well; buildSSA promotes the slots that are only ever loaded from and
+// stored to into versioned temporaries, and joins the versions that reach a
+// block from different predecessors with phi instructions. Slots whose address
+// is used in any other way stay in memory.
+//
+// The construction follows Braun et al., "Simple and Efficient Construction of
+// Static Single Assignment Form": blocks are visited in order, and a block is
+// sealed once all of its predecessors have been visited. A read in a block that
+// isn't sealed yet gets a phi whose arguments are filled in when it's sealed.
+// Phis that turn out to select a single value are removed at the end.
+func buildSSA(fd *FuncDef) {
+	// Unreachable blocks would be predecessors that never pass on a value.
+	fd.RemoveUnreachable()
+
+	cfg := NewCFG(fd)
+	b := &ssaBuilder{
+		slots:    make(map[Ident]AbiTy),
+		values:   make(map[Ident]*Val),
+		phiSlots: make(map[*Phi]Ident),
+		versions: make(map[Ident]int),
+	}
+
+	byBlock := make(map[*Block]*ssaBlock)
+
+	for _, block := range cfg.Blocks {
+		bb := &ssaBlock{Block: block, defs: make(map[Ident]*Val)}
+		byBlock[block] = bb
+		b.blocks = append(b.blocks, bb)
+	}
+
+	for _, bb := range b.blocks {
+		for _, pred := range cfg.Predecessors(bb.Block) {
+			bb.preds = append(bb.preds, byBlock[pred])
+		}
+	}
+
+	b.promotable()
+
+	if len(b.slots) == 0 {
+		return
+	}
+
+	b.rename()
+	b.removeTrivialPhis()
+	b.rewrite()
+
+	for _, bb := range b.blocks {
+		phis := make([]Instruction, 0, len(bb.phis)+len(bb.Instructions))
+		for _, phi := range bb.phis {
+			phis = append(phis, phi)
+		}
+
+		bb.Instructions = append(phis, bb.Instructions...)
+	}
+}
+
+// ssaBlock is a block of the function being put into SSA form, with the state
+// of the construction.
+type ssaBlock struct {
+	*Block
+	preds      []*ssaBlock
+	phis       []*Phi
+	defs       map[Ident]*Val // the current version of each promoted slot
+	incomplete []*Phi         // phis waiting for the block to be sealed
+	filled     bool           // all instructions have been visited
+	sealed     bool           // all predecessors have been visited
+}
+
+type ssaBuilder struct {
+	blocks   []*ssaBlock
+	slots    map[Ident]AbiTy // promoted slots and the type of their value
+	values   map[Ident]*Val  // removed temporaries and the value that replaces them
+	phiSlots map[*Phi]Ident
+	versions map[Ident]int
+}
+
+// promotable finds the slots that can be promoted: stack slots whose address
+// is only used to load and store values of a single type.
+func (b *ssaBuilder) promotable() {
+	candidates := make(map[Ident]bool)
+	escaped := make(map[Ident]bool)
+
+	for _, bb := range b.blocks {
+		for _, instr := range bb.Instructions {
+			if alloc, ok := instr.(*Alloc); ok && alloc.Ret.Type == ValIdent {
+				candidates[alloc.Ret.Ident] = true
+			}
+		}
+	}
+
+	isSlot := func(val *Val) bool {
+		return val != nil && val.Type == ValIdent && candidates[val.Ident]
+	}
+
+	// A slot is only promoted if it's always accessed with the same type in
+	// memory, so the values don't need to be truncated or extended.
+	memTys := make(map[Ident]AbiTy)
+
+	accessed := func(slot Ident, memTy AbiTy) {
+		if t, ok := memTys[slot]; ok && t != memTy {
+			escaped[slot] = true
+		}
+
+		memTys[slot] = memTy
+	}
+
+	typed := func(slot Ident, abiTy AbiTy) {
+		if t, ok := b.slots[slot]; ok && t != abiTy {
+			escaped[slot] = true
+		}
+
+		b.slots[slot] = abiTy
+	}
+
+	for _, bb := range b.blocks {
+		for _, instr := range bb.Instructions {
+			ops := Operands(instr)
+
+			switch instr := instr.(type) {
+			case *Load:
+				if isSlot(instr.Addr) {
+					typed(instr.Addr.Ident, instr.Ret.AbiTy)
+					accessed(instr.Addr.Ident, instr.Ty)
+
+					continue
+				}
+			case *Store:
+				if isSlot(instr.Addr) {
+					accessed(instr.Addr.Ident, instr.Ty)
+
+					if instr.Val.Type == ValIdent {
+						typed(instr.Addr.Ident, instr.Val.AbiTy)
+					}
+
+					ops = []**Val{&instr.Val}
+				}
+			}
+
+			for _, op := range ops {
+				if isSlot(*op) {
+					escaped[(*op).Ident] = true
+				}
+			}
+		}
+	}
+
+	for slot := range candidates {
+		if _, ok := b.slots[slot]; !ok && !escaped[slot] {
+			// Only ever stored to: the stores are dead.
+			b.slots[slot] = NewAbiTyBase(BaseWord)
+		}
+	}
+
+	for slot := range b.slots {
+		if !candidates[slot] || escaped[slot] {
+			delete(b.slots, slot)
+		}
+	}
+}
+
+// rename replaces the loads and stores of promoted slots by the versions of
+// their values, visiting the blocks in order.
+func (b *ssaBuilder) rename() {
+	b.sealReady()
+
+	for _, bb := range b.blocks {
+		var body []Instruction
+
+		for _, instr := range bb.Instructions {
+			switch instr := instr.(type) {
+			case *Alloc:
+				if b.promoted(instr.Ret) {
+					continue
+				}
+			case *Store:
+				if b.promoted(instr.Addr) {
+					bb.defs[instr.Addr.Ident] = instr.Val
+
+					continue
+				}
+			case *Load:
+				if b.promoted(instr.Addr) {
+					val := b.read(instr.Addr.Ident, bb)
+					b.values[instr.Ret.Ident] = val.WithAbiTy(instr.Ret.AbiTy)
+
+					continue
+				}
+			}
+
+			body = append(body, instr)
+		}
+
+		bb.Instructions = body
+		bb.filled = true
+
+		b.sealReady()
+	}
+}
+
+func (b *ssaBuilder) promoted(val *Val) bool {
+	if val == nil || val.Type != ValIdent {
+		return false
+	}
+
+	_, ok := b.slots[val.Ident]
+
+	return ok
+}
+
+// sealReady seals the blocks whose predecessors have all been visited.
+func (b *ssaBuilder) sealReady() {
+	for _, bb := range b.blocks {
+		if bb.sealed {
+			continue
+		}
+
+		ready := true
+		for _, pred := range bb.preds {
+			ready = ready && pred.filled
+		}
+
+		if ready {
+			for _, phi := range bb.incomplete {
+				b.addPhiArgs(phi, bb)
+			}
+
+			bb.incomplete = nil
+			bb.sealed = true
+		}
+	}
+}
+
+// read returns the version of slot that reaches the current point of bb.
+func (b *ssaBuilder) read(slot Ident, bb *ssaBlock) *Val {
+	if val, ok := bb.defs[slot]; ok {
+		return val
+	}
+
+	var val *Val
+
+	switch {
+	case !bb.sealed:
+		phi := b.newPhi(slot, bb)
+		bb.incomplete = append(bb.incomplete, phi)
+		val = phi.Ret
+	case len(bb.preds) == 0:
+		// Read before anything was stored: the slot holds whatever the stack
+		// held, so any value will do.
+		val = NewValInteger(bb.Loc, 0, b.slots[slot])
+	case len(bb.preds) == 1:
+		val = b.read(slot, bb.preds[0])
+	default:
+		// Define the phi before reading the predecessors, to break cycles.
+		phi := b.newPhi(slot, bb)
+		bb.defs[slot] = phi.Ret
+		b.addPhiArgs(phi, bb)
+		val = phi.Ret
+	}
+
+	bb.defs[slot] = val
+
+	return val
+}
+
+func (b *ssaBuilder) newPhi(slot Ident, bb *ssaBlock) *Phi {
+	b.versions[slot]++
+
+	// Versions are named after the variable; the dot keeps them apart from
+	// any name in the source.
+	name := Ident(fmt.Sprintf("%s.%d", strings.TrimSuffix(string(slot), "_slot"), b.versions[slot]))
+	phi := NewPhi(bb.Loc, NewValIdent(bb.Loc, name, b.slots[slot]))
+
+	b.phiSlots[phi] = slot
+	bb.phis = append(bb.phis, phi)
+
+	return phi
+}
+
+func (b *ssaBuilder) addPhiArgs(phi *Phi, bb *ssaBlock) {
+	slot := b.phiSlots[phi]
+
+	for _, pred := range bb.preds {
+		phi.Args = append(phi.Args, NewPhiArg(pred.Label, b.read(slot, pred)))
+	}
+}
+
+// removeTrivialPhis removes the phis that select a single value (other than
+// the phi itself), until there are none left. Removing one can make the phis
+// that use it trivial as well.
+func (b *ssaBuilder) removeTrivialPhis() {
+	for changed := true; changed; {
+		changed = false
+
+		for _, bb := range b.blocks {
+			var phis []*Phi
+
+			for _, phi := range bb.phis {
+				for i := range phi.Args {
+					phi.Args[i].Val = b.resolve(phi.Args[i].Val)
+				}
+
+				if val := phi.Trivial(); val != nil {
+					b.values[phi.Ret.Ident] = val
+					changed = true
+
+					continue
+				}
+
+				phis = append(phis, phi)
+			}
+
+			bb.phis = phis
+		}
+	}
+}
+
+// resolve follows the replacements of removed temporaries.
+func (b *ssaBuilder) resolve(val *Val) *Val {
+	for val != nil && val.Type == ValIdent {
+		next, ok := b.values[val.Ident]
+		if !ok {
+			break
+		}
+
+		val = next
+	}
+
+	return val
+}
+
+// rewrite replaces the uses of removed temporaries.
+func (b *ssaBuilder) rewrite() {
+	for _, bb := range b.blocks {
+		for _, phi := range bb.phis {
+			for _, op := range Operands(phi) {
+				*op = b.resolve(*op)
+			}
+		}
+
+		for _, instr := range bb.Instructions {
+			for _, op := range Operands(instr) {
+				*op = b.resolve(*op)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/corani/cubit/internal/typecheck"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildSSA(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+f :: func(a: bool, n: int) -> int {
+    r := 0
+    if a {
+        r = n
+    }
+    for i := 0; i < n; i = i + 1 {
+        x := [4]int{}
+        x[0] = r
+        r = r + i
+    }
+    return r
+}
+
+g :: func(a: bool, b: bool) -> bool {
+    return a && b
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+	require.NoError(t, typecheck.Check(unit))
+
+	lowered, err := Lower(unit)
+	require.NoError(t, err)
+
+	val := func(v *Val) string {
+		if v.Type == ValIdent {
+			return string(v.Ident)
+		}
+
+		return fmt.Sprint(v.DynConst.Const.I64)
+	}
+
+	phis := make(map[Ident][]string)
+	allocs := make(map[Ident][]Ident)
+
+	for _, fd := range lowered.FuncDefs {
+		require.NoError(t, fd.Verify())
+
+		for _, block := range fd.Blocks {
+			for _, instr := range block.Instructions {
+				switch instr := instr.(type) {
+				case *Alloc:
+					allocs[fd.Ident] = append(allocs[fd.Ident], instr.Ret.Ident)
+				case *Load:
+					require.NotContains(t, string(instr.Addr.Ident), "slot", "load from a promoted slot")
+				case *Phi:
+					var args []string
+					for _, arg := range instr.Args {
+						args = append(args, arg.Label+" "+val(arg.Val))
+					}
+
+					phis[fd.Ident] = append(phis[fd.Ident],
+						fmt.Sprintf("@%s %s = phi %s", block.Label, instr.Ret.Ident, strings.Join(args, ", ")))
+				}
+			}
+		}
+	}
+
+	require.Equal(t, map[Ident][]string{
+		"f": {
+			// r after the if
+			"@L0003_end r.3 = phi L0001_then n, L0002_else 0",
+			// the loop variables
+			"@L0004_for i.1 = phi L0003_end 0, L0008_zi_end _tmp_0019",
+			"@L0004_for r.2 = phi L0003_end r.3, L0008_zi_end _tmp_0016",
+			// the index of the loop that zeroes x
+			"@L0007_zi_loop _slot_0007.1 = phi L0005_body 0, L0009_zi_tmp _zi_idx_0011",
+		},
+		"g": {
+			// built by lowering: false if a is, or b compared to 0
+			"@L0002_end _tmp_0002 = phi start 0, L0001_rhs _tmp_0004",
+		},
+	}, phis)
+
+	// Only the array stays in memory; its address is used to index it.
+	require.Equal(t, map[Ident][]Ident{"f": {"_arr_0006"}}, allocs)
+}
+
+func TestBuildSSA_Shadowing(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+f :: func(a: bool) -> int {
+    x := 1
+    if a {
+        x := 5
+        x = x + 1
+    }
+    return x
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+	require.NoError(t, typecheck.Check(unit))
+
+	lowered, err := Lower(unit)
+	require.NoError(t, err)
+
+	// The inner x is a variable of its own: the outer one is still 1 after
+	// the if, so there's nothing to merge.
+	var ret *Ret
+
+	for _, fd := range lowered.FuncDefs {
+		require.NoError(t, fd.Verify())
+
+		for _, block := range fd.Blocks {
+			for _, instr := range block.Instructions {
+				switch instr := instr.(type) {
+				case *Phi:
+					require.Failf(t, "unexpected phi", "@%s %s", block.Label, instr.Ret.Ident)
+				case *Ret:
+					ret = instr
+				}
+			}
+		}
+	}
+
+	require.NotNil(t, ret)
+	require.Equal(t, ValDynConst, ret.Val.Type)
+	require.Equal(t, int64(1), ret.Val.DynConst.Const.I64)
+}
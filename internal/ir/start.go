@@ -0,0 +1,49 @@
+package ir
+
+import (
+	"fmt"
+	"slices"
+)
+
+// StartIdent is the entry point of a freestanding program, where the loader
+// or the boot code jumps to.
+const StartIdent Ident = "_start"
+
+// WithStart adds the entry point of a freestanding program, which calls main
+// and then spins, as there's no operating system to return to. The C library
+// usually provides it, along with the code that sets up the program. A unit
+// that defines _start already, e.g. as a naked function that sets up the
+// stack, keeps its own.
+func (cu *CompilationUnit) WithStart() error {
+	if slices.ContainsFunc(cu.FuncDefs, func(fd FuncDef) bool {
+		return fd.Ident == StartIdent && fd.Blocks != nil
+	}) {
+		return nil
+	}
+
+	i := slices.IndexFunc(cu.FuncDefs, func(fd FuncDef) bool {
+		return fd.Ident == "main" && fd.Blocks != nil
+	})
+	if i < 0 {
+		return fmt.Errorf("a freestanding program needs a main function, or a %s of its own", StartIdent)
+	}
+
+	main := cu.FuncDefs[i]
+	if len(main.Params) > 0 {
+		return main.Loc.Errorf("a freestanding main can't take arguments, as nothing passes them")
+	}
+
+	loc := main.Loc
+
+	cu.WithFuncDefs(NewFuncDef(loc, StartIdent).
+		WithLinkage(NewLinkageExport(loc)).
+		WithBlocks(
+			NewBlock(loc, "start", []Instruction{
+				NewCall(loc, NewValGlobal(loc, "main", NewAbiTyBase(BaseLong))),
+				NewJmp(loc, "hang"),
+			}),
+			NewBlock(loc, "hang", []Instruction{NewJmp(loc, "hang")}),
+		))
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompilationUnit_WithStart(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 1}
+	word := NewAbiTyBase(BaseWord)
+	body := func(ident Ident, params ...*Param) FuncDef {
+		return NewFuncDef(loc, ident, params...).WithBlocks(
+			NewBlock(loc, "start", []Instruction{NewRet(loc, NewValInteger(loc, 0, word))}))
+	}
+
+	t.Run("adds _start", func(t *testing.T) {
+		t.Parallel()
+
+		unit := NewCompilationUnit().WithFuncDefs(body("main"))
+		require.NoError(t, unit.WithStart())
+		require.Empty(t, Verify(unit))
+		require.Len(t, unit.FuncDefs, 2)
+
+		start := unit.FuncDefs[1]
+		require.Equal(t, StartIdent, start.Ident)
+		require.Equal(t, LinkageExport, start.Linkage.Type)
+		require.Len(t, start.Blocks, 2)
+
+		call, ok := start.Blocks[0].Instructions[0].(*Call)
+		require.True(t, ok)
+		require.Equal(t, Ident("main"), call.Val.Ident)
+
+		// After main returns, it spins.
+		require.Equal(t, []string{"hang"}, start.Blocks[1].Successors())
+	})
+
+	t.Run("keeps its own", func(t *testing.T) {
+		t.Parallel()
+
+		unit := NewCompilationUnit().WithFuncDefs(body(StartIdent))
+		require.NoError(t, unit.WithStart())
+		require.Len(t, unit.FuncDefs, 1)
+	})
+
+	t.Run("no main", func(t *testing.T) {
+		t.Parallel()
+
+		unit := NewCompilationUnit().WithFuncDefs(NewFuncDef(loc, "main"))
+		require.EqualError(t, unit.WithStart(), "a freestanding program needs a main function, or a _start of its own")
+	})
+
+	t.Run("main with arguments", func(t *testing.T) {
+		t.Parallel()
+
+		unit := NewCompilationUnit().WithFuncDefs(body("main", NewParamRegular(loc, word, "argc")))
+		require.EqualError(t, unit.WithStart(), "test.in:3:1: a freestanding main can't take arguments, as nothing passes them")
+	})
+}
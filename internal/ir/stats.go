@@ -0,0 +1,101 @@
+package ir
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// FuncStats is the size of a function.
+type FuncStats struct {
+	Ident        Ident
+	Blocks       int
+	Instructions int
+}
+
+// Stats is the size of a compilation unit: the functions it defines, and the
+// bytes of data it holds. Comparing the stats before and after the passes
+// shows what they did.
+type Stats struct {
+	Funcs []FuncStats
+	Data  int
+}
+
+// Stats returns the size of the unit. Functions that are only declared have
+// no size and are left out.
+func (cu *CompilationUnit) Stats() Stats {
+	var stats Stats
+
+	for _, fd := range cu.FuncDefs {
+		if fd.Blocks == nil {
+			continue
+		}
+
+		fs := FuncStats{Ident: fd.Ident, Blocks: len(fd.Blocks)}
+		for _, block := range fd.Blocks {
+			fs.Instructions += len(block.Instructions)
+		}
+
+		stats.Funcs = append(stats.Funcs, fs)
+	}
+
+	for _, dd := range cu.DataDefs {
+		stats.Data += dd.Size()
+	}
+
+	return stats
+}
+
+// Total returns the size of all functions together.
+func (s Stats) Total() FuncStats {
+	total := FuncStats{Ident: "total"}
+
+	for _, fs := range s.Funcs {
+		total.Blocks += fs.Blocks
+		total.Instructions += fs.Instructions
+	}
+
+	return total
+}
+
+// WriteStats writes a table that compares the size of every function before
+// and after a change, followed by the size of the data. A function that's gone
+// after the change has a size of 0.
+func WriteStats(w io.Writer, before, after Stats) error {
+	sizes := make(map[Ident]FuncStats)
+	for _, fs := range after.Funcs {
+		sizes[fs.Ident] = fs
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "function\tblocks\tinstructions")
+
+	row := func(b, a FuncStats) {
+		fmt.Fprintf(tw, "%s\t%d -> %d\t%d -> %d%s\n",
+			b.Ident, b.Blocks, a.Blocks, b.Instructions, a.Instructions, change(b.Instructions, a.Instructions))
+	}
+
+	for _, fs := range before.Funcs {
+		row(fs, sizes[fs.Ident])
+	}
+
+	row(before.Total(), after.Total())
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "data: %d -> %d bytes\n", before.Data, after.Data)
+
+	return err
+}
+
+// change formats the relative change from before to after.
+func change(before, after int) string {
+	if before == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(" (%+.0f%%)", float64(after-before)*100/float64(before))
+}
@@ -0,0 +1,51 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 5}
+	word := NewAbiTyBase(BaseWord)
+	x := NewValIdent(loc, "x", word)
+
+	unit := NewCompilationUnit()
+	unit.WithDataDefs(NewDataDefStringZ(loc, "str", `a\n`))
+	unit.WithFuncDefs(
+		NewFuncDef(loc, "printf"),
+		NewFuncDef(loc, "f").WithRetTy(word).WithBlocks(
+			NewBlock(loc, "start", []Instruction{
+				NewBinop(loc, BinOpAdd, x, NewValInteger(loc, 1, word), NewValInteger(loc, 2, word)),
+				NewJmp(loc, "end"),
+			}),
+			NewBlock(loc, "end", []Instruction{NewRet(loc, x)}),
+		),
+		NewFuncDef(loc, "g").WithBlocks(NewBlock(loc, "start", []Instruction{NewRet(loc, nil)})),
+	)
+
+	before := unit.Stats()
+
+	// The escape sequence is a single byte, followed by the terminating zero.
+	require.Equal(t, Stats{
+		Funcs: []FuncStats{{Ident: "f", Blocks: 2, Instructions: 3}, {Ident: "g", Blocks: 1, Instructions: 1}},
+		Data:  3,
+	}, before)
+
+	unit.FuncDefs[1].Blocks = []Block{NewBlock(loc, "start", []Instruction{NewRet(loc, NewValInteger(loc, 3, word))})}
+	unit.FuncDefs = unit.FuncDefs[:2]
+
+	var sb strings.Builder
+	require.NoError(t, WriteStats(&sb, before, unit.Stats()))
+	require.Equal(t, ""+
+		"function  blocks  instructions\n"+
+		"f         2 -> 1  3 -> 1 (-67%)\n"+
+		"g         1 -> 0  1 -> 0 (-100%)\n"+
+		"total     3 -> 1  4 -> 1 (-75%)\n"+
+		"data: 3 -> 3 bytes\n", sb.String())
+}
@@ -0,0 +1,217 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Verify checks the invariants of a lowered unit and returns every violation it
+// finds. Like ast.Check, it reports bugs in the compiler rather than in the
+// program, before they surface as failures in the backend:
+//
+//   - the blocks of every function satisfy FuncDef.Verify;
+//   - every temporary is defined once, and before it's used: earlier in the
+//     same block or in a block that dominates it. A phi uses its value at the
+//     end of the block it comes from;
+//   - the operands of a binary operation have the same type, except for the
+//     amount of a shift, which is a word whatever the type of the value it
+//     shifts, and floating point operands are only used in arithmetic and
+//     comparisons;
+//   - stack slots are aligned to 4, 8 or 16 bytes;
+//   - calls go to functions the unit defines or declares.
+func Verify(unit *CompilationUnit) []error {
+	v := &verifier{funcs: make(map[Ident]bool)}
+
+	for _, fd := range unit.FuncDefs {
+		v.funcs[fd.Ident] = true
+
+		if fd.LinkName != "" {
+			v.funcs[fd.LinkName] = true
+		}
+	}
+
+	for i := range unit.FuncDefs {
+		fd := &unit.FuncDefs[i]
+
+		if fd.Blocks == nil {
+			continue
+		}
+
+		if err := fd.Verify(); err != nil {
+			v.errs = append(v.errs, err)
+
+			continue
+		}
+
+		v.verifyFunc(fd)
+	}
+
+	return v.errs
+}
+
+type verifier struct {
+	errs  []error
+	funcs map[Ident]bool // functions that can be called
+}
+
+func (v *verifier) fail(loc lexer.Location, format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", loc, fmt.Sprintf(format, args...)))
+}
+
+// def is where a temporary is defined: the block and the index of the
+// instruction in it. Parameters are defined before the first instruction.
+type def struct {
+	block *Block
+	index int
+}
+
+func (v *verifier) verifyFunc(fd *FuncDef) {
+	cfg := NewCFG(fd)
+	idom := cfg.Dominators()
+	defs := make(map[Ident]def)
+
+	for _, param := range fd.Params {
+		if param.Type == ParamRegular || param.Type == ParamEnv {
+			defs[param.Ident] = def{block: cfg.Entry(), index: -1}
+		}
+	}
+
+	for _, b := range cfg.Blocks {
+		for i, instr := range b.Instructions {
+			ident, ok := defines(instr)
+			if !ok {
+				continue
+			}
+
+			if _, ok := defs[ident]; ok {
+				v.fail(instr.Location(), "temporary %%%s is defined more than once in function %s", ident, fd.Ident)
+
+				continue
+			}
+
+			defs[ident] = def{block: b, index: i}
+		}
+	}
+
+	// available reports whether the value of a temporary defined at d can be
+	// used by instruction i of block b.
+	available := func(d def, b *Block, i int) bool {
+		if d.block == b {
+			return d.index < i
+		}
+
+		return Dominates(idom, d.block, b)
+	}
+
+	for _, b := range cfg.Blocks {
+		if _, ok := idom[b]; !ok {
+			// Unreachable blocks aren't emitted by lowering and are removed
+			// by the passes; there's no dominance to check.
+			continue
+		}
+
+		for i, instr := range b.Instructions {
+			if phi, ok := instr.(*Phi); ok {
+				for _, arg := range phi.Args {
+					pred := cfg.Block(arg.Label)
+					if pred == nil {
+						v.fail(phi.Loc, "phi %%%s in block @%s has a value for unknown block @%s", phi.Ret.Ident, b.Label, arg.Label)
+
+						continue
+					}
+
+					v.checkUse(fd, defs, arg.Val, func(d def) bool {
+						return available(d, pred, len(pred.Instructions))
+					})
+				}
+
+				continue
+			}
+
+			for _, op := range Operands(instr) {
+				v.checkUse(fd, defs, *op, func(d def) bool {
+					return available(d, b, i)
+				})
+			}
+
+			switch instr := instr.(type) {
+			case *Binop:
+				if instr.Lhs.AbiTy != instr.Rhs.AbiTy && !shiftOps[instr.Op] {
+					v.fail(instr.Loc, "operands of %s have different types %s and %s",
+						instr.Op, abiTyString(instr.Lhs.AbiTy), abiTyString(instr.Rhs.AbiTy))
+				}
+
+				if instr.Lhs.AbiTy.IsFloat() && !floatOps[instr.Op] {
+					v.fail(instr.Loc, "%s of floating point operands", instr.Op)
+				}
+			case *Alloc:
+				if instr.Align != 4 && instr.Align != 8 && instr.Align != 16 {
+					v.fail(instr.Loc, "stack slot %%%s is aligned to %d bytes, not 4, 8 or 16", instr.Ret.Ident, instr.Align)
+				}
+			case *Call:
+				if instr.Val.Type == ValDynConst && instr.Val.Ident != "" && !v.funcs[instr.Val.Ident] {
+					v.fail(instr.Loc, "call to unknown function $%s", instr.Val.Ident)
+				}
+			}
+		}
+	}
+}
+
+// checkUse reports a use of a temporary that isn't defined, or whose
+// definition isn't available where it's used.
+func (v *verifier) checkUse(fd *FuncDef, defs map[Ident]def, val *Val, available func(def) bool) {
+	if val == nil || val.Type != ValIdent {
+		return
+	}
+
+	d, ok := defs[val.Ident]
+	if !ok {
+		v.fail(val.Loc, "temporary %%%s is used but not defined in function %s", val.Ident, fd.Ident)
+	} else if !available(d) {
+		v.fail(val.Loc, "temporary %%%s is used before it's defined in function %s", val.Ident, fd.Ident)
+	}
+}
+
+// floatOps are the binary operations on floating point operands.
+var floatOps = map[BinOpKind]bool{
+	BinOpAdd: true, BinOpSub: true, BinOpMul: true, BinOpDiv: true,
+	BinOpEq: true, BinOpNe: true, BinOpLt: true, BinOpLe: true, BinOpGt: true, BinOpGe: true,
+}
+
+// shiftOps are the shifts, whose amount needn't have the type of the value
+// they shift.
+var shiftOps = map[BinOpKind]bool{BinOpShl: true, BinOpShr: true}
+
+// defines returns the temporary an instruction assigns, if any.
+func defines(instr Instruction) (Ident, bool) {
+	switch instr := instr.(type) {
+	case *Binop:
+		return instr.Ret.Ident, true
+	case *Load:
+		return instr.Ret.Ident, true
+	case *Convert:
+		return instr.Ret.Ident, true
+	case *Alloc:
+		return instr.Ret.Ident, true
+	case *Phi:
+		return instr.Ret.Ident, true
+	case *Call:
+		if instr.LHS != nil {
+			return *instr.LHS, true
+		}
+	}
+
+	return "", false
+}
+
+func abiTyString(a AbiTy) string {
+	switch a.Type {
+	case AbiTyBase:
+		return string(a.BaseTy)
+	case AbiTySubW:
+		return string(a.SubWTy)
+	default:
+		return ":" + string(a.Ident)
+	}
+}
@@ -0,0 +1,172 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 5}
+	word, long := NewAbiTyBase(BaseWord), NewAbiTyBase(BaseLong)
+	ident := func(name Ident) *Val { return NewValIdent(loc, name, word) }
+	one := NewValInteger(loc, 1, word)
+
+	tt := []struct {
+		name     string
+		blocks   []Block
+		expected []string
+	}{
+		{
+			name: "valid",
+			blocks: []Block{
+				NewBlock(loc, "start", []Instruction{NewJnz(loc, ident("a"), "then", "end")}),
+				NewBlock(loc, "then", []Instruction{
+					NewBinop(loc, BinOpAdd, ident("x"), ident("a"), one),
+					NewJmp(loc, "end"),
+				}),
+				NewBlock(loc, "end", []Instruction{
+					NewPhi(loc, ident("r"), NewPhiArg("start", ident("a")), NewPhiArg("then", ident("x"))),
+					NewCall(loc, NewValGlobal(loc, "g", word)).WithRet("y", word),
+					NewRet(loc, ident("r")),
+				}),
+			},
+		},
+		{
+			name:     "block invariants",
+			blocks:   []Block{NewBlock(loc, "start", nil)},
+			expected: []string{"test.in:3:5: block @start doesn't end with a jump or return"},
+		},
+		{
+			name: "undefined",
+			blocks: []Block{
+				NewBlock(loc, "start", []Instruction{NewRet(loc, ident("x"))}),
+			},
+			expected: []string{"test.in:3:5: temporary %x is used but not defined in function f"},
+		},
+		{
+			name: "defined twice",
+			blocks: []Block{
+				NewBlock(loc, "start", []Instruction{
+					NewBinop(loc, BinOpAdd, ident("x"), ident("a"), one),
+					NewBinop(loc, BinOpAdd, ident("x"), ident("a"), one),
+					NewRet(loc, ident("x")),
+				}),
+			},
+			expected: []string{"test.in:3:5: temporary %x is defined more than once in function f"},
+		},
+		{
+			name: "used before defined",
+			blocks: []Block{
+				NewBlock(loc, "start", []Instruction{
+					NewBinop(loc, BinOpAdd, ident("y"), ident("x"), one),
+					NewBinop(loc, BinOpAdd, ident("x"), ident("a"), one),
+					NewRet(loc, ident("y")),
+				}),
+			},
+			expected: []string{"test.in:3:5: temporary %x is used before it's defined in function f"},
+		},
+		{
+			name: "not dominated",
+			blocks: []Block{
+				NewBlock(loc, "start", []Instruction{NewJnz(loc, ident("a"), "then", "end")}),
+				NewBlock(loc, "then", []Instruction{
+					NewBinop(loc, BinOpAdd, ident("x"), ident("a"), one),
+					NewJmp(loc, "end"),
+				}),
+				NewBlock(loc, "end", []Instruction{NewRet(loc, ident("x"))}),
+			},
+			expected: []string{"test.in:3:5: temporary %x is used before it's defined in function f"},
+		},
+		{
+			name: "phi value not available",
+			blocks: []Block{
+				NewBlock(loc, "start", []Instruction{NewJnz(loc, ident("a"), "then", "end")}),
+				NewBlock(loc, "then", []Instruction{
+					NewBinop(loc, BinOpAdd, ident("x"), ident("a"), one),
+					NewJmp(loc, "end"),
+				}),
+				NewBlock(loc, "end", []Instruction{
+					NewPhi(loc, ident("r"), NewPhiArg("start", ident("x")), NewPhiArg("then", ident("x"))),
+					NewRet(loc, ident("r")),
+				}),
+			},
+			expected: []string{"test.in:3:5: temporary %x is used before it's defined in function f"},
+		},
+		{
+			name: "mismatched operands",
+			blocks: []Block{
+				NewBlock(loc, "start", []Instruction{
+					NewBinop(loc, BinOpAdd, ident("x"), ident("a"), NewValInteger(loc, 1, long)),
+					NewRet(loc, ident("x")),
+				}),
+			},
+			expected: []string{"test.in:3:5: operands of add have different types w and l"},
+		},
+		{
+			name: "shift of a long",
+			blocks: []Block{
+				NewBlock(loc, "start", []Instruction{
+					NewBinop(loc, BinOpShl, NewValIdent(loc, "x", long), NewValInteger(loc, 1, long), one),
+					NewRet(loc, one),
+				}),
+			},
+		},
+		{
+			name: "float remainder",
+			blocks: []Block{
+				NewBlock(loc, "start", []Instruction{
+					NewBinop(loc, BinOpMod, NewValIdent(loc, "x", NewAbiTyBase(BaseDouble)),
+						NewValDouble(loc, 1.5), NewValDouble(loc, 2)),
+					NewRet(loc, one),
+				}),
+			},
+			expected: []string{"test.in:3:5: mod of floating point operands"},
+		},
+		{
+			name: "alloc alignment",
+			blocks: []Block{
+				NewBlock(loc, "start", []Instruction{
+					NewAlloc(loc, NewValIdent(loc, "p", NewAbiTyBase(BaseLong)),
+						NewValInteger(loc, 3, NewAbiTyBase(BaseLong))).WithAlign(1),
+					NewRet(loc, one),
+				}),
+			},
+			expected: []string{"test.in:3:5: stack slot %p is aligned to 1 bytes, not 4, 8 or 16"},
+		},
+		{
+			name: "unknown function",
+			blocks: []Block{
+				NewBlock(loc, "start", []Instruction{
+					NewCall(loc, NewValGlobal(loc, "h", word)),
+					NewRet(loc),
+				}),
+			},
+			expected: []string{"test.in:3:5: call to unknown function $h"},
+		},
+	}
+
+	extern := NewFuncDef(loc, "extern_g").WithRetTy(word)
+	extern.LinkName = "g"
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			unit := NewCompilationUnit().WithFuncDefs(
+				NewFuncDef(loc, "f", NewParamRegular(loc, word, "a")).WithRetTy(word).WithBlocks(tc.blocks...),
+				extern,
+			)
+
+			var errs []string
+			for _, err := range Verify(unit) {
+				errs = append(errs, err.Error())
+			}
+
+			require.Equal(t, tc.expected, errs)
+		})
+	}
+}
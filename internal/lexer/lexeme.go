@@ -1,6 +1,8 @@
 package lexer
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
 )
 
@@ -26,6 +28,7 @@ const (
 	TypeColon     TokenType = "Colon"        // ":"
 	TypeSemicolon TokenType = "Semicolon"    // ";"
 	TypeAt        TokenType = "At"           // "@"
+	TypeHash      TokenType = "Hash"         // "#"
 	TypeAssign    TokenType = "Assign"       // ":="
 	TypePlus      TokenType = "Plus"         // "+"
 	TypeMinus     TokenType = "Minus"        // "-"
@@ -58,6 +61,7 @@ var symbols = map[string]TokenType{
 	":":  TypeColon,
 	";":  TypeSemicolon,
 	"@":  TypeAt,
+	"#":  TypeHash,
 	"+":  TypePlus,
 	"*":  TypeStar,
 	"%":  TypePercent,
@@ -85,6 +89,26 @@ type Token struct {
 	StringVal  string
 	NumberVal  int
 	Location   Location
+	Comments   []Comment // leading comments
+}
+
+// String returns the type of t, and its value if it has one, e.g. `Number 42`
+// or `String "hi\n"`, with the escapes of a string as they're written.
+func (t Token) String() string {
+	switch t.Type {
+	case TypeIdent, TypeKeyword, TypeBool, TypeNumber:
+		return fmt.Sprintf("%s %s", t.Type, t.StringVal)
+	case TypeString:
+		return fmt.Sprintf("%s \"%s\"", t.Type, t.StringVal)
+	default:
+		return string(t.Type)
+	}
+}
+
+// Comment is a `//` line comment. Text excludes the leading slashes.
+type Comment struct {
+	Text     string
+	Location Location
 }
 
 func NewStringToken(val string, location Location) (Token, error) {
@@ -97,8 +121,10 @@ func NewStringToken(val string, location Location) (Token, error) {
 
 func NewNumberToken(val string, location Location) (Token, error) {
 	num, err := strconv.Atoi(string(val))
-	if err != nil {
-		return Token{}, err
+	if errors.Is(err, strconv.ErrRange) {
+		return Token{}, fmt.Errorf("%s: integer literal %s is out of range", location, val)
+	} else if err != nil {
+		return Token{}, fmt.Errorf("%s: invalid integer literal %s", location, val)
 	}
 
 	return Token{
@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -29,7 +30,10 @@ func TestNewNumberToken(t *testing.T) {
 	require.Equal(t, loc, tok.Location)
 
 	_, err = NewNumberToken("notanumber", loc)
-	require.Error(t, err)
+	require.EqualError(t, err, "file.go:2:3: invalid integer literal notanumber")
+
+	_, err = NewNumberToken("99999999999999999999", loc)
+	require.EqualError(t, err, "file.go:2:3: integer literal 99999999999999999999 is out of range")
 }
 
 func TestNewIdentOrKeywordToken(t *testing.T) {
@@ -60,3 +64,33 @@ func TestNewIdentOrKeywordToken(t *testing.T) {
 	require.Equal(t, "foobar", tok.StringVal)
 	require.Equal(t, loc, tok.Location)
 }
+
+func TestToken_String(t *testing.T) {
+	t.Parallel()
+
+	loc := Location{"file.go", 1, 1}
+
+	tt := []struct {
+		src      string
+		expected string
+	}{
+		{src: "main", expected: "Identifier main"},
+		{src: "return", expected: "Keyword return"},
+		{src: "42", expected: "Number 42"},
+		{src: `"a\tb"`, expected: `String "a\tb"`},
+		{src: "->", expected: "Arrow"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.src, func(t *testing.T) {
+			t.Parallel()
+
+			scanner, err := NewScanner(loc.Filename, strings.NewReader(tc.src))
+			require.NoError(t, err)
+
+			tokens, err := NewLexer(scanner).Tokens()
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, tokens[0].String())
+		})
+	}
+}
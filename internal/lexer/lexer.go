@@ -11,6 +11,7 @@ type Lexer struct {
 	parenDepth   int
 	bracketDepth int
 	prevToken    *Token
+	comments     []Comment // comments seen since the previous token
 }
 
 func NewLexer(scan *Scanner) *Lexer {
@@ -40,6 +41,14 @@ func (t *Lexer) Tokens() ([]Token, error) {
 	}
 }
 
+// TrailingComments returns the comments after the last token, which aren't
+// attached to any token.
+func (t *Lexer) TrailingComments() []Comment {
+	return t.comments
+}
+
+// Next returns the next token. Comments preceding the token are attached to it
+// as trivia.
 func (t *Lexer) Next() (Token, error) {
 	if len(t.Buffer) > 0 {
 		token := t.Buffer[0]
@@ -47,6 +56,18 @@ func (t *Lexer) Next() (Token, error) {
 		return token, nil
 	}
 
+	token, err := t.next()
+	if err != nil {
+		return token, err
+	}
+
+	token.Comments = t.comments
+	t.comments = nil
+
+	return token, nil
+}
+
+func (t *Lexer) next() (Token, error) {
 	var buf []byte
 
 	for {
@@ -85,7 +106,9 @@ func (t *Lexer) Next() (Token, error) {
 
 			switch {
 			case c2 == '/':
-				// Skip comment
+				// Collect the comment, it's attached to the next token.
+				var text []byte
+
 				for {
 					c, err = t.Scan.Next()
 					if err != nil {
@@ -95,7 +118,12 @@ func (t *Lexer) Next() (Token, error) {
 						t.Scan.Unread(1) // Unread the newline character
 						break
 					}
+
+					text = append(text, c)
 				}
+
+				t.comments = append(t.comments, Comment{Text: string(text), Location: start})
+
 				continue
 			default:
 				t.Scan.Unread(1)
@@ -183,6 +183,27 @@ func TestLexerTokens(t *testing.T) {
 	}
 }
 
+func TestLexerComments(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewScanner("test.in", strings.NewReader("// one\n// two\nfoo // three\nbar"))
+	require.NoError(t, err)
+
+	toks, err := NewLexer(s).Tokens()
+	require.NoError(t, err)
+	require.Len(t, toks, 3)
+
+	require.Equal(t, []Comment{
+		{Text: " one", Location: Location{"test.in", 1, 1}},
+		{Text: " two", Location: Location{"test.in", 2, 1}},
+	}, toks[0].Comments)
+
+	// The trailing comment precedes the inserted semicolon.
+	require.Equal(t, TypeSemicolon, toks[1].Type)
+	require.Equal(t, " three", toks[1].Comments[0].Text)
+	require.Empty(t, toks[2].Comments)
+}
+
 func TestShouldInsertSemicolon(t *testing.T) {
 	t.Parallel()
 
@@ -2,27 +2,150 @@ package loader
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
 	"github.com/corani/cubit/internal/lexer"
 	"github.com/corani/cubit/internal/parser"
+	"github.com/corani/cubit/internal/timing"
 )
 
+// SourceExt is the extension of source files, which LoadPackage looks for in
+// a directory.
+const SourceExt = ".in"
+
 type Loader struct {
 	visited map[string]*ast.CompilationUnit
+	files   []*ast.CompilationUnit
+	diags   *diag.Bag
+	tokens  func(filename string, tokens []lexer.Token)
+	timing  *timing.Recorder
 }
 
-func NewLoader() *Loader {
+// NewLoader creates a loader that reports syntax errors to diags.
+func NewLoader(diags *diag.Bag) *Loader {
 	return &Loader{
 		visited: make(map[string]*ast.CompilationUnit),
+		diags:   diags,
 	}
 }
 
+// WithTokens calls fn with the tokens of every file the loader lexes, the
+// source files and their imports, before they're parsed.
+func (l *Loader) WithTokens(fn func(filename string, tokens []lexer.Token)) *Loader {
+	l.tokens = fn
+
+	return l
+}
+
+// WithTiming records how long lexing and parsing every file takes in rec.
+func (l *Loader) WithTiming(rec *timing.Recorder) *Loader {
+	l.timing = rec
+
+	return l
+}
+
 // Load parses the given file and all its imports.
 func (l *Loader) Load(filename string) (*ast.CompilationUnit, error) {
+	return l.LoadPackage(filename)
+}
+
+// LoadPackage parses the source files of one package and all their imports,
+// and merges their declarations into one compilation unit. A directory stands
+// for the source files in it. The files must all declare the same package;
+// declarations that clash are left to the type checker, which reports them
+// with the locations of both.
+func (l *Loader) LoadPackage(paths ...string) (*ast.CompilationUnit, error) {
+	files, err := SourceFiles(paths...)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkg *ast.CompilationUnit
+
+	for _, file := range files {
+		cu, err := l.parse(file)
+		if err != nil {
+			return nil, err
+		}
+
+		// The files are merged into a unit of their own, as the cached
+		// units may be loaded into other packages.
+		if pkg == nil {
+			pkg = ast.NewCompilationUnit(cu.Loc)
+			pkg.Ident = cu.Ident
+		}
+
+		if err := l.merge(pkg, cu); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := l.ResolveImports(pkg); err != nil {
+		return nil, err
+	}
+
+	return pkg, nil
+}
+
+// SourceFiles returns the absolute paths of the source files of paths, in
+// order and without duplicates, with the source files in a directory in
+// lexical order.
+func SourceFiles(paths ...string) ([]string, error) {
+	var files []string
+
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := os.Stat(absPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, absPath)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(absPath, "*"+SourceExt))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no %s files in %s", SourceExt, path)
+		}
+
+		files = append(files, matches...)
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("no source files")
+	}
+
+	// A file may be named as well as be in a named directory.
+	var unique []string
+
+	for _, file := range files {
+		if !slices.Contains(unique, file) {
+			unique = append(unique, file)
+		}
+	}
+
+	return unique, nil
+}
+
+// parse parses the given file, without its imports.
+func (l *Loader) parse(filename string) (*ast.CompilationUnit, error) {
 	absPath, err := filepath.Abs(filename)
 	if err != nil {
 		return nil, err
@@ -43,28 +166,94 @@ func (l *Loader) Load(filename string) (*ast.CompilationUnit, error) {
 		return nil, err
 	}
 
+	stop := l.timing.Start("lex", absPath)
 	tokens, err := lexer.NewLexer(scanner).Tokens()
+	stop()
+
 	if err != nil {
 		return nil, err
 	}
 
-	pr := parser.New(tokens)
+	if l.tokens != nil {
+		l.tokens(absPath, tokens)
+	}
+
+	pr := parser.NewWithDiagnostics(tokens, l.diags)
 
+	stop = l.timing.Start("parse", absPath)
 	cu, err := pr.Parse()
+	stop()
+
 	if err != nil && !errors.Is(err, io.EOF) {
 		return nil, err
 	}
 
+	if l.diags.HasErrors() {
+		return nil, fmt.Errorf("failed to parse %s", filename)
+	}
+
 	l.visited[absPath] = cu
+	l.files = append(l.files, cu)
 
+	return cu, nil
+}
+
+// Files returns the files the loader parsed, the source files and their
+// imports, in the order it parsed them, each with the declarations and
+// imports of its own.
+func (l *Loader) Files() []*ast.CompilationUnit {
+	return l.files
+}
+
+// merge adds the imports and declarations of cu, another file of the package
+// of pkg, to pkg. The attributes of the package are merged too, with the lists
+// of libraries and allowed diagnostics joined.
+func (l *Loader) merge(pkg, cu *ast.CompilationUnit) error {
+	if cu.Ident != pkg.Ident {
+		return l.diags.Errorf(cu.Loc, "package %s, but %s is package %s", cu.Ident, pkg.Loc.Filename, pkg.Ident)
+	}
+
+	for alias, importPath := range cu.Imports {
+		if prev, ok := pkg.Imports[alias]; ok && prev != importPath {
+			return l.diags.Errorf(cu.Loc, "%s imports %q, but %s imports %q as %s",
+				cu.Loc.Filename, importPath, pkg.Loc.Filename, prev, alias)
+		}
+
+		pkg.Imports[alias] = importPath
+	}
+
+	for key, value := range cu.Attributes {
+		prev, ok := pkg.Attributes[key].(ast.AttrString)
+		if next, isString := value.(ast.AttrString); ok && isString && prev != next {
+			value = ast.AttrString(strings.Join([]string{string(prev), string(next)}, ","))
+		}
+
+		pkg.Attributes[key] = value
+	}
+
+	if pkg.Doc == nil {
+		pkg.Doc = cu.Doc
+	}
+
+	pkg.Types = append(pkg.Types, cu.Types...)
+	pkg.Data = append(pkg.Data, cu.Data...)
+	pkg.Funcs = append(pkg.Funcs, cu.Funcs...)
+
+	return nil
+}
+
+// ResolveImports adds the declarations of the packages that cu imports to cu.
+// LoadPackage resolves the imports of the files it loads; a unit parsed from
+// memory, like the input of the REPL, is resolved with this.
+func (l *Loader) ResolveImports(cu *ast.CompilationUnit) error {
 	for alias, importPath := range cu.Imports {
 		_ = alias
 
 		// Special-case: import "core" brings in core.in into the global namespace
 		if importPath == "core" {
-			subCU, err := l.Load("stdlib/core/core.in")
+			subCU, err := l.LoadPackage("stdlib/core/core.in")
 			if err != nil {
-				return nil, err
+				return err
 			}
 
 			// Merge subCU's definitions into cu
@@ -73,11 +262,11 @@ func (l *Loader) Load(filename string) (*ast.CompilationUnit, error) {
 			cu.Funcs = append(cu.Funcs, subCU.Funcs...)
 		} else {
 			// Report an error for now
-			return nil, errors.New("import handling not implemented: " + importPath)
+			return errors.New("import handling not implemented: " + importPath)
 		}
 
 		// In the future, handle other imports here
 	}
 
-	return cu, nil
+	return nil
 }
@@ -0,0 +1,104 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoader_LoadPackage(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(src), 0644))
+
+		return path
+	}
+
+	main := write("pkg/main.in", "@(link=\"m\")\npackage main\n\nmain :: func() -> int {\n    return twice(2)\n}\n")
+	write("pkg/util.in", "@(link=\"pthread\")\npackage main\n\ntwice :: func(n: int) -> int {\n    return n * 2\n}\n")
+	other := write("other.in", "package other\n\nf :: func() {\n}\n")
+	truncated := write("truncated.in", "package main\n\nmain :: func() -> int {\n    return 0\n")
+	attributes := write("attributes.in", "package main\n\n@(export)\n")
+	unterminated := write("unterminated.in", "package main\n\nmain :: func() -> int {\n    s := \"hi\n    return 0\n}\n")
+
+	funcs := func(unit *ast.CompilationUnit) []string {
+		var idents []string
+		for _, fd := range unit.Funcs {
+			idents = append(idents, fd.Ident)
+		}
+
+		return idents
+	}
+
+	tt := []struct {
+		name     string
+		paths    []string
+		funcs    []string
+		link     string
+		expected string
+		diag     string
+	}{
+		{name: "file", paths: []string{main}, funcs: []string{"main"}, link: "m"},
+		{name: "directory", paths: []string{filepath.Join(dir, "pkg")}, funcs: []string{"main", "twice"}, link: "m,pthread"},
+		{name: "file and directory", paths: []string{main, filepath.Join(dir, "pkg")}, funcs: []string{"main", "twice"}, link: "m,pthread"},
+		{
+			name:     "other package",
+			paths:    []string{main, other},
+			expected: other + ":1:1: package other, but " + main + " is package main",
+		},
+		{name: "empty directory", paths: []string{t.TempDir()}, expected: "no .in files in "},
+		{
+			name:     "truncated file",
+			paths:    []string{truncated},
+			expected: "failed to parse " + truncated,
+			diag:     truncated + ":4:12: unexpected end of file",
+		},
+		{
+			name:     "attributes without declaration",
+			paths:    []string{attributes},
+			expected: "failed to parse " + attributes,
+			diag:     attributes + ":3:9: unexpected end of file",
+		},
+		{
+			name:     "unterminated string",
+			paths:    []string{unterminated},
+			expected: "failed to parse " + unterminated,
+			diag:     unterminated + ":4:10: unexpected end of file",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			diags := diag.NewBag(diag.Config{})
+
+			unit, err := NewLoader(diags).LoadPackage(tc.paths...)
+			if tc.expected != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expected)
+
+				if tc.diag != "" {
+					require.Error(t, diags.Err())
+					require.Contains(t, diags.Err().Error(), tc.diag)
+				}
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, "main", unit.Ident)
+			require.Equal(t, tc.funcs, funcs(unit))
+			require.Equal(t, ast.AttrString(tc.link), unit.Attributes[ast.AttrKeyLink])
+		})
+	}
+}
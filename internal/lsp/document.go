@@ -0,0 +1,422 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/ast/printer"
+	"github.com/corani/cubit/internal/ast/symbols"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/corani/cubit/internal/loader"
+	"github.com/corani/cubit/internal/parser"
+	"github.com/corani/cubit/internal/typecheck"
+)
+
+// document is an open document, and what the compiler found in it.
+type document struct {
+	uri   string
+	path  string // the file the locations of the document are in
+	lines []string
+
+	// unit is what parsed of the document, with its imports, and table the
+	// declarations its names resolve to. The unit is type checked if it
+	// parsed without errors.
+	unit  *ast.CompilationUnit
+	table *symbols.Table
+	diags []diag.Diagnostic
+}
+
+// analyze lexes, parses and type checks text, the text of the document at uri.
+func analyze(uri, text string) *document {
+	doc := &document{
+		uri:   uri,
+		path:  uriPath(uri),
+		lines: strings.Split(text, "\n"),
+	}
+
+	diags := diag.NewBag(diag.Config{})
+	defer func() { doc.diags = diags.Diagnostics() }()
+
+	scanner, err := lexer.NewScanner(doc.path, strings.NewReader(text))
+	if err != nil {
+		diags.Add(err)
+		return doc
+	}
+
+	tokens, err := lexer.NewLexer(scanner).Tokens()
+	if err != nil {
+		diags.Add(err)
+		return doc
+	}
+
+	// The parser stops at the first error it can't recover from, but what
+	// it parsed so far still resolves.
+	unit, err := parser.NewWithDiagnostics(tokens, diags).Parse()
+	if err != nil && !errors.Is(err, io.EOF) && !diags.HasErrors() {
+		diags.Add(err)
+	}
+
+	if unit == nil {
+		return doc
+	}
+
+	doc.unit = unit
+
+	if !diags.HasErrors() {
+		if err := loader.NewLoader(diags).ResolveImports(unit); err != nil {
+			diags.Errorf(unit.Loc, "%v", err)
+		}
+
+		// The errors are in the bag.
+		_ = typecheck.NewChecker(typecheck.Options{Diagnostics: diags}).Check(unit)
+	}
+
+	// The type checker reports the names that don't resolve.
+	doc.table, _ = symbols.Resolve(unit)
+
+	return doc
+}
+
+// uriPath returns the path of a file URI, or the URI itself for another
+// scheme, so it still names the document.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+
+	return filepath.FromSlash(u.Path)
+}
+
+// pathURI returns the file URI of path.
+func pathURI(path string) string {
+	if strings.Contains(path, "://") {
+		return path
+	}
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// position returns the position of loc in the document. A line that isn't in
+// the document is counted in bytes.
+func (d *document) position(loc lexer.Location) Position {
+	line, col := max(loc.Line-1, 0), max(loc.Column-1, 0)
+
+	if line >= len(d.lines) {
+		return Position{Line: line, Character: col}
+	}
+
+	text := d.lines[line][:min(col, len(d.lines[line]))]
+
+	return Position{Line: line, Character: len(utf16.Encode([]rune(text)))}
+}
+
+// location returns the location of pos in the document.
+func (d *document) location(pos Position) lexer.Location {
+	loc := lexer.Location{Filename: d.path, Line: pos.Line + 1, Column: pos.Character + 1}
+
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return loc
+	}
+
+	// Count the bytes of the characters up to pos.
+	units := 0
+
+	for i, r := range d.lines[pos.Line] {
+		if units >= pos.Character {
+			loc.Column = i + 1
+			return loc
+		}
+
+		units += len(utf16.Encode([]rune{r}))
+	}
+
+	loc.Column = len(d.lines[pos.Line]) + 1
+
+	return loc
+}
+
+// identRange returns the range of the name ident at loc.
+func (d *document) identRange(loc lexer.Location, ident string) Range {
+	end := loc
+	end.Column += len(ident)
+
+	return Range{Start: d.position(loc), End: d.position(end)}
+}
+
+// wordRange returns the range of the word at loc, or of the one character
+// there if it's not in a word, for a diagnostic that only has a start.
+func (d *document) wordRange(loc lexer.Location) Range {
+	end := loc
+	end.Column++
+
+	if line := loc.Line - 1; line >= 0 && line < len(d.lines) {
+		text := d.lines[line]
+
+		for i := loc.Column; i <= len(text) && isWordByte(text[i-1]); i++ {
+			end.Column = i + 1
+		}
+	}
+
+	return Range{Start: d.position(loc), End: d.position(end)}
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// diagnostics returns the diagnostics in the document, in the protocol.
+func (d *document) diagnostics() []Diagnostic {
+	diagnostics := []Diagnostic{}
+
+	for _, dg := range d.diags {
+		// The diagnostics of the imported files aren't shown here.
+		if !dg.Span.IsZero() && dg.Span.Start.Filename != d.path {
+			continue
+		}
+
+		out := Diagnostic{
+			Severity: severity(dg.Severity),
+			Source:   "cubit",
+			Message:  dg.Message,
+		}
+
+		switch {
+		case dg.Span.IsZero():
+			// At the start of the document.
+		case dg.Span.End != (lexer.Location{}):
+			out.Range = Range{Start: d.position(dg.Span.Start), End: d.position(dg.Span.End)}
+		default:
+			out.Range = d.wordRange(dg.Span.Start)
+		}
+
+		if dg.Code != nil {
+			out.Code = dg.Code.ID
+		}
+
+		for _, note := range dg.Notes {
+			out.RelatedInformation = append(out.RelatedInformation, DiagnosticRelatedInformation{
+				Location: Location{URI: pathURI(note.Span.Start.Filename), Range: d.wordRange(note.Span.Start)},
+				Message:  note.Message,
+			})
+		}
+
+		diagnostics = append(diagnostics, out)
+	}
+
+	return diagnostics
+}
+
+func severity(s diag.Severity) int {
+	switch s {
+	case diag.SeverityError:
+		return severityError
+	case diag.SeverityWarning:
+		return severityWarning
+	default:
+		return severityInformation
+	}
+}
+
+// nodeAt returns the node whose name is at loc: a declaration, a reference to
+// a variable or a call.
+func (d *document) nodeAt(loc lexer.Location) (ast.Node, string) {
+	if d.unit == nil {
+		return nil, ""
+	}
+
+	var (
+		found ast.Node
+		name  string
+	)
+
+	ast.Walk(d.unit, func(n ast.Node) bool {
+		if found != nil {
+			return false
+		}
+
+		ident, at, ok := declName(n)
+		if !ok {
+			switch n := n.(type) {
+			case *ast.VariableRef:
+				ident, at, ok = n.Ident, n.Loc, true
+			case *ast.Call:
+				ident, at, ok = n.Ident, n.Loc, true
+			}
+		}
+
+		if ok && at.Filename == loc.Filename && at.Line == loc.Line &&
+			at.Column <= loc.Column && loc.Column < at.Column+len(ident) {
+			found, name = n, ident
+		}
+
+		return true
+	})
+
+	return found, name
+}
+
+// declName returns the name of a declaration, and where it is.
+func declName(n ast.Node) (string, lexer.Location, bool) {
+	switch n := n.(type) {
+	case *ast.FuncDef:
+		return n.Ident, n.Loc, true
+	case *ast.FuncParam:
+		return n.Ident, n.Loc, true
+	case *ast.Declare:
+		return n.Ident, n.Loc, true
+	case *ast.DataDef:
+		return n.Ident, n.Loc, true
+	case *ast.TypeDef:
+		return n.Ident, n.Loc, true
+	default:
+		return "", lexer.Location{}, false
+	}
+}
+
+// declaration returns the declaration n refers to, or n if it's one.
+func (d *document) declaration(n ast.Node) ast.Node {
+	switch n := n.(type) {
+	case *ast.VariableRef:
+		if d.table != nil {
+			return d.table.Declaration(n)
+		}
+	case *ast.Call:
+		// The type checker picked the overload that's called.
+		if n.FuncDef != nil {
+			return n.FuncDef
+		}
+
+		if d.table != nil {
+			if sym, ok := d.table.Calls[n]; ok {
+				return sym.Node
+			}
+		}
+	default:
+		if _, _, ok := declName(n); ok {
+			return n
+		}
+	}
+
+	return nil
+}
+
+// hover returns the text shown for the node n, as markdown: the declaration
+// it refers to, with its type, and its documentation.
+func (d *document) hover(n ast.Node) string {
+	decl := d.declaration(n)
+
+	var code string
+
+	var doc *ast.CommentGroup
+
+	switch decl := decl.(type) {
+	case *ast.FuncDef:
+		code, doc = printer.SprintSignature(decl), decl.Doc
+	case *ast.DataDef:
+		code, doc = binding(decl.Ident, decl.Type), decl.Doc
+	case *ast.TypeDef:
+		code, doc = fmt.Sprintf("%s :: %s", decl.Ident, decl.Type), decl.Doc
+	case *ast.FuncParam:
+		code = binding(decl.Ident, decl.Type)
+	case *ast.Declare:
+		code = binding(decl.Ident, decl.Type)
+	default:
+		return ""
+	}
+
+	// A variable declared without a type has the type the checker
+	// inferred for its references.
+	if ref, ok := n.(*ast.VariableRef); ok {
+		if ty := ref.Type(); ty != nil && ty.Kind != ast.TypeUnknown {
+			code = binding(ref.Ident, ty)
+		}
+	}
+
+	text := "```cubit\n" + code + "\n```"
+	if doc != nil {
+		text += "\n\n" + doc.Text()
+	}
+
+	return text
+}
+
+// binding returns `name: type`, or the name if the type isn't known.
+func binding(ident string, ty *ast.Type) string {
+	if ty == nil || ty.Kind == ast.TypeUnknown {
+		return ident
+	}
+
+	return fmt.Sprintf("%s: %s", ident, ty)
+}
+
+// symbols returns the declarations of the document, in the order of the
+// unit.
+func (d *document) symbols() []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+
+	if d.unit == nil {
+		return symbols
+	}
+
+	// symbol returns the symbol of a declaration that spans from its
+	// documentation, or its name, to end.
+	symbol := func(kind int, ident, detail string, doc *ast.CommentGroup, loc, end lexer.Location) DocumentSymbol {
+		start := loc
+		if doc != nil && len(doc.List) > 0 {
+			start = doc.List[0].Loc
+		}
+
+		sel := d.identRange(loc, ident)
+		full := Range{Start: d.position(start), End: sel.End}
+
+		if end != (lexer.Location{}) {
+			end.Column++ // past the closing brace
+			full.End = d.position(end)
+		}
+
+		return DocumentSymbol{Name: ident, Detail: detail, Kind: kind, Range: full, SelectionRange: sel}
+	}
+
+	for _, td := range d.unit.Types {
+		if td.Loc.Filename == d.path {
+			symbols = append(symbols, symbol(symbolKindStruct, td.Ident, td.Type.String(), td.Doc, td.Loc, lexer.Location{}))
+		}
+	}
+
+	for _, dd := range d.unit.Data {
+		if dd.Loc.Filename == d.path {
+			symbols = append(symbols, symbol(symbolKindVariable, dd.Ident, dd.Type.String(), dd.Doc, dd.Loc, lexer.Location{}))
+		}
+	}
+
+	for _, fd := range d.unit.Funcs {
+		if fd.Loc.Filename != d.path {
+			continue
+		}
+
+		// The signature without the attributes and the name.
+		sig := printer.SprintSignature(fd)
+		sig = strings.TrimPrefix(sig[strings.LastIndex(sig, "\n")+1:], fd.Ident+" :: ")
+
+		var end lexer.Location
+		if fd.Body != nil {
+			end = fd.Body.End
+		}
+
+		symbols = append(symbols, symbol(symbolKindFunction, fd.Ident, sig, fd.Doc, fd.Loc, end))
+	}
+
+	return symbols
+}
@@ -0,0 +1,179 @@
+package lsp
+
+import "encoding/json"
+
+// The messages of JSON-RPC 2.0, which the protocol is built on. A request
+// has an ID and a method, a notification only a method, and a response only
+// the ID of its request.
+type (
+	message struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id,omitempty"`
+		Method  string          `json:"method,omitempty"`
+		Params  json.RawMessage `json:"params,omitempty"`
+	}
+
+	response struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  any             `json:"result"`
+	}
+
+	errorResponse struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Error   *responseError  `json:"error"`
+	}
+
+	notification struct {
+		JSONRPC string `json:"jsonrpc"`
+		Method  string `json:"method"`
+		Params  any    `json:"params"`
+	}
+)
+
+// responseError is the error of a request that failed.
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+// The codes of the errors of a request.
+const (
+	codeParseError           = -32700
+	codeInvalidParams        = -32602
+	codeMethodNotFound       = -32601
+	codeServerNotInitialized = -32002
+	codeInvalidRequest       = -32600
+)
+
+// The types of the protocol the server uses. Positions are 0-based, and
+// characters are counted in UTF-16 code units, like editors do.
+type (
+	Position struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	}
+
+	Range struct {
+		Start Position `json:"start"`
+		End   Position `json:"end"`
+	}
+
+	Location struct {
+		URI   string `json:"uri"`
+		Range Range  `json:"range"`
+	}
+
+	TextDocumentIdentifier struct {
+		URI string `json:"uri"`
+	}
+
+	TextDocumentItem struct {
+		URI     string `json:"uri"`
+		Version int    `json:"version"`
+		Text    string `json:"text"`
+	}
+
+	TextDocumentPositionParams struct {
+		TextDocument TextDocumentIdentifier `json:"textDocument"`
+		Position     Position               `json:"position"`
+	}
+
+	DidOpenTextDocumentParams struct {
+		TextDocument TextDocumentItem `json:"textDocument"`
+	}
+
+	DidChangeTextDocumentParams struct {
+		TextDocument   TextDocumentIdentifier           `json:"textDocument"`
+		ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+	}
+
+	// TextDocumentContentChangeEvent is the whole text of a document, as
+	// the server only syncs whole documents.
+	TextDocumentContentChangeEvent struct {
+		Text string `json:"text"`
+	}
+
+	DidCloseTextDocumentParams struct {
+		TextDocument TextDocumentIdentifier `json:"textDocument"`
+	}
+
+	DocumentSymbolParams struct {
+		TextDocument TextDocumentIdentifier `json:"textDocument"`
+	}
+
+	Diagnostic struct {
+		Range              Range                          `json:"range"`
+		Severity           int                            `json:"severity"`
+		Code               string                         `json:"code,omitempty"`
+		Source             string                         `json:"source"`
+		Message            string                         `json:"message"`
+		RelatedInformation []DiagnosticRelatedInformation `json:"relatedInformation,omitempty"`
+	}
+
+	DiagnosticRelatedInformation struct {
+		Location Location `json:"location"`
+		Message  string   `json:"message"`
+	}
+
+	PublishDiagnosticsParams struct {
+		URI         string       `json:"uri"`
+		Diagnostics []Diagnostic `json:"diagnostics"`
+	}
+
+	Hover struct {
+		Contents MarkupContent `json:"contents"`
+		Range    *Range        `json:"range,omitempty"`
+	}
+
+	MarkupContent struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}
+
+	DocumentSymbol struct {
+		Name           string `json:"name"`
+		Detail         string `json:"detail,omitempty"`
+		Kind           int    `json:"kind"`
+		Range          Range  `json:"range"`
+		SelectionRange Range  `json:"selectionRange"`
+	}
+
+	InitializeResult struct {
+		Capabilities ServerCapabilities `json:"capabilities"`
+		ServerInfo   ServerInfo         `json:"serverInfo"`
+	}
+
+	ServerCapabilities struct {
+		TextDocumentSync       int  `json:"textDocumentSync"`
+		DefinitionProvider     bool `json:"definitionProvider"`
+		HoverProvider          bool `json:"hoverProvider"`
+		DocumentSymbolProvider bool `json:"documentSymbolProvider"`
+	}
+
+	ServerInfo struct {
+		Name string `json:"name"`
+	}
+)
+
+// textDocumentSyncFull syncs the whole text of a document on every change.
+const textDocumentSyncFull = 1
+
+// The severities of a diagnostic.
+const (
+	severityError       = 1
+	severityWarning     = 2
+	severityInformation = 3
+)
+
+// The kinds of a document symbol.
+const (
+	symbolKindFunction = 12
+	symbolKindVariable = 13
+	symbolKindStruct   = 23
+)
@@ -0,0 +1,330 @@
+// Package lsp is a language server, for `cubit lsp`. It speaks the Language
+// Server Protocol over a pair of streams, usually stdin and stdout, and
+// gives editors the diagnostics of a document whenever it changes, the
+// declaration of a name, hover text with types and documentation, and the
+// declarations of a document.
+//
+// Every change lexes, parses and type checks the whole document again, with
+// its imports, like the compiler does. Documents are synced whole.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+
+	"github.com/corani/cubit/internal/ast"
+)
+
+// Server is a language server that reads requests from one stream and writes
+// the responses to another.
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	docs        map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+// NewServer returns a server that reads from in and writes to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]*document),
+	}
+}
+
+// errExit is returned by the handler of the exit notification.
+var errExit = errors.New("exit")
+
+// Serve handles messages until the client sends exit, or closes the stream.
+// It returns an error if that happens before the client sent shutdown, as
+// the protocol asks the server to exit with a failure then.
+func (s *Server) Serve() error {
+	for {
+		msg, err := s.read()
+		if err == nil && msg != nil {
+			err = s.handle(msg)
+		}
+
+		switch {
+		case errors.Is(err, io.EOF) || errors.Is(err, errExit):
+			if !s.shutdown {
+				return errors.New("exited without a shutdown request")
+			}
+
+			return nil
+		case err != nil:
+			return err
+		}
+	}
+}
+
+// read reads a message: headers, of which only Content-Length matters, and
+// a JSON body of that length. A body that isn't JSON is answered with an
+// error, and read returns no message.
+func (s *Server) read() (*message, error) {
+	headers, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %w", err)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, s.respond(json.RawMessage("null"), nil, &responseError{Code: codeParseError, Message: err.Error()})
+	}
+
+	return &msg, nil
+}
+
+// write writes v as a message.
+func (s *Server) write(v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+
+	return err
+}
+
+// respond writes the response to the request with id: its result, or its
+// error.
+func (s *Server) respond(id json.RawMessage, result any, err error) error {
+	if err == nil {
+		return s.write(response{JSONRPC: "2.0", ID: id, Result: result})
+	}
+
+	var rerr *responseError
+	if !errors.As(err, &rerr) {
+		rerr = &responseError{Code: codeInvalidRequest, Message: err.Error()}
+	}
+
+	return s.write(errorResponse{JSONRPC: "2.0", ID: id, Error: rerr})
+}
+
+// notify writes a notification.
+func (s *Server) notify(method string, params any) error {
+	return s.write(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// handler handles the params of a request or notification, and returns the
+// result of a request.
+type handler func(s *Server, params json.RawMessage) (any, error)
+
+// handlers are the methods the server handles.
+var handlers = map[string]handler{
+	"initialize":                  (*Server).initialize,
+	"initialized":                 ignore,
+	"shutdown":                    (*Server).shutdownRequest,
+	"exit":                        func(*Server, json.RawMessage) (any, error) { return nil, errExit },
+	"textDocument/didOpen":        (*Server).didOpen,
+	"textDocument/didChange":      (*Server).didChange,
+	"textDocument/didClose":       (*Server).didClose,
+	"textDocument/definition":     (*Server).definition,
+	"textDocument/hover":          (*Server).hover,
+	"textDocument/documentSymbol": (*Server).documentSymbol,
+}
+
+func ignore(*Server, json.RawMessage) (any, error) {
+	return nil, nil
+}
+
+// handle handles msg, and responds if it's a request. Notifications the
+// server doesn't know are ignored, as the protocol asks.
+func (s *Server) handle(msg *message) error {
+	isRequest := len(msg.ID) > 0
+
+	h, ok := handlers[msg.Method]
+
+	switch {
+	case !ok && isRequest:
+		return s.respond(msg.ID, nil, &responseError{Code: codeMethodNotFound, Message: "unknown method " + msg.Method})
+	case !ok:
+		return nil
+	case !s.initialized && msg.Method != "initialize" && msg.Method != "exit":
+		if isRequest {
+			return s.respond(msg.ID, nil, &responseError{Code: codeServerNotInitialized, Message: "the server isn't initialized"})
+		}
+
+		return nil
+	}
+
+	result, err := h(s, msg.Params)
+	if errors.Is(err, errExit) || !isRequest {
+		return err
+	}
+
+	return s.respond(msg.ID, result, err)
+}
+
+// decode decodes params into v.
+func decode(params json.RawMessage, v any) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+
+	return nil
+}
+
+func (s *Server) initialize(json.RawMessage) (any, error) {
+	s.initialized = true
+
+	return InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync:       textDocumentSyncFull,
+			DefinitionProvider:     true,
+			HoverProvider:          true,
+			DocumentSymbolProvider: true,
+		},
+		ServerInfo: ServerInfo{Name: "cubit"},
+	}, nil
+}
+
+func (s *Server) shutdownRequest(json.RawMessage) (any, error) {
+	s.shutdown = true
+
+	return nil, nil
+}
+
+// update analyzes the text of the document at uri, and publishes its
+// diagnostics.
+func (s *Server) update(uri, text string) error {
+	doc := analyze(uri, text)
+	s.docs[uri] = doc
+
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: doc.diagnostics(),
+	})
+}
+
+func (s *Server) didOpen(params json.RawMessage) (any, error) {
+	var p DidOpenTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	return nil, s.update(p.TextDocument.URI, p.TextDocument.Text)
+}
+
+func (s *Server) didChange(params json.RawMessage) (any, error) {
+	var p DidChangeTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	// The changes are whole documents, so the last one is the text.
+	if len(p.ContentChanges) == 0 {
+		return nil, nil
+	}
+
+	return nil, s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+}
+
+func (s *Server) didClose(params json.RawMessage) (any, error) {
+	var p DidCloseTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	delete(s.docs, p.TextDocument.URI)
+
+	// The diagnostics of a closed document are cleared.
+	return nil, s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         p.TextDocument.URI,
+		Diagnostics: []Diagnostic{},
+	})
+}
+
+// at returns the open document of a request for a position, and the node
+// whose name is there, if any.
+func (s *Server) at(params json.RawMessage) (*document, ast.Node, error) {
+	var p TextDocumentPositionParams
+	if err := decode(params, &p); err != nil {
+		return nil, nil, err
+	}
+
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil, nil, &responseError{Code: codeInvalidParams, Message: "unknown document " + p.TextDocument.URI}
+	}
+
+	n, _ := doc.nodeAt(doc.location(p.Position))
+
+	return doc, n, nil
+}
+
+func (s *Server) definition(params json.RawMessage) (any, error) {
+	doc, n, err := s.at(params)
+	if err != nil || n == nil {
+		return nil, err
+	}
+
+	decl := doc.declaration(n)
+	if decl == nil {
+		return nil, nil
+	}
+
+	ident, loc, _ := declName(decl)
+
+	// The declaration may be in another document, or in an imported file
+	// that isn't open, whose columns are counted in bytes.
+	target := doc
+	if loc.Filename != doc.path {
+		target = &document{path: loc.Filename}
+
+		for _, open := range s.docs {
+			if open.path == loc.Filename {
+				target = open
+			}
+		}
+	}
+
+	return Location{URI: pathURI(loc.Filename), Range: target.identRange(loc, ident)}, nil
+}
+
+func (s *Server) hover(params json.RawMessage) (any, error) {
+	doc, n, err := s.at(params)
+	if err != nil || n == nil {
+		return nil, err
+	}
+
+	text := doc.hover(n)
+	if text == "" {
+		return nil, nil
+	}
+
+	return Hover{Contents: MarkupContent{Kind: "markdown", Value: text}}, nil
+}
+
+func (s *Server) documentSymbol(params json.RawMessage) (any, error) {
+	var p DocumentSymbolParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: "unknown document " + p.TextDocument.URI}
+	}
+
+	return doc.symbols(), nil
+}
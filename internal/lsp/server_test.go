@@ -0,0 +1,246 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testURI = "file:///work/main.in"
+
+const testSource = `package main
+
+// Square returns n squared.
+square :: func(n: int) -> int {
+    return n * n
+}
+
+main :: func() -> int {
+    x := square(3)
+    return x
+}
+`
+
+// session writes requests to a server and reads what it writes back.
+type session struct {
+	t   *testing.T
+	in  *io.PipeWriter
+	out *bufio.Reader
+	id  int
+}
+
+func newSession(t *testing.T) (*session, <-chan error) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- NewServer(inR, outW).Serve()
+		outW.Close()
+	}()
+
+	return &session{t: t, in: inW, out: bufio.NewReader(outR)}, done
+}
+
+func (s *session) send(v any) {
+	body, err := json.Marshal(v)
+	require.NoError(s.t, err)
+
+	_, err = fmt.Fprintf(s.in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	require.NoError(s.t, err)
+}
+
+func (s *session) receive() map[string]any {
+	headers, err := textproto.NewReader(s.out).ReadMIMEHeader()
+	require.NoError(s.t, err)
+
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	require.NoError(s.t, err)
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(s.out, body)
+	require.NoError(s.t, err)
+
+	var msg map[string]any
+	require.NoError(s.t, json.Unmarshal(body, &msg))
+
+	return msg
+}
+
+func (s *session) notify(method string, params any) {
+	s.send(map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// request sends a request, and returns the response.
+func (s *session) request(method string, params any) map[string]any {
+	s.id++
+	s.send(map[string]any{"jsonrpc": "2.0", "id": s.id, "method": method, "params": params})
+
+	// The numbers in the messages decode as float64.
+	msg := s.receive()
+	require.Equal(s.t, float64(s.id), msg["id"])
+
+	return msg
+}
+
+// result returns the result of a response, as JSON.
+func (s *session) result(msg map[string]any) string {
+	body, err := json.Marshal(msg["result"])
+	require.NoError(s.t, err)
+
+	return string(body)
+}
+
+func at(line, character int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": testURI},
+		"position":     map[string]any{"line": line, "character": character},
+	}
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	s, done := newSession(t)
+
+	msg := s.request("textDocument/hover", at(0, 0))
+	require.Equal(t, float64(codeServerNotInitialized), msg["error"].(map[string]any)["code"])
+
+	msg = s.request("initialize", map[string]any{})
+	require.Equal(t, `{"capabilities":{"definitionProvider":true,"documentSymbolProvider":true,"hoverProvider":true,"textDocumentSync":1},"serverInfo":{"name":"cubit"}}`, s.result(msg))
+
+	s.notify("initialized", map[string]any{})
+	s.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": testURI, "version": 1, "text": testSource},
+	})
+
+	msg = s.receive()
+	require.Equal(t, "textDocument/publishDiagnostics", msg["method"])
+	require.Empty(t, msg["params"].(map[string]any)["diagnostics"])
+
+	tt := []struct {
+		name     string
+		method   string
+		params   any
+		expected string
+	}{
+		{
+			name:     "definition of a call",
+			method:   "textDocument/definition",
+			params:   at(8, 10),
+			expected: `{"range":{"end":{"character":6,"line":3},"start":{"character":0,"line":3}},"uri":"file:///work/main.in"}`,
+		},
+		{
+			name:     "definition of a variable",
+			method:   "textDocument/definition",
+			params:   at(9, 11),
+			expected: `{"range":{"end":{"character":5,"line":8},"start":{"character":4,"line":8}},"uri":"file:///work/main.in"}`,
+		},
+		{
+			name:     "definition of nothing",
+			method:   "textDocument/definition",
+			params:   at(9, 0),
+			expected: `null`,
+		},
+		{
+			name:     "hover on a call",
+			method:   "textDocument/hover",
+			params:   at(8, 10),
+			expected: `{"contents":{"kind":"markdown","value":"` + "```cubit\\nsquare :: func(n: int) -\\u003e int\\n```\\n\\nSquare returns n squared." + `"}}`,
+		},
+		{
+			name:     "hover on an inferred variable",
+			method:   "textDocument/hover",
+			params:   at(9, 11),
+			expected: `{"contents":{"kind":"markdown","value":"` + "```cubit\\nx: int\\n```" + `"}}`,
+		},
+		{
+			name:   "document symbols",
+			method: "textDocument/documentSymbol",
+			params: map[string]any{"textDocument": map[string]any{"uri": testURI}},
+			expected: `[{"detail":"func(n: int) -\u003e int","kind":12,"name":"square",` +
+				`"range":{"end":{"character":1,"line":5},"start":{"character":0,"line":2}},` +
+				`"selectionRange":{"end":{"character":6,"line":3},"start":{"character":0,"line":3}}},` +
+				`{"detail":"func() -\u003e int","kind":12,"name":"main",` +
+				`"range":{"end":{"character":1,"line":10},"start":{"character":0,"line":7}},` +
+				`"selectionRange":{"end":{"character":4,"line":7},"start":{"character":0,"line":7}}}]`,
+		},
+	}
+
+	// The requests share the server, so they run in order.
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			s.t = t
+			require.Equal(t, tc.expected, s.result(s.request(tc.method, tc.params)))
+		})
+	}
+
+	s.t = t
+
+	s.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": testURI, "version": 2},
+		"contentChanges": []any{map[string]any{"text": strings.Replace(testSource, "return x", "return y", 1)}},
+	})
+
+	msg = s.receive()
+	diagnostics, err := json.Marshal(msg["params"].(map[string]any)["diagnostics"])
+	require.NoError(t, err)
+	require.Equal(t, `[{"message":"undefined variable 'y'","range":{"end":{"character":12,"line":9},"start":{"character":11,"line":9}},"severity":1,"source":"cubit"},`+
+		`{"code":"CB0001","message":"variable 'x' is declared but never used","range":{"end":{"character":5,"line":8},"start":{"character":4,"line":8}},"severity":2,"source":"cubit"}]`,
+		string(diagnostics))
+
+	msg = s.request("workspace/symbol", map[string]any{})
+	require.Equal(t, float64(codeMethodNotFound), msg["error"].(map[string]any)["code"])
+
+	s.request("shutdown", nil)
+	s.notify("exit", nil)
+
+	require.NoError(t, <-done)
+}
+
+func TestServer_ExitWithoutShutdown(t *testing.T) {
+	t.Parallel()
+
+	s, done := newSession(t)
+
+	s.request("initialize", map[string]any{})
+	s.notify("exit", nil)
+
+	require.Error(t, <-done)
+}
+
+func TestDocument_Position(t *testing.T) {
+	t.Parallel()
+
+	// é is two bytes and one code unit, and 😀 four bytes and two units.
+	doc := analyze(testURI, "package main\n// é😀x\n")
+
+	tt := []struct {
+		column   int
+		expected int
+	}{
+		{column: 1, expected: 0},
+		{column: 4, expected: 3},
+		{column: 6, expected: 4},
+		{column: 10, expected: 6},
+	}
+
+	for _, tc := range tt {
+		t.Run(strconv.Itoa(tc.column), func(t *testing.T) {
+			t.Parallel()
+
+			loc := doc.location(Position{Line: 1, Character: tc.expected})
+			require.Equal(t, tc.column, loc.Column)
+
+			require.Equal(t, Position{Line: 1, Character: tc.expected}, doc.position(loc))
+		})
+	}
+}
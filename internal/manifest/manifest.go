@@ -0,0 +1,272 @@
+// Package manifest reads cubit.toml, the manifest of a project: the package it
+// builds, where its source files are, and the settings to build it with, in
+// general and per profile, like debug and release.
+//
+//	[package]
+//	name = "hello"
+//	sources = ["src"]
+//
+//	[build]
+//	target = "linux/arm64"
+//	link = ["m"]
+//
+//	[profile.release]
+//	opt-level = 2
+//	strict = true
+//
+// The settings are the ones of flags of the driver: target, backend,
+// opt-level (-O), opt, debug (-g), strict, inline, hardening and link, with a
+// list for the comma-separated ones. The flags on the command line override them.
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Filename is the name of the manifest, in the directory of the project.
+const Filename = "cubit.toml"
+
+// DefaultProfile is the profile a project is built with if none is given.
+const DefaultProfile = "debug"
+
+// settingFlags are the settings of the [build] and [profile.<name>] tables,
+// and the flags of the driver they stand for.
+var settingFlags = map[string]string{
+	"target":    "target",
+	"backend":   "backend",
+	"opt-level": "O",
+	"opt":       "opt",
+	"debug":     "g",
+	"strict":    "strict",
+	"inline":    "inline",
+	"hardening": "hardening",
+	"link":      "link",
+}
+
+// Setting is a setting of the manifest, as the flag it stands for.
+type Setting struct {
+	Flag, Value string
+	Loc         lexer.Location
+}
+
+// profiles are the settings of the profiles every project has, which the
+// tables of the profiles add to. A debug build has line information and isn't
+// optimized, a release build is.
+var profiles = map[string][]Setting{
+	"debug":   {{Flag: "g", Value: "true"}, {Flag: "O", Value: "0"}},
+	"release": {{Flag: "O", Value: "2"}},
+}
+
+// Manifest is the manifest of a project.
+type Manifest struct {
+	// Dir is the directory of the manifest, the root of the project.
+	Dir string
+	// Name is the name of the package, which the outputs are named after;
+	// empty to name them after the directory.
+	Name string
+	// Sources are the source files and directories of the package, relative
+	// to Dir; the default is Dir itself.
+	Sources []string
+
+	build    []Setting
+	profiles map[string][]Setting
+}
+
+// Find returns the manifest in dir, if there is one.
+func Find(dir string) (string, bool) {
+	path := filepath.Join(dir, Filename)
+
+	info, err := os.Stat(path)
+
+	return path, err == nil && !info.IsDir()
+}
+
+// Load reads the manifest at path.
+func Load(path string) (*Manifest, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(path, string(src))
+}
+
+// Parse parses src, the manifest at path.
+func Parse(path, src string) (*Manifest, error) {
+	doc, err := parseTOML(path, src)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{
+		Dir:      filepath.Dir(path),
+		profiles: make(map[string][]Setting),
+	}
+
+	var errs []error
+
+	for _, table := range slices.Sorted(maps.Keys(doc)) {
+		keys := doc[table]
+
+		switch {
+		case table == "" && len(keys) > 0:
+			// The keys belong in a table, so a misplaced one is reported.
+			for _, key := range slices.Sorted(maps.Keys(keys)) {
+				errs = append(errs, diag.Errorf(keys[key].loc, "key %s must be in a table, like [package] or [build]", key))
+			}
+		case table == "":
+		case table == "package":
+			errs = append(errs, m.parsePackage(keys)...)
+		case table == "build":
+			m.build, err = settings(keys)
+			errs = append(errs, err)
+		case strings.HasPrefix(table, "profile."):
+			m.profiles[strings.TrimPrefix(table, "profile.")], err = settings(keys)
+			errs = append(errs, err)
+		default:
+			errs = append(errs, fmt.Errorf("%s: unknown table [%s], want package, build or profile.<name>", path, table))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Manifest) parsePackage(keys map[string]value) []error {
+	var errs []error
+
+	for _, key := range slices.Sorted(maps.Keys(keys)) {
+		v := keys[key]
+
+		var err error
+
+		switch key {
+		case "name":
+			m.Name, err = asString(key, v)
+		case "sources":
+			m.Sources, err = asStrings(key, v)
+		default:
+			err = diag.Errorf(v.loc, "unknown key %s in [package], want name or sources", key)
+		}
+
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// settings returns the settings in the keys of a table, in the order of the
+// flags they stand for.
+func settings(keys map[string]value) ([]Setting, error) {
+	var (
+		list []Setting
+		errs []error
+	)
+
+	for _, key := range slices.Sorted(maps.Keys(keys)) {
+		v := keys[key]
+
+		flag, ok := settingFlags[key]
+		if !ok {
+			errs = append(errs, diag.Errorf(v.loc, "unknown setting %s", key))
+			continue
+		}
+
+		var val string
+
+		switch x := v.val.(type) {
+		case string:
+			val = x
+		case bool:
+			val = strconv.FormatBool(x)
+		case int:
+			val = strconv.Itoa(x)
+		case []any:
+			// A list, like the libraries, is a comma-separated flag.
+			strs, err := asStrings(key, v)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			val = strings.Join(strs, ",")
+		}
+
+		list = append(list, Setting{Flag: flag, Value: val, Loc: v.loc})
+	}
+
+	slices.SortFunc(list, func(a, b Setting) int { return strings.Compare(a.Flag, b.Flag) })
+
+	return list, errors.Join(errs...)
+}
+
+func asString(key string, v value) (string, error) {
+	s, ok := v.val.(string)
+	if !ok {
+		return "", diag.Errorf(v.loc, "%s must be a string", key)
+	}
+
+	return s, nil
+}
+
+func asStrings(key string, v value) ([]string, error) {
+	list, ok := v.val.([]any)
+	if !ok {
+		return nil, diag.Errorf(v.loc, "%s must be an array of strings", key)
+	}
+
+	strs := make([]string, len(list))
+
+	for i, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, diag.Errorf(v.loc, "%s must be an array of strings", key)
+		}
+
+		strs[i] = s
+	}
+
+	return strs, nil
+}
+
+// Settings returns the settings to build with the profile: the ones of the
+// profile if it's debug or release, those of [build], and those of its
+// table, where a later setting of a flag overrides an earlier one.
+func (m *Manifest) Settings(profile string) ([]Setting, error) {
+	builtin, ok := profiles[profile]
+	table, hasTable := m.profiles[profile]
+
+	if !ok && !hasTable {
+		return nil, fmt.Errorf("unknown profile %s", profile)
+	}
+
+	return slices.Concat(builtin, m.build, table), nil
+}
+
+// SourcePaths returns the paths of the sources of the package.
+func (m *Manifest) SourcePaths() []string {
+	if len(m.Sources) == 0 {
+		return []string{m.Dir}
+	}
+
+	paths := make([]string, len(m.Sources))
+
+	for i, src := range m.Sources {
+		paths[i] = filepath.Join(m.Dir, filepath.FromSlash(src))
+	}
+
+	return paths
+}
@@ -0,0 +1,129 @@
+package manifest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	src := `# The example project.
+[package]
+name = "hello"
+sources = [
+    "src",   # the code
+    'lib/util.in',
+]
+
+[build]
+target = "linux/arm64"
+link = ["m", "pthread"]
+inline = 1_0
+
+[profile.release]
+opt-level = 2
+strict = true
+
+[profile.bench]
+debug = false
+opt-level = "s"
+opt = ["constfold", "dce"]
+`
+
+	m, err := Parse(filepath.Join("proj", Filename), src)
+	require.NoError(t, err)
+	require.Equal(t, "proj", m.Dir)
+	require.Equal(t, "hello", m.Name)
+	require.Equal(t, []string{filepath.Join("proj", "src"), filepath.Join("proj", "lib", "util.in")}, m.SourcePaths())
+
+	tt := []struct {
+		profile  string
+		expected []string
+	}{
+		{profile: "debug", expected: []string{"g=true", "O=0", "inline=10", "link=m,pthread", "target=linux/arm64"}},
+		{profile: "release", expected: []string{"O=2", "inline=10", "link=m,pthread", "target=linux/arm64", "O=2", "strict=true"}},
+		{profile: "bench", expected: []string{"inline=10", "link=m,pthread", "target=linux/arm64", "O=s", "g=false", "opt=constfold,dce"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.profile, func(t *testing.T) {
+			t.Parallel()
+
+			settings, err := m.Settings(tc.profile)
+			require.NoError(t, err)
+
+			var actual []string
+			for _, s := range settings {
+				actual = append(actual, s.Flag+"="+s.Value)
+			}
+
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+
+	_, err = m.Settings("fast")
+	require.EqualError(t, err, "unknown profile fast")
+}
+
+func TestParse_Errors(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{
+			name:     "key outside a table",
+			src:      "name = \"x\"\n",
+			expected: "cubit.toml:1:1: key name must be in a table, like [package] or [build]",
+		},
+		{
+			name:     "unknown setting",
+			src:      "[build]\ntarget = \"wasm32\"\nspeed = 11\n",
+			expected: "cubit.toml:3:1: unknown setting speed",
+		},
+		{
+			name:     "wrong type",
+			src:      "[package]\nname = 1\n",
+			expected: "cubit.toml:2:1: name must be a string",
+		},
+		{
+			name:     "unknown table",
+			src:      "[dependencies]\n",
+			expected: "cubit.toml: unknown table [dependencies], want package, build or profile.<name>",
+		},
+		{
+			name:     "table defined twice",
+			src:      "[build]\n[build]\n",
+			expected: "cubit.toml:2:1: table [build] is defined twice",
+		},
+		{
+			name:     "unterminated string",
+			src:      "[package]\nname = \"hello\n",
+			expected: "cubit.toml:2:8: unterminated string",
+		},
+		{
+			name:     "missing value",
+			src:      "[package]\nname =\n",
+			expected: "cubit.toml:2:7: expected a value, got '\\n'",
+		},
+		{
+			name:     "two values",
+			src:      "[build]\nstrict = true false\n",
+			expected: "cubit.toml:2:15: expected the end of the line, got 'f'",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Parse(Filename, tc.src)
+			require.EqualError(t, err, tc.expected)
+		})
+	}
+}
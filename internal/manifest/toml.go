@@ -0,0 +1,311 @@
+package manifest
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// The manifest is read with a subset of TOML that's all a manifest needs:
+// tables, with dotted names, of keys with strings, integers, booleans and
+// arrays of them. Inline tables, dotted keys, floats, dates and multi-line
+// strings aren't supported.
+
+// value is a value of a key, and where it is.
+type value struct {
+	val any // string, int, bool or []any
+	loc lexer.Location
+}
+
+// document is a parsed manifest: the keys of every table, by the name of the
+// table. The keys before the first table are in the table "".
+type document map[string]map[string]value
+
+// tomlParser parses a document from its source, a byte at a time.
+type tomlParser struct {
+	filename  string
+	src       string
+	pos       int
+	line, col int
+}
+
+// parseTOML parses src, the contents of filename.
+func parseTOML(filename, src string) (document, error) {
+	p := &tomlParser{filename: filename, src: src, line: 1, col: 1}
+	doc := document{"": {}}
+	table := ""
+
+	for {
+		p.skipSpace(true)
+
+		if p.pos >= len(p.src) {
+			return doc, nil
+		}
+
+		loc := p.loc()
+
+		if p.peek() == '[' {
+			p.next()
+			p.skipSpace(false)
+
+			name, err := p.tableName()
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := doc[name]; ok {
+				return nil, diag.Errorf(loc, "table [%s] is defined twice", name)
+			}
+
+			table = name
+			doc[table] = map[string]value{}
+		} else {
+			key, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+
+			p.skipSpace(false)
+
+			if !p.accept('=') {
+				return nil, diag.Errorf(p.loc(), "expected = after key %s", key)
+			}
+
+			p.skipSpace(false)
+
+			val, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := doc[table][key]; ok {
+				return nil, diag.Errorf(loc, "key %s is defined twice", key)
+			}
+
+			doc[table][key] = value{val: val, loc: loc}
+		}
+
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *tomlParser) loc() lexer.Location {
+	return lexer.Location{Filename: p.filename, Line: p.line, Column: p.col}
+}
+
+func (p *tomlParser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+
+	return p.src[p.pos]
+}
+
+func (p *tomlParser) next() byte {
+	c := p.peek()
+	p.pos++
+
+	if c == '\n' {
+		p.line, p.col = p.line+1, 1
+	} else {
+		p.col++
+	}
+
+	return c
+}
+
+func (p *tomlParser) accept(c byte) bool {
+	if p.pos < len(p.src) && p.peek() == c {
+		p.next()
+		return true
+	}
+
+	return false
+}
+
+// skipSpace skips spaces and comments, and with newlines also line breaks.
+func (p *tomlParser) skipSpace(newlines bool) {
+	for p.pos < len(p.src) {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.next()
+		case c == '\n' && newlines:
+			p.next()
+		case c == '#':
+			for p.pos < len(p.src) && p.peek() != '\n' {
+				p.next()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endOfLine expects the end of a line, after a table header or a key.
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace(false)
+
+	if p.pos < len(p.src) && !p.accept('\n') {
+		return diag.Errorf(p.loc(), "expected the end of the line, got %q", p.peek())
+	}
+
+	return nil
+}
+
+func isBareKey(c byte) bool {
+	return c == '_' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// key parses a bare key.
+func (p *tomlParser) key() (string, error) {
+	start := p.pos
+
+	for p.pos < len(p.src) && isBareKey(p.peek()) {
+		p.next()
+	}
+
+	if start == p.pos {
+		return "", diag.Errorf(p.loc(), "expected a key, got %q", p.peek())
+	}
+
+	return p.src[start:p.pos], nil
+}
+
+// tableName parses the dotted name of a table header, and its closing
+// bracket.
+func (p *tomlParser) tableName() (string, error) {
+	var parts []string
+
+	for {
+		key, err := p.key()
+		if err != nil {
+			return "", err
+		}
+
+		parts = append(parts, key)
+
+		p.skipSpace(false)
+
+		if p.accept(']') {
+			return strings.Join(parts, "."), nil
+		}
+
+		if !p.accept('.') {
+			return "", diag.Errorf(p.loc(), "expected . or ] in table name, got %q", p.peek())
+		}
+
+		p.skipSpace(false)
+	}
+}
+
+// value parses a string, integer, boolean or array.
+func (p *tomlParser) value() (any, error) {
+	loc := p.loc()
+
+	switch c := p.peek(); {
+	case c == '"':
+		return p.basicString()
+	case c == '\'':
+		p.next()
+
+		start := p.pos
+
+		for p.pos < len(p.src) && p.peek() != '\'' && p.peek() != '\n' {
+			p.next()
+		}
+
+		if !p.accept('\'') {
+			return nil, diag.Errorf(loc, "unterminated string")
+		}
+
+		return p.src[start : p.pos-1], nil
+	case c == '[':
+		return p.array()
+	case c == '+' || c == '-' || c >= '0' && c <= '9' || isBareKey(c):
+		start := p.pos
+
+		for p.pos < len(p.src) && (isBareKey(p.peek()) || p.peek() == '+') {
+			p.next()
+		}
+
+		word := p.src[start:p.pos]
+
+		switch word {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+
+		n, err := strconv.ParseInt(strings.ReplaceAll(word, "_", ""), 10, 0)
+		if err != nil {
+			return nil, diag.Errorf(loc, "invalid value %s", word)
+		}
+
+		return int(n), nil
+	default:
+		return nil, diag.Errorf(loc, "expected a value, got %q", c)
+	}
+}
+
+// basicString parses a string in double quotes, with the escapes of Go, which
+// has the ones of TOML.
+func (p *tomlParser) basicString() (string, error) {
+	loc := p.loc()
+	start := p.pos
+
+	p.next()
+
+	for p.pos < len(p.src) && p.peek() != '"' && p.peek() != '\n' {
+		if p.next() == '\\' && p.pos < len(p.src) {
+			p.next()
+		}
+	}
+
+	if !p.accept('"') {
+		return "", diag.Errorf(loc, "unterminated string")
+	}
+
+	s, err := strconv.Unquote(p.src[start:p.pos])
+	if err != nil {
+		return "", diag.Errorf(loc, "invalid string %s", p.src[start:p.pos])
+	}
+
+	return s, nil
+}
+
+// array parses an array, which may span lines and end with a comma.
+func (p *tomlParser) array() ([]any, error) {
+	p.next()
+
+	list := []any{}
+
+	for {
+		p.skipSpace(true)
+
+		if p.accept(']') {
+			return list, nil
+		}
+
+		val, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, val)
+
+		p.skipSpace(true)
+
+		if p.accept(']') {
+			return list, nil
+		}
+
+		if !p.accept(',') {
+			return nil, diag.Errorf(p.loc(), "expected , or ] in array, got %q", p.peek())
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Package parallel runs the work on the functions of a program, like lowering
+// and generating code, on a pool of goroutines. The work items write their
+// results to their own slots, and errors are reported in the order of the
+// items, so the output doesn't depend on the schedule.
+package parallel
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// errFailed marks an item that didn't run, as an item it waited for failed.
+var errFailed = errors.New("an item it waits for failed")
+
+// Jobs returns the number of items to work on at once for a setting of jobs:
+// jobs itself, or GOMAXPROCS if it's 0 or less.
+func Jobs(jobs int) int {
+	if jobs <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+
+	return jobs
+}
+
+// Do calls fn for the items 0 to n-1, with up to Jobs(jobs) calls at once.
+// Item i starts after the items after(i) returns have finished, which must
+// come before i; after may be nil if the items are independent. With one job,
+// the items run in order and Do stops at the first error. Otherwise all items
+// run, except the ones that wait for an item that failed, and Do returns the
+// error of the first item that failed.
+func Do(n, jobs int, after func(i int) []int, fn func(i int) error) error {
+	jobs = Jobs(jobs)
+
+	if jobs == 1 || n < 2 {
+		for i := range n {
+			if err := fn(i); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	var (
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, jobs)
+		done = make([]chan struct{}, n)
+		errs = make([]error, n)
+	)
+
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	for i := range n {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer close(done[i])
+
+			// The dependencies are waited for before taking a job, so the
+			// items that hold the jobs never wait for the ones that don't.
+			if after != nil {
+				for _, dep := range after(i) {
+					<-done[dep]
+
+					if errs[dep] != nil {
+						errs[i] = errFailed
+					}
+				}
+
+				if errs[i] != nil {
+					return
+				}
+			}
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			errs[i] = fn(i)
+		}()
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil && err != errFailed {
+			return err
+		}
+	}
+
+	return nil
+}
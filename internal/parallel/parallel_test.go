@@ -0,0 +1,97 @@
+package parallel
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDo(t *testing.T) {
+	t.Parallel()
+
+	for _, jobs := range []int{1, 4} {
+		t.Run(fmt.Sprintf("jobs=%d", jobs), func(t *testing.T) {
+			t.Parallel()
+
+			t.Run("independent", func(t *testing.T) {
+				t.Parallel()
+
+				squares := make([]int, 100)
+
+				require.NoError(t, Do(len(squares), jobs, nil, func(i int) error {
+					squares[i] = i * i
+					return nil
+				}))
+
+				for i, sq := range squares {
+					require.Equal(t, i*i, sq)
+				}
+			})
+
+			t.Run("after", func(t *testing.T) {
+				t.Parallel()
+
+				// Every item sums the item before it, so it must see its
+				// result.
+				sums := make([]int, 50)
+
+				require.NoError(t, Do(len(sums), jobs, func(i int) []int {
+					if i == 0 {
+						return nil
+					}
+
+					return []int{i - 1}
+				}, func(i int) error {
+					if i > 0 {
+						sums[i] = sums[i-1]
+					}
+
+					sums[i] += i
+
+					return nil
+				}))
+
+				require.Equal(t, 49*50/2, sums[49])
+			})
+
+			t.Run("errors", func(t *testing.T) {
+				t.Parallel()
+
+				var (
+					mu  sync.Mutex
+					ran []int
+				)
+
+				err := Do(10, jobs, func(i int) []int {
+					if i == 9 {
+						return []int{5}
+					}
+
+					return nil
+				}, func(i int) error {
+					mu.Lock()
+					ran = append(ran, i)
+					mu.Unlock()
+
+					if i == 5 || i == 7 {
+						return fmt.Errorf("item %d failed", i)
+					}
+
+					return nil
+				})
+
+				require.EqualError(t, err, "item 5 failed")
+				require.NotContains(t, ran, 9)
+			})
+		})
+	}
+}
+
+func TestJobs(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, 3, Jobs(3))
+	require.Greater(t, Jobs(0), 0)
+}
@@ -110,7 +110,7 @@ func (p *Parser) parsePrimary(optional bool) (ast.Expression, error) {
 			return nil, nil
 		}
 
-		start.Location.Errorf("expected start of expression, got %s", start.StringVal)
+		p.errorf(start.Location, "expected start of expression, got %s", start.StringVal)
 
 		// TODO: error recovery
 		return nil, nil
@@ -132,7 +132,7 @@ func (p *Parser) parsePrimary(optional bool) (ast.Expression, error) {
 		case lexer.KeywordFalse:
 			expr = ast.NewBoolLiteral(false, start.Location)
 		default:
-			start.Location.Errorf("unexpected keyword %s in expression", start.Keyword)
+			p.errorf(start.Location, "unexpected keyword %s in expression", start.Keyword)
 
 			// TODO: error recovery
 			return nil, fmt.Errorf("unexpected keyword %s at %s",
@@ -146,7 +146,7 @@ func (p *Parser) parsePrimary(optional bool) (ast.Expression, error) {
 		} else if start.Keyword == lexer.KeywordFalse {
 			expr = ast.NewBoolLiteral(false, start.Location)
 		} else {
-			start.Location.Errorf("unexpected boolean keyword %s in expression", start.Keyword)
+			p.errorf(start.Location, "unexpected boolean keyword %s in expression", start.Keyword)
 
 			// error recovery:
 			expr = ast.NewBoolLiteral(false, start.Location)
@@ -232,7 +232,7 @@ func (p *Parser) parsePrimary(optional bool) (ast.Expression, error) {
 		case lexer.KeywordAny:
 			elemType = ast.NewType(ast.TypeAny, typeTok.Location)
 		default:
-			typeTok.Location.Errorf("unexpected type %s in array literal", typeTok.StringVal)
+			p.errorf(typeTok.Location, "unexpected type %s in array literal", typeTok.StringVal)
 
 			// error recovery:
 			elemType = ast.NewType(ast.TypeUnknown, typeTok.Location)
@@ -262,7 +262,7 @@ func (p *Parser) parsePrimary(optional bool) (ast.Expression, error) {
 			// Only allow scalar literals for now
 			lit, ok := elemExpr.(*ast.Literal)
 			if !ok {
-				tok.Location.Errorf("array literal elements must be literals")
+				p.errorf(tok.Location, "array literal elements must be literals")
 
 				// error recovery: ignore element
 			} else {
@@ -283,8 +283,8 @@ func (p *Parser) parsePrimary(optional bool) (ast.Expression, error) {
 
 		// Build the array type
 		sizeLit, ok := sizeExpr.(*ast.Literal)
-		if !ok || sizeLit.Type.Kind != ast.TypeInt {
-			start.Location.Errorf("array size must be an integer literal")
+		if !ok || sizeLit.Type().Kind != ast.TypeInt {
+			p.errorf(start.Location, "array size must be an integer literal")
 
 			// error recovery
 			sizeLit = ast.NewIntLiteral(0, start.Location)
@@ -293,7 +293,7 @@ func (p *Parser) parsePrimary(optional bool) (ast.Expression, error) {
 		arrType := ast.NewArrayType(elemType, ast.NewSizeLiteral(sizeLit.IntValue), start.Location)
 		expr = ast.NewArrayLiteral(arrType, elements, start.Location)
 	default:
-		start.Location.Errorf("unexpected token %s in expression", start.StringVal)
+		p.errorf(start.Location, "unexpected token %s in expression", start.StringVal)
 	}
 
 	return expr, nil
@@ -21,6 +21,55 @@ func (p *Parser) parseReturn(first lexer.Token) (ast.Instruction, error) {
 	return ast.NewReturn(first.Location, p.currentRetType, expr), nil
 }
 
+// parseAssert parses a compile-time assertion, `#assert(cond)` or
+// `#assert(cond, "message")`. The '#' has been consumed already.
+func (p *Parser) parseAssert(hash lexer.Token) (ast.Instruction, error) {
+	name, err := p.expectType(lexer.TypeIdent)
+	if err != nil {
+		return nil, err // EOF
+	}
+
+	if name.Identifier != "assert" {
+		p.errorf(name.Location, "unknown directive #%s", name.StringVal)
+	}
+
+	if _, err := p.expectType(lexer.TypeLparen); err != nil {
+		return nil, err // EOF
+	}
+
+	cond, err := p.parseExpression(false)
+	if err != nil {
+		return nil, err
+	}
+
+	if cond == nil {
+		// error recovery:
+		cond = ast.NewBoolLiteral(true, hash.Location)
+	}
+
+	var message string
+
+	tok, err := p.expectType(lexer.TypeRparen, lexer.TypeComma)
+	if err != nil {
+		return nil, err // EOF
+	}
+
+	if tok.Type == lexer.TypeComma {
+		msg, err := p.expectType(lexer.TypeString)
+		if err != nil {
+			return nil, err // EOF
+		}
+
+		message = msg.StringVal
+
+		if _, err := p.expectType(lexer.TypeRparen); err != nil {
+			return nil, err // EOF
+		}
+	}
+
+	return ast.NewAssert(cond, message, hash.Location), nil
+}
+
 func (p *Parser) parseDeclare(ident lexer.Token) ([]ast.Instruction, error) {
 	// <indent> ':'
 	// have been consumed already.
@@ -173,11 +222,12 @@ func (p *Parser) parseIf(first lexer.Token) (ast.Instruction, error) {
 		return nil, err
 	}
 
-	if _, err := p.expectType(lexer.TypeRbrace); err != nil {
+	rbrace, err := p.expectType(lexer.TypeRbrace)
+	if err != nil {
 		return nil, err // EOF
 	}
 
-	thenBody := ast.NewBody(thenInstrs, lbrace.Location)
+	thenBody := ast.NewBody(thenInstrs, lbrace.Location).WithEnd(rbrace.Location)
 
 	// Check for else or else if
 	var elseBody *ast.Body
@@ -213,13 +263,14 @@ func (p *Parser) parseIf(first lexer.Token) (ast.Instruction, error) {
 				return nil, err
 			}
 
-			if _, err := p.expectType(lexer.TypeRbrace); err != nil {
+			rbrace, err := p.expectType(lexer.TypeRbrace)
+			if err != nil {
 				return nil, err // EOF
 			}
 
-			elseBody = ast.NewBody(elseInstrs, lbrace.Location)
+			elseBody = ast.NewBody(elseInstrs, lbrace.Location).WithEnd(rbrace.Location)
 		} else {
-			afterElse.Location.Errorf("expected 'if' or '{' after 'else', got %s", afterElse.StringVal)
+			p.errorf(afterElse.Location, "expected 'if' or '{' after 'else', got %s", afterElse.StringVal)
 
 			// error recovery:
 			elseBody = nil
@@ -241,8 +292,8 @@ func (p *Parser) parseFor(first lexer.Token) (ast.Instruction, error) {
 	)
 
 	// Try to parse an initializer (for now only assignment or set)
-	start, err := p.expectType(lexer.TypeIdent)
-	if err == nil {
+	start, err := p.peekType(lexer.TypeIdent)
+	if err == nil && start.Type == lexer.TypeIdent {
 		next, err := p.peekType(lexer.TypeColon, lexer.TypeAssign)
 		if err != nil {
 			// If we didn't parse an initializer, roll back the index and try
@@ -318,10 +369,11 @@ func (p *Parser) parseFor(first lexer.Token) (ast.Instruction, error) {
 		return nil, err
 	}
 
-	if _, err := p.expectType(lexer.TypeRbrace); err != nil {
+	rbrace, err := p.expectType(lexer.TypeRbrace)
+	if err != nil {
 		return nil, err // EOF
 	}
 
 	return ast.NewFor(first.Location, initInstrs, cond, postInstrs,
-		ast.NewBody(bodyInstrs, lbrace.Location)), nil
+		ast.NewBody(bodyInstrs, lbrace.Location).WithEnd(rbrace.Location)), nil
 }
@@ -69,12 +69,12 @@ func (p *Parser) parseLValue() (ast.LValue, error) {
 			return ast.NewDeref(expr, next.Location), nil
 		}
 
-		first.Location.Errorf("expected dereference after parenthesized expression")
+		p.errorf(first.Location, "expected dereference after parenthesized expression")
 
 		// error recovery:
 		return ast.NewDeref(expr, next.Location), nil
 	default:
-		first.Location.Errorf("expected lvalue, got %s", first.StringVal)
+		p.errorf(first.Location, "expected lvalue, got %s", first.StringVal)
 
 		// TODO: error recovery
 		return nil, fmt.Errorf("invalid lvalue start: %s", first.StringVal)
@@ -1,12 +1,14 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"maps"
 	"strings"
 
 	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
 	"github.com/corani/cubit/internal/lexer"
 )
 
@@ -15,12 +17,21 @@ type Parser struct {
 	index          int
 	unit           *ast.CompilationUnit
 	attributes     ast.Attributes
+	doc            *ast.CommentGroup
 	localID        int
 	currentRetType *ast.Type
-	errors         []error
+	diags          *diag.Bag
 }
 
+// New creates a parser that reports syntax errors to a bag of its own, see
+// Diagnostics.
 func New(tok []lexer.Token) *Parser {
+	return NewWithDiagnostics(tok, diag.NewBag(diag.Config{}))
+}
+
+// NewWithDiagnostics creates a parser that reports syntax errors to diags, e.g.
+// the bag shared by all passes of a compilation.
+func NewWithDiagnostics(tok []lexer.Token, diags *diag.Bag) *Parser {
 	var location lexer.Location
 
 	// Initial location, we'll update this to the location of 'package' later.
@@ -37,33 +48,55 @@ func New(tok []lexer.Token) *Parser {
 		attributes:     ast.Attributes{},
 		localID:        0,
 		currentRetType: nil,
+		diags:          diags,
 	}
 }
 
+// Diagnostics returns the bag the parser reports syntax errors to.
+func (p *Parser) Diagnostics() *diag.Bag {
+	return p.diags
+}
+
+// errorf reports a syntax error at loc. Parsing continues, so more errors can
+// be reported.
+func (p *Parser) errorf(loc lexer.Location, format string, args ...any) {
+	p.diags.Errorf(loc, format, args...)
+}
+
 func (p *Parser) Parse() (*ast.CompilationUnit, error) {
 	for {
 		start, err := p.expectType(lexer.TypeKeyword, lexer.TypeIdent, lexer.TypeAt)
 		if err != nil {
+			// Attributes must be followed by the declaration they're on.
+			if len(p.attributes) > 0 {
+				return p.unit, p.unexpectedEOF(err)
+			}
+
 			return p.unit, err // EOF
 		}
 
+		// The documentation precedes the attributes, if any.
+		if p.doc == nil {
+			p.doc = docComment(start)
+		}
+
 		switch start.Type {
 		case lexer.TypeAt:
 			if err := p.parseAttributes(start); err != nil {
-				return p.unit, err // EOF
+				return p.unit, p.unexpectedEOF(err)
 			}
 		case lexer.TypeKeyword:
 			switch start.Keyword {
 			case lexer.KeywordPackage:
 				if err := p.parsePackage(start); err != nil {
-					return p.unit, err // EOF
+					return p.unit, p.unexpectedEOF(err)
 				}
 			case lexer.KeywordImport:
 				if err := p.parseImport(start); err != nil {
-					return p.unit, err // EOF
+					return p.unit, p.unexpectedEOF(err)
 				}
 			default:
-				start.Location.Errorf("expected keyword 'package', got %s",
+				p.errorf(start.Location, "expected keyword 'package', got %s",
 					start.StringVal)
 
 				// TODO: error recovery
@@ -72,37 +105,56 @@ func (p *Parser) Parse() (*ast.CompilationUnit, error) {
 			}
 		case lexer.TypeIdent:
 			if p.unit.Ident == "" {
-				start.Location.Errorf("package must be defined before any other declarations")
+				p.errorf(start.Location, "package must be defined before any other declarations")
 
 				// error recovery: just continue parsing
 			}
 
 			if _, err := p.expectType(lexer.TypeColon); err != nil {
-				return p.unit, err // EOF
+				return p.unit, p.unexpectedEOF(err)
 			}
 
 			// TODO(daniel): parse optional type.
 
 			if _, err := p.expectType(lexer.TypeColon); err != nil {
-				return p.unit, err // EOF
+				return p.unit, p.unexpectedEOF(err)
 			}
 
 			if _, err := p.expectKeyword(lexer.KeywordFunc); err != nil {
-				return p.unit, err // EOF
+				return p.unit, p.unexpectedEOF(err)
 			}
 
 			if err := p.parseFunc(start); err != nil {
-				return p.unit, err
+				return p.unit, p.unexpectedEOF(err)
 			}
 		}
 	}
 }
 
+// unexpectedEOF reports the end of the file in the middle of a declaration, at
+// the last token before the semicolons the lexer inserts at the end of the
+// lines, e.g. of a function without its closing brace or of a string without
+// its closing quote. It returns err, which is io.EOF in that case.
+func (p *Parser) unexpectedEOF(err error) error {
+	if !errors.Is(err, io.EOF) || len(p.tok) == 0 {
+		return err
+	}
+
+	last := len(p.tok) - 1
+	for last > 0 && p.tok[last].Type == lexer.TypeSemicolon {
+		last--
+	}
+
+	p.errorf(p.tok[last].Location, "unexpected end of file")
+
+	return err
+}
+
 func (p *Parser) parseImport(start lexer.Token) error {
 	_ = start
 
 	if p.unit.Ident == "" {
-		start.Location.Errorf("package must be defined before imports")
+		p.errorf(start.Location, "package must be defined before imports")
 
 		// error recovery: just continue parsing
 	}
@@ -116,8 +168,8 @@ func (p *Parser) parseImport(start lexer.Token) error {
 
 	// parse optional "as as"
 	as, err := p.peekKeyword(lexer.KeywordAs)
-	if err != nil {
-		return err // EOF
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
 	}
 
 	if as.Keyword == lexer.KeywordAs {
@@ -130,7 +182,7 @@ func (p *Parser) parseImport(start lexer.Token) error {
 	}
 
 	if orig, ok := p.unit.Imports[alias]; ok {
-		start.Location.Errorf("import %s already defined as %s, cannot redefine",
+		p.errorf(start.Location, "import %s already defined as %s, cannot redefine",
 			alias, orig)
 		p.unit.Loc.Infof("previous definition was here")
 
@@ -139,8 +191,11 @@ func (p *Parser) parseImport(start lexer.Token) error {
 		p.unit.Imports[alias] = pkgName.StringVal
 	}
 
-	if _, err := p.expectType(lexer.TypeSemicolon); err != nil {
-		return err // EOF
+	p.doc = nil
+
+	// The import is complete, so the file may end here.
+	if _, err := p.expectType(lexer.TypeSemicolon); err != nil && !errors.Is(err, io.EOF) {
+		return err
 	}
 
 	return nil
@@ -150,7 +205,7 @@ func (p *Parser) parseImport(start lexer.Token) error {
 // It returns io.EOF when there are no more tokens.
 func (p *Parser) parsePackage(start lexer.Token) error {
 	if p.unit.Ident != "" {
-		start.Location.Errorf("package already defined, cannot redefine")
+		p.errorf(start.Location, "package already defined, cannot redefine")
 		p.unit.Loc.Infof("previous definition was here")
 
 		// error recovery: just ignore the new package definition.
@@ -166,19 +221,46 @@ func (p *Parser) parsePackage(start lexer.Token) error {
 
 		// Store any attributes collected before the package in the unit's Attributes
 		p.unit.Attributes = maps.Clone(p.attributes)
+		p.unit.Doc = p.doc
 		p.unit.Ident = pkgName.StringVal
 		p.unit.Loc = start.Location
 	}
 
-	if _, err := p.expectType(lexer.TypeSemicolon); err != nil {
-		return err // EOF
+	// The package clause is complete, so the file may end here.
+	if _, err := p.expectType(lexer.TypeSemicolon); err != nil && !errors.Is(err, io.EOF) {
+		return err
 	}
 
 	clear(p.attributes)
+	p.doc = nil
 
 	return nil
 }
 
+// docComment returns the comments that directly precede tok, on consecutive
+// lines ending just above it, or nil if there are none.
+func docComment(tok lexer.Token) *ast.CommentGroup {
+	var list []*ast.Comment
+
+	line := tok.Location.Line
+
+	for i := len(tok.Comments) - 1; i >= 0; i-- {
+		c := tok.Comments[i]
+		if c.Location.Line != line-1 {
+			break
+		}
+
+		line = c.Location.Line
+		list = append([]*ast.Comment{{Text: c.Text, Loc: c.Location}}, list...)
+	}
+
+	if len(list) == 0 {
+		return nil
+	}
+
+	return ast.NewCommentGroup(list...)
+}
+
 // parseAttributes parses attributes in the form `@(...)`.
 // It returns io.EOF when there are no more tokens.
 func (p *Parser) parseAttributes(atToken lexer.Token) error {
@@ -190,7 +272,7 @@ func (p *Parser) parseAttributes(atToken lexer.Token) error {
 	}
 
 	if lparen.Type != lexer.TypeLparen {
-		lparen.Location.Errorf("expected ( after @, got %s", lparen.StringVal)
+		p.errorf(lparen.Location, "expected ( after @, got %s", lparen.StringVal)
 
 		// TODO: error recovery
 	}
@@ -207,7 +289,7 @@ func (p *Parser) parseAttributes(atToken lexer.Token) error {
 
 		key, ok := ast.ParseAttrKey(tok.StringVal)
 		if !ok {
-			tok.Location.Errorf("invalid attribute key: %s", tok.StringVal)
+			p.errorf(tok.Location, "invalid attribute key: %s", tok.StringVal)
 		}
 
 		value := ast.AttrValue(ast.AttrBool(true))
@@ -247,7 +329,7 @@ func (p *Parser) parseAttributes(atToken lexer.Token) error {
 	}
 
 	// parse optional semicolon
-	if _, err := p.expectType(lexer.TypeSemicolon); err != nil {
+	if _, err := p.peekType(lexer.TypeSemicolon); err != nil {
 		return err // EOF
 	}
 
@@ -260,7 +342,9 @@ func (p *Parser) parseFunc(name lexer.Token) error {
 	}
 
 	def := ast.NewFuncDef(name.StringVal, p.attributes, name.Location)
+	def.Doc = p.doc
 	clear(p.attributes)
+	p.doc = nil
 
 	for {
 		param, err := p.parseFuncParam()
@@ -285,8 +369,11 @@ func (p *Parser) parseFunc(name lexer.Token) error {
 	}
 
 	retType, err := p.parseFuncReturnType()
-	if err != nil {
-		name.Location.Errorf("error parsing return type: %v", err)
+	if errors.Is(err, io.EOF) {
+		// A void function without a body, e.g. an extern one, may end the file.
+		retType = ast.NewType(ast.TypeVoid, name.Location)
+	} else if err != nil {
+		p.errorf(name.Location, "error parsing return type: %v", err)
 
 		// error recovery:
 		retType = ast.NewType(ast.TypeVoid, name.Location)
@@ -295,53 +382,46 @@ func (p *Parser) parseFunc(name lexer.Token) error {
 	p.currentRetType = retType
 	def.ReturnType = retType
 
-	if def.Attributes.Has(ast.AttrKeyExtern) || def.Attributes.Has(ast.AttrKeyBuiltin) {
-		// If the function is extern or builtin, we don't parse the body.
-	} else {
-		lbrace, err := p.expectType(lexer.TypeLbrace)
-		if err != nil {
-			return err // EOF
-		}
+	// The body is optional here: extern and builtin functions must not have one
+	// and all others must, which ast.ValidateAttributes reports.
+	lbrace, err := p.peekType(lexer.TypeLbrace)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
 
+	if err == nil && lbrace.Type == lexer.TypeLbrace {
 		instructions, err := p.parseBlock(lbrace)
 		if err != nil {
 			return err
 		}
 
-		// Add implicit return if needed
-		addRet := false
-		if len(instructions) == 0 {
-			addRet = true
-		} else {
-			_, hasRet := instructions[len(instructions)-1].(*ast.Return)
-			addRet = !hasRet
+		// Add an implicit return to void functions. Whether other functions
+		// return on every path depends on the functions they call, so the type
+		// checker reports those. Noreturn functions must not return at all.
+		addRet := retType.Kind == ast.TypeVoid && !def.Attributes.Has(ast.AttrKeyNoReturn)
+		if len(instructions) > 0 {
+			if _, hasRet := instructions[len(instructions)-1].(*ast.Return); hasRet {
+				addRet = false
+			}
 		}
 
 		if addRet {
-			switch retType.Kind {
-			case ast.TypeVoid:
-				// If the return type is void, we can just add an empty return.
-				instructions = append(instructions, ast.NewReturn(lbrace.Location, retType))
-			default:
-				name.Location.Errorf("function %s has return type %s but no return statement",
-					def.Ident, retType.String())
-
-				// error recovery:
-				instructions = append(instructions, ast.NewReturn(lbrace.Location, retType))
-			}
+			instructions = append(instructions, ast.NewReturn(lbrace.Location, retType))
 		}
 
-		if _, err := p.expectType(lexer.TypeRbrace); err != nil {
+		rbrace, err := p.expectType(lexer.TypeRbrace)
+		if err != nil {
 			return err // EOF
 		}
 
-		def.Body = ast.NewBody(instructions, lbrace.Location)
+		def.Body = ast.NewBody(instructions, lbrace.Location).WithEnd(rbrace.Location)
 	}
 
 	p.unit.Funcs = append(p.unit.Funcs, def)
 
-	if _, err := p.peekType(lexer.TypeSemicolon); err != nil {
-		return err // EOF
+	// The function is complete, so the file may end here.
+	if _, err := p.peekType(lexer.TypeSemicolon); err != nil && !errors.Is(err, io.EOF) {
+		return err
 	}
 
 	return nil
@@ -466,6 +546,13 @@ func (p *Parser) parseBlock(start lexer.Token) ([]ast.Instruction, error) {
 		case lexer.TypeSemicolon:
 			// Empty statement, just continue
 			continue
+		case lexer.TypeHash:
+			inst, err := p.parseAssert(first)
+			if err != nil {
+				return nil, err
+			}
+
+			instructions = append(instructions, inst)
 		case lexer.TypeKeyword:
 			switch first.Keyword {
 			case lexer.KeywordReturn:
@@ -551,9 +638,13 @@ func (p *Parser) parseBlock(start lexer.Token) ([]ast.Instruction, error) {
 				}
 			}
 
-			first.Location.Errorf("expected statement, got %s", first.StringVal)
+			p.errorf(first.Location, "expected statement, got %s", first.StringVal)
 
 			// TODO: error recovery
+			return nil, fmt.Errorf("unexpected statement at %s", first.Location)
+		default:
+			p.errorf(first.Location, "expected statement, got %s", first.StringVal)
+
 			return nil, fmt.Errorf("unexpected statement at %s", first.Location)
 		}
 	}
@@ -580,12 +671,12 @@ func (p *Parser) parseType() *ast.Type {
 		if tok, err := p.peekType(lexer.TypeLBracket); err == nil && tok.Type == lexer.TypeLBracket {
 			sizeTok, err := p.expectType(lexer.TypeNumber)
 			if err != nil {
-				tok.Location.Errorf("expected array size after '['")
+				p.errorf(tok.Location, "expected array size after '['")
 				sizeTok.NumberVal = 0
 			}
 
 			if _, err := p.expectType(lexer.TypeRBracket); err != nil {
-				tok.Location.Errorf("expected ']' after array size")
+				p.errorf(tok.Location, "expected ']' after array size")
 			}
 
 			loc := tok.Location // TODO(daniel): I think this is not needed?
@@ -614,7 +705,7 @@ func (p *Parser) parseType() *ast.Type {
 func (p *Parser) parseBaseType() *ast.Type {
 	tok, err := p.expectType(lexer.TypeKeyword)
 	if err != nil {
-		tok.Location.Errorf("expected type keyword, got %s", tok.Type)
+		p.errorf(tok.Location, "expected type keyword, got %s", tok.Type)
 
 		// error recover:
 		tok = lexer.Token{
@@ -636,7 +727,7 @@ func (p *Parser) parseBaseType() *ast.Type {
 	case lexer.KeywordAny:
 		return ast.NewType(ast.TypeAny, tok.Location)
 	default:
-		tok.Location.Errorf("unexpected type keyword %s", tok.Keyword)
+		p.errorf(tok.Location, "unexpected type keyword %s", tok.Keyword)
 
 		// error recovery:
 		return ast.NewType(ast.TypeVoid, tok.Location)
@@ -681,7 +772,7 @@ func (p *Parser) expectKeyword(kws ...lexer.Keyword) (lexer.Token, error) {
 	}
 
 	if token.Type != lexer.TypeKeyword {
-		token.Location.Errorf("expected keyword, got %s", token.Type)
+		p.errorf(token.Location, "expected keyword, got %s", token.Type)
 
 		// error recovery:
 		return lexer.Token{
@@ -703,7 +794,7 @@ func (p *Parser) expectKeyword(kws ...lexer.Keyword) (lexer.Token, error) {
 		}
 	}
 
-	token.Location.Errorf("expected %s, got %s", strings.Join(kwnames, " or "), token.Keyword)
+	p.errorf(token.Location, "expected %s, got %s", strings.Join(kwnames, " or "), token.Keyword)
 
 	// error recovery:
 	return lexer.Token{
@@ -755,7 +846,7 @@ func (p *Parser) expectType(tts ...lexer.TokenType) (lexer.Token, error) {
 		}
 	}
 
-	token.Location.Errorf("expected %s, got %s", strings.Join(ttnames, " or "), token.Type)
+	p.errorf(token.Location, "expected %s, got %s", strings.Join(ttnames, " or "), token.Type)
 
 	// error recover:
 	p.index--
@@ -0,0 +1,57 @@
+package parser
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// TestParser_Golden parses every example and compares the AST dump with the
+// golden file in testdata. Run with -update to regenerate the golden files.
+func TestParser_Golden(t *testing.T) {
+	t.Parallel()
+
+	files, err := filepath.Glob("../../examples/*.in")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".in")
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := os.Open(file)
+			require.NoError(t, err)
+
+			defer f.Close()
+
+			scanner, err := lexer.NewScanner(filepath.Base(file), f)
+			require.NoError(t, err)
+
+			tokens, err := lexer.NewLexer(scanner).Tokens()
+			require.NoError(t, err)
+
+			unit, _ := New(tokens).Parse()
+			actual := ast.SDump(unit)
+
+			golden := filepath.Join("testdata", name+".golden")
+
+			if *update {
+				require.NoError(t, os.WriteFile(golden, []byte(actual), 0o644))
+			}
+
+			expected, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			require.Equal(t, string(expected), actual)
+		})
+	}
+}
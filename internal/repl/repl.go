@@ -0,0 +1,458 @@
+// Package repl evaluates declarations, statements and expressions one at a
+// time, for `cubit repl`.
+//
+// The entries of a session are kept as source: the declarations at the top
+// level, and the statements and expressions in the body of a function that
+// runs in the IR interpreter. Every entry runs the session again from the
+// start, so the variables of the earlier entries are in scope, and holds back
+// the output the session already printed. The entries must do the same on
+// every run for that, which they do, as a program can't read input. An entry
+// that doesn't compile, or fails to run, isn't kept.
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/ir/interp"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/corani/cubit/internal/loader"
+	"github.com/corani/cubit/internal/parser"
+	"github.com/corani/cubit/internal/typecheck"
+)
+
+// The files the locations of the session are in: the source the REPL wraps
+// around the entries, and the entries, each with lines of its own.
+const (
+	sessionFile = "<repl>"
+	inputFile   = "<input>"
+)
+
+// sessionFunc is the function the statements and expressions run in.
+const sessionFunc = "__repl"
+
+// syntaxError is the errors of an entry that doesn't parse.
+type syntaxError struct {
+	err error
+}
+
+func (e *syntaxError) Error() string {
+	return e.err.Error()
+}
+
+func (e *syntaxError) Unwrap() error {
+	return e.err
+}
+
+// kind is what an entry is.
+type kind int
+
+const (
+	kindDecl kind = iota // a declaration, at the top level
+	kindStmt             // a statement, in the session function
+	kindExpr             // an expression, whose value is printed
+)
+
+// entry is an entry of a session.
+type entry struct {
+	kind kind
+	src  string
+}
+
+// REPL is a session.
+type REPL struct {
+	entries []entry
+	printed int // the length of the output of the session
+}
+
+// New returns an empty session.
+func New() *REPL {
+	return &REPL{}
+}
+
+// Reset forgets the entries of the session.
+func (r *REPL) Reset() {
+	r.entries, r.printed = nil, 0
+}
+
+// Eval evaluates input, an entry, and writes its output, and for an
+// expression its value, to w. It returns the errors if the entry doesn't
+// compile, or why it failed to run.
+//
+// An entry that starts like a declaration, with attributes or `name ::`, is
+// one. Anything else is an expression if it compiles as one, or else a
+// statement.
+func (r *REPL) Eval(w io.Writer, input string) error {
+	src := strings.TrimSpace(input)
+	if src == "" {
+		return nil
+	}
+
+	if isDecl(src) {
+		e := entry{kind: kindDecl, src: src}
+
+		if _, err := r.check(e, nil); err != nil {
+			return err
+		}
+
+		r.entries = append(r.entries, e)
+
+		return nil
+	}
+
+	expr := entry{kind: kindExpr, src: src}
+
+	unit, exprErr := r.check(expr, nil)
+	if exprErr == nil {
+		return r.evalExpr(w, expr, unit)
+	}
+
+	stmt := entry{kind: kindStmt, src: src}
+
+	unit, err := r.check(stmt, nil)
+	if err != nil {
+		// The errors of the expression are the ones to report if it parsed,
+		// like a reference to an unknown variable, which isn't a statement.
+		if serr := (*syntaxError)(nil); !errors.As(exprErr, &serr) {
+			return exprErr
+		}
+
+		return err
+	}
+
+	return r.run(w, stmt, unit, nil)
+}
+
+// evalExpr runs the session with expr, and prints its value. The session
+// function returns it if it's a value that can be shown.
+func (r *REPL) evalExpr(w io.Writer, expr entry, unit *ast.CompilationUnit) error {
+	ty := r.valueType(unit)
+
+	switch ty.Kind {
+	case ast.TypeInt, ast.TypeBool, ast.TypeString, ast.TypePointer:
+		unit, err := r.check(expr, ty)
+		if err != nil {
+			return err
+		}
+
+		return r.run(w, expr, unit, ty)
+	default:
+		if err := r.run(w, expr, unit, nil); err != nil {
+			return err
+		}
+
+		_, err := fmt.Fprintf(w, "<%s>\n", ty)
+
+		return err
+	}
+}
+
+// run lowers unit, the session with e, runs the session function in the
+// interpreter, and keeps e if it succeeds. With ty, it prints the value the
+// function returns, of that type.
+func (r *REPL) run(w io.Writer, e entry, unit *ast.CompilationUnit, ty *ast.Type) error {
+	low, err := ir.Lower(unit)
+	if err != nil {
+		return err
+	}
+
+	in, err := interp.New(low)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+
+	ret, err := in.WithStdout(&out).Call(sessionFunc)
+
+	// The output of the earlier entries was printed when they ran.
+	if _, err := w.Write(out.Bytes()[min(r.printed, out.Len()):]); err != nil {
+		return err
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to run: %w", err)
+	}
+
+	if ty != nil {
+		val, err := show(in, ty, ret)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintln(w, val); err != nil {
+			return err
+		}
+	}
+
+	r.entries = append(r.entries, e)
+	r.printed = out.Len()
+
+	return nil
+}
+
+// show returns how val, of type ty, is printed.
+func show(in *interp.Interp, ty *ast.Type, val int64) (string, error) {
+	switch ty.Kind {
+	case ast.TypeBool:
+		return strconv.FormatBool(val != 0), nil
+	case ast.TypeString:
+		s, err := in.String(val)
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.Quote(s), nil
+	case ast.TypePointer:
+		return fmt.Sprintf("%#x", val), nil
+	default:
+		return strconv.FormatInt(val, 10), nil
+	}
+}
+
+// value returns the name of the variable the expression of the entry at index
+// i is assigned to.
+func value(i int) string {
+	return fmt.Sprintf("__value%d", i)
+}
+
+// valueType returns the type of the expression of the next entry, in unit.
+func (r *REPL) valueType(unit *ast.CompilationUnit) *ast.Type {
+	name := value(len(r.entries))
+
+	for _, fd := range unit.Funcs {
+		if fd.Ident != sessionFunc {
+			continue
+		}
+
+		for _, instr := range fd.Body.Instructions {
+			if decl, ok := instr.(*ast.Declare); ok && decl.Ident == name {
+				return decl.Type
+			}
+		}
+	}
+
+	return ast.NewType(ast.TypeUnknown, lexer.Location{})
+}
+
+// check parses and type checks the session with next as its last entry.
+// With ret, the session function returns the value of next, an expression,
+// as that type.
+func (r *REPL) check(next entry, ret *ast.Type) (*ast.CompilationUnit, error) {
+	tokens, err := r.tokens(append(r.entries[:len(r.entries):len(r.entries)], next), ret)
+	if err != nil {
+		return nil, &syntaxError{err}
+	}
+
+	diags := diag.NewBag(diag.Config{})
+
+	unit, err := parser.NewWithDiagnostics(tokens, diags).Parse()
+	// The diagnostics say more than the error, if there are any.
+	if diags.HasErrors() {
+		return nil, &syntaxError{errors.Join(diags.Filter(diag.SeverityError)...)}
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, &syntaxError{err}
+	}
+
+	if err := loader.NewLoader(diags).ResolveImports(unit); err != nil {
+		return nil, err
+	}
+
+	// Only the errors are reported; the warnings, like for the variables
+	// that aren't used yet, would be noise.
+	if err := typecheck.NewChecker(typecheck.Options{Diagnostics: diags}).Check(unit); err != nil {
+		return nil, errors.Join(diags.Filter(diag.SeverityError)...)
+	}
+
+	return unit, nil
+}
+
+// tokens returns the tokens of the session with entries. Each entry is lexed
+// on its own, so the locations in it are from its start.
+func (r *REPL) tokens(entries []entry, ret *ast.Type) ([]lexer.Token, error) {
+	var tokens []lexer.Token
+
+	add := func(filename, src string) error {
+		toks, err := lex(filename, src)
+		tokens = append(tokens, toks...)
+
+		return err
+	}
+
+	if err := add(sessionFile, "package main\nimport \"core\"\n"); err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.kind != kindDecl {
+			continue
+		}
+
+		if err := add(inputFile, e.src+"\n"); err != nil {
+			return nil, err
+		}
+	}
+
+	head := sessionFunc + " :: func() {\n"
+	if ret != nil {
+		head = fmt.Sprintf("%s :: func() -> %s {\n", sessionFunc, ret)
+	}
+
+	if err := add(sessionFile, head); err != nil {
+		return nil, err
+	}
+
+	for i, e := range entries {
+		var err error
+
+		// A statement ends with a semicolon of its own, so one that's cut
+		// short is reported in the input, and an expression is
+		// parenthesized, so nothing more than one parses.
+		switch e.kind {
+		case kindDecl:
+			continue
+		case kindStmt:
+			err = add(inputFile, e.src+";\n")
+		case kindExpr:
+			err = errors.Join(
+				add(sessionFile, value(i)+" := ("),
+				add(inputFile, e.src),
+				add(sessionFile, ")\n"))
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ret != nil {
+		if err := add(sessionFile, fmt.Sprintf("return %s\n", value(len(entries)-1))); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := add(sessionFile, "}\n"); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// lex returns the tokens of src.
+func lex(filename, src string) ([]lexer.Token, error) {
+	scanner, err := lexer.NewScanner(filename, strings.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+
+	return lexer.NewLexer(scanner).Tokens()
+}
+
+// isDecl reports whether src starts like a declaration: with attributes, or
+// with `name ::`.
+func isDecl(src string) bool {
+	tokens, err := lex(inputFile, src)
+	if err != nil || len(tokens) == 0 {
+		return false
+	}
+
+	if tokens[0].Type == lexer.TypeAt {
+		return true
+	}
+
+	return len(tokens) >= 3 && tokens[0].Type == lexer.TypeIdent &&
+		tokens[1].Type == lexer.TypeColon && tokens[2].Type == lexer.TypeColon
+}
+
+// Complete reports whether src is a complete entry: it closes all the braces,
+// parentheses and brackets it opens. Source that doesn't lex is, so its
+// errors are reported.
+func Complete(src string) bool {
+	tokens, err := lex(inputFile, src)
+	if err != nil {
+		return true
+	}
+
+	depth := 0
+
+	for _, tok := range tokens {
+		switch tok.Type {
+		case lexer.TypeLbrace, lexer.TypeLparen, lexer.TypeLBracket:
+			depth++
+		case lexer.TypeRbrace, lexer.TypeRparen, lexer.TypeRBracket:
+			depth--
+		}
+	}
+
+	return depth <= 0
+}
+
+// help is printed for :help.
+const help = `Enter declarations, statements and expressions, one at a time; the value of
+an expression is printed. An entry continues on the next line until its
+braces and parentheses are closed.
+
+  :help   show this help
+  :reset  forget the entries so far
+  :quit   leave, like the end of the input
+`
+
+// Run reads entries from in and evaluates them, and writes the prompts, the
+// output and the errors to out, until in ends or :quit.
+func (r *REPL) Run(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+
+	var input strings.Builder
+
+	for {
+		prompt := "> "
+		if input.Len() > 0 {
+			prompt = ". "
+		}
+
+		fmt.Fprint(out, prompt)
+
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+
+			return scanner.Err()
+		}
+
+		line := scanner.Text()
+
+		if input.Len() == 0 {
+			switch strings.TrimSpace(line) {
+			case ":help":
+				fmt.Fprint(out, help)
+				continue
+			case ":reset":
+				r.Reset()
+				continue
+			case ":quit":
+				return nil
+			}
+		}
+
+		input.WriteString(line + "\n")
+
+		if !Complete(input.String()) {
+			continue
+		}
+
+		if err := r.Eval(out, input.String()); err != nil {
+			fmt.Fprintln(out, err)
+		}
+
+		input.Reset()
+	}
+}
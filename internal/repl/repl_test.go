@@ -0,0 +1,112 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestREPL_Run(t *testing.T) {
+	// The session imports core, which is found from the root of the module.
+	// Changing the directory rules out running in parallel.
+	t.Chdir("../..")
+
+	tt := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "expressions",
+			input: `1 + 2 * 3
+"hello"
+3 > 4
+`,
+			expected: "> 7\n> \"hello\"\n> false\n> \n",
+		},
+		{
+			name: "persistent scope",
+			input: `x := 40
+printf("x is %d\n", x)
+x = x + 2
+x
+`,
+			expected: "> > x is 40\n> > 42\n> \n",
+		},
+		{
+			name: "declarations and continued lines",
+			input: `square :: func(n: int) -> int {
+    return n * n
+}
+for i := 1; i <= 2; i = i + 1 {
+    printf("%d\n", square(i))
+}
+square(3)
+`,
+			expected: "> . . > . . 1\n4\n> 9\n> \n",
+		},
+		{
+			name: "errors aren't kept",
+			input: `y
+y := 1 +
+y := "one"
+y
+`,
+			expected: "> <input>:1:1: undefined variable 'y'\n" +
+				"> <input>:1:9: expected start of expression, got ;\n" +
+				"> > \"one\"\n> \n",
+		},
+		{
+			name: "run time errors",
+			input: `zero := 0
+printf("before\n")
+1 / zero
+printf("after\n")
+`,
+			expected: "> > before\n> failed to run: <input>:1:1: division by zero\n> after\n> \n",
+		},
+		{
+			name: "commands",
+			input: `x := 1
+:reset
+x
+:quit
+x
+`,
+			expected: "> > > <input>:1:1: undefined variable 'x'\n> ",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+
+			require.NoError(t, New().Run(strings.NewReader(tc.input), &out))
+			require.Equal(t, tc.expected, out.String())
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		input    string
+		expected bool
+	}{
+		{input: "x := 1", expected: true},
+		{input: "f :: func() {", expected: false},
+		{input: "f :: func() {\n}", expected: true},
+		{input: "printf(\"{\",\n", expected: false},
+		{input: "}", expected: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.input, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.expected, Complete(tc.input))
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Package target describes the machines the compiler generates code for: the
+// size of a pointer, the byte order, and the toolchain that builds the code.
+package target
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Target is the machine the code is generated for. The zero Target is the
+// host.
+type Target struct {
+	// Triple is the target triple it was parsed from, e.g. "aarch64-linux-gnu",
+	// or empty for the host.
+	Triple string
+	// Arch is the architecture, as in GOARCH, or "wasm32".
+	Arch string
+	// OS is the operating system, as in GOOS, or "wasi".
+	OS string
+}
+
+// archs maps the architecture of a target triple to GOARCH.
+var archs = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"riscv64": "riscv64",
+	"wasm32":  "wasm32",
+}
+
+// oses maps the system of a target triple to GOOS.
+var oses = map[string]string{
+	"linux":   "linux",
+	"android": "linux",
+	"darwin":  "darwin",
+	"macos":   "darwin",
+	"freebsd": "freebsd",
+	"wasi":    "wasi",
+	"unknown": "wasi",
+}
+
+// triples are the triples of the targets named as <os>/<arch>, which are the
+// names of their cross compilers.
+var triples = map[string]string{
+	"linux/amd64":   "x86_64-linux-gnu",
+	"linux/arm64":   "aarch64-linux-gnu",
+	"linux/riscv64": "riscv64-linux-gnu",
+	"freebsd/amd64": "x86_64-unknown-freebsd",
+	"freebsd/arm64": "aarch64-unknown-freebsd",
+	"darwin/amd64":  "x86_64-apple-darwin",
+	"darwin/arm64":  "arm64-apple-darwin",
+	"wasi/wasm32":   "wasm32-wasi",
+}
+
+// Host returns the target of the machine the compiler runs on.
+func Host() Target {
+	goos := runtime.GOOS
+	if goos == "android" {
+		goos = "linux" // For Termux support on Android
+	}
+
+	return Target{Arch: runtime.GOARCH, OS: goos}
+}
+
+// Parse parses a target. It's either a name of the form <os>/<arch>, like
+// "linux/arm64", or a target triple of the form <arch>-<vendor>-<os>[-<abi>],
+// where the vendor may be left out, like "x86_64-linux-gnu" or
+// "aarch64-unknown-linux-gnu". "wasm32" is short for "wasm32-wasi". An empty
+// target is the host.
+func Parse(name string) (Target, error) {
+	switch name {
+	case "":
+		return Host(), nil
+	case "wasm32":
+		name = "wasi/wasm32"
+	}
+
+	if goos, goarch, ok := strings.Cut(name, "/"); ok {
+		triple, ok := triples[name]
+		if !ok {
+			return Target{}, fmt.Errorf("unsupported target %q", name)
+		}
+
+		return Target{Triple: triple, Arch: goarch, OS: goos}, nil
+	}
+
+	parts := strings.Split(name, "-")
+
+	arch, ok := archs[parts[0]]
+	if !ok {
+		return Target{}, fmt.Errorf("unsupported architecture %q in target %q", parts[0], name)
+	}
+
+	for _, part := range parts[1:] {
+		goos, ok := oses[part]
+		if !ok || goos == "wasi" && arch != "wasm32" {
+			continue
+		}
+
+		return Target{Triple: name, Arch: arch, OS: goos}, nil
+	}
+
+	return Target{}, fmt.Errorf("unsupported operating system in target %q", name)
+}
+
+// resolve returns t, or the host for the zero Target.
+func (t Target) resolve() Target {
+	if t.Arch == "" {
+		return Host()
+	}
+
+	return t
+}
+
+// IsHost reports whether t is the machine the compiler runs on.
+func (t Target) IsHost() bool {
+	host := Host()
+
+	return t.Arch == "" || t.Arch == host.Arch && t.OS == host.OS
+}
+
+// PtrSize returns the size of a pointer in bytes, which is also the size of a
+// string.
+func (t Target) PtrSize() int {
+	if t.resolve().Arch == "wasm32" {
+		return 4
+	}
+
+	return 8
+}
+
+// ByteOrder returns the order of the bytes of values in memory. All supported
+// targets are little-endian.
+func (t Target) ByteOrder() binary.ByteOrder {
+	return binary.LittleEndian
+}
+
+// String returns the triple of t, or its GOOS/GOARCH if it wasn't parsed from
+// one.
+func (t Target) String() string {
+	if t.Triple != "" {
+		return t.Triple
+	}
+
+	t = t.resolve()
+
+	return t.OS + "/" + t.Arch
+}
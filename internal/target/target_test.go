@@ -0,0 +1,62 @@
+package target
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		triple   string
+		expected Target
+		err      bool
+	}{
+		{triple: "", expected: Host()},
+		{triple: "x86_64-linux-gnu", expected: Target{Triple: "x86_64-linux-gnu", Arch: "amd64", OS: "linux"}},
+		{triple: "aarch64-unknown-linux-gnu", expected: Target{Triple: "aarch64-unknown-linux-gnu", Arch: "arm64", OS: "linux"}},
+		{triple: "riscv64-linux-gnu", expected: Target{Triple: "riscv64-linux-gnu", Arch: "riscv64", OS: "linux"}},
+		{triple: "arm64-apple-darwin", expected: Target{Triple: "arm64-apple-darwin", Arch: "arm64", OS: "darwin"}},
+		{triple: "wasm32-unknown-unknown", expected: Target{Triple: "wasm32-unknown-unknown", Arch: "wasm32", OS: "wasi"}},
+		{triple: "wasm32", expected: Target{Triple: "wasm32-wasi", Arch: "wasm32", OS: "wasi"}},
+		{triple: "linux/arm64", expected: Target{Triple: "aarch64-linux-gnu", Arch: "arm64", OS: "linux"}},
+		{triple: "linux/wasm32", err: true},
+		{triple: "x86_64-unknown-wasi", err: true},
+		{triple: "mips-linux-gnu", err: true},
+		{triple: "x86_64-unknown-plan9", err: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.triple, func(t *testing.T) {
+			t.Parallel()
+
+			target, err := Parse(tc.triple)
+			if tc.err {
+				require.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, target)
+		})
+	}
+}
+
+func TestTarget_IsHost(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, Host().IsHost())
+	require.True(t, Target{}.IsHost())
+	require.False(t, Target{Arch: "mips", OS: "linux"}.IsHost())
+}
+
+func TestTarget_PtrSize(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, 8, Target{Arch: "amd64", OS: "linux"}.PtrSize())
+	require.Equal(t, 8, Target{}.PtrSize())
+	require.Equal(t, 4, Target{Arch: "wasm32", OS: "wasi"}.PtrSize())
+}
@@ -0,0 +1,105 @@
+package testrun
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/corani/cubit/internal/loader"
+)
+
+// Packages returns the packages that patterns stand for. A source file or a
+// directory is a package; a directory followed by /... stands for it and the
+// directories under it that have tests. Directories whose names start with .
+// or _ are skipped, like the ones of version control.
+func Packages(patterns ...string) ([]string, error) {
+	var pkgs []string
+
+	for _, pattern := range patterns {
+		root, ok := strings.CutSuffix(filepath.ToSlash(pattern), "/...")
+		if !ok {
+			pkgs = append(pkgs, pattern)
+			continue
+		}
+
+		root = filepath.FromSlash(root)
+
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !d.IsDir() {
+				return nil
+			}
+
+			if name := d.Name(); path != root && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+
+			if hasTests(path) {
+				pkgs = append(pkgs, path)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pkgs, nil
+}
+
+// hasTests reports whether a source file in dir has a test: a function with
+// the test attribute. The files are only lexed, so a directory that isn't a
+// package, like one with a program per file, is skipped without errors.
+func hasTests(dir string) bool {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+loader.SourceExt))
+	if err != nil {
+		return false
+	}
+
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		scanner, err := lexer.NewScanner(file, bytes.NewReader(src))
+		if err != nil {
+			continue
+		}
+
+		// The tokens up to an error are enough to look for the attribute.
+		tokens, _ := lexer.NewLexer(scanner).Tokens()
+		if hasTestAttr(tokens) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasTestAttr reports whether tokens have an attribute list with test, like
+// `@(test)` or `@(inline, test)`.
+func hasTestAttr(tokens []lexer.Token) bool {
+	inAttrs := false
+
+	for i, tok := range tokens {
+		switch {
+		case tok.Type == lexer.TypeAt && i+1 < len(tokens) && tokens[i+1].Type == lexer.TypeLparen:
+			inAttrs = true
+		case tok.Type == lexer.TypeRparen:
+			inAttrs = false
+		case inAttrs && tok.Type == lexer.TypeIdent && tok.Identifier == string(ast.AttrKeyTest):
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,301 @@
+// Package testrun runs the tests of a package, for `cubit test`: its
+// functions with the @(test) attribute, which take no arguments and return
+// nothing, a bool or an int. A test fails if it returns false or an int other
+// than 0, exits with a status other than 0, or fails to run, like when it
+// accesses memory out of bounds in the interpreter.
+//
+// The tests run in the IR interpreter, each with memory of its own, or in a
+// binary that has a harness in place of main. The harness runs the test whose
+// index is in the CUBIT_TEST environment variable, and exits with 0 if it
+// passes, so the binary runs once per test, and a test that crashes doesn't
+// take the others with it.
+package testrun
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/ast/astbuild"
+	"github.com/corani/cubit/internal/codegen"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/ir/interp"
+	"github.com/corani/cubit/internal/typecheck"
+)
+
+// Mode is how the tests run.
+type Mode string
+
+const (
+	ModeJIT    Mode = "jit"    // in the IR interpreter
+	ModeBinary Mode = "binary" // in a binary built with the harness
+)
+
+// EnvVar is the environment variable the harness reads the index of the test
+// to run from.
+const EnvVar = "CUBIT_TEST"
+
+// The functions of the harness, named so they don't clash with the ones of
+// the package.
+const (
+	harnessGetenv = "__cubit_getenv"
+	harnessAtoi   = "__cubit_atoi"
+	harnessIndex  = "__cubit_test"
+)
+
+// Options configure Run.
+type Options struct {
+	// Mode is how the tests run, ModeJIT if it's empty.
+	Mode Mode
+	// Match selects the tests to run by name; nil runs all of them.
+	Match *regexp.Regexp
+	// Diagnostics receives the errors and warnings of the package.
+	Diagnostics *diag.Bag
+	// Codegen are the options the binary is built with, for ModeBinary.
+	Codegen codegen.Options
+	// Dir is the directory the binary is built in, for ModeBinary.
+	Dir string
+}
+
+// Result is the outcome of a test.
+type Result struct {
+	Name string
+	// Failure is why the test failed, or empty if it passed.
+	Failure string
+	// Output is what the test printed.
+	Output  string
+	Elapsed time.Duration
+}
+
+// Passed reports whether the test passed.
+func (r Result) Passed() bool {
+	return r.Failure == ""
+}
+
+// Tests returns the tests of unit, in the order they're declared.
+func Tests(unit *ast.CompilationUnit) []*ast.FuncDef {
+	var tests []*ast.FuncDef
+
+	for _, fd := range unit.Funcs {
+		if fd.Attributes.Has(ast.AttrKeyTest) {
+			tests = append(tests, fd)
+		}
+	}
+
+	return tests
+}
+
+// Run type checks unit, a package as it's loaded, and runs the tests that
+// match. The errors of the package are in the diagnostics, and make Run fail
+// before any test runs.
+func Run(unit *ast.CompilationUnit, opts Options) ([]Result, error) {
+	var tests []*ast.FuncDef
+
+	for _, fd := range Tests(unit) {
+		if opts.Match == nil || opts.Match.MatchString(fd.Ident) {
+			tests = append(tests, fd)
+		}
+	}
+
+	if opts.Mode == ModeBinary {
+		if err := addHarness(unit, tests); err != nil {
+			return nil, err
+		}
+	}
+
+	diags := opts.Diagnostics
+	if diags == nil {
+		diags = diag.NewBag(diag.Config{})
+	}
+
+	if err := typecheck.NewChecker(typecheck.Options{Diagnostics: diags}).Check(unit); err != nil {
+		return nil, err
+	}
+
+	if len(tests) == 0 {
+		return nil, nil
+	}
+
+	// The functions that are only called by tests aren't dead, so the unit
+	// is left as it's lowered.
+	low, err := ir.LowerWithOptions(unit, ir.LowerOptions{Target: opts.Codegen.Target})
+	if err != nil {
+		return nil, fmt.Errorf("failed to lower IR: %w", err)
+	}
+
+	switch opts.Mode {
+	case ModeJIT, "":
+		return runJIT(low, tests)
+	case ModeBinary:
+		return runBinary(low, tests, opts)
+	default:
+		return nil, fmt.Errorf("unknown mode %q, want %s or %s", opts.Mode, ModeJIT, ModeBinary)
+	}
+}
+
+// runJIT runs each test in an interpreter of its own, so it starts with the
+// data of the package as it's declared.
+func runJIT(low *ir.CompilationUnit, tests []*ast.FuncDef) ([]Result, error) {
+	results := make([]Result, len(tests))
+
+	for i, fd := range tests {
+		in, err := interp.New(low)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load program: %w", err)
+		}
+
+		var out bytes.Buffer
+
+		start := time.Now()
+		ret, err := in.WithStdout(&out).Call(ir.Ident(fd.Ident))
+
+		results[i] = Result{
+			Name:    fd.Ident,
+			Failure: failure(fd.ReturnType, ret, err),
+			Output:  out.String(),
+			Elapsed: time.Since(start),
+		}
+	}
+
+	return results, nil
+}
+
+// failure returns why a test with the result type ty failed, given what it
+// returned, or empty if it passed.
+func failure(ty *ast.Type, ret int64, err error) string {
+	var exit *interp.ExitError
+
+	switch {
+	case errors.As(err, &exit):
+		if exit.Code == 0 {
+			return ""
+		}
+
+		return exit.Error()
+	case err != nil:
+		return err.Error()
+	case ty == nil:
+		return ""
+	case ty.Kind == ast.TypeBool && ret == 0:
+		return "returned false"
+	case ty.Kind == ast.TypeInt && ret != 0:
+		return fmt.Sprintf("returned %d", ret)
+	default:
+		return ""
+	}
+}
+
+// addHarness replaces the main function of unit with the harness, which runs
+// one of the tests:
+//
+//	@(export)
+//	main :: func() -> int {
+//	    __cubit_test := __cubit_atoi(__cubit_getenv("CUBIT_TEST"))
+//	    if __cubit_test == 0 {
+//	        if test_sum() != 0 { return 1 }
+//	        return 0
+//	    }
+//	    ...
+//	    return 2
+//	}
+func addHarness(unit *ast.CompilationUnit, tests []*ast.FuncDef) error {
+	body := []astbuild.Stmt{
+		astbuild.Define(harnessIndex, astbuild.Call(harnessAtoi, astbuild.Call(harnessGetenv, astbuild.Lit(EnvVar)))),
+	}
+
+	for i, fd := range tests {
+		var run []astbuild.Stmt
+
+		switch ty := fd.ReturnType; {
+		case ty == nil || ty.Kind == ast.TypeVoid:
+			run = append(run, astbuild.Do(astbuild.Call(fd.Ident)))
+		case ty.Kind == ast.TypeBool:
+			run = append(run, astbuild.If(astbuild.Bin(ast.BinOpEq, astbuild.Call(fd.Ident), astbuild.Lit(false)),
+				astbuild.Return(astbuild.Lit(1))))
+		default:
+			run = append(run, astbuild.If(astbuild.Bin(ast.BinOpNe, astbuild.Call(fd.Ident), astbuild.Lit(0)),
+				astbuild.Return(astbuild.Lit(1))))
+		}
+
+		run = append(run, astbuild.Return(astbuild.Lit(0)))
+		body = append(body, astbuild.If(astbuild.Bin(ast.BinOpEq, astbuild.Ref(harnessIndex), astbuild.Lit(i)), run...))
+	}
+
+	// An index that's out of range is a bug of the runner.
+	body = append(body, astbuild.Return(astbuild.Lit(2)))
+
+	harness, err := astbuild.Unit(unit.Ident).At(unit.Loc).Func(
+		astbuild.Func(harnessGetenv).Attr(ast.AttrKeyExtern).Attr(ast.AttrKeyLinkname, "getenv").
+			Param("name", astbuild.String()).Returns(astbuild.String()),
+		astbuild.Func(harnessAtoi).Attr(ast.AttrKeyExtern).Attr(ast.AttrKeyLinkname, "atoi").
+			Param("s", astbuild.String()).Returns(astbuild.Int()),
+		astbuild.Func("main").At(unit.Loc).Attr(ast.AttrKeyExport).Returns(astbuild.Int()).Body(body...),
+	).Build()
+	if err != nil {
+		return fmt.Errorf("failed to build the test harness: %w", err)
+	}
+
+	unit.Funcs = slices.DeleteFunc(unit.Funcs, func(fd *ast.FuncDef) bool {
+		return fd.Ident == "main" && fd.Body != nil
+	})
+	unit.Funcs = append(unit.Funcs, harness.Funcs...)
+
+	return nil
+}
+
+// runBinary builds the binary with the harness, and runs it once per test.
+func runBinary(low *ir.CompilationUnit, tests []*ast.FuncDef, opts Options) ([]Result, error) {
+	name := low.Package + "_test"
+	asmFile := filepath.Join(opts.Dir, name+opts.Codegen.Backend.Ext())
+	binFile := filepath.Join(opts.Dir, name)
+
+	if err := codegen.GenerateAssembly(low.Loc.Filename, low, asmFile, opts.Codegen); err != nil {
+		return nil, fmt.Errorf("failed to generate assembly: %w", err)
+	}
+
+	if err := codegen.Compile(asmFile, binFile, low.Libraries, opts.Codegen); err != nil {
+		return nil, fmt.Errorf("failed to compile assembly: %w", err)
+	}
+
+	// An absolute path, so the binary isn't looked up in $PATH.
+	binFile, err := filepath.Abs(binFile)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(tests))
+
+	for i, fd := range tests {
+		var out bytes.Buffer
+
+		cmd := exec.Command(binFile)
+		cmd.Env = append(os.Environ(), EnvVar+"="+strconv.Itoa(i))
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+
+		start := time.Now()
+		err := cmd.Run()
+
+		results[i] = Result{Name: fd.Ident, Output: out.String(), Elapsed: time.Since(start)}
+
+		var exitErr *exec.ExitError
+
+		switch {
+		case errors.As(err, &exitErr):
+			results[i].Failure = exitErr.String()
+		case err != nil:
+			return nil, fmt.Errorf("failed to run %s: %w", binFile, err)
+		}
+	}
+
+	return results, nil
+}
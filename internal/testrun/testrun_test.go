@@ -0,0 +1,163 @@
+package testrun
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+const testSrc = `package main
+
+@(extern)
+printf :: func(format: string, args: ..any)
+
+@(extern)
+exit :: func(code: int)
+
+sum :: func(a: int, b: int) -> int {
+    return a + b
+}
+
+@(export)
+main :: func() -> int {
+    return sum(1, 2)
+}
+
+@(test)
+test_sum :: func() -> bool {
+    printf("sum is %d\n", sum(2, 3))
+    return sum(2, 3) == 5
+}
+
+@(test)
+test_bool :: func() -> bool {
+    printf("sum is %d\n", sum(2, 2))
+    return sum(2, 2) == 5
+}
+
+@(test)
+test_int :: func() -> int {
+    return sum(2, -5)
+}
+
+@(test)
+test_exit :: func() {
+    exit(4)
+}
+
+@(test)
+test_void :: func() {
+}
+`
+
+func parse(t *testing.T, src string) *ast.CompilationUnit {
+	t.Helper()
+
+	return testutil.Parse(t, "test.in", src)
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		match    string
+		expected []Result
+	}{
+		{
+			name: "all",
+			expected: []Result{
+				{Name: "test_sum", Output: "sum is 5\n"},
+				{Name: "test_bool", Failure: "returned false", Output: "sum is 4\n"},
+				{Name: "test_int", Failure: "returned -3"},
+				{Name: "test_exit", Failure: "exit status 4"},
+				{Name: "test_void"},
+			},
+		},
+		{
+			name:     "match",
+			match:    "sum|void",
+			expected: []Result{{Name: "test_sum", Output: "sum is 5\n"}, {Name: "test_void"}},
+		},
+		{
+			name:  "no match",
+			match: "none",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := Options{Mode: ModeJIT}
+			if tc.match != "" {
+				opts.Match = regexp.MustCompile(tc.match)
+			}
+
+			results, err := Run(parse(t, testSrc), opts)
+			require.NoError(t, err)
+
+			for i := range results {
+				results[i].Elapsed = 0
+			}
+
+			require.Equal(t, tc.expected, results)
+		})
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+@(test)
+test_args :: func(x: int) -> bool {
+    return x == 0
+}
+`
+
+	diags := diag.NewBag(diag.Config{})
+
+	_, err := Run(parse(t, src), Options{Diagnostics: diags})
+	require.Error(t, err)
+	require.Equal(t, []error{
+		diag.Errorf(lexer.Location{Filename: "test.in", Line: 4, Column: 1},
+			"test function 'test_args' can't have parameters and must return nothing, bool or int"),
+	}, diags.Filter(diag.SeverityError))
+}
+
+func TestPackages(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"lib/lib.in":          "package lib\n\n@(test)\ntest_lib :: func() {\n}\n",
+		"lib/sub/sub.in":      "package sub\n\n@(inline, test)\ntest_sub :: func() {\n}\n",
+		"examples/example.in": "package main\n\n// @(test) in a comment\n@(export)\nmain :: func() -> int {\n    return 0\n}\n",
+		".git/test.in":        "package git\n\n@(test)\ntest_git :: func() {\n}\n",
+	}
+
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(src), 0o644))
+	}
+
+	pkgs, err := Packages(filepath.Join(dir, "..."), filepath.Join(dir, "examples"))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "lib"),
+		filepath.Join(dir, "lib", "sub"),
+		filepath.Join(dir, "examples"),
+	}, pkgs)
+}
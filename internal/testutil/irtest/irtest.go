@@ -0,0 +1,38 @@
+// Package irtest holds the helpers the tests of the packages that consume the
+// IR share. It's apart from testutil, as the tests of ir itself use testutil.
+package irtest
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ir"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/corani/cubit/internal/typecheck"
+	"github.com/stretchr/testify/require"
+)
+
+// printf is the package clause and the declaration of printf the programs of
+// LowerWithPrintf start with.
+const printf = "package main\n\n@(extern)\nprintf :: func(msg: string, args: ..any)\n"
+
+// Lower parses, type checks and lowers src, a complete program, and fails the
+// test if any of that fails.
+func Lower(t testing.TB, src string) *ir.CompilationUnit {
+	t.Helper()
+
+	unit := testutil.Parse(t, "test.in", src)
+	require.NoError(t, typecheck.Check(unit))
+
+	lowered, err := ir.Lower(unit)
+	require.NoError(t, err)
+
+	return lowered
+}
+
+// LowerWithPrintf lowers src as the rest of package main, after a declaration
+// of the extern printf.
+func LowerWithPrintf(t testing.TB, src string) *ir.CompilationUnit {
+	t.Helper()
+
+	return Lower(t, printf+src)
+}
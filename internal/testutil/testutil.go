@@ -0,0 +1,38 @@
+// Package testutil holds the helpers the tests of the compiler share.
+package testutil
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/corani/cubit/internal/parser"
+	"github.com/stretchr/testify/require"
+)
+
+// Parse parses src as the file filename, and fails the test if it has syntax
+// errors. The parser stops at the end of the file with io.EOF, so that's the
+// only error it accepts.
+func Parse(t testing.TB, filename, src string) *ast.CompilationUnit {
+	t.Helper()
+
+	scanner, err := lexer.NewScanner(filename, strings.NewReader(src))
+	require.NoError(t, err)
+
+	tokens, err := lexer.NewLexer(scanner).Tokens()
+	require.NoError(t, err)
+
+	p := parser.New(tokens)
+
+	unit, err := p.Parse()
+	if !errors.Is(err, io.EOF) {
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, p.Diagnostics().Err())
+
+	return unit
+}
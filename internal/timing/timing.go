@@ -0,0 +1,230 @@
+// Package timing records how long the phases of a compilation take, and how
+// much memory they allocate, for -time-report: lexing and parsing every file,
+// type checking, lowering, every pass on every function, and generating code.
+// The report is a table of the phases, or a trace of every one of them that
+// chrome://tracing and Perfetto show on a timeline.
+//
+// A nil *Recorder records nothing, so the phases can be timed whether there's
+// a report or not.
+package timing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// allocMetric is the number of bytes allocated so far, which is cheap to read,
+// unlike runtime.ReadMemStats, which stops the world.
+const allocMetric = "/gc/heap/allocs:bytes"
+
+// Event is a phase that ran.
+type Event struct {
+	// Phase is the name of the phase, e.g. parse or the name of a pass.
+	Phase string
+	// File is the file the phase ran on, or empty for a phase of the package.
+	File string
+	// Func is the function the phase ran on, or empty.
+	Func string
+	// Start is when the phase started, since the recorder was created.
+	Start time.Duration
+	// Elapsed is how long the phase took.
+	Elapsed time.Duration
+	// Alloc is about how many bytes the compiler allocated while the phase
+	// ran; the runtime counts them in batches, so a short phase may get none.
+	Alloc uint64
+}
+
+// Recorder records the phases of a compilation. It's safe to use from
+// several goroutines, though phases that run at once allocate for each other.
+type Recorder struct {
+	origin time.Time
+	alloc  uint64
+
+	mu     sync.Mutex
+	events []Event
+}
+
+// New returns a recorder that starts now.
+func New() *Recorder {
+	return &Recorder{origin: time.Now(), alloc: allocated()}
+}
+
+func allocated() uint64 {
+	sample := []metrics.Sample{{Name: allocMetric}}
+	metrics.Read(sample)
+
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+
+	return sample[0].Value.Uint64()
+}
+
+// Start starts timing phase, on file if it's a phase of a file, and returns
+// the function that stops it.
+func (r *Recorder) Start(phase, file string) func() {
+	return r.start(Event{Phase: phase, File: file})
+}
+
+// StartFunc starts timing phase on the function fn, and returns the function
+// that stops it. The table sums up the functions of a phase.
+func (r *Recorder) StartFunc(phase, fn string) func() {
+	return r.start(Event{Phase: phase, Func: fn})
+}
+
+func (r *Recorder) start(e Event) func() {
+	if r == nil {
+		return func() {}
+	}
+
+	start, alloc := time.Now(), allocated()
+
+	return func() {
+		e.Start, e.Elapsed = start.Sub(r.origin), time.Since(start)
+		e.Alloc = allocated() - alloc
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.events = append(r.events, e)
+	}
+}
+
+// Events returns the phases that ran, in the order they stopped.
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.events
+}
+
+// row is a line of the table: the phases of the same name on the same file.
+type row struct {
+	phase, file string
+	count       int
+	elapsed     time.Duration
+	alloc       uint64
+}
+
+// WriteTable writes a table of the phases in the order they first ran, with
+// how often they ran, how long they took in total and how much they
+// allocated, followed by the total since the recorder was created.
+func (r *Recorder) WriteTable(w io.Writer) error {
+	var rows []*row
+
+	index := make(map[[2]string]*row)
+
+	for _, e := range r.Events() {
+		key := [2]string{e.Phase, e.File}
+
+		rw, ok := index[key]
+		if !ok {
+			rw = &row{phase: e.Phase, file: e.File}
+			index[key] = rw
+			rows = append(rows, rw)
+		}
+
+		rw.count++
+		rw.elapsed += e.Elapsed
+		rw.alloc += e.Alloc
+	}
+
+	total := time.Since(r.origin)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "phase\tfile\tcount\ttime\t%\talloc\t")
+
+	for _, rw := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%.1f%%\t%s\t\n", rw.phase, displayName(rw.file), rw.count,
+			formatDuration(rw.elapsed), 100*rw.elapsed.Seconds()/total.Seconds(), formatBytes(rw.alloc))
+	}
+
+	fmt.Fprintf(tw, "total\t\t\t%s\t\t%s\t\n", formatDuration(total), formatBytes(allocated()-r.alloc))
+
+	return tw.Flush()
+}
+
+// displayName returns file relative to the working directory, if it's in it.
+func displayName(file string) string {
+	wd, err := os.Getwd()
+	if err != nil || !filepath.IsAbs(file) {
+		return file
+	}
+
+	rel, err := filepath.Rel(wd, file)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return file
+	}
+
+	return rel
+}
+
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.3fms", float64(d)/float64(time.Millisecond))
+}
+
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+// traceEvent is a complete event of the Trace Event Format, which
+// chrome://tracing reads.
+type traceEvent struct {
+	Name  string            `json:"name"`
+	Cat   string            `json:"cat"`
+	Phase string            `json:"ph"`
+	TS    float64           `json:"ts"`  // start, in microseconds
+	Dur   float64           `json:"dur"` // duration, in microseconds
+	PID   int               `json:"pid"`
+	TID   int               `json:"tid"`
+	Args  map[string]string `json:"args,omitempty"`
+}
+
+// WriteTrace writes every phase as an event of a trace for chrome://tracing,
+// named after the phase, and the file or function it ran on.
+func (r *Recorder) WriteTrace(w io.Writer) error {
+	events := []traceEvent{}
+
+	for _, e := range r.Events() {
+		te := traceEvent{
+			Name:  e.Phase,
+			Cat:   "compile",
+			Phase: "X",
+			TS:    float64(e.Start) / float64(time.Microsecond),
+			Dur:   float64(e.Elapsed) / float64(time.Microsecond),
+			PID:   1,
+			TID:   1,
+			Args:  map[string]string{"alloc": formatBytes(e.Alloc)},
+		}
+
+		switch {
+		case e.File != "":
+			te.Name += " " + displayName(e.File)
+			te.Args["file"] = e.File
+		case e.Func != "":
+			te.Name += " " + e.Func
+			te.Cat = "pass"
+			te.Args["func"] = e.Func
+		}
+
+		events = append(events, te)
+	}
+
+	return json.NewEncoder(w).Encode(map[string]any{"traceEvents": events, "displayTimeUnit": "ms"})
+}
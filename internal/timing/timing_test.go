@@ -0,0 +1,80 @@
+package timing
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+
+	rec := New()
+
+	for _, phase := range []struct{ phase, file string }{{"lex", "a.in"}, {"parse", "a.in"}, {"lex", "b.in"}} {
+		rec.Start(phase.phase, phase.file)()
+	}
+
+	rec.StartFunc("dce", "main")()
+	rec.StartFunc("dce", "fib")()
+
+	events := rec.Events()
+	require.Len(t, events, 5)
+	require.Equal(t, Event{Phase: "dce", Func: "fib"}, Event{Phase: events[4].Phase, Func: events[4].Func})
+
+	t.Run("table", func(t *testing.T) {
+		t.Parallel()
+
+		var sb strings.Builder
+		require.NoError(t, rec.WriteTable(&sb))
+
+		var rows [][]string
+		for line := range strings.Lines(sb.String()) {
+			rows = append(rows, strings.Fields(line))
+		}
+
+		require.Len(t, rows, 6)
+		require.Equal(t, []string{"phase", "file", "count", "time", "%", "alloc"}, rows[0])
+		require.Equal(t, []string{"lex", "a.in", "1"}, rows[1][:3])
+		require.Equal(t, []string{"parse", "a.in", "1"}, rows[2][:3])
+		require.Equal(t, []string{"lex", "b.in", "1"}, rows[3][:3])
+		require.Equal(t, []string{"dce", "2"}, rows[4][:2])
+		require.Equal(t, "total", rows[5][0])
+	})
+
+	t.Run("trace", func(t *testing.T) {
+		t.Parallel()
+
+		var sb strings.Builder
+		require.NoError(t, rec.WriteTrace(&sb))
+
+		var trace struct {
+			TraceEvents []struct {
+				Name  string            `json:"name"`
+				Cat   string            `json:"cat"`
+				Phase string            `json:"ph"`
+				Args  map[string]string `json:"args"`
+			} `json:"traceEvents"`
+		}
+
+		require.NoError(t, json.Unmarshal([]byte(sb.String()), &trace))
+		require.Len(t, trace.TraceEvents, 5)
+		require.Equal(t, "lex a.in", trace.TraceEvents[0].Name)
+		require.Equal(t, "X", trace.TraceEvents[0].Phase)
+		require.Equal(t, "a.in", trace.TraceEvents[0].Args["file"])
+		require.Equal(t, "dce main", trace.TraceEvents[3].Name)
+		require.Equal(t, "pass", trace.TraceEvents[3].Cat)
+		require.Equal(t, "main", trace.TraceEvents[3].Args["func"])
+	})
+}
+
+func TestRecorder_Nil(t *testing.T) {
+	t.Parallel()
+
+	var rec *Recorder
+
+	rec.Start("lex", "a.in")()
+	rec.StartFunc("dce", "main")()
+}
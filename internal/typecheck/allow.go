@@ -0,0 +1,91 @@
+package typecheck
+
+import (
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// validateAllow checks that the `allow` attributes of the unit only name known
+// diagnostics, e.g. `@(allow="unused,CB0003")`.
+func validateAllow(unit *ast.CompilationUnit) []error {
+	var errs []error
+
+	check := func(attrs ast.Attributes, loc lexer.Location) {
+		value, ok := attrs[ast.AttrKeyAllow].(ast.AttrString)
+		if !ok {
+			return
+		}
+
+		if _, err := diag.ParseList(string(value)); err != nil {
+			errs = append(errs, diag.Errorf(loc, "attribute %s: %s", ast.AttrKeyAllow, err))
+		}
+	}
+
+	check(unit.Attributes, unit.Loc)
+
+	for _, fd := range unit.Funcs {
+		check(fd.Attributes, fd.Loc)
+
+		for _, param := range fd.Params {
+			check(param.Attributes, param.Loc)
+		}
+	}
+
+	return errs
+}
+
+// allowed reports whether one of the attributes suppresses diagnostics with the
+// given code.
+func allowed(code *diag.Code, attrs ...ast.Attributes) bool {
+	for _, a := range attrs {
+		value, ok := a[ast.AttrKeyAllow].(ast.AttrString)
+		if !ok {
+			continue
+		}
+
+		// The lists were validated before checking started.
+		codes, _ := diag.ParseList(string(value))
+
+		for _, c := range codes {
+			if c == code {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// attributesOf returns the attributes that apply to a local symbol, from the
+// innermost to the outermost declaration: the parameter it refers to, if any,
+// the function being checked and the package.
+func (tc *Checker) attributesOf(sym *Symbol) []ast.Attributes {
+	var attrs []ast.Attributes
+
+	if tc.fn != nil && sym.IsParam {
+		for _, param := range tc.fn.Params {
+			if param.Ident == sym.Name {
+				attrs = append(attrs, param.Attributes)
+			}
+		}
+	}
+
+	return append(attrs, tc.enclosingAttributes()...)
+}
+
+// enclosingAttributes returns the attributes that apply to the code being
+// checked: those of the function and the package.
+func (tc *Checker) enclosingAttributes() []ast.Attributes {
+	var attrs []ast.Attributes
+
+	if tc.fn != nil {
+		attrs = append(attrs, tc.fn.Attributes)
+	}
+
+	if tc.unit != nil {
+		attrs = append(attrs, tc.unit.Attributes)
+	}
+
+	return attrs
+}
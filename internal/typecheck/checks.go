@@ -0,0 +1,51 @@
+package typecheck
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+)
+
+// UnitCheck checks a type checked unit, e.g. for a lint rule of a project, and
+// returns the errors to report. Diagnostics, e.g. of diag.Errorf, are reported
+// at their location, other errors at the package.
+type UnitCheck func(unit *ast.CompilationUnit) []error
+
+type unitCheck struct {
+	name  string
+	check UnitCheck
+}
+
+var unitChecks []unitCheck
+
+// RegisterCheck adds check to the checks that run once the type checker found
+// no errors, in the order they're registered, for programs that embed the
+// compiler. It isn't safe to call while units are checked, so it's meant to be
+// called from init.
+func RegisterCheck(name string, check UnitCheck) error {
+	for _, c := range unitChecks {
+		if c.name == name {
+			return fmt.Errorf("check %s is already registered", name)
+		}
+	}
+
+	unitChecks = append(unitChecks, unitCheck{name: name, check: check})
+
+	return nil
+}
+
+// runChecks runs the registered checks on unit and reports their errors.
+func (tc *Checker) runChecks(unit *ast.CompilationUnit) {
+	for _, c := range unitChecks {
+		for _, err := range c.check(unit) {
+			var d diag.Diagnostic
+			if !errors.As(err, &d) {
+				err = diag.Errorf(unit.Loc, "check %s: %v", c.name, err)
+			}
+
+			tc.report(err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package typecheck
+
+import (
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Constraint is a set of operations a generic type parameter requires of the
+// types it's instantiated with, e.g. `$T: Comparable`. Constraints are built
+// in; the language has no way to declare them yet.
+type Constraint struct {
+	Name       string
+	Operations string // the operations the constraint requires, for diagnostics
+	satisfies  func(*ast.Type) bool
+}
+
+var (
+	Comparable = &Constraint{
+		Name:       "Comparable",
+		Operations: "== and !=",
+		satisfies: func(t *ast.Type) bool {
+			return t.Kind != ast.TypeVoid && t.Kind != ast.TypeVararg
+		},
+	}
+	Ordered = &Constraint{
+		Name:       "Ordered",
+		Operations: "<, <=, > and >=",
+		satisfies: func(t *ast.Type) bool {
+			return t.Kind == ast.TypeInt || t.Kind == ast.TypeString || t.Kind == ast.TypePointer
+		},
+	}
+	Numeric = &Constraint{
+		Name:       "Numeric",
+		Operations: "+, -, *, / and %",
+		satisfies: func(t *ast.Type) bool {
+			return t.Kind == ast.TypeInt
+		},
+	}
+)
+
+// Constraints lists the known constraints.
+var Constraints = []*Constraint{
+	Comparable,
+	Ordered,
+	Numeric,
+}
+
+// LookupConstraint returns the constraint with the given name.
+func LookupConstraint(name string) (*Constraint, bool) {
+	for _, c := range Constraints {
+		if c.Name == name {
+			return c, true
+		}
+	}
+
+	return nil, false
+}
+
+// SatisfiedBy reports whether t supports the operations the constraint
+// requires. Unknown types satisfy every constraint, so errors don't cascade.
+func (c *Constraint) SatisfiedBy(t *ast.Type) bool {
+	return t == nil || isUnknown(t) || c.satisfies(t)
+}
+
+// CheckInstantiation reports an error at loc for every type argument that
+// doesn't satisfy the constraint of its generic parameter. It's meant to run
+// before a generic function is monomorphized, so the error points at the
+// instantiation rather than somewhere in the generic body.
+func CheckInstantiation(loc lexer.Location, params []*ast.GenericParam, args []*ast.Type) []error {
+	var errs []error
+
+	for i, gp := range params {
+		if i >= len(args) || gp.Kind != ast.GenericType || gp.Constraint == "" {
+			continue
+		}
+
+		c, ok := LookupConstraint(gp.Constraint)
+		if !ok || c.SatisfiedBy(args[i]) {
+			continue
+		}
+
+		errs = append(errs, diag.Errorf(loc, "%s does not satisfy %s for $%s (it doesn't support %s)",
+			args[i], c.Name, gp.Symbol, c.Operations).
+			WithNote(gp.Location(), "$%s is constrained here", gp.Symbol))
+	}
+
+	return errs
+}
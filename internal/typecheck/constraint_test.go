@@ -0,0 +1,91 @@
+package typecheck
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/lexer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckInstantiation(t *testing.T) {
+	t.Parallel()
+
+	var (
+		intT    = &ast.Type{Kind: ast.TypeInt}
+		boolT   = &ast.Type{Kind: ast.TypeBool}
+		stringT = &ast.Type{Kind: ast.TypeString}
+		unknown = &ast.Type{Kind: ast.TypeUnknown}
+		loc     = lexer.Location{Filename: "test.in", Line: 9, Column: 5}
+		param   = func(symbol, constraint string) *ast.GenericParam {
+			gp := ast.NewGenericParamType(symbol)
+			gp.Constraint = constraint
+			gp.Loc = lexer.Location{Filename: "test.in", Line: 3, Column: 9}
+
+			return gp
+		}
+	)
+
+	tt := []struct {
+		name     string
+		params   []*ast.GenericParam
+		args     []*ast.Type
+		expected string
+	}{
+		{"unconstrained", []*ast.GenericParam{param("T", "")}, []*ast.Type{boolT}, ""},
+		{"comparable", []*ast.GenericParam{param("T", "Comparable")}, []*ast.Type{boolT}, ""},
+		{"ordered", []*ast.GenericParam{param("T", "Ordered")}, []*ast.Type{stringT}, ""},
+		{"unknown type", []*ast.GenericParam{param("T", "Numeric")}, []*ast.Type{unknown}, ""},
+		{
+			"not ordered", []*ast.GenericParam{param("K", "Ordered"), param("V", "Numeric")}, []*ast.Type{boolT, intT},
+			"test.in:9:5: bool does not satisfy Ordered for $K (it doesn't support <, <=, > and >=)\n" +
+				"\ttest.in:3:9: $K is constrained here",
+		},
+		{
+			"not numeric", []*ast.GenericParam{param("T", "Numeric")}, []*ast.Type{stringT},
+			"test.in:9:5: string does not satisfy Numeric for $T (it doesn't support +, -, *, / and %)\n" +
+				"\ttest.in:3:9: $T is constrained here",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := errors.Join(CheckInstantiation(loc, tc.params, tc.args)...)
+			if tc.expected == "" {
+				require.NoError(t, err)
+
+				return
+			}
+
+			require.EqualError(t, err, tc.expected)
+		})
+	}
+}
+
+func TestCheck_GenericParam(t *testing.T) {
+	t.Parallel()
+
+	loc := lexer.Location{Filename: "test.in", Line: 3, Column: 9}
+
+	typeParam := ast.NewGenericParamType("T")
+	typeParam.Constraint = "Sortable"
+	typeParam.Loc = loc
+
+	valueParam := ast.NewGenericParamValue("N", &ast.Type{Kind: ast.TypeInt})
+	valueParam.Constraint = "Numeric"
+	valueParam.Loc = loc
+
+	fd := ast.NewFuncDef("f", nil, loc)
+	fd.GenericParams = []*ast.GenericParam{typeParam, valueParam}
+	fd.Body = ast.NewBody(nil, loc)
+
+	unit := ast.NewCompilationUnit(loc)
+	unit.Ident = "main"
+	unit.Funcs = []*ast.FuncDef{fd}
+
+	require.EqualError(t, Check(unit), "test.in:3:9: unknown constraint 'Sortable' for $T\n"+
+		"test.in:3:9: value parameter $N can't have a constraint")
+}
@@ -0,0 +1,137 @@
+package typecheck
+
+import (
+	"fmt"
+
+	"github.com/corani/cubit/internal/ast"
+)
+
+// Conversion classifies how a value of one type can be used where a value of
+// another type is expected. The lattice is:
+//
+//   - identical types convert trivially;
+//   - every type but void converts implicitly to and from any, and every
+//     pointer to and from ^any;
+//   - other pointer types, int and pointers, and arrays and pointers to their
+//     element type only convert explicitly;
+//   - everything else, notably int and bool, doesn't convert at all.
+type Conversion int
+
+const (
+	ConvNone     Conversion = iota // the types are incompatible
+	ConvIdentity                   // the types are the same
+	ConvImplicit                   // the value is converted automatically
+	ConvExplicit                   // the value must be converted explicitly
+)
+
+func (c Conversion) String() string {
+	switch c {
+	case ConvNone:
+		return "none"
+	case ConvIdentity:
+		return "identity"
+	case ConvImplicit:
+		return "implicit"
+	case ConvExplicit:
+		return "explicit"
+	default:
+		return fmt.Sprintf("Conversion(%d)", int(c))
+	}
+}
+
+// Implicit reports whether the conversion happens without being asked for.
+func (c Conversion) Implicit() bool {
+	return c == ConvIdentity || c == ConvImplicit
+}
+
+// Convert returns the conversion of a value of type src to type dst.
+func Convert(dst, src *ast.Type) Conversion {
+	switch {
+	case dst == nil || src == nil:
+		return ConvNone
+	case identical(dst, src):
+		return ConvIdentity
+	case dst.Kind == ast.TypeVoid || src.Kind == ast.TypeVoid:
+		return ConvNone
+	case dst.Kind == ast.TypeAny || src.Kind == ast.TypeAny:
+		return ConvImplicit
+	}
+
+	switch {
+	case dst.Kind == ast.TypePointer && src.Kind == ast.TypePointer:
+		if isAny(dst.Elem) || isAny(src.Elem) {
+			return ConvImplicit
+		}
+
+		return ConvExplicit
+	case dst.Kind == ast.TypePointer && src.Kind == ast.TypeArray:
+		if identical(dst.Elem, src.Elem) {
+			return ConvExplicit
+		}
+	case dst.Kind == ast.TypePointer && src.Kind == ast.TypeInt,
+		dst.Kind == ast.TypeInt && src.Kind == ast.TypePointer:
+		return ConvExplicit
+	}
+
+	return ConvNone
+}
+
+func isAny(t *ast.Type) bool {
+	return t != nil && t.Kind == ast.TypeAny
+}
+
+// identical reports whether a and b are the same type.
+func identical(a, b *ast.Type) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	if a.Kind != b.Kind {
+		return false
+	}
+
+	switch a.Kind {
+	case ast.TypePointer, ast.TypeVararg:
+		return identical(a.Elem, b.Elem)
+	case ast.TypeArray:
+		return identical(a.Elem, b.Elem) && sameSize(a.Size, b.Size)
+	default:
+		return true
+	}
+}
+
+func sameSize(a, b *ast.Size) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+// conversionHint suggests how to fix a value of type src used where dst is
+// expected, as a suffix for a diagnostic. It returns "" if there's nothing to
+// suggest.
+func conversionHint(dst, src *ast.Type) string {
+	switch {
+	case dst == nil || src == nil:
+		return ""
+	case Convert(dst, src) == ConvExplicit:
+		return fmt.Sprintf(" (an explicit conversion from %s to %s is required)", src, dst)
+	case dst.Kind == ast.TypeBool && src.Kind == ast.TypeInt:
+		return " (compare with 0 to get a bool, e.g. 'x != 0')"
+	default:
+		return ""
+	}
+}
+
+// assignable reports whether a value of type src can be used where dst is
+// expected without an explicit conversion.
+func assignable(dst, src *ast.Type) bool {
+	return Convert(dst, src).Implicit()
+}
+
+// compatible reports whether values of type a and b can be compared, i.e.
+// whether either implicitly converts to the other.
+func compatible(a, b *ast.Type) bool {
+	return assignable(a, b) || assignable(b, a)
+}
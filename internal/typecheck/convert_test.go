@@ -0,0 +1,57 @@
+package typecheck
+
+import (
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvert(t *testing.T) {
+	t.Parallel()
+
+	var (
+		intT    = &ast.Type{Kind: ast.TypeInt}
+		boolT   = &ast.Type{Kind: ast.TypeBool}
+		stringT = &ast.Type{Kind: ast.TypeString}
+		voidT   = &ast.Type{Kind: ast.TypeVoid}
+		anyT    = &ast.Type{Kind: ast.TypeAny}
+		ptr     = func(elem *ast.Type) *ast.Type { return &ast.Type{Kind: ast.TypePointer, Elem: elem} }
+		array   = func(n int, elem *ast.Type) *ast.Type {
+			return &ast.Type{Kind: ast.TypeArray, Elem: elem, Size: ast.NewSizeLiteral(n)}
+		}
+	)
+
+	tt := []struct {
+		name     string
+		dst, src *ast.Type
+		expected Conversion
+	}{
+		{"int to int", intT, intT, ConvIdentity},
+		{"pointer to same pointer", ptr(intT), ptr(intT), ConvIdentity},
+		{"array to same array", array(4, intT), array(4, intT), ConvIdentity},
+		{"int to any", anyT, intT, ConvImplicit},
+		{"any to string", stringT, anyT, ConvImplicit},
+		{"pointer to ^any", ptr(anyT), ptr(intT), ConvImplicit},
+		{"^any to pointer", ptr(boolT), ptr(anyT), ConvImplicit},
+		{"pointer to other pointer", ptr(intT), ptr(boolT), ConvExplicit},
+		{"pointer depth", ptr(intT), ptr(ptr(intT)), ConvExplicit},
+		{"array to element pointer", ptr(intT), array(4, intT), ConvExplicit},
+		{"int to pointer", ptr(intT), intT, ConvExplicit},
+		{"pointer to int", intT, ptr(intT), ConvExplicit},
+		{"int to bool", boolT, intT, ConvNone},
+		{"bool to int", intT, boolT, ConvNone},
+		{"string to int", intT, stringT, ConvNone},
+		{"array size", array(4, intT), array(8, intT), ConvNone},
+		{"void to any", anyT, voidT, ConvNone},
+		{"array to other pointer", ptr(boolT), array(4, intT), ConvNone},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.expected, Convert(tc.dst, tc.src))
+		})
+	}
+}
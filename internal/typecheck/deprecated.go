@@ -0,0 +1,20 @@
+package typecheck
+
+import (
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+)
+
+// checkDeprecated warns about a call to a function marked deprecated, with the
+// message of the attribute and a note where the function is declared.
+func (tc *Checker) checkDeprecated(call *ast.Call) {
+	msg, ok := call.FuncDef.Attributes[ast.AttrKeyDeprecated].(ast.AttrString)
+	if !ok {
+		return
+	}
+
+	d := diag.Newf(diag.Deprecated, call.Location(), "'%s' is deprecated: %s", call.Ident, msg).
+		WithNote(call.FuncDef.Location(), "'%s' is declared deprecated here", call.Ident)
+
+	tc.warn(d, tc.enclosingAttributes())
+}
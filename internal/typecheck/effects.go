@@ -0,0 +1,93 @@
+package typecheck
+
+import (
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+)
+
+// terminates reports whether control can't reach the end of a list of
+// statements: its last statement returns, calls a noreturn function, is an if
+// whose branches both terminate, or is a loop whose condition is always true.
+// The language has no break statement, so such a loop never exits.
+func terminates(instrs []ast.Instruction) bool {
+	if len(instrs) == 0 {
+		return false
+	}
+
+	switch last := instrs[len(instrs)-1].(type) {
+	case *ast.Return:
+		return true
+	case *ast.Call:
+		return last.FuncDef != nil && last.FuncDef.Attributes.Has(ast.AttrKeyNoReturn)
+	case *ast.If:
+		return last.Else != nil && terminates(last.Then.Instructions) && terminates(last.Else.Instructions)
+	case *ast.For:
+		cond := ast.ConstValue(last.Cond)
+
+		return cond != nil && cond.Type().Kind == ast.TypeBool && cond.BoolValue
+	default:
+		return false
+	}
+}
+
+// checkReturns reports a function that can reach the end of its body without
+// returning a value, and a noreturn function that can reach it at all.
+func (tc *Checker) checkReturns(fn *ast.FuncDef) {
+	if fn.Body == nil || terminates(fn.Body.Instructions) {
+		return
+	}
+
+	switch {
+	case fn.Attributes.Has(ast.AttrKeyNoReturn):
+		tc.errorf(fn.Location(), "noreturn function '%s' can reach the end of its body", fn.Ident)
+	case fn.ReturnType != nil && fn.ReturnType.Kind != ast.TypeVoid && !isUnknown(fn.ReturnType):
+		tc.errorf(fn.Location(), "function '%s' is missing a return at the end of its body", fn.Ident)
+	}
+}
+
+// checkPureCall reports a call from a pure function to a function that isn't
+// pure. Builtins are provided by the compiler and don't have side effects.
+func (tc *Checker) checkPureCall(call *ast.Call) {
+	if tc.fn == nil || !tc.fn.Attributes.Has(ast.AttrKeyPure) {
+		return
+	}
+
+	if callee := call.FuncDef; !callee.Attributes.Has(ast.AttrKeyPure) && !callee.Attributes.Has(ast.AttrKeyBuiltin) {
+		tc.errorf(call.Location(), "pure function '%s' can't call '%s', which isn't pure", tc.fn.Ident, call.Ident)
+	}
+}
+
+// checkPureAssign reports an assignment in a pure function that writes to
+// memory the function doesn't own: through a pointer, or into an array that was
+// passed in (arrays are passed by reference).
+func (tc *Checker) checkPureAssign(a *ast.Assign) {
+	if tc.fn == nil || !tc.fn.Attributes.Has(ast.AttrKeyPure) {
+		return
+	}
+
+	switch lhs := a.LHS.(type) {
+	case *ast.Deref:
+		tc.errorf(a.Location(), "pure function '%s' can't write through a pointer", tc.fn.Ident)
+	case *ast.ArrayIndex:
+		ref, ok := lhs.Array.(*ast.VariableRef)
+		if !ok {
+			break
+		}
+
+		if sym, ok := tc.lookupSymbol(ref.Ident); ok && sym.IsParam {
+			tc.errorf(a.Location(), "pure function '%s' can't modify its parameter '%s'", tc.fn.Ident, ref.Ident)
+		}
+	}
+}
+
+// checkUnusedResult warns about a call to a pure function used as a statement:
+// its result is discarded, so the call does nothing.
+func (tc *Checker) checkUnusedResult(instr ast.Instruction) {
+	call, ok := instr.(*ast.Call)
+	if !ok || call.FuncDef == nil || !call.FuncDef.Attributes.Has(ast.AttrKeyPure) {
+		return
+	}
+
+	tc.warnf(diag.UnusedResult, tc.enclosingAttributes(), call.Location(),
+		"result of pure function '%s' is not used", call.Ident)
+}
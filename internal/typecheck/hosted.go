@@ -0,0 +1,18 @@
+package typecheck
+
+import (
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+)
+
+// checkHosted reports a call to a function marked hosted in a freestanding
+// program, with a note where the function is declared.
+func (tc *Checker) checkHosted(call *ast.Call) {
+	if !tc.freestanding || !call.FuncDef.Attributes.Has(ast.AttrKeyHosted) {
+		return
+	}
+
+	tc.report(diag.Errorf(call.Location(), "'%s' needs an operating system, which a freestanding program doesn't have",
+		call.Ident).
+		WithNote(call.FuncDef.Location(), "'%s' is declared hosted here", call.Ident))
+}
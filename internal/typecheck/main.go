@@ -0,0 +1,27 @@
+package typecheck
+
+import (
+	"github.com/corani/cubit/internal/ast"
+)
+
+// checkMain reports an executable without the exported main function the C
+// library starts it in, which would otherwise fail when it's linked.
+func (tc *Checker) checkMain(unit *ast.CompilationUnit) {
+	if !tc.executable {
+		return
+	}
+
+	for _, fn := range unit.Funcs {
+		if fn.Ident != "main" || fn.Body == nil {
+			continue
+		}
+
+		if !fn.Attributes.Has(ast.AttrKeyExport) {
+			tc.errorf(fn.Location(), "function 'main' must be @(%s), as the program starts in it", ast.AttrKeyExport)
+		}
+
+		return
+	}
+
+	tc.errorf(unit.Loc, "program has no function 'main' to start in")
+}
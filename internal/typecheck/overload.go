@@ -0,0 +1,107 @@
+package typecheck
+
+import (
+	"strings"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+)
+
+// checkOverloads reports functions that have the same name and parameter types
+// as an earlier function, as no call could choose between them, and overloaded
+// functions that are linked by name without an explicit link name, as only one
+// of them could have that name.
+func (tc *Checker) checkOverloads(unit *ast.CompilationUnit) {
+	for _, sigs := range tc.sigs {
+		for i, sig := range sigs {
+			for _, prev := range sigs[:i] {
+				if sig.SameParams(prev) {
+					tc.report(diag.Errorf(sig.Def.Location(), "function '%s' redeclared", sig.Name).
+						WithNote(prev.Def.Location(), "previously declared here"))
+
+					break
+				}
+			}
+		}
+	}
+
+	for _, fn := range unit.Funcs {
+		if len(tc.sigs[fn.Ident]) < 2 || fn.Attributes.Has(ast.AttrKeyLinkname) {
+			continue
+		}
+
+		for _, key := range []ast.AttrKey{ast.AttrKeyExtern, ast.AttrKeyExport, ast.AttrKeyNoMangle} {
+			if fn.Attributes.Has(key) {
+				tc.errorf(fn.Location(), "overloaded function '%s' can't be %s without a %s",
+					fn.Ident, key, ast.AttrKeyLinkname)
+
+				break
+			}
+		}
+	}
+}
+
+// resolveOverload picks the function a call refers to among the functions with
+// its name. A single candidate is always picked, so its arity and argument
+// types can be reported in detail. Otherwise, the candidate that matches the
+// arguments with the lowest cost wins; if there is none, or several, the call
+// is reported and nil is returned.
+func (tc *Checker) resolveOverload(call *ast.Call, candidates []*FuncSig) *FuncSig {
+	switch len(candidates) {
+	case 0:
+		return nil
+	case 1:
+		return candidates[0]
+	}
+
+	args := make([]*ast.Type, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = arg.Type
+	}
+
+	var (
+		best     []*FuncSig
+		bestCost int
+	)
+
+	for _, sig := range candidates {
+		cost, ok := sig.Match(args)
+
+		switch {
+		case !ok:
+		case len(best) == 0 || cost < bestCost:
+			best, bestCost = []*FuncSig{sig}, cost
+		case cost == bestCost:
+			best = append(best, sig)
+		}
+	}
+
+	if len(best) == 1 {
+		return best[0]
+	}
+
+	problem, listed := "no overload of '%s' matches arguments", candidates
+	if len(best) > 1 {
+		problem, listed = "ambiguous call to '%s' with arguments", best
+	}
+
+	d := diag.Errorf(call.Location(), problem+" %s", call.Ident, typeList(args))
+
+	for _, sig := range listed {
+		d = d.WithNote(sig.Def.Location(), "candidate %s", sig)
+	}
+
+	tc.report(d)
+
+	return nil
+}
+
+// typeList formats types as a parenthesized list, e.g. `(int, ^bool)`.
+func typeList(types []*ast.Type) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+
+	return "(" + strings.Join(names, ", ") + ")"
+}
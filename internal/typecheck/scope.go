@@ -0,0 +1,158 @@
+package typecheck
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Symbol represents a variable or function in the symbol table.
+type Symbol struct {
+	Name        string
+	Type        *ast.Type
+	IsFunc      bool
+	FuncDef     *ast.FuncDef // Only set if IsFunc
+	Declaration *ast.Declare
+	IsParam     bool
+	Used        bool           // set when the variable is read
+	Loc         lexer.Location // where the symbol was declared
+}
+
+func NewSymbolFunc(name string, ty *ast.Type, def *ast.FuncDef, loc lexer.Location) *Symbol {
+	return &Symbol{
+		Name:        name,
+		Type:        ty,
+		IsFunc:      true,
+		FuncDef:     def,
+		Declaration: nil,
+		Loc:         loc,
+	}
+}
+
+func NewSymbolVariable(name string, ty *ast.Type, decl *ast.Declare, loc lexer.Location) *Symbol {
+	return &Symbol{
+		Name:        name,
+		Type:        ty,
+		IsFunc:      false,
+		FuncDef:     nil,
+		Declaration: decl,
+		Loc:         loc,
+	}
+}
+
+func (s *Symbol) UpdateType(ty *ast.Type) error {
+	// Allow specializing from 'any' to a more specific type
+	if s.Type != nil && s.Type.Kind != ast.TypeUnknown {
+		if s.Type.Kind == ast.TypeAny && ty != nil && ty.Kind != ast.TypeAny && ty.Kind != ast.TypeUnknown {
+			s.Type = ty
+		} else {
+			return fmt.Errorf("symbol %s already has a type: %s", s.Name, s.Type)
+		}
+	} else {
+		s.Type = ty
+	}
+
+	if s.Declaration == nil {
+		return nil
+	}
+
+	if s.Declaration.Type != nil && s.Declaration.Type.Kind != ast.TypeUnknown {
+		if s.Declaration.Type.Kind == ast.TypeAny && ty != nil && ty.Kind != ast.TypeAny && ty.Kind != ast.TypeUnknown {
+			s.Declaration.Type = ty
+		} else {
+			return fmt.Errorf("symbol %s already has a declaration type: %s", s.Name, s.Declaration.Type)
+		}
+	} else {
+		s.Declaration.Type = ty
+	}
+
+	return nil
+}
+
+// Scope management helpers
+func (tc *Checker) pushScope() {
+	tc.scopes = append(tc.scopes, make(map[string]*Symbol))
+}
+
+func (tc *Checker) popScope() {
+	if len(tc.scopes) > 0 {
+		tc.reportUnused(tc.scopes[len(tc.scopes)-1])
+		tc.scopes = tc.scopes[:len(tc.scopes)-1]
+	}
+}
+
+// reportUnused warns about the variables and parameters of a scope that are
+// never read. Names starting with an underscore are exempt.
+func (tc *Checker) reportUnused(scope map[string]*Symbol) {
+	var unused []*Symbol
+
+	for _, sym := range scope {
+		if !sym.IsFunc && !sym.Used && !strings.HasPrefix(sym.Name, "_") {
+			unused = append(unused, sym)
+		}
+	}
+
+	slices.SortFunc(unused, func(a, b *Symbol) int {
+		return cmp.Or(cmp.Compare(a.Loc.Line, b.Loc.Line), cmp.Compare(a.Loc.Column, b.Loc.Column))
+	})
+
+	for _, sym := range unused {
+		if sym.IsParam {
+			tc.warnf(diag.UnusedParameter, tc.attributesOf(sym), sym.Loc, "parameter '%s' is never used", sym.Name)
+		} else {
+			tc.warnf(diag.UnusedVariable, tc.attributesOf(sym), sym.Loc, "variable '%s' is declared but never used", sym.Name)
+		}
+	}
+}
+
+func (tc *Checker) withScope(fn func()) {
+	tc.pushScope()
+	defer tc.popScope()
+
+	fn()
+}
+
+func (tc *Checker) addSymbol(sym *Symbol) {
+	if len(tc.scopes) == 0 {
+		tc.pushScope()
+	}
+	tc.scopes[len(tc.scopes)-1][sym.Name] = sym
+}
+
+// declare adds sym to the current scope, reporting an error if the name was
+// already declared in the same scope. Variables and parameters in enclosing
+// scopes may be shadowed, but that's reported as a warning unless the name
+// starts with an underscore. kind names the declaration in the diagnostic, e.g.
+// "variable".
+func (tc *Checker) declare(sym *Symbol, kind string) {
+	if len(tc.scopes) > 0 {
+		if prev, ok := tc.scopes[len(tc.scopes)-1][sym.Name]; ok {
+			tc.report(diag.Errorf(sym.Loc, "%s '%s' redeclared", kind, sym.Name).
+				WithNote(prev.Loc, "previously declared here"))
+		} else if prev, ok := tc.lookupSymbol(sym.Name); ok && !prev.IsFunc && !sym.IsFunc && !strings.HasPrefix(sym.Name, "_") {
+			shadowed := "variable"
+			if prev.IsParam {
+				shadowed = "parameter"
+			}
+
+			tc.warnf(diag.ShadowedVariable, tc.attributesOf(sym), sym.Loc, "%s '%s' shadows the %s declared at %s",
+				kind, sym.Name, shadowed, prev.Loc)
+		}
+	}
+
+	tc.addSymbol(sym)
+}
+
+func (tc *Checker) lookupSymbol(name string) (*Symbol, bool) {
+	for i := len(tc.scopes) - 1; i >= 0; i-- {
+		if sym, ok := tc.scopes[i][name]; ok {
+			return sym, true
+		}
+	}
+	return nil, false
+}
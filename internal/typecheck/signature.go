@@ -0,0 +1,194 @@
+package typecheck
+
+import (
+	"strings"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// FuncSig is the signature of a function as seen by its callers: the fixed
+// parameters, the optional trailing variadic parameter and the return type.
+type FuncSig struct {
+	Name    string
+	Params  []*ast.FuncParam
+	Vararg  *ast.FuncParam // nil if the function isn't variadic
+	Returns *ast.Type
+	Def     *ast.FuncDef
+}
+
+// NewFuncSig builds the signature of fd. It reports an error if a variadic
+// parameter isn't the last parameter of the function, or has a default value.
+func NewFuncSig(fd *ast.FuncDef) (*FuncSig, error) {
+	sig := &FuncSig{
+		Name:    fd.Ident,
+		Returns: fd.ReturnType,
+		Def:     fd,
+	}
+
+	for i, param := range fd.Params {
+		if param.Type == nil || param.Type.Kind != ast.TypeVararg {
+			sig.Params = append(sig.Params, param)
+
+			continue
+		}
+
+		if i != len(fd.Params)-1 {
+			return nil, diag.Errorf(param.Location(), "variadic parameter '%s' must be the last parameter of '%s'",
+				param.Ident, fd.Ident)
+		}
+
+		if param.Value != nil {
+			return nil, diag.Errorf(param.Location(), "variadic parameter '%s' can't have a default value",
+				param.Ident)
+		}
+
+		sig.Vararg = param
+	}
+
+	return sig, nil
+}
+
+// IsVariadic reports whether the function takes a variable number of arguments.
+func (sig *FuncSig) IsVariadic() bool {
+	return sig.Vararg != nil
+}
+
+// Param returns the parameter that receives argument i (0-based), or nil if the
+// function takes fewer arguments.
+func (sig *FuncSig) Param(i int) *ast.FuncParam {
+	switch {
+	case i < len(sig.Params):
+		return sig.Params[i]
+	case sig.Vararg != nil:
+		return sig.Vararg
+	default:
+		return nil
+	}
+}
+
+// ParamType returns the type expected for argument i (0-based): the type of the
+// parameter, or the element type for arguments passed to the variadic parameter.
+func (sig *FuncSig) ParamType(i int) *ast.Type {
+	param := sig.Param(i)
+	if param == nil {
+		return nil
+	}
+
+	if param == sig.Vararg {
+		return param.Type.Elem
+	}
+
+	return param.Type
+}
+
+// CheckArity reports an error at loc if n arguments can't be passed to the
+// function.
+func (sig *FuncSig) CheckArity(loc lexer.Location, n int) error {
+	switch {
+	case n < len(sig.Params) && sig.IsVariadic():
+		return diag.Errorf(loc, "call to '%s' has too few arguments, expected at least %d, got %d",
+			sig.Name, len(sig.Params), n)
+	case n < len(sig.Params):
+		return diag.Errorf(loc, "call to '%s' has too few arguments, expected %d, got %d",
+			sig.Name, len(sig.Params), n)
+	case n > len(sig.Params) && !sig.IsVariadic():
+		return diag.Errorf(loc, "call to '%s' has too many arguments, expected %d, got %d",
+			sig.Name, len(sig.Params), n)
+	default:
+		return nil
+	}
+}
+
+// Match reports whether arguments of the given types can be passed to the
+// function, and at what cost: each argument that needs an implicit conversion
+// or is passed to the variadic parameter adds one. Arguments of unknown type
+// match any parameter.
+func (sig *FuncSig) Match(args []*ast.Type) (int, bool) {
+	if sig.CheckArity(lexer.Location{}, len(args)) != nil {
+		return 0, false
+	}
+
+	cost := 0
+
+	for i, arg := range args {
+		if sig.Param(i) == sig.Vararg {
+			cost++
+		}
+
+		if isUnknown(arg) {
+			continue
+		}
+
+		switch conv := Convert(sig.ParamType(i), arg); {
+		case conv == ConvIdentity:
+		case conv.Implicit():
+			cost++
+		default:
+			return 0, false
+		}
+	}
+
+	return cost, true
+}
+
+// SameParams reports whether both functions take the same parameter types, so
+// no call could tell them apart.
+func (sig *FuncSig) SameParams(other *FuncSig) bool {
+	if len(sig.Params) != len(other.Params) || sig.IsVariadic() != other.IsVariadic() {
+		return false
+	}
+
+	for i := range sig.Params {
+		if !identical(sig.Params[i].Type, other.Params[i].Type) {
+			return false
+		}
+	}
+
+	return !sig.IsVariadic() || identical(sig.Vararg.Type, other.Vararg.Type)
+}
+
+func (sig *FuncSig) String() string {
+	var sb strings.Builder
+
+	sb.WriteString(sig.Name)
+	sb.WriteString("(")
+
+	for i, param := range sig.Def.Params {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+
+		sb.WriteString(param.Type.String())
+	}
+
+	sb.WriteString(")")
+
+	return sb.String()
+}
+
+// FuncSigs maps function names to their signatures. A name has more than one
+// signature if the function is overloaded.
+type FuncSigs map[string][]*FuncSig
+
+// NewFuncSigs builds the signatures of all functions declared in the unit,
+// including extern and builtin functions, in declaration order.
+func NewFuncSigs(unit *ast.CompilationUnit) (FuncSigs, []error) {
+	sigs := make(FuncSigs, len(unit.Funcs))
+
+	var errs []error
+
+	for _, fd := range unit.Funcs {
+		sig, err := NewFuncSig(fd)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		sigs[fd.Ident] = append(sigs[fd.Ident], sig)
+	}
+
+	return sigs, errs
+}
@@ -0,0 +1,129 @@
+package typecheck
+
+import (
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// undefinedVariable reports a reference to a name that isn't a visible variable.
+// If the variable is declared later in the function, the error points at the
+// declaration; otherwise it suggests a similarly named variable, if any.
+func (tc *Checker) undefinedVariable(ref *ast.VariableRef) {
+	if decl := tc.laterDeclaration(ref); decl != nil {
+		tc.report(diag.Errorf(ref.Location(), "variable '%s' used before declaration", ref.Ident).
+			WithNote(decl.Location(), "declared here"))
+
+		return
+	}
+
+	if sym, ok := tc.lookupSymbol(ref.Ident); ok && sym.IsFunc {
+		tc.errorf(ref.Location(), "function '%s' used as a variable", ref.Ident)
+
+		return
+	}
+
+	if name := tc.suggest(ref.Ident, false); name != "" {
+		tc.errorf(ref.Location(), "undefined variable '%s'; did you mean '%s'?", ref.Ident, name)
+
+		return
+	}
+
+	tc.errorf(ref.Location(), "undefined variable '%s'", ref.Ident)
+}
+
+// undefinedFunction reports a call to a name that isn't a visible function,
+// suggesting a similarly named function, if any.
+func (tc *Checker) undefinedFunction(call *ast.Call) {
+	if sym, ok := tc.lookupSymbol(call.Ident); ok && !sym.IsFunc {
+		tc.report(diag.Errorf(call.Location(), "cannot call non-function '%s'", call.Ident).
+			WithNote(sym.Loc, "declared here"))
+
+		return
+	}
+
+	if name := tc.suggest(call.Ident, true); name != "" {
+		tc.errorf(call.Location(), "call to undefined function '%s'; did you mean '%s'?", call.Ident, name)
+
+		return
+	}
+
+	tc.errorf(call.Location(), "call to undefined function '%s'", call.Ident)
+}
+
+// laterDeclaration returns the declaration of ref's name that follows ref in
+// the function being checked, or nil if there is none.
+func (tc *Checker) laterDeclaration(ref *ast.VariableRef) *ast.Declare {
+	if tc.fn == nil || tc.fn.Body == nil {
+		return nil
+	}
+
+	var found *ast.Declare
+
+	ast.Walk(tc.fn.Body, func(n ast.Node) bool {
+		if found != nil {
+			return false
+		}
+
+		if decl, ok := n.(*ast.Declare); ok && decl.Ident == ref.Ident && after(decl.Location(), ref.Location()) {
+			found = decl
+		}
+
+		return true
+	})
+
+	return found
+}
+
+// after reports whether a comes after b in the same file.
+func after(a, b lexer.Location) bool {
+	return a.Line > b.Line || (a.Line == b.Line && a.Column > b.Column)
+}
+
+// suggest returns the visible function or variable name closest to name, or ""
+// if none is close enough to be a likely typo.
+func (tc *Checker) suggest(name string, funcs bool) string {
+	best, bestDist := "", len(name)/3+1
+
+	for i := len(tc.scopes) - 1; i >= 0; i-- {
+		for candidate, sym := range tc.scopes[i] {
+			if sym.IsFunc != funcs {
+				continue
+			}
+
+			dist := editDistance(name, candidate)
+			if dist < bestDist || (dist == bestDist && best != "" && candidate < best) {
+				best, bestDist = candidate, dist
+			}
+		}
+	}
+
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
@@ -0,0 +1,817 @@
+package typecheck
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/ast/printer"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/lexer"
+)
+
+// Options configure the checker.
+type Options struct {
+	// Diagnostics collects the errors and warnings, and decides which warnings
+	// are reported. If nil, the checker uses a bag of its own with the default
+	// configuration.
+	Diagnostics *diag.Bag
+	// Freestanding rejects calls to functions marked hosted, like printf,
+	// which need an operating system.
+	Freestanding bool
+	// Executable requires the exported main function a hosted program is
+	// started in, as it's linked with the C library.
+	Executable bool
+}
+
+// Checker implements a visitor for type checking the AST.
+type Checker struct {
+	diags      *diag.Bag
+	unit       *ast.CompilationUnit
+	scopes     []map[string]*Symbol
+	errors     []error
+	lastType   *ast.Type
+	lastSymbol *Symbol      // set by VisitVariableRef for lvalue assignment
+	assigning  bool         // set while checking a variable that is assigned to
+	negated    *ast.Literal // operand of the unary minus being checked
+	fn         *ast.FuncDef // function being checked
+	sigs       FuncSigs
+	// freestanding rejects calls to hosted functions.
+	freestanding bool
+	// executable requires an exported main function.
+	executable bool
+}
+
+func NewChecker(opts Options) *Checker {
+	return &Checker{
+		diags:        cmp.Or(opts.Diagnostics, diag.NewBag(diag.Config{})),
+		freestanding: opts.Freestanding,
+		executable:   opts.Executable,
+		scopes:       nil,
+		errors:       nil,
+	}
+}
+
+// Check runs the type checker on the given compilation unit, with the default
+// options.
+func Check(unit *ast.CompilationUnit) error {
+	return NewChecker(Options{}).Check(unit)
+}
+
+// Check runs the type checker on the given compilation unit. Warnings are
+// printed and can be retrieved with Warnings afterwards.
+func (tc *Checker) Check(unit *ast.CompilationUnit) error {
+	if errs := ast.ValidateAttributes(unit); len(errs) > 0 {
+		return tc.reportAll(errs)
+	}
+
+	if errs := validateAllow(unit); len(errs) > 0 {
+		return tc.reportAll(errs)
+	}
+
+	tc.unit = unit
+	unit.Accept(tc)
+
+	if len(tc.errors) == 0 {
+		tc.runChecks(unit)
+	}
+
+	return errors.Join(tc.errors...)
+}
+
+// Warnings returns the warnings reported by Check.
+func (tc *Checker) Warnings() []error {
+	return tc.diags.Filter(diag.SeverityWarning)
+}
+
+// errorf reports an error at loc.
+func (tc *Checker) errorf(loc lexer.Location, format string, args ...any) {
+	tc.errors = append(tc.errors, tc.diags.Errorf(loc, format, args...))
+}
+
+// report reports an error, e.g. one with notes.
+func (tc *Checker) report(err error) {
+	tc.errors = append(tc.errors, tc.diags.Add(err))
+}
+
+// reportAll reports errors found before checking and returns them joined.
+func (tc *Checker) reportAll(errs []error) error {
+	for _, err := range errs {
+		tc.report(err)
+	}
+
+	return errors.Join(tc.errors...)
+}
+
+// warnf reports a diagnostic with the given code at loc, unless it's disabled
+// or suppressed by the attributes in scope. In strict mode it's an error.
+func (tc *Checker) warnf(code *diag.Code, attrs []ast.Attributes, loc lexer.Location, format string, args ...any) {
+	tc.warn(diag.Newf(code, loc, format, args...), attrs)
+}
+
+// warn reports a diagnostic with a code, e.g. one with notes, like warnf.
+func (tc *Checker) warn(d diag.Diagnostic, attrs []ast.Attributes) {
+	if allowed(d.Code, attrs...) {
+		return
+	}
+
+	if d, ok := tc.diags.Report(d); ok && d.Severity == diag.SeverityError {
+		tc.errors = append(tc.errors, d)
+	}
+}
+
+func (tc *Checker) VisitCompilationUnit(unit *ast.CompilationUnit) {
+	// Push global scope
+	tc.pushScope()
+
+	// Check the parameters first, so inferred parameter types are known before
+	// any call is checked against them.
+	for _, fn := range unit.Funcs {
+		for _, param := range fn.Params {
+			param.Accept(tc)
+		}
+	}
+
+	// Collect the signatures that calls are checked against
+	sigs, errs := NewFuncSigs(unit)
+	tc.sigs = sigs
+
+	for _, err := range errs {
+		tc.report(err)
+	}
+
+	// Add all function definitions to the global scope. Overloads share the
+	// symbol of the first function with their name.
+	for _, fn := range unit.Funcs {
+		if _, ok := tc.lookupSymbol(fn.Ident); !ok {
+			tc.declare(NewSymbolFunc(fn.Ident, fn.ReturnType, fn, fn.Location()), "function")
+		}
+	}
+
+	tc.checkOverloads(unit)
+	tc.checkMain(unit)
+
+	// Visit all function, type, and data definitions
+	for _, td := range unit.Types {
+		td.Accept(tc)
+	}
+	for _, dd := range unit.Data {
+		dd.Accept(tc)
+	}
+	for _, fn := range unit.Funcs {
+		fn.Accept(tc)
+	}
+}
+
+func (tc *Checker) VisitTypeDef(fn *ast.TypeDef) {
+	// TODO: implement. The parser doesn't produce type definitions yet; once
+	// struct, alias and const declarations are parsed, report cycles such as
+	// `A :: struct { b: B }; B :: struct { a: A }` (without a pointer in
+	// between) or `X :: Y; Y :: X` here, before resolving their types.
+}
+
+func (tc *Checker) VisitDataDef(fn *ast.DataDef) {
+	// TODO: implement
+}
+
+func (tc *Checker) VisitFuncDef(fn *ast.FuncDef) {
+	tc.fn = fn
+
+	for _, gp := range fn.GenericParams {
+		gp.Accept(tc)
+	}
+
+	tc.withScope(func() {
+		// Add parameters to the new scope
+		for i := range fn.Params {
+			param := fn.Params[i]
+
+			sym := NewSymbolVariable(param.Ident, param.Type, nil, param.Location())
+			sym.IsParam = true
+
+			// Parameters of functions without a body can't be used.
+			sym.Used = fn.Body == nil
+
+			tc.declare(sym, "parameter")
+		}
+
+		// Type check the function body (if present)
+		if fn.Body != nil {
+			fn.Body.Accept(tc)
+		}
+	})
+
+	tc.checkReturns(fn)
+}
+
+// VisitGenericParam checks the constraint of a generic parameter. Whether the
+// types a function is instantiated with satisfy it is checked by
+// CheckInstantiation.
+// TODO(daniel): The parser doesn't produce generic parameters yet, and there's
+// no monomorphization to call CheckInstantiation from.
+func (tc *Checker) VisitGenericParam(gp *ast.GenericParam) {
+	if gp.Constraint == "" {
+		return
+	}
+
+	if gp.Kind != ast.GenericType {
+		tc.errorf(gp.Location(), "value parameter $%s can't have a constraint", gp.Symbol)
+
+		return
+	}
+
+	if _, ok := LookupConstraint(gp.Constraint); !ok {
+		tc.errorf(gp.Location(), "unknown constraint '%s' for $%s", gp.Constraint, gp.Symbol)
+	}
+}
+
+func (tc *Checker) VisitFuncParam(fn *ast.FuncParam) {
+	if fn.Value != nil {
+		valueType, _ := tc.visitNode(fn.Value)
+
+		if fn.Type != nil && fn.Type.Kind == ast.TypeUnknown {
+			// Case 3: arg := 1 (infer type from value)
+			if err := canInfer(valueType); err != nil {
+				tc.errorf(fn.Location(), "cannot infer type of parameter '%s': %s", fn.Ident, err)
+			} else if !isUnknown(valueType) {
+				fn.Type = valueType
+			}
+		} else {
+			// Case 2: arg : int = 1 (check match)
+			if !isUnknown(valueType) && !assignable(fn.Type, valueType) {
+				tc.errorf(fn.Location(), "parameter '%s' declared as %s but default value is %s%s",
+					fn.Ident, fn.Type, valueType, conversionHint(fn.Type, valueType))
+			}
+		}
+	}
+
+	// Case 1: arg: int (declared type, no value) or after inference
+	tc.lastType = fn.Type
+}
+
+func (tc *Checker) VisitBody(body *ast.Body) {
+	// Type check each instruction in the body
+	for _, instr := range body.Instructions {
+		instr.Accept(tc)
+		tc.checkUnusedResult(instr)
+	}
+}
+
+// VisitDeclare handles variable declarations.
+func (tc *Checker) VisitDeclare(d *ast.Declare) {
+	// Add the declared variable to the current scope. Type may be unknown
+	// at this point, and could be updated later when the variable is assigned.
+	tc.declare(NewSymbolVariable(d.Ident, d.Type, d, d.Location()), "variable")
+}
+
+// VisitAssign handles assignment to lvalues.
+func (tc *Checker) VisitAssign(a *ast.Assign) {
+	// Typecheck the lvalue. Assigning to a variable doesn't count as using it.
+	_, tc.assigning = a.LHS.(*ast.VariableRef)
+	lvalType, lvalSymbol := tc.visitNode(a.LHS)
+	tc.assigning = false
+
+	// Typecheck the value
+	valType, _ := tc.visitNode(a.Value)
+
+	// If the lvalue is a variable, lastSymbol will be set
+	if lvalSymbol != nil {
+		// If the variable was declared without a type (`x := value`), infer it
+		// from the value. Report it if the value doesn't have a usable type.
+		if lvalSymbol.Type.Kind == ast.TypeUnknown {
+			if err := canInfer(valType); err != nil {
+				tc.errorf(a.Location(), "cannot infer type of '%s': %s", lvalSymbol.Name, err)
+			}
+		}
+
+		// If the variable type is unknown or 'any', specialize it to the assigned value's type
+		if lvalSymbol.Type.Kind == ast.TypeUnknown || lvalSymbol.Type.Kind == ast.TypeAny {
+			// Only specialize if the assigned value's type is not 'any', void or unknown
+			if !isUnknown(valType) && canInfer(valType) == nil {
+				if err := lvalSymbol.UpdateType(valType); err != nil {
+					tc.errorf(a.Location(), "type error: %s", err)
+				}
+				// If LHS is a variable, we can set its type now
+				switch lvalue := a.LHS.(type) {
+				case *ast.VariableRef:
+					lvalue.SetType(lvalSymbol.Type)
+				}
+			}
+		} else if !isUnknown(valType) && !assignable(lvalType, valType) {
+			tc.errorf(a.Location(), "variable '%s' declared as %s but assigned %s%s",
+				lvalSymbol.Name, lvalSymbol.Type, valType, conversionHint(lvalType, valType))
+		}
+	} else {
+		// TODO: handle pointer deref, array index, etc.
+		if !isUnknown(lvalType) && !isUnknown(valType) && !assignable(lvalType, valType) {
+			tc.errorf(a.Location(), "lvalue type %s but assigned %s%s",
+				lvalType, valType, conversionHint(lvalType, valType))
+		}
+	}
+
+	tc.checkPureAssign(a)
+
+	a.Type = valType
+	tc.lastType = valType
+}
+
+func (tc *Checker) VisitCall(call *ast.Call) {
+	// Look up the function definition
+	sym, ok := tc.lookupSymbol(call.Ident)
+	if !ok || !sym.IsFunc || sym.FuncDef == nil {
+		tc.undefinedFunction(call)
+		tc.lastType = &ast.Type{Kind: ast.TypeUnknown}
+
+		return
+	}
+
+	// Type check all arguments, even if the call turns out to be invalid
+	for i := range call.Args {
+		call.Args[i].Type, _ = tc.visitNode(call.Args[i].Value)
+	}
+
+	sig := tc.resolveOverload(call, tc.sigs[call.Ident])
+	if sig == nil {
+		// Either no signature is valid, which has already been reported, or
+		// no overload matches the arguments.
+		tc.lastType = &ast.Type{Kind: ast.TypeUnknown}
+
+		return
+	}
+
+	call.FuncDef = sig.Def
+	tc.checkPureCall(call)
+	tc.checkDeprecated(call)
+	tc.checkHosted(call)
+
+	// An interrupt handler returns from the interrupt, not to a caller.
+	if call.FuncDef.Attributes.CallConv() == ast.CallConvInterrupt {
+		tc.errorf(call.Location(), "interrupt function '%s' can't be called", call.Ident)
+	}
+
+	if err := sig.CheckArity(call.Location(), len(call.Args)); err != nil {
+		tc.report(err)
+	}
+
+	for i, arg := range call.Args {
+		param := sig.Param(i)
+		if param == nil {
+			break
+		}
+
+		paramType := sig.ParamType(i)
+
+		if !isUnknown(arg.Type) && arg.Type.Kind == ast.TypeVoid {
+			tc.errorf(arg.Location(), "call to '%s': argument %d ('%s') has no value",
+				call.Ident, i+1, param.Ident)
+
+			continue
+		}
+
+		if !isUnknown(paramType) && !isUnknown(arg.Type) && !assignable(paramType, arg.Type) {
+			tc.errorf(arg.Location(), "call to '%s': argument %d ('%s') type mismatch: expected %s, got %s%s",
+				call.Ident, i+1, param.Ident, paramType, arg.Type, conversionHint(paramType, arg.Type))
+		}
+	}
+
+	// Set the type of the call to the function's return type
+	call.SetType(call.FuncDef.ReturnType)
+	tc.lastType = call.Type()
+}
+
+func (tc *Checker) VisitReturn(ret *ast.Return) {
+	// Type check the return value (if any)
+	retType := &ast.Type{Kind: ast.TypeVoid}
+
+	if ret.Value != nil {
+		retType, _ = tc.visitNode(ret.Value)
+	}
+
+	if tc.fn != nil && tc.fn.Attributes.Has(ast.AttrKeyNoReturn) {
+		tc.errorf(ret.Location(), "noreturn function '%s' can't return", tc.fn.Ident)
+	}
+
+	if tc.fn != nil && !isUnknown(retType) && !assignable(tc.fn.ReturnType, retType) {
+		tc.errorf(ret.Location(), "function '%s' returns %s, but return value is %s%s",
+			tc.fn.Ident, tc.fn.ReturnType, retType, conversionHint(tc.fn.ReturnType, retType))
+	}
+
+	tc.lastType = retType
+}
+
+func (tc *Checker) VisitLiteral(lit *ast.Literal) {
+	switch lit.Type().Kind {
+	case ast.TypeInt:
+		tc.checkIntLiteral(lit)
+	case ast.TypeBool, ast.TypeString:
+		// Literals already have their type set
+	case ast.TypeArray:
+		// Array literals must have all elements of the same type
+		// TODO(daniel): check array value types
+	}
+
+	tc.lastType = lit.Type()
+}
+
+func (tc *Checker) VisitVariableRef(ref *ast.VariableRef) {
+	// Look up the variable in the current scope stack
+	if sym, ok := tc.lookupSymbol(ref.Ident); ok && !sym.IsFunc {
+		if !tc.assigning {
+			sym.Used = true
+		}
+
+		ref.SetType(sym.Type)
+		tc.lastType = sym.Type
+		tc.lastSymbol = sym
+	} else {
+		tc.undefinedVariable(ref)
+		ref.SetType(&ast.Type{Kind: ast.TypeUnknown})
+		tc.lastType = ref.Type()
+		tc.lastSymbol = nil
+	}
+}
+
+func (tc *Checker) VisitBinop(binop *ast.Binop) {
+	lhsType, _ := tc.visitNode(binop.Lhs)
+	rhsType, _ := tc.visitNode(binop.Rhs)
+
+	unknown := func(msg string, args ...any) *ast.Type {
+		binop.SetType(&ast.Type{Kind: ast.TypeUnknown})
+
+		// Don't report follow-up errors for operands that failed to check.
+		if !isUnknown(lhsType) && !isUnknown(rhsType) {
+			tc.errorf(binop.Location(), msg, args...)
+		}
+
+		return binop.Type()
+	}
+
+	isInt := func(t *ast.Type) bool { return t != nil && t.Kind == ast.TypeInt }
+	isPointer := func(t *ast.Type) bool { return t != nil && t.Kind == ast.TypePointer }
+	isString := func(t *ast.Type) bool { return t != nil && t.Kind == ast.TypeString }
+
+	switch binop.Operation {
+	case ast.BinOpAdd, ast.BinOpSub:
+		switch {
+		case isPointer(lhsType) || isPointer(rhsType):
+			if ty, err := pointerArithmetic(binop.Operation, lhsType, rhsType); err != nil {
+				unknown("%s", err)
+			} else {
+				binop.SetType(ty)
+			}
+		case isInt(lhsType) && isInt(rhsType):
+			binop.SetType(&ast.Type{Kind: ast.TypeInt})
+		case isString(lhsType) && isString(rhsType) && binop.Operation == ast.BinOpAdd:
+			binop.SetType(&ast.Type{Kind: ast.TypeString})
+		default:
+			unknown("invalid operands for arithmetic: %s %s %s",
+				lhsType, binop.Operation, rhsType)
+		}
+	case ast.BinOpDiv, ast.BinOpMul, ast.BinOpMod:
+		if isInt(lhsType) && isInt(rhsType) {
+			binop.SetType(&ast.Type{Kind: ast.TypeInt})
+		} else {
+			unknown("invalid operands for arithmetic: %s %s %s",
+				lhsType, binop.Operation, rhsType)
+		}
+	case ast.BinOpEq, ast.BinOpNe:
+		if compatible(lhsType, rhsType) {
+			binop.SetType(&ast.Type{Kind: ast.TypeBool})
+		} else {
+			unknown("type mismatch in equality/inequality operation: %s %s %s",
+				lhsType, binop.Operation, rhsType)
+		}
+	case ast.BinOpLt, ast.BinOpLe, ast.BinOpGt, ast.BinOpGe:
+		if compatible(lhsType, rhsType) &&
+			(isInt(lhsType) || isString(lhsType) || isPointer(lhsType)) {
+			binop.SetType(&ast.Type{Kind: ast.TypeBool})
+		} else {
+			unknown("type mismatch or invalid types in comparison operation: %s %s %s",
+				lhsType, binop.Operation, rhsType)
+		}
+	case ast.BinOpShl, ast.BinOpShr:
+		if isInt(lhsType) && isInt(rhsType) {
+			binop.SetType(&ast.Type{Kind: ast.TypeInt})
+		} else {
+			unknown("shift operation requires int operands, got %s %s %s",
+				lhsType, binop.Operation, rhsType)
+		}
+	case ast.BinOpAnd, ast.BinOpOr:
+		if isInt(lhsType) && isInt(rhsType) {
+			binop.SetType(&ast.Type{Kind: ast.TypeInt})
+		} else {
+			unknown("bitwise operation requires int operands, got %s %s %s",
+				lhsType, binop.Operation, rhsType)
+		}
+	case ast.BinOpLogAnd, ast.BinOpLogOr:
+		// The result is a bool either way, so errors don't cascade.
+		tc.checkCondition(binop.Lhs, lhsType, fmt.Sprintf("left operand of %s", binop.Operation))
+		tc.checkCondition(binop.Rhs, rhsType, fmt.Sprintf("right operand of %s", binop.Operation))
+		binop.SetType(&ast.Type{Kind: ast.TypeBool})
+	default:
+		unknown("unknown binary operation: %s", binop.Operation)
+	}
+
+	if !isUnknown(binop.Type()) {
+		lhs, rhs := ast.ConstValue(binop.Lhs), ast.ConstValue(binop.Rhs)
+		if lhs != nil && rhs != nil {
+			tc.fold(binop, ast.NewBinop(binop.Operation, lhs, rhs, binop.Loc))
+		}
+	}
+
+	tc.lastType = binop.Type()
+}
+
+func (tc *Checker) VisitUnaryOp(u *ast.UnaryOp) {
+	if lit, ok := u.Expr.(*ast.Literal); ok && u.Operation == ast.UnaryOpMinus {
+		tc.negated = lit
+	}
+
+	// Type check the expression
+	exprType, _ := tc.visitNode(u.Expr)
+	u.SetType(exprType)
+
+	switch u.Operation {
+	case ast.UnaryOpMinus:
+		if isUnknown(exprType) {
+			break
+		}
+
+		if u.Type() == nil || u.Type().Kind != ast.TypeInt {
+			tc.errorf(u.Location(), "unary minus requires int type, got %s", u.Type())
+			u.SetType(&ast.Type{Kind: ast.TypeUnknown})
+		}
+	default:
+		tc.errorf(u.Location(), "unknown unary operation: %s", u.Operation)
+		u.SetType(&ast.Type{Kind: ast.TypeUnknown})
+	}
+
+	if !isUnknown(u.Type()) {
+		if expr := ast.ConstValue(u.Expr); expr != nil {
+			tc.fold(u, ast.NewUnaryOp(u.Operation, expr, u.Loc))
+		}
+	}
+
+	tc.lastType = u.Type()
+}
+
+func (tc *Checker) VisitIf(iff *ast.If) {
+	// If statements introduce a new scope for variables (e.g. initializer)
+	tc.withScope(func() {
+		// Type check the initializers, if present
+		for _, init := range iff.Init {
+			init.Accept(tc)
+		}
+
+		// Type check the condition
+		condType, _ := tc.visitNode(iff.Cond)
+		tc.checkCondition(iff.Cond, condType, "if condition")
+
+		// Each branch has a scope of its own, so the locals of one aren't
+		// visible in the other.
+		tc.withScope(func() { iff.Then.Accept(tc) })
+
+		if iff.Else != nil {
+			tc.withScope(func() { iff.Else.Accept(tc) })
+		}
+
+		tc.lastType = &ast.Type{Kind: ast.TypeVoid} // if is a statement, not an expression
+	})
+}
+
+func (tc *Checker) VisitFor(f *ast.For) {
+	// For statements introduce a new scope for variables
+	tc.withScope(func() {
+		// Type check the initializers, if present
+		for _, init := range f.Init {
+			init.Accept(tc)
+		}
+
+		// Type check the condition
+		condType, _ := tc.visitNode(f.Cond)
+		tc.checkCondition(f.Cond, condType, "for condition")
+
+		// The body has a scope of its own, inside the one of the
+		// initializers.
+		if f.Body != nil {
+			tc.withScope(func() { f.Body.Accept(tc) })
+		}
+
+		// Type check the post-conditions, if present
+		for _, post := range f.Post {
+			post.Accept(tc)
+		}
+
+		tc.lastType = &ast.Type{Kind: ast.TypeVoid} // for is a statement, not an expression
+	})
+}
+
+// VisitDeref handles pointer dereference expressions (currently a no-op).
+func (tc *Checker) VisitDeref(d *ast.Deref) {
+	// Dereference does not change the type, just returns the type of the dereferenced expression
+	ref, _ := tc.visitNode(d.Expr)
+	switch {
+	case isUnknown(ref):
+		d.SetType(&ast.Type{Kind: ast.TypeUnknown})
+	case ref.Kind != ast.TypePointer:
+		tc.errorf(d.Location(), "dereference requires pointer type, got %s", ref)
+		d.SetType(&ast.Type{Kind: ast.TypeUnknown})
+	case !hasSize(ref.Elem):
+		tc.errorf(d.Location(), "cannot dereference %s, the element type has no size", ref)
+		d.SetType(&ast.Type{Kind: ast.TypeUnknown})
+	default:
+		d.SetType(ref.Elem) // Dereference returns the element type
+	}
+
+	tc.lastType = d.Type()
+}
+
+// VisitArrayIndex handles array index expressions.
+func (tc *Checker) VisitArrayIndex(a *ast.ArrayIndex) {
+	// Typecheck the array expression
+	arrayType, _ := tc.visitNode(a.Array)
+	indexType, _ := tc.visitNode(a.Index)
+
+	if arrayType == nil || arrayType.Kind != ast.TypeArray {
+		tc.errorf(a.Location(), "cannot index non-array type %s", arrayType)
+		a.SetType(&ast.Type{Kind: ast.TypeUnknown})
+		tc.lastType = a.Type()
+		return
+	}
+
+	if indexType == nil || indexType.Kind != ast.TypeInt {
+		tc.errorf(a.Location(), "array index must be int, got %s", indexType)
+	}
+
+	a.SetType(arrayType.Elem)
+	tc.lastType = a.Type()
+}
+
+// visitNode is a helper method to visit a node and return the lastType.
+func (tc *Checker) visitNode(node interface{ Accept(visitor ast.Visitor) }) (*ast.Type, *Symbol) {
+	if node != nil {
+		node.Accept(tc)
+	} else {
+		tc.lastType = &ast.Type{Kind: ast.TypeUnknown}
+	}
+
+	return tc.lastType, tc.lastSymbol
+}
+
+// isUnknown reports whether t is missing or unknown, usually because checking
+// the expression it belongs to has already failed.
+func isUnknown(t *ast.Type) bool {
+	return t == nil || t.Kind == ast.TypeUnknown
+}
+
+// canInfer reports why a declaration can't take its type from a value of type
+// t. Unknown types are accepted, as the error has already been reported for
+// the value itself.
+func canInfer(t *ast.Type) error {
+	switch {
+	case isUnknown(t):
+		return nil
+	case t.Kind == ast.TypeVoid:
+		return errors.New("value has no type")
+	case t.Kind == ast.TypeAny:
+		return errors.New("value has type any")
+	default:
+		return nil
+	}
+}
+
+// fold evaluates expr, an operation on constant operands, and records the result
+// on node so lowering can emit the folded literal instead. Division by zero and
+// overflow are reported; other operations are simply not folded.
+func (tc *Checker) fold(node interface{ SetConst(*ast.Literal) }, expr ast.Expression) {
+	lit, err := ast.EvalConst(expr)
+	if err != nil {
+		var cerr *ast.ConstError
+
+		if errors.As(err, &cerr) && (errors.Is(err, ast.ErrDivisionByZero) || errors.Is(err, ast.ErrOverflow)) {
+			tc.errorf(cerr.Loc, "%s", cerr.Msg)
+		}
+
+		return
+	}
+
+	node.SetConst(lit)
+}
+
+// VisitAssert evaluates a compile-time assertion, reporting its message if the
+// condition is false.
+func (tc *Checker) VisitAssert(a *ast.Assert) {
+	condType, _ := tc.visitNode(a.Cond)
+	if isUnknown(condType) {
+		return
+	}
+
+	if condType.Kind != ast.TypeBool {
+		tc.checkCondition(a.Cond, condType, "assertion condition")
+
+		return
+	}
+
+	lit := ast.ConstValue(a.Cond)
+	if lit == nil {
+		tc.errorf(a.Cond.Location(), "assertion condition is not a constant expression")
+
+		return
+	}
+
+	if !lit.BoolValue {
+		msg := a.Message
+		if msg == "" {
+			msg = printer.SprintExpr(a.Cond)
+		}
+
+		tc.errorf(a.Location(), "assertion failed: %s", msg)
+	}
+}
+
+// checkIntLiteral reports an int literal that doesn't fit in an int (32 bits),
+// and marks its type unknown so the error doesn't cascade. The operand of a
+// unary minus may be one more than the largest int, as in -2147483648.
+func (tc *Checker) checkIntLiteral(lit *ast.Literal) {
+	limit := int64(math.MaxInt32)
+	if lit == tc.negated {
+		limit++
+	}
+
+	if int64(lit.IntValue) > limit {
+		tc.errorf(lit.Location(), "constant %d overflows int", lit.IntValue)
+		lit.SetType(&ast.Type{Kind: ast.TypeUnknown})
+	}
+}
+
+// checkCondition reports a condition, or an operand of a logical operator, that
+// isn't a bool. Ints aren't truthy, so for those it suggests comparing with 0.
+func (tc *Checker) checkCondition(expr ast.Expression, t *ast.Type, what string) {
+	switch {
+	case isUnknown(t) || t.Kind == ast.TypeBool:
+	case t.Kind == ast.TypeInt:
+		fix := ast.NewBinop(ast.BinOpNe, expr, ast.NewIntLiteral(0, expr.Location()), expr.Location())
+
+		tc.errorf(expr.Location(), "%s must be bool, got int; did you mean '%s'?", what, printer.SprintExpr(fix))
+	default:
+		tc.errorf(expr.Location(), "%s must be bool, got %s", what, t)
+	}
+}
+
+// pointerArithmetic returns the type of `lhs op rhs`, where op is + or - and at
+// least one operand is a pointer. Pointers can be offset by an int, and two
+// pointers of the same type can be subtracted to get the number of elements
+// between them. The element type must have a size.
+func pointerArithmetic(op ast.BinOpKind, lhs, rhs *ast.Type) (*ast.Type, error) {
+	invalid := fmt.Errorf("invalid operands for pointer arithmetic: %s %s %s", lhs, op, rhs)
+
+	if lhs == nil || rhs == nil {
+		return nil, invalid
+	}
+
+	var ptr *ast.Type
+
+	switch {
+	case lhs.Kind == ast.TypePointer && rhs.Kind == ast.TypeInt:
+		ptr = lhs
+	case lhs.Kind == ast.TypeInt && rhs.Kind == ast.TypePointer && op == ast.BinOpAdd:
+		ptr = rhs
+	case lhs.Kind == ast.TypePointer && rhs.Kind == ast.TypePointer && op == ast.BinOpSub:
+		if !identical(lhs, rhs) {
+			return nil, fmt.Errorf("pointer subtraction requires matching pointer types, got %s - %s", lhs, rhs)
+		}
+
+		ptr = lhs
+	default:
+		return nil, invalid
+	}
+
+	if !hasSize(ptr.Elem) {
+		return nil, fmt.Errorf("pointer arithmetic on %s, the element type has no size", ptr)
+	}
+
+	if lhs.Kind == ast.TypePointer && rhs.Kind == ast.TypePointer {
+		return &ast.Type{Kind: ast.TypeInt}, nil
+	}
+
+	return ptr, nil
+}
+
+// hasSize reports whether values of type t occupy a known amount of memory, so
+// they can be loaded, stored and stepped over.
+func hasSize(t *ast.Type) bool {
+	if isUnknown(t) {
+		return false
+	}
+
+	switch t.Kind {
+	case ast.TypeVoid, ast.TypeAny, ast.TypeVararg:
+		return false
+	case ast.TypeArray:
+		return hasSize(t.Elem)
+	default:
+		return true
+	}
+}
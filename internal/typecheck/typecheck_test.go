@@ -0,0 +1,956 @@
+package typecheck
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/corani/cubit/internal/ast"
+	"github.com/corani/cubit/internal/diag"
+	"github.com/corani/cubit/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{
+			name: "valid",
+			src: `package main
+
+add :: func(a: int, b: int) -> int {
+    return a + b
+}
+
+main :: func() -> int {
+    x := add(1, 2)
+    if x > 2 {
+        return x
+    }
+    return 0
+}
+`,
+		},
+		{
+			name: "scope per body",
+			src: `package main
+
+f :: func(c: bool) -> int {
+    if c {
+        x := 1
+        return x
+    } else {
+        x := 2
+        return x
+    }
+    for i := 0; i < 2; i = i + 1 {
+        i := 5
+        return i
+    }
+    return 0
+}
+`,
+		},
+		{
+			name: "undefined identifiers",
+			src: `package main
+
+main :: func() -> int {
+    x := y + 1
+    return f(x)
+}
+`,
+			expected: "test.in:4:10: undefined variable 'y'\n" +
+				"test.in:5:12: call to undefined function 'f'",
+		},
+		{
+			name: "undefined in other branch",
+			src: `package main
+
+@(extern) printf :: func(msg: string, args: ..any)
+
+main :: func(c: bool) {
+    if c {
+        y := 1
+    } else {
+        printf("%d", y)
+    }
+}
+`,
+			expected: "test.in:9:22: undefined variable 'y'",
+		},
+		{
+			name: "suggestions",
+			src: `package main
+
+print :: func(n: int) {
+}
+
+main :: func(count: int) {
+    x := cout + 1
+    prnt(x)
+    count(x)
+    x = main
+}
+`,
+			expected: "test.in:7:10: undefined variable 'cout'; did you mean 'count'?\n" +
+				"test.in:8:5: call to undefined function 'prnt'; did you mean 'print'?\n" +
+				"test.in:9:5: cannot call non-function 'count'\n\ttest.in:6:14: declared here\n" +
+				"test.in:10:9: function 'main' used as a variable",
+		},
+		{
+			name: "use before declaration",
+			src: `package main
+
+main :: func() -> int {
+    y := x * 2
+    x := 1
+    return y
+}
+`,
+			expected: "test.in:4:10: variable 'x' used before declaration\n\ttest.in:5:5: declared here",
+		},
+		{
+			name: "argument mismatch",
+			src: `package main
+
+f :: func(s: string) {
+}
+
+main :: func() {
+    f(1)
+}
+`,
+			expected: "test.in:7:7: call to 'f': argument 1 ('s') type mismatch: expected string, got int",
+		},
+		{
+			name: "arity",
+			src: `package main
+
+f :: func(a: int, b: int) {
+}
+
+@(extern)
+printf :: func(msg: string, args: ..any)
+
+main :: func() {
+    f(1)
+    f(1, 2, 3)
+    printf()
+    printf("%d %s\n", 1, "a")
+}
+`,
+			expected: "test.in:10:5: call to 'f' has too few arguments, expected 2, got 1\n" +
+				"test.in:11:5: call to 'f' has too many arguments, expected 2, got 3\n" +
+				"test.in:12:5: call to 'printf' has too few arguments, expected at least 1, got 0",
+		},
+		{
+			name: "varargs",
+			src: `package main
+
+@(extern)
+sum :: func(args: ..int, n: int)
+
+@(extern)
+printf :: func(msg: string, args: ..int)
+
+g :: func() {
+}
+
+main :: func() {
+    printf("%d %d\n", 1, "a")
+    printf("%d\n", g())
+}
+`,
+			expected: "test.in:4:13: variadic parameter 'args' must be the last parameter of 'sum'\n" +
+				"test.in:13:26: call to 'printf': argument 3 ('args') type mismatch: expected int, got string\n" +
+				"test.in:14:20: call to 'printf': argument 2 ('args') has no value",
+		},
+		{
+			name: "redeclared",
+			src: `package main
+
+f :: func(a: int, a: int) {
+    x := 1
+    if a > 1 {
+        x := 2
+    }
+    x := 3
+}
+
+f :: func(x: int, y: int) {
+}
+`,
+			expected: "test.in:11:1: function 'f' redeclared\n\ttest.in:3:1: previously declared here\n" +
+				"test.in:3:19: parameter 'a' redeclared\n\ttest.in:3:11: previously declared here\n" +
+				"test.in:8:5: variable 'x' redeclared\n\ttest.in:4:5: previously declared here",
+		},
+		{
+			name: "conversions",
+			src: `package main
+
+f :: func(p: ^int) -> bool {
+    return 1
+}
+
+main :: func(q: ^^int) {
+    b := true
+    b = 0
+    f(q)
+}
+`,
+			expected: "test.in:4:5: function 'f' returns bool, but return value is int (compare with 0 to get a bool, e.g. 'x != 0')\n" +
+				"test.in:9:5: variable 'b' declared as bool but assigned int (compare with 0 to get a bool, e.g. 'x != 0')\n" +
+				"test.in:10:7: call to 'f': argument 1 ('p') type mismatch: expected ^int, got ^^int " +
+				"(an explicit conversion from ^^int to ^int is required)",
+		},
+		{
+			name: "constant errors",
+			src: `package main
+
+main :: func() -> int {
+    x := 1 / (2 - 2)
+    return 2147483647 + 1
+}
+`,
+			expected: "test.in:4:10: division by zero\n" +
+				"test.in:5:12: constant 2147483648 overflows int",
+		},
+		{
+			name: "int range",
+			src: `package main
+
+main :: func() -> int {
+    a := -2147483648
+    b: int = 2147483648
+    c := -2147483649
+    d := 3000000000 + 1
+    return a + (-2147483648 - 1)
+}
+`,
+			expected: "test.in:5:14: constant 2147483648 overflows int\n" +
+				"test.in:6:11: constant 2147483649 overflows int\n" +
+				"test.in:7:10: constant 3000000000 overflows int\n" +
+				"test.in:8:17: constant -2147483649 overflows int",
+		},
+		{
+			name: "pointers",
+			src: `package main
+
+main :: func(p: ^int, q: ^bool, a: ^any, n: int) -> int {
+    x := n^
+    y := a^
+    r := p + q
+    s := p - q
+    t := n - p
+    u := a + 1
+    v := p + 1
+    return p - v
+}
+`,
+			expected: "test.in:4:11: dereference requires pointer type, got int\n" +
+				"test.in:5:11: cannot dereference ^any, the element type has no size\n" +
+				"test.in:6:10: invalid operands for pointer arithmetic: ^int + ^bool\n" +
+				"test.in:7:10: pointer subtraction requires matching pointer types, got ^int - ^bool\n" +
+				"test.in:8:10: invalid operands for pointer arithmetic: int - ^int\n" +
+				"test.in:9:10: pointer arithmetic on ^any, the element type has no size",
+		},
+		{
+			name: "conditions",
+			src: `package main
+
+main :: func(n: int, s: string, b: bool) {
+    if n % 2 {
+    }
+    for i := 0; n - i; i = i + 1 {
+    }
+    if s {
+    }
+    if b && n {
+    }
+    if n || b {
+    }
+}
+`,
+			expected: "test.in:4:8: if condition must be bool, got int; did you mean 'n % 2 != 0'?\n" +
+				"test.in:6:17: for condition must be bool, got int; did you mean 'n - i != 0'?\n" +
+				"test.in:8:8: if condition must be bool, got string\n" +
+				"test.in:10:13: right operand of && must be bool, got int; did you mean 'n != 0'?\n" +
+				"test.in:12:8: left operand of || must be bool, got int; did you mean 'n != 0'?",
+		},
+		{
+			name: "assertions",
+			src: `package main
+
+main :: func(n: int) {
+    #assert(2 + 2 == 4, "math works")
+    #assert(1 < 0, "size must be positive")
+    #assert(3 > 4)
+    #assert(n > 0)
+    #assert(1 + 1)
+}
+`,
+			expected: "test.in:5:5: assertion failed: size must be positive\n" +
+				"test.in:6:5: assertion failed: 3 > 4\n" +
+				"test.in:7:13: assertion condition is not a constant expression\n" +
+				"test.in:8:13: assertion condition must be bool, got int; did you mean '1 + 1 != 0'?",
+		},
+		{
+			name: "missing return",
+			src: `package main
+
+@(extern, noreturn)
+exit :: func(code: int)
+
+f :: func(n: int) -> int {
+    if n > 0 {
+        return 1
+    }
+}
+
+g :: func(n: int) -> int {
+    if n > 0 {
+        return 1
+    } else {
+        exit(1)
+    }
+}
+
+h :: func() -> int {
+    for true {
+    }
+}
+
+@(noreturn)
+fail :: func(n: int) {
+    if n > 0 {
+        exit(n)
+    }
+}
+
+@(noreturn)
+stop :: func() {
+    return
+}
+`,
+			expected: "test.in:6:1: function 'f' is missing a return at the end of its body\n" +
+				"test.in:26:1: noreturn function 'fail' can reach the end of its body\n" +
+				"test.in:34:5: noreturn function 'stop' can't return",
+		},
+		{
+			name: "pure",
+			src: `package main
+
+@(extern)
+puts :: func(s: string)
+
+@(pure)
+twice :: func(n: int) -> int {
+    return n * 2
+}
+
+@(pure)
+f :: func(p: ^int, row: [4]int) -> int {
+    puts("hi")
+    p^ = 1
+    row[0] = twice(1)
+    local := [4]int{}
+    local[0] = 1
+    return local[0]
+}
+`,
+			expected: "test.in:13:5: pure function 'f' can't call 'puts', which isn't pure\n" +
+				"test.in:14:6: pure function 'f' can't write through a pointer\n" +
+				"test.in:15:8: pure function 'f' can't modify its parameter 'row'",
+		},
+		{
+			name: "interrupt",
+			src: `package main
+
+@(callconv="interrupt")
+tick :: func() {
+}
+
+main :: func() -> int {
+    tick()
+    return 0
+}
+`,
+			expected: "test.in:8:5: interrupt function 'tick' can't be called",
+		},
+		{
+			name: "overloads",
+			src: `package main
+
+foo :: func(x: int) {
+}
+
+foo :: func(s: string) {
+}
+
+bar :: func(x: int, a: any) {
+}
+
+bar :: func(a: any, x: int) {
+}
+
+@(extern)
+baz :: func(x: int)
+
+baz :: func(x: bool) {
+}
+
+main :: func() {
+    foo(true)
+    bar(1, 1)
+}
+`,
+			expected: "test.in:16:1: overloaded function 'baz' can't be extern without a link_name\n" +
+				"test.in:22:5: no overload of 'foo' matches arguments (bool)\n" +
+				"\ttest.in:3:1: candidate foo(int)\n" +
+				"\ttest.in:6:1: candidate foo(string)\n" +
+				"test.in:23:5: ambiguous call to 'bar' with arguments (int, int)\n" +
+				"\ttest.in:9:1: candidate bar(int, any)\n" +
+				"\ttest.in:12:1: candidate bar(any, int)",
+		},
+		{
+			name: "return mismatch",
+			src: `package main
+
+main :: func() -> int {
+    return "hello"
+}
+`,
+			expected: "test.in:4:5: function 'main' returns int, but return value is string",
+		},
+		{
+			name: "assignment mismatch",
+			src: `package main
+
+main :: func() {
+    x: int = 1
+    x = "hello"
+}
+`,
+			expected: "test.in:5:5: variable 'x' declared as int but assigned string",
+		},
+		{
+			name: "infer from void",
+			src: `package main
+
+f :: func() {
+}
+
+main :: func() {
+    x := f()
+}
+`,
+			expected: "test.in:7:5: cannot infer type of 'x': value has no type",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			unit := testutil.Parse(t, "test.in", tc.src)
+
+			err := Check(unit)
+			if tc.expected == "" {
+				require.NoError(t, err)
+
+				return
+			}
+
+			require.EqualError(t, err, tc.expected)
+		})
+	}
+}
+
+func TestCheck_InferDeclaration(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+main :: func(n := 1) {
+    x := n < 2
+    y := "hello"
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+	require.NoError(t, Check(unit))
+
+	fd := unit.Funcs[0]
+	require.Equal(t, ast.TypeInt, fd.Params[0].Type.Kind)
+
+	var declared []ast.TypeKind
+
+	ast.Walk(fd, func(n ast.Node) bool {
+		if d, ok := n.(*ast.Declare); ok {
+			declared = append(declared, d.Type.Kind)
+		}
+
+		return true
+	})
+
+	require.Equal(t, []ast.TypeKind{ast.TypeBool, ast.TypeString}, declared)
+}
+
+func TestCheck_Fold(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+main :: func(n: int) {
+    a := 2 + 3 * 4
+    b := "foo" + "bar"
+    c := -(1 + 1) < 0
+    d := n + 1
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+	require.NoError(t, Check(unit))
+
+	var folded []any
+
+	ast.Walk(unit.Funcs[0], func(n ast.Node) bool {
+		a, ok := n.(*ast.Assign)
+		if !ok {
+			return true
+		}
+
+		switch lit := ast.ConstValue(a.Value); {
+		case lit == nil:
+			folded = append(folded, nil)
+		case lit.Type().Kind == ast.TypeInt:
+			folded = append(folded, lit.IntValue)
+		case lit.Type().Kind == ast.TypeBool:
+			folded = append(folded, lit.BoolValue)
+		default:
+			folded = append(folded, lit.StringValue)
+		}
+
+		return true
+	})
+
+	require.Equal(t, []any{14, "foobar", true, nil}, folded)
+}
+
+func TestCheck_Unused(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+@(extern)
+puts :: func(s: string)
+
+@(pure)
+g :: func() -> int {
+    return 1
+}
+
+f :: func(a: int, b: int, _c: int) -> int {
+    g()
+    x := 1
+    x = 2
+    _y := 3
+    for i := 0; i < a; i = i + 1 {
+        z := i
+    }
+    return a
+}
+`
+
+	parse := func(t *testing.T) *ast.CompilationUnit {
+		t.Helper()
+
+		return testutil.Parse(t, "test.in", src)
+	}
+
+	// Inner scopes are reported first, as they're closed first.
+	expected := "test.in:12:5: result of pure function 'g' is not used [CB0004]\n" +
+		"test.in:17:9: variable 'z' is declared but never used [CB0001]\n" +
+		"test.in:11:19: parameter 'b' is never used [CB0002]\n" +
+		"test.in:13:5: variable 'x' is declared but never used [CB0001]"
+
+	t.Run("warnings", func(t *testing.T) {
+		t.Parallel()
+
+		tc := NewChecker(Options{})
+		require.NoError(t, tc.Check(parse(t)))
+
+		var warnings []string
+		for _, w := range tc.Warnings() {
+			warnings = append(warnings, w.Error())
+		}
+
+		require.Equal(t, expected, strings.Join(warnings, "\n"))
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		t.Parallel()
+
+		require.EqualError(t, NewChecker(Options{Diagnostics: diag.NewBag(diag.Config{Strict: true})}).Check(parse(t)), expected)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+
+		tc := NewChecker(Options{
+			Diagnostics: diag.NewBag(diag.Config{
+				Strict:  true,
+				Disable: []*diag.Code{diag.UnusedVariable, diag.UnusedParameter, diag.UnusedResult},
+				Enable:  []*diag.Code{diag.UnusedParameter},
+			}),
+		})
+
+		require.EqualError(t, tc.Check(parse(t)), "test.in:11:19: parameter 'b' is never used [CB0002]")
+	})
+}
+
+func TestCheck_Shadow(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+f :: func(x: int) -> int {
+    y := x
+    if x := y + 1; x > 2 {
+        y = x
+    }
+    for i := 0; i < y; i = i + 1 {
+        for i := 0; i < 2; i = i + 1 {
+            _y := i
+            y = y + _y
+        }
+    }
+    return y
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+
+	tc := NewChecker(Options{})
+	require.NoError(t, tc.Check(unit))
+
+	var warnings []string
+	for _, w := range tc.Warnings() {
+		warnings = append(warnings, w.Error())
+	}
+
+	require.Equal(t, "test.in:5:8: variable 'x' shadows the parameter declared at test.in:3:11 [CB0003]\n"+
+		"test.in:9:13: variable 'i' shadows the variable declared at test.in:8:9 [CB0003]",
+		strings.Join(warnings, "\n"))
+}
+
+func TestCheck_Allow(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{
+			name: "function",
+			src: `package main
+
+@(allow="unused-variable")
+f :: func(a: int) {
+    x := 1
+}
+`,
+			expected: "test.in:4:11: parameter 'a' is never used [CB0002]",
+		},
+		{
+			name: "parameter",
+			src: `package main
+
+f :: func(@(allow="CB0002") a: int, b: int) {
+}
+`,
+			expected: "test.in:3:37: parameter 'b' is never used [CB0002]",
+		},
+		{
+			name: "package",
+			src: `@(allow="unused")
+package main
+
+f :: func(a: int) {
+    x := 1
+}
+`,
+		},
+		{
+			name: "unknown",
+			src: `package main
+
+@(allow="unused,shadowing")
+f :: func() {
+}
+`,
+			expected: "test.in:4:1: attribute allow: unknown diagnostic 'shadowing'",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			unit := testutil.Parse(t, "test.in", tc.src)
+
+			err := NewChecker(Options{Diagnostics: diag.NewBag(diag.Config{Strict: true})}).Check(unit)
+			if tc.expected == "" {
+				require.NoError(t, err)
+
+				return
+			}
+
+			require.EqualError(t, err, tc.expected)
+		})
+	}
+}
+
+func TestCheck_Deprecated(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+@(deprecated="use g instead")
+f :: func() {
+}
+
+g :: func() {
+}
+
+main :: func() {
+    f()
+    g()
+}
+
+@(allow="deprecated")
+legacy :: func() {
+    f()
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+
+	tc := NewChecker(Options{})
+	require.NoError(t, tc.Check(unit))
+
+	var warnings []string
+	for _, w := range tc.Warnings() {
+		warnings = append(warnings, w.Error())
+	}
+
+	require.Equal(t, []string{
+		"test.in:11:5: 'f' is deprecated: use g instead [CB0005]\n\ttest.in:4:1: 'f' is declared deprecated here",
+	}, warnings)
+}
+
+func TestCheck_Freestanding(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+@(extern, hosted)
+printf :: func(msg: string, args: ..any)
+
+@(extern)
+outb :: func(port: int, value: int)
+
+main :: func() -> int {
+    printf("hello\n")
+    outb(1016, 72)
+    return 0
+}
+`
+
+	tt := []struct {
+		name         string
+		freestanding bool
+		expected     string
+	}{
+		{name: "hosted", freestanding: false},
+		{
+			name:         "freestanding",
+			freestanding: true,
+			expected: "test.in:10:5: 'printf' needs an operating system, which a freestanding program doesn't have\n" +
+				"\ttest.in:4:1: 'printf' is declared hosted here",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			unit := testutil.Parse(t, "test.in", src)
+
+			err := NewChecker(Options{Freestanding: tc.freestanding}).Check(unit)
+			if tc.expected == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCheck_Executable(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name       string
+		src        string
+		executable bool
+		expected   string
+	}{
+		{
+			name: "exported",
+			src: `package main
+
+@(export)
+main :: func() -> int {
+    return 0
+}
+`,
+			executable: true,
+		},
+		{
+			name: "not exported",
+			src: `package main
+
+main :: func() -> int {
+    return 0
+}
+`,
+			executable: true,
+			expected:   "test.in:3:1: function 'main' must be @(export), as the program starts in it",
+		},
+		{
+			name: "missing",
+			src: `package main
+
+f :: func() -> int {
+    return 0
+}
+`,
+			executable: true,
+			expected:   "test.in:1:1: program has no function 'main' to start in",
+		},
+		{
+			name: "not an executable",
+			src: `package main
+
+main :: func() -> int {
+    return 0
+}
+`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			unit := testutil.Parse(t, "test.in", tc.src)
+
+			err := NewChecker(Options{Executable: tc.executable}).Check(unit)
+			if tc.expected == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCheck_Overload(t *testing.T) {
+	t.Parallel()
+
+	src := `package main
+
+f :: func(x: int) {
+}
+
+f :: func(s: string) {
+}
+
+f :: func(x: int, y: int) {
+}
+
+f :: func(a: any) {
+}
+
+main :: func() {
+    f(1)
+    f("a")
+    f(1, 2)
+    f(true)
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+	require.NoError(t, Check(unit))
+
+	var resolved []*ast.FuncDef
+
+	ast.Walk(unit.Funcs[4], func(n ast.Node) bool {
+		if call, ok := n.(*ast.Call); ok {
+			resolved = append(resolved, call.FuncDef)
+		}
+
+		return true
+	})
+
+	require.Equal(t, unit.Funcs[:4], resolved)
+}
+
+// TestRegisterCheck isn't parallel, as it changes the checks every test runs.
+func TestRegisterCheck(t *testing.T) {
+	checks := unitChecks
+	t.Cleanup(func() { unitChecks = checks })
+
+	require.NoError(t, RegisterCheck("no_recursion", func(unit *ast.CompilationUnit) []error {
+		var errs []error
+
+		for _, fd := range unit.Funcs {
+			ast.Walk(fd, func(n ast.Node) bool {
+				if call, ok := n.(*ast.Call); ok && call.FuncDef == fd {
+					errs = append(errs, diag.Errorf(call.Location(), "'%s' calls itself", fd.Ident))
+				}
+
+				return true
+			})
+		}
+
+		return errs
+	}))
+	require.NoError(t, RegisterCheck("no_main", func(unit *ast.CompilationUnit) []error {
+		return []error{errors.New("main is missing")}
+	}))
+	require.EqualError(t, RegisterCheck("no_main", nil), "check no_main is already registered")
+
+	src := `package main
+
+f :: func(n: int) -> int {
+    if n == 0 {
+        return 0
+    }
+    return f(n - 1)
+}
+`
+
+	unit := testutil.Parse(t, "test.in", src)
+
+	require.EqualError(t, Check(unit), "test.in:7:12: 'f' calls itself\n"+
+		"test.in:1:1: check no_main: main is missing")
+}